@@ -0,0 +1,35 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// AdminAuth protects the /admin routes with a shared bearer token.
+func AdminAuth(next http.Handler, adminToken string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isAdminRequest(r, adminToken) {
+			respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isAdminRequest reports whether the request carries the admin token or
+// was made with an admin API key. An empty token never matches, so admin
+// access is off unless configured.
+func isAdminRequest(r *http.Request, adminToken string) bool {
+	if creds := apiKeyFromContext(r.Context()); creds != nil && creds.admin {
+		return true
+	}
+	if adminToken == "" {
+		return false
+	}
+	token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !found {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) == 1
+}
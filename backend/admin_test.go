@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAdminAuth(t *testing.T) {
+	withKey := func(r *http.Request, creds *apiKeyCredentials) *http.Request {
+		return r.WithContext(context.WithValue(r.Context(), apiKeyKey, creds))
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		req   func() *http.Request
+		want  int
+		actor string
+	}{
+		{
+			name:  "admin token",
+			token: "secret",
+			req: func() *http.Request {
+				r := httptest.NewRequest(http.MethodGet, "/admin/bans", nil)
+				r.Header.Set("Authorization", "Bearer secret")
+				r.Header.Set(adminActorHeader, "sam")
+				return r
+			},
+			want:  http.StatusOK,
+			actor: "sam",
+		},
+		{
+			name:  "wrong token",
+			token: "secret",
+			req: func() *http.Request {
+				r := httptest.NewRequest(http.MethodGet, "/admin/bans", nil)
+				r.Header.Set("Authorization", "Bearer guess")
+				return r
+			},
+			want: http.StatusUnauthorized,
+		},
+		{
+			name: "admin API key without a token configured",
+			req: func() *http.Request {
+				r := httptest.NewRequest(http.MethodGet, "/admin/bans", nil)
+				r.Header.Set(adminActorHeader, "someone else")
+				return withKey(r, &apiKeyCredentials{id: 1, name: "alex", admin: true})
+			},
+			want:  http.StatusOK,
+			actor: "api_key:alex",
+		},
+		{
+			name:  "ordinary API key",
+			token: "secret",
+			req: func() *http.Request {
+				return withKey(httptest.NewRequest(http.MethodGet, "/admin/bans", nil), &apiKeyCredentials{id: 2, name: "app"})
+			},
+			want: http.StatusUnauthorized,
+		},
+	}
+	for _, tt := range tests {
+		var actor string
+		handler := AdminAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			actor = adminActor(r)
+		}), tt.token)
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, tt.req())
+		if rec.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, rec.Code, tt.want)
+		}
+		if actor != tt.actor {
+			t.Errorf("%s: actor = %q, want %q", tt.name, actor, tt.actor)
+		}
+	}
+}
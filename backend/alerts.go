@@ -0,0 +1,622 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	alertInterval       = time.Minute
+	alertDigestInterval = 24 * time.Hour
+	// Unconfirmed alerts are forgotten after alertConfirmTTL
+	alertConfirmTTL = 7 * 24 * time.Hour
+	// Unsubscribe links stop working after unsubscribeTokenTTL; by then
+	// newer emails link to newer tokens
+	unsubscribeTokenTTL = 365 * 24 * time.Hour
+	// maxAlertsPerEmail caps the alerts, confirmed or not, on one address,
+	// which also caps the confirmation emails it can be sent
+	maxAlertsPerEmail = 5
+	// maxAlertPosts caps the posts listed in one email
+	maxAlertPosts = 20
+)
+
+// Alert frequencies
+const (
+	AlertInstant = "instant"
+	AlertDaily   = "daily"
+)
+
+// EmailAlert emails an address about new posts on an event that mention
+// any of its keywords, once the address has been confirmed.
+type EmailAlert struct {
+	ID        int      `json:"id"`
+	Email     string   `json:"email"`
+	EventName string   `json:"event"`
+	Keywords  []string `json:"keywords"`
+	Frequency string   `json:"frequency"`
+	Confirmed bool     `json:"confirmed"`
+
+	EventID int `json:"-"`
+	// UnsubscribeToken is set on an alert about to be emailed, for the
+	// email to link to. Only its hash is stored.
+	UnsubscribeToken string     `json:"-"`
+	LastPostID       int        `json:"-"`
+	LastSentAt       *time.Time `json:"-"`
+}
+
+type CreateAlertRequest struct {
+	Email    string   `json:"email"`
+	Event    string   `json:"event"`
+	Keywords []string `json:"keywords"`
+	// Frequency is instant or daily, the default
+	Frequency string `json:"frequency"`
+}
+
+// alertURL links to an alert endpoint with token.
+func alertURL(publicURL, path, token string) string {
+	return strings.TrimSuffix(publicURL, "/") + path + "?" + url.Values{"token": {token}}.Encode()
+}
+
+// CreateAlert handles POST /api/alerts. The alert does nothing until the
+// link emailed to the address is followed.
+func (h *Handler) CreateAlert(w http.ResponseWriter, r *http.Request) {
+	if h.cfg.Mailer == nil {
+		respondWithError(w, http.StatusNotFound, "Email alerts are not enabled")
+		return
+	}
+
+	var req CreateAlertRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	address, err := mail.ParseAddress(strings.TrimSpace(req.Email))
+	if err != nil || len(address.Address) > 254 {
+		respondWithError(w, http.StatusBadRequest, "Invalid email address")
+		return
+	}
+	alert := EmailAlert{Email: strings.ToLower(address.Address), Frequency: req.Frequency}
+
+	switch alert.Frequency {
+	case "":
+		alert.Frequency = AlertDaily
+	case AlertInstant, AlertDaily:
+	default:
+		respondWithError(w, http.StatusBadRequest, "frequency must be one of instant, daily")
+		return
+	}
+
+	alert.Keywords, err = normalizeKeywords(req.Keywords)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(alert.Keywords) == 0 {
+		respondWithError(w, http.StatusBadRequest, "keywords must list at least one keyword")
+		return
+	}
+
+	name, err := h.canonicalEventName(r.Context(), strings.TrimSpace(req.Event))
+	if err != nil {
+		log.Printf("Error resolving event name: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to create alert")
+		return
+	}
+	event, err := h.db.GetEvent(r.Context(), name)
+	if err != nil {
+		log.Printf("Error getting event: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to create alert")
+		return
+	}
+	if event == nil {
+		respondWithError(w, http.StatusNotFound, "Event not found")
+		return
+	}
+	alert.EventID, alert.EventName = event.ID, event.Name
+
+	count, err := h.db.CountEmailAlerts(r.Context(), alert.Email)
+	if err != nil {
+		log.Printf("Error counting email alerts: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to create alert")
+		return
+	}
+	if count >= maxAlertsPerEmail {
+		respondWithError(w, http.StatusTooManyRequests, fmt.Sprintf("at most %d alerts are allowed per address", maxAlertsPerEmail))
+		return
+	}
+
+	confirmToken := randomToken(16)
+	unsubscribeToken := randomToken(16)
+	created, err := h.db.CreateEmailAlert(r.Context(), alert, hashToken(confirmToken), hashToken(unsubscribeToken))
+	if err != nil {
+		log.Printf("Error creating email alert: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to create alert")
+		return
+	}
+	created.UnsubscribeToken = unsubscribeToken
+
+	if err := h.cfg.Mailer.Send(r.Context(), confirmationEmail(*created, confirmToken, h.cfg.PublicURL)); err != nil {
+		log.Printf("Error sending alert confirmation: %v", err)
+		respondWithError(w, http.StatusBadGateway, "Failed to send confirmation email")
+		return
+	}
+
+	respondWithJSON(w, http.StatusAccepted, created)
+}
+
+// ConfirmAlert handles GET /api/alerts/confirm?token=, the link in the
+// confirmation email. Only posts made after confirming are alerted on.
+func (h *Handler) ConfirmAlert(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		respondWithError(w, http.StatusBadRequest, "token is required")
+		return
+	}
+
+	alert, err := h.db.ConfirmEmailAlert(r.Context(), hashToken(token))
+	if err != nil {
+		log.Printf("Error confirming email alert: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to confirm alert")
+		return
+	}
+	if alert == nil {
+		respondWithError(w, http.StatusNotFound, "Alert not found")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, alert)
+}
+
+var unsubscribePage = template.Must(template.New("unsubscribe").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Unsubscribe</title>
+<style>
+body { font-family: system-ui, sans-serif; max-width: 32rem; margin: 2rem auto; padding: 0 1rem; color: #1f2937; }
+</style>
+</head>
+<body>
+{{with .Alert}}<p>Stop emailing {{.Email}} about posts on {{.EventName}} mentioning {{range $i, $k := .Keywords}}{{if $i}}, {{end}}{{$k}}{{end}}?</p>
+<form method="post" action="?token={{$.Token}}"><button type="submit">Unsubscribe</button></form>
+{{else}}<p>You're unsubscribed and won't get any more of these emails.</p>
+{{end}}</body>
+</html>
+`))
+
+type unsubscribeData struct {
+	// Alert is the alert to confirm unsubscribing from, or nil once done
+	Alert *EmailAlert
+	Token string
+}
+
+// Unsubscribe handles GET and POST /api/alerts/unsubscribe?token=. GET,
+// the link in alert emails, only shows a page to confirm on, since mail
+// scanners follow links. POST unsubscribes: it is the one-click unsubscribe
+// mail clients send for List-Unsubscribe-Post, answered with 204, and the
+// page's form, answered with a page.
+func (h *Handler) Unsubscribe(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		respondWithError(w, http.StatusBadRequest, "token is required")
+		return
+	}
+
+	data := unsubscribeData{Token: token}
+	if r.Method == http.MethodGet {
+		alert, err := h.db.GetEmailAlertByUnsubscribeToken(r.Context(), hashToken(token))
+		if err != nil {
+			log.Printf("Error getting email alert: %v", err)
+			respondWithError(w, http.StatusInternalServerError, "Failed to retrieve alert")
+			return
+		}
+		if alert == nil {
+			respondWithError(w, http.StatusNotFound, "Alert not found")
+			return
+		}
+		data.Alert = alert
+	} else {
+		found, err := h.db.DeleteEmailAlert(r.Context(), hashToken(token))
+		if err != nil {
+			log.Printf("Error deleting email alert: %v", err)
+			respondWithError(w, http.StatusInternalServerError, "Failed to unsubscribe")
+			return
+		}
+		if !found {
+			respondWithError(w, http.StatusNotFound, "Alert not found")
+			return
+		}
+		if !strings.Contains(r.Header.Get("Accept"), "text/html") {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	}
+
+	var page bytes.Buffer
+	if err := unsubscribePage.Execute(&page, data); err != nil {
+		log.Printf("Error rendering unsubscribe page: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to render page")
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; form-action 'self'; frame-ancestors 'none'")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(page.Bytes())
+}
+
+func confirmationEmail(alert EmailAlert, confirmToken, publicURL string) Email {
+	return Email{
+		To:      alert.Email,
+		Subject: fmt.Sprintf("Confirm your alert for %s", alert.EventName),
+		Body: fmt.Sprintf("Someone, hopefully you, asked to be emailed (%s) about posts on %s mentioning %s.\n\n"+
+			"Confirm the alert: %s\n\n"+
+			"If it wasn't you, ignore this email and you won't hear from us again. "+
+			"Every alert email links to unsubscribe, as does this one: %s\n",
+			alert.Frequency, alert.EventName, strings.Join(alert.Keywords, ", "),
+			alertURL(publicURL, "/api/alerts/confirm", confirmToken),
+			alertURL(publicURL, "/api/alerts/unsubscribe", alert.UnsubscribeToken)),
+	}
+}
+
+// alertEmail lists posts for an alert, with their times in the event's
+// timezone, loc, or UTC if it is nil. more says there were posts left out
+// to keep the email short.
+func alertEmail(alert EmailAlert, posts []Post, more bool, loc *time.Location, publicURL string) Email {
+	if loc == nil {
+		loc = time.UTC
+	}
+	unsubscribe := alertURL(publicURL, "/api/alerts/unsubscribe", alert.UnsubscribeToken)
+
+	subject := fmt.Sprintf("New posts on %s", alert.EventName)
+	if alert.Frequency == AlertDaily {
+		subject = fmt.Sprintf("Your daily digest for %s", alert.EventName)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Posts on %s mentioning %s:\n\n", alert.EventName, strings.Join(alert.Keywords, ", "))
+	for _, post := range posts {
+		fmt.Fprintf(&b, "%s\n%s\n\n", post.CreatedAt.In(loc).Format("Jan 2 15:04 MST"), post.Content)
+	}
+	if more {
+		b.WriteString("More posts matched than fit in this email.\n\n")
+	}
+	fmt.Fprintf(&b, "Unsubscribe: %s\n", unsubscribe)
+
+	return Email{
+		To:      alert.Email,
+		Subject: subject,
+		Body:    b.String(),
+		Headers: map[string]string{
+			"List-Unsubscribe":      "<" + unsubscribe + ">",
+			"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+		},
+	}
+}
+
+// AlertJob emails confirmed alerts about new matching posts: instant alerts
+// every alertInterval, daily ones once a day.
+type AlertJob struct {
+	db        *DB
+	mailer    Mailer
+	publicURL string
+	// failures counts the failed sends of each alert's current email
+	failures map[int]int
+}
+
+// NewAlertJob returns nil when there is no mailer to send with.
+func NewAlertJob(db *DB, mailer Mailer, publicURL string) *AlertJob {
+	if mailer == nil {
+		return nil
+	}
+	return &AlertJob{db: db, mailer: mailer, publicURL: publicURL, failures: make(map[int]int)}
+}
+
+// Run sends alerts every alertInterval until ctx is cancelled.
+func (j *AlertJob) Run(ctx context.Context) {
+	ticker := time.NewTicker(alertInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			j.send(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (j *AlertJob) send(ctx context.Context) {
+	if err := j.db.DeleteUnconfirmedEmailAlerts(ctx, time.Now().Add(-alertConfirmTTL)); err != nil {
+		log.Printf("Error deleting unconfirmed email alerts: %v", err)
+	}
+	if err := j.db.DeleteExpiredUnsubscribeTokens(ctx, time.Now().Add(-unsubscribeTokenTTL)); err != nil {
+		log.Printf("Error deleting expired unsubscribe tokens: %v", err)
+	}
+
+	alerts, err := j.db.GetDueEmailAlerts(ctx, time.Now().Add(-alertDigestInterval))
+	if err != nil {
+		log.Printf("Error getting due email alerts: %v", err)
+		return
+	}
+	sent := 0
+	for _, alert := range alerts {
+		if ctx.Err() != nil {
+			break
+		}
+		ok, err := j.sendAlert(ctx, alert)
+		if err != nil {
+			log.Printf("Error sending email alert %d: %v", alert.ID, err)
+			continue
+		}
+		if ok {
+			sent++
+		}
+	}
+	if sent > 0 {
+		log.Printf("Sent %d email alerts", sent)
+	}
+}
+
+// sendAlert emails the posts made since the alert was last sent, reporting
+// whether there were any. An email that fails maxDeliveryAttempts times is
+// dead-lettered and the alert moves on past its posts.
+func (j *AlertJob) sendAlert(ctx context.Context, alert EmailAlert) (bool, error) {
+	// Bound the posts up front, so posts left out of a long email are
+	// skipped rather than sent next time
+	latest, err := j.db.LatestPostID(ctx)
+	if err != nil {
+		return false, err
+	}
+	filter := PostFilter{Event: alert.EventName, Keywords: alert.Keywords, MaxID: latest}
+	posts, err := j.db.GetPostsAfter(ctx, filter, alert.LastPostID, maxAlertPosts+1)
+	if err != nil {
+		return false, err
+	}
+	if len(posts) == 0 {
+		return false, nil
+	}
+
+	more := len(posts) > maxAlertPosts
+	if more {
+		posts = posts[:maxAlertPosts]
+	}
+	// Each email gets a token of its own, as only their hashes are kept
+	alert.UnsubscribeToken = randomToken(16)
+	if err := j.db.AddUnsubscribeToken(ctx, alert.ID, hashToken(alert.UnsubscribeToken)); err != nil {
+		return false, err
+	}
+	settings, err := j.db.GetEventSettings(ctx, alert.EventName)
+	if err != nil {
+		return false, err
+	}
+	email := alertEmail(alert, posts, more, settings.location(), j.publicURL)
+	if err := j.mailer.Send(ctx, email); err != nil {
+		j.failures[alert.ID]++
+		attempts := j.failures[alert.ID]
+		if attempts < maxDeliveryAttempts {
+			return false, err
+		}
+		log.Printf("Dead-lettering email alert %d after %d attempts: %v", alert.ID, attempts, err)
+		if err := j.db.RecordDeadLetter(ctx, deadLetterEmail, alert.Email, email, err.Error(), attempts); err != nil {
+			return false, err
+		}
+		delete(j.failures, alert.ID)
+		return false, j.db.MarkEmailAlertSent(ctx, alert.ID, latest)
+	}
+	delete(j.failures, alert.ID)
+	return true, j.db.MarkEmailAlertSent(ctx, alert.ID, latest)
+}
+
+const emailAlertColumns = `a.id, a.email, e.id, e.name, a.keywords, a.frequency, a.confirmed_at IS NOT NULL,
+	a.last_post_id, a.last_sent_at`
+
+func scanEmailAlert(row rowScanner) (*EmailAlert, error) {
+	var alert EmailAlert
+	var keywords []byte
+	if err := row.Scan(&alert.ID, &alert.Email, &alert.EventID, &alert.EventName, &keywords, &alert.Frequency,
+		&alert.Confirmed, &alert.LastPostID, &alert.LastSentAt); err != nil {
+		return nil, err
+	}
+	if err := scanJSON(keywords, &alert.Keywords); err != nil {
+		return nil, err
+	}
+	return &alert, nil
+}
+
+// CountEmailAlerts counts an address's alerts, confirmed or not.
+func (db *DB) CountEmailAlerts(ctx context.Context, email string) (int, error) {
+	var count int
+	err := db.conn.QueryRowContext(ctx, "SELECT COUNT(*) FROM email_alerts WHERE email = $1", email).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count email alerts: %w", err)
+	}
+	return count, nil
+}
+
+// CreateEmailAlert saves an unconfirmed alert, with the unsubscribe token
+// its confirmation email links to.
+func (db *DB) CreateEmailAlert(ctx context.Context, alert EmailAlert, confirmTokenHash, unsubscribeTokenHash string) (*EmailAlert, error) {
+	keywords, err := json.Marshal(alert.Keywords)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode keywords: %w", err)
+	}
+
+	query := `
+		WITH a AS (
+			INSERT INTO email_alerts (email, event_id, keywords, frequency, confirm_token_hash)
+			VALUES ($1, $2, $3::jsonb, $4, $5)
+			RETURNING *
+		), t AS (
+			INSERT INTO email_alert_unsubscribe_tokens (token_hash, alert_id)
+			SELECT $6, id FROM a
+		)
+		SELECT ` + emailAlertColumns + `
+		FROM a JOIN events e ON e.id = a.event_id`
+
+	created, err := scanEmailAlert(db.conn.QueryRowContext(ctx, query,
+		alert.Email, alert.EventID, string(keywords), alert.Frequency, confirmTokenHash, unsubscribeTokenHash))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create email alert: %w", err)
+	}
+	return created, nil
+}
+
+// ConfirmEmailAlert confirms the alert with the confirmation token hash,
+// returning nil if there is none. Posts made before confirming are skipped.
+func (db *DB) ConfirmEmailAlert(ctx context.Context, confirmTokenHash string) (*EmailAlert, error) {
+	query := `
+		WITH a AS (
+			UPDATE email_alerts
+			SET confirmed_at = NOW(), confirm_token_hash = NULL,
+				last_post_id = (SELECT COALESCE(MAX(id), 0) FROM posts)
+			WHERE confirm_token_hash = $1
+			RETURNING *
+		)
+		SELECT ` + emailAlertColumns + `
+		FROM a JOIN events e ON e.id = a.event_id`
+
+	alert, err := scanEmailAlert(db.conn.QueryRowContext(ctx, query, confirmTokenHash))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to confirm email alert: %w", err)
+	}
+	return alert, nil
+}
+
+// AddUnsubscribeToken saves the hash of a new unsubscribe token for an
+// alert, alongside those of its earlier emails.
+func (db *DB) AddUnsubscribeToken(ctx context.Context, alertID int, tokenHash string) error {
+	_, err := db.conn.ExecContext(ctx,
+		"INSERT INTO email_alert_unsubscribe_tokens (token_hash, alert_id) VALUES ($1, $2)", tokenHash, alertID)
+	if err != nil {
+		return fmt.Errorf("failed to save unsubscribe token: %w", err)
+	}
+	return nil
+}
+
+// DeleteExpiredUnsubscribeTokens forgets unsubscribe tokens issued before
+// cutoff.
+func (db *DB) DeleteExpiredUnsubscribeTokens(ctx context.Context, cutoff time.Time) error {
+	_, err := db.conn.ExecContext(ctx, "DELETE FROM email_alert_unsubscribe_tokens WHERE created_at < $1", cutoff)
+	if err != nil {
+		return fmt.Errorf("failed to delete expired unsubscribe tokens: %w", err)
+	}
+	return nil
+}
+
+// GetEmailAlertByUnsubscribeToken returns the alert with the unsubscribe
+// token hash, or nil if there is none.
+func (db *DB) GetEmailAlertByUnsubscribeToken(ctx context.Context, tokenHash string) (*EmailAlert, error) {
+	alert, err := scanEmailAlert(db.conn.QueryRowContext(ctx, `
+		SELECT `+emailAlertColumns+`
+		FROM email_alert_unsubscribe_tokens t
+		JOIN email_alerts a ON a.id = t.alert_id
+		JOIN events e ON e.id = a.event_id
+		WHERE t.token_hash = $1`, tokenHash))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get email alert: %w", err)
+	}
+	return alert, nil
+}
+
+// DeleteEmailAlert deletes the alert with the unsubscribe token hash,
+// reporting whether there was one.
+func (db *DB) DeleteEmailAlert(ctx context.Context, unsubscribeTokenHash string) (bool, error) {
+	result, err := db.conn.ExecContext(ctx, `
+		DELETE FROM email_alerts
+		WHERE id = (SELECT alert_id FROM email_alert_unsubscribe_tokens WHERE token_hash = $1)
+	`, unsubscribeTokenHash)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete email alert: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to delete email alert: %w", err)
+	}
+
+	return affected > 0, nil
+}
+
+// DeleteUnconfirmedEmailAlerts forgets alerts created before cutoff that
+// were never confirmed.
+func (db *DB) DeleteUnconfirmedEmailAlerts(ctx context.Context, cutoff time.Time) error {
+	_, err := db.conn.ExecContext(ctx, "DELETE FROM email_alerts WHERE confirmed_at IS NULL AND created_at < $1", cutoff)
+	if err != nil {
+		return fmt.Errorf("failed to delete unconfirmed email alerts: %w", err)
+	}
+	return nil
+}
+
+// GetDueEmailAlerts returns the confirmed alerts to check for new posts:
+// every instant alert, and daily alerts last sent before digestCutoff.
+func (db *DB) GetDueEmailAlerts(ctx context.Context, digestCutoff time.Time) ([]EmailAlert, error) {
+	rows, err := db.conn.QueryContext(ctx, `
+		SELECT `+emailAlertColumns+`
+		FROM email_alerts a
+		JOIN events e ON e.id = a.event_id
+		WHERE a.confirmed_at IS NOT NULL
+		AND (a.frequency = $1 OR a.last_sent_at IS NULL OR a.last_sent_at < $2)
+		ORDER BY a.id
+	`, AlertInstant, digestCutoff)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query email alerts: %w", err)
+	}
+	defer rows.Close()
+
+	var alerts []EmailAlert
+	for rows.Next() {
+		alert, err := scanEmailAlert(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan email alert: %w", err)
+		}
+		alerts = append(alerts, *alert)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating email alerts: %w", err)
+	}
+
+	return alerts, nil
+}
+
+// MarkEmailAlertSent records that an alert was sent with the posts up to
+// lastPostID.
+func (db *DB) MarkEmailAlertSent(ctx context.Context, id, lastPostID int) error {
+	_, err := db.conn.ExecContext(ctx, `
+		UPDATE email_alerts SET last_post_id = $2, last_sent_at = NOW() WHERE id = $1
+	`, id, lastPostID)
+	if err != nil {
+		return fmt.Errorf("failed to mark email alert sent: %w", err)
+	}
+	return nil
+}
+
+// LatestPostID returns the ID of the newest post, or 0 if there are none.
+func (db *DB) LatestPostID(ctx context.Context) (int, error) {
+	var id int
+	if err := db.conn.QueryRowContext(ctx, "SELECT COALESCE(MAX(id), 0) FROM posts").Scan(&id); err != nil {
+		return 0, fmt.Errorf("failed to get latest post ID: %w", err)
+	}
+	return id, nil
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeMailer keeps the emails it is asked to send.
+type fakeMailer struct {
+	sent []Email
+	fail bool
+}
+
+func (m *fakeMailer) Send(ctx context.Context, email Email) error {
+	if m.fail {
+		return errors.New("mail relay unavailable")
+	}
+	m.sent = append(m.sent, email)
+	return nil
+}
+
+var alertLinkPattern = regexp.MustCompile(`https?://\S+/api/alerts/(confirm|unsubscribe)\?token=(\w+)`)
+
+// tokens returns the confirm and unsubscribe tokens linked from the last
+// email sent.
+func (m *fakeMailer) tokens(t *testing.T) (confirm, unsubscribe string) {
+	t.Helper()
+	if len(m.sent) == 0 {
+		t.Fatal("no email sent")
+	}
+	for _, match := range alertLinkPattern.FindAllStringSubmatch(m.sent[len(m.sent)-1].Body, -1) {
+		if match[1] == "confirm" {
+			confirm = match[2]
+		} else {
+			unsubscribe = match[2]
+		}
+	}
+	return confirm, unsubscribe
+}
+
+func TestCreateAlert(t *testing.T) {
+	event := &Event{ID: 7, Name: "Glastonbury"}
+
+	tests := []struct {
+		name      string
+		body      string
+		noMailer  bool
+		event     *Event
+		existing  int
+		mailFails bool
+		status    int
+		errorMsg  string
+	}{
+		{name: "disabled", noMailer: true, body: `{}`, status: 404, errorMsg: "Email alerts are not enabled"},
+		{name: "invalid body", body: `[]`, status: 400, errorMsg: "Invalid request body"},
+		{name: "bad email", body: `{"email":"fan","event":"Glastonbury","keywords":["tent"]}`, status: 400, errorMsg: "Invalid email address"},
+		{name: "bad frequency", body: `{"email":"fan@example.com","event":"Glastonbury","keywords":["tent"],"frequency":"hourly"}`, status: 400, errorMsg: "frequency must be one of instant, daily"},
+		{name: "no keywords", body: `{"email":"fan@example.com","event":"Glastonbury"}`, status: 400, errorMsg: "keywords must list at least one keyword"},
+		{name: "blank keyword", body: `{"email":"fan@example.com","event":"Glastonbury","keywords":[""]}`, status: 400, errorMsg: "keywords can't be empty"},
+		{name: "unknown event", body: `{"email":"fan@example.com","event":"Nowhere","keywords":["tent"]}`, status: 404, errorMsg: "Event not found"},
+		{name: "too many alerts", event: event, existing: 5, body: `{"email":"Fan@Example.com","event":"Glastonbury","keywords":["tent"]}`, status: 429, errorMsg: "at most 5 alerts are allowed per address"},
+		{name: "mail fails", event: event, mailFails: true, body: `{"email":"fan@example.com","event":"Glastonbury","keywords":["tent"]}`, status: 502, errorMsg: "Failed to send confirmation email"},
+		{name: "created", event: event, existing: 4, body: `{"email":"Fan <Fan@Example.com>","event":"Glastonbury","keywords":[" Tent ","tent"]}`, status: 202},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newFakeStore()
+			store.event = tt.event
+			for i := 0; i < tt.existing; i++ {
+				store.alerts = append(store.alerts, EmailAlert{Email: "fan@example.com"})
+			}
+			mailer := &fakeMailer{fail: tt.mailFails}
+			cfg := HandlerConfig{Mailer: mailer, PublicURL: "https://api.example.com/"}
+			if tt.noMailer {
+				cfg.Mailer = nil
+			}
+			h := newTestHandler(store, cfg)
+
+			rec := httptest.NewRecorder()
+			h.CreateAlert(rec, httptest.NewRequest(http.MethodPost, "/api/alerts", strings.NewReader(tt.body)))
+
+			if tt.errorMsg != "" {
+				assertError(t, rec, tt.status, tt.errorMsg)
+				return
+			}
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+
+			alert := store.alerts[len(store.alerts)-1]
+			if alert.Email != "fan@example.com" || alert.EventID != 7 || alert.Frequency != AlertDaily || len(alert.Keywords) != 1 || alert.Keywords[0] != "tent" {
+				t.Errorf("alert = %+v", alert)
+			}
+			if len(mailer.sent) != 1 || mailer.sent[0].To != "fan@example.com" {
+				t.Fatalf("sent %+v, want one email to fan@example.com", mailer.sent)
+			}
+			confirm, unsubscribe := mailer.tokens(t)
+			if hashToken(confirm) != store.alertConfirmHash {
+				t.Errorf("confirmation link token %q doesn't match the stored hash", confirm)
+			}
+			if id, ok := store.unsubscribeHashes[hashToken(unsubscribe)]; !ok || id != alert.ID {
+				t.Errorf("unsubscribe link token %q doesn't match a stored hash", unsubscribe)
+			}
+			if strings.Contains(mailer.sent[0].Body, "example.com//api") {
+				t.Error("links doubled the slash after the public URL")
+			}
+		})
+	}
+}
+
+func TestConfirmAndUnsubscribe(t *testing.T) {
+	store := newFakeStore()
+	store.alerts = []EmailAlert{{ID: 1, Email: "fan@example.com", EventName: "Glastonbury", Keywords: []string{"tent"}}}
+	store.alertConfirmHash = hashToken("confirm")
+	store.unsubscribeHashes = map[string]int{hashToken("unsub"): 1}
+	h := newTestHandler(store, HandlerConfig{})
+
+	rec := httptest.NewRecorder()
+	h.ConfirmAlert(rec, httptest.NewRequest(http.MethodGet, "/api/alerts/confirm", nil))
+	assertError(t, rec, 400, "token is required")
+
+	rec = httptest.NewRecorder()
+	h.ConfirmAlert(rec, httptest.NewRequest(http.MethodGet, "/api/alerts/confirm?token=wrong", nil))
+	assertError(t, rec, 404, "Alert not found")
+
+	rec = httptest.NewRecorder()
+	h.ConfirmAlert(rec, httptest.NewRequest(http.MethodGet, "/api/alerts/confirm?token=confirm", nil))
+	if rec.Code != http.StatusOK || !store.alerts[0].Confirmed {
+		t.Fatalf("confirm status = %d, confirmed = %v", rec.Code, store.alerts[0].Confirmed)
+	}
+
+	// Following the link only asks to confirm
+	rec = httptest.NewRecorder()
+	h.Unsubscribe(rec, httptest.NewRequest(http.MethodGet, "/api/alerts/unsubscribe?token=unsub", nil))
+	if rec.Code != http.StatusOK || len(store.alerts) != 1 {
+		t.Fatalf("GET unsubscribe status = %d, alerts left = %d, want 200 and the alert kept", rec.Code, len(store.alerts))
+	}
+	if body := rec.Body.String(); !strings.Contains(body, `<form method="post" action="?token=unsub">`) || !strings.Contains(body, "Glastonbury") {
+		t.Errorf("GET unsubscribe page = %s, want a form posting the token", body)
+	}
+
+	rec = httptest.NewRecorder()
+	h.Unsubscribe(rec, httptest.NewRequest(http.MethodGet, "/api/alerts/unsubscribe?token=wrong", nil))
+	assertError(t, rec, 404, "Alert not found")
+
+	rec = httptest.NewRecorder()
+	h.Unsubscribe(rec, httptest.NewRequest(http.MethodPost, "/api/alerts/unsubscribe?token=unsub", strings.NewReader("List-Unsubscribe=One-Click")))
+	if rec.Code != http.StatusNoContent || len(store.alerts) != 0 {
+		t.Fatalf("unsubscribe status = %d, alerts left = %d", rec.Code, len(store.alerts))
+	}
+
+	rec = httptest.NewRecorder()
+	h.Unsubscribe(rec, httptest.NewRequest(http.MethodGet, "/api/alerts/unsubscribe?token=unsub", nil))
+	assertError(t, rec, 404, "Alert not found")
+}
+
+func TestAlertEmail(t *testing.T) {
+	alert := EmailAlert{Email: "fan@example.com", EventName: "Glastonbury", Keywords: []string{"tent", "lost"}, Frequency: AlertDaily, UnsubscribeToken: "abc"}
+	posts := []Post{
+		{Content: "Lost my tent pegs", CreatedAt: time.Date(2026, 6, 26, 14, 5, 0, 0, time.UTC)},
+		{Content: "Spare tent by the Park stage", CreatedAt: time.Date(2026, 6, 26, 15, 30, 0, 0, time.UTC)},
+	}
+
+	email := alertEmail(alert, posts, true, nil, "https://api.example.com")
+	if email.Subject != "Your daily digest for Glastonbury" {
+		t.Errorf("subject = %q", email.Subject)
+	}
+	for _, want := range []string{
+		"Posts on Glastonbury mentioning tent, lost:",
+		"Jun 26 14:05 UTC\nLost my tent pegs",
+		"More posts matched than fit in this email.",
+		"Unsubscribe: https://api.example.com/api/alerts/unsubscribe?token=abc",
+	} {
+		if !strings.Contains(email.Body, want) {
+			t.Errorf("body is missing %q:\n%s", want, email.Body)
+		}
+	}
+	if got := email.Headers["List-Unsubscribe"]; got != "<https://api.example.com/api/alerts/unsubscribe?token=abc>" {
+		t.Errorf("List-Unsubscribe = %q", got)
+	}
+
+	london, err := time.LoadLocation("Europe/London")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if email := alertEmail(alert, posts[:1], false, london, "https://api.example.com"); !strings.Contains(email.Body, "Jun 26 15:05 BST\nLost my tent pegs") {
+		t.Errorf("body doesn't give the time at the venue:\n%s", email.Body)
+	}
+
+	alert.Frequency = AlertInstant
+	if email := alertEmail(alert, posts[:1], false, nil, "https://api.example.com"); email.Subject != "New posts on Glastonbury" || strings.Contains(email.Body, "More posts") {
+		t.Errorf("instant email = %+v", email)
+	}
+}
+
+func TestFormatEmail(t *testing.T) {
+	msg := string(formatEmail("alerts@example.com", Email{
+		To:      "fan@example.com",
+		Subject: "Neue Beiträge",
+		Body:    "line one\nline two\n",
+		Headers: map[string]string{"List-Unsubscribe": "<" + (&url.URL{Scheme: "https", Host: "api.example.com"}).String() + ">"},
+	}, time.Date(2026, 6, 26, 12, 0, 0, 0, time.UTC)))
+
+	for _, want := range []string{
+		"From: alerts@example.com\r\n",
+		"To: fan@example.com\r\n",
+		"Subject: =?utf-8?q?Neue_Beitr=C3=A4ge?=\r\n",
+		"Date: Fri, 26 Jun 2026 12:00:00 +0000\r\n",
+		"List-Unsubscribe: <https://api.example.com>\r\n",
+		"\r\n\r\nline one\r\nline two\r\n",
+	} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("message is missing %q:\n%s", want, msg)
+		}
+	}
+}
+
+func TestNewMailer(t *testing.T) {
+	if m, err := NewMailer("none", "", "", "", ""); m != nil || err != nil {
+		t.Errorf("none = %v, %v", m, err)
+	}
+	if _, err := NewMailer("smtp", "", "", "", "alerts@example.com"); err == nil {
+		t.Error("smtp without an address succeeded")
+	}
+	if _, err := NewMailer("smtp", "smtp.example.com", "", "", "alerts@example.com"); err == nil {
+		t.Error("smtp address without a port succeeded")
+	}
+	if m, err := NewMailer("smtp", "smtp.example.com:587", "user", "pass", "alerts@example.com"); m == nil || err != nil {
+		t.Errorf("smtp = %v, %v", m, err)
+	}
+	if _, err := NewMailer("carrier-pigeon", "", "", "", ""); err == nil {
+		t.Error("unknown mailer succeeded")
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const altTextBackfillBatchSize = 50
+
+// Captioner describes an image in words, for generating alt text.
+type Captioner interface {
+	Caption(ctx context.Context, image []byte, contentType string) (string, error)
+}
+
+// NewCaptioner builds the provider named by CAPTION_PROVIDER. It returns nil
+// when captioning is disabled.
+func NewCaptioner(kind, endpoint, apiKey string) (Captioner, error) {
+	switch kind {
+	case "", "none":
+		return nil, nil
+	case "http":
+		if endpoint == "" {
+			return nil, fmt.Errorf("CAPTION_URL is required for the http captioner")
+		}
+		return &HTTPCaptioner{endpoint: endpoint, apiKey: apiKey, client: &http.Client{Timeout: 60 * time.Second}}, nil
+	default:
+		return nil, fmt.Errorf("unknown caption provider %q", kind)
+	}
+}
+
+// HTTPCaptioner posts the raw image to a captioning service (for example a
+// self-hosted BLIP server) that responds with {"caption": "..."}.
+type HTTPCaptioner struct {
+	endpoint string
+	apiKey   string
+	client   *http.Client
+}
+
+func (c *HTTPCaptioner) Caption(ctx context.Context, image []byte, contentType string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint, bytes.NewReader(image))
+	if err != nil {
+		return "", fmt.Errorf("failed to build caption request: %w", err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	if c.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to send caption request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return "", fmt.Errorf("caption provider returned status %d: %s", resp.StatusCode, msg)
+	}
+
+	var out struct {
+		Caption string `json:"caption"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return "", fmt.Errorf("failed to decode caption response: %w", err)
+	}
+	return out.Caption, nil
+}
+
+// AltTextBackfillStatus reports the progress of the current or last run.
+type AltTextBackfillStatus struct {
+	Running    bool       `json:"running"`
+	Processed  int        `json:"processed"`
+	Failed     int        `json:"failed"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// AltTextBackfill generates alt text for attached images that have none,
+// typically those uploaded before alt text was collected. It runs on demand
+// from the admin API, one run at a time.
+type AltTextBackfill struct {
+	ctx       context.Context
+	db        *DB
+	media     MediaStore
+	captioner Captioner
+
+	mu     sync.Mutex
+	status AltTextBackfillStatus
+}
+
+// NewAltTextBackfill creates the job; runs stop when ctx is cancelled.
+func NewAltTextBackfill(ctx context.Context, db *DB, media MediaStore, captioner Captioner) *AltTextBackfill {
+	return &AltTextBackfill{ctx: ctx, db: db, media: media, captioner: captioner}
+}
+
+// Start begins a run in the background, reporting false if one is already
+// running.
+func (b *AltTextBackfill) Start() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.status.Running {
+		return false
+	}
+	now := time.Now()
+	b.status = AltTextBackfillStatus{Running: true, StartedAt: &now}
+
+	go b.run()
+	return true
+}
+
+func (b *AltTextBackfill) Status() AltTextBackfillStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.status
+}
+
+func (b *AltTextBackfill) run() {
+	defer func() {
+		b.mu.Lock()
+		now := time.Now()
+		b.status.Running = false
+		b.status.FinishedAt = &now
+		log.Printf("Alt text backfill finished: %d captioned, %d failed", b.status.Processed, b.status.Failed)
+		b.mu.Unlock()
+	}()
+
+	afterID := 0
+	for b.ctx.Err() == nil {
+		batch, err := b.db.GetAttachmentsMissingAltText(b.ctx, afterID, altTextBackfillBatchSize)
+		if err != nil {
+			log.Printf("Error listing attachments for alt text backfill: %v", err)
+			return
+		}
+		if len(batch) == 0 {
+			return
+		}
+
+		for _, a := range batch {
+			afterID = a.ID
+			err := b.caption(a)
+
+			b.mu.Lock()
+			if err != nil {
+				log.Printf("Error generating alt text for attachment %d: %v", a.ID, err)
+				b.status.Failed++
+			} else {
+				b.status.Processed++
+			}
+			b.mu.Unlock()
+		}
+	}
+}
+
+func (b *AltTextBackfill) caption(a Attachment) error {
+	data, err := b.media.Get(b.ctx, a.storageKey)
+	if err != nil {
+		return fmt.Errorf("failed to read media: %w", err)
+	}
+
+	caption, err := b.captioner.Caption(b.ctx, data, a.ContentType)
+	if err != nil {
+		return err
+	}
+
+	if len(caption) > maxAltTextLength {
+		caption = strings.ToValidUTF8(caption[:maxAltTextLength], "")
+	}
+	caption, err = normalizeAltText(caption)
+	if err != nil || caption == "" {
+		return fmt.Errorf("provider returned unusable caption %q", caption)
+	}
+
+	return b.db.SetGeneratedAltText(b.ctx, a.ID, caption)
+}
+
+// StartAltTextBackfill handles POST /admin/alt-text-backfill
+func (h *Handler) StartAltTextBackfill(w http.ResponseWriter, r *http.Request) {
+	if h.cfg.AltTextBackfill == nil {
+		respondWithError(w, http.StatusNotFound, "No caption provider is configured")
+		return
+	}
+
+	if !h.cfg.AltTextBackfill.Start() {
+		respondWithError(w, http.StatusConflict, "A backfill is already running")
+		return
+	}
+
+	h.audit(r, "attachment.backfill_alt_text", "", "", nil)
+
+	respondWithJSON(w, http.StatusAccepted, h.cfg.AltTextBackfill.Status())
+}
+
+// GetAltTextBackfill handles GET /admin/alt-text-backfill
+func (h *Handler) GetAltTextBackfill(w http.ResponseWriter, r *http.Request) {
+	if h.cfg.AltTextBackfill == nil {
+		respondWithError(w, http.StatusNotFound, "No caption provider is configured")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, h.cfg.AltTextBackfill.Status())
+}
+
+// GetAttachmentsMissingAltText returns attached images without alt text,
+// in ID order after afterID.
+func (db *DB) GetAttachmentsMissingAltText(ctx context.Context, afterID, limit int) ([]Attachment, error) {
+	return db.queryAttachments(ctx, `
+		SELECT `+attachmentColumns+`
+		FROM attachments
+		WHERE alt_text IS NULL AND post_id IS NOT NULL AND scan_status = 'clean' AND id > $1
+		ORDER BY id
+		LIMIT $2
+	`, afterID, limit)
+}
+
+// SetGeneratedAltText stores generated alt text unless the attachment has
+// gained alt text in the meantime.
+func (db *DB) SetGeneratedAltText(ctx context.Context, id int, altText string) error {
+	_, err := db.conn.ExecContext(ctx, `
+		UPDATE attachments
+		SET alt_text = $2, alt_text_generated = TRUE
+		WHERE id = $1 AND alt_text IS NULL
+	`, id, altText)
+	if err != nil {
+		return fmt.Errorf("failed to set alt text: %w", err)
+	}
+	return nil
+}
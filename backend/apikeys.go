@@ -0,0 +1,323 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	apiKeyHeader   = "X-API-Key"
+	apiKeyPrefix   = "hk_"
+	apiKeyCacheTTL = time.Minute
+)
+
+// APIKey is a client's key. Admin keys can also use the admin routes, like
+// ADMIN_TOKEN.
+type APIKey struct {
+	ID               int        `json:"id"`
+	Name             string     `json:"name"`
+	KeyPrefix        string     `json:"key_prefix"`
+	RequireSignature bool       `json:"require_signature"`
+	Admin            bool       `json:"admin"`
+	CreatedAt        time.Time  `json:"created_at"`
+	RevokedAt        *time.Time `json:"revoked_at,omitempty"`
+}
+
+type CreateAPIKeyRequest struct {
+	Name             string `json:"name"`
+	RequireSignature bool   `json:"require_signature"`
+	Admin            bool   `json:"admin"`
+}
+
+// CreateAPIKeyResponse is the only time the plaintext key and signing
+// secret are returned.
+type CreateAPIKeyResponse struct {
+	APIKey
+	Key           string `json:"key"`
+	SigningSecret string `json:"signing_secret"`
+}
+
+// apiKeyCredentials is what a request authenticated by API key carries in
+// its context.
+type apiKeyCredentials struct {
+	id               int
+	name             string
+	signingSecret    string
+	requireSignature bool
+	admin            bool
+}
+
+// APIKeyAuth identifies API-key clients. Requests without a key pass through
+// anonymously; requests with an unknown or revoked key are rejected.
+type APIKeyAuth struct {
+	db *DB
+
+	mu    sync.Mutex
+	cache map[string]cachedAPIKey
+	// prunedAt is when expired entries were last swept from cache
+	prunedAt time.Time
+}
+
+type cachedAPIKey struct {
+	creds     *apiKeyCredentials
+	fetchedAt time.Time
+}
+
+func NewAPIKeyAuth(db *DB) *APIKeyAuth {
+	return &APIKeyAuth{
+		db:    db,
+		cache: make(map[string]cachedAPIKey),
+	}
+}
+
+func (a *APIKeyAuth) Authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(apiKeyHeader)
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		creds, err := a.lookup(r.Context(), hashAPIKey(key))
+		if err != nil {
+			log.Printf("Error looking up API key: %v", err)
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+		if creds == nil {
+			respondWithError(w, http.StatusUnauthorized, "Invalid API key")
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), apiKeyKey, creds)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// lookup resolves a key hash to its credentials, caching briefly so
+// revocations take effect within apiKeyCacheTTL without a query on every
+// request. It returns nil for unknown or revoked keys. Those aren't cached,
+// so random keys can't grow the cache, which holds at most the active keys
+// seen in the last apiKeyCacheTTL.
+func (a *APIKeyAuth) lookup(ctx context.Context, keyHash string) (*apiKeyCredentials, error) {
+	a.mu.Lock()
+	cached, ok := a.cache[keyHash]
+	a.mu.Unlock()
+	if ok && time.Since(cached.fetchedAt) < apiKeyCacheTTL {
+		return cached.creds, nil
+	}
+
+	creds, err := a.db.GetActiveAPIKey(ctx, keyHash)
+	if err != nil {
+		return nil, err
+	}
+
+	if creds == nil {
+		return nil, nil
+	}
+
+	now := time.Now()
+	a.mu.Lock()
+	if now.Sub(a.prunedAt) >= apiKeyCacheTTL {
+		for hash, entry := range a.cache {
+			if now.Sub(entry.fetchedAt) >= apiKeyCacheTTL {
+				delete(a.cache, hash)
+			}
+		}
+		a.prunedAt = now
+	}
+	a.cache[keyHash] = cachedAPIKey{creds: creds, fetchedAt: now}
+	a.mu.Unlock()
+
+	return creds, nil
+}
+
+const apiKeyKey contextKey = "apiKey"
+
+func apiKeyFromContext(ctx context.Context) *apiKeyCredentials {
+	creds, _ := ctx.Value(apiKeyKey).(*apiKeyCredentials)
+	return creds
+}
+
+// APIKeyIDFromContext returns the authenticated API key ID, or 0.
+func APIKeyIDFromContext(ctx context.Context) int {
+	if creds := apiKeyFromContext(ctx); creds != nil {
+		return creds.id
+	}
+	return 0
+}
+
+func hashAPIKey(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:])
+}
+
+// CreateAPIKey handles POST /admin/keys
+func (h *Handler) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	var req CreateAPIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		respondWithError(w, http.StatusBadRequest, "name is required")
+		return
+	}
+	if len(req.Name) > 200 {
+		respondWithError(w, http.StatusBadRequest, "name must be 200 characters or less")
+		return
+	}
+
+	key := apiKeyPrefix + randomToken(24)
+	signingSecret := randomToken(32)
+	apiKey, err := h.db.CreateAPIKey(r.Context(), req, key[:len(apiKeyPrefix)+6], hashAPIKey(key), signingSecret)
+	if err != nil {
+		log.Printf("Error creating API key: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to create API key")
+		return
+	}
+
+	h.audit(r, "api_key.create", "api_key", strconv.Itoa(apiKey.ID), req)
+
+	respondWithJSON(w, http.StatusCreated, CreateAPIKeyResponse{APIKey: *apiKey, Key: key, SigningSecret: signingSecret})
+}
+
+// ListAPIKeys handles GET /admin/keys
+func (h *Handler) ListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := h.db.ListAPIKeys(r.Context())
+	if err != nil {
+		log.Printf("Error listing API keys: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve API keys")
+		return
+	}
+
+	if keys == nil {
+		keys = []APIKey{}
+	}
+
+	respondWithJSON(w, http.StatusOK, keys)
+}
+
+// RevokeAPIKey handles DELETE /admin/keys/{id}
+func (h *Handler) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid API key ID")
+		return
+	}
+
+	found, err := h.db.RevokeAPIKey(r.Context(), id)
+	if err != nil {
+		log.Printf("Error revoking API key: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to revoke API key")
+		return
+	}
+	if !found {
+		respondWithError(w, http.StatusNotFound, "API key not found")
+		return
+	}
+
+	h.audit(r, "api_key.revoke", "api_key", strconv.Itoa(id), nil)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (db *DB) CreateAPIKey(ctx context.Context, req CreateAPIKeyRequest, keyPrefix, keyHash, signingSecret string) (*APIKey, error) {
+	query := `
+		INSERT INTO api_keys (name, key_prefix, key_hash, signing_secret, require_signature, admin)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, name, key_prefix, require_signature, admin, created_at
+	`
+
+	var key APIKey
+	err := db.conn.QueryRowContext(ctx, query, req.Name, keyPrefix, keyHash, signingSecret, req.RequireSignature, req.Admin).Scan(
+		&key.ID,
+		&key.Name,
+		&key.KeyPrefix,
+		&key.RequireSignature,
+		&key.Admin,
+		&key.CreatedAt,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create API key: %w", err)
+	}
+
+	return &key, nil
+}
+
+func (db *DB) ListAPIKeys(ctx context.Context) ([]APIKey, error) {
+	rows, err := db.conn.QueryContext(ctx, `
+		SELECT id, name, key_prefix, require_signature, admin, created_at, revoked_at
+		FROM api_keys
+		ORDER BY created_at DESC
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query API keys: %w", err)
+	}
+	defer rows.Close()
+
+	var keys []APIKey
+	for rows.Next() {
+		var key APIKey
+		if err := rows.Scan(&key.ID, &key.Name, &key.KeyPrefix, &key.RequireSignature, &key.Admin, &key.CreatedAt, &key.RevokedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan API key: %w", err)
+		}
+		keys = append(keys, key)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating API keys: %w", err)
+	}
+
+	return keys, nil
+}
+
+// RevokeAPIKey marks a key revoked, reporting whether it existed.
+func (db *DB) RevokeAPIKey(ctx context.Context, id int) (bool, error) {
+	result, err := db.conn.ExecContext(ctx, `
+		UPDATE api_keys
+		SET revoked_at = COALESCE(revoked_at, NOW())
+		WHERE id = $1
+	`, id)
+	if err != nil {
+		return false, fmt.Errorf("failed to revoke API key: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to revoke API key: %w", err)
+	}
+
+	return affected > 0, nil
+}
+
+// GetActiveAPIKey returns the credentials of an unrevoked key, or nil if
+// none matches.
+func (db *DB) GetActiveAPIKey(ctx context.Context, keyHash string) (*apiKeyCredentials, error) {
+	var creds apiKeyCredentials
+	err := db.conn.QueryRowContext(ctx, `
+		SELECT id, name, signing_secret, require_signature, admin
+		FROM api_keys
+		WHERE key_hash = $1 AND revoked_at IS NULL
+	`, keyHash).Scan(&creds.id, &creds.name, &creds.signingSecret, &creds.requireSignature, &creds.admin)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up API key: %w", err)
+	}
+
+	return &creds, nil
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// appealReason is the moderation queue reason of an author's appeal.
+const appealReason = "appeal"
+
+const maxAppealLength = 1000
+
+// Statuses of an appealed post, reported by GET /api/post-status/{token}
+const (
+	postStatusAppealPending = "appeal_pending"
+	postStatusReinstated    = "reinstated"
+	postStatusAppealDenied  = "appeal_denied"
+)
+
+// Appeal decisions
+const (
+	appealGrant = "grant"
+	appealDeny  = "deny"
+)
+
+type AppealRequest struct {
+	Message string `json:"message"`
+}
+
+// AppealResponse is the 202 response to an appeal. The author polls
+// StatusURL to learn the decision.
+type AppealResponse struct {
+	Status    string `json:"status"`
+	StatusURL string `json:"status_url"`
+}
+
+type ResolveAppealRequest struct {
+	Decision string `json:"decision"`
+}
+
+// AppealPost handles POST /api/posts/{id}/appeal. Only the author of a
+// hidden post, identified by the X-Device-Token it was posted with, can
+// appeal, and only once.
+func (h *Handler) AppealPost(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid post ID")
+		return
+	}
+
+	tokenHash, ok := deviceToken(w, r)
+	if !ok {
+		return
+	}
+
+	var req AppealRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	req.Message = strings.TrimSpace(req.Message)
+	if req.Message == "" {
+		respondWithError(w, http.StatusBadRequest, "message is required")
+		return
+	}
+	if len(req.Message) > maxAppealLength {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("message must be %d characters or less", maxAppealLength))
+		return
+	}
+
+	// Posts that aren't hidden, or aren't this device's, look the same
+	notice, err := h.db.GetRemovalNotice(r.Context(), id, tokenHash)
+	if err != nil {
+		log.Printf("Error getting removal notice: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to appeal post")
+		return
+	}
+	if notice == nil {
+		respondWithError(w, http.StatusNotFound, "Post not found")
+		return
+	}
+
+	statusToken := randomToken(16)
+	created, err := h.db.CreateAppeal(r.Context(), id, req.Message, hashToken(statusToken))
+	if err != nil {
+		log.Printf("Error creating appeal: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to appeal post")
+		return
+	}
+	if !created {
+		respondWithError(w, http.StatusConflict, "This post has already been appealed")
+		return
+	}
+
+	respondWithJSON(w, http.StatusAccepted, AppealResponse{
+		Status:    postStatusAppealPending,
+		StatusURL: "/api/post-status/" + statusToken,
+	})
+}
+
+// ResolveAppeal handles POST /admin/appeals/{id}/resolve, where id is the
+// appeal's moderation queue item. Granting an appeal reinstates the post.
+func (h *Handler) ResolveAppeal(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid appeal ID")
+		return
+	}
+
+	var req ResolveAppealRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if req.Decision != appealGrant && req.Decision != appealDeny {
+		respondWithError(w, http.StatusBadRequest, "decision must be grant or deny")
+		return
+	}
+
+	found, err := h.db.ResolveAppeal(r.Context(), id, req.Decision == appealGrant, adminActor(r))
+	if err != nil {
+		log.Printf("Error resolving appeal: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to resolve appeal")
+		return
+	}
+	if !found {
+		respondWithError(w, http.StatusNotFound, "Open appeal not found")
+		return
+	}
+
+	h.audit(r, "appeal.resolve", "moderation_item", strconv.Itoa(id), req)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// CreateAppeal queues an appeal of a post for moderators, reporting false
+// if the post has been appealed before.
+func (db *DB) CreateAppeal(ctx context.Context, postID int, message, statusTokenHash string) (bool, error) {
+	result, err := db.conn.ExecContext(ctx, `
+		INSERT INTO moderation_queue (post_id, reason, details, source, status_token_hash)
+		VALUES ($1, $2, $3, 'author', $4)
+		ON CONFLICT (post_id) WHERE reason = 'appeal' DO NOTHING
+	`, postID, appealReason, message, statusTokenHash)
+	if err != nil {
+		return false, fmt.Errorf("failed to create appeal: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to create appeal: %w", err)
+	}
+
+	return affected > 0, nil
+}
+
+// GetAppealStatus returns the status of the appeal with a status token, or
+// nil if there is none. A resolved appeal was granted if its post is no
+// longer hidden, however that came about.
+func (db *DB) GetAppealStatus(ctx context.Context, statusTokenHash string) (*PostStatus, error) {
+	var status PostStatus
+	var resolved, visible bool
+	err := db.conn.QueryRowContext(ctx, `
+		SELECT q.post_id, q.resolved_at IS NOT NULL, p.hidden_at IS NULL, COALESCE(q.resolved_at, q.created_at)
+		FROM moderation_queue q
+		JOIN posts p ON p.id = q.post_id
+		WHERE q.status_token_hash = $1
+	`, statusTokenHash).Scan(&status.PostID, &resolved, &visible, &status.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get appeal status: %w", err)
+	}
+
+	switch {
+	case !resolved:
+		status.Status = postStatusAppealPending
+	case visible:
+		status.Status = postStatusReinstated
+	default:
+		status.Status = postStatusAppealDenied
+	}
+	return &status, nil
+}
+
+// ResolveAppeal closes an open appeal, unhiding its post if granted, and
+// reports whether one existed.
+func (db *DB) ResolveAppeal(ctx context.Context, id int, granted bool, resolvedBy string) (bool, error) {
+	tx, err := db.begin(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to begin resolving appeal: %w", err)
+	}
+	defer tx.Rollback()
+
+	resolution := "denied"
+	if granted {
+		resolution = "granted"
+	}
+
+	var postID int
+	err = tx.QueryRowContext(ctx, `
+		UPDATE moderation_queue
+		SET resolved_at = NOW(), resolved_by = $2, resolution = $3
+		WHERE id = $1 AND reason = 'appeal' AND resolved_at IS NULL
+		RETURNING post_id
+	`, id, resolvedBy, resolution).Scan(&postID)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to resolve appeal: %w", err)
+	}
+
+	if granted {
+		_, err = tx.ExecContext(ctx, `
+			UPDATE posts SET hidden_at = NULL, hidden_by = NULL, removal_reason_id = NULL
+			WHERE id = $1
+		`, postID)
+		if err != nil {
+			return false, fmt.Errorf("failed to reinstate post: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit appeal resolution: %w", err)
+	}
+	return true, nil
+}
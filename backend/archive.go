@@ -0,0 +1,453 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"maps"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const archiveInterval = time.Hour
+
+// ArchivedBoard is the snapshot of a board kept in the archive: the event
+// and its visible posts, oldest first. Attachments aren't archived.
+type ArchivedBoard struct {
+	Event      Event     `json:"event"`
+	Posts      []Post    `json:"posts"`
+	ArchivedAt time.Time `json:"archived_at"`
+}
+
+// archiveKey is where an event's snapshot is stored, by event ID so it
+// survives renames. ext is ".json" or ".html".
+func archiveKey(eventID int, ext string) string {
+	return "events/" + strconv.Itoa(eventID) + ext
+}
+
+var archivePage = template.Must(template.New("archive").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Event.Name}} (archived)</title>
+<style>
+body { font-family: system-ui, sans-serif; max-width: 40rem; margin: 0 auto; padding: 1rem; color: #1f2937; }
+article { border-bottom: 1px solid #e5e7eb; padding: 0.75rem 0; }
+details summary { cursor: pointer; }
+.meta { color: #4b5563; font-size: 0.875rem; }
+p { white-space: pre-wrap; }
+</style>
+</head>
+<body>
+<h1>{{.Event.Name}}</h1>
+<p class="meta">This board was archived on {{.ArchivedAt.Format "2 January 2006"}} and is read-only. {{len .Posts}} posts.</p>
+{{range .Posts}}<article>
+<p class="meta"><time datetime="{{.CreatedAt.Format "2006-01-02T15:04:05Z07:00"}}">{{.CreatedAt.Format "2 Jan 2006 15:04"}}</time>{{if .Location}} · {{.Location}}{{end}}</p>
+{{if .ContentWarning}}<details><summary>{{.ContentWarning}}</summary><p>{{.Content}}</p></details>{{else}}<p>{{.Content}}</p>{{end}}
+</article>
+{{end}}</body>
+</html>
+`))
+
+// Archiver moves the boards of events that ended more than after ago out
+// of the database: it writes a JSON and a static HTML snapshot to the
+// archive store, then deletes the posts. Posts under legal hold stay in
+// the database as well.
+type Archiver struct {
+	db    *DB
+	store MediaStore
+	after time.Duration
+}
+
+// NewArchiver returns nil when there is no archive store, so archiving is
+// off.
+func NewArchiver(db *DB, store MediaStore, after time.Duration) *Archiver {
+	if store == nil {
+		return nil
+	}
+	return &Archiver{db: db, store: store, after: after}
+}
+
+// Run archives once at startup and then every archiveInterval until ctx is
+// cancelled.
+func (a *Archiver) Run(ctx context.Context) {
+	ticker := time.NewTicker(archiveInterval)
+	defer ticker.Stop()
+
+	for {
+		a.archiveEnded(ctx)
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (a *Archiver) archiveEnded(ctx context.Context) {
+	names, err := a.db.GetArchivableEvents(ctx, time.Now().Add(-a.after))
+	if err != nil {
+		log.Printf("Error listing events to archive: %v", err)
+		return
+	}
+
+	for _, name := range names {
+		if ctx.Err() != nil {
+			return
+		}
+		if err := a.archive(ctx, name); err != nil {
+			log.Printf("Error archiving event %s: %v", name, err)
+		}
+	}
+}
+
+func (a *Archiver) archive(ctx context.Context, name string) error {
+	event, err := a.db.GetEvent(ctx, name)
+	if err != nil || event == nil {
+		return err
+	}
+	posts, err := a.db.GetVisiblePosts(ctx, name)
+	if err != nil {
+		return err
+	}
+	if posts == nil {
+		posts = []Post{}
+	}
+
+	board := ArchivedBoard{Event: *event, Posts: posts, ArchivedAt: time.Now().UTC()}
+	board.Event.PostCount = len(posts)
+
+	data, err := json.Marshal(board)
+	if err != nil {
+		return fmt.Errorf("failed to encode archive: %w", err)
+	}
+	var page bytes.Buffer
+	if err := archivePage.Execute(&page, board); err != nil {
+		return fmt.Errorf("failed to render archive: %w", err)
+	}
+
+	// The snapshot must be stored before anything is deleted
+	if err := a.store.Put(ctx, archiveKey(event.ID, ".json"), data); err != nil {
+		return fmt.Errorf("failed to store archive: %w", err)
+	}
+	if err := a.store.Put(ctx, archiveKey(event.ID, ".html"), page.Bytes()); err != nil {
+		return fmt.Errorf("failed to store archive page: %w", err)
+	}
+
+	deleted, err := a.db.ArchiveEvent(ctx, name, board.ArchivedAt)
+	if err != nil {
+		return err
+	}
+
+	if err := a.db.RecordAudit(ctx, "archiver", "event.archive", "event", name, map[string]int{
+		"archived_posts": len(posts),
+		"deleted_posts":  deleted,
+	}); err != nil {
+		log.Printf("Error recording archive of event %s: %v", name, err)
+	}
+	log.Printf("Archive: archived %d posts of event %s, deleted %d", len(posts), name, deleted)
+	return nil
+}
+
+// ArchiveReader reads archived boards, keeping the most recently loaded
+// snapshots in memory so archived listings don't refetch them.
+type ArchiveReader struct {
+	store     MediaStore
+	maxBoards int
+
+	mu     sync.Mutex
+	boards map[int]*ArchivedBoard
+	// loaded is the cached event IDs, least recently used first
+	loaded []int
+}
+
+// NewArchiveReader returns nil when there is no archive store.
+func NewArchiveReader(store MediaStore, maxBoards int) *ArchiveReader {
+	if store == nil {
+		return nil
+	}
+	return &ArchiveReader{store: store, maxBoards: max(1, maxBoards), boards: make(map[int]*ArchivedBoard)}
+}
+
+// Board returns an event's archived snapshot. Snapshots never change, so
+// a cached one is always current.
+func (a *ArchiveReader) Board(ctx context.Context, eventID int) (*ArchivedBoard, error) {
+	a.mu.Lock()
+	board, ok := a.boards[eventID]
+	if ok {
+		a.touch(eventID)
+	}
+	a.mu.Unlock()
+	if ok {
+		return board, nil
+	}
+
+	data, err := a.store.Get(ctx, archiveKey(eventID, ".json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read archive: %w", err)
+	}
+	board = &ArchivedBoard{}
+	if err := json.Unmarshal(data, board); err != nil {
+		return nil, fmt.Errorf("failed to decode archive: %w", err)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, ok := a.boards[eventID]; !ok && len(a.loaded) >= a.maxBoards {
+		delete(a.boards, a.loaded[0])
+		a.loaded = a.loaded[1:]
+	}
+	a.boards[eventID] = board
+	a.touch(eventID)
+	return board, nil
+}
+
+// touch moves eventID to the most recently used end of loaded.
+func (a *ArchiveReader) touch(eventID int) {
+	for i, id := range a.loaded {
+		if id == eventID {
+			a.loaded = append(a.loaded[:i], a.loaded[i+1:]...)
+			break
+		}
+	}
+	a.loaded = append(a.loaded, eventID)
+}
+
+// matches applies a post filter the way GetPosts does, for posts read
+// from an archive, which are never hidden.
+func (f PostFilter) matches(p Post) bool {
+	if f.Event != "" && p.EventName != f.Event {
+		return false
+	}
+	if f.Session != 0 && (p.SessionID == nil || *p.SessionID != f.Session) {
+		return false
+	}
+	if !f.IncludeSensitive && p.ContentWarning != "" {
+		return false
+	}
+	if f.MaxID != 0 && p.ID > f.MaxID {
+		return false
+	}
+	// Snapshots don't keep translations, so every post's language is
+	// unknown and Languages leaves them all in
+	if len(f.Keywords) > 0 && !mentionsKeyword(p.Content, f.Keywords) {
+		return false
+	}
+	for name, value := range f.Fields {
+		if p.CustomFields[name] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// archivedPosts returns a page of an archived event's posts matching
+// filter, in the order GetPosts would list them, each marked archived.
+func (a *ArchiveReader) archivedPosts(ctx context.Context, eventID int, filter PostFilter, limit, offset int) ([]Post, error) {
+	board, err := a.Board(ctx, eventID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Snapshots are oldest first
+	var matching []Post
+	for i := len(board.Posts) - 1; i >= 0; i-- {
+		if filter.matches(board.Posts[i]) {
+			matching = append(matching, board.Posts[i])
+		}
+	}
+	if filter.ShuffleSeed != "" {
+		keys := make(map[int]string, len(matching))
+		for _, post := range matching {
+			keys[post.ID] = shuffleKey(filter.ShuffleSeed, post.ID)
+		}
+		sort.Slice(matching, func(i, j int) bool {
+			ki, kj := keys[matching[i].ID], keys[matching[j].ID]
+			if ki != kj {
+				return ki < kj
+			}
+			return matching[i].ID < matching[j].ID
+		})
+	}
+
+	posts := []Post{}
+	for i := offset; i < len(matching) && len(posts) < limit; i++ {
+		// Copy the fields map so redacting the result can't change the cache
+		post := matching[i]
+		post.CustomFields = maps.Clone(post.CustomFields)
+		post.Archived = true
+		posts = append(posts, post)
+	}
+	return posts, nil
+}
+
+// getArchivedPosts serves GET /api/posts for an archived event from its
+// snapshot, redacted by the event's current settings.
+func (h *Handler) getArchivedPosts(w http.ResponseWriter, r *http.Request, event *Event, filter PostFilter, projection PostProjection, limit, offset int) {
+	posts, err := h.cfg.Archive.archivedPosts(r.Context(), event.ID, filter, limit, offset)
+	if err != nil {
+		log.Printf("Error reading archive of event %s: %v", event.Name, err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve posts")
+		return
+	}
+
+	loc := event.location()
+	for i := range posts {
+		event.EventSettings.redactPost(&posts[i])
+		localizePost(&posts[i], loc)
+	}
+
+	respondWithJSON(w, http.StatusOK, projection.Apply(posts))
+}
+
+// GetEventArchive handles GET /api/events/{event}/archive, the JSON
+// snapshot of an archived board.
+func (h *Handler) GetEventArchive(w http.ResponseWriter, r *http.Request) {
+	h.serveArchive(w, r, ".json", "application/json")
+}
+
+// GetEventArchivePage handles GET /archive/{event}, the read-only HTML
+// view of an archived board.
+func (h *Handler) GetEventArchivePage(w http.ResponseWriter, r *http.Request) {
+	h.serveArchive(w, r, ".html", "text/html; charset=utf-8")
+}
+
+func (h *Handler) serveArchive(w http.ResponseWriter, r *http.Request, ext, contentType string) {
+	event, err := h.db.GetEvent(r.Context(), r.PathValue("event"))
+	if err != nil {
+		log.Printf("Error getting event: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve archive")
+		return
+	}
+	if event == nil || event.ArchivedAt == nil || h.cfg.Archive == nil {
+		respondWithError(w, http.StatusNotFound, "Event is not archived")
+		return
+	}
+
+	data, err := h.cfg.Archive.store.Get(r.Context(), archiveKey(event.ID, ext))
+	if err != nil {
+		log.Printf("Error reading archive of event %s: %v", event.Name, err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve archive")
+		return
+	}
+
+	// Archives never change
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	http.ServeContent(w, r, "", *event.ArchivedAt, bytes.NewReader(data))
+}
+
+// GetArchivableEvents returns the unarchived events that ended before
+// cutoff, oldest first.
+func (db *DB) GetArchivableEvents(ctx context.Context, cutoff time.Time) ([]string, error) {
+	rows, err := db.conn.QueryContext(ctx, `
+		SELECT name FROM events
+		WHERE archived_at IS NULL AND ends_at < $1
+		ORDER BY ends_at
+	`, cutoff)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query archivable events: %w", err)
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("failed to scan event name: %w", err)
+		}
+		names = append(names, name)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating archivable events: %w", err)
+	}
+
+	return names, nil
+}
+
+// GetVisiblePosts returns all of an event's posts that aren't hidden,
+// oldest first.
+func (db *DB) GetVisiblePosts(ctx context.Context, eventName string) ([]Post, error) {
+	rows, err := db.conn.QueryContext(ctx, `
+		SELECT `+postColumns+`
+		FROM posts
+		WHERE event_name = $1 AND hidden_at IS NULL
+		ORDER BY created_at, id
+	`, eventName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query posts: %w", err)
+	}
+	defer rows.Close()
+
+	var posts []Post
+	for rows.Next() {
+		post, err := scanPost(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan post: %w", err)
+		}
+		posts = append(posts, *post)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating posts: %w", err)
+	}
+
+	return posts, nil
+}
+
+// ArchiveEvent marks an event archived and deletes its posts, except those
+// under legal hold, returning how many were deleted.
+func (db *DB) ArchiveEvent(ctx context.Context, name string, archivedAt time.Time) (int, error) {
+	tx, err := db.begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin archiving event: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "UPDATE events SET archived_at = $2 WHERE name = $1", name, archivedAt); err != nil {
+		return 0, fmt.Errorf("failed to mark event archived: %w", err)
+	}
+
+	result, err := tx.ExecContext(ctx, `
+		DELETE FROM posts p
+		WHERE p.event_name = $1
+		AND `+notUnderLegalHold, name)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete archived posts: %w", err)
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete archived posts: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit event archive: %w", err)
+	}
+	return int(deleted), nil
+}
+
+// IsEventArchived reports whether an event's board has been archived, and
+// so takes no new posts.
+func (db *DB) IsEventArchived(ctx context.Context, name string) (bool, error) {
+	var archived bool
+	err := db.conn.QueryRowContext(ctx,
+		"SELECT archived_at IS NOT NULL FROM events WHERE name = $1", name,
+	).Scan(&archived)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to check event archive: %w", err)
+	}
+	return archived, nil
+}
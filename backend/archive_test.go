@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestArchivePage(t *testing.T) {
+	board := ArchivedBoard{
+		Event: Event{Name: "Glastonbury"},
+		Posts: []Post{
+			{Content: "Blue hat <script>alert(1)</script>", Location: "Pyramid stage"},
+			{Content: "Spoilers", ContentWarning: "ending of the film"},
+		},
+		ArchivedAt: time.Date(2026, 7, 1, 0, 0, 0, 0, time.UTC),
+	}
+
+	var page bytes.Buffer
+	if err := archivePage.Execute(&page, board); err != nil {
+		t.Fatal(err)
+	}
+	html := page.String()
+	for _, want := range []string{"archived on 1 July 2026", "2 posts", "&lt;script&gt;", "<summary>ending of the film</summary>"} {
+		if !strings.Contains(html, want) {
+			t.Errorf("page is missing %q", want)
+		}
+	}
+	if strings.Contains(html, "<script>") {
+		t.Error("post content is not escaped")
+	}
+}
+
+func TestGetEventArchive(t *testing.T) {
+	archivedAt := time.Now()
+	archive, err := NewLocalMediaStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive.Put(context.Background(), archiveKey(7, ".json"), []byte(`{"posts":[]}`))
+	reader := NewArchiveReader(archive, 2)
+
+	tests := []struct {
+		name   string
+		event  *Event
+		cfg    HandlerConfig
+		status int
+	}{
+		{name: "archived", event: &Event{ID: 7, ArchivedAt: &archivedAt}, cfg: HandlerConfig{Archive: reader}, status: 200},
+		{name: "not archived", event: &Event{ID: 7}, cfg: HandlerConfig{Archive: reader}, status: 404},
+		{name: "no event", cfg: HandlerConfig{Archive: reader}, status: 404},
+		{name: "archiving off", event: &Event{ID: 7, ArchivedAt: &archivedAt}, status: 404},
+		{name: "snapshot missing", event: &Event{ID: 8, ArchivedAt: &archivedAt}, cfg: HandlerConfig{Archive: reader}, status: 500},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newFakeStore()
+			store.event = tt.event
+			h := newTestHandler(store, tt.cfg)
+
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/api/events/glastonbury/archive", nil)
+			req.SetPathValue("event", "Glastonbury")
+			h.GetEventArchive(rec, req)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d (body %s)", rec.Code, tt.status, rec.Body)
+			}
+			if tt.status == 200 && rec.Body.String() != `{"posts":[]}` {
+				t.Errorf("body = %s", rec.Body)
+			}
+		})
+	}
+}
+
+func TestGetPostsArchived(t *testing.T) {
+	archive, err := NewLocalMediaStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	session := 3
+	board := ArchivedBoard{Posts: []Post{
+		{ID: 1, EventName: "Glastonbury", Content: "first", Age: new(int)},
+		{ID: 2, EventName: "Glastonbury", Content: "second", SessionID: &session},
+		{ID: 3, EventName: "Glastonbury", Content: "third", ContentWarning: "spoilers"},
+		{ID: 4, EventName: "Glastonbury", Content: "fourth", CustomFields: CustomFieldValues{"stage": "Pyramid"}},
+	}}
+	data, _ := json.Marshal(board)
+	archive.Put(context.Background(), archiveKey(7, ".json"), data)
+
+	archivedAt := time.Now()
+	schema := CustomFieldSchema{{Name: "stage", Type: "text"}}
+
+	tests := []struct {
+		name  string
+		query string
+		want  []int
+	}{
+		{name: "newest first", query: "", want: []int{4, 2, 1}},
+		{name: "sensitive", query: "&include_sensitive=true", want: []int{4, 3, 2, 1}},
+		{name: "paged", query: "&limit=1&offset=1", want: []int{2}},
+		{name: "session", query: "&session=3", want: []int{2}},
+		{name: "field", query: "&field.stage=Pyramid", want: []int{4}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newFakeStore()
+			store.event = &Event{ID: 7, Name: "Glastonbury", ArchivedAt: &archivedAt}
+			store.event.CustomFields = schema
+			store.settings["Glastonbury"] = EventSettings{CustomFields: schema}
+			h := newTestHandler(store, HandlerConfig{Archive: NewArchiveReader(archive, 1)})
+
+			rec := httptest.NewRecorder()
+			h.GetPosts(rec, httptest.NewRequest(http.MethodGet, "/api/posts?event=Glastonbury"+tt.query, nil))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d (body %s)", rec.Code, rec.Body)
+			}
+
+			var posts []Post
+			json.Unmarshal(rec.Body.Bytes(), &posts)
+			var got []int
+			for _, p := range posts {
+				got = append(got, p.ID)
+				if !p.Archived {
+					t.Errorf("post %d is not marked archived", p.ID)
+				}
+				if p.Age != nil {
+					t.Errorf("post %d has an age the event doesn't collect", p.ID)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("posts = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestArchiveReaderCache(t *testing.T) {
+	archive, err := NewLocalMediaStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	for _, id := range []int{1, 2, 3} {
+		archive.Put(ctx, archiveKey(id, ".json"), []byte(`{"posts":[]}`))
+	}
+
+	reader := NewArchiveReader(archive, 2)
+	for _, id := range []int{1, 2, 1, 3} {
+		if _, err := reader.Board(ctx, id); err != nil {
+			t.Fatalf("Board(%d): %v", id, err)
+		}
+	}
+	// 2 was least recently used when 3 was loaded
+	if !reflect.DeepEqual(reader.loaded, []int{1, 3}) {
+		t.Errorf("cached boards = %v, want [1 3]", reader.loaded)
+	}
+
+	// Cached boards are served without the store
+	archive.Delete(ctx, archiveKey(1, ".json"))
+	if _, err := reader.Board(ctx, 1); err != nil {
+		t.Errorf("cached Board(1): %v", err)
+	}
+}
+
+func TestArchivedPostsShuffle(t *testing.T) {
+	archive, err := NewLocalMediaStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var board ArchivedBoard
+	for id := 20; id >= 1; id-- {
+		board.Posts = append(board.Posts, Post{ID: id, EventName: "Glastonbury"})
+	}
+	data, _ := json.Marshal(board)
+	archive.Put(context.Background(), archiveKey(1, ".json"), data)
+	reader := NewArchiveReader(archive, 1)
+
+	page := func(seed string, limit, offset int) []int {
+		posts, err := reader.archivedPosts(context.Background(), 1, PostFilter{ShuffleSeed: seed}, limit, offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []int
+		for _, post := range posts {
+			ids = append(ids, post.ID)
+		}
+		return ids
+	}
+
+	all := page("a", 20, 0)
+	if !reflect.DeepEqual(all, page("a", 20, 0)) {
+		t.Error("the same seed gave different orders")
+	}
+	if reflect.DeepEqual(all, page("b", 20, 0)) {
+		t.Error("different seeds gave the same order")
+	}
+	seen := make(map[int]bool)
+	for _, id := range all {
+		seen[id] = true
+	}
+	if len(all) != 20 || len(seen) != 20 {
+		t.Errorf("shuffle returned %v, want every post once", all)
+	}
+	if got := append(page("a", 7, 0), page("a", 13, 7)...); !reflect.DeepEqual(got, all) {
+		t.Errorf("pages = %v, want %v", got, all)
+	}
+	for i := 1; i < len(all); i++ {
+		if shuffleKey("a", all[i-1]) > shuffleKey("a", all[i]) {
+			t.Errorf("posts %d and %d out of shuffle order", all[i-1], all[i])
+		}
+	}
+}
@@ -0,0 +1,524 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	maxAttachmentsPerPost = 4
+	maxImageDimension     = 8000
+	maxAltTextLength      = 1000
+	// Uploads that are never attached to a post are removed after this long
+	orphanAttachmentAge  = 24 * time.Hour
+	mediaJanitorInterval = time.Hour
+)
+
+// imageContentTypes maps the image formats accepted for upload to their
+// file extension.
+var imageContentTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+}
+
+// fileNameAltText catches alt text that is just the uploaded file's name.
+var fileNameAltText = regexp.MustCompile(`(?i)^[\w\-. ]+\.(jpe?g|png|gif|heic|webp)$`)
+
+// Attachment is an image uploaded with a post.
+type Attachment struct {
+	ID          int    `json:"id"`
+	URL         string `json:"url"`
+	ContentType string `json:"content_type"`
+	Width       int    `json:"width"`
+	Height      int    `json:"height"`
+	Size        int    `json:"size"`
+	AltText     string `json:"alt_text,omitempty"`
+	// AltTextGenerated is set when the alt text came from the captioning
+	// backfill rather than the poster.
+	AltTextGenerated bool      `json:"alt_text_generated,omitempty"`
+	CreatedAt        time.Time `json:"created_at"`
+	// Renditions are smaller copies, smallest first, and Srcset offers
+	// them with the original for an img srcset attribute
+	Renditions []Rendition `json:"renditions,omitempty"`
+	Srcset     string      `json:"srcset,omitempty"`
+
+	postID     *int
+	storageKey string
+	// bannedImageID is set when the image resembles a banned one
+	bannedImageID *int
+	scanStatus    string
+}
+
+// MediaStore holds uploaded files.
+type MediaStore interface {
+	Put(ctx context.Context, key string, data []byte) error
+	Get(ctx context.Context, key string) ([]byte, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// LocalMediaStore keeps files in a directory on local disk.
+type LocalMediaStore struct {
+	dir string
+}
+
+func NewLocalMediaStore(dir string) (*LocalMediaStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create media directory: %w", err)
+	}
+	return &LocalMediaStore{dir: dir}, nil
+}
+
+func (s *LocalMediaStore) path(key string) string {
+	return filepath.Join(s.dir, filepath.Base(key))
+}
+
+func (s *LocalMediaStore) Put(ctx context.Context, key string, data []byte) error {
+	return os.WriteFile(s.path(key), data, 0o644)
+}
+
+func (s *LocalMediaStore) Get(ctx context.Context, key string) ([]byte, error) {
+	return os.ReadFile(s.path(key))
+}
+
+func (s *LocalMediaStore) Delete(ctx context.Context, key string) error {
+	err := os.Remove(s.path(key))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// UploadAttachment handles POST /api/attachments, a multipart form with a
+// "file" image and optional "alt_text". The returned ID is passed in
+// attachment_ids when creating the post, from the same client.
+func (h *Handler) UploadAttachment(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, int64(h.cfg.MaxUploadBytes)+1<<20)
+	if err := r.ParseMultipartForm(int64(h.cfg.MaxUploadBytes)); err != nil {
+		respondWithError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Upload must be a multipart form of at most %d bytes", h.cfg.MaxUploadBytes))
+		return
+	}
+
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "file is required")
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, int64(h.cfg.MaxUploadBytes)+1))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Failed to read upload")
+		return
+	}
+	if len(data) > h.cfg.MaxUploadBytes {
+		respondWithError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("file must be %d bytes or less", h.cfg.MaxUploadBytes))
+		return
+	}
+
+	// Sniff the type ourselves rather than trusting the client
+	contentType := http.DetectContentType(data)
+	ext, ok := imageContentTypes[contentType]
+	if !ok {
+		respondWithError(w, http.StatusUnsupportedMediaType, "file must be a JPEG, PNG or GIF image")
+		return
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "file is not a valid image")
+		return
+	}
+	if cfg.Width > maxImageDimension || cfg.Height > maxImageDimension {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("image must be at most %dx%d pixels", maxImageDimension, maxImageDimension))
+		return
+	}
+
+	altText, err := normalizeAltText(r.FormValue("alt_text"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	bannedImageID, err := h.screenImage(r.Context(), data)
+	var validationErr *ValidationError
+	var bannedErr *BannedError
+	switch {
+	case errors.As(err, &validationErr):
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	case errors.As(err, &bannedErr):
+		respondWithError(w, http.StatusForbidden, "This image isn't allowed here.")
+		return
+	case err != nil:
+		log.Printf("Error screening upload: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to store upload")
+		return
+	}
+
+	attachment := Attachment{
+		ContentType: contentType,
+		Width:       cfg.Width,
+		Height:      cfg.Height,
+		Size:        len(data),
+		AltText:     altText,
+		storageKey:  randomToken(16) + ext,
+
+		bannedImageID: bannedImageID,
+		scanStatus:    scanClean,
+	}
+	if h.cfg.ScanUploads {
+		attachment.scanStatus = scanPending
+	}
+
+	if err := h.cfg.Media.Put(r.Context(), attachment.storageKey, data); err != nil {
+		log.Printf("Error storing upload: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to store upload")
+		return
+	}
+
+	saved, err := h.db.CreateAttachment(r.Context(), attachment, hashIP(getIP(r)))
+	if err != nil {
+		log.Printf("Error creating attachment: %v", err)
+		h.cfg.Media.Delete(r.Context(), attachment.storageKey)
+		respondWithError(w, http.StatusInternalServerError, "Failed to store upload")
+		return
+	}
+
+	h.signAttachments([]Attachment{*saved})
+	respondWithJSON(w, http.StatusCreated, saved)
+}
+
+// ServeMedia handles GET /media/{key}. Only files attached to a live post
+// are served, so deleting a post takes its images down with it, and only
+// once they have passed any virus scan. When media URLs are signed, the
+// URL must be unexpired and is cached no longer than it is valid.
+func (h *Handler) ServeMedia(w http.ResponseWriter, r *http.Request) {
+	cacheControl := "public, max-age=3600"
+	if h.cfg.MediaURLs != nil {
+		expires, ok := h.cfg.MediaURLs.Verify(r.PathValue("key"), r.URL.Query(), time.Now())
+		if !ok {
+			http.Error(w, "Media link is invalid or has expired", http.StatusForbidden)
+			return
+		}
+		cacheControl = fmt.Sprintf("private, max-age=%d", int(time.Until(expires).Seconds()))
+	}
+
+	attachment, err := h.db.GetAttachmentByKey(r.Context(), r.PathValue("key"))
+	if err == nil && attachment == nil {
+		attachment, err = h.db.GetRenditionByKey(r.Context(), r.PathValue("key"))
+	}
+	if err != nil {
+		log.Printf("Error getting attachment: %v", err)
+		http.Error(w, "Failed to load media", http.StatusInternalServerError)
+		return
+	}
+	if attachment == nil || attachment.postID == nil || attachment.scanStatus != scanClean {
+		http.NotFound(w, r)
+		return
+	}
+
+	data, err := h.cfg.Media.Get(r.Context(), attachment.storageKey)
+	if err != nil {
+		log.Printf("Error reading media %s: %v", attachment.storageKey, err)
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", attachment.ContentType)
+	w.Header().Set("Cache-Control", cacheControl)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	http.ServeContent(w, r, attachment.storageKey, attachment.CreatedAt, bytes.NewReader(data))
+}
+
+// normalizeAltText trims alt text and rejects values that describe nothing.
+func normalizeAltText(altText string) (string, error) {
+	altText = strings.Join(strings.Fields(altText), " ")
+	if len(altText) > maxAltTextLength {
+		return "", &ValidationError{Message: fmt.Sprintf("alt_text must be %d characters or less", maxAltTextLength)}
+	}
+	if fileNameAltText.MatchString(altText) {
+		return "", &ValidationError{Message: "alt_text should describe the image, not its file name"}
+	}
+	return altText, nil
+}
+
+// checkPostAttachments validates the attachments named on a new post: they
+// must exist, be unattached, have been uploaded by the same client and, if
+// the deployment requires it, carry alt text. It returns the attachments.
+func (h *Handler) checkPostAttachments(ctx context.Context, req CreatePostRequest, ipHash string) ([]Attachment, error) {
+	if len(req.AttachmentIDs) == 0 {
+		return nil, nil
+	}
+	if len(req.AttachmentIDs) > maxAttachmentsPerPost {
+		return nil, &ValidationError{Message: fmt.Sprintf("at most %d attachments are allowed", maxAttachmentsPerPost), Rule: "attachments.max_count"}
+	}
+
+	attachments, err := h.db.GetPendingAttachments(ctx, req.AttachmentIDs, ipHash)
+	if err != nil {
+		return nil, err
+	}
+	if len(attachments) != len(req.AttachmentIDs) {
+		return nil, &ValidationError{Message: "attachment_ids must be your own unused uploads", Rule: "attachments.not_owned"}
+	}
+
+	if h.cfg.RequireAltText {
+		for _, a := range attachments {
+			if a.AltText == "" {
+				return nil, &ValidationError{Message: "every image needs alt_text describing it", Rule: "attachments.alt_text_required"}
+			}
+		}
+	}
+
+	return attachments, nil
+}
+
+// loadAttachments fills in the attachments of each post.
+func (h *Handler) loadAttachments(ctx context.Context, posts []Post) error {
+	ids := make([]int, 0, len(posts))
+	for _, post := range posts {
+		ids = append(ids, post.ID)
+	}
+
+	byPost, err := h.db.GetAttachmentsForPosts(ctx, ids)
+	if err != nil {
+		return err
+	}
+
+	for i := range posts {
+		posts[i].Attachments = byPost[posts[i].ID]
+		h.signAttachments(posts[i].Attachments)
+		for j := range posts[i].Attachments {
+			posts[i].Attachments[j].Srcset = srcset(posts[i].Attachments[j])
+		}
+	}
+	return nil
+}
+
+// MediaJanitor deletes orphaned attachments and their files.
+type MediaJanitor struct {
+	db    *DB
+	media MediaStore
+}
+
+func NewMediaJanitor(db *DB, media MediaStore) *MediaJanitor {
+	return &MediaJanitor{db: db, media: media}
+}
+
+// Run cleans up every mediaJanitorInterval until ctx is cancelled.
+func (j *MediaJanitor) Run(ctx context.Context) {
+	ticker := time.NewTicker(mediaJanitorInterval)
+	defer ticker.Stop()
+
+	for {
+		j.clean(ctx)
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (j *MediaJanitor) clean(ctx context.Context) {
+	keys, err := j.db.DeleteOrphanAttachments(ctx, time.Now().Add(-orphanAttachmentAge))
+	if err != nil {
+		log.Printf("Error deleting orphaned attachments: %v", err)
+		return
+	}
+	for _, key := range keys {
+		if err := j.media.Delete(ctx, key); err != nil {
+			log.Printf("Error deleting media %s: %v", key, err)
+		}
+	}
+	if len(keys) > 0 {
+		log.Printf("Media: deleted %d orphaned attachments", len(keys))
+	}
+}
+
+const attachmentColumns = `id, post_id, storage_key, content_type, width, height, size, COALESCE(alt_text, ''), alt_text_generated, created_at, banned_image_id, scan_status`
+
+func scanAttachment(row rowScanner) (*Attachment, error) {
+	var a Attachment
+	err := row.Scan(&a.ID, &a.postID, &a.storageKey, &a.ContentType, &a.Width, &a.Height, &a.Size, &a.AltText, &a.AltTextGenerated, &a.CreatedAt, &a.bannedImageID, &a.scanStatus)
+	if err != nil {
+		return nil, err
+	}
+	a.URL = "/media/" + a.storageKey
+	return &a, nil
+}
+
+func (db *DB) queryAttachments(ctx context.Context, query string, args ...interface{}) ([]Attachment, error) {
+	rows, err := db.reader(ctx).QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query attachments: %w", err)
+	}
+	defer rows.Close()
+
+	var attachments []Attachment
+	for rows.Next() {
+		a, err := scanAttachment(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan attachment: %w", err)
+		}
+		attachments = append(attachments, *a)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating attachments: %w", err)
+	}
+
+	return attachments, nil
+}
+
+func (db *DB) CreateAttachment(ctx context.Context, a Attachment, ipHash string) (*Attachment, error) {
+	query := `
+		INSERT INTO attachments (storage_key, content_type, width, height, size, alt_text, uploader_ip_hash, banned_image_id, scan_status)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), $7, $8, $9)
+		RETURNING ` + attachmentColumns
+
+	saved, err := scanAttachment(db.conn.QueryRowContext(ctx, query,
+		a.storageKey, a.ContentType, a.Width, a.Height, a.Size, a.AltText, ipHash, a.bannedImageID, a.scanStatus,
+	))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create attachment: %w", err)
+	}
+	return saved, nil
+}
+
+// GetAttachmentByKey returns the attachment stored under key, or nil
+func (db *DB) GetAttachmentByKey(ctx context.Context, key string) (*Attachment, error) {
+	query := `SELECT ` + attachmentColumns + ` FROM attachments WHERE storage_key = $1`
+
+	a, err := scanAttachment(db.conn.QueryRowContext(ctx, query, key))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get attachment: %w", err)
+	}
+	return a, nil
+}
+
+// GetAttachmentByID returns an attachment, or nil
+func (db *DB) GetAttachmentByID(ctx context.Context, id int) (*Attachment, error) {
+	query := `SELECT ` + attachmentColumns + ` FROM attachments WHERE id = $1`
+
+	a, err := scanAttachment(db.conn.QueryRowContext(ctx, query, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get attachment: %w", err)
+	}
+	return a, nil
+}
+
+// GetPendingAttachments returns those of the given uploads that were made
+// from ipHash, aren't attached to a post yet and haven't failed a virus scan.
+func (db *DB) GetPendingAttachments(ctx context.Context, ids []int, ipHash string) ([]Attachment, error) {
+	return db.queryAttachments(ctx, `
+		SELECT `+attachmentColumns+`
+		FROM attachments
+		WHERE id = ANY($1) AND uploader_ip_hash = $2 AND attached_at IS NULL
+		AND scan_status IN ('pending', 'clean')
+	`, ids, ipHash)
+}
+
+// AttachToPost links uploads to a newly created post.
+func (db *DB) AttachToPost(ctx context.Context, postID int, ids []int) error {
+	_, err := db.conn.ExecContext(ctx,
+		"UPDATE attachments SET post_id = $1, attached_at = NOW() WHERE id = ANY($2) AND attached_at IS NULL",
+		postID, ids,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to attach uploads: %w", err)
+	}
+	return nil
+}
+
+// GetAttachmentsForPosts returns the attachments of the given posts that
+// have passed any virus scan, keyed by post ID.
+func (db *DB) GetAttachmentsForPosts(ctx context.Context, postIDs []int) (map[int][]Attachment, error) {
+	byPost := make(map[int][]Attachment)
+	if len(postIDs) == 0 {
+		return byPost, nil
+	}
+
+	attachments, err := db.queryAttachments(ctx, `
+		SELECT `+attachmentColumns+`
+		FROM attachments
+		WHERE post_id = ANY($1) AND scan_status = 'clean'
+		ORDER BY id
+	`, postIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]int, 0, len(attachments))
+	for _, a := range attachments {
+		ids = append(ids, a.ID)
+	}
+	renditions, err := db.getRenditions(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, a := range attachments {
+		a.Renditions = renditions[a.ID]
+		byPost[*a.postID] = append(byPost[*a.postID], a)
+	}
+	return byPost, nil
+}
+
+// DeleteOrphanAttachments removes attachments whose post has been deleted,
+// and uploads created before the cutoff that were never attached to a post.
+// Quarantined uploads are kept. It returns their storage keys and those
+// of their renditions.
+func (db *DB) DeleteOrphanAttachments(ctx context.Context, before time.Time) ([]string, error) {
+	rows, err := db.conn.QueryContext(ctx, `
+		WITH deleted AS (
+			DELETE FROM attachments
+			WHERE post_id IS NULL AND (attached_at IS NOT NULL OR created_at < $1)
+			AND scan_status <> 'quarantined'
+			RETURNING id, storage_key
+		)
+		SELECT storage_key FROM deleted
+		UNION ALL
+		SELECT r.storage_key FROM attachment_renditions r JOIN deleted d ON d.id = r.attachment_id
+	`, before)
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete orphaned attachments: %w", err)
+	}
+	defer rows.Close()
+
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, fmt.Errorf("failed to scan attachment key: %w", err)
+		}
+		keys = append(keys, key)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating attachment keys: %w", err)
+	}
+
+	return keys, nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// adminActorHeader lets admins identify themselves in the audit log, since
+// the shared admin token doesn't. Admin API keys are identified by name.
+const adminActorHeader = "X-Admin-Actor"
+
+type AuditEntry struct {
+	ID          int             `json:"id"`
+	Actor       string          `json:"actor"`
+	Action      string          `json:"action"`
+	TargetType  string          `json:"target_type,omitempty"`
+	TargetValue string          `json:"target_value,omitempty"`
+	Details     json.RawMessage `json:"details,omitempty"`
+	CreatedAt   time.Time       `json:"created_at"`
+}
+
+func adminActor(r *http.Request) string {
+	if creds := apiKeyFromContext(r.Context()); creds != nil && creds.admin {
+		return "api_key:" + creds.name
+	}
+	actor := strings.TrimSpace(r.Header.Get(adminActorHeader))
+	if actor == "" {
+		return "admin"
+	}
+	if len(actor) > 200 {
+		actor = actor[:200]
+	}
+	return actor
+}
+
+// audit records an admin action. Failures are logged rather than returned:
+// the action itself has already happened.
+func (h *Handler) audit(r *http.Request, action, targetType, targetValue string, details interface{}) {
+	if err := h.db.RecordAudit(r.Context(), adminActor(r), action, targetType, targetValue, details); err != nil {
+		log.Printf("Error recording audit entry for %s: %v", action, err)
+	}
+}
+
+// auditTx records an admin action in the action's own transaction, so the
+// entry exists exactly when the action does.
+func auditTx(tx Store, r *http.Request, action, targetType, targetValue string, details interface{}) error {
+	return tx.RecordAudit(r.Context(), adminActor(r), action, targetType, targetValue, details)
+}
+
+// GetAuditLog handles GET /admin/audit-log
+func (h *Handler) GetAuditLog(w http.ResponseWriter, r *http.Request) {
+	limit, offset := parsePagination(r)
+
+	entries, err := h.db.GetAuditLog(r.Context(), limit, offset)
+	if err != nil {
+		log.Printf("Error getting audit log: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve audit log")
+		return
+	}
+
+	if entries == nil {
+		entries = []AuditEntry{}
+	}
+
+	respondWithJSON(w, http.StatusOK, entries)
+}
+
+func (db *DB) RecordAudit(ctx context.Context, actor, action, targetType, targetValue string, details interface{}) error {
+	var detailsJSON []byte
+	if details != nil {
+		var err error
+		detailsJSON, err = json.Marshal(details)
+		if err != nil {
+			return fmt.Errorf("failed to encode audit details: %w", err)
+		}
+	}
+
+	_, err := db.conn.ExecContext(ctx, `
+		INSERT INTO admin_audit_log (actor, action, target_type, target_value, details)
+		VALUES ($1, $2, NULLIF($3, ''), NULLIF($4, ''), $5)
+	`, actor, action, targetType, targetValue, detailsJSON)
+	if err != nil {
+		return fmt.Errorf("failed to record audit entry: %w", err)
+	}
+	return nil
+}
+
+func (db *DB) GetAuditLog(ctx context.Context, limit, offset int) ([]AuditEntry, error) {
+	rows, err := db.conn.QueryContext(ctx, `
+		SELECT id, actor, action, COALESCE(target_type, ''), COALESCE(target_value, ''), details, created_at
+		FROM admin_audit_log
+		ORDER BY created_at DESC, id DESC
+		LIMIT $1 OFFSET $2
+	`, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query audit log: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []AuditEntry
+	for rows.Next() {
+		var entry AuditEntry
+		var details []byte
+		if err := rows.Scan(&entry.ID, &entry.Actor, &entry.Action, &entry.TargetType, &entry.TargetValue, &details, &entry.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan audit entry: %w", err)
+		}
+		entry.Details = details
+		entries = append(entries, entry)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating audit log: %w", err)
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// hashPattern matches the IP and phone hashes posts are recorded with.
+var hashPattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// AuthorBan refuses posts from an IP hash, or a phone hash for SMS posts.
+type AuthorBan struct {
+	IPHash    string    `json:"ip_hash"`
+	BannedBy  string    `json:"banned_by"`
+	Reason    string    `json:"reason,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type CreateAuthorBanRequest struct {
+	IPHash string `json:"ip_hash"`
+	Reason string `json:"reason"`
+}
+
+// GetAuthorBans handles GET /admin/bans
+func (h *Handler) GetAuthorBans(w http.ResponseWriter, r *http.Request) {
+	limit, offset := parsePagination(r)
+
+	bans, err := h.db.GetAuthorBans(r.Context(), limit, offset)
+	if err != nil {
+		log.Printf("Error getting author bans: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve bans")
+		return
+	}
+
+	if bans == nil {
+		bans = []AuthorBan{}
+	}
+
+	respondWithJSON(w, http.StatusOK, bans)
+}
+
+// CreateAuthorBan handles POST /admin/bans, banning a hash directly rather
+// than through the posts it wrote.
+func (h *Handler) CreateAuthorBan(w http.ResponseWriter, r *http.Request) {
+	var req CreateAuthorBanRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	req.IPHash = strings.ToLower(strings.TrimSpace(req.IPHash))
+	req.Reason = strings.TrimSpace(req.Reason)
+
+	if !hashPattern.MatchString(req.IPHash) {
+		respondWithError(w, http.StatusBadRequest, "ip_hash must be 64 hex characters")
+		return
+	}
+	if len(req.Reason) > 1000 {
+		respondWithError(w, http.StatusBadRequest, "reason must be 1000 characters or less")
+		return
+	}
+
+	ban, err := h.db.CreateAuthorBan(r.Context(), req.IPHash, adminActor(r), req.Reason)
+	if err != nil {
+		log.Printf("Error creating author ban: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to create ban")
+		return
+	}
+	if ban == nil {
+		respondWithError(w, http.StatusConflict, "That hash is already banned")
+		return
+	}
+
+	h.audit(r, "author.ban", "ip_hash", req.IPHash, req)
+
+	respondWithJSON(w, http.StatusCreated, ban)
+}
+
+// DeleteAuthorBan handles DELETE /admin/bans/{hash}
+func (h *Handler) DeleteAuthorBan(w http.ResponseWriter, r *http.Request) {
+	hash := strings.ToLower(r.PathValue("hash"))
+
+	found, err := h.db.DeleteAuthorBan(r.Context(), hash)
+	if err != nil {
+		log.Printf("Error deleting author ban: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to delete ban")
+		return
+	}
+	if !found {
+		respondWithError(w, http.StatusNotFound, "Ban not found")
+		return
+	}
+
+	h.audit(r, "author.unban", "ip_hash", hash, nil)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+const authorBanColumns = `ip_hash, banned_by, COALESCE(reason, ''), created_at`
+
+func scanAuthorBan(row rowScanner) (*AuthorBan, error) {
+	var ban AuthorBan
+	if err := row.Scan(&ban.IPHash, &ban.BannedBy, &ban.Reason, &ban.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &ban, nil
+}
+
+// GetAuthorBans lists bans, newest first.
+func (db *DB) GetAuthorBans(ctx context.Context, limit, offset int) ([]AuthorBan, error) {
+	rows, err := db.conn.QueryContext(ctx, `
+		SELECT `+authorBanColumns+`
+		FROM author_bans
+		ORDER BY created_at DESC, ip_hash
+		LIMIT $1 OFFSET $2
+	`, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query author bans: %w", err)
+	}
+	defer rows.Close()
+
+	var bans []AuthorBan
+	for rows.Next() {
+		ban, err := scanAuthorBan(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan author ban: %w", err)
+		}
+		bans = append(bans, *ban)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating author bans: %w", err)
+	}
+
+	return bans, nil
+}
+
+// CreateAuthorBan bans ipHash, returning nil if it is already banned.
+func (db *DB) CreateAuthorBan(ctx context.Context, ipHash, bannedBy, reason string) (*AuthorBan, error) {
+	query := `
+		INSERT INTO author_bans (ip_hash, banned_by, reason)
+		VALUES ($1, $2, NULLIF($3, ''))
+		ON CONFLICT (ip_hash) DO NOTHING
+		RETURNING ` + authorBanColumns
+
+	ban, err := scanAuthorBan(db.conn.QueryRowContext(ctx, query, ipHash, bannedBy, reason))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create author ban: %w", err)
+	}
+	return ban, nil
+}
+
+// DeleteAuthorBan lifts a ban, reporting whether there was one.
+func (db *DB) DeleteAuthorBan(ctx context.Context, ipHash string) (bool, error) {
+	result, err := db.conn.ExecContext(ctx, "DELETE FROM author_bans WHERE ip_hash = $1", ipHash)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete author ban: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to delete author ban: %w", err)
+	}
+
+	return affected > 0, nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	avatarGrid          = 5
+	maxAvatarSeedLength = 100
+	avatarBackground    = "#ffffff"
+)
+
+// avatarPalette is the foreground colors of generated avatars. Each has a
+// contrast ratio of at least 4.5:1 against avatarBackground, so avatars
+// stay legible as WCAG graphics and can also carry white initials.
+var avatarPalette = []string{
+	"#b91c1c", // red
+	"#c2410c", // orange
+	"#a16207", // amber
+	"#15803d", // green
+	"#0f766e", // teal
+	"#0369a1", // sky
+	"#1d4ed8", // blue
+	"#6d28d9", // violet
+	"#a21caf", // fuchsia
+	"#be123c", // rose
+}
+
+// Avatar is the identicon for a seed: a color and a horizontally symmetric
+// pattern of filled cells.
+type Avatar struct {
+	Color string
+	cells [avatarGrid][avatarGrid]bool
+}
+
+// NewAvatar derives an avatar from a seed, such as a per-event pseudonym.
+// The same seed always gives the same avatar.
+func NewAvatar(seed string) Avatar {
+	sum := sha256.Sum256([]byte(seed))
+	a := Avatar{Color: avatarPalette[int(sum[0])%len(avatarPalette)]}
+
+	// One bit per cell of the left half and middle column, mirrored right
+	bit := 0
+	for col := 0; col < (avatarGrid+1)/2; col++ {
+		for row := 0; row < avatarGrid; row++ {
+			filled := sum[1+bit/8]&(1<<(bit%8)) != 0
+			a.cells[row][col] = filled
+			a.cells[row][avatarGrid-1-col] = filled
+			bit++
+		}
+	}
+	return a
+}
+
+// SVG renders the avatar on a grid of avatarGrid units with a one unit
+// margin, for the client to scale.
+func (a Avatar) SVG() []byte {
+	size := avatarGrid + 2
+	var b bytes.Buffer
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" shape-rendering="crispEdges">`, size, size)
+	fmt.Fprintf(&b, `<rect width="%d" height="%d" fill="%s"/>`, size, size, avatarBackground)
+	fmt.Fprintf(&b, `<g fill="%s">`, a.Color)
+	for row := range a.cells {
+		for col, filled := range a.cells[row] {
+			if filled {
+				fmt.Fprintf(&b, `<rect x="%d" y="%d" width="1" height="1"/>`, col+1, row+1)
+			}
+		}
+	}
+	b.WriteString(`</g></svg>`)
+	return b.Bytes()
+}
+
+// GetAvatar handles GET /api/avatars/{seed}.svg. Avatars never change for a
+// seed, so they are cached for a year.
+func (h *Handler) GetAvatar(w http.ResponseWriter, r *http.Request) {
+	seed, ok := strings.CutSuffix(r.PathValue("file"), ".svg")
+	if !ok || seed == "" {
+		http.NotFound(w, r)
+		return
+	}
+	if len(seed) > maxAvatarSeedLength {
+		http.Error(w, fmt.Sprintf("Seed must be %d characters or less", maxAvatarSeedLength), http.StatusBadRequest)
+		return
+	}
+
+	svg := NewAvatar(seed).SVG()
+	sum := sha256.Sum256(svg)
+
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:8])+`"`)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(svg))
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+// contrastRatio is the WCAG contrast ratio between two #rrggbb colors.
+func contrastRatio(a, b string) float64 {
+	luminance := func(hex string) float64 {
+		var l float64
+		for i, weight := range []float64{0.2126, 0.7152, 0.0722} {
+			v, _ := strconv.ParseUint(hex[1+2*i:3+2*i], 16, 8)
+			c := float64(v) / 255
+			if c <= 0.03928 {
+				c /= 12.92
+			} else {
+				c = math.Pow((c+0.055)/1.055, 2.4)
+			}
+			l += weight * c
+		}
+		return l
+	}
+	la, lb := luminance(a), luminance(b)
+	return (math.Max(la, lb) + 0.05) / (math.Min(la, lb) + 0.05)
+}
+
+func TestAvatarPaletteContrast(t *testing.T) {
+	for _, color := range avatarPalette {
+		if ratio := contrastRatio(color, avatarBackground); ratio < 4.5 {
+			t.Errorf("%s has contrast %.2f against the background, want at least 4.5", color, ratio)
+		}
+	}
+}
+
+func TestNewAvatar(t *testing.T) {
+	a, b := NewAvatar("Quiet Otter"), NewAvatar("Quiet Otter")
+	if a != b {
+		t.Error("the same seed gave different avatars")
+	}
+	if NewAvatar("Loud Otter") == a {
+		t.Error("different seeds gave the same avatar")
+	}
+
+	for row := range a.cells {
+		for col := range a.cells[row] {
+			if a.cells[row][col] != a.cells[row][avatarGrid-1-col] {
+				t.Fatalf("avatar is not symmetric at row %d, column %d", row, col)
+			}
+		}
+	}
+}
+
+func TestGetAvatar(t *testing.T) {
+	h := newTestHandler(newFakeStore(), HandlerConfig{})
+
+	tests := []struct {
+		file string
+		want int
+	}{
+		{"quiet-otter.svg", http.StatusOK},
+		{"quiet-otter.png", http.StatusNotFound},
+		{".svg", http.StatusNotFound},
+		{string(make([]byte, maxAvatarSeedLength+1)) + ".svg", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/api/avatars/x", nil)
+		req.SetPathValue("file", tt.file)
+		h.GetAvatar(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("%q: status = %d, want %d", tt.file, rec.Code, tt.want)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/api/avatars/quiet-otter.svg", nil)
+	req.SetPathValue("file", "quiet-otter.svg")
+	h.GetAvatar(rec, req)
+	if ct := rec.Header().Get("Content-Type"); ct != "image/svg+xml" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+
+	// Revalidating with the ETag needs no body
+	rec2 := httptest.NewRecorder()
+	req.Header.Set("If-None-Match", rec.Header().Get("ETag"))
+	h.GetAvatar(rec2, req)
+	if rec2.Code != http.StatusNotModified {
+		t.Errorf("revalidation status = %d, want 304", rec2.Code)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import "sync"
+
+// PostBroker tells requests waiting on new posts, such as long-polls, when
+// one is published. Waiters are only told that something changed, and read
+// the posts from the database themselves, so they see them as everyone else
+// does: redacted, and without hidden posts. Without a relay only posts
+// published by this process are heard of.
+type PostBroker struct {
+	relay PostRelay
+
+	mu      sync.Mutex
+	waiters map[chan struct{}]string
+}
+
+func NewPostBroker(relay PostRelay) *PostBroker {
+	return &PostBroker{relay: relay, waiters: make(map[chan struct{}]string)}
+}
+
+// Subscribe returns a channel that is closed when a post is next published
+// on event, or on any event if event is empty, and a func to call once the
+// channel is no longer needed. Each channel fires once; subscribe again to
+// keep waiting. On a nil PostBroker the channel never fires.
+func (b *PostBroker) Subscribe(event string) (<-chan struct{}, func()) {
+	if b == nil {
+		return nil, func() {}
+	}
+	ch := make(chan struct{})
+	b.mu.Lock()
+	b.waiters[ch] = event
+	b.mu.Unlock()
+
+	return ch, func() {
+		b.mu.Lock()
+		delete(b.waiters, ch)
+		b.mu.Unlock()
+	}
+}
+
+// Publish wakes the waiters for the post's event, here and, through the
+// relay, in other processes. It is safe to call on a nil PostBroker.
+func (b *PostBroker) Publish(post Post) {
+	if b == nil {
+		return
+	}
+	b.wake(post.EventName)
+	if b.relay != nil {
+		b.relay.Send(post.EventName)
+	}
+}
+
+// wake wakes the waiters for a post published on published.
+func (b *PostBroker) wake(published string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch, event := range b.waiters {
+		if event == "" || event == published {
+			close(ch)
+			delete(b.waiters, ch)
+		}
+	}
+}
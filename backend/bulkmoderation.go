@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+const maxBulkPosts = 1000
+
+// Bulk moderation actions
+const (
+	bulkHide       = "hide"
+	bulkDelete     = "delete"
+	bulkApprove    = "approve"
+	bulkBanAuthors = "ban-authors"
+)
+
+// BannedError is returned for a post from a banned author.
+type BannedError struct{}
+
+func (e *BannedError) Error() string {
+	return "You can't post here."
+}
+
+// BulkModerationRequest applies one action to many posts, for cleaning up
+// a spam wave. With DryRun set nothing is changed, but the result reports
+// what would have been.
+type BulkModerationRequest struct {
+	PostIDs []int  `json:"post_ids"`
+	Action  string `json:"action"`
+	// Reason is recorded with bans; ReasonID is the removal reason shown
+	// to the authors of hidden posts
+	Reason   string `json:"reason"`
+	ReasonID *int   `json:"reason_id"`
+	DryRun   bool   `json:"dry_run"`
+}
+
+// BulkModerationResult counts what a bulk action did, or would do.
+type BulkModerationResult struct {
+	Action string `json:"action"`
+	DryRun bool   `json:"dry_run"`
+	// Matched is the number of the requested posts that exist, and
+	// Missing lists the rest
+	Matched int   `json:"matched"`
+	Missing []int `json:"missing"`
+	// Affected is the number of posts hidden, deleted or unhidden, or of
+	// authors newly banned
+	Affected int `json:"affected"`
+	// Held is the number of posts not deleted because of a legal hold
+	Held int `json:"held,omitempty"`
+	// Resolved is the number of moderation queue items an approval closed
+	Resolved int `json:"resolved,omitempty"`
+}
+
+// BulkModeratePosts handles POST /admin/posts/bulk. The action is applied
+// in one transaction with its audit entry: it happens to every post or to
+// none.
+func (h *Handler) BulkModeratePosts(w http.ResponseWriter, r *http.Request) {
+	var req BulkModerationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	switch req.Action {
+	case bulkHide, bulkDelete, bulkApprove, bulkBanAuthors:
+	default:
+		respondWithError(w, http.StatusBadRequest, "action must be hide, delete, approve or ban-authors")
+		return
+	}
+	if len(req.PostIDs) == 0 {
+		respondWithError(w, http.StatusBadRequest, "post_ids is required")
+		return
+	}
+	if len(req.PostIDs) > maxBulkPosts {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("post_ids must have %d IDs or fewer", maxBulkPosts))
+		return
+	}
+	if req.ReasonID != nil {
+		if req.Action != bulkHide {
+			respondWithError(w, http.StatusBadRequest, "reason_id only applies to hide")
+			return
+		}
+		reason, err := h.db.GetRemovalReason(r.Context(), *req.ReasonID)
+		if err != nil {
+			log.Printf("Error getting removal reason: %v", err)
+			respondWithError(w, http.StatusInternalServerError, "Failed to apply bulk moderation")
+			return
+		}
+		if reason == nil {
+			respondWithError(w, http.StatusBadRequest, "reason_id is not an active removal reason")
+			return
+		}
+	}
+
+	var result *BulkModerationResult
+	err := h.db.WithTx(r.Context(), func(tx Store) error {
+		var err error
+		result, err = tx.BulkModeratePosts(r.Context(), req, adminActor(r))
+		if err != nil || req.DryRun {
+			return err
+		}
+		return auditTx(tx, r, "post.bulk_"+req.Action, "", "", map[string]interface{}{"request": req, "result": result})
+	})
+	if err != nil {
+		log.Printf("Error applying bulk moderation: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to apply bulk moderation")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, result)
+}
+
+// BulkModeratePosts applies a bulk action in a transaction, rolling it back
+// if req.DryRun is set.
+func (db *DB) BulkModeratePosts(ctx context.Context, req BulkModerationRequest, actor string) (*BulkModerationResult, error) {
+	tx, err := db.begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin bulk moderation: %w", err)
+	}
+	defer tx.Rollback()
+
+	result := &BulkModerationResult{Action: req.Action, DryRun: req.DryRun, Missing: []int{}}
+
+	// Lock the posts so the counts hold until the transaction ends
+	rows, err := tx.QueryContext(ctx, "SELECT id FROM posts WHERE id = ANY($1) FOR UPDATE", req.PostIDs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock posts: %w", err)
+	}
+	found := make(map[int]bool)
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan post ID: %w", err)
+		}
+		found[id] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating posts: %w", err)
+	}
+	result.Matched = len(found)
+	for _, id := range req.PostIDs {
+		if !found[id] {
+			result.Missing = append(result.Missing, id)
+			found[id] = true // report duplicates once
+		}
+	}
+
+	var res sql.Result
+	switch req.Action {
+	case bulkHide:
+		res, err = tx.ExecContext(ctx, `
+			UPDATE posts SET hidden_at = NOW(), hidden_by = $2, removal_reason_id = $3
+			WHERE id = ANY($1) AND hidden_at IS NULL
+		`, req.PostIDs, actor, req.ReasonID)
+	case bulkDelete:
+		res, err = tx.ExecContext(ctx, `
+			DELETE FROM posts p
+			WHERE p.id = ANY($1)
+			AND `+notUnderLegalHold, req.PostIDs)
+	case bulkApprove:
+		var resolved sql.Result
+		resolved, err = tx.ExecContext(ctx, `
+			UPDATE moderation_queue
+			SET resolved_at = NOW(), resolved_by = $2, resolution = 'dismissed'
+			WHERE post_id = ANY($1) AND resolved_at IS NULL
+		`, req.PostIDs, actor)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve moderation items: %w", err)
+		}
+		var n int64
+		if n, err = resolved.RowsAffected(); err != nil {
+			return nil, fmt.Errorf("failed to resolve moderation items: %w", err)
+		}
+		result.Resolved = int(n)
+
+		res, err = tx.ExecContext(ctx, `
+			UPDATE posts SET hidden_at = NULL, hidden_by = NULL, removal_reason_id = NULL
+			WHERE id = ANY($1) AND hidden_at IS NOT NULL
+		`, req.PostIDs)
+	case bulkBanAuthors:
+		res, err = tx.ExecContext(ctx, `
+			INSERT INTO author_bans (ip_hash, banned_by, reason)
+			SELECT DISTINCT ip_hash, $2, NULLIF($3, '') FROM posts WHERE id = ANY($1)
+			ON CONFLICT (ip_hash) DO NOTHING
+		`, req.PostIDs, actor, req.Reason)
+	default:
+		return nil, fmt.Errorf("unknown bulk action %q", req.Action)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to %s posts: %w", req.Action, err)
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return nil, fmt.Errorf("failed to %s posts: %w", req.Action, err)
+	}
+	result.Affected = int(affected)
+	if req.Action == bulkDelete {
+		result.Held = result.Matched - result.Affected
+	}
+
+	if req.DryRun {
+		return result, nil
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit bulk moderation: %w", err)
+	}
+	return result, nil
+}
+
+// IsAuthorBanned reports whether posts from ipHash are refused.
+func (db *DB) IsAuthorBanned(ctx context.Context, ipHash string) (bool, error) {
+	var banned bool
+	err := db.conn.QueryRowContext(ctx,
+		"SELECT EXISTS (SELECT 1 FROM author_bans WHERE ip_hash = $1)", ipHash,
+	).Scan(&banned)
+	if err != nil {
+		return false, fmt.Errorf("failed to check author ban: %w", err)
+	}
+	return banned, nil
+}
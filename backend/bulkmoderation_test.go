@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// TestBulkModeratePosts runs each action against the database, first as a
+// dry run, which must report the same counts without changing anything.
+func TestBulkModeratePosts(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+
+	event := fmt.Sprintf("Bulk Test %d", time.Now().UnixNano())
+	spammer := fmt.Sprintf("spammer-%d", time.Now().UnixNano())
+	var ids []int
+	for i := 0; i < 3; i++ {
+		post, err := db.CreatePost(ctx, CreatePostRequest{EventName: event, Content: "Buy now", Age: 25, Location: "x"}, spammer, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, post.ID)
+	}
+	hold, err := db.CreateLegalHold(ctx, CreateLegalHoldRequest{TargetType: "post", TargetValue: strconv.Itoa(ids[0]), Reason: "test"}, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.ReleaseLegalHold(context.Background(), hold.ID, "test") })
+
+	visible := func() int {
+		posts, err := db.GetPosts(ctx, PostFilter{Event: event}, 50, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(posts)
+	}
+
+	missing := 2147483647
+	steps := []struct {
+		action  string
+		want    BulkModerationResult
+		visible int
+	}{
+		{bulkHide, BulkModerationResult{Matched: 3, Affected: 3}, 0},
+		{bulkApprove, BulkModerationResult{Matched: 3, Affected: 3}, 3},
+		{bulkBanAuthors, BulkModerationResult{Matched: 3, Affected: 1}, 3},
+		{bulkDelete, BulkModerationResult{Matched: 3, Affected: 2, Held: 1}, 1},
+	}
+	for _, step := range steps {
+		for _, dryRun := range []bool{true, false} {
+			before := visible()
+			req := BulkModerationRequest{PostIDs: append(ids, missing), Action: step.action, DryRun: dryRun}
+			got, err := db.BulkModeratePosts(ctx, req, "test")
+			if err != nil {
+				t.Fatalf("%s: %v", step.action, err)
+			}
+			if got.Matched != step.want.Matched || got.Affected != step.want.Affected || got.Held != step.want.Held ||
+				len(got.Missing) != 1 || got.Missing[0] != missing {
+				t.Errorf("%s (dry run %v) = %+v, want %+v and %d missing", step.action, dryRun, got, step.want, missing)
+			}
+
+			want := step.visible
+			if dryRun {
+				want = before
+			}
+			if n := visible(); n != want {
+				t.Errorf("%s (dry run %v): %d posts visible, want %d", step.action, dryRun, n, want)
+			}
+		}
+	}
+
+	banned, err := db.IsAuthorBanned(ctx, spammer)
+	if err != nil || !banned {
+		t.Errorf("IsAuthorBanned = %v, %v; want true", banned, err)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	icsDateTime = "20060102T150405Z"
+	icsDate     = "20060102"
+)
+
+// icsEscaper escapes TEXT values per RFC 5545 section 3.3.11.
+var icsEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`)
+
+// GetEventCalendar handles GET /api/events/{event}/calendar.ics. The feed
+// has the event itself, if it has dates, and a "morning after" entry: an
+// all-day date with a 9am alarm, which calendars interpret in the
+// subscriber's own timezone.
+func (h *Handler) GetEventCalendar(w http.ResponseWriter, r *http.Request) {
+	event, err := h.db.GetEvent(r.Context(), r.PathValue("event"))
+	if err != nil {
+		log.Printf("Error getting event: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve event")
+		return
+	}
+	if event == nil {
+		respondWithError(w, http.StatusNotFound, "Event not found")
+		return
+	}
+
+	cal := &icsWriter{}
+	cal.line("BEGIN", "VCALENDAR")
+	cal.line("VERSION", "2.0")
+	cal.line("PRODID", "-//hndshake//event calendar//EN")
+	cal.line("CALSCALE", "GREGORIAN")
+	cal.line("X-WR-CALNAME", icsEscaper.Replace(event.Name))
+
+	stamp := time.Now().UTC().Format(icsDateTime)
+
+	if event.StartsAt != nil {
+		cal.line("BEGIN", "VEVENT")
+		cal.line("UID", fmt.Sprintf("event-%d@%s", event.ID, r.Host))
+		cal.line("DTSTAMP", stamp)
+		cal.line("DTSTART", event.StartsAt.UTC().Format(icsDateTime))
+		if event.EndsAt != nil {
+			cal.line("DTEND", event.EndsAt.UTC().Format(icsDateTime))
+		}
+		cal.line("SUMMARY", icsEscaper.Replace(event.Name))
+		cal.line("END", "VEVENT")
+
+		end := *event.StartsAt
+		if event.EndsAt != nil {
+			end = *event.EndsAt
+		}
+		morningAfter := end.UTC().AddDate(0, 0, 1)
+
+		cal.line("BEGIN", "VEVENT")
+		cal.line("UID", fmt.Sprintf("event-%d-morning-after@%s", event.ID, r.Host))
+		cal.line("DTSTAMP", stamp)
+		cal.line("DTSTART;VALUE=DATE", morningAfter.Format(icsDate))
+		cal.line("DTEND;VALUE=DATE", morningAfter.AddDate(0, 0, 1).Format(icsDate))
+		cal.line("SUMMARY", icsEscaper.Replace("Check the "+event.Name+" board"))
+		cal.line("DESCRIPTION", icsEscaper.Replace("See who was looking for you at "+event.Name+"."))
+		cal.line("TRANSP", "TRANSPARENT")
+		cal.line("BEGIN", "VALARM")
+		cal.line("ACTION", "DISPLAY")
+		cal.line("DESCRIPTION", icsEscaper.Replace("Check the "+event.Name+" board"))
+		cal.line("TRIGGER;RELATED=START", "PT9H")
+		cal.line("END", "VALARM")
+		cal.line("END", "VEVENT")
+	}
+
+	cal.line("END", "VCALENDAR")
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", event.Slug+".ics"))
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(cal.String()))
+}
+
+// icsWriter builds an iCalendar document with CRLF line endings, folding
+// lines longer than 75 octets as RFC 5545 requires.
+type icsWriter struct {
+	strings.Builder
+}
+
+func (c *icsWriter) line(name, value string) {
+	line := name + ":" + value
+	// Continuation lines start with a space, which counts toward the limit
+	limit := 75
+	for len(line) > limit {
+		cut := limit
+		// Don't split a multi-byte UTF-8 sequence
+		for cut > 0 && line[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		c.WriteString(line[:cut] + "\r\n ")
+		line = line[cut:]
+		limit = 74
+	}
+	c.WriteString(line + "\r\n")
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+const postCapWindow = time.Minute
+
+// Error codes for posts refused by a cap, so clients can tell a flooded
+// event from their own rate limit
+const (
+	capCodeGlobal = "posting_paused"
+	capCodeEvent  = "event_flooded"
+)
+
+// PostingCaps limit how many posts are accepted per minute, across the whole
+// server and per event, whoever sends them. They protect the database during
+// a viral spike that per-client rate limits can't. Counts are kept in memory
+// per server process, so they are cheap to check when the database is
+// struggling, and reset every postCapWindow.
+type PostingCaps struct {
+	global   int
+	perEvent int
+
+	mu          sync.Mutex
+	windowStart time.Time
+	total       int
+	events      map[string]int
+}
+
+// NewPostingCaps returns nil when both caps are 0 (unlimited).
+func NewPostingCaps(global, perEvent int) *PostingCaps {
+	if global <= 0 && perEvent <= 0 {
+		return nil
+	}
+	return &PostingCaps{global: global, perEvent: perEvent, events: make(map[string]int)}
+}
+
+// allow counts a post to event against the caps. When it is refused, code
+// says which cap was hit and retryAfter is when the window resets.
+func (c *PostingCaps) allow(event string) (code string, retryAfter time.Duration, ok bool) {
+	if c == nil {
+		return "", 0, true
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if time.Since(c.windowStart) > postCapWindow {
+		c.windowStart = time.Now()
+		c.total = 0
+		c.events = make(map[string]int)
+	}
+	retryAfter = postCapWindow - time.Since(c.windowStart)
+
+	if c.global > 0 && c.total >= c.global {
+		return capCodeGlobal, retryAfter, false
+	}
+	if c.perEvent > 0 && c.events[event] >= c.perEvent {
+		return capCodeEvent, retryAfter, false
+	}
+	c.total++
+	c.events[event]++
+	return "", 0, true
+}
+
+// checkPostingCaps reports whether a post to event is within the caps,
+// writing a 429 with the cap's code if it isn't.
+func (h *Handler) checkPostingCaps(w http.ResponseWriter, event string) bool {
+	code, retryAfter, ok := h.cfg.Caps.allow(event)
+	if ok {
+		return true
+	}
+
+	message := "We're receiving a lot of posts right now. Please try again in a minute."
+	if code == capCodeEvent {
+		message = "This event is receiving a lot of posts right now. Please try again in a minute."
+	}
+	respondRateLimited(w, code, message, retryAfter)
+	return false
+}
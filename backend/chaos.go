@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Chaos injects faults into requests so client retry and offline handling
+// can be tried against a misbehaving server. It is for development and
+// staging only, and is enabled by setting CHAOS_RULES.
+type Chaos struct {
+	rules []chaosRule
+}
+
+// chaosRule applies to requests whose path starts with prefix.
+type chaosRule struct {
+	prefix string
+
+	// Each request waits a random time between minLatency and maxLatency.
+	minLatency time.Duration
+	maxLatency time.Duration
+
+	// dropRate is the fraction of requests whose connection is closed after
+	// the handler runs, so the client gets no response to a request that
+	// took effect.
+	dropRate float64
+
+	// dbErrorRate is the fraction of requests whose database calls fail.
+	dbErrorRate float64
+}
+
+// ParseChaosRules parses CHAOS_RULES, rules separated by semicolons, each a
+// path prefix followed by options:
+//
+//	/api/posts latency=200ms-2s drop=0.1 dberror=0.05; /api/ latency=0-500ms
+//
+// The longest matching prefix applies. It returns nil when rules is empty.
+func ParseChaosRules(rules string) (*Chaos, error) {
+	if strings.TrimSpace(rules) == "" {
+		return nil, nil
+	}
+
+	var c Chaos
+	for _, spec := range strings.Split(rules, ";") {
+		fields := strings.Fields(spec)
+		if len(fields) == 0 {
+			continue
+		}
+		rule := chaosRule{prefix: fields[0]}
+		if !strings.HasPrefix(rule.prefix, "/") {
+			return nil, fmt.Errorf("chaos rule %q must start with a path", spec)
+		}
+
+		for _, option := range fields[1:] {
+			key, value, _ := strings.Cut(option, "=")
+			var err error
+			switch key {
+			case "latency":
+				rule.minLatency, rule.maxLatency, err = parseLatencyRange(value)
+			case "drop":
+				rule.dropRate, err = parseRate(value)
+			case "dberror":
+				rule.dbErrorRate, err = parseRate(value)
+			default:
+				err = fmt.Errorf("unknown option %q", key)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("chaos rule for %s: %w", rule.prefix, err)
+			}
+		}
+		c.rules = append(c.rules, rule)
+	}
+	return &c, nil
+}
+
+// parseLatencyRange parses "500ms" or "200ms-2s".
+func parseLatencyRange(s string) (lo, hi time.Duration, err error) {
+	first, second, isRange := strings.Cut(s, "-")
+	if lo, err = parseLatency(first); err != nil {
+		return 0, 0, err
+	}
+	hi = lo
+	if isRange {
+		if hi, err = parseLatency(second); err != nil {
+			return 0, 0, err
+		}
+	}
+	if hi < lo {
+		return 0, 0, fmt.Errorf("latency range %q is backwards", s)
+	}
+	return lo, hi, nil
+}
+
+func parseLatency(s string) (time.Duration, error) {
+	if s == "0" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid latency %q", s)
+	}
+	return d, nil
+}
+
+func parseRate(s string) (float64, error) {
+	rate, err := strconv.ParseFloat(s, 64)
+	if err != nil || rate < 0 || rate > 1 {
+		return 0, fmt.Errorf("rate %q must be between 0 and 1", s)
+	}
+	return rate, nil
+}
+
+func (c *Chaos) rule(path string) *chaosRule {
+	var match *chaosRule
+	for i, rule := range c.rules {
+		if strings.HasPrefix(path, rule.prefix) && (match == nil || len(rule.prefix) > len(match.prefix)) {
+			match = &c.rules[i]
+		}
+	}
+	return match
+}
+
+// Middleware applies the matching rule to each request. A nil Chaos passes
+// requests through untouched.
+func (c *Chaos) Middleware(next http.Handler) http.Handler {
+	if c == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rule := c.rule(r.URL.Path)
+		if rule == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if rule.maxLatency > 0 {
+			delay := rule.minLatency
+			if spread := rule.maxLatency - rule.minLatency; spread > 0 {
+				delay += rand.N(spread)
+			}
+			select {
+			case <-time.After(delay):
+			case <-r.Context().Done():
+				return
+			}
+		}
+
+		// Cancelling the request's context before the handler runs makes its
+		// database calls fail, so the handler's real error path is taken
+		if rand.Float64() < rule.dbErrorRate {
+			log.Printf("Chaos: failing database calls for %s %s", r.Method, r.URL.Path)
+			ctx, cancel := context.WithCancel(r.Context())
+			cancel()
+			r = r.WithContext(ctx)
+		}
+
+		if rand.Float64() < rule.dropRate {
+			log.Printf("Chaos: dropping connection for %s %s", r.Method, r.URL.Path)
+			next.ServeHTTP(&discardResponseWriter{header: make(http.Header)}, r)
+			panic(http.ErrAbortHandler)
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// discardResponseWriter swallows a response that is never sent.
+type discardResponseWriter struct {
+	header http.Header
+}
+
+func (d *discardResponseWriter) Header() http.Header         { return d.header }
+func (d *discardResponseWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (d *discardResponseWriter) WriteHeader(int)             {}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// checkinCodeLength is short enough to read off a screen and type
+	checkinCodeLength = 6
+	// checkinCodeAlphabet leaves out characters easily confused, such as
+	// 0 and O, or 1 and I
+	checkinCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+	defaultCheckinTTL = 15 * time.Minute
+	maxCheckinTTL     = 24 * time.Hour
+)
+
+// CheckinCode is a short-lived code an organizer shows at the venue. Posts
+// made with it are marked as from a verified attendee: someone who was
+// there, without saying who they are.
+type CheckinCode struct {
+	ID        int        `json:"id"`
+	EventName string     `json:"event_name"`
+	Code      string     `json:"code"`
+	Label     string     `json:"label,omitempty"`
+	Uses      int        `json:"uses"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+type CreateCheckinCodeRequest struct {
+	// TTLMinutes is how long the code works; 15 minutes if not set
+	TTLMinutes int `json:"ttl_minutes"`
+	// Label says where the code is shown, such as "Main entrance"
+	Label string `json:"label"`
+}
+
+// newCheckinCode generates a random check-in code.
+func newCheckinCode() string {
+	b := make([]byte, checkinCodeLength)
+	rand.Read(b)
+	for i := range b {
+		b[i] = checkinCodeAlphabet[int(b[i])%len(checkinCodeAlphabet)]
+	}
+	return string(b)
+}
+
+// checkCheckinCode marks a new post as from a verified attendee if it came
+// with a check-in code that is current for its event. publishPost uses the
+// code with the post.
+func (h *Handler) checkCheckinCode(ctx context.Context, req *CreatePostRequest) error {
+	req.VerifiedAttendee = false
+	req.CheckinCode = strings.ToUpper(strings.TrimSpace(req.CheckinCode))
+	if req.CheckinCode == "" {
+		return nil
+	}
+	ok, err := h.db.CheckCheckinCode(ctx, req.EventName, req.CheckinCode)
+	if err != nil {
+		return fmt.Errorf("failed to check checkin code: %w", err)
+	}
+	if !ok {
+		return errCheckinCodeInvalid()
+	}
+	req.VerifiedAttendee = true
+	return nil
+}
+
+func errCheckinCodeInvalid() error {
+	return &ValidationError{Message: "checkin_code is invalid or has expired", Rule: "checkin_code.invalid"}
+}
+
+// GetCheckinCodes handles GET /admin/events/{event}/checkin-codes, newest
+// first, including expired ones so organizers can see how each was used.
+func (h *Handler) GetCheckinCodes(w http.ResponseWriter, r *http.Request) {
+	limit, offset := parsePagination(r)
+
+	codes, err := h.db.GetCheckinCodes(r.Context(), r.PathValue("event"), limit, offset)
+	if err != nil {
+		log.Printf("Error getting checkin codes: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve checkin codes")
+		return
+	}
+
+	if codes == nil {
+		codes = []CheckinCode{}
+	}
+
+	respondWithJSON(w, http.StatusOK, codes)
+}
+
+// CreateCheckinCode handles POST /admin/events/{event}/checkin-codes
+func (h *Handler) CreateCheckinCode(w http.ResponseWriter, r *http.Request) {
+	var req CreateCheckinCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	ttl := defaultCheckinTTL
+	if req.TTLMinutes != 0 {
+		ttl = time.Duration(req.TTLMinutes) * time.Minute
+	}
+	if ttl < time.Minute || ttl > maxCheckinTTL {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("ttl_minutes must be between 1 and %d", int(maxCheckinTTL.Minutes())))
+		return
+	}
+	req.Label = strings.TrimSpace(req.Label)
+	if len(req.Label) > 100 {
+		respondWithError(w, http.StatusBadRequest, "label must be 100 characters or less")
+		return
+	}
+
+	code, err := h.db.CreateCheckinCode(r.Context(), r.PathValue("event"), req.Label, ttl)
+	if err != nil {
+		log.Printf("Error creating checkin code: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to create checkin code")
+		return
+	}
+	if code == nil {
+		respondWithError(w, http.StatusNotFound, "Event not found")
+		return
+	}
+
+	h.audit(r, "checkin_code.create", "event", code.EventName, code)
+
+	respondWithJSON(w, http.StatusCreated, code)
+}
+
+// RevokeCheckinCode handles DELETE /admin/events/{event}/checkin-codes/{id},
+// for a code that has leaked beyond the venue. Posts already made with it
+// keep their badge.
+func (h *Handler) RevokeCheckinCode(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid checkin code ID")
+		return
+	}
+
+	found, err := h.db.RevokeCheckinCode(r.Context(), r.PathValue("event"), id)
+	if err != nil {
+		log.Printf("Error revoking checkin code: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to revoke checkin code")
+		return
+	}
+	if !found {
+		respondWithError(w, http.StatusNotFound, "Checkin code not found")
+		return
+	}
+
+	h.audit(r, "checkin_code.revoke", "event", r.PathValue("event"), map[string]int{"id": id})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+const checkinCodeColumns = `id, event_name, code, label, uses, created_at, expires_at, revoked_at`
+
+// checkinCodeCurrent matches event $1's code $2 while it works.
+const checkinCodeCurrent = `event_name = $1 AND code = $2 AND revoked_at IS NULL AND expires_at > NOW()`
+
+func scanCheckinCode(row rowScanner) (*CheckinCode, error) {
+	var code CheckinCode
+	if err := row.Scan(&code.ID, &code.EventName, &code.Code, &code.Label, &code.Uses, &code.CreatedAt, &code.ExpiresAt, &code.RevokedAt); err != nil {
+		return nil, err
+	}
+	return &code, nil
+}
+
+func (db *DB) GetCheckinCodes(ctx context.Context, eventName string, limit, offset int) ([]CheckinCode, error) {
+	rows, err := db.conn.QueryContext(ctx, `
+		SELECT `+checkinCodeColumns+`
+		FROM checkin_codes
+		WHERE event_name = $1
+		ORDER BY created_at DESC, id DESC
+		LIMIT $2 OFFSET $3
+	`, eventName, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query checkin codes: %w", err)
+	}
+	defer rows.Close()
+
+	var codes []CheckinCode
+	for rows.Next() {
+		code, err := scanCheckinCode(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan checkin code: %w", err)
+		}
+		codes = append(codes, *code)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating checkin codes: %w", err)
+	}
+
+	return codes, nil
+}
+
+// CreateCheckinCode generates a code for eventName that works for ttl,
+// returning nil if there is no such event.
+func (db *DB) CreateCheckinCode(ctx context.Context, eventName, label string, ttl time.Duration) (*CheckinCode, error) {
+	query := `
+		INSERT INTO checkin_codes (event_name, code, label, expires_at)
+		SELECT name, $2, $3, NOW() + make_interval(secs => $4) FROM events WHERE name = $1
+		ON CONFLICT (event_name, code) DO NOTHING
+		RETURNING ` + checkinCodeColumns
+
+	// A code the event has had before is generated again, rarely
+	for attempt := 0; attempt < 3; attempt++ {
+		code, err := scanCheckinCode(db.conn.QueryRowContext(ctx, query, eventName, newCheckinCode(), label, ttl.Seconds()))
+		if err == nil {
+			return code, nil
+		}
+		if err != sql.ErrNoRows {
+			return nil, fmt.Errorf("failed to create checkin code: %w", err)
+		}
+	}
+	return nil, nil
+}
+
+// RevokeCheckinCode stops one of eventName's codes working, reporting
+// whether it existed unrevoked.
+func (db *DB) RevokeCheckinCode(ctx context.Context, eventName string, id int) (bool, error) {
+	result, err := db.conn.ExecContext(ctx,
+		"UPDATE checkin_codes SET revoked_at = NOW() WHERE id = $1 AND event_name = $2 AND revoked_at IS NULL",
+		id, eventName,
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to revoke checkin code: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to revoke checkin code: %w", err)
+	}
+
+	return affected > 0, nil
+}
+
+// CheckCheckinCode reports whether code currently works for eventName.
+func (db *DB) CheckCheckinCode(ctx context.Context, eventName, code string) (bool, error) {
+	var ok bool
+	err := db.conn.QueryRowContext(ctx,
+		"SELECT EXISTS (SELECT 1 FROM checkin_codes WHERE "+checkinCodeCurrent+")",
+		eventName, code,
+	).Scan(&ok)
+	if err != nil {
+		return false, fmt.Errorf("failed to check checkin code: %w", err)
+	}
+	return ok, nil
+}
+
+// UseCheckinCode counts a post made with code, reporting false if the code
+// has stopped working since it was checked.
+func (db *DB) UseCheckinCode(ctx context.Context, eventName, code string) (bool, error) {
+	result, err := db.conn.ExecContext(ctx,
+		"UPDATE checkin_codes SET uses = uses + 1 WHERE "+checkinCodeCurrent,
+		eventName, code,
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to use checkin code: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to use checkin code: %w", err)
+	}
+
+	return affected > 0, nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCreatePostCheckinCode(t *testing.T) {
+	store := newFakeStore()
+	store.checkins["K7P2QX"] = 0
+	h := newTestHandler(store, HandlerConfig{})
+
+	post := func(code string) *httptest.ResponseRecorder {
+		body := `{"event_name": "Glastonbury", "content": "hi", "checkin_code": "` + code + `", "age": 25, "location": "x"}`
+		rec := httptest.NewRecorder()
+		h.CreatePost(rec, httptest.NewRequest(http.MethodPost, "/api/posts", strings.NewReader(body)))
+		return rec
+	}
+
+	assertError(t, post("NOPE"), 400, "checkin_code is invalid or has expired")
+
+	// Codes are matched case-insensitively and badge the post
+	if rec := post(" k7p2qx "); rec.Code != 201 || !strings.Contains(rec.Body.String(), `"verified_attendee":true`) {
+		t.Fatalf("status = %d, body %s; want 201 with a verified attendee badge", rec.Code, rec.Body)
+	}
+	if store.checkins["K7P2QX"] != 1 {
+		t.Errorf("code used %d times, want 1", store.checkins["K7P2QX"])
+	}
+
+	// Posts without a code are accepted without the badge
+	if rec := post(""); rec.Code != 201 || strings.Contains(rec.Body.String(), "verified_attendee") {
+		t.Fatalf("status = %d, body %s; want 201 without a badge", rec.Code, rec.Body)
+	}
+}
+
+func TestCreatePostCheckinExpired(t *testing.T) {
+	// A code that stops working between the check and the post is refused,
+	// and the post isn't kept
+	store := newFakeStore()
+	h := newTestHandler(store, HandlerConfig{})
+
+	req := CreatePostRequest{EventName: "Glastonbury", Content: "hi", CheckinCode: "K7P2QX", VerifiedAttendee: true}
+	if _, err := h.publishPost(t.Context(), req, nil, "ip", ""); err == nil || err.Error() != "checkin_code is invalid or has expired" {
+		t.Fatalf("publishPost error = %v, want the expired code refused", err)
+	}
+	if len(store.posts) != 0 {
+		t.Errorf("%d posts kept, want none", len(store.posts))
+	}
+}
+
+func TestCreateCheckinCodeValidation(t *testing.T) {
+	h := newTestHandler(newFakeStore(), HandlerConfig{})
+	for _, tt := range []struct {
+		body, want string
+	}{
+		{`{`, "Invalid request body"},
+		{`{"ttl_minutes": -5}`, "ttl_minutes must be between 1 and 1440"},
+		{`{"ttl_minutes": 1441}`, "ttl_minutes must be between 1 and 1440"},
+		{`{"label": "` + strings.Repeat("l", 101) + `"}`, "label must be 100 characters or less"},
+	} {
+		rec := httptest.NewRecorder()
+		h.CreateCheckinCode(rec, httptest.NewRequest(http.MethodPost, "/admin/events/Glastonbury/checkin-codes", strings.NewReader(tt.body)))
+		assertError(t, rec, 400, tt.want)
+	}
+}
+
+func TestNewCheckinCode(t *testing.T) {
+	code := newCheckinCode()
+	if len(code) != checkinCodeLength {
+		t.Fatalf("code %q has length %d, want %d", code, len(code), checkinCodeLength)
+	}
+	for _, c := range code {
+		if !strings.ContainsRune(checkinCodeAlphabet, c) {
+			t.Errorf("code %q has %q, which isn't in the alphabet", code, c)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	clientErrorMaxBytes   = 32 << 10
+	clientErrorQueueSize  = 100
+	clientErrorMaxMessage = 1000
+	clientErrorMaxStack   = 16 << 10
+	clientErrorMaxURL     = 2000
+	clientErrorMaxVersion = 100
+	clientErrorMaxAgent   = 500
+)
+
+// ClientErrorReport is an error the frontend caught, such as an uncaught
+// exception in the PWA.
+type ClientErrorReport struct {
+	Message    string    `json:"message"`
+	Stack      string    `json:"stack,omitempty"`
+	URL        string    `json:"url,omitempty"`
+	AppVersion string    `json:"app_version,omitempty"`
+	UserAgent  string    `json:"user_agent,omitempty"`
+	ReceivedAt time.Time `json:"received_at"`
+}
+
+// ClientErrorSink receives the client error reports that are kept.
+type ClientErrorSink interface {
+	Emit(ctx context.Context, report ClientErrorReport) error
+}
+
+// LogClientErrorSink writes client error reports to the application log.
+type LogClientErrorSink struct{}
+
+func (LogClientErrorSink) Emit(ctx context.Context, report ClientErrorReport) error {
+	log.Printf("client error: version=%q url=%q message=%q stack=%q", report.AppVersion, report.URL, report.Message, report.Stack)
+	return nil
+}
+
+// WebhookClientErrorSink POSTs each client error report as JSON, for an
+// error reporting service's ingestion endpoint.
+type WebhookClientErrorSink struct {
+	url    string
+	client *http.Client
+}
+
+func NewWebhookClientErrorSink(url string) *WebhookClientErrorSink {
+	return &WebhookClientErrorSink{url: url, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (s *WebhookClientErrorSink) Emit(ctx context.Context, report ClientErrorReport) error {
+	body, err := json.Marshal(report)
+	if err != nil {
+		return fmt.Errorf("failed to encode client error: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to build client error request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send client error: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("client error sink returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// NewClientErrorSink builds the sink named by CLIENT_ERROR_SINK ("log",
+// "webhook" or "none"); it returns nil when reports should be discarded.
+func NewClientErrorSink(kind, url string) (ClientErrorSink, error) {
+	switch kind {
+	case "", "none":
+		return nil, nil
+	case "log":
+		return LogClientErrorSink{}, nil
+	case "webhook":
+		if url == "" {
+			return nil, fmt.Errorf("CLIENT_ERROR_SINK_URL is required for the webhook sink")
+		}
+		return NewWebhookClientErrorSink(url), nil
+	default:
+		return nil, fmt.Errorf("unknown client error sink %q", kind)
+	}
+}
+
+// ClientErrors accepts error reports from the frontend. A crash at a busy
+// event can arrive from thousands of phones at once, so reports are rate
+// limited per IP, only samplePercent of them are kept, and they are sent
+// to the sink in the background, dropping any that arrive while its queue
+// is full.
+type ClientErrors struct {
+	sink          ClientErrorSink
+	samplePercent int
+	reports       chan ClientErrorReport
+}
+
+func NewClientErrors(sink ClientErrorSink, samplePercent int) *ClientErrors {
+	return &ClientErrors{
+		sink:          sink,
+		samplePercent: samplePercent,
+		reports:       make(chan ClientErrorReport, clientErrorQueueSize),
+	}
+}
+
+// truncate cuts s to at most n bytes without splitting a UTF-8 sequence.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return strings.ToValidUTF8(s[:n], "")
+}
+
+// Report handles POST /api/client-errors. It answers 204 whether or not the
+// report is kept, so clients have nothing to retry.
+func (c *ClientErrors) Report(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, clientErrorMaxBytes+1))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Failed to read report")
+		return
+	}
+	if len(body) > clientErrorMaxBytes {
+		respondWithError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Report must be %d bytes or less", clientErrorMaxBytes))
+		return
+	}
+
+	var report ClientErrorReport
+	if err := json.Unmarshal(body, &report); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	report.Message = truncate(strings.TrimSpace(report.Message), clientErrorMaxMessage)
+	if report.Message == "" {
+		respondWithError(w, http.StatusBadRequest, "message is required")
+		return
+	}
+	report.Stack = truncate(report.Stack, clientErrorMaxStack)
+	report.URL = truncate(report.URL, clientErrorMaxURL)
+	report.AppVersion = truncate(report.AppVersion, clientErrorMaxVersion)
+	report.UserAgent = truncate(r.UserAgent(), clientErrorMaxAgent)
+	report.ReceivedAt = time.Now().UTC()
+
+	if c.sink != nil && rand.IntN(100) < c.samplePercent {
+		select {
+		case c.reports <- report:
+		default:
+			log.Printf("Client errors: queue full, dropping report")
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Run sends queued reports to the sink until ctx is cancelled.
+func (c *ClientErrors) Run(ctx context.Context) {
+	if c.sink == nil {
+		return
+	}
+	for {
+		select {
+		case report := <-c.reports:
+			if err := c.sink.Emit(ctx, report); err != nil {
+				log.Printf("Error sending client error report: %v", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
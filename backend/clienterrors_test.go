@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestClientErrorsReport(t *testing.T) {
+	tests := []struct {
+		name          string
+		body          string
+		samplePercent int
+		status        int
+		kept          bool
+	}{
+		{name: "kept", body: `{"message": "TypeError", "stack": "at app.js:1", "url": "/e/glasto", "app_version": "1.4.0"}`, samplePercent: 100, status: 204, kept: true},
+		{name: "sampled out", body: `{"message": "TypeError"}`, samplePercent: 0, status: 204},
+		{name: "no message", body: `{"stack": "at app.js:1"}`, samplePercent: 100, status: 400},
+		{name: "blank message", body: `{"message": "  "}`, samplePercent: 100, status: 400},
+		{name: "malformed", body: `{"message":`, samplePercent: 100, status: 400},
+		{name: "too large", body: `{"message": "` + strings.Repeat("x", clientErrorMaxBytes) + `"}`, samplePercent: 100, status: 413},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewClientErrors(LogClientErrorSink{}, tt.samplePercent)
+
+			rec := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "/api/client-errors", strings.NewReader(tt.body))
+			r.Header.Set("User-Agent", "Test/1.0")
+			c.Report(rec, r)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d (body %s)", rec.Code, tt.status, rec.Body)
+			}
+
+			if kept := len(c.reports) == 1; kept != tt.kept {
+				t.Fatalf("kept = %v, want %v", kept, tt.kept)
+			}
+			if tt.kept {
+				if report := <-c.reports; report.UserAgent != "Test/1.0" || report.AppVersion != "1.4.0" {
+					t.Errorf("report = %+v", report)
+				}
+			}
+		})
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	if got := truncate("héllo", 2); got != "h" || !utf8.ValidString(got) {
+		t.Errorf("truncate splitting é = %q, want %q", got, "h")
+	}
+	if got := truncate("hello", 10); got != "hello" {
+		t.Errorf("truncate short = %q", got)
+	}
+}
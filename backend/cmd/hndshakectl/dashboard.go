@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+)
+
+// dashboardMetrics is the part of GET /admin/metrics the dashboard shows.
+type dashboardMetrics struct {
+	WindowMinutes int `json:"window_minutes"`
+	Minutes       []struct {
+		Requests    int `json:"requests"`
+		Errors      int `json:"errors"`
+		RateLimited int `json:"rate_limited"`
+	} `json:"minutes"`
+	ErrorRate float64 `json:"error_rate"`
+	Latency   struct {
+		P50 int64 `json:"p50"`
+		P95 int64 `json:"p95"`
+		P99 int64 `json:"p99"`
+	} `json:"latency_ms"`
+	ModerationQueue int `json:"moderation_queue"`
+	WriteQueue      int `json:"write_queue"`
+}
+
+type dashboardPost struct {
+	PublicID  string    `json:"public_id"`
+	EventName string    `json:"event_name"`
+	Content   string    `json:"content"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+const dashboardPosts = 10
+
+// sparkBlocks draw a series scaled to its largest value.
+var sparkBlocks = []rune(" ▁▂▃▄▅▆▇█")
+
+// dashboard redraws live server metrics and the latest posts every
+// interval until interrupted. It polls rather than streams, and redraws
+// with plain ANSI escapes, so it works in any terminal without extra
+// dependencies.
+func (c *client) dashboard(args []string) error {
+	flags := flag.NewFlagSet("dashboard", flag.ContinueOnError)
+	interval := flags.Duration("interval", 5*time.Second, "time between refreshes")
+	event := flags.String("event", "", "only show posts on this event")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *interval < time.Second {
+		return fmt.Errorf("interval must be at least 1s")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+	for {
+		// Clear the screen and home the cursor
+		fmt.Fprint(c.out, "\033[H\033[2J")
+		if err := c.drawDashboard(*event, time.Now()); err != nil {
+			fmt.Fprintf(c.out, "Error: %v\n", err)
+		}
+		fmt.Fprintf(c.out, "\nRefreshing every %s; Ctrl-C to quit\n", *interval)
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (c *client) drawDashboard(event string, now time.Time) error {
+	data, err := c.do("GET", "/admin/metrics", nil)
+	if err != nil {
+		return err
+	}
+	var m dashboardMetrics
+	if err := json.Unmarshal(data, &m); err != nil {
+		return err
+	}
+
+	query := url.Values{"limit": {fmt.Sprint(dashboardPosts)}}
+	if event != "" {
+		query.Set("event", event)
+	}
+	data, err = c.do("GET", "/api/posts?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	var posts []dashboardPost
+	if err := json.Unmarshal(data, &posts); err != nil {
+		return err
+	}
+
+	renderDashboard(c.out, c.baseURL, m, posts, now)
+	return nil
+}
+
+func renderDashboard(w io.Writer, server string, m dashboardMetrics, posts []dashboardPost, now time.Time) {
+	requests := make([]int, len(m.Minutes))
+	rateLimited := make([]int, len(m.Minutes))
+	var totalRequests, totalLimited int
+	for i, minute := range m.Minutes {
+		requests[i], rateLimited[i] = minute.Requests, minute.RateLimited
+		totalRequests += minute.Requests
+		totalLimited += minute.RateLimited
+	}
+	current := 0
+	if len(requests) > 0 {
+		current = requests[len(requests)-1]
+	}
+
+	fmt.Fprintf(w, "hndshake %s  %s\n\n", server, now.Format("15:04:05"))
+	fmt.Fprintf(w, "Requests/min   %s  %d now, %d in %dm\n", sparkline(requests), current, totalRequests, m.WindowMinutes)
+	fmt.Fprintf(w, "Rate limited   %s  %d in %dm\n", sparkline(rateLimited), totalLimited, m.WindowMinutes)
+	fmt.Fprintf(w, "Errors         %.1f%%\n", m.ErrorRate*100)
+	fmt.Fprintf(w, "Latency        p50 %dms  p95 %dms  p99 %dms\n", m.Latency.P50, m.Latency.P95, m.Latency.P99)
+	fmt.Fprintf(w, "Moderation     %d open\n", m.ModerationQueue)
+	fmt.Fprintf(w, "Write queue    %d waiting\n", m.WriteQueue)
+
+	fmt.Fprintf(w, "\nRecent posts\n")
+	if len(posts) == 0 {
+		fmt.Fprintln(w, "  (none)")
+	}
+	for _, post := range posts {
+		fmt.Fprintf(w, "  %s  %-10s  %-20s  %s\n",
+			post.CreatedAt.Local().Format("15:04"), post.PublicID, truncate(post.EventName, 20), cell(post.Content))
+	}
+}
+
+// sparkline draws one block per value, scaled so the largest is full height.
+func sparkline(values []int) string {
+	peak := 0
+	for _, v := range values {
+		peak = max(peak, v)
+	}
+	var b strings.Builder
+	for _, v := range values {
+		i := 0
+		if peak > 0 {
+			i = (v*(len(sparkBlocks)-1) + peak - 1) / peak
+		}
+		b.WriteRune(sparkBlocks[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,523 @@
+// Command hndshakectl runs common admin operations against a Handshake
+// server's API, so ops don't need curl and hand-built auth headers.
+//
+// The server and admin token come from HNDSHAKE_URL and
+// HNDSHAKE_ADMIN_TOKEN, or the -url and -token flags. Results print as a
+// table, or as the API's JSON with -json.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const usage = `Usage: hndshakectl [-url URL] [-token TOKEN] [-json] <command> [args]
+
+Commands:
+  posts list [-event NAME] [-limit N]       list recent visible posts
+  posts show ID                             show a post with its moderation context
+  posts hide [-reason-id N] [-dry-run] ID...
+  posts delete [-dry-run] ID...
+  posts approve [-dry-run] ID...
+  posts ban-authors [-reason TEXT] [-dry-run] ID...
+  queue [-resolved]                         list the moderation queue
+  bans list                                 list banned hashes
+  bans add [-reason TEXT] HASH              ban an IP or phone hash
+  bans remove HASH                          lift a ban
+  events list                               list events
+  events show NAME                          show an event
+  events rename NAME NEW_NAME
+  events retention NAME CLASS               set an event's retention class
+  jobs list                                 list background jobs
+  jobs run NAME                             run a background job now
+  rate-limits [-key HASH | -ip ADDRESS]     show rate limits, and a key's usage
+  dashboard [-interval 5s] [-event NAME]    watch live traffic, moderation and posts
+`
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "hndshakectl: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("hndshakectl", flag.ContinueOnError)
+	flags.Usage = func() { fmt.Fprint(flags.Output(), usage) }
+	baseURL := flags.String("url", envOr("HNDSHAKE_URL", "http://localhost:8080"), "server URL")
+	token := flags.String("token", os.Getenv("HNDSHAKE_ADMIN_TOKEN"), "admin token")
+	asJSON := flags.Bool("json", false, "print JSON instead of a table")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return fmt.Errorf("no command given")
+	}
+
+	c := &client{
+		baseURL: strings.TrimRight(*baseURL, "/"),
+		token:   *token,
+		http:    &http.Client{Timeout: 30 * time.Second},
+		out:     out,
+		json:    *asJSON,
+	}
+
+	cmd, rest := flags.Arg(0), flags.Args()[1:]
+	switch cmd {
+	case "posts":
+		return c.posts(rest)
+	case "queue":
+		return c.queue(rest)
+	case "bans":
+		return c.bans(rest)
+	case "events":
+		return c.events(rest)
+	case "jobs":
+		return c.jobs(rest)
+	case "rate-limits":
+		return c.rateLimits(rest)
+	case "dashboard":
+		return c.dashboard(rest)
+	default:
+		flags.Usage()
+		return fmt.Errorf("unknown command %q", cmd)
+	}
+}
+
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// client calls the API and prints what it returns.
+type client struct {
+	baseURL string
+	token   string
+	http    *http.Client
+	out     io.Writer
+	json    bool
+}
+
+// do sends a request with the admin token, returning the response body.
+// Error responses become errors carrying the API's message.
+func (c *client) do(method, path string, body interface{}) ([]byte, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, c.baseURL+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 400 {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(data, &apiErr) == nil && apiErr.Error != "" {
+			return nil, fmt.Errorf("%s %s: %s (%d)", method, path, apiErr.Error, resp.StatusCode)
+		}
+		return nil, fmt.Errorf("%s %s: %s", method, path, resp.Status)
+	}
+	return data, nil
+}
+
+// print writes a response as indented JSON, or as a table of the given
+// columns. Columns are JSON field names; a dotted name reaches into a
+// nested object. A response that is a single object prints as one row.
+func (c *client) print(data []byte, columns ...string) error {
+	if len(data) == 0 {
+		return nil
+	}
+	if c.json {
+		var buf bytes.Buffer
+		if err := json.Indent(&buf, data, "", "  "); err != nil {
+			return err
+		}
+		buf.WriteByte('\n')
+		_, err := c.out.Write(buf.Bytes())
+		return err
+	}
+
+	var rows []map[string]interface{}
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		if err := json.Unmarshal(data, &rows); err != nil {
+			return err
+		}
+	} else {
+		var row map[string]interface{}
+		if err := json.Unmarshal(data, &row); err != nil {
+			return err
+		}
+		rows = append(rows, row)
+	}
+	return writeTable(c.out, rows, columns)
+}
+
+func writeTable(out io.Writer, rows []map[string]interface{}, columns []string) error {
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.ToUpper(strings.Join(columns, "\t")))
+	for _, row := range rows {
+		cells := make([]string, len(columns))
+		for i, col := range columns {
+			cells[i] = cell(lookup(row, col))
+		}
+		fmt.Fprintln(tw, strings.Join(cells, "\t"))
+	}
+	return tw.Flush()
+}
+
+func lookup(row map[string]interface{}, path string) interface{} {
+	var v interface{} = row
+	for _, key := range strings.Split(path, ".") {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		v = m[key]
+	}
+	return v
+}
+
+// maxCellWidth keeps long post content from stretching every row.
+const maxCellWidth = 60
+
+func cell(v interface{}) string {
+	var s string
+	switch v := v.(type) {
+	case nil:
+		return "-"
+	case string:
+		s = v
+	case float64:
+		s = strconv.FormatFloat(v, 'f', -1, 64)
+	case []interface{}:
+		parts := make([]string, len(v))
+		for i, item := range v {
+			parts[i] = cell(item)
+		}
+		s = strings.Join(parts, ",")
+	default:
+		data, _ := json.Marshal(v)
+		s = string(data)
+	}
+	return truncate(s, maxCellWidth)
+}
+
+// truncate puts s on one line of at most n characters.
+func truncate(s string, n int) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if runes := []rune(s); len(runes) > n {
+		s = string(runes[:n-1]) + "…"
+	}
+	return s
+}
+
+// subcommand splits a subcommand from its arguments.
+func subcommand(args []string, group string) (string, []string, error) {
+	if len(args) == 0 {
+		return "", nil, fmt.Errorf("%s needs a subcommand", group)
+	}
+	return args[0], args[1:], nil
+}
+
+func parsePostIDs(args []string) ([]int, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("no post IDs given")
+	}
+	ids := make([]int, len(args))
+	for i, arg := range args {
+		id, err := strconv.Atoi(arg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid post ID %q", arg)
+		}
+		ids[i] = id
+	}
+	return ids, nil
+}
+
+func (c *client) posts(args []string) error {
+	sub, args, err := subcommand(args, "posts")
+	if err != nil {
+		return err
+	}
+
+	flags := flag.NewFlagSet("posts "+sub, flag.ContinueOnError)
+	switch sub {
+	case "list":
+		event := flags.String("event", "", "only posts on this event")
+		limit := flags.Int("limit", 50, "number of posts")
+		if err := flags.Parse(args); err != nil {
+			return err
+		}
+		query := url.Values{"limit": {strconv.Itoa(*limit)}}
+		if *event != "" {
+			query.Set("event", *event)
+		}
+		data, err := c.do("GET", "/api/posts?"+query.Encode(), nil)
+		if err != nil {
+			return err
+		}
+		return c.print(data, "id", "public_id", "event_name", "created_at", "content")
+
+	case "show":
+		if err := flags.Parse(args); err != nil {
+			return err
+		}
+		if flags.NArg() != 1 {
+			return fmt.Errorf("usage: posts show ID")
+		}
+		data, err := c.do("GET", "/admin/posts/"+url.PathEscape(flags.Arg(0))+"/context", nil)
+		if err != nil {
+			return err
+		}
+		return c.print(data, "post.id", "post.public_id", "post.event_name", "post.created_at", "post.hidden_at", "post.content")
+
+	case "hide", "delete", "approve", "ban-authors":
+		dryRun := flags.Bool("dry-run", false, "report what would change without changing it")
+		var reasonID *int
+		var reason *string
+		if sub == "hide" {
+			reasonID = flags.Int("reason-id", 0, "removal reason shown to the authors")
+		}
+		if sub == "ban-authors" {
+			reason = flags.String("reason", "", "why the authors are banned")
+		}
+		if err := flags.Parse(args); err != nil {
+			return err
+		}
+		ids, err := parsePostIDs(flags.Args())
+		if err != nil {
+			return err
+		}
+
+		req := map[string]interface{}{"action": sub, "post_ids": ids, "dry_run": *dryRun}
+		if reasonID != nil && *reasonID != 0 {
+			req["reason_id"] = *reasonID
+		}
+		if reason != nil {
+			req["reason"] = *reason
+		}
+		data, err := c.do("POST", "/admin/posts/bulk", req)
+		if err != nil {
+			return err
+		}
+		return c.print(data, "action", "dry_run", "matched", "affected", "missing", "held", "resolved")
+
+	default:
+		return fmt.Errorf("unknown posts subcommand %q", sub)
+	}
+}
+
+func (c *client) queue(args []string) error {
+	flags := flag.NewFlagSet("queue", flag.ContinueOnError)
+	resolved := flags.Bool("resolved", false, "list resolved items instead of open ones")
+	limit := flags.Int("limit", 50, "number of items")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	query := url.Values{"limit": {strconv.Itoa(*limit)}}
+	if *resolved {
+		query.Set("status", "resolved")
+	}
+	data, err := c.do("GET", "/admin/moderation/queue?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	return c.print(data, "id", "post.id", "reason", "source", "created_at", "resolution", "post.content")
+}
+
+func (c *client) bans(args []string) error {
+	sub, args, err := subcommand(args, "bans")
+	if err != nil {
+		return err
+	}
+
+	flags := flag.NewFlagSet("bans "+sub, flag.ContinueOnError)
+	columns := []string{"ip_hash", "banned_by", "created_at", "reason"}
+	switch sub {
+	case "list":
+		limit := flags.Int("limit", 100, "number of bans")
+		if err := flags.Parse(args); err != nil {
+			return err
+		}
+		data, err := c.do("GET", "/admin/bans?limit="+strconv.Itoa(*limit), nil)
+		if err != nil {
+			return err
+		}
+		return c.print(data, columns...)
+
+	case "add":
+		reason := flags.String("reason", "", "why the hash is banned")
+		if err := flags.Parse(args); err != nil {
+			return err
+		}
+		if flags.NArg() != 1 {
+			return fmt.Errorf("usage: bans add [-reason TEXT] HASH")
+		}
+		data, err := c.do("POST", "/admin/bans", map[string]string{"ip_hash": flags.Arg(0), "reason": *reason})
+		if err != nil {
+			return err
+		}
+		return c.print(data, columns...)
+
+	case "remove":
+		if err := flags.Parse(args); err != nil {
+			return err
+		}
+		if flags.NArg() != 1 {
+			return fmt.Errorf("usage: bans remove HASH")
+		}
+		if _, err := c.do("DELETE", "/admin/bans/"+url.PathEscape(flags.Arg(0)), nil); err != nil {
+			return err
+		}
+		if !c.json {
+			fmt.Fprintf(c.out, "Unbanned %s\n", flags.Arg(0))
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("unknown bans subcommand %q", sub)
+	}
+}
+
+func (c *client) events(args []string) error {
+	sub, args, err := subcommand(args, "events")
+	if err != nil {
+		return err
+	}
+
+	columns := []string{"id", "name", "slug", "post_count", "retention_class", "archived_at"}
+	switch sub {
+	case "list":
+		data, err := c.do("GET", "/api/events", nil)
+		if err != nil {
+			return err
+		}
+		return c.print(data, "name", "slug", "post_count", "last_post_at", "starts_at", "ends_at")
+
+	case "show":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: events show NAME")
+		}
+		data, err := c.do("GET", "/api/events/"+url.PathEscape(args[0]), nil)
+		if err != nil {
+			return err
+		}
+		return c.print(data, columns...)
+
+	case "rename":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: events rename NAME NEW_NAME")
+		}
+		data, err := c.do("POST", "/admin/events/"+url.PathEscape(args[0])+"/rename", map[string]string{"name": args[1]})
+		if err != nil {
+			return err
+		}
+		return c.print(data, columns...)
+
+	case "retention":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: events retention NAME CLASS")
+		}
+		data, err := c.do("PUT", "/admin/events/"+url.PathEscape(args[0])+"/retention", map[string]string{"retention_class": args[1]})
+		if err != nil {
+			return err
+		}
+		return c.print(data, columns...)
+
+	default:
+		return fmt.Errorf("unknown events subcommand %q", sub)
+	}
+}
+
+func (c *client) jobs(args []string) error {
+	sub, args, err := subcommand(args, "jobs")
+	if err != nil {
+		return err
+	}
+
+	columns := []string{"name", "running", "started_at", "finished_at"}
+	switch sub {
+	case "list":
+		data, err := c.do("GET", "/admin/jobs", nil)
+		if err != nil {
+			return err
+		}
+		return c.print(data, columns...)
+
+	case "run":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: jobs run NAME")
+		}
+		data, err := c.do("POST", "/admin/jobs/"+url.PathEscape(args[0]), nil)
+		if err != nil {
+			return err
+		}
+		return c.print(data, columns...)
+
+	default:
+		return fmt.Errorf("unknown jobs subcommand %q", sub)
+	}
+}
+
+func (c *client) rateLimits(args []string) error {
+	flags := flag.NewFlagSet("rate-limits", flag.ContinueOnError)
+	key := flags.String("key", "", "IP hash to show usage for")
+	ip := flags.String("ip", "", "address to show usage for")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	query := url.Values{}
+	if *key != "" {
+		query.Set("key", *key)
+	}
+	if *ip != "" {
+		query.Set("ip", *ip)
+	}
+	path := "/admin/rate-limits"
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+	data, err := c.do("GET", path, nil)
+	if err != nil {
+		return err
+	}
+	return c.print(data, "name", "algorithm", "requests", "window_minutes", "burst", "remaining")
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRun(t *testing.T) {
+	var got struct {
+		method, path, auth string
+		body               map[string]interface{}
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got.method, got.path, got.auth = r.Method, r.URL.RequestURI(), r.Header.Get("Authorization")
+		got.body = nil
+		json.NewDecoder(r.Body).Decode(&got.body)
+
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/admin/bans":
+			w.Write([]byte(`[{"ip_hash": "abc", "banned_by": "admin", "created_at": "2024-01-01T00:00:00Z"}]`))
+		case "/admin/posts/bulk":
+			w.Write([]byte(`{"action": "hide", "dry_run": true, "matched": 2, "missing": [9], "affected": 2}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error": "Job not found"}`))
+		}
+	}))
+	defer server.Close()
+
+	base := []string{"-url", server.URL, "-token", "secret"}
+
+	var out bytes.Buffer
+	if err := run(append(base, "bans", "list"), &out); err != nil {
+		t.Fatal(err)
+	}
+	if got.method != "GET" || got.path != "/admin/bans?limit=100" || got.auth != "Bearer secret" {
+		t.Errorf("request = %s %s (%q)", got.method, got.path, got.auth)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "IP_HASH") || !strings.Contains(lines[1], "admin") || !strings.HasSuffix(lines[1], "-") {
+		t.Errorf("table =\n%s", out.String())
+	}
+
+	out.Reset()
+	if err := run(append(base, "-json", "posts", "hide", "-dry-run", "-reason-id", "4", "1", "2"), &out); err != nil {
+		t.Fatal(err)
+	}
+	if got.body["action"] != "hide" || got.body["dry_run"] != true || got.body["reason_id"] != float64(4) || len(got.body["post_ids"].([]interface{})) != 2 {
+		t.Errorf("body = %v", got.body)
+	}
+	if !strings.Contains(out.String(), `"matched": 2`) {
+		t.Errorf("JSON output = %s", out.String())
+	}
+
+	err := run(append(base, "jobs", "run", "nope"), &out)
+	if err == nil || !strings.Contains(err.Error(), "Job not found") {
+		t.Errorf("error = %v, want the API's message", err)
+	}
+
+	if err := run(append(base, "posts", "hide", "x"), &out); err == nil {
+		t.Error("invalid post ID accepted")
+	}
+}
+
+func TestCell(t *testing.T) {
+	tests := []struct {
+		in   interface{}
+		want string
+	}{
+		{nil, "-"},
+		{float64(12), "12"},
+		{true, "true"},
+		{[]interface{}{float64(1), float64(2)}, "1,2"},
+		{"two\nlines", "two lines"},
+		{strings.Repeat("x", 100), strings.Repeat("x", maxCellWidth-1) + "…"},
+	}
+	for _, tt := range tests {
+		if got := cell(tt.in); got != tt.want {
+			t.Errorf("cell(%v) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSparkline(t *testing.T) {
+	tests := []struct {
+		in   []int
+		want string
+	}{
+		{[]int{0, 0, 0}, "   "},
+		{[]int{0, 1, 8}, " ▁█"},
+		{[]int{2, 4}, "▄█"},
+	}
+	for _, tt := range tests {
+		if got := sparkline(tt.in); got != tt.want {
+			t.Errorf("sparkline(%v) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestRenderDashboard(t *testing.T) {
+	var m dashboardMetrics
+	if err := json.Unmarshal([]byte(`{
+		"window_minutes": 3,
+		"minutes": [{"requests": 4}, {"requests": 0}, {"requests": 8, "rate_limited": 2}],
+		"error_rate": 0.125,
+		"latency_ms": {"p50": 5, "p95": 50, "p99": 250},
+		"moderation_queue": 7,
+		"write_queue": 1
+	}`), &m); err != nil {
+		t.Fatal(err)
+	}
+	posts := []dashboardPost{{PublicID: "abc123defg", EventName: "Launch Party", Content: "Hello\nthere"}}
+
+	var out bytes.Buffer
+	renderDashboard(&out, "http://localhost", m, posts, time.Now())
+	for _, want := range []string{"▄ █  8 now, 12 in 3m", "  █  2 in 3m", "12.5%", "p95 50ms", "7 open", "1 waiting", "abc123defg", "Hello there"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("dashboard missing %q:\n%s", want, out.String())
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const maxCommentLength = 1000
+
+var errCommentParentNotFound = errors.New("parent comment not found")
+
+// Comment is a reply to a post, made on the web or federated in from the
+// fediverse. Comments form threads through ParentID; they are listed
+// oldest first, so a parent always comes before its replies.
+type Comment struct {
+	// ID is the comment's UUID
+	ID string `json:"id"`
+	// ParentID is the comment this one answers, if any
+	ParentID string `json:"parent_id,omitempty"`
+	Content  string `json:"content"`
+	// Source is "web" or "activitypub"
+	Source    string    `json:"source"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type CreateCommentRequest struct {
+	Content  string `json:"content"`
+	ParentID string `json:"parent_id"`
+}
+
+func validateCommentContent(content string) error {
+	if content == "" {
+		return &ValidationError{Message: "content is required"}
+	}
+	if len(content) > maxCommentLength {
+		return &ValidationError{Message: fmt.Sprintf("content must be %d characters or less", maxCommentLength)}
+	}
+	return nil
+}
+
+// CreateComment handles POST /api/posts/{id}/comments. The post's device
+// is notified, as with federated replies, unless the comment was made with
+// the same X-Device-Token.
+func (h *Handler) CreateComment(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid post ID")
+		return
+	}
+
+	var req CreateCommentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	req.Content = strings.TrimSpace(req.Content)
+	if err := validateCommentContent(req.Content); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	req.ParentID = strings.ToLower(req.ParentID)
+	if req.ParentID != "" && !uuidV7Pattern.MatchString(req.ParentID) {
+		respondWithError(w, http.StatusBadRequest, "parent_id must be the ID of a comment")
+		return
+	}
+
+	var tokenHash string
+	if r.Header.Get("X-Device-Token") != "" {
+		var ok bool
+		if tokenHash, ok = deviceToken(w, r); !ok {
+			return
+		}
+	}
+
+	comment, err := h.db.CreateComment(r.Context(), id, req, hashIP(getIP(r)), tokenHash)
+	if errors.Is(err, errCommentParentNotFound) {
+		respondWithError(w, http.StatusBadRequest, "parent_id is not a comment on this post")
+		return
+	}
+	if err != nil {
+		log.Printf("Error creating comment: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to create comment")
+		return
+	}
+	if comment == nil {
+		respondWithError(w, http.StatusNotFound, "Post not found")
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, comment)
+}
+
+// GetComments handles GET /api/posts/{id}/comments, oldest first.
+func (h *Handler) GetComments(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid post ID")
+		return
+	}
+	limit, offset := parsePagination(r)
+
+	comments, found, err := h.db.GetComments(r.Context(), id, limit, offset)
+	if err != nil {
+		log.Printf("Error getting comments: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve comments")
+		return
+	}
+	if !found {
+		respondWithError(w, http.StatusNotFound, "Post not found")
+		return
+	}
+
+	if comments == nil {
+		comments = []Comment{}
+	}
+
+	respondWithJSON(w, http.StatusOK, comments)
+}
+
+// CreateComment stores a web comment on a visible post, counting it in the
+// post's reply_count and notifying the post's device unless it is
+// commenterDevice. It returns nil if there is no such post, and
+// errCommentParentNotFound if the parent isn't a comment on the post.
+func (db *DB) CreateComment(ctx context.Context, postID int, req CreateCommentRequest, ipHash, commenterDevice string) (*Comment, error) {
+	var postExists, parentExists bool
+	var id sql.NullString
+	var createdAt sql.NullTime
+	err := db.conn.QueryRowContext(ctx, `
+		WITH post AS (
+			SELECT id FROM posts WHERE id = $1 AND hidden_at IS NULL
+		), parent AS (
+			SELECT id FROM replies WHERE uuid = NULLIF($3, '')::uuid AND post_id = $1
+		), reply AS (
+			INSERT INTO replies (post_id, parent_id, content, source, ip_hash)
+			SELECT post.id, (SELECT id FROM parent), $2, 'web', $4
+			FROM post
+			WHERE $3 = '' OR EXISTS (SELECT 1 FROM parent)
+			RETURNING id, uuid, post_id, created_at
+		), counted AS (
+			UPDATE posts SET reply_count = reply_count + 1
+			FROM reply
+			WHERE posts.id = reply.post_id
+			RETURNING posts.device_token_hash, reply.post_id, reply.id AS reply_id
+		), notified AS (
+			INSERT INTO notifications (device_token_hash, kind, post_id, reply_id)
+			SELECT device_token_hash, $6, post_id, reply_id
+			FROM counted
+			WHERE device_token_hash IS NOT NULL AND device_token_hash <> $5
+		)
+		SELECT EXISTS (SELECT 1 FROM post), EXISTS (SELECT 1 FROM parent),
+			(SELECT uuid::text FROM reply), (SELECT created_at FROM reply)
+	`, postID, req.Content, req.ParentID, ipHash, commenterDevice, NotificationReply).Scan(&postExists, &parentExists, &id, &createdAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create comment: %w", err)
+	}
+
+	switch {
+	case !postExists:
+		return nil, nil
+	case req.ParentID != "" && !parentExists:
+		return nil, errCommentParentNotFound
+	}
+
+	return &Comment{
+		ID:        id.String,
+		ParentID:  req.ParentID,
+		Content:   req.Content,
+		Source:    "web",
+		CreatedAt: createdAt.Time,
+	}, nil
+}
+
+// GetComments returns a page of a visible post's comments, oldest first,
+// reporting false if there is no such post.
+func (db *DB) GetComments(ctx context.Context, postID, limit, offset int) ([]Comment, bool, error) {
+	var found bool
+	err := db.reader(ctx).QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM posts WHERE id = $1 AND hidden_at IS NULL)
+	`, postID).Scan(&found)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to check post: %w", err)
+	}
+	if !found {
+		return nil, false, nil
+	}
+
+	rows, err := db.reader(ctx).QueryContext(ctx, `
+		SELECT r.uuid::text, COALESCE(p.uuid::text, ''), r.content, r.source, r.created_at
+		FROM replies r
+		LEFT JOIN replies p ON p.id = r.parent_id
+		WHERE r.post_id = $1
+		ORDER BY r.created_at, r.id
+		LIMIT $2 OFFSET $3
+	`, postID, limit, offset)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to query comments: %w", err)
+	}
+	defer rows.Close()
+
+	var comments []Comment
+	for rows.Next() {
+		var c Comment
+		if err := rows.Scan(&c.ID, &c.ParentID, &c.Content, &c.Source, &c.CreatedAt); err != nil {
+			return nil, false, fmt.Errorf("failed to scan comment: %w", err)
+		}
+		comments = append(comments, c)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, false, fmt.Errorf("error iterating comments: %w", err)
+	}
+
+	return comments, true, nil
+}
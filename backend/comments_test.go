@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCreateCommentValidation(t *testing.T) {
+	h := newTestHandler(newFakeStore(), HandlerConfig{})
+
+	tests := []struct {
+		body string
+		want string
+	}{
+		{`{"content":"   "}`, "content is required"},
+		{`{"content":"` + strings.Repeat("a", maxCommentLength+1) + `"}`, "content must be 1000 characters or less"},
+		{`{"content":"hi","parent_id":"a000000001"}`, "parent_id must be the ID of a comment"},
+		{`not json`, "Invalid request body"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/api/posts/1/comments", strings.NewReader(tt.body))
+		req.SetPathValue("id", "1")
+		rec := httptest.NewRecorder()
+		h.CreateComment(rec, req)
+		assertError(t, rec, http.StatusBadRequest, tt.want)
+	}
+}
+
+// TestComments checks comments thread, count towards reply_count and
+// notify the poster, but not when the poster comments themselves.
+func TestComments(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	event := fmt.Sprintf("Comments Test %d", time.Now().UnixNano())
+	device := hashToken(event + " device")
+
+	post, err := db.CreatePost(ctx, CreatePostRequest{EventName: event, Content: "hello", Age: 25, Location: "x", DeviceTokenHash: device}, "comments-test", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := db.CreateComment(ctx, post.ID, CreateCommentRequest{Content: "Was it you?"}, "reader", "")
+	if err != nil || first == nil {
+		t.Fatalf("first comment = %v, %v", first, err)
+	}
+	reply, err := db.CreateComment(ctx, post.ID, CreateCommentRequest{Content: "It was!", ParentID: first.ID}, "poster", device)
+	if err != nil || reply == nil {
+		t.Fatalf("reply = %v, %v", reply, err)
+	}
+
+	if _, err := db.CreateComment(ctx, post.ID, CreateCommentRequest{Content: "hi", ParentID: "0190a6f0-0000-7000-8000-000000000000"}, "reader", ""); err != errCommentParentNotFound {
+		t.Errorf("reply to a missing comment: err = %v, want errCommentParentNotFound", err)
+	}
+	if c, err := db.CreateComment(ctx, 0, CreateCommentRequest{Content: "hi"}, "reader", ""); err != nil || c != nil {
+		t.Errorf("comment on a missing post = %v, %v; want nil", c, err)
+	}
+
+	comments, found, err := db.GetComments(ctx, post.ID, 50, 0)
+	if err != nil || !found {
+		t.Fatalf("GetComments = %v, %v", found, err)
+	}
+	if len(comments) != 2 || comments[0].ID != first.ID || comments[1].ParentID != first.ID {
+		t.Errorf("comments = %+v, want the reply threaded under the first", comments)
+	}
+
+	got, err := db.GetPostByID(ctx, post.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ReplyCount != 2 {
+		t.Errorf("ReplyCount = %d, want 2", got.ReplyCount)
+	}
+
+	notifications, err := db.GetNotifications(ctx, device, false, 50, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(notifications) != 1 || notifications[0].Content != "Was it you?" {
+		t.Errorf("notifications = %+v, want only the reader's comment", notifications)
+	}
+}
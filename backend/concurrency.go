@@ -0,0 +1,185 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// concurrencyWindow is how long the fastest recent write is kept as
+	// the baseline; the baseline is the fastest of this window and the last
+	concurrencyWindow = 30 * time.Second
+	// concurrencyTolerance is how many times slower than the baseline a
+	// write can be before the limit backs off
+	concurrencyTolerance = 2.0
+	// concurrencyBackoff is what the limit is multiplied by when it backs off
+	concurrencyBackoff = 0.75
+
+	overloadedCode = "overloaded"
+)
+
+// ConcurrencyLimiter caps how many writes are in flight at once, adapting
+// the cap to how fast writes complete. While they complete near the fastest
+// recently seen, the cap grows by one for each cap's worth of writes; when
+// they slow to concurrencyTolerance times that, it shrinks by
+// concurrencyBackoff, at most once per slow write's duration. Writes over
+// the cap wait in turn for up to maxWait and are then refused with a 503,
+// so the burst after the headline act ends reaches the database at the
+// rate it can take rather than all at once.
+type ConcurrencyLimiter struct {
+	min, max int
+	maxWait  time.Duration
+
+	mu       sync.Mutex
+	limit    float64
+	inFlight int
+	// waiters are the writes waiting for a slot, in arrival order. A
+	// waiter's channel is closed when it is given one.
+	waiters []chan struct{}
+	// fastest is the fastest write this window, and previousFastest that of
+	// the last window
+	fastest         time.Duration
+	previousFastest time.Duration
+	windowStart     time.Time
+	lastBackoff     time.Time
+}
+
+// NewConcurrencyLimiter returns nil, which limits nothing, when max is 0.
+// The limit starts at max and never drops below min.
+func NewConcurrencyLimiter(minLimit, maxLimit int, maxWait time.Duration) *ConcurrencyLimiter {
+	if maxLimit <= 0 {
+		return nil
+	}
+	minLimit = min(max(minLimit, 1), maxLimit)
+	return &ConcurrencyLimiter{min: minLimit, max: maxLimit, maxWait: maxWait, limit: float64(maxLimit), windowStart: time.Now()}
+}
+
+// acquire waits for a slot, reporting false if none came free within
+// maxWait or the request was cancelled. The slot must be given back with
+// release.
+func (c *ConcurrencyLimiter) acquire(r *http.Request) bool {
+	c.mu.Lock()
+	if len(c.waiters) == 0 && c.inFlight < int(c.limit) {
+		c.inFlight++
+		c.mu.Unlock()
+		return true
+	}
+	granted := make(chan struct{})
+	c.waiters = append(c.waiters, granted)
+	c.mu.Unlock()
+
+	timer := time.NewTimer(c.maxWait)
+	defer timer.Stop()
+	select {
+	case <-granted:
+		return true
+	case <-timer.C:
+	case <-r.Context().Done():
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, waiter := range c.waiters {
+		if waiter == granted {
+			c.waiters = append(c.waiters[:i], c.waiters[i+1:]...)
+			return false
+		}
+	}
+	// Given a slot while giving up
+	return true
+}
+
+// release gives back a slot held for a write that took latency, adjusts
+// the limit and hands free slots to waiting writes.
+func (c *ConcurrencyLimiter) release(latency time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.inFlight--
+	c.observe(latency, time.Now())
+	for len(c.waiters) > 0 && c.inFlight < int(c.limit) {
+		close(c.waiters[0])
+		c.waiters = c.waiters[1:]
+		c.inFlight++
+	}
+}
+
+// observe adjusts the limit for a write that took latency. The caller
+// holds c.mu.
+func (c *ConcurrencyLimiter) observe(latency time.Duration, now time.Time) {
+	if now.Sub(c.windowStart) >= concurrencyWindow {
+		c.previousFastest, c.fastest, c.windowStart = c.fastest, 0, now
+	}
+	if c.fastest == 0 || latency < c.fastest {
+		c.fastest = latency
+	}
+	baseline := c.fastest
+	if c.previousFastest > 0 && c.previousFastest < baseline {
+		baseline = c.previousFastest
+	}
+
+	if float64(latency) > concurrencyTolerance*float64(baseline) {
+		// The writes that were already in flight are slow for the same
+		// reason, so back off once per round of them
+		if now.Sub(c.lastBackoff) >= latency {
+			c.limit = max(c.limit*concurrencyBackoff, float64(c.min))
+			c.lastBackoff = now
+		}
+		return
+	}
+	// Only grow a limit that is being used
+	if float64(c.inFlight+1) >= c.limit/2 {
+		c.limit = min(c.limit+1/c.limit, float64(c.max))
+	}
+}
+
+// Limit applies the limiter to a route's writes; reads pass straight
+// through.
+func (c *ConcurrencyLimiter) Limit(next http.Handler) http.Handler {
+	if c == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if !c.acquire(r) {
+			w.Header().Set("Retry-After", "1")
+			respondWithJSON(w, http.StatusServiceUnavailable, RateLimitedError{
+				Error:             "We're receiving a lot of posts right now. Please try again in a moment.",
+				Code:              overloadedCode,
+				RetryAfterSeconds: 1,
+			})
+			return
+		}
+		start := time.Now()
+		defer func() { c.release(time.Since(start)) }()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ConcurrencyStats is the limiter's state, for the admin metrics.
+type ConcurrencyStats struct {
+	Limit    int `json:"limit"`
+	InFlight int `json:"in_flight"`
+	Waiting  int `json:"waiting"`
+	// BaselineMs is the fastest recent write, which the limit's latency
+	// tolerance is measured from
+	BaselineMs int64 `json:"baseline_ms"`
+}
+
+func (c *ConcurrencyLimiter) stats() *ConcurrencyStats {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	baseline := c.fastest
+	if c.previousFastest > 0 && c.previousFastest < baseline {
+		baseline = c.previousFastest
+	}
+	return &ConcurrencyStats{Limit: int(c.limit), InFlight: c.inFlight, Waiting: len(c.waiters), BaselineMs: baseline.Milliseconds()}
+}
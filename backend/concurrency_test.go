@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestConcurrencyLimiterAdapts(t *testing.T) {
+	c := NewConcurrencyLimiter(2, 10, time.Second)
+	now := time.Now()
+
+	// Fast writes keep a full limit
+	c.inFlight = 9
+	for i := 0; i < 5; i++ {
+		c.observe(10*time.Millisecond, now)
+	}
+	if c.limit != 10 {
+		t.Fatalf("limit = %v after fast writes, want 10", c.limit)
+	}
+
+	// A slow write backs off once for its round, not once per write
+	c.observe(50*time.Millisecond, now)
+	c.observe(50*time.Millisecond, now.Add(time.Millisecond))
+	if c.limit != 7.5 {
+		t.Fatalf("limit = %v after a slow round, want 7.5", c.limit)
+	}
+	for i := 1; i <= 10; i++ {
+		c.observe(50*time.Millisecond, now.Add(time.Duration(i)*50*time.Millisecond))
+	}
+	if c.limit != 2 {
+		t.Errorf("limit = %v after sustained slow writes, want the minimum 2", c.limit)
+	}
+
+	// Fast writes grow a used limit back, a little at a time
+	c.inFlight = 1
+	c.observe(10*time.Millisecond, now.Add(time.Second))
+	if c.limit != 2.5 {
+		t.Errorf("limit = %v after a fast write, want 2.5", c.limit)
+	}
+	c.inFlight = 0
+	c.limit = 8
+	c.observe(10*time.Millisecond, now.Add(time.Second))
+	if c.limit != 8 {
+		t.Errorf("limit = %v after a fast write with little in flight, want it unchanged", c.limit)
+	}
+}
+
+func TestConcurrencyLimiterLimit(t *testing.T) {
+	c := NewConcurrencyLimiter(1, 1, 50*time.Millisecond)
+	started, finish := make(chan struct{}), make(chan struct{})
+	h := c.Limit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			started <- struct{}{}
+			<-finish
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	serve := func(method string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(method, "/api/posts", nil))
+		return rec
+	}
+
+	first := make(chan *httptest.ResponseRecorder)
+	go func() { first <- serve(http.MethodPost) }()
+	<-started
+
+	// Reads aren't limited; a second write waits, then is refused
+	if rec := serve(http.MethodGet); rec.Code != http.StatusCreated {
+		t.Errorf("read status = %d while a write is in flight, want it served", rec.Code)
+	}
+	rec := serve(http.MethodPost)
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") != "1" {
+		t.Errorf("second write = %d, Retry-After %q; want 503, 1", rec.Code, rec.Header().Get("Retry-After"))
+	}
+
+	// A waiting write is given the slot when the first finishes
+	c.maxWait = time.Minute
+	second := make(chan *httptest.ResponseRecorder)
+	go func() { second <- serve(http.MethodPost) }()
+	for c.stats().Waiting == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	finish <- struct{}{}
+	if rec := <-first; rec.Code != http.StatusCreated {
+		t.Errorf("first write = %d, want 201", rec.Code)
+	}
+	<-started
+	finish <- struct{}{}
+	if rec := <-second; rec.Code != http.StatusCreated {
+		t.Errorf("waiting write = %d, want 201", rec.Code)
+	}
+	if s := c.stats(); s.InFlight != 0 || s.Waiting != 0 {
+		t.Errorf("stats = %+v after all writes finished, want none in flight", s)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const maxContentWarningLength = 100
+
+type SetContentWarningRequest struct {
+	// ContentWarning replaces the post's warning; empty removes it.
+	ContentWarning string `json:"content_warning"`
+}
+
+// SetContentWarning handles PUT /admin/posts/{id}/content-warning, letting
+// moderators add, change or remove a post's content warning.
+func (h *Handler) SetContentWarning(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid post ID")
+		return
+	}
+
+	var req SetContentWarningRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	req.ContentWarning = strings.TrimSpace(req.ContentWarning)
+	if len(req.ContentWarning) > maxContentWarningLength {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("content_warning must be %d characters or less", maxContentWarningLength))
+		return
+	}
+
+	found, err := h.db.SetContentWarning(r.Context(), id, req.ContentWarning)
+	if err != nil {
+		log.Printf("Error setting content warning: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to set content warning")
+		return
+	}
+	if !found {
+		respondWithError(w, http.StatusNotFound, "Post not found")
+		return
+	}
+
+	h.audit(r, "post.set_content_warning", "post", strconv.Itoa(id), req)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// SetContentWarning sets a post's content warning; an empty warning clears it.
+func (db *DB) SetContentWarning(ctx context.Context, postID int, warning string) (bool, error) {
+	result, err := db.conn.ExecContext(ctx,
+		"UPDATE posts SET content_warning = NULLIF($2, '') WHERE id = $1",
+		postID, warning,
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to set content warning: %w", err)
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to set content warning: %w", err)
+	}
+	return n > 0, nil
+}
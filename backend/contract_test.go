@@ -0,0 +1,501 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The contract tests run the API against a real database (see openTestDB)
+// and check every response against openapi.json: the status must be
+// documented for the operation, and JSON bodies must match its schema. Each
+// run posts to a new event so runs don't interfere.
+
+// contract is the parsed OpenAPI document.
+type contract struct {
+	doc map[string]interface{}
+
+	// covered records the operations ("GET /api/posts") that were checked.
+	covered map[string]bool
+}
+
+func loadContract(t *testing.T) *contract {
+	t.Helper()
+	var doc map[string]interface{}
+	if err := json.Unmarshal(openAPISpec, &doc); err != nil {
+		t.Fatalf("openapi.json is not valid JSON: %v", err)
+	}
+	return &contract{doc: doc, covered: make(map[string]bool)}
+}
+
+// resolve follows a local $ref ("#/components/schemas/Post"), returning nil
+// if it doesn't point anywhere.
+func (c *contract) resolve(node map[string]interface{}) map[string]interface{} {
+	for i := 0; i < 10; i++ {
+		ref, ok := node["$ref"].(string)
+		if !ok {
+			return node
+		}
+		var target interface{} = c.doc
+		for _, key := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+			m, ok := target.(map[string]interface{})
+			if !ok {
+				return nil
+			}
+			target = m[key]
+		}
+		node, ok = target.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+	}
+	return nil
+}
+
+// operation finds the documented operation for a request. Literal path
+// segments win over parameters, as they do in the router, so
+// /api/events/nearby isn't taken for /api/events/{event}.
+func (c *contract) operation(method, path string) (map[string]interface{}, string) {
+	paths, _ := c.doc["paths"].(map[string]interface{})
+	segments := strings.Split(path, "/")
+
+	best, bestScore := "", -1
+	for template := range paths {
+		parts := strings.Split(template, "/")
+		if len(parts) != len(segments) {
+			continue
+		}
+		score := 0
+		for i, part := range parts {
+			if strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}") {
+				if segments[i] == "" {
+					score = -1
+					break
+				}
+				continue
+			}
+			if part != segments[i] {
+				score = -1
+				break
+			}
+			score++
+		}
+		if score > bestScore {
+			best, bestScore = template, score
+		}
+	}
+	if best == "" {
+		return nil, ""
+	}
+
+	item, _ := paths[best].(map[string]interface{})
+	op, _ := item[strings.ToLower(method)].(map[string]interface{})
+	return op, best
+}
+
+// check validates a response against the contract for its request.
+func (c *contract) check(r *http.Request, rec *httptest.ResponseRecorder) []string {
+	op, template := c.operation(r.Method, r.URL.Path)
+	if op == nil {
+		return []string{fmt.Sprintf("%s %s is not documented", r.Method, r.URL.Path)}
+	}
+	c.covered[r.Method+" "+template] = true
+
+	responses, _ := op["responses"].(map[string]interface{})
+	response, ok := responses[strconv.Itoa(rec.Code)].(map[string]interface{})
+	if !ok {
+		response, ok = responses["default"].(map[string]interface{})
+	}
+	if !ok {
+		return []string{fmt.Sprintf("status %d is not documented for %s %s (body %s)", rec.Code, r.Method, template, rec.Body)}
+	}
+	response = c.resolve(response)
+
+	content, _ := response["content"].(map[string]interface{})
+	media, ok := content["application/json"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+		return []string{fmt.Sprintf("Content-Type = %q, want application/json", ct)}
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(rec.Body.Bytes()))
+	dec.UseNumber()
+	var body interface{}
+	if err := dec.Decode(&body); err != nil {
+		return []string{fmt.Sprintf("body is not JSON: %v", err)}
+	}
+	schema, _ := media["schema"].(map[string]interface{})
+	return c.validate(schema, body, "body")
+}
+
+// validate checks a decoded JSON value against a schema. It covers the
+// subset of OpenAPI that openapi.json uses. Objects that list properties
+// may not have others, so fields added to a response without documenting
+// them fail too.
+func (c *contract) validate(schema map[string]interface{}, value interface{}, at string) []string {
+	if schema == nil {
+		return nil
+	}
+	schema = c.resolve(schema)
+	if schema == nil {
+		return []string{at + ": unresolvable $ref"}
+	}
+
+	if value == nil {
+		if nullable, _ := schema["nullable"].(bool); nullable {
+			return nil
+		}
+		return []string{at + ": is null"}
+	}
+
+	// oneOf passes if any of its schemas does
+	if options, ok := schema["oneOf"].([]interface{}); ok {
+		var errs []string
+		for _, option := range options {
+			optionErrs := c.validate(option.(map[string]interface{}), value, at)
+			if len(optionErrs) == 0 {
+				return nil
+			}
+			errs = append(errs, optionErrs...)
+		}
+		return errs
+	}
+
+	var errs []string
+	switch schema["type"] {
+	case "object":
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return []string{fmt.Sprintf("%s: want object, got %T", at, value)}
+		}
+		required, _ := schema["required"].([]interface{})
+		for _, name := range required {
+			if _, ok := obj[name.(string)]; !ok {
+				errs = append(errs, fmt.Sprintf("%s: missing required %q", at, name))
+			}
+		}
+		properties, hasProperties := schema["properties"].(map[string]interface{})
+		for name, v := range obj {
+			prop, ok := properties[name].(map[string]interface{})
+			if !ok {
+				if hasProperties {
+					errs = append(errs, fmt.Sprintf("%s: undocumented property %q", at, name))
+				}
+				continue
+			}
+			errs = append(errs, c.validate(prop, v, at+"."+name)...)
+		}
+
+	case "array":
+		arr, ok := value.([]interface{})
+		if !ok {
+			return []string{fmt.Sprintf("%s: want array, got %T", at, value)}
+		}
+		items, _ := schema["items"].(map[string]interface{})
+		for i, v := range arr {
+			errs = append(errs, c.validate(items, v, fmt.Sprintf("%s[%d]", at, i))...)
+		}
+
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			return []string{fmt.Sprintf("%s: want string, got %T", at, value)}
+		}
+		if enum, ok := schema["enum"].([]interface{}); ok {
+			found := false
+			for _, e := range enum {
+				found = found || e == s
+			}
+			if !found {
+				errs = append(errs, fmt.Sprintf("%s: %q is not one of %v", at, s, enum))
+			}
+		}
+		if schema["format"] == "date-time" {
+			if _, err := time.Parse(time.RFC3339Nano, s); err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %q is not a date-time", at, s))
+			}
+		}
+
+	case "integer":
+		n, ok := value.(json.Number)
+		if !ok {
+			return []string{fmt.Sprintf("%s: want integer, got %T", at, value)}
+		}
+		if _, err := n.Int64(); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s is not an integer", at, n))
+		}
+
+	case "number":
+		if _, ok := value.(json.Number); !ok {
+			return []string{fmt.Sprintf("%s: want number, got %T", at, value)}
+		}
+
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return []string{fmt.Sprintf("%s: want boolean, got %T", at, value)}
+		}
+	}
+	return errs
+}
+
+// operations lists every documented operation, as "GET /api/posts".
+func (c *contract) operations() []string {
+	var ops []string
+	paths, _ := c.doc["paths"].(map[string]interface{})
+	for template, item := range paths {
+		for method := range item.(map[string]interface{}) {
+			if method == "parameters" {
+				continue
+			}
+			ops = append(ops, strings.ToUpper(method)+" "+template)
+		}
+	}
+	sort.Strings(ops)
+	return ops
+}
+
+// TestOpenAPISpec checks the document itself, so it runs without a database.
+func TestOpenAPISpec(t *testing.T) {
+	c := loadContract(t)
+
+	var walk func(node interface{}, at string)
+	walk = func(node interface{}, at string) {
+		switch n := node.(type) {
+		case map[string]interface{}:
+			if _, ok := n["$ref"]; ok && c.resolve(n) == nil {
+				t.Errorf("%s: $ref %v doesn't resolve", at, n["$ref"])
+			}
+			for k, v := range n {
+				walk(v, at+"/"+k)
+			}
+		case []interface{}:
+			for i, v := range n {
+				walk(v, fmt.Sprintf("%s/%d", at, i))
+			}
+		}
+	}
+	walk(c.doc, "#")
+
+	errorSchema := c.doc["components"].(map[string]interface{})["schemas"].(map[string]interface{})["Error"]
+	for _, op := range c.operations() {
+		method, template, _ := strings.Cut(op, " ")
+		item := c.doc["paths"].(map[string]interface{})[template].(map[string]interface{})
+		responses, _ := item[strings.ToLower(method)].(map[string]interface{})["responses"].(map[string]interface{})
+		if len(responses) == 0 {
+			t.Errorf("%s documents no responses", op)
+		}
+		// Every error uses the one error shape
+		for status, response := range responses {
+			if status[0] != '4' && status[0] != '5' {
+				continue
+			}
+			resolved := c.resolve(response.(map[string]interface{}))
+			content, _ := resolved["content"].(map[string]interface{})
+			media, _ := content["application/json"].(map[string]interface{})
+			schema, _ := media["schema"].(map[string]interface{})
+			if schema == nil || fmt.Sprint(c.resolve(schema)) != fmt.Sprint(errorSchema) {
+				t.Errorf("%s: %s response doesn't use the Error schema", op, status)
+			}
+		}
+	}
+}
+
+
+// newContractServer wires the documented routes as main does.
+func newContractServer(t *testing.T, db *DB, mailer Mailer) http.Handler {
+	media, err := NewLocalMediaStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewHandler(db, nil, HandlerConfig{Media: media, MaxUploadBytes: 1 << 20, WriteQueue: NewWriteQueue(10), LegacyPostIDs: true,
+		Mailer: mailer, PublicURL: "https://api.example.com"})
+	drafts := NewDrafts(db, time.Hour, 16<<10)
+	rateLimiter, err := NewRateLimiter(db, "posts", RateLimitPolicy{Requests: 1000, WindowMinutes: 60})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/api/posts", rateLimiter.Limit(methods{"GET": h.GetPosts, "POST": h.CreatePost}))
+	mux.Handle("/api/posts/preview", methods{"POST": h.PreviewPost})
+	mux.Handle("/api/pow/challenge", methods{"GET": h.GetPowChallenge})
+	mux.Handle("/api/posts/poll", methods{"GET": h.PollPosts})
+	mux.Handle("/api/posts/{id}", h.withPost(methods{"GET": h.GetPost, "PATCH": h.EditPost, "DELETE": h.DeletePost}.ServeHTTP))
+	mux.Handle("/api/posts/{id}/appeal", h.withPost(methods{"POST": h.AppealPost}.ServeHTTP))
+	mux.Handle("/api/posts/{id}/react", h.withPost(methods{"POST": h.ReactToPost}.ServeHTTP))
+	mux.Handle("/api/posts/{id}/comments", h.withPost(methods{"GET": h.GetComments, "POST": h.CreateComment}.ServeHTTP))
+	mux.Handle("/api/stories", rateLimiter.Limit(methods{"GET": h.GetStories, "POST": h.SubmitStory}))
+	mux.Handle("/api/post-status/{token}", methods{"GET": h.GetPostStatus})
+	mux.Handle("/api/events", methods{"GET": h.GetEvents})
+	mux.Handle("/api/events/nearby", methods{"GET": h.GetNearbyEvents})
+	mux.HandleFunc("/api/events/{event}", h.withEvent(methods{"GET": h.GetEvent}.ServeHTTP))
+	mux.HandleFunc("/api/events/{event}/archive", h.withEvent(methods{"GET": h.GetEventArchive}.ServeHTTP))
+	mux.HandleFunc("/api/events/{event}/sessions", h.withEvent(methods{"GET": h.GetSessions}.ServeHTTP))
+	mux.HandleFunc("/api/events/{event}/templates", h.withEvent(methods{"GET": h.GetPostTemplates}.ServeHTTP))
+	mux.HandleFunc("/api/events/{event}/fields", h.withEvent(methods{"GET": h.GetCustomFields}.ServeHTTP))
+	mux.Handle("/api/discover", methods{"GET": h.Discover})
+	mux.Handle("/api/terms", methods{"GET": h.GetTerms})
+	mux.Handle("/api/status", methods{"GET": NewStatusPage(db, NewMetrics(), nil).Get})
+	mux.Handle("/api/public-stats", methods{"GET": NewPublicStats(db).Get})
+	mux.Handle("/api/supporters", methods{"GET": NewDonations(db, "", "").GetSupporters})
+	mux.Handle("/api/me/supporter", methods{"GET": NewDonations(db, "", "").GetMySupporter})
+	mux.Handle("/api/client-errors", rateLimiter.Limit(methods{"POST": NewClientErrors(nil, 100).Report}))
+	mux.Handle("/api/me/draft", methods{"GET": drafts.Get, "PUT": drafts.Put, "DELETE": drafts.Delete})
+	mux.Handle("/api/me/counts", methods{"GET": h.GetMyCounts})
+	mux.Handle("/api/me/counts/read", methods{"POST": h.MarkMyRepliesRead})
+	mux.Handle("/api/me/notifications", methods{"GET": h.GetMyNotifications})
+	mux.Handle("/api/me/notifications/read", methods{"POST": h.MarkMyNotificationsRead})
+	mux.Handle("/api/me/preferences", methods{"GET": h.GetMyPreferences, "PUT": h.PutMyPreferences})
+	mux.Handle("/api/alerts", methods{"POST": h.CreateAlert})
+	mux.Handle("/api/alerts/confirm", methods{"GET": h.ConfirmAlert})
+	mux.Handle("/api/alerts/unsubscribe", methods{"GET": h.Unsubscribe, "POST": h.Unsubscribe})
+	mux.Handle("/api/openapi.json", methods{"GET": h.GetOpenAPISpec})
+	return mux
+}
+
+func TestContract(t *testing.T) {
+	db := openTestDB(t)
+	c := loadContract(t)
+	mailer := &fakeMailer{}
+	server := newContractServer(t, db, mailer)
+
+	event := fmt.Sprintf("Contract Test %d", time.Now().UnixNano())
+	eventPath := "/api/events/" + url.PathEscape(event)
+	device := map[string]string{"X-Device-Token": randomToken(16)}
+	post := fmt.Sprintf(`{"event_name":%q,"content":"Blue hat by the main stage","age":25,"gender":"F","location":"Bristol"}`, event)
+
+	// do sends a request, checks it against the contract and the expected
+	// status, and returns the decoded body.
+	do := func(method, target, body string, headers map[string]string, want int) map[string]interface{} {
+		t.Helper()
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		for k, v := range headers {
+			r.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, r)
+
+		for _, problem := range c.check(r, rec) {
+			t.Errorf("%s %s: %s", method, target, problem)
+		}
+		if rec.Code != want {
+			t.Errorf("%s %s: status = %d, want %d (body %s)", method, target, rec.Code, want, rec.Body)
+		}
+		var decoded map[string]interface{}
+		json.Unmarshal(rec.Body.Bytes(), &decoded)
+		return decoded
+	}
+
+	created := do("POST", "/api/posts", post, nil, http.StatusCreated)
+	do("POST", "/api/posts", `{"event_name":`, nil, http.StatusBadRequest)
+	do("POST", "/api/posts", fmt.Sprintf(`{"event_name":%q,"content":""}`, event), nil, http.StatusBadRequest)
+	do("GET", "/api/posts?event="+url.QueryEscape(event), "", nil, http.StatusOK)
+	do("GET", "/api/posts?session=abc", "", nil, http.StatusBadRequest)
+	do("POST", "/api/posts/preview", post, nil, http.StatusOK)
+	do("POST", "/api/posts/preview", `{}`, nil, http.StatusBadRequest)
+	do("GET", "/api/pow/challenge", "", nil, http.StatusOK)
+	do("GET", "/api/post-status/unknown", "", nil, http.StatusNotFound)
+
+	id, _ := created["id"].(float64)
+	publicID, _ := created["public_id"].(string)
+	token, _ := created["edit_token"].(string)
+	postPath := "/api/posts/" + publicID
+	do("PATCH", postPath, fmt.Sprintf(`{"edit_token":%q,"content":"Stale"}`, token), map[string]string{"If-Match": `"v0"`}, http.StatusPreconditionFailed)
+	do("PATCH", postPath, fmt.Sprintf(`{"edit_token":%q,"content":"Blue hat, left of the main stage"}`, token), nil, http.StatusOK)
+	do("PATCH", postPath, `{"edit_token":"wrong","content":"Mine now"}`, nil, http.StatusForbidden)
+	do("PATCH", "/api/posts/2147483647", `{"edit_token":"wrong","content":"Anyone?"}`, nil, http.StatusNotFound)
+	do("PATCH", "/api/posts/abc", `{}`, nil, http.StatusBadRequest)
+	do("GET", postPath, "", nil, http.StatusOK)
+	do("GET", "/api/posts/2147483647", "", nil, http.StatusNotFound)
+	do("GET", fmt.Sprintf("/api/posts/%d", int(id)), "", nil, http.StatusOK)
+	do("GET", "/api/posts/zzzzzzzzzz", "", nil, http.StatusNotFound)
+	do("GET", "/api/posts?ids="+publicID+",zzzzzzzzzz", "", nil, http.StatusOK)
+	do("GET", "/api/posts?ids=1,2", "", nil, http.StatusBadRequest)
+	do("GET", "/api/posts/poll?wait=0s&since_id="+publicID, "", nil, http.StatusOK)
+	do("GET", "/api/posts/poll?since_id=zzzzzzzzzz", "", nil, http.StatusNotFound)
+	uuid, _ := created["uuid"].(string)
+	do("GET", "/api/posts/"+uuid, "", nil, http.StatusOK)
+	do("POST", postPath+"/appeal", `{"message":"Not mine"}`, device, http.StatusNotFound)
+	do("POST", postPath+"/appeal", `{"message":"Not mine"}`, nil, http.StatusBadRequest)
+	do("POST", "/api/stories", fmt.Sprintf(`{"content":"We met at the hat stall","signature":"Sam & Alex","post_id":%q,"consent":true}`, publicID), nil, http.StatusAccepted)
+	do("POST", "/api/stories", `{"content":"We met at the hat stall"}`, nil, http.StatusBadRequest)
+	do("GET", "/api/stories", "", nil, http.StatusOK)
+	do("POST", postPath+"/react", "", nil, http.StatusOK)
+	do("POST", postPath+"/react", "", nil, http.StatusOK)
+	do("POST", "/api/posts/zzzzzzzzzz/react", "", nil, http.StatusNotFound)
+	do("POST", postPath+"/comments", `{"content":"Was it you by the bar?"}`, nil, http.StatusCreated)
+	do("POST", postPath+"/comments", `{"content":""}`, nil, http.StatusBadRequest)
+	do("GET", postPath+"/comments", "", nil, http.StatusOK)
+	do("GET", "/api/posts/zzzzzzzzzz/comments", "", nil, http.StatusNotFound)
+	do("DELETE", postPath, "", map[string]string{"X-Edit-Token": "wrong"}, http.StatusForbidden)
+	do("DELETE", "/api/posts/2147483647", "", map[string]string{"X-Edit-Token": token}, http.StatusNotFound)
+	do("DELETE", postPath, "", map[string]string{"X-Edit-Token": token}, http.StatusNoContent)
+
+	do("GET", "/api/events", "", nil, http.StatusOK)
+	do("GET", "/api/events?sort=random", "", nil, http.StatusBadRequest)
+	do("GET", "/api/events/nearby?lat=51.5&lon=-0.12", "", nil, http.StatusOK)
+	do("GET", "/api/events/nearby", "", nil, http.StatusBadRequest)
+	do("GET", eventPath, "", nil, http.StatusOK)
+	do("GET", "/api/events/"+url.PathEscape(event+" missing"), "", nil, http.StatusNotFound)
+	do("GET", eventPath+"/archive", "", nil, http.StatusNotFound)
+	do("GET", eventPath+"/sessions", "", nil, http.StatusOK)
+	do("GET", eventPath+"/templates", "", nil, http.StatusOK)
+	do("GET", eventPath+"/fields", "", nil, http.StatusOK)
+	do("GET", "/api/discover?lat=51.5&lon=-0.12", "", nil, http.StatusOK)
+	do("GET", "/api/discover?lat=north", "", nil, http.StatusBadRequest)
+	do("GET", "/api/terms", "", nil, http.StatusNotFound)
+
+	do("GET", "/api/status", "", nil, http.StatusOK)
+	do("GET", "/api/public-stats", "", nil, http.StatusOK)
+	do("GET", "/api/supporters?event="+url.QueryEscape(event), "", nil, http.StatusOK)
+	do("POST", "/api/client-errors", `{"message":"TypeError: x is undefined","app_version":"1.4.0"}`, nil, http.StatusNoContent)
+	do("POST", "/api/client-errors", `{"stack":"at app.js:1"}`, nil, http.StatusBadRequest)
+
+	do("GET", "/api/me/draft", "", nil, http.StatusBadRequest)
+	do("PUT", "/api/me/draft", `{"content":"Half-written"}`, device, http.StatusOK)
+	do("PUT", "/api/me/draft", `"not an object"`, device, http.StatusBadRequest)
+	do("GET", "/api/me/draft", "", device, http.StatusOK)
+	do("DELETE", "/api/me/draft", "", device, http.StatusNoContent)
+	do("GET", "/api/me/draft", "", device, http.StatusNotFound)
+	do("GET", "/api/me/counts", "", nil, http.StatusBadRequest)
+	do("GET", "/api/me/counts", "", device, http.StatusOK)
+	do("POST", "/api/me/counts/read", "", device, http.StatusNoContent)
+	do("GET", "/api/me/supporter", "", nil, http.StatusBadRequest)
+	do("GET", "/api/me/supporter", "", device, http.StatusOK)
+	do("GET", "/api/me/notifications?unread=true", "", device, http.StatusOK)
+	do("GET", "/api/me/notifications", "", nil, http.StatusBadRequest)
+	do("POST", "/api/me/notifications/read", `{"ids":[1,2]}`, device, http.StatusNoContent)
+	do("POST", "/api/me/notifications/read", `{"ids":"all"}`, device, http.StatusBadRequest)
+	do("GET", "/api/me/preferences", "", device, http.StatusOK)
+	do("PUT", "/api/me/preferences", `{"hide_content_warnings":true,"keywords":["Lost"],"languages":["en"]}`, device, http.StatusOK)
+	do("PUT", "/api/me/preferences", `{"languages":["English"]}`, device, http.StatusBadRequest)
+
+	do("POST", "/api/alerts", fmt.Sprintf(`{"email":"fan@example.com","event":%q,"keywords":["hat"],"frequency":"instant"}`, event), nil, http.StatusAccepted)
+	do("POST", "/api/alerts", fmt.Sprintf(`{"email":"not an address","event":%q,"keywords":["hat"]}`, event), nil, http.StatusBadRequest)
+	confirm, unsubscribe := mailer.tokens(t)
+	do("GET", "/api/alerts/confirm?token="+confirm, "", nil, http.StatusOK)
+	do("GET", "/api/alerts/confirm?token="+confirm, "", nil, http.StatusNotFound)
+	do("GET", "/api/alerts/unsubscribe?token="+unsubscribe, "", nil, http.StatusOK)
+	do("POST", "/api/alerts/unsubscribe?token="+unsubscribe, "", nil, http.StatusNoContent)
+	do("GET", "/api/alerts/unsubscribe?token="+unsubscribe, "", nil, http.StatusNotFound)
+
+	do("GET", "/api/openapi.json", "", nil, http.StatusOK)
+
+	// A newly documented operation needs a request above
+	for _, op := range c.operations() {
+		if !c.covered[op] {
+			t.Errorf("%s is documented but not exercised", op)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// DeviceCounts are the numbers a device shows on its app icon badge.
+type DeviceCounts struct {
+	// Replies counts unread replies to the device's posts
+	Replies int `json:"replies"`
+	// Reactions counts unread hearts on the device's posts
+	Reactions int `json:"reactions"`
+
+	// LastModified is when the counts last changed, or zero if they never
+	// have
+	LastModified time.Time `json:"-"`
+}
+
+// GetMyCounts handles GET /api/me/counts for the device in X-Device-Token.
+// It is meant to be polled, so it honours If-Modified-Since.
+func (h *Handler) GetMyCounts(w http.ResponseWriter, r *http.Request) {
+	tokenHash, ok := deviceToken(w, r)
+	if !ok {
+		return
+	}
+
+	counts, err := h.db.GetDeviceCounts(r.Context(), tokenHash)
+	if err != nil {
+		log.Printf("Error getting device counts: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve counts")
+		return
+	}
+
+	w.Header().Set("Cache-Control", "private, no-cache")
+	w.Header().Set("Vary", "X-Device-Token")
+	if !counts.LastModified.IsZero() {
+		lastModified := counts.LastModified.UTC().Truncate(time.Second)
+		w.Header().Set("Last-Modified", lastModified.Format(http.TimeFormat))
+		if since, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil && !lastModified.After(since) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+
+	respondWithJSON(w, http.StatusOK, counts)
+}
+
+// MarkMyRepliesRead handles POST /api/me/counts/read, clearing the device's
+// badge by marking all its notifications read.
+func (h *Handler) MarkMyRepliesRead(w http.ResponseWriter, r *http.Request) {
+	tokenHash, ok := deviceToken(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.db.MarkNotificationsRead(r.Context(), tokenHash, nil); err != nil {
+		log.Printf("Error marking replies read: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to mark replies read")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetDeviceCounts counts the unread notifications on the visible posts made
+// with a device token.
+func (db *DB) GetDeviceCounts(ctx context.Context, deviceTokenHash string) (*DeviceCounts, error) {
+	var counts DeviceCounts
+	var lastModified *time.Time
+	// Marking notifications read changes the counts as much as a new one
+	// does, so both count towards LastModified
+	err := db.conn.QueryRowContext(ctx, `
+		SELECT COUNT(*) FILTER (WHERE n.kind = $2 AND n.read_at IS NULL),
+			COUNT(*) FILTER (WHERE n.kind = $3 AND n.read_at IS NULL),
+			GREATEST(MAX(n.created_at), MAX(n.read_at))
+		FROM notifications n
+		JOIN posts p ON p.id = n.post_id
+		WHERE n.device_token_hash = $1 AND p.hidden_at IS NULL
+	`, deviceTokenHash, NotificationReply, NotificationReaction).Scan(&counts.Replies, &counts.Reactions, &lastModified)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count notifications: %w", err)
+	}
+
+	if lastModified != nil {
+		counts.LastModified = *lastModified
+	}
+	return &counts, nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGetMyCounts(t *testing.T) {
+	changed := time.Date(2026, 7, 1, 12, 0, 0, 500_000_000, time.UTC)
+	token := strings.Repeat("d", 16)
+
+	tests := []struct {
+		name      string
+		token     string
+		since     string
+		counts    DeviceCounts
+		fail      bool
+		status    int
+		errorMsg  string
+		wantBody  string
+		wantMtime string
+	}{
+		{name: "no token", status: 400, errorMsg: "X-Device-Token must be a random string of 16 to 128 characters"},
+		{name: "never replied to", token: token, status: 200, wantBody: `{"replies":0,"reactions":0}`},
+		{
+			name:      "unread replies and reactions",
+			token:     token,
+			counts:    DeviceCounts{Replies: 3, Reactions: 2, LastModified: changed},
+			status:    200,
+			wantBody:  `{"replies":3,"reactions":2}`,
+			wantMtime: "Wed, 01 Jul 2026 12:00:00 GMT",
+		},
+		{
+			name:      "not modified",
+			token:     token,
+			since:     "Wed, 01 Jul 2026 12:00:00 GMT",
+			counts:    DeviceCounts{Replies: 3, LastModified: changed},
+			status:    304,
+			wantMtime: "Wed, 01 Jul 2026 12:00:00 GMT",
+		},
+		{
+			name:      "modified since",
+			token:     token,
+			since:     "Wed, 01 Jul 2026 11:59:59 GMT",
+			counts:    DeviceCounts{Replies: 3, LastModified: changed},
+			status:    200,
+			wantBody:  `{"replies":3,"reactions":0}`,
+			wantMtime: "Wed, 01 Jul 2026 12:00:00 GMT",
+		},
+		{name: "query fails", token: token, fail: true, status: 500, errorMsg: "Failed to retrieve counts"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newFakeStore()
+			store.counts = tt.counts
+			store.fail["GetDeviceCounts"] = tt.fail
+			h := newTestHandler(store, HandlerConfig{})
+
+			req := httptest.NewRequest(http.MethodGet, "/api/me/counts", nil)
+			if tt.token != "" {
+				req.Header.Set("X-Device-Token", tt.token)
+			}
+			if tt.since != "" {
+				req.Header.Set("If-Modified-Since", tt.since)
+			}
+			rec := httptest.NewRecorder()
+			h.GetMyCounts(rec, req)
+
+			if tt.errorMsg != "" {
+				assertError(t, rec, tt.status, tt.errorMsg)
+				return
+			}
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d (body %s)", rec.Code, tt.status, rec.Body)
+			}
+			if got := strings.TrimSpace(rec.Body.String()); got != tt.wantBody {
+				t.Errorf("body = %q, want %q", got, tt.wantBody)
+			}
+			if got := rec.Header().Get("Last-Modified"); got != tt.wantMtime {
+				t.Errorf("Last-Modified = %q, want %q", got, tt.wantMtime)
+			}
+		})
+	}
+}
+
+func TestMarkMyRepliesRead(t *testing.T) {
+	store := newFakeStore()
+	h := newTestHandler(store, HandlerConfig{})
+	token := strings.Repeat("d", 16)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/me/counts/read", nil)
+	req.Header.Set("X-Device-Token", token)
+	rec := httptest.NewRecorder()
+	h.MarkMyRepliesRead(rec, req)
+
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("status = %d, want 204 (body %s)", rec.Code, rec.Body)
+	}
+	if store.readBy != hashToken(token) {
+		t.Errorf("marked read for %q, want the token's hash", store.readBy)
+	}
+}
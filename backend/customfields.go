@@ -0,0 +1,330 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	maxCustomFields             = 20
+	defaultCustomFieldMaxLength = 200
+	maxCustomFieldMaxLength     = 2000
+)
+
+var customFieldNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,39}$`)
+
+// CustomField describes one organizer-defined field on an event's posts,
+// such as "stage" or "campsite".
+type CustomField struct {
+	Name      string   `json:"name"`
+	Label     string   `json:"label,omitempty"`
+	Type      string   `json:"type"` // text, number or select
+	Required  bool     `json:"required,omitempty"`
+	Options   []string `json:"options,omitempty"`
+	MaxLength int      `json:"max_length,omitempty"`
+}
+
+// CustomFieldSchema is an event's list of custom fields, stored as JSONB.
+type CustomFieldSchema []CustomField
+
+// CustomFieldValues are a post's custom field values, stored as JSONB. Text
+// and select values are strings; number values are float64.
+type CustomFieldValues map[string]interface{}
+
+type SetCustomFieldsRequest struct {
+	Fields CustomFieldSchema `json:"fields"`
+}
+
+// GetCustomFields handles GET /api/events/{event}/fields so clients can
+// render the extra inputs an event asks for.
+func (h *Handler) GetCustomFields(w http.ResponseWriter, r *http.Request) {
+	settings, err := h.db.GetEventSettings(r.Context(), r.PathValue("event"))
+	if err != nil {
+		log.Printf("Error getting custom fields: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve custom fields")
+		return
+	}
+
+	fields := settings.CustomFields
+	if fields == nil {
+		fields = CustomFieldSchema{}
+	}
+
+	respondWithJSON(w, http.StatusOK, fields)
+}
+
+// SetCustomFields handles PUT /admin/events/{event}/fields. The schema is
+// replaced wholesale; values already stored on posts are left alone, but
+// only fields still in the schema are returned.
+func (h *Handler) SetCustomFields(w http.ResponseWriter, r *http.Request) {
+	var req SetCustomFieldsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if err := req.Fields.normalize(); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	found, err := h.db.SetCustomFields(r.Context(), r.PathValue("event"), req.Fields)
+	if err != nil {
+		log.Printf("Error setting custom fields: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to update event")
+		return
+	}
+	if !found {
+		respondWithError(w, http.StatusNotFound, "Event not found")
+		return
+	}
+
+	h.audit(r, "event.set_custom_fields", "event", r.PathValue("event"), req)
+
+	h.GetEvent(w, r)
+}
+
+// normalize validates a schema submitted by an organizer and fills in defaults.
+func (s CustomFieldSchema) normalize() error {
+	if len(s) > maxCustomFields {
+		return &ValidationError{Message: fmt.Sprintf("at most %d custom fields are allowed", maxCustomFields)}
+	}
+
+	seen := make(map[string]bool)
+	for i := range s {
+		f := &s[i]
+		f.Name = strings.TrimSpace(f.Name)
+		f.Label = strings.TrimSpace(f.Label)
+
+		if !customFieldNamePattern.MatchString(f.Name) {
+			return &ValidationError{Message: "field names must be lowercase letters, digits and underscores, starting with a letter"}
+		}
+		if seen[f.Name] {
+			return &ValidationError{Message: fmt.Sprintf("duplicate field %q", f.Name)}
+		}
+		seen[f.Name] = true
+
+		if len(f.Label) > 100 {
+			return &ValidationError{Message: "field labels must be 100 characters or less"}
+		}
+
+		switch f.Type {
+		case "text":
+			if f.MaxLength == 0 {
+				f.MaxLength = defaultCustomFieldMaxLength
+			}
+			if f.MaxLength < 1 || f.MaxLength > maxCustomFieldMaxLength {
+				return &ValidationError{Message: fmt.Sprintf("max_length must be between 1 and %d", maxCustomFieldMaxLength)}
+			}
+			f.Options = nil
+		case "select":
+			if len(f.Options) == 0 {
+				return &ValidationError{Message: fmt.Sprintf("select field %q needs options", f.Name)}
+			}
+			for j, option := range f.Options {
+				f.Options[j] = strings.TrimSpace(option)
+				if f.Options[j] == "" || len(f.Options[j]) > 200 {
+					return &ValidationError{Message: "options must be between 1 and 200 characters"}
+				}
+			}
+			f.MaxLength = 0
+		case "number":
+			f.Options = nil
+			f.MaxLength = 0
+		default:
+			return &ValidationError{Message: "field type must be one of text, number, select"}
+		}
+	}
+
+	return nil
+}
+
+func (s CustomFieldSchema) field(name string) (CustomField, bool) {
+	for _, f := range s {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return CustomField{}, false
+}
+
+// validate checks submitted values against the schema, returning the values
+// to store. Unknown fields are rejected so typos don't silently vanish.
+func (s CustomFieldSchema) validate(values map[string]interface{}) (CustomFieldValues, error) {
+	out := make(CustomFieldValues)
+
+	for name := range values {
+		if _, ok := s.field(name); !ok {
+			return nil, &ValidationError{Message: fmt.Sprintf("unknown custom field %q", name), Rule: "custom_field.unknown"}
+		}
+	}
+
+	for _, f := range s {
+		raw, present := values[f.Name]
+		if str, ok := raw.(string); ok {
+			raw = strings.TrimSpace(str)
+			present = raw != ""
+		}
+		if !present || raw == nil {
+			if f.Required {
+				return nil, &ValidationError{Message: fmt.Sprintf("custom field %q is required", f.Name), Rule: "custom_field.required"}
+			}
+			continue
+		}
+
+		switch f.Type {
+		case "text":
+			str, ok := raw.(string)
+			if !ok {
+				return nil, &ValidationError{Message: fmt.Sprintf("custom field %q must be text", f.Name), Rule: "custom_field.type"}
+			}
+			if len(str) > f.MaxLength {
+				return nil, &ValidationError{Message: fmt.Sprintf("custom field %q must be %d characters or less", f.Name, f.MaxLength), Rule: "custom_field.max_length"}
+			}
+			out[f.Name] = str
+		case "select":
+			str, ok := raw.(string)
+			if !ok || !containsString(f.Options, str) {
+				return nil, &ValidationError{Message: fmt.Sprintf("custom field %q must be one of: %s", f.Name, strings.Join(f.Options, ", ")), Rule: "custom_field.option"}
+			}
+			out[f.Name] = str
+		case "number":
+			num, ok := raw.(float64)
+			if !ok {
+				return nil, &ValidationError{Message: fmt.Sprintf("custom field %q must be a number", f.Name), Rule: "custom_field.type"}
+			}
+			out[f.Name] = num
+		}
+	}
+
+	if len(out) == 0 {
+		return nil, nil
+	}
+	return out, nil
+}
+
+// redact drops stored values for fields no longer in the schema.
+func (s CustomFieldSchema) redact(values CustomFieldValues) CustomFieldValues {
+	for name := range values {
+		if _, ok := s.field(name); !ok {
+			delete(values, name)
+		}
+	}
+	if len(values) == 0 {
+		return nil
+	}
+	return values
+}
+
+func hasFieldFilters(r *http.Request) bool {
+	for key := range r.URL.Query() {
+		if strings.HasPrefix(key, "field.") {
+			return true
+		}
+	}
+	return false
+}
+
+// parseFieldFilters reads ?field.<name>=<value> query parameters into values
+// matching the event's schema, for filtering with GetPosts.
+func parseFieldFilters(r *http.Request, schema CustomFieldSchema) (CustomFieldValues, error) {
+	var filters CustomFieldValues
+	for key, vals := range r.URL.Query() {
+		name, ok := strings.CutPrefix(key, "field.")
+		if !ok {
+			continue
+		}
+		f, ok := schema.field(name)
+		if !ok {
+			return nil, &ValidationError{Message: fmt.Sprintf("unknown custom field %q", name)}
+		}
+		if filters == nil {
+			filters = make(CustomFieldValues)
+		}
+		if f.Type == "number" {
+			num, err := strconv.ParseFloat(vals[0], 64)
+			// NaN and infinities parse but can't be encoded as JSON
+			if err != nil || math.IsNaN(num) || math.IsInf(num, 0) {
+				return nil, &ValidationError{Message: fmt.Sprintf("custom field %q must be a number", name)}
+			}
+			filters[name] = num
+		} else {
+			filters[name] = vals[0]
+		}
+	}
+	return filters, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func (s CustomFieldSchema) Value() (driver.Value, error) {
+	if s == nil {
+		return "[]", nil
+	}
+	b, err := json.Marshal(s)
+	return string(b), err
+}
+
+func (s *CustomFieldSchema) Scan(src interface{}) error {
+	return scanJSON(src, s)
+}
+
+func (v CustomFieldValues) Value() (driver.Value, error) {
+	if v == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(v)
+	return string(b), err
+}
+
+func (v *CustomFieldValues) Scan(src interface{}) error {
+	return scanJSON(src, v)
+}
+
+// scanJSON decodes a JSONB column, leaving dest untouched for NULL.
+func scanJSON(src interface{}, dest interface{}) error {
+	switch src := src.(type) {
+	case nil:
+		return nil
+	case []byte:
+		return json.Unmarshal(src, dest)
+	case string:
+		return json.Unmarshal([]byte(src), dest)
+	default:
+		return fmt.Errorf("cannot scan %T into JSON", src)
+	}
+}
+
+// SetCustomFields replaces an event's custom field schema, reporting whether
+// the event exists.
+func (db *DB) SetCustomFields(ctx context.Context, name string, fields CustomFieldSchema) (bool, error) {
+	result, err := db.conn.ExecContext(ctx,
+		"UPDATE events SET custom_fields = $2::jsonb WHERE name = $1",
+		name, fields,
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to set custom fields: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to set custom fields: %w", err)
+	}
+
+	return affected > 0, nil
+}
@@ -2,10 +2,13 @@ package main
 
 import (
 	"context"
+	"crypto/md5"
 	"database/sql"
+	"encoding/hex"
 	"fmt"
 	"log"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
@@ -13,7 +16,15 @@ import (
 )
 
 type DB struct {
-	conn *sql.DB
+	pool *sql.DB
+	// conn runs the DB's statements: the pool, or the transaction of a DB
+	// from WithTx
+	conn querier
+	// tx is set on a DB from WithTx
+	tx         *sql.Tx
+	savepoints *int
+	// replicas, if set, serve the reads of GET requests; see reader
+	replicas *replicaSet
 }
 
 func NewDB(databaseURL string) (*DB, error) {
@@ -38,23 +49,75 @@ func NewDB(databaseURL string) (*DB, error) {
 
 	log.Println("Successfully connected to database")
 
-	return &DB{conn: conn}, nil
+	return &DB{pool: conn, conn: conn}, nil
 }
 
 func (db *DB) Close() {
-	db.conn.Close()
+	db.closeReplicas()
+	db.pool.Close()
 }
 
-// CreatePost inserts a new post into the database
-func (db *DB) CreatePost(ctx context.Context, req CreatePostRequest, ipHash string) (*Post, error) {
-	query := `
-		INSERT INTO posts (event_name, content, age, gender, location, ip_hash)
-		VALUES ($1, $2, $3, $4, $5, $6)
-		RETURNING id, event_name, content, age, gender, location, created_at
-	`
+// postColumns is the column list shared by every query that returns a Post.
+const postColumns = `id, public_id, uuid, event_name, content, age, gender, location, created_at, custom_fields, template_id, session_id, COALESCE(content_warning, ''), edited_at, edit_count, hidden_at, reply_count, report_count, reaction_count, verified_attendee, version`
 
+// rowScanner is satisfied by both *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanPost reads a row selected with postColumns into a Post. Any extra
+// destinations are scanned from the columns that follow postColumns.
+func scanPost(row rowScanner, extra ...interface{}) (*Post, error) {
 	var post Post
-	err := db.conn.QueryRowContext(
+	var age sql.NullInt64
+	dest := []interface{}{
+		&post.ID,
+		&post.PublicID,
+		&post.UUID,
+		&post.EventName,
+		&post.Content,
+		&age,
+		&post.Gender,
+		&post.Location,
+		&post.CreatedAt,
+		&post.CustomFields,
+		&post.TemplateID,
+		&post.SessionID,
+		&post.ContentWarning,
+		&post.EditedAt,
+		&post.EditCount,
+		&post.HiddenAt,
+		&post.ReplyCount,
+		&post.ReportCount,
+		&post.ReactionCount,
+		&post.VerifiedAttendee,
+		&post.Version,
+	}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return nil, err
+	}
+	if age.Valid {
+		a := int(age.Int64)
+		post.Age = &a
+	}
+	return &post, nil
+}
+
+// CreatePost inserts a new post into the database.
+// An Age of 0 is stored as NULL (e.g. posts that arrive by SMS). The event
+// row is created on its first post. Posts created without an edit token
+// can't be edited.
+func (db *DB) CreatePost(ctx context.Context, req CreatePostRequest, ipHash, editTokenHash string) (*Post, error) {
+	query := `
+		WITH new_event AS (
+			INSERT INTO events (name) VALUES ($1)
+			ON CONFLICT (name) DO NOTHING
+		)
+		INSERT INTO posts (event_name, content, age, gender, location, ip_hash, terms_version, custom_fields, template_id, session_id, content_warning, edit_token_hash, device_token_hash, verified_attendee)
+		VALUES ($1, $2, NULLIF($3, 0), $4, $5, $6, NULLIF($7, ''), $8::jsonb, $9, $10, NULLIF($11, ''), NULLIF($12, ''), NULLIF($13, ''), $14)
+		RETURNING ` + postColumns
+
+	post, err := scanPost(db.conn.QueryRowContext(
 		ctx,
 		query,
 		req.EventName,
@@ -63,48 +126,141 @@ func (db *DB) CreatePost(ctx context.Context, req CreatePostRequest, ipHash stri
 		req.Gender,
 		req.Location,
 		ipHash,
-	).Scan(
-		&post.ID,
-		&post.EventName,
-		&post.Content,
-		&post.Age,
-		&post.Gender,
-		&post.Location,
-		&post.CreatedAt,
-	)
-
+		req.TermsVersion,
+		req.CustomFields,
+		req.TemplateID,
+		req.SessionID,
+		req.ContentWarning,
+		editTokenHash,
+		req.DeviceTokenHash,
+		req.VerifiedAttendee,
+	))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create post: %w", err)
 	}
 
-	return &post, nil
+	return post, nil
+}
+
+// PostFilter narrows the posts returned by GetPosts. Zero fields match
+// everything.
+type PostFilter struct {
+	Event string
+	// Session drills into one session of an event; 0 rolls up all of them.
+	Session int
+	// Fields matches posts whose custom fields contain all of these values.
+	Fields CustomFieldValues
+	// IncludeSensitive includes posts that carry a content warning.
+	IncludeSensitive bool
+	// MaxID leaves out posts saved after this one, pinning a listing to a
+	// snapshot; 0 leaves in every post.
+	MaxID int
+	// ShuffleSeed, when set, orders posts randomly instead of newest first;
+	// the same seed always gives the same order (see shuffleKey).
+	ShuffleSeed string
+	// Keywords, when set, match posts whose content mentions any of them.
+	Keywords []string
+	// Languages, when set, leave out posts translated from other languages.
+	Languages []string
 }
 
-// GetPosts retrieves posts, optionally filtered by event
-func (db *DB) GetPosts(ctx context.Context, eventFilter string, limit int, offset int) ([]Post, error) {
-	var query string
-	var args []interface{}
-
-	if eventFilter != "" {
-		query = `
-			SELECT id, event_name, content, age, gender, location, created_at
-			FROM posts
-			WHERE event_name = $1
-			ORDER BY created_at DESC
-			LIMIT $2 OFFSET $3
-		`
-		args = []interface{}{eventFilter, limit, offset}
-	} else {
-		query = `
-			SELECT id, event_name, content, age, gender, location, created_at
-			FROM posts
-			ORDER BY created_at DESC
-			LIMIT $1 OFFSET $2
-		`
-		args = []interface{}{limit, offset}
+// maxShuffleSeed caps the length of a shuffle seed.
+const maxShuffleSeed = 64
+
+// shuffleKey is what posts are sorted by for a shuffle seed. GetPosts sorts
+// by the same md5 in SQL, byte-wise, with the ID breaking ties.
+func shuffleKey(seed string, id int) string {
+	sum := md5.Sum([]byte(seed + ":" + strconv.Itoa(id)))
+	return hex.EncodeToString(sum[:])
+}
+
+// conditions returns the SQL conditions on posts for the filter, with
+// their arguments appended to args.
+func (f PostFilter) conditions(args []interface{}) ([]string, []interface{}) {
+	var conditions []string
+
+	if f.Event != "" {
+		args = append(args, f.Event)
+		conditions = append(conditions, fmt.Sprintf("event_name = $%d", len(args)))
+	}
+	if f.Session != 0 {
+		args = append(args, f.Session)
+		conditions = append(conditions, fmt.Sprintf("session_id = $%d", len(args)))
+	}
+	if len(f.Fields) > 0 {
+		// Served by the GIN index on custom_fields
+		args = append(args, f.Fields)
+		conditions = append(conditions, fmt.Sprintf("custom_fields @> $%d::jsonb", len(args)))
+	}
+	if f.MaxID != 0 {
+		args = append(args, f.MaxID)
+		conditions = append(conditions, fmt.Sprintf("id <= $%d", len(args)))
+	}
+	if len(f.Keywords) > 0 {
+		args = append(args, keywordPatterns(f.Keywords))
+		conditions = append(conditions, fmt.Sprintf("content ILIKE ANY($%d)", len(args)))
 	}
+	if len(f.Languages) > 0 {
+		// A post's language is only known from its translations
+		args = append(args, f.Languages)
+		conditions = append(conditions, fmt.Sprintf(`NOT EXISTS (
+			SELECT 1 FROM post_translations t
+			WHERE t.post_id = posts.id AND t.source_language <> ALL($%d)
+		)`, len(args)))
+	}
+	// Hidden posts are only shown to moderators
+	conditions = append(conditions, "hidden_at IS NULL")
+	if !f.IncludeSensitive {
+		conditions = append(conditions, "content_warning IS NULL")
+	}
+
+	return conditions, args
+}
+
+// GetPosts retrieves posts matching the filter, newest first unless they
+// are shuffled
+func (db *DB) GetPosts(ctx context.Context, filter PostFilter, limit int, offset int) ([]Post, error) {
+	conditions, args := filter.conditions(nil)
+
+	order := "created_at DESC"
+	if filter.ShuffleSeed != "" {
+		args = append(args, filter.ShuffleSeed)
+		order = fmt.Sprintf(`md5($%d::text || ':' || id) COLLATE "C", id`, len(args))
+	}
+
+	args = append(args, limit, offset)
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM posts
+		WHERE %s
+		ORDER BY %s
+		LIMIT $%d OFFSET $%d
+	`, postColumns, strings.Join(conditions, " AND "), order, len(args)-1, len(args))
 
-	rows, err := db.conn.QueryContext(ctx, query, args...)
+	return db.queryPosts(ctx, query, args...)
+}
+
+// GetPostsAfter retrieves posts matching the filter that were saved after
+// the post with afterID, oldest first.
+func (db *DB) GetPostsAfter(ctx context.Context, filter PostFilter, afterID int, limit int) ([]Post, error) {
+	conditions, args := filter.conditions([]interface{}{afterID})
+	conditions = append(conditions, "id > $1")
+
+	args = append(args, limit)
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM posts
+		WHERE %s
+		ORDER BY id
+		LIMIT $%d
+	`, postColumns, strings.Join(conditions, " AND "), len(args))
+
+	return db.queryPosts(ctx, query, args...)
+}
+
+// queryPosts runs a query selecting postColumns.
+func (db *DB) queryPosts(ctx context.Context, query string, args ...interface{}) ([]Post, error) {
+	rows, err := db.reader(ctx).QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query posts: %w", err)
 	}
@@ -112,20 +268,11 @@ func (db *DB) GetPosts(ctx context.Context, eventFilter string, limit int, offse
 
 	var posts []Post
 	for rows.Next() {
-		var post Post
-		err := rows.Scan(
-			&post.ID,
-			&post.EventName,
-			&post.Content,
-			&post.Age,
-			&post.Gender,
-			&post.Location,
-			&post.CreatedAt,
-		)
+		post, err := scanPost(rows)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan post: %w", err)
 		}
-		posts = append(posts, post)
+		posts = append(posts, *post)
 	}
 
 	if err := rows.Err(); err != nil {
@@ -135,16 +282,55 @@ func (db *DB) GetPosts(ctx context.Context, eventFilter string, limit int, offse
 	return posts, nil
 }
 
-// GetEvents retrieves all unique event names ordered by most recent post
-func (db *DB) GetEvents(ctx context.Context) ([]string, error) {
+// GetPostByID retrieves a single post, returning nil if it does not exist
+func (db *DB) GetPostByID(ctx context.Context, id int) (*Post, error) {
+	query := `SELECT ` + postColumns + ` FROM posts WHERE id = $1`
+
+	post, err := scanPost(db.reader(ctx).QueryRowContext(ctx, query, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get post: %w", err)
+	}
+
+	return post, nil
+}
+
+// EventListOptions filters and orders the events list.
+type EventListOptions struct {
+	Sort     string // a key of eventSortOrders
+	Query    string // case-insensitive substring of the event name
+	Category string
+}
+
+var eventSortOrders = map[string]string{
+	"recent":       "MAX(created_at) DESC",
+	"alphabetical": "LOWER(event_name), event_name",
+	"most_posts":   "COUNT(*) DESC, MAX(created_at) DESC",
+}
+
+// likeEscaper escapes the LIKE wildcards in user-supplied search text.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// GetEvents retrieves the names of events that have posts
+func (db *DB) GetEvents(ctx context.Context, opts EventListOptions, limit, offset int) ([]string, error) {
+	order, ok := eventSortOrders[opts.Sort]
+	if !ok {
+		order = eventSortOrders["recent"]
+	}
+
 	query := `
 		SELECT event_name
 		FROM posts
+		WHERE ($1 = '' OR event_name ILIKE '%' || $1 || '%')
+		AND ($4 = '' OR event_name IN (SELECT name FROM events WHERE category = $4))
 		GROUP BY event_name
-		ORDER BY MAX(created_at) DESC
+		ORDER BY ` + order + `
+		LIMIT $2 OFFSET $3
 	`
 
-	rows, err := db.conn.QueryContext(ctx, query)
+	rows, err := db.reader(ctx).QueryContext(ctx, query, likeEscaper.Replace(opts.Query), limit, offset, opts.Category)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query events: %w", err)
 	}
@@ -166,29 +352,35 @@ func (db *DB) GetEvents(ctx context.Context) ([]string, error) {
 	return events, nil
 }
 
-// GetPostCountByIPInWindow checks how many posts an IP has made in the time window
-func (db *DB) GetPostCountByIPInWindow(ctx context.Context, ipHash string, windowMinutes int) (int, error) {
+// FindEventName returns the stored spelling of an event whose name matches
+// the given one case-insensitively, or "" if no post mentions it yet.
+func (db *DB) FindEventName(ctx context.Context, name string) (string, error) {
 	query := `
-		SELECT COUNT(*)
+		SELECT event_name
 		FROM posts
-		WHERE ip_hash = $1
-		AND created_at > NOW() - INTERVAL '1 minute' * $2
+		WHERE LOWER(event_name) = LOWER($1)
+		GROUP BY event_name
+		ORDER BY MAX(created_at) DESC
+		LIMIT 1
 	`
 
-	var count int
-	err := db.conn.QueryRowContext(ctx, query, ipHash, windowMinutes).Scan(&count)
+	var event string
+	err := db.conn.QueryRowContext(ctx, query, name).Scan(&event)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
 	if err != nil {
-		return 0, fmt.Errorf("failed to count posts: %w", err)
+		return "", fmt.Errorf("failed to find event: %w", err)
 	}
 
-	return count, nil
+	return event, nil
 }
 
 // runMigrations executes all pending database migrations in order.
 // It reads migration files from the migrations/ folder and tracks which have been run.
 func runMigrations(db *DB) {
 	// Create schema_migrations table to track applied migrations
-	_, err := db.conn.Exec(`
+	_, err := db.pool.Exec(`
 		CREATE TABLE IF NOT EXISTS schema_migrations (
 			version VARCHAR(255) PRIMARY KEY,
 			applied_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
@@ -219,7 +411,7 @@ func runMigrations(db *DB) {
 
 		// Check if migration has been applied
 		var exists bool
-		err := db.conn.QueryRow(
+		err := db.pool.QueryRow(
 			"SELECT EXISTS(SELECT 1 FROM schema_migrations WHERE version = $1)",
 			version,
 		).Scan(&exists)
@@ -240,13 +432,13 @@ func runMigrations(db *DB) {
 
 		// Run the migration
 		log.Printf("Applying migration: %s", version)
-		_, err = db.conn.Exec(string(sqlBytes))
+		_, err = db.pool.Exec(string(sqlBytes))
 		if err != nil {
 			log.Fatalf("Failed to run migration %s: %v", version, err)
 		}
 
 		// Record the migration
-		_, err = db.conn.Exec(
+		_, err = db.pool.Exec(
 			"INSERT INTO schema_migrations (version) VALUES ($1)",
 			version,
 		)
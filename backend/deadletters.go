@@ -0,0 +1,287 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Dead-letter kinds
+const (
+	deadLetterFederation = "federation"
+	deadLetterEmail      = "email"
+	deadLetterPost       = "post"
+)
+
+const maxRedriveIDs = 100
+
+// DeadLetter is a delivery that was given up on: a federation activity, an
+// alert email or a queued post that kept failing. It stays until an admin
+// redrives it.
+type DeadLetter struct {
+	ID   int    `json:"id"`
+	Kind string `json:"kind"`
+	// Destination is the inbox URL, email address or a post's event
+	Destination string `json:"destination"`
+	// Payload is what was being delivered, enough to send it again
+	Payload  json.RawMessage `json:"payload"`
+	Error    string          `json:"error"`
+	Attempts int             `json:"attempts"`
+
+	CreatedAt  time.Time  `json:"created_at"`
+	RedrivenAt *time.Time `json:"redriven_at,omitempty"`
+}
+
+// federationDeadLetter is the payload of a federation dead letter.
+type federationDeadLetter struct {
+	EventName string          `json:"event_name"`
+	Inbox     string          `json:"inbox"`
+	Activity  json.RawMessage `json:"activity"`
+}
+
+type RedriveRequest struct {
+	IDs []int `json:"ids"`
+}
+
+// RedriveResult reports what became of each dead letter asked for.
+// Requeued ones are marked redriven; if they fail again they come back as
+// new dead letters. Failed ones couldn't be sent and stay in the queue with
+// the new error, and Missing ones don't exist or were already redriven.
+type RedriveResult struct {
+	Requeued []int `json:"requeued"`
+	Failed   []int `json:"failed"`
+	Missing  []int `json:"missing"`
+}
+
+// GetDeadLetters handles GET /admin/dlq. ?kind= narrows it to federation,
+// email or post, and ?status=redriven or all includes those already redriven.
+func (h *Handler) GetDeadLetters(w http.ResponseWriter, r *http.Request) {
+	kind := r.URL.Query().Get("kind")
+	switch kind {
+	case "", deadLetterFederation, deadLetterEmail, deadLetterPost:
+	default:
+		respondWithError(w, http.StatusBadRequest, "kind must be federation, email or post")
+		return
+	}
+	var redriven *bool
+	switch r.URL.Query().Get("status") {
+	case "", "pending":
+		redriven = new(bool)
+	case "redriven":
+		redriven = new(bool)
+		*redriven = true
+	case "all":
+	default:
+		respondWithError(w, http.StatusBadRequest, "status must be pending, redriven or all")
+		return
+	}
+	limit, offset := parsePagination(r)
+
+	letters, err := h.db.GetDeadLetters(r.Context(), kind, redriven, limit, offset)
+	if err != nil {
+		log.Printf("Error getting dead letters: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve dead letters")
+		return
+	}
+
+	if letters == nil {
+		letters = []DeadLetter{}
+	}
+
+	respondWithJSON(w, http.StatusOK, letters)
+}
+
+// RedriveDeadLetters handles POST /admin/dlq/redrive. Federation activities
+// go back on the delivery queue and posts on the write queue; emails are sent straight away, so a
+// failure is reported in the result.
+func (h *Handler) RedriveDeadLetters(w http.ResponseWriter, r *http.Request) {
+	var req RedriveRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if len(req.IDs) == 0 {
+		respondWithError(w, http.StatusBadRequest, "ids is required")
+		return
+	}
+	if len(req.IDs) > maxRedriveIDs {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("ids must have %d IDs or fewer", maxRedriveIDs))
+		return
+	}
+
+	// Claiming marks them redriven, so two admins can't send one twice
+	letters, err := h.db.ClaimDeadLetters(r.Context(), req.IDs)
+	if err != nil {
+		log.Printf("Error claiming dead letters: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to redrive dead letters")
+		return
+	}
+
+	result := RedriveResult{Requeued: []int{}, Failed: []int{}, Missing: []int{}}
+	claimed := make(map[int]bool)
+	for _, letter := range letters {
+		claimed[letter.ID] = true
+		if err := h.redrive(r.Context(), letter); err != nil {
+			log.Printf("Error redriving dead letter %d: %v", letter.ID, err)
+			if err := h.db.ReleaseDeadLetter(r.Context(), letter.ID, err.Error()); err != nil {
+				log.Printf("Error releasing dead letter %d: %v", letter.ID, err)
+			}
+			result.Failed = append(result.Failed, letter.ID)
+			continue
+		}
+		result.Requeued = append(result.Requeued, letter.ID)
+	}
+	for _, id := range req.IDs {
+		if !claimed[id] {
+			result.Missing = append(result.Missing, id)
+		}
+	}
+
+	h.audit(r, "dlq.redrive", "", "", result)
+	respondWithJSON(w, http.StatusOK, result)
+}
+
+// redrive sends a dead letter again.
+func (h *Handler) redrive(ctx context.Context, letter DeadLetter) error {
+	switch letter.Kind {
+	case deadLetterFederation:
+		if h.federation == nil {
+			return fmt.Errorf("federation is not enabled")
+		}
+		var payload federationDeadLetter
+		if err := json.Unmarshal(letter.Payload, &payload); err != nil {
+			return fmt.Errorf("invalid payload: %w", err)
+		}
+		h.federation.enqueueDelivery(payload.EventName, payload.Inbox, payload.Activity)
+		return nil
+	case deadLetterEmail:
+		if h.cfg.Mailer == nil {
+			return fmt.Errorf("email is not enabled")
+		}
+		var email Email
+		if err := json.Unmarshal(letter.Payload, &email); err != nil {
+			return fmt.Errorf("invalid payload: %w", err)
+		}
+		return h.cfg.Mailer.Send(ctx, email)
+	case deadLetterPost:
+		if h.cfg.WriteQueue == nil {
+			return fmt.Errorf("the write queue is not enabled")
+		}
+		var payload queuedPostDeadLetter
+		if err := json.Unmarshal(letter.Payload, &payload); err != nil {
+			return fmt.Errorf("invalid payload: %w", err)
+		}
+		p := &queuedPost{token: payload.Token, req: payload.Post, ipHash: payload.IPHash, editTokenHash: payload.EditTokenHash}
+		if !h.cfg.WriteQueue.enqueue(p) {
+			return fmt.Errorf("the write queue is full")
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown kind %q", letter.Kind)
+}
+
+// RecordDeadLetter saves a delivery that was given up on after attempts
+// tries.
+func (db *DB) RecordDeadLetter(ctx context.Context, kind, destination string, payload interface{}, errMsg string, attempts int) error {
+	payloadJSON, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode dead letter: %w", err)
+	}
+	_, err = db.conn.ExecContext(ctx, `
+		INSERT INTO dead_letters (kind, destination, payload, error, attempts)
+		VALUES ($1, $2, $3::jsonb, $4, $5)
+	`, kind, destination, string(payloadJSON), errMsg, attempts)
+	if err != nil {
+		return fmt.Errorf("failed to record dead letter: %w", err)
+	}
+	return nil
+}
+
+const deadLetterColumns = "id, kind, destination, payload, error, attempts, created_at, redriven_at"
+
+func scanDeadLetter(row rowScanner) (*DeadLetter, error) {
+	var letter DeadLetter
+	var payload []byte
+	if err := row.Scan(&letter.ID, &letter.Kind, &letter.Destination, &payload, &letter.Error,
+		&letter.Attempts, &letter.CreatedAt, &letter.RedrivenAt); err != nil {
+		return nil, err
+	}
+	letter.Payload = payload
+	return &letter, nil
+}
+
+// GetDeadLetters lists dead letters newest first, of one kind unless kind
+// is empty, and only those redriven or not unless redriven is nil.
+func (db *DB) GetDeadLetters(ctx context.Context, kind string, redriven *bool, limit, offset int) ([]DeadLetter, error) {
+	rows, err := db.conn.QueryContext(ctx, `
+		SELECT `+deadLetterColumns+`
+		FROM dead_letters
+		WHERE ($1::text = '' OR kind = $1)
+		AND ($2::boolean IS NULL OR (redriven_at IS NOT NULL) = $2)
+		ORDER BY created_at DESC, id DESC
+		LIMIT $3 OFFSET $4
+	`, kind, redriven, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query dead letters: %w", err)
+	}
+	defer rows.Close()
+
+	var letters []DeadLetter
+	for rows.Next() {
+		letter, err := scanDeadLetter(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan dead letter: %w", err)
+		}
+		letters = append(letters, *letter)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating dead letters: %w", err)
+	}
+
+	return letters, nil
+}
+
+// ClaimDeadLetters marks those of ids not yet redriven as redriven and
+// returns them.
+func (db *DB) ClaimDeadLetters(ctx context.Context, ids []int) ([]DeadLetter, error) {
+	rows, err := db.conn.QueryContext(ctx, `
+		UPDATE dead_letters SET redriven_at = NOW()
+		WHERE id = ANY($1) AND redriven_at IS NULL
+		RETURNING `+deadLetterColumns, ids)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim dead letters: %w", err)
+	}
+	defer rows.Close()
+
+	var letters []DeadLetter
+	for rows.Next() {
+		letter, err := scanDeadLetter(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan dead letter: %w", err)
+		}
+		letters = append(letters, *letter)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating dead letters: %w", err)
+	}
+
+	return letters, nil
+}
+
+// ReleaseDeadLetter puts a claimed dead letter back in the queue after
+// another failed attempt.
+func (db *DB) ReleaseDeadLetter(ctx context.Context, id int, errMsg string) error {
+	_, err := db.conn.ExecContext(ctx, `
+		UPDATE dead_letters SET redriven_at = NULL, error = $2, attempts = attempts + 1
+		WHERE id = $1
+	`, id, errMsg)
+	if err != nil {
+		return fmt.Errorf("failed to release dead letter: %w", err)
+	}
+	return nil
+}
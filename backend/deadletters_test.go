@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRedriveDeadLetters(t *testing.T) {
+	email := func(id int, to string) DeadLetter {
+		payload, _ := json.Marshal(Email{To: to, Subject: "New posts", Body: "..."})
+		return DeadLetter{ID: id, Kind: deadLetterEmail, Destination: to, Payload: payload, Error: "relay down", Attempts: maxDeliveryAttempts}
+	}
+	redriven := time.Now()
+	federated := DeadLetter{ID: 4, Kind: deadLetterFederation, Payload: json.RawMessage(`{"inbox":"https://remote.example/inbox"}`), Attempts: maxDeliveryAttempts}
+
+	tests := []struct {
+		name       string
+		body       string
+		mailerDown bool
+		want       RedriveResult
+		wantSent   int
+		wantStatus int
+	}{
+		{
+			name:       "sends pending emails and skips the rest",
+			body:       `{"ids":[1,2,3,99]}`,
+			want:       RedriveResult{Requeued: []int{1, 2}, Failed: []int{}, Missing: []int{3, 99}},
+			wantSent:   2,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "failed sends stay queued",
+			body:       `{"ids":[1]}`,
+			mailerDown: true,
+			want:       RedriveResult{Requeued: []int{}, Failed: []int{1}, Missing: []int{}},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "federation disabled",
+			body:       `{"ids":[4]}`,
+			want:       RedriveResult{Requeued: []int{}, Failed: []int{4}, Missing: []int{}},
+			wantStatus: http.StatusOK,
+		},
+		{name: "no ids", body: `{"ids":[]}`, wantStatus: http.StatusBadRequest},
+		{name: "too many ids", body: fmt.Sprintf(`{"ids":[%s1]}`, strings.Repeat("1,", maxRedriveIDs)), wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newFakeStore()
+			done := email(3, "c@example.com")
+			done.RedrivenAt = &redriven
+			store.deadLetters = []DeadLetter{email(1, "a@example.com"), email(2, "b@example.com"), done, federated}
+			mailer := &fakeMailer{fail: tt.mailerDown}
+			h := newTestHandler(store, HandlerConfig{Mailer: mailer})
+
+			rec := httptest.NewRecorder()
+			h.RedriveDeadLetters(rec, httptest.NewRequest(http.MethodPost, "/admin/dlq/redrive", strings.NewReader(tt.body)))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %s)", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+
+			var got RedriveResult
+			if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("result = %+v, want %+v", got, tt.want)
+			}
+			if len(mailer.sent) != tt.wantSent {
+				t.Errorf("sent %d emails, want %d", len(mailer.sent), tt.wantSent)
+			}
+			for _, letter := range store.deadLetters {
+				failed := false
+				for _, id := range got.Failed {
+					failed = failed || id == letter.ID
+				}
+				if failed && (letter.RedrivenAt != nil || letter.Attempts != maxDeliveryAttempts+1) {
+					t.Errorf("dead letter %d not released after failing again", letter.ID)
+				}
+			}
+			if len(store.audited) != 1 || store.audited[0] != "dlq.redrive" {
+				t.Errorf("audited %v, want [dlq.redrive]", store.audited)
+			}
+		})
+	}
+}
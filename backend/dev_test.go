@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDevFrontend(t *testing.T) {
+	dir := t.TempDir()
+	page := `<script>const API_URL = "https://example.com/api";</script>`
+	if err := os.WriteFile(filepath.Join(dir, "index.html"), []byte(page), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "styles.css"), []byte("body {}"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	h := devFrontend(dir)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if body := w.Body.String(); !strings.Contains(body, `const API_URL = "/api"`) || strings.Contains(body, "example.com") {
+		t.Errorf("index = %s, want the API pointed at this server", body)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/styles.css", nil))
+	if w.Code != 200 || w.Body.String() != "body {}" {
+		t.Errorf("styles.css = %d %q", w.Code, w.Body.String())
+	}
+}
+
+func TestWatchDevConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".env")
+	changed := make(chan struct{})
+	watchDevConfig(context.Background(), path, func() { close(changed) })
+
+	// Creating the file counts as a change
+	if err := os.WriteFile(path, []byte("PORT=9000\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-changed:
+	case <-time.After(5 * devConfigPollInterval):
+		t.Fatal("change not noticed")
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// deviceQuotaCode is the error code of a post refused by its event's
+// device post limit.
+const deviceQuotaCode = "device_quota_reached"
+
+// maxDevicePostLimit bounds the per-device limit an event can set.
+const maxDevicePostLimit = 1000
+
+// DeviceQuotaError is returned for a post from a device that has already
+// made its event's limit of posts.
+type DeviceQuotaError struct {
+	Limit int
+}
+
+func (e *DeviceQuotaError) Error() string {
+	if e.Limit == 1 {
+		return "You've already posted to this board, which allows one post each."
+	}
+	return fmt.Sprintf("You've reached this board's limit of %d posts each.", e.Limit)
+}
+
+// respondDeviceQuota writes the 403 for a *DeviceQuotaError, with its code
+// so clients can tell it from other refusals.
+func respondDeviceQuota(w http.ResponseWriter, err *DeviceQuotaError) {
+	respondWithJSON(w, http.StatusForbidden, map[string]string{
+		"error": err.Error(),
+		"code":  deviceQuotaCode,
+	})
+}
+
+// checkDeviceQuota applies the event's device post limit, so one
+// enthusiastic poster can't take over a small board. Posts are counted by
+// the poster's device token, or by their IP hash if they didn't send one.
+// It is checked before the post is written, so posts sent at the same
+// moment can go one over.
+func (h *Handler) checkDeviceQuota(ctx context.Context, req CreatePostRequest, ipHash string, settings EventSettings) error {
+	if settings.DevicePostLimit <= 0 {
+		return nil
+	}
+	count, err := h.db.CountDevicePosts(ctx, req.EventName, req.DeviceTokenHash, ipHash)
+	if err != nil {
+		return fmt.Errorf("failed to count device posts: %w", err)
+	}
+	if count >= settings.DevicePostLimit {
+		return &DeviceQuotaError{Limit: settings.DevicePostLimit}
+	}
+	return nil
+}
+
+// CountDevicePosts counts the posts to event from the device with
+// deviceTokenHash, or when it is empty, from ipHash.
+func (db *DB) CountDevicePosts(ctx context.Context, event, deviceTokenHash, ipHash string) (int, error) {
+	var count int
+	err := db.conn.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM posts
+		WHERE event_name = $1
+		AND CASE WHEN $2 <> '' THEN device_token_hash = $2 ELSE ip_hash = $3 END
+	`, event, deviceTokenHash, ipHash).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count device posts: %w", err)
+	}
+	return count, nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCreatePostDeviceQuota(t *testing.T) {
+	store := newFakeStore()
+	store.settings["Book Club"] = EventSettings{DevicePostLimit: 2, AllAges: true}
+	h := newTestHandler(store, HandlerConfig{})
+
+	post := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/posts", strings.NewReader(`{"event_name": "Book Club", "content": "hi"}`))
+		req.Header.Set("X-Device-Token", strings.Repeat("a", 16))
+		rec := httptest.NewRecorder()
+		h.CreatePost(rec, req)
+		return rec
+	}
+
+	for i := 0; i < 2; i++ {
+		if rec := post(); rec.Code != http.StatusCreated {
+			t.Fatalf("post %d: status = %d, want 201 (body %s)", i+1, rec.Code, rec.Body)
+		}
+	}
+	rec := post()
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("status = %d over the limit, want 403", rec.Code)
+	}
+	var body map[string]string
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body["code"] != deviceQuotaCode || body["error"] != "You've reached this board's limit of 2 posts each." {
+		t.Errorf("body = %v, want the device quota error", body)
+	}
+
+	store.fail["CountDevicePosts"] = true
+	assertError(t, post(), http.StatusInternalServerError, "Failed to create post")
+}
+
+func TestUpdateEventSettingsDevicePostLimit(t *testing.T) {
+	h := newTestHandler(newFakeStore(), HandlerConfig{})
+	for _, limit := range []string{"-1", "1001"} {
+		req := httptest.NewRequest(http.MethodPatch, "/admin/events/Book%20Club", strings.NewReader(`{"device_post_limit": `+limit+`}`))
+		req.SetPathValue("event", "Book Club")
+		rec := httptest.NewRecorder()
+		h.UpdateEventSettings(rec, req)
+		assertError(t, rec, http.StatusBadRequest, "device_post_limit must be between 0 and 1000")
+	}
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	discoverListSize       = 10
+	discoverNearbyRadiusKm = 50
+	discoverTrendingWindow = 24 * time.Hour
+	discoverUpcomingWindow = 14 * 24 * time.Hour
+
+	defaultNearbyRadiusKm = 25
+	maxNearbyRadiusKm     = 500
+	// nearbyBucketKm groups nearby events into distance bands; within a band
+	// the most recently active board comes first.
+	nearbyBucketKm = 5
+)
+
+// eventCategories are the categories an event can be filed under.
+var eventCategories = []string{"music", "conference", "sports", "campus", "transit"}
+
+// EventDetails describe what and where an event is, for discovery.
+type EventDetails struct {
+	Category  string     `json:"category,omitempty"`
+	Latitude  *float64   `json:"latitude,omitempty"`
+	Longitude *float64   `json:"longitude,omitempty"`
+	StartsAt  *time.Time `json:"starts_at,omitempty"`
+	EndsAt    *time.Time `json:"ends_at,omitempty"`
+	// Timezone is the IANA name of the timezone the event takes place in
+	Timezone string `json:"timezone,omitempty"`
+}
+
+// EventSummary is an event as listed on the discovery page.
+type EventSummary struct {
+	Name       string     `json:"name"`
+	Slug       string     `json:"slug"`
+	PostCount  int        `json:"post_count"`
+	LastPostAt *time.Time `json:"last_post_at,omitempty"`
+	EventDetails
+	// DistanceKm is set on location-based results.
+	DistanceKm *float64 `json:"distance_km,omitempty"`
+}
+
+// Discovery is the homepage's selection of events.
+type Discovery struct {
+	Trending []EventSummary `json:"trending"`
+	Nearby   []EventSummary `json:"nearby"`
+	Upcoming []EventSummary `json:"upcoming"`
+}
+
+// SetEventDetails handles PUT /admin/events/{event}/details. All details are
+// replaced; omitted fields are cleared.
+func (h *Handler) SetEventDetails(w http.ResponseWriter, r *http.Request) {
+	var req EventDetails
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if err := validateEventDetails(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	found, err := h.db.SetEventDetails(r.Context(), r.PathValue("event"), req)
+	if err != nil {
+		log.Printf("Error setting event details: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to update event")
+		return
+	}
+	if !found {
+		respondWithError(w, http.StatusNotFound, "Event not found")
+		return
+	}
+
+	h.audit(r, "event.set_details", "event", r.PathValue("event"), req)
+
+	h.GetEvent(w, r)
+}
+
+// Discover handles GET /api/discover. Nearby events are only included when
+// ?lat= and ?lon= are given.
+func (h *Handler) Discover(w http.ResponseWriter, r *http.Request) {
+	var discovery Discovery
+	var err error
+
+	discovery.Trending, err = h.hotTrending(r.Context())
+	if err != nil {
+		log.Printf("Error getting trending events: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve events")
+		return
+	}
+
+	discovery.Upcoming, err = h.db.UpcomingEvents(r.Context(), time.Now().Add(discoverUpcomingWindow), discoverListSize)
+	if err != nil {
+		log.Printf("Error getting upcoming events: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve events")
+		return
+	}
+
+	if r.URL.Query().Get("lat") != "" || r.URL.Query().Get("lon") != "" {
+		lat, lon, err := parseCoordinates(r)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		discovery.Nearby, err = h.db.NearbyEvents(r.Context(), lat, lon, discoverNearbyRadiusKm, discoverListSize, 0)
+		if err != nil {
+			log.Printf("Error getting nearby events: %v", err)
+			respondWithError(w, http.StatusInternalServerError, "Failed to retrieve events")
+			return
+		}
+	}
+
+	for _, list := range []*[]EventSummary{&discovery.Trending, &discovery.Nearby, &discovery.Upcoming} {
+		if *list == nil {
+			*list = []EventSummary{}
+		}
+	}
+
+	respondWithJSON(w, http.StatusOK, discovery)
+}
+
+// GetNearbyEvents handles GET /api/events/nearby?lat=&lon=&radius_km=. This
+// route shadows an event named or slugged "nearby".
+func (h *Handler) GetNearbyEvents(w http.ResponseWriter, r *http.Request) {
+	lat, lon, err := parseCoordinates(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	radius := float64(defaultNearbyRadiusKm)
+	if s := r.URL.Query().Get("radius_km"); s != "" {
+		radius, err = strconv.ParseFloat(s, 64)
+		if err != nil || radius <= 0 || radius > maxNearbyRadiusKm {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("radius_km must be a number between 0 and %d", maxNearbyRadiusKm))
+			return
+		}
+	}
+
+	limit, offset := parsePagination(r)
+
+	events, err := h.db.NearbyEvents(r.Context(), lat, lon, radius, limit, offset)
+	if err != nil {
+		log.Printf("Error getting nearby events: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve events")
+		return
+	}
+
+	if events == nil {
+		events = []EventSummary{}
+	}
+
+	respondWithJSON(w, http.StatusOK, events)
+}
+
+func validateEventDetails(d *EventDetails) error {
+	d.Category = strings.ToLower(strings.TrimSpace(d.Category))
+	if d.Category != "" && !containsString(eventCategories, d.Category) {
+		return &ValidationError{Message: "category must be one of " + strings.Join(eventCategories, ", ")}
+	}
+
+	if (d.Latitude == nil) != (d.Longitude == nil) {
+		return &ValidationError{Message: "latitude and longitude must be given together"}
+	}
+	if d.Latitude != nil && (*d.Latitude < -90 || *d.Latitude > 90) {
+		return &ValidationError{Message: "latitude must be between -90 and 90"}
+	}
+	if d.Longitude != nil && (*d.Longitude < -180 || *d.Longitude > 180) {
+		return &ValidationError{Message: "longitude must be between -180 and 180"}
+	}
+
+	if d.StartsAt != nil && d.EndsAt != nil && d.EndsAt.Before(*d.StartsAt) {
+		return &ValidationError{Message: "ends_at must not be before starts_at"}
+	}
+
+	d.Timezone = strings.TrimSpace(d.Timezone)
+	if d.Timezone != "" {
+		if _, err := time.LoadLocation(d.Timezone); err != nil {
+			return &ValidationError{Message: "timezone must be an IANA timezone, such as Europe/London"}
+		}
+	}
+
+	return nil
+}
+
+// parseCoordinates reads the ?lat= and ?lon= query parameters.
+func parseCoordinates(r *http.Request) (lat, lon float64, err error) {
+	lat, err = strconv.ParseFloat(r.URL.Query().Get("lat"), 64)
+	if err != nil || lat < -90 || lat > 90 {
+		return 0, 0, &ValidationError{Message: "lat must be a number between -90 and 90"}
+	}
+	lon, err = strconv.ParseFloat(r.URL.Query().Get("lon"), 64)
+	if err != nil || lon < -180 || lon > 180 {
+		return 0, 0, &ValidationError{Message: "lon must be a number between -180 and 180"}
+	}
+	return lat, lon, nil
+}
+
+// eventSummaryColumns selects an EventSummary from events aliased e.
+const eventSummaryColumns = `e.name, e.slug,
+	(SELECT COUNT(*) FROM posts p WHERE p.event_name = e.name) AS post_count,
+	(SELECT MAX(p.created_at) FROM posts p WHERE p.event_name = e.name) AS last_post_at,
+	COALESCE(e.category, '') AS category, e.latitude, e.longitude, e.starts_at, e.ends_at,
+	COALESCE(e.timezone, '') AS timezone`
+
+// distanceKmSQL is the great-circle distance in km from ($1, $2) to the
+// event aliased e. There's no PostGIS; at discovery scale a scan is fine.
+const distanceKmSQL = `(6371 * 2 * ASIN(SQRT(
+	POWER(SIN(RADIANS(e.latitude - $1) / 2), 2) +
+	COS(RADIANS($1)) * COS(RADIANS(e.latitude)) * POWER(SIN(RADIANS(e.longitude - $2) / 2), 2)
+)))`
+
+func (db *DB) queryEventSummaries(ctx context.Context, withDistance bool, query string, args ...interface{}) ([]EventSummary, error) {
+	rows, err := db.conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query events: %w", err)
+	}
+	defer rows.Close()
+
+	var events []EventSummary
+	for rows.Next() {
+		var e EventSummary
+		dest := []interface{}{&e.Name, &e.Slug, &e.PostCount, &e.LastPostAt, &e.Category, &e.Latitude, &e.Longitude, &e.StartsAt, &e.EndsAt, &e.Timezone}
+		if withDistance {
+			dest = append(dest, &e.DistanceKm)
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, fmt.Errorf("failed to scan event: %w", err)
+		}
+		events = append(events, e)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating events: %w", err)
+	}
+
+	return events, nil
+}
+
+// TrendingEvents returns the events with the most posts since the given time
+func (db *DB) TrendingEvents(ctx context.Context, since time.Time, limit int) ([]EventSummary, error) {
+	return db.queryEventSummaries(ctx, false, `
+		SELECT `+eventSummaryColumns+`
+		FROM events e
+		JOIN (
+			SELECT event_name, COUNT(*) AS recent
+			FROM posts
+			WHERE created_at > $1
+			GROUP BY event_name
+		) t ON t.event_name = e.name
+		ORDER BY t.recent DESC, e.name
+		LIMIT $2
+	`, since, limit)
+}
+
+// UpcomingEvents returns events starting between now and the given time
+func (db *DB) UpcomingEvents(ctx context.Context, until time.Time, limit int) ([]EventSummary, error) {
+	return db.queryEventSummaries(ctx, false, `
+		SELECT `+eventSummaryColumns+`
+		FROM events e
+		WHERE e.starts_at > NOW() AND e.starts_at <= $1
+		ORDER BY e.starts_at
+		LIMIT $2
+	`, until, limit)
+}
+
+// NearbyEvents returns located events within radiusKm, ordered by distance
+// band and then by most recent post, so a busy board a few minutes away
+// comes before a quiet one next door.
+func (db *DB) NearbyEvents(ctx context.Context, lat, lon, radiusKm float64, limit, offset int) ([]EventSummary, error) {
+	return db.queryEventSummaries(ctx, true, `
+		SELECT name, slug, post_count, last_post_at, category, latitude, longitude, starts_at, ends_at, timezone, distance_km
+		FROM (
+			SELECT `+eventSummaryColumns+`, `+distanceKmSQL+` AS distance_km
+			FROM events e
+			WHERE e.latitude IS NOT NULL AND e.longitude IS NOT NULL
+		) nearby
+		WHERE distance_km <= $3
+		ORDER BY FLOOR(distance_km / $6), last_post_at DESC NULLS LAST, distance_km
+		LIMIT $4 OFFSET $5
+	`, lat, lon, radiusKm, limit, offset, nearbyBucketKm)
+}
+
+// SetEventDetails replaces an event's details, reporting whether it exists.
+func (db *DB) SetEventDetails(ctx context.Context, name string, d EventDetails) (bool, error) {
+	result, err := db.conn.ExecContext(ctx, `
+		UPDATE events
+		SET category = NULLIF($2, ''), latitude = $3, longitude = $4, starts_at = $5, ends_at = $6, timezone = NULLIF($7, '')
+		WHERE name = $1
+	`, name, d.Category, d.Latitude, d.Longitude, d.StartsAt, d.EndsAt, d.Timezone)
+	if err != nil {
+		return false, fmt.Errorf("failed to set event details: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to set event details: %w", err)
+	}
+
+	return affected > 0, nil
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	deliveryTimeout = 30 * time.Second
+	// maxQueuedPerDestination caps the deliveries waiting for one
+	// destination; more are dropped
+	maxQueuedPerDestination = 1000
+	// circuitThreshold is how many deliveries in a row must fail before a
+	// destination's circuit opens
+	circuitThreshold = 5
+	circuitCooldown  = 5 * time.Minute
+	// maxDeliveryAttempts is how many times a delivery is tried before it
+	// is given up on
+	maxDeliveryAttempts = 4
+	// deliveryRetryDelay is the wait before the first retry; it doubles
+	// with each one after
+	deliveryRetryDelay = 10 * time.Second
+)
+
+var (
+	errCircuitOpen = errors.New("destination is failing; its circuit is open")
+	errQueueFull   = errors.New("destination's queue is full")
+)
+
+// Dispatcher delivers to remote endpoints on a fixed pool of workers.
+// Deliveries to one destination run one at a time and in order, and the
+// workers take turns between destinations, so a slow endpoint ties up at
+// most one worker and delays only its own deliveries. After
+// circuitThreshold failures in a row a destination's circuit opens: its
+// queued deliveries are dropped, and new ones are refused for
+// circuitCooldown. The first delivery after that decides whether it closes
+// or opens again.
+//
+// A failed delivery is retried up to maxDeliveryAttempts times, with the
+// destination's later deliveries held behind it. Every delivery given up on,
+// whether out of attempts, dropped by the circuit or refused, is handed to
+// its failed func, so nothing is lost without a trace.
+type Dispatcher struct {
+	workers int
+	// retryDelay is deliveryRetryDelay outside tests
+	retryDelay time.Duration
+
+	mu   sync.Mutex
+	cond *sync.Cond
+	// destinations have queued deliveries or an open circuit
+	destinations map[string]*destination
+	// ready are the destinations with deliveries and no worker on them,
+	// in turn order
+	ready   []*destination
+	stopped bool
+}
+
+type delivery struct {
+	deliver func(ctx context.Context) error
+	// failed is called, off the workers' lock, with the last error and the
+	// attempts made once the delivery is given up on
+	failed   func(err error, attempts int)
+	attempts int
+}
+
+type destination struct {
+	key   string
+	queue []*delivery
+	// busy is set while the destination is in ready or a worker has it
+	busy      bool
+	failures  int
+	openUntil time.Time
+}
+
+func NewDispatcher(workers int) *Dispatcher {
+	d := &Dispatcher{workers: max(workers, 1), retryDelay: deliveryRetryDelay, destinations: make(map[string]*destination)}
+	d.cond = sync.NewCond(&d.mu)
+	return d
+}
+
+// Enqueue queues a delivery to the destination named by key, reporting
+// false if it was refused because the destination's circuit is open or its
+// queue is full. failed, which may be nil, is called if the delivery is
+// refused or given up on.
+func (d *Dispatcher) Enqueue(key string, deliver func(ctx context.Context) error, failed func(err error, attempts int)) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	dest := d.destinations[key]
+	if dest == nil {
+		dest = &destination{key: key}
+		d.destinations[key] = dest
+	}
+	var refused error
+	switch {
+	case time.Now().Before(dest.openUntil):
+		refused = errCircuitOpen
+	case len(dest.queue) >= maxQueuedPerDestination:
+		refused = errQueueFull
+	}
+	if refused != nil {
+		if failed != nil {
+			go failed(refused, 0)
+		}
+		return false
+	}
+
+	dest.queue = append(dest.queue, &delivery{deliver: deliver, failed: failed})
+	if !dest.busy {
+		dest.busy = true
+		d.ready = append(d.ready, dest)
+		d.cond.Signal()
+	}
+	return true
+}
+
+// Run works through deliveries until ctx is cancelled. Deliveries still
+// queued or waiting to be retried then are dropped.
+func (d *Dispatcher) Run(ctx context.Context) {
+	stop := context.AfterFunc(ctx, func() {
+		d.mu.Lock()
+		d.stopped = true
+		d.mu.Unlock()
+		d.cond.Broadcast()
+	})
+	defer stop()
+
+	var wg sync.WaitGroup
+	for i := 0; i < d.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.work(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+func (d *Dispatcher) work(ctx context.Context) {
+	for {
+		d.mu.Lock()
+		for len(d.ready) == 0 && !d.stopped {
+			d.cond.Wait()
+		}
+		if d.stopped {
+			d.mu.Unlock()
+			return
+		}
+		dest := d.ready[0]
+		d.ready = d.ready[1:]
+		next := dest.queue[0]
+		dest.queue = dest.queue[1:]
+		d.mu.Unlock()
+
+		deliveryCtx, cancel := context.WithTimeout(ctx, deliveryTimeout)
+		err := next.deliver(deliveryCtx)
+		cancel()
+		next.attempts++
+
+		d.mu.Lock()
+		dead := d.finish(dest, next, err, time.Now())
+		d.mu.Unlock()
+
+		for _, dl := range dead {
+			if dl.failed == nil {
+				continue
+			}
+			if dl == next {
+				dl.failed(err, dl.attempts)
+			} else {
+				dl.failed(fmt.Errorf("%w: %v", errCircuitOpen, err), dl.attempts)
+			}
+		}
+	}
+}
+
+// finish records the outcome of a delivery to dest, then either schedules
+// its retry or gives the destination's next delivery a turn. It returns the
+// deliveries given up on. The caller holds d.mu.
+func (d *Dispatcher) finish(dest *destination, dl *delivery, err error, now time.Time) (dead []*delivery) {
+	if err == nil {
+		dest.failures = 0
+	} else {
+		dest.failures++
+		switch {
+		case dest.failures >= circuitThreshold:
+			log.Printf("Delivery to %s failed %d times in a row, pausing it for %s and dropping %d queued: %v",
+				dest.key, dest.failures, circuitCooldown, len(dest.queue), err)
+			dest.openUntil = now.Add(circuitCooldown)
+			dead = append([]*delivery{dl}, dest.queue...)
+			dest.queue = nil
+		case dl.attempts < maxDeliveryAttempts:
+			delay := d.retryDelay << (dl.attempts - 1)
+			log.Printf("Delivery to %s failed, retrying in %s: %v", dest.key, delay, err)
+			// The destination stays busy until the retry, so the deliveries
+			// behind it keep their order
+			dest.queue = append([]*delivery{dl}, dest.queue...)
+			time.AfterFunc(delay, func() {
+				d.mu.Lock()
+				defer d.mu.Unlock()
+				if !d.stopped {
+					d.ready = append(d.ready, dest)
+					d.cond.Signal()
+				}
+			})
+			return nil
+		default:
+			log.Printf("Delivery to %s failed %d times, giving up: %v", dest.key, dl.attempts, err)
+			dead = []*delivery{dl}
+		}
+	}
+
+	if len(dest.queue) > 0 {
+		d.ready = append(d.ready, dest)
+		d.cond.Signal()
+		return dead
+	}
+	dest.busy = false
+	if dest.failures == 0 {
+		delete(d.destinations, dest.key)
+	}
+	return dead
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func runDispatcher(t *testing.T, workers int) *Dispatcher {
+	t.Helper()
+	d := NewDispatcher(workers)
+	d.retryDelay = time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		d.Run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return d
+}
+
+// TestDispatcherOrder checks that deliveries to one destination run one at
+// a time and in order, even with workers to spare.
+func TestDispatcherOrder(t *testing.T) {
+	d := runDispatcher(t, 4)
+
+	var mu sync.Mutex
+	var got []string
+	active := map[string]int{}
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		for _, key := range []string{"a.example", "b.example"} {
+			wg.Add(1)
+			item := fmt.Sprintf("%s/%d", key, i)
+			d.Enqueue(key, func(ctx context.Context) error {
+				defer wg.Done()
+				mu.Lock()
+				active[key]++
+				if active[key] > 1 {
+					t.Errorf("%d deliveries to %s at once", active[key], key)
+				}
+				if key == "a.example" {
+					got = append(got, item)
+				}
+				mu.Unlock()
+				time.Sleep(time.Millisecond)
+				mu.Lock()
+				active[key]--
+				mu.Unlock()
+				return nil
+			}, nil)
+		}
+	}
+	wg.Wait()
+
+	for i, item := range got {
+		if want := fmt.Sprintf("a.example/%d", i); item != want {
+			t.Fatalf("delivery %d was %s, want %s", i, item, want)
+		}
+	}
+}
+
+// TestDispatcherSlowDestination checks that a hung destination holds one
+// worker, not everyone's deliveries.
+func TestDispatcherSlowDestination(t *testing.T) {
+	d := runDispatcher(t, 2)
+
+	hung := make(chan struct{})
+	defer close(hung)
+	for i := 0; i < 3; i++ {
+		d.Enqueue("slow.example", func(ctx context.Context) error {
+			select {
+			case <-hung:
+			case <-ctx.Done():
+			}
+			return nil
+		}, nil)
+	}
+
+	delivered := make(chan struct{}, 5)
+	for i := 0; i < 5; i++ {
+		d.Enqueue(fmt.Sprintf("fast%d.example", i%2), func(ctx context.Context) error {
+			delivered <- struct{}{}
+			return nil
+		}, nil)
+	}
+	for i := 0; i < 5; i++ {
+		select {
+		case <-delivered:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("only %d deliveries to other destinations made past the hung one", i)
+		}
+	}
+}
+
+// TestDispatcherRetry checks that a failed delivery is retried ahead of the
+// deliveries queued behind it.
+func TestDispatcherRetry(t *testing.T) {
+	d := runDispatcher(t, 2)
+
+	var mu sync.Mutex
+	var got []string
+	done := make(chan struct{})
+	tries := 0
+	d.Enqueue("flaky.example", func(ctx context.Context) error {
+		mu.Lock()
+		defer mu.Unlock()
+		tries++
+		got = append(got, fmt.Sprintf("first/%d", tries))
+		if tries < maxDeliveryAttempts {
+			return errors.New("502")
+		}
+		return nil
+	}, func(err error, attempts int) {
+		t.Errorf("delivery given up on after %d attempts: %v", attempts, err)
+	})
+	d.Enqueue("flaky.example", func(ctx context.Context) error {
+		mu.Lock()
+		defer mu.Unlock()
+		got = append(got, "second")
+		close(done)
+		return nil
+	}, nil)
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("deliveries never completed")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{"first/1", "first/2", "first/3", "first/4", "second"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("deliveries ran as %v, want %v", got, want)
+	}
+}
+
+func TestDispatcherCircuit(t *testing.T) {
+	d := runDispatcher(t, 1)
+
+	type failure struct {
+		err      error
+		attempts int
+	}
+	failures := make(chan failure, 100)
+	failed := func(err error, attempts int) {
+		failures <- failure{err, attempts}
+	}
+	attempts := make(chan struct{}, 100)
+	fail := func(ctx context.Context) error {
+		attempts <- struct{}{}
+		return errors.New("503")
+	}
+	// Block the worker so the whole batch is queued before any fails
+	release := make(chan struct{})
+	d.Enqueue("dead.example", func(ctx context.Context) error {
+		<-release
+		return errors.New("503")
+	}, failed)
+	queued := circuitThreshold + 3
+	for i := 0; i < queued; i++ {
+		if !d.Enqueue("dead.example", fail, failed) {
+			t.Fatalf("delivery %d refused before the circuit opened", i)
+		}
+	}
+	close(release)
+
+	// The first delivery uses up its attempts, the next one's failure opens
+	// the circuit, and the rest are dropped
+	var dropped int
+	for i := 0; i < queued+1; i++ {
+		select {
+		case f := <-failures:
+			if i == 0 && f.attempts != maxDeliveryAttempts {
+				t.Errorf("first delivery given up on after %d attempts, want %d", f.attempts, maxDeliveryAttempts)
+			}
+			if errors.Is(f.err, errCircuitOpen) && f.attempts == 0 {
+				dropped++
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("only %d of %d deliveries reported failed", i, queued+1)
+		}
+	}
+	if dropped != queued-1 {
+		t.Errorf("%d deliveries dropped by the circuit, want %d", dropped, queued-1)
+	}
+	if n := len(attempts); n != 1 {
+		t.Errorf("%d queued deliveries attempted, want 1", n)
+	}
+
+	if d.Enqueue("dead.example", fail, failed) {
+		t.Fatal("delivery accepted with the circuit open")
+	}
+	select {
+	case f := <-failures:
+		if !errors.Is(f.err, errCircuitOpen) {
+			t.Errorf("refused delivery failed with %v", f.err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("refused delivery not reported failed")
+	}
+
+	// Other destinations are unaffected
+	ok := make(chan struct{})
+	d.Enqueue("live.example", func(ctx context.Context) error {
+		close(ok)
+		return nil
+	}, nil)
+	<-ok
+}
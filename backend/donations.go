@@ -0,0 +1,320 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// stripeSignatureTolerance is how old a signed Stripe webhook may be,
+	// which is Stripe's own default
+	stripeSignatureTolerance = 5 * time.Minute
+	maxDonationWebhookBytes  = 64 << 10
+	supportersCacheSeconds   = 300
+)
+
+// Donation is a payment recorded from a provider's webhook.
+type Donation struct {
+	Provider   string
+	ProviderID string
+	// EventName is the event the donation was made for, if any; names and
+	// slugs of events that don't exist are dropped
+	EventName string
+	// DeviceTokenHash is the donor's device, if they gave one, and earns
+	// it a supporter badge
+	DeviceTokenHash string
+	AmountCents     int64
+	Currency        string
+}
+
+// SupporterCount is the number of people who have donated, to the site or
+// to one event.
+type SupporterCount struct {
+	Event      string `json:"event,omitempty"`
+	Supporters int    `json:"supporters"`
+}
+
+// SupporterStatus is a device's supporter badge.
+type SupporterStatus struct {
+	Supporter bool `json:"supporter"`
+	// Since is the device's first donation
+	Since *time.Time `json:"since,omitempty"`
+}
+
+// Donations receives payment webhooks from Stripe and Ko-fi, so a
+// self-hosted site can take donations without running anything else, and
+// serves supporter counts and badges. Each provider is enabled by setting
+// its secret.
+type Donations struct {
+	db           *DB
+	stripeSecret string
+	kofiToken    string
+}
+
+func NewDonations(db *DB, stripeSecret, kofiToken string) *Donations {
+	return &Donations{db: db, stripeSecret: stripeSecret, kofiToken: kofiToken}
+}
+
+type stripeEvent struct {
+	ID   string `json:"id"`
+	Type string `json:"type"`
+	Data struct {
+		Object struct {
+			ID                string            `json:"id"`
+			ClientReferenceID string            `json:"client_reference_id"`
+			AmountTotal       int64             `json:"amount_total"`
+			Currency          string            `json:"currency"`
+			PaymentStatus     string            `json:"payment_status"`
+			Metadata          map[string]string `json:"metadata"`
+		} `json:"object"`
+	} `json:"data"`
+}
+
+// Stripe handles POST /api/donations/stripe. Donations are completed
+// Checkout Sessions: the event is the "event" metadata key, which can be
+// set on a Payment Link, and the device token is the client_reference_id.
+func (d *Donations) Stripe(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxDonationWebhookBytes))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if !validStripeSignature(r.Header.Get("Stripe-Signature"), body, d.stripeSecret, time.Now()) {
+		respondWithError(w, http.StatusForbidden, "Invalid signature")
+		return
+	}
+
+	var event stripeEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	// Stripe sends whichever events the endpoint subscribes to; anything
+	// but a paid checkout is acknowledged and ignored
+	session := event.Data.Object
+	if event.Type != "checkout.session.completed" || session.PaymentStatus != "paid" {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	d.record(w, r, Donation{
+		Provider:        "stripe",
+		ProviderID:      session.ID,
+		EventName:       session.Metadata["event"],
+		DeviceTokenHash: donorDevice(session.ClientReferenceID),
+		AmountCents:     session.AmountTotal,
+		Currency:        session.Currency,
+	})
+}
+
+type kofiPayload struct {
+	VerificationToken string `json:"verification_token"`
+	MessageID         string `json:"message_id"`
+	Type              string `json:"type"`
+	Amount            string `json:"amount"`
+	Currency          string `json:"currency"`
+}
+
+// Kofi handles POST /api/donations/kofi. Ko-fi can't pass anything through
+// a payment, so donations count towards the event in the webhook URL's
+// ?event= if it has one, and never earn a device a badge.
+func (d *Donations) Kofi(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxDonationWebhookBytes)
+	if err := r.ParseForm(); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid form body")
+		return
+	}
+
+	var payload kofiPayload
+	if err := json.Unmarshal([]byte(r.PostForm.Get("data")), &payload); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if !hmac.Equal([]byte(payload.VerificationToken), []byte(d.kofiToken)) {
+		respondWithError(w, http.StatusForbidden, "Invalid verification token")
+		return
+	}
+
+	amount, err := strconv.ParseFloat(payload.Amount, 64)
+	if err != nil || amount <= 0 || payload.MessageID == "" {
+		respondWithError(w, http.StatusBadRequest, "Invalid donation")
+		return
+	}
+
+	d.record(w, r, Donation{
+		Provider:    "kofi",
+		ProviderID:  payload.MessageID,
+		EventName:   r.URL.Query().Get("event"),
+		AmountCents: int64(math.Round(amount * 100)),
+		Currency:    payload.Currency,
+	})
+}
+
+func (d *Donations) record(w http.ResponseWriter, r *http.Request, donation Donation) {
+	donation.Currency = strings.ToUpper(donation.Currency)
+	if donation.ProviderID == "" || len(donation.Currency) != 3 {
+		respondWithError(w, http.StatusBadRequest, "Invalid donation")
+		return
+	}
+
+	recorded, err := d.db.RecordDonation(r.Context(), donation)
+	if err != nil {
+		// A 5xx has the provider retry later
+		log.Printf("Error recording %s donation: %v", donation.Provider, err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to record donation")
+		return
+	}
+	if recorded {
+		log.Printf("Recorded %s donation %s", donation.Provider, donation.ProviderID)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetSupporters handles GET /api/supporters, for the whole site or the
+// event in ?event=.
+func (d *Donations) GetSupporters(w http.ResponseWriter, r *http.Request) {
+	event := r.URL.Query().Get("event")
+	count, err := d.db.CountSupporters(r.Context(), event)
+	if err != nil {
+		log.Printf("Error counting supporters: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve supporters")
+		return
+	}
+
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", supportersCacheSeconds))
+	respondWithJSON(w, http.StatusOK, SupporterCount{Event: event, Supporters: count})
+}
+
+// GetMySupporter handles GET /api/me/supporter for the device in
+// X-Device-Token.
+func (d *Donations) GetMySupporter(w http.ResponseWriter, r *http.Request) {
+	tokenHash, ok := deviceToken(w, r)
+	if !ok {
+		return
+	}
+
+	since, err := d.db.GetSupporterSince(r.Context(), tokenHash)
+	if err != nil {
+		log.Printf("Error getting supporter status: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve supporter status")
+		return
+	}
+
+	w.Header().Set("Cache-Control", "private, no-cache")
+	w.Header().Set("Vary", "X-Device-Token")
+	respondWithJSON(w, http.StatusOK, SupporterStatus{Supporter: since != nil, Since: since})
+}
+
+// validStripeSignature checks a Stripe-Signature header of the form
+// "t=timestamp,v1=signature,...", where a v1 signature is the hex
+// HMAC-SHA256, keyed with the endpoint's secret, of "timestamp.body".
+// Stripe sends several v1 signatures while a secret is being rolled.
+func validStripeSignature(header string, body []byte, secret string, now time.Time) bool {
+	var timestamp string
+	var signatures []string
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || secret == "" {
+		return false
+	}
+	if age := now.Sub(time.Unix(seconds, 0)); age > stripeSignatureTolerance || age < -stripeSignatureTolerance {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	expected := hex.EncodeToString(mac.Sum(nil))
+
+	for _, signature := range signatures {
+		if hmac.Equal([]byte(expected), []byte(signature)) {
+			return true
+		}
+	}
+	return false
+}
+
+// donorDevice hashes a device token passed through a payment, ignoring
+// anything that can't be one.
+func donorDevice(token string) string {
+	if len(token) < 16 || len(token) > 128 {
+		return ""
+	}
+	return hashToken(token)
+}
+
+// RecordDonation stores a donation, reporting false if it was already
+// recorded, as providers resend webhooks until they are acknowledged.
+func (db *DB) RecordDonation(ctx context.Context, d Donation) (bool, error) {
+	result, err := db.conn.ExecContext(ctx, `
+		INSERT INTO donations (provider, provider_id, event_name, device_token_hash, amount_cents, currency)
+		VALUES ($1, $2, (SELECT name FROM events WHERE name = $3 OR slug = $3 LIMIT 1), NULLIF($4, ''), $5, $6)
+		ON CONFLICT (provider, provider_id) DO NOTHING
+	`, d.Provider, d.ProviderID, d.EventName, d.DeviceTokenHash, d.AmountCents, d.Currency)
+	if err != nil {
+		return false, fmt.Errorf("failed to record donation: %w", err)
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to record donation: %w", err)
+	}
+	return n > 0, nil
+}
+
+// CountSupporters counts donors to the named event, by name or slug, or
+// to the whole site when event is empty. Donations from the same device
+// count once; those without one each count.
+func (db *DB) CountSupporters(ctx context.Context, event string) (int, error) {
+	var count int
+	err := db.reader(ctx).QueryRowContext(ctx, `
+		SELECT COUNT(DISTINCT COALESCE(device_token_hash, provider || ':' || provider_id))
+		FROM donations
+		WHERE $1 = '' OR event_name = (SELECT name FROM events WHERE name = $1 OR slug = $1 LIMIT 1)
+	`, event).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count supporters: %w", err)
+	}
+	return count, nil
+}
+
+// GetSupporterSince returns when a device first donated, or nil if it
+// never has.
+func (db *DB) GetSupporterSince(ctx context.Context, deviceTokenHash string) (*time.Time, error) {
+	var since sql.NullTime
+	err := db.reader(ctx).QueryRowContext(ctx, `
+		SELECT MIN(created_at) FROM donations WHERE device_token_hash = $1
+	`, deviceTokenHash).Scan(&since)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get supporter status: %w", err)
+	}
+	if !since.Valid {
+		return nil, nil
+	}
+	return &since.Time, nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func signStripe(body, secret string, at time.Time) string {
+	timestamp := fmt.Sprint(at.Unix())
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "." + body))
+	return "t=" + timestamp + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestValidStripeSignature(t *testing.T) {
+	now := time.Unix(1_800_000_000, 0)
+	body := `{"id":"evt_1"}`
+	signed := signStripe(body, "whsec_test", now)
+
+	tests := []struct {
+		name   string
+		header string
+		body   string
+		now    time.Time
+		want   bool
+	}{
+		{"valid", signed, body, now, true},
+		{"rolled secret", signStripe(body, "whsec_old", now) + "," + strings.Split(signed, ",")[1], body, now, true},
+		{"wrong secret", signStripe(body, "whsec_other", now), body, now, false},
+		{"changed body", signed, `{"id":"evt_2"}`, now, false},
+		{"too old", signed, body, now.Add(6 * time.Minute), false},
+		{"no timestamp", strings.Split(signed, ",")[1], body, now, false},
+		{"empty", "", body, now, false},
+	}
+	for _, tt := range tests {
+		if got := validStripeSignature(tt.header, []byte(tt.body), "whsec_test", tt.now); got != tt.want {
+			t.Errorf("%s: valid = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDonationWebhooksRejected(t *testing.T) {
+	d := NewDonations(nil, "whsec_test", "kofi-token")
+
+	req := httptest.NewRequest(http.MethodPost, "/api/donations/stripe", strings.NewReader(`{}`))
+	req.Header.Set("Stripe-Signature", signStripe(`{}`, "whsec_other", time.Now()))
+	rec := httptest.NewRecorder()
+	d.Stripe(rec, req)
+	assertError(t, rec, http.StatusForbidden, "Invalid signature")
+
+	form := url.Values{"data": {`{"verification_token":"guess","message_id":"m1","amount":"3.00","currency":"USD"}`}}
+	req = httptest.NewRequest(http.MethodPost, "/api/donations/kofi", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec = httptest.NewRecorder()
+	d.Kofi(rec, req)
+	assertError(t, rec, http.StatusForbidden, "Invalid verification token")
+}
+
+// TestStripeIgnoresOtherEvents checks events other than a paid checkout
+// are acknowledged without being recorded.
+func TestStripeIgnoresOtherEvents(t *testing.T) {
+	d := NewDonations(nil, "whsec_test", "")
+	for _, body := range []string{
+		`{"type":"invoice.paid","data":{"object":{"id":"in_1"}}}`,
+		`{"type":"checkout.session.completed","data":{"object":{"id":"cs_1","payment_status":"unpaid"}}}`,
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/api/donations/stripe", strings.NewReader(body))
+		req.Header.Set("Stripe-Signature", signStripe(body, "whsec_test", time.Now()))
+		rec := httptest.NewRecorder()
+		d.Stripe(rec, req)
+		if rec.Code != http.StatusNoContent {
+			t.Errorf("%s: status = %d, want 204", body, rec.Code)
+		}
+	}
+}
+
+// TestDonations checks retried webhooks are recorded once and a device
+// counts as one supporter however often it donates.
+func TestDonations(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	event := fmt.Sprintf("Donations Test %d", time.Now().UnixNano())
+	if _, err := db.CreatePost(ctx, CreatePostRequest{EventName: event, Content: "hello", Age: 25, Location: "x"}, "donations-test", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	device := hashToken(event + " device")
+	donations := []Donation{
+		{Provider: "stripe", ProviderID: event + " 1", EventName: event, DeviceTokenHash: device, AmountCents: 500, Currency: "USD"},
+		{Provider: "stripe", ProviderID: event + " 1", EventName: event, DeviceTokenHash: device, AmountCents: 500, Currency: "USD"},
+		{Provider: "stripe", ProviderID: event + " 2", EventName: event, DeviceTokenHash: device, AmountCents: 300, Currency: "USD"},
+		{Provider: "kofi", ProviderID: event + " 3", EventName: event, AmountCents: 300, Currency: "GBP"},
+	}
+	for i, d := range donations {
+		recorded, err := db.RecordDonation(ctx, d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := i != 1; recorded != want {
+			t.Errorf("donation %d recorded = %v, want %v", i+1, recorded, want)
+		}
+	}
+
+	count, err := db.CountSupporters(ctx, event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("supporters = %d, want 2", count)
+	}
+
+	since, err := db.GetSupporterSince(ctx, device)
+	if err != nil || since == nil {
+		t.Errorf("supporter since = %v, %v; want a time", since, err)
+	}
+	if since, err := db.GetSupporterSince(ctx, hashToken(event+" stranger")); err != nil || since != nil {
+		t.Errorf("stranger supporter since = %v, %v; want nil", since, err)
+	}
+}
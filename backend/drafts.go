@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+const draftJanitorInterval = time.Hour
+
+// Draft is an unfinished post saved by a device, so it survives the app
+// being closed or the phone dying. The content is whatever the client wants
+// to restore, typically its form state.
+type Draft struct {
+	Content   json.RawMessage `json:"draft"`
+	UpdatedAt time.Time       `json:"updated_at"`
+	ExpiresAt time.Time       `json:"expires_at"`
+}
+
+// Drafts stores one draft per device. Devices identify themselves with a
+// random token they generate and keep, sent in the X-Device-Token header;
+// only its hash is stored.
+type Drafts struct {
+	db       *DB
+	ttl      time.Duration
+	maxBytes int
+}
+
+func NewDrafts(db *DB, ttl time.Duration, maxBytes int) *Drafts {
+	return &Drafts{db: db, ttl: ttl, maxBytes: maxBytes}
+}
+
+// deviceToken returns the hash of the request's device token, writing an
+// error response if it is missing or malformed.
+func deviceToken(w http.ResponseWriter, r *http.Request) (string, bool) {
+	token := r.Header.Get("X-Device-Token")
+	if len(token) < 16 || len(token) > 128 {
+		respondWithError(w, http.StatusBadRequest, "X-Device-Token must be a random string of 16 to 128 characters")
+		return "", false
+	}
+	return hashToken(token), true
+}
+
+// Get handles GET /api/me/draft
+func (d *Drafts) Get(w http.ResponseWriter, r *http.Request) {
+	tokenHash, ok := deviceToken(w, r)
+	if !ok {
+		return
+	}
+
+	draft, err := d.db.GetDraft(r.Context(), tokenHash)
+	if err != nil {
+		log.Printf("Error getting draft: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve draft")
+		return
+	}
+	if draft == nil {
+		respondWithError(w, http.StatusNotFound, "No draft saved")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, draft)
+}
+
+// Put handles PUT /api/me/draft. The body is the draft, a JSON object of at
+// most maxBytes; saving again replaces it and restarts its expiry.
+func (d *Drafts) Put(w http.ResponseWriter, r *http.Request) {
+	tokenHash, ok := deviceToken(w, r)
+	if !ok {
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, int64(d.maxBytes)+1))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Failed to read draft")
+		return
+	}
+	if len(body) > d.maxBytes {
+		respondWithError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Draft must be %d bytes or less", d.maxBytes))
+		return
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil || fields == nil {
+		respondWithError(w, http.StatusBadRequest, "Draft must be a JSON object")
+		return
+	}
+
+	draft, err := d.db.SaveDraft(r.Context(), tokenHash, body, d.ttl)
+	if err != nil {
+		log.Printf("Error saving draft: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to save draft")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, draft)
+}
+
+// Delete handles DELETE /api/me/draft, for when the post is published.
+func (d *Drafts) Delete(w http.ResponseWriter, r *http.Request) {
+	tokenHash, ok := deviceToken(w, r)
+	if !ok {
+		return
+	}
+
+	if err := d.db.DeleteDraft(r.Context(), tokenHash); err != nil {
+		log.Printf("Error deleting draft: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to delete draft")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Run deletes expired drafts every draftJanitorInterval until ctx is
+// cancelled.
+func (d *Drafts) Run(ctx context.Context) {
+	ticker := time.NewTicker(draftJanitorInterval)
+	defer ticker.Stop()
+
+	for {
+		deleted, err := d.db.DeleteExpiredDrafts(ctx)
+		if err != nil {
+			log.Printf("Error deleting expired drafts: %v", err)
+		} else if deleted > 0 {
+			log.Printf("Drafts: deleted %d expired drafts", deleted)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+const draftColumns = `content, updated_at, expires_at`
+
+func scanDraft(row rowScanner) (*Draft, error) {
+	var draft Draft
+	var content []byte
+	if err := row.Scan(&content, &draft.UpdatedAt, &draft.ExpiresAt); err != nil {
+		return nil, err
+	}
+	draft.Content = content
+	return &draft, nil
+}
+
+// GetDraft returns the device's draft, or nil if it has none or it expired
+func (db *DB) GetDraft(ctx context.Context, tokenHash string) (*Draft, error) {
+	query := `SELECT ` + draftColumns + ` FROM drafts WHERE device_token_hash = $1 AND expires_at > NOW()`
+
+	draft, err := scanDraft(db.conn.QueryRowContext(ctx, query, tokenHash))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get draft: %w", err)
+	}
+	return draft, nil
+}
+
+func (db *DB) SaveDraft(ctx context.Context, tokenHash string, content []byte, ttl time.Duration) (*Draft, error) {
+	query := `
+		INSERT INTO drafts (device_token_hash, content, updated_at, expires_at)
+		VALUES ($1, $2::jsonb, NOW(), NOW() + make_interval(secs => $3))
+		ON CONFLICT (device_token_hash) DO UPDATE
+		SET content = EXCLUDED.content, updated_at = EXCLUDED.updated_at, expires_at = EXCLUDED.expires_at
+		RETURNING ` + draftColumns
+
+	draft, err := scanDraft(db.conn.QueryRowContext(ctx, query, tokenHash, string(content), ttl.Seconds()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to save draft: %w", err)
+	}
+	return draft, nil
+}
+
+func (db *DB) DeleteDraft(ctx context.Context, tokenHash string) error {
+	_, err := db.conn.ExecContext(ctx, "DELETE FROM drafts WHERE device_token_hash = $1", tokenHash)
+	if err != nil {
+		return fmt.Errorf("failed to delete draft: %w", err)
+	}
+	return nil
+}
+
+func (db *DB) DeleteExpiredDrafts(ctx context.Context) (int64, error) {
+	result, err := db.conn.ExecContext(ctx, "DELETE FROM drafts WHERE expires_at <= NOW()")
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired drafts: %w", err)
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+const (
+	embedPostCount      = 10
+	embedRefreshSeconds = 60
+	maxEmbedDomains     = 20
+)
+
+// embedDomainPattern matches a host name, optionally with a leading "*."
+// to allow its subdomains.
+var embedDomainPattern = regexp.MustCompile(`^(\*\.)?([a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?\.)+[a-z]{2,63}$`)
+
+var embedPage = template.Must(template.New("embed").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta http-equiv="refresh" content="{{.Refresh}}">
+<title>{{.Event.Name}}</title>
+<style>
+body { font-family: system-ui, sans-serif; margin: 0; padding: 0.75rem; color: #1f2937; font-size: 0.9375rem; }
+header { border-bottom: 3px solid {{.Accent}}; padding-bottom: 0.5rem; }
+header img { display: block; width: 100%; max-height: 6rem; object-fit: cover; }
+h1 { font-size: 1.125rem; margin: 0.5rem 0 0; }
+article { border-bottom: 1px solid #e5e7eb; padding: 0.5rem 0; }
+.meta { color: #4b5563; font-size: 0.8125rem; margin: 0; }
+p { white-space: pre-wrap; margin: 0.25rem 0; }
+</style>
+</head>
+<body>
+<header>
+{{if .Event.Theme.BannerURL}}<img src="{{.Event.Theme.BannerURL}}" alt="">{{end}}
+<h1>{{.Event.Name}}</h1>
+{{if .Event.Theme.WelcomeMessage}}<p>{{.Event.Theme.WelcomeMessage}}</p>{{end}}
+</header>
+{{range .Posts}}<article>
+<p class="meta"><time datetime="{{.CreatedAt.Format "2006-01-02T15:04:05Z07:00"}}">{{with .CreatedAtLocal}}{{.Format "2 Jan 15:04"}}{{else}}{{.CreatedAt.Format "2 Jan 15:04"}}{{end}}</time>{{if .Location}} · {{.Location}}{{end}}</p>
+<p>{{.Content}}</p>
+</article>
+{{else}}<p class="meta">No posts yet.</p>
+{{end}}</body>
+</html>
+`))
+
+type embedData struct {
+	Event   *Event
+	Posts   []Post
+	Refresh int
+	// Accent is the theme's accent color, or a neutral default
+	Accent template.CSS
+}
+
+type SetEmbedDomainsRequest struct {
+	Domains []string `json:"domains"`
+}
+
+// frameAncestors is the Content-Security-Policy frame-ancestors source list
+// allowing a page to be framed by the given domains over https, or by no
+// one without any.
+func frameAncestors(domains []string) string {
+	if len(domains) == 0 {
+		return "'none'"
+	}
+	sources := make([]string, len(domains))
+	for i, domain := range domains {
+		sources[i] = "https://" + domain
+	}
+	return strings.Join(sources, " ")
+}
+
+// GetEventEmbed handles GET /embed/events/{event}, a small HTML widget of the
+// latest posts for a venue to put on its own site in an iframe. It has no
+// scripts, refreshes itself, leaves out posts with a content warning, and
+// can only be framed by the domains the organizer registered.
+func (h *Handler) GetEventEmbed(w http.ResponseWriter, r *http.Request) {
+	event, err := h.db.GetEvent(r.Context(), r.PathValue("event"))
+	if err != nil {
+		log.Printf("Error getting event: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve event")
+		return
+	}
+	if event == nil {
+		respondWithError(w, http.StatusNotFound, "Event not found")
+		return
+	}
+
+	posts, err := h.embedPosts(r.Context(), event)
+	if err != nil {
+		log.Printf("Error getting posts for embed of %s: %v", event.Name, err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve posts")
+		return
+	}
+	loc := event.location()
+	for i := range posts {
+		event.EventSettings.redactPost(&posts[i])
+		localizePost(&posts[i], loc)
+	}
+
+	accent := event.Theme.AccentColor
+	if accent == "" {
+		accent = "#e5e7eb"
+	}
+	var page bytes.Buffer
+	data := embedData{Event: event, Posts: posts, Refresh: embedRefreshSeconds, Accent: template.CSS(accent)}
+	if err := embedPage.Execute(&page, data); err != nil {
+		log.Printf("Error rendering embed of %s: %v", event.Name, err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to render widget")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", fmt.Sprintf(
+		"default-src 'none'; style-src 'unsafe-inline'; img-src https:; frame-ancestors %s", frameAncestors(event.EmbedDomains)))
+	if len(event.EmbedDomains) == 0 {
+		// For browsers that predate frame-ancestors
+		w.Header().Set("X-Frame-Options", "DENY")
+	}
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", embedRefreshSeconds))
+	w.Write(page.Bytes())
+}
+
+// embedPosts returns the latest posts on the event, from the archive once
+// the board has been archived.
+func (h *Handler) embedPosts(ctx context.Context, event *Event) ([]Post, error) {
+	filter := PostFilter{Event: event.Name}
+	if event.ArchivedAt != nil && h.cfg.Archive != nil {
+		return h.cfg.Archive.archivedPosts(ctx, event.ID, filter, embedPostCount, 0)
+	}
+	return h.db.GetPosts(ctx, filter, embedPostCount, 0)
+}
+
+// SetEmbedDomains handles PUT /admin/events/{event}/embed-domains, replacing
+// the sites allowed to embed the event's widget. "*.example.com" allows
+// example.com's subdomains but not example.com itself.
+func (h *Handler) SetEmbedDomains(w http.ResponseWriter, r *http.Request) {
+	var req SetEmbedDomainsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	domains := []string{}
+	for _, domain := range req.Domains {
+		domain = strings.ToLower(strings.TrimSpace(domain))
+		if !embedDomainPattern.MatchString(domain) {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Invalid domain %q", domain))
+			return
+		}
+		if !containsString(domains, domain) {
+			domains = append(domains, domain)
+		}
+	}
+	if len(domains) > maxEmbedDomains {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("at most %d domains are allowed", maxEmbedDomains))
+		return
+	}
+
+	found, err := h.db.SetEmbedDomains(r.Context(), r.PathValue("event"), domains)
+	if err != nil {
+		log.Printf("Error setting embed domains: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to update event")
+		return
+	}
+	if !found {
+		respondWithError(w, http.StatusNotFound, "Event not found")
+		return
+	}
+
+	h.audit(r, "event.set_embed_domains", "event", r.PathValue("event"), SetEmbedDomainsRequest{Domains: domains})
+
+	h.GetEvent(w, r)
+}
+
+// SetEmbedDomains replaces the domains allowed to embed an event's widget,
+// reporting whether the event exists.
+func (db *DB) SetEmbedDomains(ctx context.Context, name string, domains []string) (bool, error) {
+	encoded, err := json.Marshal(domains)
+	if err != nil {
+		return false, fmt.Errorf("failed to encode embed domains: %w", err)
+	}
+
+	result, err := db.conn.ExecContext(ctx, "UPDATE events SET embed_domains = $2::jsonb WHERE name = $1", name, string(encoded))
+	if err != nil {
+		return false, fmt.Errorf("failed to set embed domains: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to set embed domains: %w", err)
+	}
+
+	return affected > 0, nil
+}
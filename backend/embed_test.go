@@ -0,0 +1,131 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGetEventEmbed(t *testing.T) {
+	tests := []struct {
+		name        string
+		event       *Event
+		status      int
+		errorMsg    string
+		wantCSP     string
+		wantXFO     string
+		wantContent []string
+	}{
+		{name: "unknown event", status: 404, errorMsg: "Event not found"},
+		{
+			name:        "no domains",
+			event:       &Event{ID: 1, Name: "Glastonbury"},
+			status:      200,
+			wantCSP:     "default-src 'none'; style-src 'unsafe-inline'; img-src https:; frame-ancestors 'none'",
+			wantXFO:     "DENY",
+			wantContent: []string{"<h1>Glastonbury</h1>", "border-bottom: 3px solid #e5e7eb", "Meet at the &lt;b&gt;flag&lt;/b&gt;"},
+		},
+		{
+			name: "domains and theme",
+			event: &Event{
+				ID:           1,
+				Name:         "Glastonbury",
+				EmbedDomains: []string{"venue.example.com", "*.venue.example.com"},
+				Theme:        EventTheme{BannerURL: "https://cdn.example.com/b.png", AccentColor: "#ff6600", WelcomeMessage: "Hello from the Pyramid"},
+			},
+			status:  200,
+			wantCSP: "default-src 'none'; style-src 'unsafe-inline'; img-src https:; frame-ancestors https://venue.example.com https://*.venue.example.com",
+			wantContent: []string{
+				`<img src="https://cdn.example.com/b.png" alt="">`,
+				"border-bottom: 3px solid #ff6600",
+				"<p>Hello from the Pyramid</p>",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newFakeStore()
+			store.event = tt.event
+			store.posts = []Post{{ID: 1, EventName: "Glastonbury", Content: "Meet at the <b>flag</b>", CreatedAt: time.Now()}}
+			h := newTestHandler(store, HandlerConfig{})
+
+			req := httptest.NewRequest(http.MethodGet, "/embed/events/Glastonbury", nil)
+			req.SetPathValue("event", "Glastonbury")
+			rec := httptest.NewRecorder()
+			h.GetEventEmbed(rec, req)
+
+			if tt.errorMsg != "" {
+				assertError(t, rec, tt.status, tt.errorMsg)
+				return
+			}
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			if got := rec.Header().Get("Content-Security-Policy"); got != tt.wantCSP {
+				t.Errorf("Content-Security-Policy = %q, want %q", got, tt.wantCSP)
+			}
+			if got := rec.Header().Get("X-Frame-Options"); got != tt.wantXFO {
+				t.Errorf("X-Frame-Options = %q, want %q", got, tt.wantXFO)
+			}
+			if store.lastFilter.Event != "Glastonbury" || store.lastFilter.IncludeSensitive || store.lastLimit != embedPostCount {
+				t.Errorf("listed %+v, limit %d", store.lastFilter, store.lastLimit)
+			}
+			for _, want := range tt.wantContent {
+				if !strings.Contains(rec.Body.String(), want) {
+					t.Errorf("page is missing %q:\n%s", want, rec.Body.String())
+				}
+			}
+		})
+	}
+}
+
+func TestSetEmbedDomains(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		status   int
+		errorMsg string
+		want     []string
+	}{
+		{name: "invalid body", body: `{"domains":"venue.example.com"}`, status: 400, errorMsg: "Invalid request body"},
+		{name: "url", body: `{"domains":["https://venue.example.com"]}`, status: 400, errorMsg: `Invalid domain "https://venue.example.com"`},
+		{name: "bare wildcard", body: `{"domains":["*"]}`, status: 400, errorMsg: `Invalid domain "*"`},
+		{name: "inner wildcard", body: `{"domains":["venue.*.com"]}`, status: 400, errorMsg: `Invalid domain "venue.*.com"`},
+		{name: "csp injection", body: `{"domains":["venue.example.com; script-src *"]}`, status: 400, errorMsg: `Invalid domain "venue.example.com; script-src *"`},
+		{
+			name:   "normalized",
+			body:   `{"domains":[" Venue.Example.com ","*.venue.example.com","venue.example.com"]}`,
+			status: 200,
+			want:   []string{"venue.example.com", "*.venue.example.com"},
+		},
+		{name: "cleared", body: `{"domains":[]}`, status: 200, want: []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newFakeStore()
+			store.event = &Event{ID: 1, Name: "Glastonbury"}
+			h := newTestHandler(store, HandlerConfig{})
+
+			req := httptest.NewRequest(http.MethodPut, "/admin/events/Glastonbury/embed-domains", strings.NewReader(tt.body))
+			req.SetPathValue("event", "Glastonbury")
+			rec := httptest.NewRecorder()
+			h.SetEmbedDomains(rec, req)
+
+			if tt.errorMsg != "" {
+				assertError(t, rec, tt.status, tt.errorMsg)
+				return
+			}
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			if !reflect.DeepEqual(store.event.EmbedDomains, tt.want) {
+				t.Errorf("domains = %q, want %q", store.event.EmbedDomains, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	errEventNotFound = errors.New("event not found")
+	errEventExists   = errors.New("event already exists")
+)
+
+// EventAlias is a former name of an event, kept after a rename or merge so
+// old links keep working.
+type EventAlias struct {
+	Alias     string    `json:"alias"`
+	EventName string    `json:"event_name"`
+	Reason    string    `json:"reason"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type RenameEventRequest struct {
+	Name string `json:"name"`
+}
+
+type MergeEventRequest struct {
+	Into string `json:"into"`
+}
+
+// RenameEvent handles POST /admin/events/{event}/rename
+func (h *Handler) RenameEvent(w http.ResponseWriter, r *http.Request) {
+	var req RenameEventRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		respondWithError(w, http.StatusBadRequest, "name is required")
+		return
+	}
+	if len(req.Name) > 200 {
+		respondWithError(w, http.StatusBadRequest, "name must be 200 characters or less")
+		return
+	}
+
+	oldName := r.PathValue("event")
+	err := h.db.WithTx(r.Context(), func(tx Store) error {
+		if err := tx.RenameEvent(r.Context(), oldName, req.Name); err != nil {
+			return err
+		}
+		return auditTx(tx, r, "event.rename", "event", oldName, req)
+	})
+	if !h.respondEventAdminError(w, err, "rename") {
+		return
+	}
+
+	h.respondWithEvent(w, r, req.Name)
+}
+
+// MergeEvent handles POST /admin/events/{event}/merge, folding the event
+// into another (by name or slug). Posts, sessions, templates and followers move to the target,
+// whose settings win; the merged event's name becomes an alias.
+func (h *Handler) MergeEvent(w http.ResponseWriter, r *http.Request) {
+	var req MergeEventRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	source := r.PathValue("event")
+	into, err := h.canonicalEventName(r.Context(), strings.TrimSpace(req.Into))
+	if err != nil {
+		log.Printf("Error resolving event name: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to merge event")
+		return
+	}
+	req.Into = into
+	if req.Into == "" || req.Into == source {
+		respondWithError(w, http.StatusBadRequest, "into must name a different event")
+		return
+	}
+
+	err = h.db.WithTx(r.Context(), func(tx Store) error {
+		if err := tx.MergeEvent(r.Context(), source, req.Into); err != nil {
+			return err
+		}
+		return auditTx(tx, r, "event.merge", "event", source, req)
+	})
+	if !h.respondEventAdminError(w, err, "merge") {
+		return
+	}
+
+	h.respondWithEvent(w, r, req.Into)
+}
+
+// GetEventAliases handles GET /admin/events/{event}/aliases
+func (h *Handler) GetEventAliases(w http.ResponseWriter, r *http.Request) {
+	aliases, err := h.db.GetEventAliases(r.Context(), r.PathValue("event"))
+	if err != nil {
+		log.Printf("Error getting event aliases: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve aliases")
+		return
+	}
+
+	if aliases == nil {
+		aliases = []EventAlias{}
+	}
+
+	respondWithJSON(w, http.StatusOK, aliases)
+}
+
+// respondEventAdminError writes the response for a failed rename or merge,
+// reporting whether the operation succeeded.
+func (h *Handler) respondEventAdminError(w http.ResponseWriter, err error, op string) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, errEventNotFound):
+		respondWithError(w, http.StatusNotFound, "Event not found")
+	case errors.Is(err, errEventExists):
+		respondWithError(w, http.StatusConflict, "An event with that name already exists")
+	default:
+		log.Printf("Error during event %s: %v", op, err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to "+op+" event")
+	}
+	return false
+}
+
+func (h *Handler) respondWithEvent(w http.ResponseWriter, r *http.Request, name string) {
+	event, err := h.db.GetEvent(r.Context(), name)
+	if err != nil || event == nil {
+		log.Printf("Error getting event after update: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve event")
+		return
+	}
+
+	h.cfg.Retention.Apply(event)
+
+	respondWithJSON(w, http.StatusOK, event)
+}
+
+// canonicalEventName maps an event's slug or former name to its current
+// name, returning other names unchanged.
+func (h *Handler) canonicalEventName(ctx context.Context, ref string) (string, error) {
+	if ref == "" {
+		return ref, nil
+	}
+	lookup, err := h.db.LookupEvent(ctx, ref)
+	if err != nil || lookup == nil {
+		return ref, err
+	}
+	return lookup.Name, nil
+}
+
+func (db *DB) GetEventAliases(ctx context.Context, eventName string) ([]EventAlias, error) {
+	rows, err := db.conn.QueryContext(ctx, `
+		SELECT alias, event_name, reason, created_at
+		FROM event_aliases
+		WHERE event_name = $1
+		ORDER BY created_at DESC
+	`, eventName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query event aliases: %w", err)
+	}
+	defer rows.Close()
+
+	var aliases []EventAlias
+	for rows.Next() {
+		var a EventAlias
+		if err := rows.Scan(&a.Alias, &a.EventName, &a.Reason, &a.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan event alias: %w", err)
+		}
+		aliases = append(aliases, a)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating event aliases: %w", err)
+	}
+
+	return aliases, nil
+}
+
+// RenameEvent renames an event and everything that refers to it by name,
+// keeping the old name as an alias.
+func (db *DB) RenameEvent(ctx context.Context, oldName, newName string) error {
+	if oldName == newName {
+		return nil
+	}
+
+	tx, err := db.begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin rename: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := lockEvent(ctx, tx, oldName, newName); err != nil {
+		return err
+	}
+
+	// Sessions, templates and aliases follow via ON UPDATE CASCADE
+	statements := []string{
+		"UPDATE events SET name = $2 WHERE name = $1",
+		"UPDATE posts SET event_name = $2 WHERE event_name = $1",
+		"UPDATE federation_followers SET event_name = $2 WHERE event_name = $1",
+		"UPDATE legal_holds SET target_value = $2 WHERE target_type = 'event' AND target_value = $1 AND released_at IS NULL",
+		// Renaming back to a former name reclaims it
+		"DELETE FROM event_aliases WHERE alias = $2",
+		"INSERT INTO event_aliases (alias, event_name, reason) VALUES ($1, $2, 'rename')",
+	}
+	for _, stmt := range statements {
+		if _, err := tx.ExecContext(ctx, stmt, oldName, newName); err != nil {
+			return fmt.Errorf("failed to rename event: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit rename: %w", err)
+	}
+	return nil
+}
+
+// MergeEvent moves everything belonging to source into target, deletes
+// source and keeps its name as an alias of target.
+func (db *DB) MergeEvent(ctx context.Context, source, target string) error {
+	tx, err := db.begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin merge: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := lockEvent(ctx, tx, source, ""); err != nil {
+		return err
+	}
+	if err := lockEvent(ctx, tx, target, ""); err != nil {
+		return err
+	}
+
+	statements := []string{
+		"UPDATE posts SET event_name = $2 WHERE event_name = $1",
+		"UPDATE event_sessions SET event_name = $2 WHERE event_name = $1",
+		"UPDATE post_templates SET event_name = $2 WHERE event_name = $1",
+		"UPDATE invite_codes SET event_name = $2 WHERE event_name = $1",
+		"UPDATE stories SET event_name = $2 WHERE event_name = $1",
+		"UPDATE promoted_posts SET event_name = $2 WHERE event_name = $1",
+		"UPDATE donations SET event_name = $2 WHERE event_name = $1",
+		// A check-in code the target already has is dropped with the source
+		"UPDATE checkin_codes SET event_name = $2 WHERE event_name = $1 AND code NOT IN (SELECT code FROM checkin_codes WHERE event_name = $2)",
+		`INSERT INTO federation_followers (event_name, actor_uri, inbox_uri, shared_inbox_uri, created_at)
+		 SELECT $2, actor_uri, inbox_uri, shared_inbox_uri, created_at FROM federation_followers WHERE event_name = $1
+		 ON CONFLICT (event_name, actor_uri) DO NOTHING`,
+		"DELETE FROM federation_followers WHERE event_name = $1",
+		// Moving holds to the target can only retain more, never less
+		"UPDATE legal_holds SET target_value = $2 WHERE target_type = 'event' AND target_value = $1 AND released_at IS NULL",
+		"UPDATE event_aliases SET event_name = $2 WHERE event_name = $1",
+		// The merged event's slugs redirect to the target
+		`UPDATE event_slug_history SET event_id = (SELECT id FROM events WHERE name = $2)
+		 WHERE event_id = (SELECT id FROM events WHERE name = $1)`,
+		`INSERT INTO event_slug_history (slug, event_id)
+		 SELECT s.slug, t.id FROM events s, events t WHERE s.name = $1 AND t.name = $2
+		 ON CONFLICT (slug) DO NOTHING`,
+		"DELETE FROM events WHERE name = $1",
+		"INSERT INTO event_aliases (alias, event_name, reason) VALUES ($1, $2, 'merge')",
+	}
+	for _, stmt := range statements {
+		if _, err := tx.ExecContext(ctx, stmt, source, target); err != nil {
+			return fmt.Errorf("failed to merge event: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit merge: %w", err)
+	}
+	return nil
+}
+
+// lockEvent locks the named event for the rest of the transaction,
+// returning errEventNotFound if it doesn't exist. If free is set, it must
+// not name an existing event.
+func lockEvent(ctx context.Context, tx querier, name, free string) error {
+	var id int
+	err := tx.QueryRowContext(ctx, "SELECT id FROM events WHERE name = $1 FOR UPDATE", name).Scan(&id)
+	if err == sql.ErrNoRows {
+		return errEventNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to lock event: %w", err)
+	}
+
+	if free == "" {
+		return nil
+	}
+
+	var exists bool
+	err = tx.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM events WHERE name = $1)", free).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("failed to check event name: %w", err)
+	}
+	if exists {
+		return errEventExists
+	}
+	return nil
+}
@@ -0,0 +1,396 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Event is the metadata stored for an event board.
+type Event struct {
+	ID             int       `json:"id"`
+	Name           string    `json:"name"`
+	Slug           string    `json:"slug"`
+	RetentionClass string    `json:"retention_class,omitempty"`
+	RetentionDays  int       `json:"retention_days,omitempty"`
+	PostCount      int       `json:"post_count"`
+	CreatedAt      time.Time `json:"created_at"`
+	// ArchivedAt is set once the board has moved to the archive, and is
+	// read-only
+	ArchivedAt *time.Time `json:"archived_at,omitempty"`
+	Theme      EventTheme `json:"theme"`
+	// EmbedDomains are the sites allowed to frame the event's widget
+	EmbedDomains []string `json:"embed_domains"`
+	// Version counts changes to the row; it is sent as the event's ETag
+	Version int `json:"-"`
+	EventDetails
+	EventSettings
+}
+
+// EventSettings are the per-event options that change how posts are
+// collected and displayed.
+type EventSettings struct {
+	// AllAges boards don't collect or display any demographic fields.
+	AllAges bool `json:"all_ages"`
+
+	// The Collect fields choose which demographic fields the board asks for.
+	// A field that isn't collected is neither required nor stored, and is
+	// stripped from posts made before it was turned off.
+	CollectAge      bool `json:"collect_age"`
+	CollectGender   bool `json:"collect_gender"`
+	CollectLocation bool `json:"collect_location"`
+
+	// InviteOnly boards only take posts with an invite code, while they
+	// are soft-launched
+	InviteOnly bool `json:"invite_only"`
+	// DevicePostLimit caps the posts one device can make to the board, so
+	// no one poster dominates it; 0 is no cap
+	DevicePostLimit int `json:"device_post_limit"`
+	// PostingWindow limits when the board takes posts; nil is always
+	PostingWindow *PostingWindow `json:"posting_window,omitempty"`
+	// eventEndsAt is the event's ends_at, which the posting window can
+	// close relative to
+	eventEndsAt *time.Time
+	// eventTimezone is the event's timezone, which post times are given in
+	eventTimezone string
+
+	// CustomFields are extra organizer-defined fields on the event's posts.
+	CustomFields CustomFieldSchema `json:"custom_fields"`
+}
+
+// defaultEventSettings apply to events that have no row yet.
+var defaultEventSettings = EventSettings{
+	CollectAge:      true,
+	CollectGender:   true,
+	CollectLocation: true,
+}
+
+func (s EventSettings) collectsAge() bool      { return s.CollectAge && !s.AllAges }
+func (s EventSettings) collectsGender() bool   { return s.CollectGender && !s.AllAges }
+func (s EventSettings) collectsLocation() bool { return s.CollectLocation && !s.AllAges }
+
+// redactRequest clears the fields of a new post that the event doesn't collect.
+func (s EventSettings) redactRequest(req *CreatePostRequest) {
+	if !s.collectsAge() {
+		req.Age = 0
+	}
+	if !s.collectsGender() {
+		req.Gender = ""
+	}
+	if !s.collectsLocation() {
+		req.Location = ""
+	}
+}
+
+// redactPost clears the fields of a stored post that the event doesn't display.
+func (s EventSettings) redactPost(post *Post) {
+	if !s.collectsAge() {
+		post.Age = nil
+	}
+	if !s.collectsGender() {
+		post.Gender = ""
+	}
+	if !s.collectsLocation() {
+		post.Location = ""
+	}
+	post.CustomFields = s.CustomFields.redact(post.CustomFields)
+}
+
+// UpdateEventSettingsRequest is a partial update; omitted fields are left
+// unchanged.
+type UpdateEventSettingsRequest struct {
+	AllAges         *bool `json:"all_ages"`
+	CollectAge      *bool `json:"collect_age"`
+	CollectGender   *bool `json:"collect_gender"`
+	CollectLocation *bool `json:"collect_location"`
+	InviteOnly      *bool `json:"invite_only"`
+	DevicePostLimit *int  `json:"device_post_limit"`
+}
+
+type SetRetentionClassRequest struct {
+	RetentionClass string `json:"retention_class"`
+}
+
+// GetEvent handles GET /api/events/{event}
+func (h *Handler) GetEvent(w http.ResponseWriter, r *http.Request) {
+	event, err := h.db.GetEvent(r.Context(), r.PathValue("event"))
+	if err != nil {
+		log.Printf("Error getting event: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve event")
+		return
+	}
+	if event == nil {
+		respondWithError(w, http.StatusNotFound, "Event not found")
+		return
+	}
+
+	h.cfg.Retention.Apply(event)
+
+	w.Header().Set("ETag", versionETag(event.Version))
+	respondWithJSON(w, http.StatusOK, event)
+}
+
+// UpdateEventSettings handles PATCH /admin/events/{event}. With If-Match,
+// the update only applies if the event is still at the ETag's version, so
+// organizers editing at once can't undo each other's changes unseen.
+func (h *Handler) UpdateEventSettings(w http.ResponseWriter, r *http.Request) {
+	var req UpdateEventSettingsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if req.DevicePostLimit != nil && (*req.DevicePostLimit < 0 || *req.DevicePostLimit > maxDevicePostLimit) {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("device_post_limit must be between 0 and %d", maxDevicePostLimit))
+		return
+	}
+
+	found, err := h.db.UpdateEventSettings(r.Context(), r.PathValue("event"), req, ifMatchVersions(r))
+	if errors.Is(err, errVersionMismatch) {
+		respondVersionMismatch(w, "Event")
+		return
+	}
+	if err != nil {
+		log.Printf("Error updating event settings: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to update event")
+		return
+	}
+	if !found {
+		respondWithError(w, http.StatusNotFound, "Event not found")
+		return
+	}
+
+	h.audit(r, "event.update_settings", "event", r.PathValue("event"), req)
+
+	h.GetEvent(w, r)
+}
+
+// applyEventSettings strips the fields each post's event doesn't display,
+// and gives its time in the event's timezone.
+func (h *Handler) applyEventSettings(ctx context.Context, posts []Post) error {
+	names := make([]string, 0, len(posts))
+	for _, post := range posts {
+		names = append(names, post.EventName)
+	}
+
+	settings, err := h.db.GetEventSettingsByName(ctx, names)
+	if err != nil {
+		return err
+	}
+
+	locations := make(map[string]*time.Location)
+	for i := range posts {
+		s, ok := settings[posts[i].EventName]
+		if !ok {
+			s = defaultEventSettings
+		}
+		s.redactPost(&posts[i])
+
+		loc, ok := locations[posts[i].EventName]
+		if !ok {
+			loc = s.location()
+			locations[posts[i].EventName] = loc
+		}
+		localizePost(&posts[i], loc)
+	}
+	return nil
+}
+
+// SetEventRetentionClass handles PUT /admin/events/{event}/retention. An
+// empty class returns the event to the default.
+func (h *Handler) SetEventRetentionClass(w http.ResponseWriter, r *http.Request) {
+	var req SetRetentionClassRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	req.RetentionClass = strings.TrimSpace(req.RetentionClass)
+	if req.RetentionClass != "" {
+		if _, ok := h.cfg.Retention.Classes[req.RetentionClass]; !ok {
+			respondWithError(w, http.StatusBadRequest, "unknown retention_class")
+			return
+		}
+	}
+
+	found, err := h.db.SetEventRetentionClass(r.Context(), r.PathValue("event"), req.RetentionClass)
+	if err != nil {
+		log.Printf("Error setting retention class: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to update event")
+		return
+	}
+	if !found {
+		respondWithError(w, http.StatusNotFound, "Event not found")
+		return
+	}
+
+	h.audit(r, "event.set_retention_class", "event", r.PathValue("event"), req)
+
+	h.GetEvent(w, r)
+}
+
+// GetEvent retrieves an event by name, returning nil if it does not exist
+func (db *DB) GetEvent(ctx context.Context, name string) (*Event, error) {
+	query := `
+		SELECT e.id, e.name, e.slug, COALESCE(e.retention_class, ''), e.created_at,
+			(SELECT COUNT(*) FROM posts p WHERE p.event_name = e.name),
+			COALESCE(e.category, ''), e.latitude, e.longitude, e.starts_at, e.ends_at, COALESCE(e.timezone, ''), e.archived_at,
+			COALESCE(e.banner_url, ''), COALESCE(e.accent_color, ''), COALESCE(e.welcome_message, ''), e.embed_domains, e.version,
+			` + eventSettingsColumns + `
+		FROM events e
+		WHERE e.name = $1
+	`
+
+	var event Event
+	var embedDomains []byte
+	dest := []interface{}{
+		&event.ID,
+		&event.Name,
+		&event.Slug,
+		&event.RetentionClass,
+		&event.CreatedAt,
+		&event.PostCount,
+		&event.Category,
+		&event.Latitude,
+		&event.Longitude,
+		&event.StartsAt,
+		&event.EndsAt,
+		&event.Timezone,
+		&event.ArchivedAt,
+		&event.Theme.BannerURL,
+		&event.Theme.AccentColor,
+		&event.Theme.WelcomeMessage,
+		&embedDomains,
+		&event.Version,
+	}
+	err := db.reader(ctx).QueryRowContext(ctx, query, name).Scan(append(dest, scanEventSettings(&event.EventSettings)...)...)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get event: %w", err)
+	}
+	if err := scanJSON(embedDomains, &event.EmbedDomains); err != nil {
+		return nil, fmt.Errorf("failed to decode embed domains: %w", err)
+	}
+
+	return &event, nil
+}
+
+// SetEventRetentionClass assigns a retention class, reporting whether the
+// event exists.
+func (db *DB) SetEventRetentionClass(ctx context.Context, name, class string) (bool, error) {
+	result, err := db.conn.ExecContext(ctx,
+		"UPDATE events SET retention_class = NULLIF($2, '') WHERE name = $1",
+		name, class,
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to set retention class: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to set retention class: %w", err)
+	}
+
+	return affected > 0, nil
+}
+
+// eventSettingsColumns is the column list scanned by scanEventSettings.
+const eventSettingsColumns = `all_ages, collect_age, collect_gender, collect_location, invite_only, device_post_limit, posting_window, ends_at, COALESCE(timezone, ''), custom_fields`
+
+func scanEventSettings(s *EventSettings) []interface{} {
+	return []interface{}{&s.AllAges, &s.CollectAge, &s.CollectGender, &s.CollectLocation, &s.InviteOnly, &s.DevicePostLimit, &s.PostingWindow, &s.eventEndsAt, &s.eventTimezone, &s.CustomFields}
+}
+
+// GetEventSettings returns an event's settings, or the defaults for an event
+// that has no posts yet.
+func (db *DB) GetEventSettings(ctx context.Context, name string) (EventSettings, error) {
+	settings := defaultEventSettings
+	err := db.conn.QueryRowContext(ctx,
+		"SELECT "+eventSettingsColumns+" FROM events WHERE name = $1",
+		name,
+	).Scan(scanEventSettings(&settings)...)
+	if err == sql.ErrNoRows {
+		return defaultEventSettings, nil
+	}
+	if err != nil {
+		return settings, fmt.Errorf("failed to get event settings: %w", err)
+	}
+	return settings, nil
+}
+
+// GetEventSettingsByName returns the settings of every listed event that
+// exists; missing events are absent from the map and get defaultEventSettings.
+func (db *DB) GetEventSettingsByName(ctx context.Context, names []string) (map[string]EventSettings, error) {
+	settings := make(map[string]EventSettings)
+	if len(names) == 0 {
+		return settings, nil
+	}
+
+	rows, err := db.conn.QueryContext(ctx,
+		"SELECT name, "+eventSettingsColumns+" FROM events WHERE name = ANY($1)",
+		names,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query event settings: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		var s EventSettings
+		if err := rows.Scan(append([]interface{}{&name}, scanEventSettings(&s)...)...); err != nil {
+			return nil, fmt.Errorf("failed to scan event settings: %w", err)
+		}
+		settings[name] = s
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating event settings: %w", err)
+	}
+
+	return settings, nil
+}
+
+// UpdateEventSettings applies a partial settings update, reporting whether
+// the event exists. If versions isn't nil, the event must be at one of them
+// or errVersionMismatch is returned.
+func (db *DB) UpdateEventSettings(ctx context.Context, name string, req UpdateEventSettingsRequest, versions []int) (bool, error) {
+	result, err := db.conn.ExecContext(ctx, `
+		UPDATE events
+		SET all_ages = COALESCE($2, all_ages),
+			collect_age = COALESCE($3, collect_age),
+			collect_gender = COALESCE($4, collect_gender),
+			collect_location = COALESCE($5, collect_location),
+			invite_only = COALESCE($6, invite_only),
+			device_post_limit = COALESCE($7, device_post_limit)
+		WHERE name = $1 AND ($8::int[] IS NULL OR version = ANY($8))
+	`, name, req.AllAges, req.CollectAge, req.CollectGender, req.CollectLocation, req.InviteOnly, req.DevicePostLimit, versions)
+	if err != nil {
+		return false, fmt.Errorf("failed to update event settings: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to update event settings: %w", err)
+	}
+	if affected > 0 || versions == nil {
+		return affected > 0, nil
+	}
+
+	// Nothing matched: either there's no such event or it has moved on
+	var exists bool
+	if err := db.conn.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM events WHERE name = $1)", name).Scan(&exists); err != nil {
+		return false, fmt.Errorf("failed to update event settings: %w", err)
+	}
+	if exists {
+		return true, errVersionMismatch
+	}
+	return false, nil
+}
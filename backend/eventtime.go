@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// localTimeFormat is how a post's time is shown as it was at the venue,
+// such as "Saturday 11:43 PM".
+const localTimeFormat = "Monday 3:04 PM"
+
+// location returns the event's timezone, or nil if it hasn't got one.
+func (s EventSettings) location() *time.Location {
+	if s.eventTimezone == "" {
+		return nil
+	}
+	loc, err := time.LoadLocation(s.eventTimezone)
+	if err != nil {
+		return nil
+	}
+	return loc
+}
+
+// localizePost sets a post's time in its event's timezone, loc, so clients
+// anywhere can show it as it was at the venue. A nil loc leaves it in UTC.
+func localizePost(post *Post, loc *time.Location) {
+	if loc == nil {
+		return
+	}
+	local := post.CreatedAt.In(loc)
+	post.CreatedAtLocal = &local
+	post.CreatedAtDisplay = local.Format(localTimeFormat)
+	post.Timezone = loc.String()
+}
+
+// relativeTime describes how long before now t was, such as "2h ago".
+func relativeTime(t, now time.Time) string {
+	d := now.Sub(t)
+	switch {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		return fmt.Sprintf("%dm ago", int(d.Minutes()))
+	case d < 24*time.Hour:
+		return fmt.Sprintf("%dh ago", int(d.Hours()))
+	default:
+		return fmt.Sprintf("%dd ago", int(d.Hours()/24))
+	}
+}
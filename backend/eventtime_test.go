@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLocalizePost(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/London")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 22:43 UTC is 23:43 in London during summer time
+	post := Post{CreatedAt: time.Date(2024, 6, 29, 22, 43, 0, 0, time.UTC)}
+	localizePost(&post, loc)
+
+	if post.CreatedAtDisplay != "Saturday 11:43 PM" {
+		t.Errorf("CreatedAtDisplay = %q, want Saturday 11:43 PM", post.CreatedAtDisplay)
+	}
+	if post.Timezone != "Europe/London" {
+		t.Errorf("Timezone = %q, want Europe/London", post.Timezone)
+	}
+	if post.CreatedAtLocal == nil || !post.CreatedAtLocal.Equal(post.CreatedAt) || post.CreatedAtLocal.Format("-07:00") != "+01:00" {
+		t.Errorf("CreatedAtLocal = %v, want the same instant at +01:00", post.CreatedAtLocal)
+	}
+
+	utc := Post{CreatedAt: post.CreatedAt}
+	localizePost(&utc, nil)
+	if utc.CreatedAtLocal != nil || utc.CreatedAtDisplay != "" || utc.Timezone != "" {
+		t.Errorf("post with no timezone = %+v, want no local time", utc)
+	}
+}
+
+func TestRelativeTime(t *testing.T) {
+	now := time.Date(2024, 6, 29, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		ago  time.Duration
+		want string
+	}{
+		{10 * time.Second, "just now"},
+		{5 * time.Minute, "5m ago"},
+		{2*time.Hour + 30*time.Minute, "2h ago"},
+		{50 * time.Hour, "2d ago"},
+	}
+	for _, tt := range tests {
+		if got := relativeTime(now.Add(-tt.ago), now); got != tt.want {
+			t.Errorf("relativeTime(%v ago) = %q, want %q", tt.ago, got, tt.want)
+		}
+	}
+}
+
+func TestApplyEventSettingsTimezone(t *testing.T) {
+	store := newFakeStore()
+	settings := defaultEventSettings
+	settings.eventTimezone = "America/New_York"
+	store.settings["Coachella"] = settings
+	h := newTestHandler(store, HandlerConfig{})
+
+	created := time.Date(2024, 1, 6, 4, 43, 0, 0, time.UTC)
+	posts := []Post{
+		{EventName: "Coachella", CreatedAt: created},
+		{EventName: "Glastonbury", CreatedAt: created},
+	}
+	if err := h.applyEventSettings(context.Background(), posts); err != nil {
+		t.Fatal(err)
+	}
+
+	if posts[0].CreatedAtDisplay != "Friday 11:43 PM" || posts[0].Timezone != "America/New_York" {
+		t.Errorf("post to an event with a timezone = %q %q, want Friday 11:43 PM America/New_York", posts[0].CreatedAtDisplay, posts[0].Timezone)
+	}
+	if posts[1].CreatedAtLocal != nil {
+		t.Errorf("post to an event with no timezone has CreatedAtLocal %v", posts[1].CreatedAtLocal)
+	}
+}
+
+// TestGetPostLocalTime checks a single post carries its time at the venue,
+// as the feed's posts do.
+func TestGetPostLocalTime(t *testing.T) {
+	store := newFakeStore()
+	store.posts = []Post{{ID: 1, EventName: "Glastonbury", Content: "hi", CreatedAt: time.Date(2026, 6, 27, 22, 43, 0, 0, time.UTC)}}
+	store.settings["Glastonbury"] = EventSettings{eventTimezone: "Europe/London"}
+	h := newTestHandler(store, HandlerConfig{})
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/api/posts/1", nil)
+	req.SetPathValue("id", "1")
+	h.GetPost(rec, req)
+
+	var post Post
+	if err := json.Unmarshal(rec.Body.Bytes(), &post); err != nil {
+		t.Fatal(err)
+	}
+	if post.CreatedAtDisplay != "Saturday 11:43 PM" || post.Timezone != "Europe/London" {
+		t.Errorf("created_at_display = %q, timezone = %q; want the time at the venue", post.CreatedAtDisplay, post.Timezone)
+	}
+}
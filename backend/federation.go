@@ -0,0 +1,932 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	activityContentType   = "application/activity+json"
+	activityStreamsNS     = "https://www.w3.org/ns/activitystreams"
+	securityNS            = "https://w3id.org/security/v1"
+	publicAddress         = "https://www.w3.org/ns/activitystreams#Public"
+	maxInboxBodyBytes     = 1 << 20
+	outboxPageSize        = 20
+	remoteKeyCacheTTL     = time.Hour
+	maxSignatureClockSkew = 12 * time.Hour
+)
+
+// Federation publishes each event board as an ActivityPub actor. Remote
+// users can follow an event, new posts are delivered to followers' inboxes,
+// and replies to posts are stored in the replies table.
+type Federation struct {
+	db      *DB
+	baseURL string
+	domain  string
+	key     *rsa.PrivateKey
+	keyPEM  string
+	client  *http.Client
+	// legacyPostIDs accepts integer IDs in note URIs, which notes federated
+	// before public IDs still have
+	legacyPostIDs bool
+	// deliveries sends activities to remote inboxes; see Run
+	deliveries *Dispatcher
+
+	mu   sync.Mutex
+	keys map[string]cachedRemoteKey
+}
+
+type cachedRemoteKey struct {
+	key       *rsa.PublicKey
+	actor     *remoteActor
+	fetchedAt time.Time
+}
+
+type remoteActor struct {
+	ID        string `json:"id"`
+	Inbox     string `json:"inbox"`
+	Endpoints struct {
+		SharedInbox string `json:"sharedInbox"`
+	} `json:"endpoints"`
+	PublicKey struct {
+		ID           string `json:"id"`
+		Owner        string `json:"owner"`
+		PublicKeyPem string `json:"publicKeyPem"`
+	} `json:"publicKey"`
+}
+
+// activity is the subset of an incoming ActivityStreams activity we act on.
+// Object is kept raw because it may be a URI string or an embedded object.
+type activity struct {
+	ID     string          `json:"id"`
+	Type   string          `json:"type"`
+	Actor  string          `json:"actor"`
+	Object json.RawMessage `json:"object"`
+}
+
+type activityObject struct {
+	ID        string `json:"id"`
+	Type      string `json:"type"`
+	Content   string `json:"content"`
+	InReplyTo string `json:"inReplyTo"`
+	Object    string `json:"object"`
+}
+
+// NewFederation loads (or creates on first run) the instance signing key.
+// baseURL is the public URL of this API, e.g. https://api.example.com.
+// Activities are delivered by deliveryWorkers workers once Run is called.
+// legacyPostIDs is HandlerConfig.LegacyPostIDs.
+func NewFederation(db *DB, baseURL string, deliveryWorkers int, legacyPostIDs bool) (*Federation, error) {
+	baseURL = strings.TrimRight(baseURL, "/")
+	parsed, err := url.Parse(baseURL)
+	if err != nil || parsed.Host == "" {
+		return nil, fmt.Errorf("invalid federation base URL %q", baseURL)
+	}
+
+	key, keyPEM, err := db.GetOrCreateFederationKey(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	return &Federation{
+		db:      db,
+		baseURL: baseURL,
+		domain:  parsed.Host,
+		key:     key,
+		keyPEM:  keyPEM,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		keys:    make(map[string]cachedRemoteKey),
+
+		legacyPostIDs: legacyPostIDs,
+		deliveries:    NewDispatcher(deliveryWorkers),
+	}, nil
+}
+
+// Run delivers activities to remote inboxes until ctx is cancelled.
+func (f *Federation) Run(ctx context.Context) {
+	f.deliveries.Run(ctx)
+}
+
+var nonHandleChars = regexp.MustCompile(`[^a-z0-9]+`)
+
+// eventHandle derives the fediverse username of an event board. It must stay
+// in sync with the SQL expression used by FindEventByHandle.
+func eventHandle(eventName string) string {
+	return strings.Trim(nonHandleChars.ReplaceAllString(strings.ToLower(eventName), "_"), "_")
+}
+
+func (f *Federation) actorURI(eventName string) string {
+	return f.baseURL + "/ap/events/" + eventHandle(eventName)
+}
+
+// postURI uses the post's public ID, so notes don't reveal how many posts
+// there are. Notes federated before public IDs keep their integer URIs, as
+// remote servers keep the URIs of notes they have seen; see postRef.
+func (f *Federation) postURI(post Post) string {
+	return f.baseURL + "/ap/posts/" + post.PublicID
+}
+
+// postRef resolves the ID in a note URI to the post's internal ID, or 0 if
+// there is no such post. Integer IDs are only accepted with legacyPostIDs.
+func (f *Federation) postRef(ctx context.Context, ref string) (int, error) {
+	if publicIDPattern.MatchString(ref) {
+		return f.db.GetPostIDByPublicID(ctx, ref)
+	}
+	if !f.legacyPostIDs {
+		return 0, nil
+	}
+	id, err := strconv.Atoi(ref)
+	if err != nil {
+		return 0, nil
+	}
+	return id, nil
+}
+
+// Webfinger handles GET /.well-known/webfinger
+func (f *Federation) Webfinger(w http.ResponseWriter, r *http.Request) {
+	resource := strings.TrimPrefix(r.URL.Query().Get("resource"), "acct:")
+	handle, domain, found := strings.Cut(resource, "@")
+	if !found || !strings.EqualFold(domain, f.domain) {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	eventName, ok := f.lookupEvent(w, r, handle)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/jrd+json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"subject": "acct:" + eventHandle(eventName) + "@" + f.domain,
+		"links": []map[string]string{
+			{"rel": "self", "type": activityContentType, "href": f.actorURI(eventName)},
+		},
+	})
+}
+
+// Actor handles GET /ap/events/{handle}
+func (f *Federation) Actor(w http.ResponseWriter, r *http.Request) {
+	eventName, ok := f.lookupEvent(w, r, r.PathValue("handle"))
+	if !ok {
+		return
+	}
+
+	actor := f.actorURI(eventName)
+	respondWithActivity(w, http.StatusOK, map[string]interface{}{
+		"@context":          []string{activityStreamsNS, securityNS},
+		"id":                actor,
+		"type":              "Group",
+		"preferredUsername": eventHandle(eventName),
+		"name":              eventName,
+		"summary":           html.EscapeString("Anonymous posts from " + eventName),
+		"inbox":             actor + "/inbox",
+		"outbox":            actor + "/outbox",
+		"followers":         actor + "/followers",
+		"publicKey": map[string]string{
+			"id":           actor + "#main-key",
+			"owner":        actor,
+			"publicKeyPem": f.keyPEM,
+		},
+	})
+}
+
+// Outbox handles GET /ap/events/{handle}/outbox
+func (f *Federation) Outbox(w http.ResponseWriter, r *http.Request) {
+	eventName, ok := f.lookupEvent(w, r, r.PathValue("handle"))
+	if !ok {
+		return
+	}
+
+	// Sensitive posts are federated with their warning as the note's summary
+	posts, err := f.db.GetPosts(r.Context(), PostFilter{Event: eventName, IncludeSensitive: true}, outboxPageSize, 0)
+	if err != nil {
+		log.Printf("Error getting outbox posts: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	items := make([]interface{}, 0, len(posts))
+	for _, post := range posts {
+		items = append(items, f.createActivity(post))
+	}
+
+	actor := f.actorURI(eventName)
+	respondWithActivity(w, http.StatusOK, map[string]interface{}{
+		"@context":     activityStreamsNS,
+		"id":           actor + "/outbox",
+		"type":         "OrderedCollection",
+		"totalItems":   len(items),
+		"orderedItems": items,
+	})
+}
+
+// Followers handles GET /ap/events/{handle}/followers. Only the count is
+// published; follower identities stay private.
+func (f *Federation) Followers(w http.ResponseWriter, r *http.Request) {
+	eventName, ok := f.lookupEvent(w, r, r.PathValue("handle"))
+	if !ok {
+		return
+	}
+
+	count, err := f.db.CountFederationFollowers(r.Context(), eventName)
+	if err != nil {
+		log.Printf("Error counting followers: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	respondWithActivity(w, http.StatusOK, map[string]interface{}{
+		"@context":   activityStreamsNS,
+		"id":         f.actorURI(eventName) + "/followers",
+		"type":       "OrderedCollection",
+		"totalItems": count,
+	})
+}
+
+// Note handles GET /ap/posts/{id}
+func (f *Federation) Note(w http.ResponseWriter, r *http.Request) {
+	id, err := f.postRef(r.Context(), r.PathValue("id"))
+	if err != nil {
+		log.Printf("Error looking up post: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if id == 0 {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	post, err := f.db.GetPostByID(r.Context(), id)
+	if err != nil {
+		log.Printf("Error getting post: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if post == nil || post.HiddenAt != nil {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	note := f.note(*post)
+	note["@context"] = activityStreamsNS
+	respondWithActivity(w, http.StatusOK, note)
+}
+
+// Inbox handles POST /ap/events/{handle}/inbox
+func (f *Federation) Inbox(w http.ResponseWriter, r *http.Request) {
+	eventName, ok := f.lookupEvent(w, r, r.PathValue("handle"))
+	if !ok {
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxInboxBodyBytes))
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	var act activity
+	if err := json.Unmarshal(body, &act); err != nil || act.Actor == "" {
+		http.Error(w, "Invalid activity", http.StatusBadRequest)
+		return
+	}
+
+	sender, err := f.verifySignature(r.Context(), r, body)
+	if err != nil {
+		log.Printf("Rejected inbox delivery from %s: %v", act.Actor, err)
+		http.Error(w, "Invalid signature", http.StatusUnauthorized)
+		return
+	}
+	if sender.ID != act.Actor {
+		http.Error(w, "Signature does not match actor", http.StatusUnauthorized)
+		return
+	}
+
+	switch act.Type {
+	case "Follow":
+		err = f.handleFollow(r.Context(), eventName, act, sender)
+	case "Undo":
+		err = f.handleUndo(r.Context(), eventName, act)
+	case "Create":
+		err = f.handleCreate(r.Context(), act)
+	case "Delete":
+		err = f.handleDelete(r.Context(), act)
+	}
+	if err != nil {
+		log.Printf("Error handling %s activity from %s: %v", act.Type, act.Actor, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (f *Federation) handleFollow(ctx context.Context, eventName string, act activity, sender *remoteActor) error {
+	if sender.Inbox == "" {
+		return errors.New("follower has no inbox")
+	}
+	if err := f.db.AddFederationFollower(ctx, eventName, sender.ID, sender.Inbox, sender.Endpoints.SharedInbox); err != nil {
+		return err
+	}
+
+	actor := f.actorURI(eventName)
+	accept := map[string]interface{}{
+		"@context": activityStreamsNS,
+		"id":       actor + "#accepts/" + randomToken(8),
+		"type":     "Accept",
+		"actor":    actor,
+		"object":   act,
+	}
+	f.enqueueDelivery(eventName, sender.Inbox, accept)
+	return nil
+}
+
+func (f *Federation) handleUndo(ctx context.Context, eventName string, act activity) error {
+	var inner activityObject
+	if err := json.Unmarshal(act.Object, &inner); err != nil || inner.Type != "Follow" {
+		return nil
+	}
+	return f.db.RemoveFederationFollower(ctx, eventName, act.Actor)
+}
+
+// handleCreate stores Notes that reply to one of our posts; everything else
+// that lands in the inbox is ignored.
+func (f *Federation) handleCreate(ctx context.Context, act activity) error {
+	var note activityObject
+	if err := json.Unmarshal(act.Object, &note); err != nil || note.Type != "Note" || note.ID == "" {
+		return nil
+	}
+
+	ref, found := strings.CutPrefix(note.InReplyTo, f.baseURL+"/ap/posts/")
+	if !found {
+		return nil
+	}
+
+	content := strings.TrimSpace(htmlToText(note.Content))
+	if content == "" {
+		return nil
+	}
+	if len(content) > 5000 {
+		content = content[:5000]
+	}
+
+	postID, err := f.postRef(ctx, ref)
+	if err != nil || postID == 0 {
+		return err
+	}
+
+	// Hidden posts take no replies, as they can't be seen
+	post, err := f.db.GetPostByID(ctx, postID)
+	if err != nil || post == nil || post.HiddenAt != nil {
+		return err
+	}
+
+	return f.db.CreateFederatedReply(ctx, post.ID, content, act.Actor, note.ID)
+}
+
+func (f *Federation) handleDelete(ctx context.Context, act activity) error {
+	objectURI := objectID(act.Object)
+	if objectURI == "" {
+		return nil
+	}
+	return f.db.DeleteFederatedReply(ctx, objectURI, act.Actor)
+}
+
+// PublishPost delivers a Create activity for a new post to every follower of
+// its event. It is safe to call on a nil Federation (federation disabled).
+func (f *Federation) PublishPost(post Post) {
+	if f == nil {
+		return
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+
+		inboxes, err := f.db.GetFederationInboxes(ctx, post.EventName)
+		if err != nil {
+			log.Printf("Error getting follower inboxes: %v", err)
+			return
+		}
+
+		create := f.createActivity(post)
+		create["@context"] = activityStreamsNS
+		for _, inbox := range inboxes {
+			f.enqueueDelivery(post.EventName, inbox, create)
+		}
+	}()
+}
+
+func (f *Federation) createActivity(post Post) map[string]interface{} {
+	actor := f.actorURI(post.EventName)
+	return map[string]interface{}{
+		"id":        f.postURI(post) + "/activity",
+		"type":      "Create",
+		"actor":     actor,
+		"published": post.CreatedAt.UTC().Format(time.RFC3339),
+		"to":        []string{publicAddress},
+		"cc":        []string{actor + "/followers"},
+		"object":    f.note(post),
+	}
+}
+
+func (f *Federation) note(post Post) map[string]interface{} {
+	actor := f.actorURI(post.EventName)
+	content := "<p>" + strings.ReplaceAll(html.EscapeString(post.Content), "\n", "<br>") + "</p>"
+	note := map[string]interface{}{
+		"id":           f.postURI(post),
+		"type":         "Note",
+		"attributedTo": actor,
+		"content":      content,
+		"published":    post.CreatedAt.UTC().Format(time.RFC3339),
+		"to":           []string{publicAddress},
+		"cc":           []string{actor + "/followers"},
+	}
+	// Mastodon and others show the summary as a content warning
+	if post.ContentWarning != "" {
+		note["summary"] = html.EscapeString(post.ContentWarning)
+		note["sensitive"] = true
+	}
+	return note
+}
+
+// enqueueDelivery queues a signed activity for a remote inbox. Deliveries
+// to one server run in order, one at a time, and a server that keeps
+// failing is skipped for a while. Deliveries given up on are dead-lettered.
+func (f *Federation) enqueueDelivery(eventName, inbox string, payload interface{}) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("Error encoding activity: %v", err)
+		return
+	}
+	target, err := url.Parse(inbox)
+	if err != nil || target.Host == "" {
+		log.Printf("Error delivering to %s: invalid inbox URL", inbox)
+		return
+	}
+
+	f.deliveries.Enqueue(target.Host, func(ctx context.Context) error {
+		return f.deliver(ctx, eventName, inbox, body)
+	}, func(err error, attempts int) {
+		f.deadLetter(eventName, inbox, body, err, attempts)
+	})
+}
+
+// deadLetter records an activity that couldn't be delivered, so it can be
+// redriven from /admin/dlq.
+func (f *Federation) deadLetter(eventName, inbox string, body []byte, deliveryErr error, attempts int) {
+	log.Printf("Dead-lettering delivery to %s after %d attempt(s): %v", inbox, attempts, deliveryErr)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	payload := federationDeadLetter{EventName: eventName, Inbox: inbox, Activity: body}
+	if err := f.db.RecordDeadLetter(ctx, deadLetterFederation, inbox, payload, deliveryErr.Error(), attempts); err != nil {
+		log.Printf("Error recording dead letter: %v", err)
+	}
+}
+
+// deliver POSTs a signed activity to a remote inbox. A refusal by the
+// inbox is logged rather than returned: only failures of the server count
+// against its circuit.
+func (f *Federation) deliver(ctx context.Context, eventName, inbox string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, inbox, bytes.NewReader(body))
+	if err != nil {
+		log.Printf("Error building delivery to %s: %v", inbox, err)
+		return nil
+	}
+	req.Header.Set("Content-Type", activityContentType)
+	if err := f.sign(req, f.actorURI(eventName)+"#main-key", body); err != nil {
+		log.Printf("Error signing delivery to %s: %v", inbox, err)
+		return nil
+	}
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	switch {
+	case resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests:
+		return fmt.Errorf("delivery to %s failed with status %d", inbox, resp.StatusCode)
+	case resp.StatusCode >= 300:
+		log.Printf("Delivery to %s failed with status %d", inbox, resp.StatusCode)
+	}
+	return nil
+}
+
+// sign adds a draft-cavage HTTP Signature covering the request target, host,
+// date and (for requests with a body) digest, as Mastodon expects.
+func (f *Federation) sign(req *http.Request, keyID string, body []byte) error {
+	req.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+
+	headers := []string{"(request-target)", "host", "date"}
+	if body != nil {
+		sum := sha256.Sum256(body)
+		req.Header.Set("Digest", "SHA-256="+base64.StdEncoding.EncodeToString(sum[:]))
+		headers = append(headers, "digest")
+	}
+
+	signingString := buildSigningString(req, headers)
+	hashed := sha256.Sum256([]byte(signingString))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, f.key, crypto.SHA256, hashed[:])
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Signature", fmt.Sprintf(
+		`keyId="%s",algorithm="rsa-sha256",headers="%s",signature="%s"`,
+		keyID, strings.Join(headers, " "), base64.StdEncoding.EncodeToString(signature),
+	))
+	return nil
+}
+
+// verifySignature checks the HTTP Signature on an inbox delivery and returns
+// the actor that owns the signing key.
+func (f *Federation) verifySignature(ctx context.Context, r *http.Request, body []byte) (*remoteActor, error) {
+	params := parseSignatureHeader(r.Header.Get("Signature"))
+	keyID, headerList, sig := params["keyId"], params["headers"], params["signature"]
+	if keyID == "" || sig == "" {
+		return nil, errors.New("missing signature")
+	}
+	if headerList == "" {
+		headerList = "date"
+	}
+	headers := strings.Fields(strings.ToLower(headerList))
+
+	signed := make(map[string]bool, len(headers))
+	for _, h := range headers {
+		signed[h] = true
+	}
+	if !signed["(request-target)"] || !signed["date"] || !signed["digest"] {
+		return nil, errors.New("signature must cover (request-target), date and digest")
+	}
+
+	date, err := http.ParseTime(r.Header.Get("Date"))
+	if err != nil {
+		return nil, errors.New("invalid date header")
+	}
+	if skew := time.Since(date); skew > maxSignatureClockSkew || skew < -maxSignatureClockSkew {
+		return nil, errors.New("date header outside allowed clock skew")
+	}
+
+	sum := sha256.Sum256(body)
+	if r.Header.Get("Digest") != "SHA-256="+base64.StdEncoding.EncodeToString(sum[:]) {
+		return nil, errors.New("digest mismatch")
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(sig)
+	if err != nil {
+		return nil, errors.New("invalid signature encoding")
+	}
+
+	key, actor, err := f.remoteKey(ctx, keyID)
+	if err != nil {
+		return nil, err
+	}
+
+	hashed := sha256.Sum256([]byte(buildSigningString(r, headers)))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, hashed[:], signature); err != nil {
+		return nil, errors.New("signature verification failed")
+	}
+
+	return actor, nil
+}
+
+func buildSigningString(r *http.Request, headers []string) string {
+	lines := make([]string, 0, len(headers))
+	for _, h := range headers {
+		switch h {
+		case "(request-target)":
+			lines = append(lines, "(request-target): "+strings.ToLower(r.Method)+" "+r.URL.RequestURI())
+		case "host":
+			host := r.Host
+			if host == "" {
+				host = r.URL.Host
+			}
+			lines = append(lines, "host: "+host)
+		default:
+			lines = append(lines, h+": "+r.Header.Get(h))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func parseSignatureHeader(header string) map[string]string {
+	params := make(map[string]string)
+	for _, part := range strings.Split(header, ",") {
+		key, value, found := strings.Cut(strings.TrimSpace(part), "=")
+		if found {
+			params[key] = strings.Trim(value, `"`)
+		}
+	}
+	return params
+}
+
+// remoteKey fetches (and caches) the actor document that owns keyID.
+func (f *Federation) remoteKey(ctx context.Context, keyID string) (*rsa.PublicKey, *remoteActor, error) {
+	f.mu.Lock()
+	cached, ok := f.keys[keyID]
+	f.mu.Unlock()
+	if ok && time.Since(cached.fetchedAt) < remoteKeyCacheTTL {
+		return cached.key, cached.actor, nil
+	}
+
+	actorURL, _, _ := strings.Cut(keyID, "#")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, actorURL, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid key id: %w", err)
+	}
+	req.Header.Set("Accept", activityContentType)
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to fetch key: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("failed to fetch key: status %d", resp.StatusCode)
+	}
+
+	var actor remoteActor
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxInboxBodyBytes)).Decode(&actor); err != nil {
+		return nil, nil, fmt.Errorf("failed to decode actor: %w", err)
+	}
+	if actor.PublicKey.ID != keyID || actor.PublicKey.Owner != actor.ID {
+		return nil, nil, errors.New("key does not belong to actor")
+	}
+
+	block, _ := pem.Decode([]byte(actor.PublicKey.PublicKeyPem))
+	if block == nil {
+		return nil, nil, errors.New("invalid public key PEM")
+	}
+	parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid public key: %w", err)
+	}
+	key, ok := parsed.(*rsa.PublicKey)
+	if !ok {
+		return nil, nil, errors.New("unsupported public key type")
+	}
+
+	f.mu.Lock()
+	f.keys[keyID] = cachedRemoteKey{key: key, actor: &actor, fetchedAt: time.Now()}
+	f.mu.Unlock()
+
+	return key, &actor, nil
+}
+
+// lookupEvent resolves an actor handle to an event name, writing a 404 when
+// no such event exists.
+func (f *Federation) lookupEvent(w http.ResponseWriter, r *http.Request, handle string) (string, bool) {
+	eventName, err := f.db.FindEventByHandle(r.Context(), strings.ToLower(handle))
+	if err != nil {
+		log.Printf("Error finding event by handle: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return "", false
+	}
+	if eventName == "" {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return "", false
+	}
+	return eventName, true
+}
+
+func respondWithActivity(w http.ResponseWriter, status int, payload interface{}) {
+	w.Header().Set("Content-Type", activityContentType)
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(payload); err != nil {
+		log.Printf("Error encoding activity response: %v", err)
+	}
+}
+
+// objectID returns the id of an activity object given either as a URI string
+// or as an embedded object.
+func objectID(raw json.RawMessage) string {
+	var uri string
+	if err := json.Unmarshal(raw, &uri); err == nil {
+		return uri
+	}
+	var obj activityObject
+	if err := json.Unmarshal(raw, &obj); err == nil {
+		return obj.ID
+	}
+	return ""
+}
+
+var (
+	htmlBreaks = regexp.MustCompile(`(?i)<br\s*/?>|</p>`)
+	htmlTags   = regexp.MustCompile(`<[^>]*>`)
+)
+
+// htmlToText flattens the HTML content of a remote Note to plain text.
+func htmlToText(s string) string {
+	s = htmlBreaks.ReplaceAllString(s, "\n")
+	s = htmlTags.ReplaceAllString(s, "")
+	return html.UnescapeString(s)
+}
+
+func randomToken(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// GetOrCreateFederationKey returns the instance signing key, generating and
+// storing one the first time federation is enabled.
+func (db *DB) GetOrCreateFederationKey(ctx context.Context) (*rsa.PrivateKey, string, error) {
+	var privatePEM, publicPEM string
+	err := db.conn.QueryRowContext(ctx, `
+		SELECT private_key_pem, public_key_pem
+		FROM federation_keys
+		ORDER BY id
+		LIMIT 1
+	`).Scan(&privatePEM, &publicPEM)
+
+	if err == sql.ErrNoRows {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to generate federation key: %w", err)
+		}
+		publicDER, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to encode federation key: %w", err)
+		}
+		privatePEM = string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}))
+		publicPEM = string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER}))
+
+		_, err = db.conn.ExecContext(ctx,
+			"INSERT INTO federation_keys (private_key_pem, public_key_pem) VALUES ($1, $2)",
+			privatePEM, publicPEM,
+		)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to store federation key: %w", err)
+		}
+		return key, publicPEM, nil
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to load federation key: %w", err)
+	}
+
+	block, _ := pem.Decode([]byte(privatePEM))
+	if block == nil {
+		return nil, "", errors.New("stored federation key is not valid PEM")
+	}
+	key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to parse federation key: %w", err)
+	}
+	return key, publicPEM, nil
+}
+
+// FindEventByHandle returns the event whose fediverse handle matches, or "".
+func (db *DB) FindEventByHandle(ctx context.Context, handle string) (string, error) {
+	query := `
+		SELECT event_name
+		FROM posts
+		WHERE TRIM(BOTH '_' FROM REGEXP_REPLACE(LOWER(event_name), '[^a-z0-9]+', '_', 'g')) = $1
+		GROUP BY event_name
+		ORDER BY MAX(created_at) DESC
+		LIMIT 1
+	`
+
+	var event string
+	err := db.conn.QueryRowContext(ctx, query, handle).Scan(&event)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to find event by handle: %w", err)
+	}
+	return event, nil
+}
+
+func (db *DB) AddFederationFollower(ctx context.Context, eventName, actorURI, inbox, sharedInbox string) error {
+	_, err := db.conn.ExecContext(ctx, `
+		INSERT INTO federation_followers (event_name, actor_uri, inbox_uri, shared_inbox_uri)
+		VALUES ($1, $2, $3, NULLIF($4, ''))
+		ON CONFLICT (event_name, actor_uri)
+		DO UPDATE SET inbox_uri = EXCLUDED.inbox_uri, shared_inbox_uri = EXCLUDED.shared_inbox_uri
+	`, eventName, actorURI, inbox, sharedInbox)
+	if err != nil {
+		return fmt.Errorf("failed to add follower: %w", err)
+	}
+	return nil
+}
+
+func (db *DB) RemoveFederationFollower(ctx context.Context, eventName, actorURI string) error {
+	_, err := db.conn.ExecContext(ctx,
+		"DELETE FROM federation_followers WHERE event_name = $1 AND actor_uri = $2",
+		eventName, actorURI,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to remove follower: %w", err)
+	}
+	return nil
+}
+
+func (db *DB) CountFederationFollowers(ctx context.Context, eventName string) (int, error) {
+	var count int
+	err := db.conn.QueryRowContext(ctx,
+		"SELECT COUNT(*) FROM federation_followers WHERE event_name = $1",
+		eventName,
+	).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count followers: %w", err)
+	}
+	return count, nil
+}
+
+// GetFederationInboxes returns the distinct inboxes to deliver an event's
+// posts to, preferring shared inboxes so each server gets one copy.
+func (db *DB) GetFederationInboxes(ctx context.Context, eventName string) ([]string, error) {
+	rows, err := db.conn.QueryContext(ctx, `
+		SELECT DISTINCT COALESCE(shared_inbox_uri, inbox_uri)
+		FROM federation_followers
+		WHERE event_name = $1
+	`, eventName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query inboxes: %w", err)
+	}
+	defer rows.Close()
+
+	var inboxes []string
+	for rows.Next() {
+		var inbox string
+		if err := rows.Scan(&inbox); err != nil {
+			return nil, fmt.Errorf("failed to scan inbox: %w", err)
+		}
+		inboxes = append(inboxes, inbox)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating inboxes: %w", err)
+	}
+	return inboxes, nil
+}
+
+// CreateFederatedReply stores a reply, notifying the device the post was
+// made with, if any.
+func (db *DB) CreateFederatedReply(ctx context.Context, postID int, content, actorURI, objectURI string) error {
+	_, err := db.conn.ExecContext(ctx, `
+		WITH reply AS (
+			INSERT INTO replies (post_id, content, source, actor_uri, object_uri)
+			VALUES ($1, $2, 'activitypub', $3, $4)
+			ON CONFLICT (object_uri) DO NOTHING
+			RETURNING id, post_id
+		), counted AS (
+			UPDATE posts SET reply_count = reply_count + 1
+			FROM reply
+			WHERE posts.id = reply.post_id
+			RETURNING posts.device_token_hash, reply.post_id, reply.id AS reply_id
+		)
+		INSERT INTO notifications (device_token_hash, kind, post_id, reply_id)
+		SELECT device_token_hash, $5, post_id, reply_id
+		FROM counted
+		WHERE device_token_hash IS NOT NULL
+	`, postID, content, actorURI, objectURI, NotificationReply)
+	if err != nil {
+		return fmt.Errorf("failed to create reply: %w", err)
+	}
+	return nil
+}
+
+// DeleteFederatedReply removes a reply when its author deletes the Note. The
+// actor check stops one server from deleting another's replies.
+func (db *DB) DeleteFederatedReply(ctx context.Context, objectURI, actorURI string) error {
+	_, err := db.conn.ExecContext(ctx, `
+		WITH reply AS (
+			DELETE FROM replies WHERE object_uri = $1 AND actor_uri = $2
+			RETURNING post_id
+		)
+		UPDATE posts SET reply_count = reply_count - 1
+		FROM reply
+		WHERE posts.id = reply.post_id
+	`, objectURI, actorURI)
+	if err != nil {
+		return fmt.Errorf("failed to delete reply: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// postFields are the fields ?fields= can select from a post, by their JSON
+// names. Anything not listed here, such as edit_token, can't be selected.
+// created_ago isn't a field of Post; it is worked out as posts are encoded,
+// and only sent when selected.
+var postFields = map[string]func(p *Post) any{
+	"id":                 func(p *Post) any { return p.ID },
+	"public_id":          func(p *Post) any { return p.PublicID },
+	"uuid":               func(p *Post) any { return p.UUID },
+	"event_name":         func(p *Post) any { return p.EventName },
+	"content":            func(p *Post) any { return p.Content },
+	"age":                func(p *Post) any { return p.Age },
+	"gender":             func(p *Post) any { return p.Gender },
+	"location":           func(p *Post) any { return p.Location },
+	"created_at":         func(p *Post) any { return p.CreatedAt },
+	"created_at_local":   func(p *Post) any { return p.CreatedAtLocal },
+	"created_at_display": func(p *Post) any { return p.CreatedAtDisplay },
+	"timezone":           func(p *Post) any { return p.Timezone },
+	"created_ago":        func(p *Post) any { return relativeTime(p.CreatedAt, time.Now()) },
+	"custom_fields":      func(p *Post) any { return p.CustomFields },
+	"template_id":        func(p *Post) any { return p.TemplateID },
+	"session_id":         func(p *Post) any { return p.SessionID },
+	"attachments":        func(p *Post) any { return p.Attachments },
+	"content_warning":    func(p *Post) any { return p.ContentWarning },
+	"edited_at":          func(p *Post) any { return p.EditedAt },
+	"edit_count":         func(p *Post) any { return p.EditCount },
+	"hidden_at":          func(p *Post) any { return p.HiddenAt },
+	"archived":           func(p *Post) any { return p.Archived },
+	"reaction_count":     func(p *Post) any { return p.ReactionCount },
+	"is_promoted":        func(p *Post) any { return p.IsPromoted },
+	"promotion":          func(p *Post) any { return p.Promotion },
+	"verified_attendee":  func(p *Post) any { return p.VerifiedAttendee },
+}
+
+// PostProjection is the set of post fields a client asked for with
+// ?fields=, in the order asked. A nil projection means every field.
+type PostProjection []string
+
+// parsePostFields parses a comma-separated ?fields= list, rejecting fields
+// that aren't in postFields. An empty list selects every field.
+func parsePostFields(raw string) (PostProjection, error) {
+	if strings.TrimSpace(raw) == "" {
+		return nil, nil
+	}
+
+	var projection PostProjection
+	seen := make(map[string]bool)
+	for _, name := range strings.Split(raw, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" || seen[name] {
+			continue
+		}
+		if _, ok := postFields[name]; !ok {
+			return nil, &ValidationError{Message: fmt.Sprintf("unknown field %q", name)}
+		}
+		seen[name] = true
+		projection = append(projection, name)
+	}
+	return projection, nil
+}
+
+// Includes reports whether the projection selects the field.
+func (p PostProjection) Includes(field string) bool {
+	return p == nil || containsString(p, field)
+}
+
+// Apply trims posts to the selected fields, so only those are encoded.
+// Selected fields are always present, as null when the post has no value.
+// Without a projection the posts are returned as they are.
+func (p PostProjection) Apply(posts []Post) any {
+	if p == nil {
+		return posts
+	}
+
+	projected := make([]map[string]any, len(posts))
+	for i := range posts {
+		fields := make(map[string]any, len(p))
+		for _, name := range p {
+			fields[name] = postFields[name](&posts[i])
+		}
+		projected[i] = fields
+	}
+	return projected
+}
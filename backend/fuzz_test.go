@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func FuzzValidateCreatePostRequest(f *testing.F) {
+	f.Add("Glastonbury", "Blue hat by the Pyramid stage", 25, "F", "Bristol", "", false)
+	f.Add("", "", 0, "", "", "", false)
+	f.Add("Family Day", "hi", 9, "", "", "", true)
+	f.Add(" \t ", " ", -1, strings.Repeat("g", 21), strings.Repeat("l", 201), strings.Repeat("w", 101), false)
+	f.Add("\xff\xfe", "café \U0001F3B6", 120, "\x00", "\r\n", "drugs", false)
+
+	f.Fuzz(func(t *testing.T, event, content string, age int, gender, location, warning string, allAges bool) {
+		req := CreatePostRequest{
+			EventName:      event,
+			Content:        content,
+			Age:            age,
+			Gender:         gender,
+			Location:       location,
+			ContentWarning: warning,
+		}
+		normalizeCreatePostRequest(&req)
+
+		settings := defaultEventSettings
+		settings.AllAges = allAges
+
+		if err := validateCreatePostRequest(req, settings); err != nil {
+			if _, ok := err.(*ValidationError); !ok {
+				t.Fatalf("error %v is not a ValidationError", err)
+			}
+			return
+		}
+
+		// Anything accepted must satisfy the documented limits
+		if req.EventName == "" || len(req.EventName) > 200 {
+			t.Errorf("accepted event_name of length %d", len(req.EventName))
+		}
+		if req.Content == "" || len(req.Content) > 5000 {
+			t.Errorf("accepted content of length %d", len(req.Content))
+		}
+		if len(req.ContentWarning) > maxContentWarningLength {
+			t.Errorf("accepted content_warning of length %d", len(req.ContentWarning))
+		}
+		if !allAges {
+			if req.Age < minimumAge || req.Age > 120 {
+				t.Errorf("accepted age %d", req.Age)
+			}
+			if req.Location == "" || len(req.Location) > 200 {
+				t.Errorf("accepted location of length %d", len(req.Location))
+			}
+			if len(req.Gender) > 20 {
+				t.Errorf("accepted gender of length %d", len(req.Gender))
+			}
+		}
+	})
+}
+
+func FuzzGetIP(f *testing.F) {
+	f.Add("", "")
+	f.Add("198.51.100.1, 10.0.0.1", "")
+	f.Add(" , 198.51.100.1", "")
+	f.Add(",,,", " ")
+	f.Add("", "192.0.2.9")
+	f.Add("\t198.51.100.1\t", "")
+
+	f.Fuzz(func(t *testing.T, forwarded, realIP string) {
+		r := httptest.NewRequest(http.MethodPost, "/api/posts", nil)
+		r.RemoteAddr = "203.0.113.7:51234"
+		r.Header.Set("X-Forwarded-For", forwarded)
+		r.Header.Set("X-Real-IP", realIP)
+
+		ip := getIP(r)
+
+		// Junk headers must not give clients an empty or list-valued
+		// identity to share
+		if ip == "" {
+			t.Errorf("getIP returned an empty address for forwarded=%q real=%q", forwarded, realIP)
+		}
+		if ip != strings.TrimSpace(ip) {
+			t.Errorf("getIP returned untrimmed %q", ip)
+		}
+		if strings.Contains(ip, ",") {
+			t.Errorf("getIP returned a list: %q", ip)
+		}
+	})
+}
+
+func FuzzParsePagination(f *testing.F) {
+	f.Add("limit=10&offset=20")
+	f.Add("limit=0&offset=-1")
+	f.Add("limit=9999999999999999999999&offset=9223372036854775807")
+	f.Add("limit=1e3&limit=5&offset=%zz")
+	f.Add("")
+
+	f.Fuzz(func(t *testing.T, query string) {
+		r := httptest.NewRequest(http.MethodGet, "/api/posts", nil)
+		r.URL.RawQuery = query
+
+		limit, offset := parsePagination(r)
+		if limit < 1 || limit > 100 {
+			t.Errorf("limit %d out of range for %q", limit, query)
+		}
+		if offset < 0 {
+			t.Errorf("negative offset %d for %q", offset, query)
+		}
+	})
+}
+
+func FuzzParseFieldFilters(f *testing.F) {
+	schema := CustomFieldSchema{
+		{Name: "stage", Type: "select", Options: []string{"Main", "Other"}},
+		{Name: "row", Type: "number"},
+		{Name: "note", Type: "text", MaxLength: 50},
+	}
+
+	f.Add("field.stage=Main")
+	f.Add("field.row=12.5&field.note=hi")
+	f.Add("field.row=NaN")
+	f.Add("field.row=-Inf&field.unknown=1")
+	f.Add("field.=x&field.row=0x1p-2")
+
+	f.Fuzz(func(t *testing.T, query string) {
+		r := httptest.NewRequest(http.MethodGet, "/api/posts", nil)
+		r.URL.RawQuery = query
+		if _, err := url.ParseQuery(query); err != nil {
+			return
+		}
+
+		filters, err := parseFieldFilters(r, schema)
+		if err != nil {
+			if _, ok := err.(*ValidationError); !ok {
+				t.Fatalf("error %v is not a ValidationError", err)
+			}
+			return
+		}
+
+		// Filters are sent to Postgres as JSON, so must always encode
+		if _, err := json.Marshal(filters); err != nil {
+			t.Errorf("filters for %q don't encode: %v", query, err)
+		}
+		for name := range filters {
+			if _, ok := schema.field(name); !ok {
+				t.Errorf("accepted unknown field %q", name)
+			}
+		}
+	})
+}
+
+func FuzzNormalizeAltText(f *testing.F) {
+	f.Add("A crowd at the main stage at dusk")
+	f.Add("IMG_2041.JPG")
+	f.Add("  spaced \n\t out  ")
+	f.Add(strings.Repeat("a ", 600))
+
+	f.Fuzz(func(t *testing.T, alt string) {
+		out, err := normalizeAltText(alt)
+		if err != nil {
+			return
+		}
+		if len(out) > maxAltTextLength {
+			t.Errorf("accepted alt text of length %d", len(out))
+		}
+		if out != strings.TrimSpace(out) || strings.Contains(out, "  ") {
+			t.Errorf("alt text %q is not normalized", out)
+		}
+	})
+}
+
+func FuzzHTMLToText(f *testing.F) {
+	f.Add(`<p>Hello<br>world</p>`)
+	f.Add(`<scr<script>ipt>alert(1)</script>`)
+	f.Add(`&lt;b&gt;not a tag&lt;/b&gt;`)
+	f.Add(`<a href="x" title=">">link</a>`)
+
+	f.Fuzz(func(t *testing.T, s string) {
+		// Remote content is hostile; it only has to convert without panicking
+		htmlToText(s)
+	})
+}
+
+func FuzzICSLine(f *testing.F) {
+	f.Add("SUMMARY", "Check the Glastonbury board")
+	f.Add("DESCRIPTION", strings.Repeat("café \U0001F3B6 ", 40))
+	f.Add("X-WR-CALNAME", strings.Repeat("x", 75))
+
+	f.Fuzz(func(t *testing.T, name, value string) {
+		// Callers escape values, so raw line breaks never reach line
+		if !utf8.ValidString(name) || !utf8.ValidString(value) || strings.ContainsAny(name+value, "\r\n") {
+			return
+		}
+		var cal icsWriter
+		cal.line(name, value)
+
+		out := cal.String()
+		if !strings.HasSuffix(out, "\r\n") {
+			t.Fatalf("output %q doesn't end in CRLF", out)
+		}
+		for _, line := range strings.Split(strings.TrimSuffix(out, "\r\n"), "\r\n") {
+			if len(line) > 75 {
+				t.Errorf("line of %d octets exceeds 75", len(line))
+			}
+			if !utf8.ValidString(line) {
+				t.Errorf("line %q splits a UTF-8 sequence", line)
+			}
+		}
+		unfolded := strings.ReplaceAll(strings.TrimSuffix(out, "\r\n"), "\r\n ", "")
+		if unfolded != name+":"+value {
+			t.Errorf("unfolded %q, want %q", unfolded, name+":"+value)
+		}
+	})
+}
@@ -1,22 +1,94 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type Handler struct {
-	db *DB
+	db         Store
+	federation *Federation
+	cfg        HandlerConfig
+	// events and trending cache the hot lists; see Warm
+	events   listCache[[]string]
+	trending listCache[[]EventSummary]
 }
 
-func NewHandler(db *DB) *Handler {
-	return &Handler{db: db}
+// HandlerConfig carries the settings handlers need from the environment.
+type HandlerConfig struct {
+	AdminToken string
+	Retention  RetentionPolicy
+	Terms      *Terms
+	Translator Translator
+	// Media holds uploaded images; uploads are capped at MaxUploadBytes
+	Media           MediaStore
+	MaxUploadBytes  int
+	RequireAltText  bool
+	AltTextBackfill *AltTextBackfill
+	// Caps limit posts per minute across the server and per event
+	Caps *PostingCaps
+	// WriteQueue holds posts while the database is saturated
+	WriteQueue *WriteQueue
+	// WriteLimiter caps the writes in flight; only reported here
+	WriteLimiter *ConcurrencyLimiter
+	// InviteOnly requires an invite code for posts to every event, not
+	// just those marked invite-only
+	InviteOnly bool
+	// HotTier serves the first page of events with heavy traffic from
+	// memory; nil turns it off
+	HotTier *HotTier
+	// Toxicity scores posts after they are published or edited
+	Toxicity *Toxicity
+	// ScanUploads holds uploads back until the virus scanner passes them
+	ScanUploads bool
+	// MediaURLs signs attachment URLs; they are unsigned when it is nil
+	MediaURLs *MediaURLSigner
+	// LegacyPostIDs lets public post routes take integer IDs as well as
+	// public ones
+	LegacyPostIDs bool
+	// Archive reads snapshots of archived boards; nil when archiving is off
+	Archive *ArchiveReader
+	// Jobs are the background jobs admins can run on demand
+	Jobs *Jobs
+	// RateLimiters are reported by the admin rate limit status
+	RateLimiters []*RateLimiter
+	// Metrics are this process's request counts
+	Metrics *Metrics
+	// ValidationStats counts the rules that reject post submissions
+	ValidationStats *ValidationStats
+	// Impressions counts the promoted cards served in feeds
+	Impressions *PromotionImpressions
+	// Hooks run a deployment's own code as posts are created, published
+	// and reported; nil when there are none
+	Hooks *PostHooks
+	// Recorder keeps recent requests for debugging
+	Recorder *Recorder
+	// Broker wakes long-polls when posts are published
+	Broker *PostBroker
+	// Mailer sends email alerts; they are disabled without one
+	Mailer Mailer
+	// PublicURL is the base URL of this API, for links in emails
+	PublicURL string
+	// ProofOfWork makes clients solve a challenge before posting; nil
+	// turns it off
+	ProofOfWork *ProofOfWork
+	// EditWindow is how long after posting authors can edit; 0 is no limit
+	EditWindow time.Duration
 }
 
-// CreatePost handles POST /api/posts
+func NewHandler(db Store, federation *Federation, cfg HandlerConfig) *Handler {
+	return &Handler{db: db, federation: federation, cfg: cfg}
+}
+
+// CreatePost handles POST /api/posts. When the database's connections are
+// all busy and the write queue is enabled, the post is queued instead and
+// the response is a 202 with a URL to poll for the outcome.
 func (h *Handler) CreatePost(w http.ResponseWriter, r *http.Request) {
 	var req CreatePostRequest
 
@@ -25,9 +97,7 @@ func (h *Handler) CreatePost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Validate request
-	if err := validateCreatePostRequest(req); err != nil {
-		respondWithError(w, http.StatusBadRequest, err.Error())
+	if !h.checkProofOfWork(w, r) {
 		return
 	}
 
@@ -37,40 +107,326 @@ func (h *Handler) CreatePost(w http.ResponseWriter, r *http.Request) {
 		ipHash = computeIPHash(r)
 	}
 
+	// A device token is optional, and lets the poster see removal notices
+	if r.Header.Get("X-Device-Token") != "" {
+		tokenHash, ok := deviceToken(w, r)
+		if !ok {
+			return
+		}
+		req.DeviceTokenHash = tokenHash
+	}
+
+	if h.cfg.WriteQueue != nil && h.db.Saturated() {
+		if !h.checkPostingCaps(w, req.EventName) {
+			return
+		}
+		h.queuePost(w, req, ipHash)
+		return
+	}
+
+	attachments, err := h.preparePost(w, r, &req, ipHash)
+	if err != nil {
+		h.cfg.ValidationStats.Record(err)
+		return
+	}
+	if !h.checkPostingCaps(w, req.EventName) {
+		return
+	}
+
 	// Create post
-	post, err := h.db.CreatePost(r.Context(), req, ipHash)
+	editToken := randomToken(16)
+	post, err := h.publishPost(r.Context(), req, attachments, ipHash, hashToken(editToken))
+	if validation, ok := err.(*ValidationError); ok {
+		// The invite code was used up since it was checked
+		h.cfg.ValidationStats.Record(err)
+		respondWithError(w, http.StatusBadRequest, validation.Error())
+		return
+	}
 	if err != nil {
 		log.Printf("Error creating post: %v", err)
 		respondWithError(w, http.StatusInternalServerError, "Failed to create post")
 		return
 	}
 
+	post.EditToken = editToken
 	respondWithJSON(w, http.StatusCreated, post)
 }
 
+// publishPost saves a checked post, redeems its invite and check-in codes,
+// attaches its uploads, screens it, queues it for toxicity scoring and
+// federates it. attachments are the uploads checkPost returned. The post, its
+// attachments and its moderation flags are written in one transaction, so a
+// failure leaves none of them behind, and nothing is published until it
+// commits. A code used up or expired since checkPost is a *ValidationError.
+func (h *Handler) publishPost(ctx context.Context, req CreatePostRequest, attachments []Attachment, ipHash, editTokenHash string) (*Post, error) {
+	var post *Post
+	err := h.db.WithTx(ctx, func(tx Store) error {
+		// The invite code is used up with the post, so its cap holds
+		if req.InviteCode != "" {
+			ok, err := tx.RedeemInviteCode(ctx, req.InviteCode, req.EventName)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return errInviteCodeInvalid()
+			}
+		}
+		// A check-in code revoked or expired since checkPost earns no badge
+		if req.VerifiedAttendee {
+			ok, err := tx.UseCheckinCode(ctx, req.EventName, req.CheckinCode)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return errCheckinCodeInvalid()
+			}
+		}
+		var err error
+		post, err = tx.CreatePost(ctx, req, ipHash, editTokenHash)
+		if err != nil {
+			return err
+		}
+		if len(req.AttachmentIDs) > 0 {
+			if err := tx.AttachToPost(ctx, post.ID, req.AttachmentIDs); err != nil {
+				return fmt.Errorf("failed to attach uploads to post %d: %w", post.ID, err)
+			}
+			if err := flagBannedImages(ctx, tx, post.ID, attachments); err != nil {
+				return err
+			}
+		}
+		return screenPost(ctx, tx, post)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(req.AttachmentIDs) > 0 {
+		posts := []Post{*post}
+		if err := h.loadAttachments(ctx, posts); err != nil {
+			log.Printf("Error loading attachments: %v", err)
+		}
+		post = &posts[0]
+	}
+
+	h.cfg.Toxicity.Enqueue(*post)
+	h.federation.PublishPost(*post)
+	h.cfg.Broker.Publish(*post)
+	h.cfg.Hooks.Publish(*post)
+	h.cfg.HotTier.invalidate(post.EventName)
+	return post, nil
+}
+
+// preparePost normalizes, validates and redacts a new post the same way for
+// publishing and for previews, returning the post's pending attachments. On
+// failure it writes the error response and returns the error.
+func (h *Handler) preparePost(w http.ResponseWriter, r *http.Request, req *CreatePostRequest, ipHash string) ([]Attachment, error) {
+	attachments, err := h.checkPost(r.Context(), req, ipHash)
+	switch err.(type) {
+	case nil:
+		return attachments, nil
+	case *ValidationError:
+		respondWithError(w, http.StatusBadRequest, err.Error())
+	case *TermsChangedError:
+		respondWithError(w, http.StatusConflict, err.Error())
+	case *BannedError:
+		respondWithError(w, http.StatusForbidden, err.Error())
+	case *DeviceQuotaError:
+		respondDeviceQuota(w, err.(*DeviceQuotaError))
+	case *PostingClosedError:
+		respondPostingClosed(w, err.(*PostingClosedError))
+	default:
+		log.Printf("Error checking post: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to create post")
+	}
+	return nil, err
+}
+
+// checkPost does the work of preparePost. The post's problems are returned
+// as a *ValidationError, a *TermsChangedError if it cites old terms, a
+// *BannedError if its author is banned, a *DeviceQuotaError if they have
+// made the event's limit of posts, or a *PostingClosedError outside the
+// board's posting window.
+func (h *Handler) checkPost(ctx context.Context, req *CreatePostRequest, ipHash string) ([]Attachment, error) {
+	banned, err := h.db.IsAuthorBanned(ctx, ipHash)
+	if err != nil {
+		return nil, err
+	}
+	if banned {
+		return nil, &BannedError{}
+	}
+
+	normalizeCreatePostRequest(req)
+
+	// Posts to a renamed or merged event land on its current board
+	canonical, err := h.canonicalEventName(ctx, req.EventName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve event name: %w", err)
+	}
+	req.EventName = canonical
+
+	archived, err := h.db.IsEventArchived(ctx, req.EventName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check event archive: %w", err)
+	}
+	if archived {
+		return nil, &ValidationError{Message: "This board has been archived and is read-only", Rule: "event.archived"}
+	}
+
+	settings, err := h.db.GetEventSettings(ctx, req.EventName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get event settings: %w", err)
+	}
+
+	// Validate request
+	if err := validateCreatePostRequest(*req, settings); err != nil {
+		return nil, err
+	}
+
+	if err := checkPostingWindow(settings, time.Now()); err != nil {
+		return nil, err
+	}
+	if err := h.checkInviteCode(ctx, req, settings); err != nil {
+		return nil, err
+	}
+	if err := h.checkDeviceQuota(ctx, *req, ipHash, settings); err != nil {
+		return nil, err
+	}
+	if err := h.checkCheckinCode(ctx, req); err != nil {
+		return nil, err
+	}
+
+	// The template and session, if any, must belong to the post's event
+	if err := h.checkPostReferences(ctx, *req); err != nil {
+		return nil, err
+	}
+
+	req.CustomFields, err = settings.CustomFields.validate(req.CustomFields)
+	if err != nil {
+		return nil, err
+	}
+
+	// Drop anything the event doesn't collect rather than storing it
+	settings.redactRequest(req)
+
+	if err := h.checkTermsVersion(req.TermsVersion); err != nil {
+		return nil, err
+	}
+
+	// Hooks see previews too, so a preview is refused as the post would be
+	if err := h.cfg.Hooks.Create(ctx, req); err != nil {
+		return nil, err
+	}
+
+	return h.checkPostAttachments(ctx, *req, ipHash)
+}
+
 // GetPosts handles GET /api/posts
 func (h *Handler) GetPosts(w http.ResponseWriter, r *http.Request) {
 	// Parse query parameters
-	eventFilter := r.URL.Query().Get("event")
+	event, err := h.canonicalEventName(r.Context(), r.URL.Query().Get("event"))
+	if err != nil {
+		log.Printf("Error resolving event name: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve posts")
+		return
+	}
+	// A hot event's plain first page comes pre-rendered from memory
+	if h.cfg.HotTier.observe(event, time.Now()) && hotEligible(r) && h.serveHotPage(w, r, event) {
+		return
+	}
+
+	// Posts with a content warning are left out unless asked for
+	filter := PostFilter{Event: event, IncludeSensitive: r.URL.Query().Get("include_sensitive") == "true"}
+
+	limit, offset := parsePagination(r)
+
+	// ?fields=id,content trims each post to the fields the client needs
+	projection, err := parsePostFields(r.URL.Query().Get("fields"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// ?sort=shuffle&seed= browses in a random order that is the same for a
+	// seed, so older posts get seen too
+	switch r.URL.Query().Get("sort") {
+	case "", "newest":
+	case "shuffle":
+		filter.ShuffleSeed = r.URL.Query().Get("seed")
+		if filter.ShuffleSeed == "" || len(filter.ShuffleSeed) > maxShuffleSeed {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("shuffle needs a seed of at most %d characters", maxShuffleSeed))
+			return
+		}
+	default:
+		respondWithError(w, http.StatusBadRequest, "sort must be one of newest, shuffle")
+		return
+	}
+
+	// ?snapshot= pins later pages to the posts listed on the first
+	if token := r.URL.Query().Get("snapshot"); token != "" {
+		filter.MaxID, err = parseSnapshotToken(token)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid snapshot token")
+			return
+		}
+	}
 
-	limitStr := r.URL.Query().Get("limit")
-	limit := 50 // default
-	if limitStr != "" {
-		if parsedLimit, err := strconv.Atoi(limitStr); err == nil && parsedLimit > 0 && parsedLimit <= 100 {
-			limit = parsedLimit
+	// ?ids= fetches particular posts instead of listing them
+	if r.URL.Query().Has("ids") {
+		h.getPostsByID(w, r, projection)
+		return
+	}
+
+	// ?session= drills into one session; without it an event's listing
+	// includes every session
+	if session := r.URL.Query().Get("session"); session != "" {
+		id, err := strconv.Atoi(session)
+		if err != nil || id < 1 {
+			respondWithError(w, http.StatusBadRequest, "Invalid session ID")
+			return
+		}
+		filter.Session = id
+	}
+
+	// Custom field filters (?field.stage=Main) are typed by the event's schema
+	if hasFieldFilters(r) {
+		if filter.Event == "" {
+			respondWithError(w, http.StatusBadRequest, "field filters require an event")
+			return
+		}
+		settings, err := h.db.GetEventSettings(r.Context(), filter.Event)
+		if err != nil {
+			log.Printf("Error getting event settings: %v", err)
+			respondWithError(w, http.StatusInternalServerError, "Failed to retrieve posts")
+			return
+		}
+		filter.Fields, err = parseFieldFilters(r, settings.CustomFields)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
 		}
 	}
 
-	offsetStr := r.URL.Query().Get("offset")
-	offset := 0 // default
-	if offsetStr != "" {
-		if parsedOffset, err := strconv.Atoi(offsetStr); err == nil && parsedOffset >= 0 {
-			offset = parsedOffset
+	// A device's saved preferences narrow every listing it asks for
+	if !h.applyPreferences(w, r, &filter) {
+		return
+	}
+
+	// Archived boards are served from their snapshot, so old links work
+	if filter.Event != "" && h.cfg.Archive != nil {
+		event, err := h.db.GetEvent(r.Context(), filter.Event)
+		if err != nil {
+			log.Printf("Error getting event: %v", err)
+			respondWithError(w, http.StatusInternalServerError, "Failed to retrieve posts")
+			return
+		}
+		if event != nil && event.ArchivedAt != nil {
+			h.getArchivedPosts(w, r, event, filter, projection, limit, offset)
+			return
 		}
 	}
 
 	// Get posts
-	posts, err := h.db.GetPosts(r.Context(), eventFilter, limit, offset)
+	posts, err := h.db.GetPosts(r.Context(), filter, limit, offset)
 	if err != nil {
 		log.Printf("Error getting posts: %v", err)
 		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve posts")
@@ -82,12 +438,87 @@ func (h *Handler) GetPosts(w http.ResponseWriter, r *http.Request) {
 		posts = []Post{}
 	}
 
-	respondWithJSON(w, http.StatusOK, posts)
+	// The first page's newest post bounds the snapshot for later pages. A
+	// shuffled first page needn't hold the newest post, so it can't say
+	if filter.MaxID != 0 {
+		w.Header().Set(snapshotHeader, snapshotToken(filter.MaxID))
+	} else if offset == 0 && len(posts) > 0 && filter.ShuffleSeed == "" {
+		maxID := 0
+		for _, post := range posts {
+			maxID = max(maxID, post.ID)
+		}
+		w.Header().Set(snapshotHeader, snapshotToken(maxID))
+	}
+
+	if err := h.presentPosts(r.Context(), posts, projection); err != nil {
+		log.Printf("Error presenting posts: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve posts")
+		return
+	}
+
+	// An event's promoted cards go on the first page of its feed
+	if filter.Event != "" && offset == 0 && filter.MaxID == 0 {
+		var promoted []int
+		posts, promoted, err = h.promotePosts(r.Context(), filter.Event, posts)
+		if err != nil {
+			log.Printf("Error getting promotions: %v", err)
+			respondWithError(w, http.StatusInternalServerError, "Failed to retrieve posts")
+			return
+		}
+		h.cfg.Impressions.Record(promoted)
+	}
+
+	respondWithJSON(w, http.StatusOK, projection.Apply(posts))
+}
+
+// presentPosts readies posts for a public response: it redacts them by
+// their events' settings and, if the projection includes them, loads their
+// attachments.
+func (h *Handler) presentPosts(ctx context.Context, posts []Post, projection PostProjection) error {
+	if err := h.applyEventSettings(ctx, posts); err != nil {
+		return fmt.Errorf("failed to apply event settings: %w", err)
+	}
+	if projection.Includes("attachments") {
+		if err := h.loadAttachments(ctx, posts); err != nil {
+			return fmt.Errorf("failed to load attachments: %w", err)
+		}
+	}
+	return nil
 }
 
-// GetEvents handles GET /api/events
+// GetEvents handles GET /api/events. Supports ?sort=recent|alphabetical|most_posts,
+// ?q= substring search, ?category= and limit/offset pagination.
 func (h *Handler) GetEvents(w http.ResponseWriter, r *http.Request) {
-	events, err := h.db.GetEvents(r.Context())
+	opts := EventListOptions{
+		Sort:     r.URL.Query().Get("sort"),
+		Query:    strings.TrimSpace(r.URL.Query().Get("q")),
+		Category: r.URL.Query().Get("category"),
+	}
+	if opts.Category != "" && !containsString(eventCategories, opts.Category) {
+		respondWithError(w, http.StatusBadRequest, "category must be one of "+strings.Join(eventCategories, ", "))
+		return
+	}
+	if opts.Sort == "" {
+		opts.Sort = "recent"
+	}
+	if _, ok := eventSortOrders[opts.Sort]; !ok {
+		respondWithError(w, http.StatusBadRequest, "sort must be one of recent, alphabetical, most_posts")
+		return
+	}
+	if len(opts.Query) > 200 {
+		respondWithError(w, http.StatusBadRequest, "q must be 200 characters or less")
+		return
+	}
+
+	limit, offset := parsePagination(r)
+
+	var events []string
+	var err error
+	if opts == (EventListOptions{Sort: "recent"}) && limit == defaultPageSize && offset == 0 {
+		events, err = h.hotEvents(r.Context())
+	} else {
+		events, err = h.db.GetEvents(r.Context(), opts, limit, offset)
+	}
 	if err != nil {
 		log.Printf("Error getting events: %v", err)
 		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve events")
@@ -104,45 +535,80 @@ func (h *Handler) GetEvents(w http.ResponseWriter, r *http.Request) {
 
 // Helper functions
 
-func validateCreatePostRequest(req CreatePostRequest) error {
+// minimumAge is the youngest a poster may be. Under-18s are turned away at
+// creation; content suggesting a minor is flagged for review by screenPost.
+const minimumAge = 18
+
+func normalizeCreatePostRequest(req *CreatePostRequest) {
 	req.EventName = strings.TrimSpace(req.EventName)
 	req.Content = strings.TrimSpace(req.Content)
+	req.Gender = strings.TrimSpace(req.Gender)
 	req.Location = strings.TrimSpace(req.Location)
+	req.ContentWarning = strings.TrimSpace(req.ContentWarning)
+}
 
+func validateCreatePostRequest(req CreatePostRequest, settings EventSettings) error {
 	if req.EventName == "" {
-		return &ValidationError{"event_name is required"}
+		return &ValidationError{Message: "event_name is required", Rule: "event_name.required"}
 	}
 	if len(req.EventName) > 200 {
-		return &ValidationError{"event_name must be 200 characters or less"}
+		return &ValidationError{Message: "event_name must be 200 characters or less", Rule: "event_name.max_length"}
 	}
 
 	if req.Content == "" {
-		return &ValidationError{"content is required"}
+		return &ValidationError{Message: "content is required", Rule: "content.required"}
 	}
 	if len(req.Content) > 5000 {
-		return &ValidationError{"content must be 5000 characters or less"}
+		return &ValidationError{Message: "content must be 5000 characters or less", Rule: "content.max_length"}
 	}
 
-	// Age must be between 1 and 120
-	if req.Age < 1 || req.Age > 120 {
-		return &ValidationError{"age must be between 1 and 120"}
+	// Fields the event doesn't collect are neither required nor validated,
+	// since they are discarded
+	if settings.collectsAge() && (req.Age < minimumAge || req.Age > 120) {
+		return &ValidationError{Message: fmt.Sprintf("age must be between %d and 120", minimumAge), Rule: "age.range"}
 	}
 
-	if req.Location == "" {
-		return &ValidationError{"location is required"}
-	}
-	if len(req.Location) > 200 {
-		return &ValidationError{"location must be 200 characters or less"}
+	if settings.collectsLocation() {
+		if req.Location == "" {
+			return &ValidationError{Message: "location is required", Rule: "location.required"}
+		}
+		if len(req.Location) > 200 {
+			return &ValidationError{Message: "location must be 200 characters or less", Rule: "location.max_length"}
+		}
 	}
 
 	// Gender is optional, but validate if provided
-	if req.Gender != "" && len(req.Gender) > 20 {
-		return &ValidationError{"gender must be 20 characters or less"}
+	if settings.collectsGender() && len(req.Gender) > 20 {
+		return &ValidationError{Message: "gender must be 20 characters or less", Rule: "gender.max_length"}
+	}
+
+	if len(req.ContentWarning) > maxContentWarningLength {
+		return &ValidationError{Message: fmt.Sprintf("content_warning must be %d characters or less", maxContentWarningLength), Rule: "content_warning.max_length"}
 	}
 
 	return nil
 }
 
+func (h *Handler) checkPostReferences(ctx context.Context, req CreatePostRequest) error {
+	if err := h.checkPostTemplate(ctx, req); err != nil {
+		return err
+	}
+	return h.checkPostSession(ctx, req)
+}
+
+// parsePagination reads limit (1-100, default 50) and offset (default 0)
+// query parameters, ignoring invalid values.
+func parsePagination(r *http.Request) (limit, offset int) {
+	limit = defaultPageSize
+	if parsed, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && parsed > 0 && parsed <= 100 {
+		limit = parsed
+	}
+	if parsed, err := strconv.Atoi(r.URL.Query().Get("offset")); err == nil && parsed >= 0 {
+		offset = parsed
+	}
+	return limit, offset
+}
+
 func computeIPHash(r *http.Request) string {
 	ip := r.RemoteAddr
 	if colonIndex := strings.LastIndex(ip, ":"); colonIndex != -1 {
@@ -165,6 +631,9 @@ func respondWithError(w http.ResponseWriter, status int, message string) {
 
 type ValidationError struct {
 	Message string
+	// Rule names the rule a post submission broke, for rejection stats;
+	// other validation leaves it empty
+	Rule string
 }
 
 func (e *ValidationError) Error() string {
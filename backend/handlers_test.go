@@ -0,0 +1,989 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	// Handlers log every failure; keep test output readable
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+func newTestHandler(store *fakeStore, cfg HandlerConfig) *Handler {
+	return NewHandler(store, nil, cfg)
+}
+
+// assertError checks a response is the API's JSON error shape with the
+// given status and message.
+func assertError(t *testing.T, rec *httptest.ResponseRecorder, status int, message string) {
+	t.Helper()
+	if rec.Code != status {
+		t.Fatalf("status = %d, want %d (body %s)", rec.Code, status, rec.Body)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", ct)
+	}
+	var body map[string]string
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("error body is not JSON: %v (%s)", err, rec.Body)
+	}
+	if len(body) != 1 || body["error"] != message {
+		t.Errorf("body = %v, want {\"error\": %q}", body, message)
+	}
+}
+
+const validPost = `{"event_name": "Glastonbury", "content": "Blue hat by the Pyramid stage", "age": 25, "location": "Bristol"}`
+
+func TestCreatePost(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		setup    func(*fakeStore)
+		cfg      HandlerConfig
+		status   int
+		errorMsg string
+		// rule is the rejection counted in the validation stats
+		rule string
+	}{
+		{name: "invalid JSON", body: `{`, status: 400, errorMsg: "Invalid request body"},
+		{name: "missing event", body: `{"content": "hi", "age": 25, "location": "x"}`, status: 400, errorMsg: "event_name is required", rule: "event_name.required"},
+		{name: "event too long", body: `{"event_name": "` + strings.Repeat("e", 201) + `", "content": "hi", "age": 25, "location": "x"}`, status: 400, errorMsg: "event_name must be 200 characters or less", rule: "event_name.max_length"},
+		{name: "blank content", body: `{"event_name": "Glastonbury", "content": "   ", "age": 25, "location": "x"}`, status: 400, errorMsg: "content is required", rule: "content.required"},
+		{name: "content too long", body: `{"event_name": "Glastonbury", "content": "` + strings.Repeat("c", 5001) + `", "age": 25, "location": "x"}`, status: 400, errorMsg: "content must be 5000 characters or less", rule: "content.max_length"},
+		{name: "under age", body: `{"event_name": "Glastonbury", "content": "hi", "age": 17, "location": "x"}`, status: 400, errorMsg: "age must be between 18 and 120", rule: "age.range"},
+		{name: "missing location", body: `{"event_name": "Glastonbury", "content": "hi", "age": 25}`, status: 400, errorMsg: "location is required", rule: "location.required"},
+		{name: "gender too long", body: `{"event_name": "Glastonbury", "content": "hi", "age": 25, "location": "x", "gender": "` + strings.Repeat("g", 21) + `"}`, status: 400, errorMsg: "gender must be 20 characters or less", rule: "gender.max_length"},
+		{name: "content warning too long", body: `{"event_name": "Glastonbury", "content": "hi", "age": 25, "location": "x", "content_warning": "` + strings.Repeat("w", 101) + `"}`, status: 400, errorMsg: "content_warning must be 100 characters or less", rule: "content_warning.max_length"},
+		{
+			name:   "all-ages event skips age and location",
+			body:   `{"event_name": "Family Day", "content": "hi", "age": 9}`,
+			setup:  func(s *fakeStore) { s.settings["Family Day"] = EventSettings{AllAges: true} },
+			status: 201,
+		},
+		{
+			name:     "terms required",
+			body:     validPost,
+			cfg:      HandlerConfig{Terms: &Terms{Version: "2"}},
+			status:   400,
+			errorMsg: "terms_version is required",
+			rule:     "terms_version.required",
+		},
+		{
+			name:     "stale terms",
+			body:     `{"event_name": "Glastonbury", "content": "hi", "age": 25, "location": "x", "terms_version": "1"}`,
+			cfg:      HandlerConfig{Terms: &Terms{Version: "2"}},
+			status:   409,
+			errorMsg: "The terms of service have changed. Please review and accept version 2.",
+			rule:     "terms_version.outdated",
+		},
+		{
+			name:     "banned author",
+			body:     validPost,
+			setup:    func(s *fakeStore) { s.banned = true },
+			status:   403,
+			errorMsg: "You can't post here.",
+		},
+		{
+			name:     "archived event",
+			body:     validPost,
+			setup:    func(s *fakeStore) { s.archived = true },
+			status:   400,
+			errorMsg: "This board has been archived and is read-only",
+			rule:     "event.archived",
+		},
+		{
+			name:     "event lookup fails",
+			body:     validPost,
+			setup:    func(s *fakeStore) { s.fail["LookupEvent"] = true },
+			status:   500,
+			errorMsg: "Failed to create post",
+		},
+		{
+			name:     "settings lookup fails",
+			body:     validPost,
+			setup:    func(s *fakeStore) { s.fail["GetEventSettings"] = true },
+			status:   500,
+			errorMsg: "Failed to create post",
+		},
+		{
+			name:     "insert fails",
+			body:     validPost,
+			setup:    func(s *fakeStore) { s.fail["CreatePost"] = true },
+			status:   500,
+			errorMsg: "Failed to create post",
+		},
+		{
+			name:     "screening fails",
+			body:     `{"event_name": "Glastonbury", "content": "im 15 years old lol", "age": 25, "location": "x"}`,
+			setup:    func(s *fakeStore) { s.fail["EnqueueModeration"] = true },
+			status:   500,
+			errorMsg: "Failed to create post",
+		},
+		{name: "created", body: validPost, status: 201},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newFakeStore()
+			if tt.setup != nil {
+				tt.setup(store)
+			}
+			stats := NewValidationStats(nil)
+			tt.cfg.ValidationStats = stats
+			h := newTestHandler(store, tt.cfg)
+
+			rec := httptest.NewRecorder()
+			h.CreatePost(rec, httptest.NewRequest(http.MethodPost, "/api/posts", strings.NewReader(tt.body)))
+
+			var rules, want []string
+			for key := range stats.counts {
+				rules = append(rules, key.rule)
+			}
+			if tt.rule != "" {
+				want = []string{tt.rule}
+			}
+			if !reflect.DeepEqual(rules, want) {
+				t.Errorf("rejections counted for %v, want %v", rules, want)
+			}
+
+			if tt.errorMsg != "" {
+				assertError(t, rec, tt.status, tt.errorMsg)
+				if store.created != nil {
+					t.Error("post was created despite the error")
+				}
+				return
+			}
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d (body %s)", rec.Code, tt.status, rec.Body)
+			}
+		})
+	}
+}
+
+func TestCreatePostResponse(t *testing.T) {
+	store := newFakeStore()
+	store.lookups["glasto"] = &eventLookup{Name: "Glastonbury", Slug: "glasto"}
+	store.settings["Glastonbury"] = EventSettings{CollectAge: true, CollectLocation: true}
+	h := newTestHandler(store, HandlerConfig{})
+
+	body := `{"event_name": " glasto ", "content": " Blue hat ", "age": 25, "location": "Bristol", "gender": "F"}`
+	rec := httptest.NewRecorder()
+	h.CreatePost(rec, httptest.NewRequest(http.MethodPost, "/api/posts", strings.NewReader(body)))
+
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status = %d, want 201 (body %s)", rec.Code, rec.Body)
+	}
+
+	var post Post
+	if err := json.Unmarshal(rec.Body.Bytes(), &post); err != nil {
+		t.Fatal(err)
+	}
+	if post.EventName != "Glastonbury" {
+		t.Errorf("event_name = %q, want the canonical name", post.EventName)
+	}
+	if post.Content != "Blue hat" {
+		t.Errorf("content = %q, want it trimmed", post.Content)
+	}
+	if post.Gender != "" || store.created.Gender != "" {
+		t.Errorf("gender was kept for an event that doesn't collect it")
+	}
+	if post.EditToken == "" {
+		t.Error("edit_token missing from the create response")
+	}
+}
+
+func TestCreatePostCaps(t *testing.T) {
+	store := newFakeStore()
+	h := newTestHandler(store, HandlerConfig{Caps: NewPostingCaps(3, 2)})
+
+	post := func(event string) *httptest.ResponseRecorder {
+		body := `{"event_name": "` + event + `", "content": "hi", "age": 25, "location": "x"}`
+		rec := httptest.NewRecorder()
+		h.CreatePost(rec, httptest.NewRequest(http.MethodPost, "/api/posts", strings.NewReader(body)))
+		return rec
+	}
+
+	steps := []struct {
+		event  string
+		status int
+		code   string
+	}{
+		{"Glastonbury", 201, ""},
+		{"Glastonbury", 201, ""},
+		{"Glastonbury", 429, capCodeEvent},
+		{"Reading", 201, ""},
+		{"Leeds", 429, capCodeGlobal},
+	}
+	for i, step := range steps {
+		rec := post(step.event)
+		if rec.Code != step.status {
+			t.Fatalf("post %d to %s: status = %d, want %d (body %s)", i, step.event, rec.Code, step.status, rec.Body)
+		}
+		if step.code == "" {
+			continue
+		}
+		var body RateLimitedError
+		json.Unmarshal(rec.Body.Bytes(), &body)
+		if body.Code != step.code || body.Error == "" {
+			t.Errorf("post %d to %s: body = %+v, want code %q", i, step.event, body, step.code)
+		}
+		if retryAfter := rec.Header().Get("Retry-After"); retryAfter == "" || retryAfter != strconv.Itoa(body.RetryAfterSeconds) {
+			t.Errorf("post %d to %s: Retry-After = %q, retry_after_seconds = %d", i, step.event, retryAfter, body.RetryAfterSeconds)
+		}
+	}
+	if len(store.posts) != 3 {
+		t.Errorf("%d posts stored, want 3", len(store.posts))
+	}
+}
+
+func TestGetPosts(t *testing.T) {
+	tests := []struct {
+		name       string
+		query      string
+		setup      func(*fakeStore)
+		status     int
+		errorMsg   string
+		wantLimit  int
+		wantOffset int
+		wantBody   string
+	}{
+		{name: "empty result is an array", status: 200, wantLimit: 50, wantBody: "[]"},
+		{name: "limit", query: "limit=10&offset=20", status: 200, wantLimit: 10, wantOffset: 20},
+		{name: "limit above max", query: "limit=500", status: 200, wantLimit: 50},
+		{name: "zero limit", query: "limit=0", status: 200, wantLimit: 50},
+		{name: "non-numeric limit", query: "limit=ten", status: 200, wantLimit: 50},
+		{name: "negative offset", query: "offset=-5", status: 200, wantLimit: 50},
+		{name: "invalid session", query: "session=abc", status: 400, errorMsg: "Invalid session ID"},
+		{name: "zero session", query: "session=0", status: 400, errorMsg: "Invalid session ID"},
+		{name: "field filter without event", query: "field.stage=Main", status: 400, errorMsg: "field filters require an event"},
+		{name: "unknown sort", query: "sort=oldest", status: 400, errorMsg: "sort must be one of newest, shuffle"},
+		{name: "shuffle without seed", query: "sort=shuffle", status: 400, errorMsg: "shuffle needs a seed of at most 64 characters"},
+		{name: "shuffle", query: "sort=shuffle&seed=abc", status: 200, wantLimit: 50},
+		{name: "unknown field", query: "fields=id,edit_token", status: 400, errorMsg: `unknown field "edit_token"`},
+		{
+			name:  "fields",
+			query: "fields=content,%20id,content,age",
+			setup: func(s *fakeStore) {
+				s.posts = []Post{{ID: 1, PublicID: "abc", EventName: "Glastonbury", Content: "hi"}}
+			},
+			status:    200,
+			wantLimit: 50,
+			wantBody:  `[{"age":null,"content":"hi","id":1}]`,
+		},
+		{
+			name:  "attachments not loaded unless selected",
+			query: "fields=id",
+			setup: func(s *fakeStore) {
+				s.posts = []Post{{ID: 1, EventName: "Glastonbury"}}
+				s.fail["GetAttachmentsForPosts"] = true
+			},
+			status:    200,
+			wantLimit: 50,
+			wantBody:  `[{"id":1}]`,
+		},
+		{
+			name:     "query fails",
+			setup:    func(s *fakeStore) { s.fail["GetPosts"] = true },
+			status:   500,
+			errorMsg: "Failed to retrieve posts",
+		},
+		{
+			name: "settings fail",
+			setup: func(s *fakeStore) {
+				s.posts = []Post{{ID: 1, EventName: "Glastonbury"}}
+				s.fail["GetEventSettingsByName"] = true
+			},
+			status:   500,
+			errorMsg: "Failed to retrieve posts",
+		},
+		{
+			name: "attachments fail",
+			setup: func(s *fakeStore) {
+				s.posts = []Post{{ID: 1, EventName: "Glastonbury"}}
+				s.fail["GetAttachmentsForPosts"] = true
+			},
+			status:   500,
+			errorMsg: "Failed to retrieve posts",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newFakeStore()
+			if tt.setup != nil {
+				tt.setup(store)
+			}
+			h := newTestHandler(store, HandlerConfig{})
+
+			rec := httptest.NewRecorder()
+			h.GetPosts(rec, httptest.NewRequest(http.MethodGet, "/api/posts?"+tt.query, nil))
+
+			if tt.errorMsg != "" {
+				assertError(t, rec, tt.status, tt.errorMsg)
+				return
+			}
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d (body %s)", rec.Code, tt.status, rec.Body)
+			}
+			if store.lastLimit != tt.wantLimit || store.lastOffset != tt.wantOffset {
+				t.Errorf("limit, offset = %d, %d, want %d, %d", store.lastLimit, store.lastOffset, tt.wantLimit, tt.wantOffset)
+			}
+			if tt.wantBody != "" && strings.TrimSpace(rec.Body.String()) != tt.wantBody {
+				t.Errorf("body = %s, want %s", rec.Body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestGetPostsFilter(t *testing.T) {
+	store := newFakeStore()
+	store.lookups["old-name"] = &eventLookup{Name: "New Name", Slug: "new-name", Moved: true}
+	store.posts = []Post{{ID: 1, EventName: "New Name", Content: "hi", Gender: "F"}}
+	store.settings["New Name"] = EventSettings{CollectAge: true}
+	h := newTestHandler(store, HandlerConfig{})
+
+	rec := httptest.NewRecorder()
+	h.GetPosts(rec, httptest.NewRequest(http.MethodGet, "/api/posts?event=old-name&session=3&include_sensitive=true", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200 (body %s)", rec.Code, rec.Body)
+	}
+	want := PostFilter{Event: "New Name", Session: 3, IncludeSensitive: true}
+	if store.lastFilter.Event != want.Event || store.lastFilter.Session != want.Session || store.lastFilter.IncludeSensitive != want.IncludeSensitive {
+		t.Errorf("filter = %+v, want %+v", store.lastFilter, want)
+	}
+
+	var posts []Post
+	if err := json.Unmarshal(rec.Body.Bytes(), &posts); err != nil {
+		t.Fatal(err)
+	}
+	if len(posts) != 1 || posts[0].Gender != "" {
+		t.Errorf("posts = %+v, want gender redacted", posts)
+	}
+}
+
+func TestGetPostsSnapshot(t *testing.T) {
+	store := newFakeStore()
+	store.posts = []Post{{ID: 7, EventName: "Glastonbury"}, {ID: 9, EventName: "Glastonbury"}, {ID: 8, EventName: "Glastonbury"}}
+	h := newTestHandler(store, HandlerConfig{})
+
+	rec := httptest.NewRecorder()
+	h.GetPosts(rec, httptest.NewRequest(http.MethodGet, "/api/posts?limit=3", nil))
+	token := rec.Header().Get(snapshotHeader)
+	if id, err := parseSnapshotToken(token); err != nil || id != 9 {
+		t.Fatalf("first page snapshot %q = %d, %v; want the newest post, 9", token, id, err)
+	}
+
+	rec = httptest.NewRecorder()
+	h.GetPosts(rec, httptest.NewRequest(http.MethodGet, "/api/posts?limit=3&offset=3&snapshot="+token, nil))
+	if rec.Code != http.StatusOK || store.lastFilter.MaxID != 9 {
+		t.Errorf("next page: status %d, filter %+v; want MaxID 9", rec.Code, store.lastFilter)
+	}
+	if got := rec.Header().Get(snapshotHeader); got != token {
+		t.Errorf("next page snapshot = %q, want %q", got, token)
+	}
+
+	// Later pages without a snapshot don't get one, as they can't tell
+	// what the first page held
+	rec = httptest.NewRecorder()
+	h.GetPosts(rec, httptest.NewRequest(http.MethodGet, "/api/posts?offset=3", nil))
+	if got := rec.Header().Get(snapshotHeader); got != "" {
+		t.Errorf("unpinned later page snapshot = %q, want none", got)
+	}
+
+	for _, bad := range []string{"9", "!!", snapshotToken(0), "czE6bm9wZQ"} {
+		rec = httptest.NewRecorder()
+		h.GetPosts(rec, httptest.NewRequest(http.MethodGet, "/api/posts?snapshot="+bad, nil))
+		assertError(t, rec, http.StatusBadRequest, "Invalid snapshot token")
+	}
+}
+
+func TestGetPostsByID(t *testing.T) {
+	hidden := time.Now()
+	var ids []string
+	for i := 0; i <= maxPostsByID; i++ {
+		ids = append(ids, fmt.Sprintf("a%09d", i))
+	}
+	manyIDs := strings.Join(ids, ",")
+	tests := []struct {
+		name     string
+		query    string
+		setup    func(*fakeStore)
+		status   int
+		errorMsg string
+		wantBody string
+	}{
+		{
+			name:  "request order and missing",
+			query: "ids=bbbbbbbbbb,zzzzzzzzzz,aaaaaaaaaa,bbbbbbbbbb,cccccccccc&fields=public_id,gender",
+			setup: func(s *fakeStore) {
+				s.posts = []Post{
+					{ID: 1, PublicID: "aaaaaaaaaa", EventName: "Glastonbury", Gender: "F"},
+					{ID: 2, PublicID: "bbbbbbbbbb", EventName: "Glastonbury"},
+					{ID: 3, PublicID: "cccccccccc", EventName: "Glastonbury", HiddenAt: &hidden},
+				}
+				s.settings["Glastonbury"] = EventSettings{CollectAge: true}
+			},
+			status:   200,
+			wantBody: `{"posts":[{"gender":"","public_id":"bbbbbbbbbb"},{"gender":"","public_id":"aaaaaaaaaa"}],"missing":["zzzzzzzzzz","cccccccccc"]}`,
+		},
+		{name: "none", query: "ids=", status: 200, wantBody: `{"posts":[],"missing":[]}`},
+		{name: "integer ID", query: "ids=aaaaaaaaaa,12", status: 400, errorMsg: `Invalid post ID "12"`},
+		{name: "too many", query: "ids=" + manyIDs, status: 400, errorMsg: "ids must list at most 100 posts"},
+		{
+			name:     "repeats count once",
+			query:    "ids=" + strings.Repeat("aaaaaaaaaa,", 50) + strings.Repeat("bbbbbbbbbb,", 50) + "cccccccccc",
+			status:   200,
+			wantBody: `{"posts":[],"missing":["aaaaaaaaaa","bbbbbbbbbb","cccccccccc"]}`,
+		},
+		{
+			name:     "query fails",
+			query:    "ids=aaaaaaaaaa",
+			setup:    func(s *fakeStore) { s.fail["GetPostsByPublicIDs"] = true },
+			status:   500,
+			errorMsg: "Failed to retrieve posts",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newFakeStore()
+			if tt.setup != nil {
+				tt.setup(store)
+			}
+			h := newTestHandler(store, HandlerConfig{})
+
+			rec := httptest.NewRecorder()
+			h.GetPosts(rec, httptest.NewRequest(http.MethodGet, "/api/posts?"+tt.query, nil))
+
+			if tt.errorMsg != "" {
+				assertError(t, rec, tt.status, tt.errorMsg)
+				return
+			}
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d (body %s)", rec.Code, tt.status, rec.Body)
+			}
+			if got := strings.TrimSpace(rec.Body.String()); got != tt.wantBody {
+				t.Errorf("body = %s, want %s", got, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestGetEvents(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    string
+		setup    func(*fakeStore)
+		status   int
+		errorMsg string
+		wantSort string
+		wantBody string
+	}{
+		{name: "default sort", status: 200, wantSort: "recent", wantBody: "[]"},
+		{name: "sort", query: "sort=most_posts", status: 200, wantSort: "most_posts"},
+		{name: "unknown sort", query: "sort=random", status: 400, errorMsg: "sort must be one of recent, alphabetical, most_posts"},
+		{name: "unknown category", query: "category=rave", status: 400, errorMsg: "category must be one of " + strings.Join(eventCategories, ", ")},
+		{name: "query too long", query: "q=" + strings.Repeat("q", 201), status: 400, errorMsg: "q must be 200 characters or less"},
+		{
+			name:     "query fails",
+			setup:    func(s *fakeStore) { s.fail["GetEvents"] = true },
+			status:   500,
+			errorMsg: "Failed to retrieve events",
+		},
+		{
+			name:     "events",
+			setup:    func(s *fakeStore) { s.events = []string{"Glastonbury", "Coachella"} },
+			status:   200,
+			wantSort: "recent",
+			wantBody: `["Glastonbury","Coachella"]`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newFakeStore()
+			if tt.setup != nil {
+				tt.setup(store)
+			}
+			h := newTestHandler(store, HandlerConfig{})
+
+			rec := httptest.NewRecorder()
+			h.GetEvents(rec, httptest.NewRequest(http.MethodGet, "/api/events?"+tt.query, nil))
+
+			if tt.errorMsg != "" {
+				assertError(t, rec, tt.status, tt.errorMsg)
+				return
+			}
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d (body %s)", rec.Code, tt.status, rec.Body)
+			}
+			if store.lastOptions.Sort != tt.wantSort {
+				t.Errorf("sort = %q, want %q", store.lastOptions.Sort, tt.wantSort)
+			}
+			if tt.wantBody != "" && strings.TrimSpace(rec.Body.String()) != tt.wantBody {
+				t.Errorf("body = %s, want %s", rec.Body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestGetEvent(t *testing.T) {
+	tests := []struct {
+		name     string
+		setup    func(*fakeStore)
+		status   int
+		errorMsg string
+	}{
+		{name: "not found", status: 404, errorMsg: "Event not found"},
+		{
+			name:     "query fails",
+			setup:    func(s *fakeStore) { s.fail["GetEvent"] = true },
+			status:   500,
+			errorMsg: "Failed to retrieve event",
+		},
+		{
+			name:   "found",
+			setup:  func(s *fakeStore) { s.event = &Event{ID: 1, Name: "Glastonbury", Slug: "glastonbury"} },
+			status: 200,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newFakeStore()
+			if tt.setup != nil {
+				tt.setup(store)
+			}
+			h := newTestHandler(store, HandlerConfig{Retention: RetentionPolicy{
+				Classes:      map[string]int{"festival": 90},
+				DefaultClass: "festival",
+			}})
+
+			req := httptest.NewRequest(http.MethodGet, "/api/events/glastonbury", nil)
+			req.SetPathValue("event", "Glastonbury")
+			rec := httptest.NewRecorder()
+			h.GetEvent(rec, req)
+
+			if tt.errorMsg != "" {
+				assertError(t, rec, tt.status, tt.errorMsg)
+				return
+			}
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d (body %s)", rec.Code, tt.status, rec.Body)
+			}
+			var event Event
+			if err := json.Unmarshal(rec.Body.Bytes(), &event); err != nil {
+				t.Fatal(err)
+			}
+			if event.RetentionDays != 90 {
+				t.Errorf("retention_days = %d, want the default class's 90", event.RetentionDays)
+			}
+		})
+	}
+}
+
+func TestCreatePostQueued(t *testing.T) {
+	store := newFakeStore()
+	store.saturated = true
+	queue := NewWriteQueue(2)
+	h := newTestHandler(store, HandlerConfig{WriteQueue: queue})
+
+	post := func(content string) *httptest.ResponseRecorder {
+		body := `{"event_name": "Glastonbury", "content": "` + content + `", "age": 25, "location": "x"}`
+		rec := httptest.NewRecorder()
+		h.CreatePost(rec, httptest.NewRequest(http.MethodPost, "/api/posts", strings.NewReader(body)))
+		return rec
+	}
+	status := func(statusURL string) PostStatus {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, statusURL, nil)
+		req.SetPathValue("token", strings.TrimPrefix(statusURL, "/api/post-status/"))
+		h.GetPostStatus(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET %s: status = %d, want 200", statusURL, rec.Code)
+		}
+		if rec.Header().Get("Cache-Control") != "no-store" {
+			t.Errorf("GET %s: Cache-Control = %q, want no-store", statusURL, rec.Header().Get("Cache-Control"))
+		}
+		var s PostStatus
+		json.Unmarshal(rec.Body.Bytes(), &s)
+		return s
+	}
+
+	var queued []QueuedPostResponse
+	for _, content := range []string{"hi", ""} {
+		rec := post(content)
+		if rec.Code != http.StatusAccepted {
+			t.Fatalf("post %q: status = %d, want 202 (body %s)", content, rec.Code, rec.Body)
+		}
+		var resp QueuedPostResponse
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		if resp.EditToken == "" || !strings.HasPrefix(resp.StatusURL, "/api/post-status/") {
+			t.Fatalf("post %q: response = %+v", content, resp)
+		}
+		if s := status(resp.StatusURL); s.Status != postStatusPending {
+			t.Errorf("post %q: status = %q before processing, want pending", content, s.Status)
+		}
+		queued = append(queued, resp)
+	}
+	if rec := post("one too many"); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("full queue: status = %d, want 503", rec.Code)
+	}
+	if len(store.posts) != 0 {
+		t.Fatalf("%d posts stored while saturated, want 0", len(store.posts))
+	}
+
+	store.saturated = false
+	for range queued {
+		h.processQueuedPost(context.Background(), <-queue.posts)
+	}
+
+	if s := status(queued[0].StatusURL); s.Status != postStatusPublished || s.PostID != 1 {
+		t.Errorf("valid post: status = %+v, want published as post 1", s)
+	}
+	if s := status(queued[1].StatusURL); s.Status != postStatusRejected || s.Reason == "" || s.Code != invalidPostCode {
+		t.Errorf("empty post: status = %+v, want rejected as invalid with a reason", s)
+	}
+	if len(store.posts) != 1 {
+		t.Errorf("%d posts stored, want 1", len(store.posts))
+	}
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/api/post-status/nope", nil)
+	req.SetPathValue("token", "nope")
+	h.GetPostStatus(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("unknown token: status = %d, want 404", rec.Code)
+	}
+}
+
+func TestQueuedPostDeadLettered(t *testing.T) {
+	store := newFakeStore()
+	store.saturated = true
+	queue := NewWriteQueue(1)
+	queue.retryDelay = time.Millisecond
+	h := newTestHandler(store, HandlerConfig{WriteQueue: queue})
+
+	body := `{"event_name": "Glastonbury", "content": "hi", "age": 25, "location": "x"}`
+	rec := httptest.NewRecorder()
+	h.CreatePost(rec, httptest.NewRequest(http.MethodPost, "/api/posts", strings.NewReader(body)))
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, want 202 (body %s)", rec.Code, rec.Body)
+	}
+	var resp QueuedPostResponse
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	token := strings.TrimPrefix(resp.StatusURL, "/api/post-status/")
+
+	store.saturated = false
+	store.fail["CreatePost"] = true
+	h.processQueuedPost(context.Background(), <-queue.posts)
+
+	if s, _ := queue.status(token); s.Status != postStatusFailed || s.Reason == "" {
+		t.Errorf("status = %+v, want failed with a reason", s)
+	}
+	if len(store.deadLetters) != 1 {
+		t.Fatalf("%d dead letters, want 1", len(store.deadLetters))
+	}
+	letter := store.deadLetters[0]
+	if letter.Kind != deadLetterPost || letter.Attempts != writeQueueMaxAttempts {
+		t.Errorf("dead letter = %+v, want a post after %d attempts", letter, writeQueueMaxAttempts)
+	}
+
+	// Redriving queues it again under the same token
+	store.fail["CreatePost"] = false
+	rec = httptest.NewRecorder()
+	h.RedriveDeadLetters(rec, httptest.NewRequest(http.MethodPost, "/admin/dlq/redrive", strings.NewReader(`{"ids":[1]}`)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("redrive: status = %d, want 200 (body %s)", rec.Code, rec.Body)
+	}
+	h.processQueuedPost(context.Background(), <-queue.posts)
+	if s, _ := queue.status(token); s.Status != postStatusPublished {
+		t.Errorf("status after redrive = %+v, want published", s)
+	}
+}
+
+func TestGetPostContext(t *testing.T) {
+	tests := []struct {
+		name     string
+		id       string
+		query    string
+		setup    func(*fakeStore)
+		status   int
+		siblings int
+	}{
+		{name: "found", id: "1", status: 200, siblings: 2},
+		{name: "window", id: "1", query: "window_hours=168", status: 200, siblings: 2},
+		{name: "window too wide", id: "1", query: "window_hours=169", status: 400},
+		{name: "window zero", id: "1", query: "window_hours=0", status: 400},
+		{name: "bad id", id: "abc", status: 400},
+		{name: "missing", id: "99", status: 404},
+		{name: "flags fail", id: "1", setup: func(s *fakeStore) { s.fail["GetPostFlags"] = true }, status: 500},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newFakeStore()
+			store.posts = []Post{
+				{ID: 1, EventName: "Glastonbury", Content: "I'm 15 and my mom won't let me go to the pyramid stage"},
+				{ID: 2, EventName: "Glastonbury", Content: "hi"},
+				{ID: 3, EventName: "Reading", Content: "hi"},
+			}
+			if tt.setup != nil {
+				tt.setup(store)
+			}
+			h := newTestHandler(store, HandlerConfig{})
+
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/admin/posts/"+tt.id+"/context?"+tt.query, nil)
+			req.SetPathValue("id", tt.id)
+			h.GetPostContext(rec, req)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d (body %s)", rec.Code, tt.status, rec.Body)
+			}
+			if tt.status != 200 {
+				return
+			}
+
+			var pc PostContext
+			if err := json.Unmarshal(rec.Body.Bytes(), &pc); err != nil {
+				t.Fatal(err)
+			}
+			if pc.Post.ID != 1 || len(pc.Siblings) != tt.siblings {
+				t.Errorf("post %d with %d siblings, want post 1 with %d", pc.Post.ID, len(pc.Siblings), tt.siblings)
+			}
+			if len(pc.Heuristics.Flags) != 1 || pc.Heuristics.Flags[0].Reason != "possible_minor" {
+				t.Errorf("heuristic flags = %+v, want possible_minor", pc.Heuristics.Flags)
+			}
+			for _, field := range []string{`"revisions":[]`, `"flags":[]`, `"history":[]`} {
+				if !strings.Contains(rec.Body.String(), field) {
+					t.Errorf("body %s missing %s", rec.Body, field)
+				}
+			}
+		})
+	}
+}
+
+func TestGetPost(t *testing.T) {
+	author := strings.Repeat("a", 16)
+	hidden := time.Now()
+
+	tests := []struct {
+		name   string
+		id     string
+		token  string
+		status int
+		body   map[string]string
+	}{
+		{name: "visible", id: "1", status: 200},
+		{name: "bad id", id: "abc", status: 400},
+		{name: "missing", id: "99", status: 404},
+		{name: "hidden", id: "2", status: 404},
+		{name: "hidden, other device", id: "2", token: strings.Repeat("b", 16), status: 404},
+		{
+			name:   "hidden, author",
+			id:     "2",
+			token:  author,
+			status: 410,
+			body:   map[string]string{"error": "Spam isn't allowed.", "code": postRemovedCode, "reason": "spam"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newFakeStore()
+			store.posts = []Post{{ID: 1, Content: "hi"}, {ID: 2, Content: "Buy now", HiddenAt: &hidden}}
+			store.notice = &RemovalReason{Code: "spam", Message: "Spam isn't allowed."}
+			store.noticeToken = author
+			h := newTestHandler(store, HandlerConfig{})
+
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/api/posts/"+tt.id, nil)
+			req.SetPathValue("id", tt.id)
+			if tt.token != "" {
+				req.Header.Set("X-Device-Token", tt.token)
+			}
+			h.GetPost(rec, req)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d (body %s)", rec.Code, tt.status, rec.Body)
+			}
+			if tt.body != nil {
+				var body map[string]string
+				json.Unmarshal(rec.Body.Bytes(), &body)
+				if !reflect.DeepEqual(body, tt.body) {
+					t.Errorf("body = %v, want %v", body, tt.body)
+				}
+			}
+		})
+	}
+}
+
+// TestGetPostRedacted checks a single post hides the fields its event
+// doesn't display, as the feed does.
+func TestGetPostRedacted(t *testing.T) {
+	age := 25
+	store := newFakeStore()
+	store.posts = []Post{{ID: 1, EventName: "Family Day", Content: "hi", Age: &age, Gender: "F", Location: "Bristol"}}
+	store.settings["Family Day"] = EventSettings{AllAges: true}
+	h := newTestHandler(store, HandlerConfig{})
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/api/posts/1", nil)
+	req.SetPathValue("id", "1")
+	h.GetPost(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200 (body %s)", rec.Code, rec.Body)
+	}
+
+	var body map[string]interface{}
+	json.Unmarshal(rec.Body.Bytes(), &body)
+	for _, field := range []string{"age", "gender", "location"} {
+		if v, ok := body[field]; ok && v != "" && v != nil {
+			t.Errorf("%s = %v, want it redacted", field, v)
+		}
+	}
+}
+
+func TestWithPost(t *testing.T) {
+	tests := []struct {
+		name   string
+		id     string
+		legacy bool
+		status int
+		want   string
+	}{
+		{name: "public ID", id: "k3v9q2m7xa", status: 200, want: "1"},
+		{name: "unknown public ID", id: "zzzzzzzzzz", status: 404},
+		{name: "UUID", id: "0192F3A4-5B6C-7D8E-9F01-23456789ABCD", status: 200, want: "1"},
+		{name: "unknown UUID", id: "0192f3a4-5b6c-7d8e-9f01-000000000000", status: 404},
+		{name: "legacy ID", id: "1", legacy: true, status: 200, want: "1"},
+		{name: "legacy IDs off", id: "1", status: 404},
+		{name: "neither", id: "abc", status: 200, want: "abc"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newFakeStore()
+			store.posts = []Post{{ID: 1, PublicID: "k3v9q2m7xa", UUID: "0192f3a4-5b6c-7d8e-9f01-23456789abcd"}}
+			h := newTestHandler(store, HandlerConfig{LegacyPostIDs: tt.legacy})
+
+			var got string
+			next := func(w http.ResponseWriter, r *http.Request) { got = r.PathValue("id") }
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/api/posts/"+tt.id, nil)
+			req.SetPathValue("id", tt.id)
+			h.withPost(next)(rec, req)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d (body %s)", rec.Code, tt.status, rec.Body)
+			}
+			if got != tt.want {
+				t.Errorf("handler saw id %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCreatePostDeviceToken(t *testing.T) {
+	post := func(token string) (*fakeStore, int) {
+		store := newFakeStore()
+		h := newTestHandler(store, HandlerConfig{})
+		body := `{"event_name": "Glastonbury", "content": "hi", "age": 25, "location": "x"}`
+		req := httptest.NewRequest(http.MethodPost, "/api/posts", strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("X-Device-Token", token)
+		}
+		rec := httptest.NewRecorder()
+		h.CreatePost(rec, req)
+		return store, rec.Code
+	}
+
+	token := strings.Repeat("a", 16)
+	store, status := post(token)
+	if status != 201 || store.created.DeviceTokenHash != hashToken(token) {
+		t.Errorf("with token: status %d, stored hash %q", status, store.created.DeviceTokenHash)
+	}
+	store, status = post("")
+	if status != 201 || store.created.DeviceTokenHash != "" {
+		t.Errorf("without token: status %d, stored hash %q", status, store.created.DeviceTokenHash)
+	}
+	if _, status = post("short"); status != 400 {
+		t.Errorf("malformed token: status %d, want 400", status)
+	}
+}
+
+func TestAppealPost(t *testing.T) {
+	author := strings.Repeat("a", 16)
+	store := newFakeStore()
+	store.notice = &RemovalReason{Code: "spam", Message: "Spam isn't allowed."}
+	store.noticeToken = author
+	h := newTestHandler(store, HandlerConfig{})
+
+	appeal := func(id, token, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/api/posts/"+id+"/appeal", strings.NewReader(body))
+		req.SetPathValue("id", id)
+		if token != "" {
+			req.Header.Set("X-Device-Token", token)
+		}
+		h.AppealPost(rec, req)
+		return rec
+	}
+
+	tests := []struct {
+		name   string
+		id     string
+		token  string
+		body   string
+		status int
+	}{
+		{name: "no device token", id: "2", body: `{"message":"It was a joke"}`, status: 400},
+		{name: "bad id", id: "abc", token: author, body: `{"message":"It was a joke"}`, status: 400},
+		{name: "no message", id: "2", token: author, body: `{"message":"  "}`, status: 400},
+		{name: "message too long", id: "2", token: author, body: `{"message":"` + strings.Repeat("x", maxAppealLength+1) + `"}`, status: 400},
+		{name: "other device", id: "2", token: strings.Repeat("b", 16), body: `{"message":"It was a joke"}`, status: 404},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := appeal(tt.id, tt.token, tt.body); rec.Code != tt.status {
+				t.Errorf("status = %d, want %d (body %s)", rec.Code, tt.status, rec.Body)
+			}
+		})
+	}
+
+	rec := appeal("2", author, `{"message":"It was a joke"}`)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("appeal: status = %d, want 202 (body %s)", rec.Code, rec.Body)
+	}
+	var resp AppealResponse
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	if resp.Status != postStatusAppealPending || !strings.HasPrefix(resp.StatusURL, "/api/post-status/") {
+		t.Fatalf("appeal: response = %+v", resp)
+	}
+
+	rec = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, resp.StatusURL, nil)
+	req.SetPathValue("token", strings.TrimPrefix(resp.StatusURL, "/api/post-status/"))
+	h.GetPostStatus(rec, req)
+	var status PostStatus
+	json.Unmarshal(rec.Body.Bytes(), &status)
+	if rec.Code != http.StatusOK || status.Status != postStatusAppealPending || status.PostID != 2 {
+		t.Errorf("appeal status: %d %+v, want pending for post 2", rec.Code, status)
+	}
+
+	if rec := appeal("2", author, `{"message":"Please?"}`); rec.Code != http.StatusConflict {
+		t.Errorf("second appeal: status = %d, want 409", rec.Code)
+	}
+}
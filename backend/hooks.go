@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	hookReportPollInterval = 15 * time.Second
+	hookReportBatchSize    = 100
+	hookDeliveryWorkers    = 2
+)
+
+// PostReport is a post being flagged for moderators, by the screening
+// heuristics, the image hash list or the toxicity scorer.
+type PostReport struct {
+	// PostID is the post's public ID
+	PostID    string    `json:"post_id"`
+	EventName string    `json:"event_name"`
+	Reason    string    `json:"reason"`
+	Source    string    `json:"source"`
+	CreatedAt time.Time `json:"created_at"`
+
+	eventID int64
+}
+
+// PostHook lets a deployment extend what happens to posts, such as adding
+// its own filters or syncing posts to a CRM, without forking. Hooks built
+// in register with RegisterPostHook from an init func; HTTP hooks are
+// configured with POST_HOOK_URLS.
+type PostHook interface {
+	Name() string
+	// OnPostCreate runs before a post is saved, and may change it. A
+	// *ValidationError rejects the post with its message; any other error
+	// is logged and the post let through, as is a hook that times out.
+	OnPostCreate(ctx context.Context, req *CreatePostRequest) error
+	// OnPostPublish runs in the background once a post is published
+	OnPostPublish(ctx context.Context, post Post) error
+	// OnReport runs in the background once a post is flagged
+	OnReport(ctx context.Context, report PostReport) error
+}
+
+var registeredPostHooks []PostHook
+
+// RegisterPostHook adds a hook for every post. It must be called before
+// the server starts, from an init func.
+func RegisterPostHook(hook PostHook) {
+	registeredPostHooks = append(registeredPostHooks, hook)
+}
+
+// PostHooks runs the post hooks. OnPostCreate hooks run in turn with the
+// request, each within timeout. Publishes and reports are delivered to
+// each hook in order on a Dispatcher, with its retries and circuit
+// breaking, so a slow or failing hook holds up only itself.
+type PostHooks struct {
+	db         *DB
+	hooks      []PostHook
+	timeout    time.Duration
+	deliveries *Dispatcher
+}
+
+// NewPostHooks returns nil when there are no hooks to run.
+func NewPostHooks(db *DB, hooks []PostHook, timeout time.Duration) *PostHooks {
+	if len(hooks) == 0 {
+		return nil
+	}
+	return &PostHooks{db: db, hooks: hooks, timeout: timeout, deliveries: NewDispatcher(hookDeliveryWorkers)}
+}
+
+// Create runs the OnPostCreate hooks, returning the *ValidationError of
+// the first to reject the post. It does nothing on a nil PostHooks.
+func (p *PostHooks) Create(ctx context.Context, req *CreatePostRequest) error {
+	if p == nil {
+		return nil
+	}
+	for _, hook := range p.hooks {
+		hookCtx, cancel := context.WithTimeout(ctx, p.timeout)
+		err := hook.OnPostCreate(hookCtx, req)
+		cancel()
+
+		var validation *ValidationError
+		switch {
+		case err == nil:
+		case errors.As(err, &validation):
+			if validation.Rule == "" {
+				validation.Rule = "hook." + hook.Name()
+			}
+			return validation
+		default:
+			log.Printf("Post hook %s failed on create, letting the post through: %v", hook.Name(), err)
+		}
+	}
+	return nil
+}
+
+// Publish queues the OnPostPublish hooks. It does nothing on a nil
+// PostHooks.
+func (p *PostHooks) Publish(post Post) {
+	if p == nil {
+		return
+	}
+	post.EditToken = ""
+	for _, hook := range p.hooks {
+		p.deliver(hook, "publish", func(ctx context.Context) error {
+			return hook.OnPostPublish(ctx, post)
+		})
+	}
+}
+
+func (p *PostHooks) deliver(hook PostHook, what string, run func(ctx context.Context) error) {
+	p.deliveries.Enqueue("hook:"+hook.Name(), func(ctx context.Context) error {
+		ctx, cancel := context.WithTimeout(ctx, p.timeout)
+		defer cancel()
+		return run(ctx)
+	}, func(err error, attempts int) {
+		log.Printf("Post hook %s gave up on %s after %d attempts: %v", hook.Name(), what, attempts, err)
+	})
+}
+
+// Run delivers publishes and reports until ctx is cancelled, checking for
+// new reports every hookReportPollInterval. It does nothing on a nil
+// PostHooks.
+func (p *PostHooks) Run(ctx context.Context) {
+	if p == nil {
+		return
+	}
+	go p.deliveries.Run(ctx)
+
+	ticker := time.NewTicker(hookReportPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.reports(ctx)
+		}
+	}
+}
+
+func (p *PostHooks) reports(ctx context.Context) {
+	reports, err := p.db.TakePostReports(ctx, hookReportBatchSize)
+	if err != nil {
+		log.Printf("Error taking post reports for hooks: %v", err)
+		return
+	}
+	for _, report := range reports {
+		for _, hook := range p.hooks {
+			p.deliver(hook, "report", func(ctx context.Context) error {
+				return hook.OnReport(ctx, report)
+			})
+		}
+	}
+}
+
+// HTTPPostHook POSTs each hook as JSON to a URL: {"hook": "post.create",
+// "post": {...}}, with "post.publish" and "report" likewise. A post.create
+// response of {"allow": false, "message": "..."} rejects the post; other
+// hooks' responses are ignored. With a secret, X-Hook-Signature is the hex
+// HMAC-SHA256 of the body keyed with it.
+type HTTPPostHook struct {
+	endpoint string
+	secret   string
+	client   *http.Client
+}
+
+func NewHTTPPostHook(endpoint, secret string) (*HTTPPostHook, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("post hook URL %q must be an http or https URL", endpoint)
+	}
+	// Each call has a deadline from its context
+	return &HTTPPostHook{endpoint: endpoint, secret: secret, client: &http.Client{}}, nil
+}
+
+func (h *HTTPPostHook) Name() string { return h.endpoint }
+
+// hookPostRequest is the post sent to a post.create hook. The codes that
+// admit it are secrets of the event, so they are left out: these empty
+// fields hide the request's.
+type hookPostRequest struct {
+	CreatePostRequest
+	InviteCode  string `json:"invite_code,omitempty"`
+	CheckinCode string `json:"checkin_code,omitempty"`
+}
+
+type hookCreateResponse struct {
+	Allow   *bool  `json:"allow"`
+	Message string `json:"message"`
+}
+
+func (h *HTTPPostHook) OnPostCreate(ctx context.Context, req *CreatePostRequest) error {
+	var out hookCreateResponse
+	post := hookPostRequest{CreatePostRequest: *req}
+	if err := h.send(ctx, map[string]interface{}{"hook": "post.create", "post": post}, &out); err != nil {
+		return err
+	}
+	if out.Allow == nil || *out.Allow {
+		return nil
+	}
+	if out.Message == "" {
+		out.Message = "This post can't be published."
+	}
+	return &ValidationError{Message: out.Message}
+}
+
+func (h *HTTPPostHook) OnPostPublish(ctx context.Context, post Post) error {
+	return h.send(ctx, map[string]interface{}{"hook": "post.publish", "post": post}, nil)
+}
+
+func (h *HTTPPostHook) OnReport(ctx context.Context, report PostReport) error {
+	return h.send(ctx, map[string]interface{}{"hook": "report", "report": report}, nil)
+}
+
+// send POSTs body, decoding the response into out unless it is nil.
+func (h *HTTPPostHook) send(ctx context.Context, body, out interface{}) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("failed to build request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if h.secret != "" {
+		mac := hmac.New(sha256.New, []byte(h.secret))
+		mac.Write(payload)
+		req.Header.Set("X-Hook-Signature", hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("hook returned status %d: %s", resp.StatusCode, msg)
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+// TakePostReports returns up to limit posts flagged since the last call,
+// oldest first, moving the cursor past them. The cursor row is locked
+// while they are read, so instances never take the same reports; if
+// another instance holds it, there are none to take.
+func (db *DB) TakePostReports(ctx context.Context, limit int) ([]PostReport, error) {
+	tx, err := db.begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin taking reports: %w", err)
+	}
+	defer tx.Rollback()
+
+	var last int64
+	err = tx.QueryRowContext(ctx, `
+		SELECT last_event_id FROM hook_cursors WHERE name = 'reports' FOR UPDATE SKIP LOCKED
+	`).Scan(&last)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read report cursor: %w", err)
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT e.id, p.public_id, p.event_name, COALESCE(e.data->>'reason', ''), COALESCE(e.data->>'source', ''), e.created_at
+		FROM post_events e
+		JOIN posts p ON p.id = e.post_id
+		WHERE e.id > $1 AND e.type = 'reported'
+		ORDER BY e.id
+		LIMIT $2
+	`, last, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query reports: %w", err)
+	}
+	defer rows.Close()
+
+	var reports []PostReport
+	for rows.Next() {
+		var r PostReport
+		if err := rows.Scan(&r.eventID, &r.PostID, &r.EventName, &r.Reason, &r.Source, &r.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan report: %w", err)
+		}
+		reports = append(reports, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating reports: %w", err)
+	}
+	if len(reports) == 0 {
+		return nil, nil
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE hook_cursors SET last_event_id = $1 WHERE name = 'reports'
+	`, reports[len(reports)-1].eventID); err != nil {
+		return nil, fmt.Errorf("failed to move report cursor: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit report cursor: %w", err)
+	}
+	return reports, nil
+}
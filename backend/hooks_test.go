@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testHook is a PostHook whose OnPostCreate is create.
+type testHook struct {
+	name   string
+	create func(ctx context.Context, req *CreatePostRequest) error
+}
+
+func (h testHook) Name() string { return h.name }
+
+func (h testHook) OnPostCreate(ctx context.Context, req *CreatePostRequest) error {
+	return h.create(ctx, req)
+}
+
+func (h testHook) OnPostPublish(ctx context.Context, post Post) error { return nil }
+
+func (h testHook) OnReport(ctx context.Context, report PostReport) error { return nil }
+
+func TestPostHooksCreate(t *testing.T) {
+	hooks := NewPostHooks(nil, []PostHook{
+		testHook{name: "broken", create: func(ctx context.Context, req *CreatePostRequest) error {
+			return errors.New("connection refused")
+		}},
+		testHook{name: "slow", create: func(ctx context.Context, req *CreatePostRequest) error {
+			<-ctx.Done()
+			return ctx.Err()
+		}},
+		testHook{name: "rewrite", create: func(ctx context.Context, req *CreatePostRequest) error {
+			req.Content = strings.ReplaceAll(req.Content, "darn", "d**n")
+			return nil
+		}},
+		testHook{name: "filter", create: func(ctx context.Context, req *CreatePostRequest) error {
+			if strings.Contains(req.Content, "spam") {
+				return &ValidationError{Message: "No spam, please"}
+			}
+			return nil
+		}},
+	}, 50*time.Millisecond)
+
+	req := CreatePostRequest{Content: "darn, lost my hat"}
+	if err := hooks.Create(context.Background(), &req); err != nil {
+		t.Fatalf("failing and slow hooks rejected the post: %v", err)
+	}
+	if req.Content != "d**n, lost my hat" {
+		t.Errorf("content = %q, want the rewrite hook's change", req.Content)
+	}
+
+	err := hooks.Create(context.Background(), &CreatePostRequest{Content: "buy spam"})
+	var validation *ValidationError
+	if !errors.As(err, &validation) || validation.Message != "No spam, please" || validation.Rule != "hook.filter" {
+		t.Errorf("err = %#v, want the filter hook's rejection", err)
+	}
+
+	if NewPostHooks(nil, nil, time.Second) != nil {
+		t.Error("NewPostHooks without hooks isn't nil")
+	}
+	var none *PostHooks
+	if err := none.Create(context.Background(), &req); err != nil {
+		t.Errorf("nil PostHooks rejected the post: %v", err)
+	}
+	none.Publish(Post{})
+}
+
+func TestCreatePostHookRejects(t *testing.T) {
+	hooks := NewPostHooks(nil, []PostHook{testHook{name: "filter", create: func(ctx context.Context, req *CreatePostRequest) error {
+		return &ValidationError{Message: "Posts about hats are closed"}
+	}}}, time.Second)
+	store := newFakeStore()
+	h := newTestHandler(store, HandlerConfig{Hooks: hooks})
+
+	rec := httptest.NewRecorder()
+	h.CreatePost(rec, httptest.NewRequest(http.MethodPost, "/api/posts", strings.NewReader(validPost)))
+	assertError(t, rec, http.StatusBadRequest, "Posts about hats are closed")
+	if store.created != nil {
+		t.Error("the rejected post was saved")
+	}
+}
+
+func TestHTTPPostHook(t *testing.T) {
+	var got []map[string]json.RawMessage
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mac := hmac.New(sha256.New, []byte("hook-secret"))
+		mac.Write(body)
+		if r.Header.Get("X-Hook-Signature") != hex.EncodeToString(mac.Sum(nil)) {
+			t.Errorf("X-Hook-Signature doesn't match the body")
+		}
+
+		var payload map[string]json.RawMessage
+		json.Unmarshal(body, &payload)
+		got = append(got, payload)
+		if string(payload["hook"]) == `"post.create"` {
+			w.Write([]byte(`{"allow": false, "message": "Not today"}`))
+		}
+	}))
+	defer server.Close()
+
+	hook, err := NewHTTPPostHook(server.URL, "hook-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = hook.OnPostCreate(context.Background(), &CreatePostRequest{EventName: "Glastonbury", Content: "hi", InviteCode: "invite", CheckinCode: "checkin"})
+	var validation *ValidationError
+	if !errors.As(err, &validation) || validation.Message != "Not today" {
+		t.Errorf("OnPostCreate err = %v, want the hook's rejection", err)
+	}
+	if err := hook.OnPostPublish(context.Background(), Post{PublicID: "a000000001"}); err != nil {
+		t.Errorf("OnPostPublish err = %v", err)
+	}
+	if err := hook.OnReport(context.Background(), PostReport{PostID: "a000000001", Reason: "spam"}); err != nil {
+		t.Errorf("OnReport err = %v", err)
+	}
+
+	want := []string{`"post.create"`, `"post.publish"`, `"report"`}
+	if len(got) != len(want) {
+		t.Fatalf("hook got %d calls, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if string(got[i]["hook"]) != want[i] {
+			t.Errorf("call %d hook = %s, want %s", i+1, got[i]["hook"], want[i])
+		}
+	}
+
+	var post map[string]json.RawMessage
+	json.Unmarshal(got[0]["post"], &post)
+	if string(post["content"]) != `"hi"` {
+		t.Errorf("post.create post = %s, want the request", got[0]["post"])
+	}
+	for _, field := range []string{"invite_code", "checkin_code"} {
+		if _, ok := post[field]; ok {
+			t.Errorf("post.create post has %s", field)
+		}
+	}
+
+	if _, err := NewHTTPPostHook("ftp://example.com/hook", ""); err == nil {
+		t.Error("an ftp hook URL was accepted")
+	}
+}
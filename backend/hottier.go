@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// hotPageTTL is how long a hot event's first page is served before it is
+	// rendered again; a new post in the event invalidates it sooner
+	hotPageTTL = 2 * time.Second
+	// hotSweepInterval is how often events are checked for demotion
+	hotSweepInterval = time.Minute
+)
+
+// HotTier spots events drawing a disproportionate share of traffic and
+// serves their first page of posts from memory, pre-rendered, so one viral
+// event's readers don't each run the listing query. An event is promoted
+// once its requests in the last minute reach threshold, and demoted when
+// they fall below half of it. Counts are per server process.
+type HotTier struct {
+	threshold int
+
+	mu     sync.Mutex
+	events map[string]*eventTraffic
+
+	promotions, demotions int64
+	hits, misses          int64
+}
+
+// eventTraffic is one event's request rate and, while it is hot, its
+// rendered first page.
+type eventTraffic struct {
+	// minute is the current minute; requests counts this minute's requests
+	// and previous the last minute's
+	minute   int64
+	requests int
+	previous int
+
+	hot  bool
+	page *hotPage
+	// generation is bumped by invalidate, so a render that started before
+	// a new post isn't kept
+	generation int
+}
+
+type hotPage struct {
+	body     []byte
+	snapshot string
+	rendered time.Time
+	// promoted are the promotions on the page, counted each time it is
+	// served
+	promoted   []int
+	generation int
+}
+
+// NewHotTier returns nil, which caches nothing, when threshold is 0.
+func NewHotTier(threshold int) *HotTier {
+	if threshold <= 0 {
+		return nil
+	}
+	return &HotTier{threshold: threshold, events: make(map[string]*eventTraffic)}
+}
+
+// rate estimates an event's requests over the last minute, weighting the
+// previous minute by how much of it the window still covers.
+func (t *eventTraffic) rate(now time.Time) float64 {
+	t.roll(now)
+	elapsed := float64(now.Unix()%60) / 60
+	return float64(t.previous)*(1-elapsed) + float64(t.requests)
+}
+
+func (t *eventTraffic) roll(now time.Time) {
+	minute := now.Unix() / 60
+	switch {
+	case minute == t.minute:
+	case minute == t.minute+1:
+		t.minute, t.previous, t.requests = minute, t.requests, 0
+	default:
+		t.minute, t.previous, t.requests = minute, 0, 0
+	}
+}
+
+// observe counts a request for event's posts, promoting the event if that
+// makes it hot, and reports whether it is hot.
+func (c *HotTier) observe(event string, now time.Time) bool {
+	if c == nil || event == "" {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t := c.events[event]
+	if t == nil {
+		t = &eventTraffic{minute: now.Unix() / 60}
+		c.events[event] = t
+	}
+	t.roll(now)
+	t.requests++
+	if !t.hot && t.rate(now) >= float64(c.threshold) {
+		t.hot = true
+		c.promotions++
+		log.Printf("Event %q is hot (%.0f requests/min); caching its first page", event, t.rate(now))
+	}
+	return t.hot
+}
+
+// sweep demotes events whose traffic has cooled and forgets idle ones.
+func (c *HotTier) sweep(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for event, t := range c.events {
+		rate := t.rate(now)
+		if t.hot && rate < float64(c.threshold)/2 {
+			t.hot, t.page = false, nil
+			c.demotions++
+			log.Printf("Event %q has cooled (%.0f requests/min); no longer caching it", event, rate)
+		}
+		if !t.hot && t.requests == 0 && t.previous == 0 {
+			delete(c.events, event)
+		}
+	}
+}
+
+// Run demotes cooled events every hotSweepInterval until ctx is cancelled.
+func (c *HotTier) Run(ctx context.Context) {
+	if c == nil {
+		return
+	}
+	ticker := time.NewTicker(hotSweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case t := <-ticker.C:
+			c.sweep(t)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// invalidate drops a hot event's page, for when a post is published to it.
+func (c *HotTier) invalidate(event string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if t := c.events[event]; t != nil && t.hot {
+		t.page = nil
+		t.generation++
+	}
+}
+
+// page returns a hot event's rendered page if it is fresh, or else the
+// generation a new render must be stored under.
+func (c *HotTier) page(event string, now time.Time) (*hotPage, int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := c.events[event]
+	if t == nil {
+		return nil, 0
+	}
+	if t.page != nil && now.Sub(t.page.rendered) < hotPageTTL {
+		c.hits++
+		return t.page, 0
+	}
+	c.misses++
+	return nil, t.generation
+}
+
+// store keeps a rendered page, unless the event has since cooled or had a
+// new post.
+func (c *HotTier) store(event string, page *hotPage) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if t := c.events[event]; t != nil && t.hot && t.generation == page.generation {
+		t.page = page
+	}
+}
+
+// HotTierStats is the hot tier's state, for the admin metrics.
+type HotTierStats struct {
+	Hot        []string `json:"hot"`
+	Promotions int64    `json:"promotions"`
+	Demotions  int64    `json:"demotions"`
+	Hits       int64    `json:"hits"`
+	Misses     int64    `json:"misses"`
+}
+
+func (c *HotTier) stats() *HotTierStats {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := &HotTierStats{Hot: []string{}, Promotions: c.promotions, Demotions: c.demotions, Hits: c.hits, Misses: c.misses}
+	for event, t := range c.events {
+		if t.hot {
+			stats.Hot = append(stats.Hot, event)
+		}
+	}
+	return stats
+}
+
+// hotEligible reports whether a posts request is for the plain first page
+// the hot tier holds: just ?event=, no device preferences, and no
+// consistency token to honour.
+func hotEligible(r *http.Request) bool {
+	q := r.URL.Query()
+	return len(q) == 1 && q.Has("event") &&
+		r.Header.Get("X-Device-Token") == "" && r.Header.Get(consistencyHeader) == ""
+}
+
+// serveHotPage answers a hot event's first page from the hot tier,
+// rendering it if it is stale. It reports false, having written nothing, if
+// the page can't be held, so the caller should serve it as usual.
+func (h *Handler) serveHotPage(w http.ResponseWriter, r *http.Request, event string) bool {
+	page, generation := h.cfg.HotTier.page(event, time.Now())
+	if page == nil {
+		var err error
+		page, err = h.renderHotPage(r.Context(), event)
+		if err != nil {
+			log.Printf("Error rendering hot page: %v", err)
+			return false
+		}
+		if page == nil {
+			return false
+		}
+		page.generation = generation
+		h.cfg.HotTier.store(event, page)
+	}
+
+	h.cfg.Impressions.Record(page.promoted)
+	if page.snapshot != "" {
+		w.Header().Set(snapshotHeader, page.snapshot)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(page.body)
+	return true
+}
+
+// renderHotPage renders an event's first page as GetPosts would. It returns
+// nil for an archived event, which is served from its snapshot instead.
+func (h *Handler) renderHotPage(ctx context.Context, event string) (*hotPage, error) {
+	if h.cfg.Archive != nil {
+		e, err := h.db.GetEvent(ctx, event)
+		if err != nil {
+			return nil, err
+		}
+		if e != nil && e.ArchivedAt != nil {
+			return nil, nil
+		}
+	}
+
+	rendered := time.Now()
+	posts, err := h.db.GetPosts(ctx, PostFilter{Event: event}, defaultPageSize, 0)
+	if err != nil {
+		return nil, err
+	}
+	if posts == nil {
+		posts = []Post{}
+	}
+	if err := h.presentPosts(ctx, posts, nil); err != nil {
+		return nil, err
+	}
+
+	page := &hotPage{rendered: rendered}
+	if len(posts) > 0 {
+		maxID := 0
+		for _, post := range posts {
+			maxID = max(maxID, post.ID)
+		}
+		page.snapshot = snapshotToken(maxID)
+	}
+	posts, page.promoted, err = h.promotePosts(ctx, event, posts)
+	if err != nil {
+		return nil, err
+	}
+	// Encoded as respondWithJSON would, so hot and cold responses match
+	var body bytes.Buffer
+	if err := json.NewEncoder(&body).Encode(posts); err != nil {
+		return nil, err
+	}
+	page.body = body.Bytes()
+	return page, nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHotTierPromotion(t *testing.T) {
+	c := NewHotTier(3)
+	start := time.Unix(1_800_000_000, 0).Truncate(time.Minute)
+
+	for i, want := range []bool{false, false, true, true} {
+		if got := c.observe("Glastonbury", start.Add(time.Duration(i)*time.Second)); got != want {
+			t.Errorf("request %d: hot = %v, want %v", i+1, got, want)
+		}
+	}
+	if c.observe("Quiet Fest", start) {
+		t.Error("event with one request is hot")
+	}
+
+	// Half a minute on, the last minute's requests still count
+	c.sweep(start.Add(90 * time.Second))
+	if stats := c.stats(); len(stats.Hot) != 1 {
+		t.Fatalf("hot = %v after half a minute, want Glastonbury still hot", stats.Hot)
+	}
+
+	c.sweep(start.Add(3 * time.Minute))
+	stats := c.stats()
+	if len(stats.Hot) != 0 || stats.Promotions != 1 || stats.Demotions != 1 {
+		t.Errorf("stats = %+v, want one promotion and one demotion", stats)
+	}
+	if len(c.events) != 0 {
+		t.Errorf("%d idle events still tracked", len(c.events))
+	}
+}
+
+// TestGetPostsHotTier checks that a hot event's first page is served from
+// memory until a new post invalidates it, and that other pages aren't.
+func TestGetPostsHotTier(t *testing.T) {
+	store := newFakeStore()
+	store.posts = []Post{{ID: 7, EventName: "Glastonbury", Content: "hi"}}
+	h := newTestHandler(store, HandlerConfig{HotTier: NewHotTier(1)})
+	get := func(query string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.GetPosts(rec, httptest.NewRequest(http.MethodGet, "/api/posts?"+query, nil))
+		return rec
+	}
+
+	first := get("event=Glastonbury")
+	if first.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200 (body %s)", first.Code, first.Body)
+	}
+
+	// With the database down, only the cached page can be served
+	store.fail["GetPosts"] = true
+	cached := get("event=Glastonbury")
+	if cached.Code != http.StatusOK || cached.Body.String() != first.Body.String() {
+		t.Fatalf("cached page = %d %s, want %s", cached.Code, cached.Body, first.Body)
+	}
+	if got, want := cached.Header().Get(snapshotHeader), first.Header().Get(snapshotHeader); got != want || got == "" {
+		t.Errorf("cached snapshot token = %q, want %q", got, want)
+	}
+	assertError(t, get("event=Glastonbury&offset=50"), http.StatusInternalServerError, "Failed to retrieve posts")
+
+	h.cfg.HotTier.invalidate("Glastonbury")
+	assertError(t, get("event=Glastonbury"), http.StatusInternalServerError, "Failed to retrieve posts")
+
+	if stats := h.cfg.HotTier.stats(); stats.Hits != 1 || stats.Misses != 2 {
+		t.Errorf("stats = %+v, want 1 hit and 2 misses", stats)
+	}
+}
+
+// TestEditPostInvalidatesHotTier checks an edited post isn't left stale in
+// its event's cached first page.
+func TestEditPostInvalidatesHotTier(t *testing.T) {
+	store := newFakeStore()
+	store.posts = []Post{{ID: 7, EventName: "Glastonbury", Content: "Lost: blue hat"}}
+	h := newTestHandler(store, HandlerConfig{HotTier: NewHotTier(1)})
+	get := func() string {
+		rec := httptest.NewRecorder()
+		h.GetPosts(rec, httptest.NewRequest(http.MethodGet, "/api/posts?event=Glastonbury", nil))
+		return rec.Body.String()
+	}
+	get()
+
+	req := httptest.NewRequest(http.MethodPatch, "/api/posts/7", strings.NewReader(`{"edit_token":"secret","content":"Found: blue hat"}`))
+	req.SetPathValue("id", "7")
+	rec := httptest.NewRecorder()
+	h.EditPost(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("edit status = %d, want 200 (body %s)", rec.Code, rec.Body)
+	}
+
+	if body := get(); !strings.Contains(body, "Found: blue hat") {
+		t.Errorf("first page after edit = %s, want the edited post", body)
+	}
+}
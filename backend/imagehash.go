@@ -0,0 +1,355 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"log"
+	"math/bits"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Hamming distances between perceptual hashes: uploads this close to a
+// banned image are refused, and those a little further are flagged for
+// moderators once posted.
+const (
+	bannedImageRejectDistance = 6
+	bannedImageFlagDistance   = 12
+)
+
+// bannedImageReason is the moderation queue reason of a post with an image
+// resembling a banned one.
+const bannedImageReason = "banned_image"
+
+// hashCellSamples bounds the pixels read per cell of the hash grid, so large
+// images hash as quickly as small ones.
+const hashCellSamples = 16
+
+// imageHash is a 64-bit difference hash: each bit says whether a cell of a
+// 9x8 grayscale thumbnail is darker than its right neighbour. Resizing,
+// recompressing and small edits change few bits.
+type imageHash uint64
+
+func (h imageHash) String() string {
+	return fmt.Sprintf("%016x", uint64(h))
+}
+
+func (h imageHash) MarshalJSON() ([]byte, error) {
+	return json.Marshal(h.String())
+}
+
+func parseImageHash(s string) (imageHash, error) {
+	if len(s) != 16 {
+		return 0, fmt.Errorf("hash must be 16 hex digits")
+	}
+	v, err := strconv.ParseUint(s, 16, 64)
+	if err != nil {
+		return 0, fmt.Errorf("hash must be 16 hex digits")
+	}
+	return imageHash(v), nil
+}
+
+// distance is the number of bits that differ between two hashes.
+func (h imageHash) distance(other imageHash) int {
+	return bits.OnesCount64(uint64(h ^ other))
+}
+
+// perceptualHash computes the difference hash of an image.
+func perceptualHash(img image.Image) imageHash {
+	b := img.Bounds()
+	var gray [8][9]float64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 9; x++ {
+			gray[y][x] = cellBrightness(img,
+				b.Min.X+x*b.Dx()/9, b.Min.Y+y*b.Dy()/8,
+				b.Min.X+(x+1)*b.Dx()/9, b.Min.Y+(y+1)*b.Dy()/8,
+			)
+		}
+	}
+
+	var hash imageHash
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			hash <<= 1
+			if gray[y][x] < gray[y][x+1] {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// cellBrightness averages the luma of up to hashCellSamples² evenly spaced
+// pixels in a rectangle.
+func cellBrightness(img image.Image, x0, y0, x1, y1 int) float64 {
+	if x1 <= x0 {
+		x1 = x0 + 1
+	}
+	if y1 <= y0 {
+		y1 = y0 + 1
+	}
+	stepX := max(1, (x1-x0)/hashCellSamples)
+	stepY := max(1, (y1-y0)/hashCellSamples)
+
+	var sum float64
+	n := 0
+	for y := y0; y < y1; y += stepY {
+		for x := x0; x < x1; x += stepX {
+			r, g, b, _ := img.At(x, y).RGBA()
+			sum += 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)
+			n++
+		}
+	}
+	return sum / float64(n)
+}
+
+// BannedImage is the hash of an image that may not be posted again.
+type BannedImage struct {
+	ID        int       `json:"id"`
+	Hash      imageHash `json:"hash"`
+	Reason    string    `json:"reason,omitempty"`
+	BannedBy  string    `json:"banned_by"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// CreateBannedImageRequest bans an image by one of its attachments or by
+// its hash.
+type CreateBannedImageRequest struct {
+	AttachmentID *int   `json:"attachment_id"`
+	Hash         string `json:"hash"`
+	Reason       string `json:"reason"`
+}
+
+// nearestBannedImage returns the banned image closest to hash and its
+// distance, or nil if none are within bannedImageFlagDistance.
+func nearestBannedImage(bans []BannedImage, hash imageHash) (*BannedImage, int) {
+	var nearest *BannedImage
+	best := bannedImageFlagDistance + 1
+	for i := range bans {
+		if d := bans[i].Hash.distance(hash); d < best {
+			nearest, best = &bans[i], d
+		}
+	}
+	return nearest, best
+}
+
+// screenImage checks an upload against the banned images. It returns an
+// error if the upload must be refused, or else the ID of the banned image
+// it resembles, if any.
+func (h *Handler) screenImage(ctx context.Context, data []byte) (*int, error) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, &ValidationError{Message: "file is not a valid image"}
+	}
+
+	bans, err := h.db.GetBannedImages(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ban, distance := nearestBannedImage(bans, perceptualHash(img))
+	if ban == nil {
+		return nil, nil
+	}
+	if distance <= bannedImageRejectDistance {
+		log.Printf("Refused upload matching banned image %d (distance %d)", ban.ID, distance)
+		return nil, &BannedError{}
+	}
+	return &ban.ID, nil
+}
+
+// flagBannedImages queues a new post for review if any of its attachments
+// resembles a banned image.
+func flagBannedImages(ctx context.Context, db Store, postID int, attachments []Attachment) error {
+	for _, a := range attachments {
+		if a.bannedImageID == nil {
+			continue
+		}
+		flag := ContentFlag{Reason: bannedImageReason, Details: fmt.Sprintf("attachment %d resembles banned image %d", a.ID, *a.bannedImageID)}
+		if err := db.EnqueueModeration(ctx, postID, flag, "image_hash"); err != nil {
+			return fmt.Errorf("failed to queue post %d for moderation: %w", postID, err)
+		}
+	}
+	return nil
+}
+
+// GetBannedImages handles GET /admin/banned-images
+func (h *Handler) GetBannedImages(w http.ResponseWriter, r *http.Request) {
+	bans, err := h.db.GetBannedImages(r.Context())
+	if err != nil {
+		log.Printf("Error getting banned images: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve banned images")
+		return
+	}
+
+	if bans == nil {
+		bans = []BannedImage{}
+	}
+
+	respondWithJSON(w, http.StatusOK, bans)
+}
+
+// CreateBannedImage handles POST /admin/banned-images. Pass attachment_id to
+// ban an uploaded image, or hash to ban one known from elsewhere.
+func (h *Handler) CreateBannedImage(w http.ResponseWriter, r *http.Request) {
+	var req CreateBannedImageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if len(req.Reason) > 1000 {
+		respondWithError(w, http.StatusBadRequest, "reason must be 1000 characters or less")
+		return
+	}
+
+	var hash imageHash
+	switch {
+	case req.AttachmentID != nil && req.Hash != "", req.AttachmentID == nil && req.Hash == "":
+		respondWithError(w, http.StatusBadRequest, "Exactly one of attachment_id and hash is required")
+		return
+	case req.Hash != "":
+		var err error
+		if hash, err = parseImageHash(req.Hash); err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	default:
+		var ok bool
+		if hash, ok = h.attachmentHash(w, r, *req.AttachmentID); !ok {
+			return
+		}
+	}
+
+	ban, err := h.db.CreateBannedImage(r.Context(), hash, req.Reason, adminActor(r))
+	if err != nil {
+		log.Printf("Error banning image: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to ban image")
+		return
+	}
+
+	h.audit(r, "image.ban", "banned_image", strconv.Itoa(ban.ID), ban)
+
+	respondWithJSON(w, http.StatusCreated, ban)
+}
+
+// attachmentHash computes the hash of an uploaded image. On failure it
+// writes the error response and returns false.
+func (h *Handler) attachmentHash(w http.ResponseWriter, r *http.Request, id int) (imageHash, bool) {
+	attachment, err := h.db.GetAttachmentByID(r.Context(), id)
+	if err != nil {
+		log.Printf("Error getting attachment: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to ban image")
+		return 0, false
+	}
+	if attachment == nil {
+		respondWithError(w, http.StatusNotFound, "Attachment not found")
+		return 0, false
+	}
+
+	data, err := h.cfg.Media.Get(r.Context(), attachment.storageKey)
+	if err != nil {
+		log.Printf("Error reading media %s: %v", attachment.storageKey, err)
+		respondWithError(w, http.StatusNotFound, "Attachment file not found")
+		return 0, false
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		log.Printf("Error decoding media %s: %v", attachment.storageKey, err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to ban image")
+		return 0, false
+	}
+	return perceptualHash(img), true
+}
+
+// DeleteBannedImage handles DELETE /admin/banned-images/{id}
+func (h *Handler) DeleteBannedImage(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid banned image ID")
+		return
+	}
+
+	found, err := h.db.DeleteBannedImage(r.Context(), id)
+	if err != nil {
+		log.Printf("Error deleting banned image: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to delete banned image")
+		return
+	}
+	if !found {
+		respondWithError(w, http.StatusNotFound, "Banned image not found")
+		return
+	}
+
+	h.audit(r, "image.unban", "banned_image", strconv.Itoa(id), nil)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+const bannedImageColumns = `id, hash, COALESCE(reason, ''), banned_by, created_at`
+
+func scanBannedImage(row rowScanner) (*BannedImage, error) {
+	var ban BannedImage
+	var hash int64
+	if err := row.Scan(&ban.ID, &hash, &ban.Reason, &ban.BannedBy, &ban.CreatedAt); err != nil {
+		return nil, err
+	}
+	ban.Hash = imageHash(hash)
+	return &ban, nil
+}
+
+// GetBannedImages lists every banned image, newest first.
+func (db *DB) GetBannedImages(ctx context.Context) ([]BannedImage, error) {
+	rows, err := db.conn.QueryContext(ctx, `SELECT `+bannedImageColumns+` FROM banned_image_hashes ORDER BY created_at DESC, id DESC`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query banned images: %w", err)
+	}
+	defer rows.Close()
+
+	var bans []BannedImage
+	for rows.Next() {
+		ban, err := scanBannedImage(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan banned image: %w", err)
+		}
+		bans = append(bans, *ban)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating banned images: %w", err)
+	}
+
+	return bans, nil
+}
+
+func (db *DB) CreateBannedImage(ctx context.Context, hash imageHash, reason, bannedBy string) (*BannedImage, error) {
+	query := `
+		INSERT INTO banned_image_hashes (hash, reason, banned_by)
+		VALUES ($1, NULLIF($2, ''), $3)
+		RETURNING ` + bannedImageColumns
+
+	ban, err := scanBannedImage(db.conn.QueryRowContext(ctx, query, int64(hash), reason, bannedBy))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create banned image: %w", err)
+	}
+	return ban, nil
+}
+
+// DeleteBannedImage lifts a ban, reporting whether it existed.
+func (db *DB) DeleteBannedImage(ctx context.Context, id int) (bool, error) {
+	result, err := db.conn.ExecContext(ctx, "DELETE FROM banned_image_hashes WHERE id = $1", id)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete banned image: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to delete banned image: %w", err)
+	}
+
+	return affected > 0, nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// testImage draws a pattern of soft blobs, scaled to the given size.
+func testImage(width, height int, invert bool) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			fx, fy := float64(x)/float64(width), float64(y)/float64(height)
+			v := uint8(255 * (fx*fx + fy*(1-fx)) / 2)
+			if (int(fx*5)+int(fy*3))%2 == 0 {
+				v = 255 - v
+			}
+			if invert {
+				v = 255 - v
+			}
+			img.Set(x, y, color.RGBA{v, v / 2, 255 - v, 255})
+		}
+	}
+	return img
+}
+
+func TestPerceptualHash(t *testing.T) {
+	original := perceptualHash(testImage(400, 300, false))
+
+	if d := perceptualHash(testImage(200, 150, false)).distance(original); d > bannedImageRejectDistance {
+		t.Errorf("resized copy: distance = %d, want at most %d", d, bannedImageRejectDistance)
+	}
+	if d := perceptualHash(testImage(400, 300, true)).distance(original); d <= bannedImageFlagDistance {
+		t.Errorf("different image: distance = %d, want more than %d", d, bannedImageFlagDistance)
+	}
+	// Images smaller than the hash grid still hash
+	perceptualHash(testImage(3, 2, false))
+
+	parsed, err := parseImageHash(original.String())
+	if err != nil || parsed != original {
+		t.Errorf("parseImageHash(%s) = %s, %v", original, parsed, err)
+	}
+	if _, err := parseImageHash("xyz"); err == nil {
+		t.Error("parseImageHash accepted an invalid hash")
+	}
+}
+
+func TestUploadAttachmentBannedImage(t *testing.T) {
+	img := testImage(120, 90, false)
+	hash := perceptualHash(img)
+	var encoded bytes.Buffer
+	png.Encode(&encoded, img)
+
+	// flipBits returns hash with its lowest n bits inverted.
+	flipBits := func(n int) imageHash { return hash ^ imageHash(1<<n-1) }
+
+	tests := []struct {
+		name    string
+		ban     imageHash
+		status  int
+		flagged bool
+	}{
+		{name: "unrelated", ban: ^hash, status: http.StatusCreated},
+		{name: "banned", ban: hash, status: http.StatusForbidden},
+		{name: "near duplicate", ban: flipBits(bannedImageRejectDistance), status: http.StatusForbidden},
+		{name: "resembles", ban: flipBits(bannedImageFlagDistance), status: http.StatusCreated, flagged: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			media, err := NewLocalMediaStore(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			store := newFakeStore()
+			store.bannedImages = []BannedImage{{ID: 3, Hash: tt.ban}}
+			h := newTestHandler(store, HandlerConfig{Media: media, MaxUploadBytes: 1 << 20})
+
+			var body bytes.Buffer
+			form := multipart.NewWriter(&body)
+			part, _ := form.CreateFormFile("file", "photo.png")
+			part.Write(encoded.Bytes())
+			form.Close()
+
+			req := httptest.NewRequest(http.MethodPost, "/api/attachments", &body)
+			req.Header.Set("Content-Type", form.FormDataContentType())
+			rec := httptest.NewRecorder()
+			h.UploadAttachment(rec, req)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d (body %s)", rec.Code, tt.status, rec.Body)
+			}
+
+			if tt.status != http.StatusCreated {
+				if store.attachment != nil {
+					t.Error("refused upload was stored")
+				}
+				return
+			}
+			if flagged := store.attachment.bannedImageID != nil; flagged != tt.flagged {
+				t.Errorf("flagged = %v, want %v", flagged, tt.flagged)
+			}
+		})
+	}
+}
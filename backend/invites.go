@@ -0,0 +1,396 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// inviteCodePattern is the shape of an invite code, after upper-casing;
+// codes are matched case-insensitively so they can be read out or typed.
+var inviteCodePattern = regexp.MustCompile(`^[A-Z0-9_-]{4,64}$`)
+
+// InviteCode admits posts while the site, or an event, is invite-only: the
+// soft launch of a new city or event before it opens publicly. A code with
+// an EventName only admits posts to that event.
+type InviteCode struct {
+	ID        int    `json:"id"`
+	Code      string `json:"code"`
+	EventName string `json:"event_name,omitempty"`
+	Note      string `json:"note"`
+	// MaxUses caps the posts the code admits; nil is unlimited
+	MaxUses   *int       `json:"max_uses,omitempty"`
+	Uses      int        `json:"uses"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+// CreateInviteCodeRequest creates a code. A Code is generated if none is
+// given.
+type CreateInviteCodeRequest struct {
+	Code      string     `json:"code"`
+	EventName string     `json:"event_name"`
+	Note      string     `json:"note"`
+	MaxUses   *int       `json:"max_uses"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// UpdateInviteCodeRequest is a partial update; omitted fields are left
+// unchanged. A MaxUses of 0 lifts the cap.
+type UpdateInviteCodeRequest struct {
+	Note      *string    `json:"note"`
+	MaxUses   *int       `json:"max_uses"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+func normalizeInviteCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// checkInviteCode applies the invite-only gate to a new post: posts to an
+// invite-only event, or to any event while the whole site is, need a usable
+// code for it. The code is only checked here; publishPost redeems it with
+// the post. Elsewhere any code sent is dropped.
+func (h *Handler) checkInviteCode(ctx context.Context, req *CreatePostRequest, settings EventSettings) error {
+	if !h.cfg.InviteOnly && !settings.InviteOnly {
+		req.InviteCode = ""
+		return nil
+	}
+
+	req.InviteCode = normalizeInviteCode(req.InviteCode)
+	if req.InviteCode == "" {
+		return &ValidationError{Message: "This board is invite-only; invite_code is required", Rule: "invite_code.required"}
+	}
+	ok, err := h.db.CheckInviteCode(ctx, req.InviteCode, req.EventName)
+	if err != nil {
+		return fmt.Errorf("failed to check invite code: %w", err)
+	}
+	if !ok {
+		return errInviteCodeInvalid()
+	}
+	return nil
+}
+
+func errInviteCodeInvalid() error {
+	return &ValidationError{Message: "invite_code is invalid, expired or used up", Rule: "invite_code.invalid"}
+}
+
+// GetInviteCodes handles GET /admin/invite-codes, optionally narrowed to
+// one event's codes with ?event=.
+func (h *Handler) GetInviteCodes(w http.ResponseWriter, r *http.Request) {
+	event, err := h.canonicalEventName(r.Context(), r.URL.Query().Get("event"))
+	if err != nil {
+		log.Printf("Error resolving event name: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve invite codes")
+		return
+	}
+
+	codes, err := h.db.GetInviteCodes(r.Context(), event)
+	if err != nil {
+		log.Printf("Error getting invite codes: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve invite codes")
+		return
+	}
+
+	if codes == nil {
+		codes = []InviteCode{}
+	}
+
+	respondWithJSON(w, http.StatusOK, codes)
+}
+
+// CreateInviteCode handles POST /admin/invite-codes. A code for an event
+// that has no posts yet creates the event invite-only, so a new board can
+// be soft-launched before anyone can post to it openly.
+func (h *Handler) CreateInviteCode(w http.ResponseWriter, r *http.Request) {
+	var req CreateInviteCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	req.Code = normalizeInviteCode(req.Code)
+	if req.Code == "" {
+		req.Code = strings.ToUpper(randomToken(5))
+	}
+	if !inviteCodePattern.MatchString(req.Code) {
+		respondWithError(w, http.StatusBadRequest, "code must be 4 to 64 letters, digits, dashes or underscores")
+		return
+	}
+	req.Note = strings.TrimSpace(req.Note)
+	if err := validateInviteCodeLimits(req.Note, req.MaxUses); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if req.MaxUses != nil && *req.MaxUses == 0 {
+		req.MaxUses = nil
+	}
+
+	event, err := h.canonicalEventName(r.Context(), strings.TrimSpace(req.EventName))
+	if err != nil {
+		log.Printf("Error resolving event name: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to create invite code")
+		return
+	}
+	if len(event) > 200 {
+		respondWithError(w, http.StatusBadRequest, "event_name must be 200 characters or less")
+		return
+	}
+	req.EventName = event
+
+	code, err := h.db.CreateInviteCode(r.Context(), req)
+	if err != nil {
+		log.Printf("Error creating invite code: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to create invite code")
+		return
+	}
+	if code == nil {
+		respondWithError(w, http.StatusConflict, "That invite code already exists")
+		return
+	}
+
+	h.audit(r, "invite_code.create", "invite_code", strconv.Itoa(code.ID), code)
+
+	respondWithJSON(w, http.StatusCreated, code)
+}
+
+// UpdateInviteCode handles PATCH /admin/invite-codes/{id}, to raise or
+// lower a code's cap, extend it or change its note.
+func (h *Handler) UpdateInviteCode(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid invite code ID")
+		return
+	}
+
+	var req UpdateInviteCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	var note string
+	if req.Note != nil {
+		note = strings.TrimSpace(*req.Note)
+		req.Note = &note
+	}
+	if err := validateInviteCodeLimits(note, req.MaxUses); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	code, err := h.db.UpdateInviteCode(r.Context(), id, req)
+	if err != nil {
+		log.Printf("Error updating invite code: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to update invite code")
+		return
+	}
+	if code == nil {
+		respondWithError(w, http.StatusNotFound, "Invite code not found")
+		return
+	}
+
+	h.audit(r, "invite_code.update", "invite_code", strconv.Itoa(id), req)
+
+	respondWithJSON(w, http.StatusOK, code)
+}
+
+// RevokeInviteCode handles DELETE /admin/invite-codes/{id}. Codes are
+// revoked rather than deleted so their use stays on record.
+func (h *Handler) RevokeInviteCode(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid invite code ID")
+		return
+	}
+
+	found, err := h.db.RevokeInviteCode(r.Context(), id)
+	if err != nil {
+		log.Printf("Error revoking invite code: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to revoke invite code")
+		return
+	}
+	if !found {
+		respondWithError(w, http.StatusNotFound, "Invite code not found")
+		return
+	}
+
+	h.audit(r, "invite_code.revoke", "invite_code", strconv.Itoa(id), nil)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func validateInviteCodeLimits(note string, maxUses *int) error {
+	if len(note) > 500 {
+		return &ValidationError{Message: "note must be 500 characters or less"}
+	}
+	if maxUses != nil && *maxUses < 0 {
+		return &ValidationError{Message: "max_uses must not be negative"}
+	}
+	return nil
+}
+
+const inviteCodeColumns = `id, code, COALESCE(event_name, ''), note, max_uses, uses, expires_at, created_at, revoked_at`
+
+// inviteCodeUsable matches the codes that can admit a post to event $2.
+const inviteCodeUsable = `code = $1
+	AND revoked_at IS NULL
+	AND (expires_at IS NULL OR expires_at > NOW())
+	AND (max_uses IS NULL OR uses < max_uses)
+	AND (event_name IS NULL OR event_name = $2)`
+
+func scanInviteCode(row rowScanner) (*InviteCode, error) {
+	var code InviteCode
+	var maxUses sql.NullInt64
+	var expiresAt, revokedAt sql.NullTime
+	if err := row.Scan(&code.ID, &code.Code, &code.EventName, &code.Note, &maxUses, &code.Uses, &expiresAt, &code.CreatedAt, &revokedAt); err != nil {
+		return nil, err
+	}
+	if maxUses.Valid {
+		n := int(maxUses.Int64)
+		code.MaxUses = &n
+	}
+	if expiresAt.Valid {
+		code.ExpiresAt = &expiresAt.Time
+	}
+	if revokedAt.Valid {
+		code.RevokedAt = &revokedAt.Time
+	}
+	return &code, nil
+}
+
+// GetInviteCodes lists invite codes, newest first, optionally only those
+// for event.
+func (db *DB) GetInviteCodes(ctx context.Context, event string) ([]InviteCode, error) {
+	rows, err := db.conn.QueryContext(ctx, `
+		SELECT `+inviteCodeColumns+`
+		FROM invite_codes
+		WHERE $1 = '' OR event_name = $1
+		ORDER BY created_at DESC, id DESC
+	`, event)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query invite codes: %w", err)
+	}
+	defer rows.Close()
+
+	var codes []InviteCode
+	for rows.Next() {
+		code, err := scanInviteCode(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan invite code: %w", err)
+		}
+		codes = append(codes, *code)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating invite codes: %w", err)
+	}
+
+	return codes, nil
+}
+
+// CreateInviteCode adds an invite code, returning nil if the code is taken.
+// An event that doesn't exist yet is created invite-only.
+func (db *DB) CreateInviteCode(ctx context.Context, req CreateInviteCodeRequest) (*InviteCode, error) {
+	query := `
+		WITH new_event AS (
+			INSERT INTO events (name, invite_only)
+			SELECT $2, TRUE WHERE $2 <> ''
+			AND NOT EXISTS (SELECT 1 FROM invite_codes WHERE code = $1)
+			ON CONFLICT (name) DO NOTHING
+		)
+		INSERT INTO invite_codes (code, event_name, note, max_uses, expires_at)
+		VALUES ($1, NULLIF($2, ''), $3, $4, $5)
+		ON CONFLICT (code) DO NOTHING
+		RETURNING ` + inviteCodeColumns
+
+	code, err := scanInviteCode(db.conn.QueryRowContext(ctx, query, req.Code, req.EventName, req.Note, req.MaxUses, req.ExpiresAt))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create invite code: %w", err)
+	}
+	return code, nil
+}
+
+// UpdateInviteCode changes an unrevoked code, returning nil if there is
+// none with that ID.
+func (db *DB) UpdateInviteCode(ctx context.Context, id int, req UpdateInviteCodeRequest) (*InviteCode, error) {
+	query := `
+		UPDATE invite_codes
+		SET note = COALESCE($2, note),
+			max_uses = CASE WHEN $3::int IS NULL THEN max_uses ELSE NULLIF($3, 0) END,
+			expires_at = COALESCE($4, expires_at)
+		WHERE id = $1 AND revoked_at IS NULL
+		RETURNING ` + inviteCodeColumns
+
+	code, err := scanInviteCode(db.conn.QueryRowContext(ctx, query, id, req.Note, req.MaxUses, req.ExpiresAt))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to update invite code: %w", err)
+	}
+	return code, nil
+}
+
+// RevokeInviteCode stops a code admitting posts, reporting whether an
+// unrevoked one existed.
+func (db *DB) RevokeInviteCode(ctx context.Context, id int) (bool, error) {
+	result, err := db.conn.ExecContext(ctx,
+		"UPDATE invite_codes SET revoked_at = NOW() WHERE id = $1 AND revoked_at IS NULL",
+		id,
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to revoke invite code: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to revoke invite code: %w", err)
+	}
+
+	return affected > 0, nil
+}
+
+// CheckInviteCode reports whether code can admit a post to event, without
+// using it.
+func (db *DB) CheckInviteCode(ctx context.Context, code, event string) (bool, error) {
+	var ok bool
+	err := db.conn.QueryRowContext(ctx,
+		"SELECT EXISTS (SELECT 1 FROM invite_codes WHERE "+inviteCodeUsable+")",
+		code, event,
+	).Scan(&ok)
+	if err != nil {
+		return false, fmt.Errorf("failed to check invite code: %w", err)
+	}
+	return ok, nil
+}
+
+// RedeemInviteCode uses code for a post to event, reporting false if it
+// can't admit it. The cap is checked in the update, so concurrent posts
+// can't overrun it.
+func (db *DB) RedeemInviteCode(ctx context.Context, code, event string) (bool, error) {
+	result, err := db.conn.ExecContext(ctx,
+		"UPDATE invite_codes SET uses = uses + 1 WHERE "+inviteCodeUsable,
+		code, event,
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to redeem invite code: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to redeem invite code: %w", err)
+	}
+
+	return affected > 0, nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCreatePostInviteOnly(t *testing.T) {
+	store := newFakeStore()
+	store.settings["Launch Party"] = EventSettings{InviteOnly: true, AllAges: true}
+	store.invites["EARLY1"] = 1
+	h := newTestHandler(store, HandlerConfig{})
+
+	post := func(event, code string) *httptest.ResponseRecorder {
+		body := `{"event_name": "` + event + `", "content": "hi", "invite_code": "` + code + `", "age": 25, "location": "x"}`
+		rec := httptest.NewRecorder()
+		h.CreatePost(rec, httptest.NewRequest(http.MethodPost, "/api/posts", strings.NewReader(body)))
+		return rec
+	}
+
+	assertError(t, post("Launch Party", ""), 400, "This board is invite-only; invite_code is required")
+	assertError(t, post("Launch Party", "NOPE"), 400, "invite_code is invalid, expired or used up")
+
+	// Codes are matched case-insensitively and used up by the post
+	if rec := post("Launch Party", " early1 "); rec.Code != 201 {
+		t.Fatalf("status = %d with a valid code, want 201 (body %s)", rec.Code, rec.Body)
+	}
+	if store.invites["EARLY1"] != 0 {
+		t.Errorf("code has %d uses left, want 0", store.invites["EARLY1"])
+	}
+	assertError(t, post("Launch Party", "EARLY1"), 400, "invite_code is invalid, expired or used up")
+
+	// Open events ignore codes
+	if rec := post("Glastonbury", "EARLY1"); rec.Code != 201 {
+		t.Fatalf("status = %d on an open event, want 201", rec.Code)
+	}
+	if store.created.InviteCode != "" {
+		t.Errorf("open event post kept invite code %q", store.created.InviteCode)
+	}
+
+	// A site-wide soft launch gates every event
+	h = newTestHandler(store, HandlerConfig{InviteOnly: true})
+	assertError(t, post("Glastonbury", ""), 400, "This board is invite-only; invite_code is required")
+}
+
+func TestCreatePostInviteUsedUp(t *testing.T) {
+	// A code used up between the check and the post is refused, and the
+	// post isn't kept
+	store := newFakeStore()
+	h := newTestHandler(store, HandlerConfig{})
+
+	req := CreatePostRequest{EventName: "Launch Party", Content: "hi", InviteCode: "EARLY1"}
+	if _, err := h.publishPost(t.Context(), req, nil, "ip", ""); err == nil || err.Error() != "invite_code is invalid, expired or used up" {
+		t.Fatalf("publishPost error = %v, want the used-up code refused", err)
+	}
+	if len(store.posts) != 0 {
+		t.Errorf("%d posts kept, want none", len(store.posts))
+	}
+}
+
+func TestCreateInviteCodeValidation(t *testing.T) {
+	h := newTestHandler(newFakeStore(), HandlerConfig{})
+	for _, tt := range []struct {
+		body, want string
+	}{
+		{`{`, "Invalid request body"},
+		{`{"code": "ab"}`, "code must be 4 to 64 letters, digits, dashes or underscores"},
+		{`{"code": "has space"}`, "code must be 4 to 64 letters, digits, dashes or underscores"},
+		{`{"max_uses": -1}`, "max_uses must not be negative"},
+		{`{"note": "` + strings.Repeat("n", 501) + `"}`, "note must be 500 characters or less"},
+	} {
+		rec := httptest.NewRecorder()
+		h.CreateInviteCode(rec, httptest.NewRequest(http.MethodPost, "/admin/invite-codes", strings.NewReader(tt.body)))
+		assertError(t, rec, 400, tt.want)
+	}
+}
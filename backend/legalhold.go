@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LegalHold exempts a post, an event, or everything posted from an IP hash
+// from retention and any other job that would delete or anonymize it.
+type LegalHold struct {
+	ID          int        `json:"id"`
+	TargetType  string     `json:"target_type"`
+	TargetValue string     `json:"target_value"`
+	Reason      string     `json:"reason"`
+	Reference   string     `json:"reference,omitempty"`
+	PlacedBy    string     `json:"placed_by"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ReleasedAt  *time.Time `json:"released_at,omitempty"`
+	ReleasedBy  string     `json:"released_by,omitempty"`
+}
+
+// CreateLegalHoldRequest targets a "post" (by ID), an "event" (by name) or
+// an "ip_hash". Target type "ip" takes a raw IP address and stores its hash,
+// for requests that arrive quoting an address.
+type CreateLegalHoldRequest struct {
+	TargetType  string `json:"target_type"`
+	TargetValue string `json:"target_value"`
+	Reason      string `json:"reason"`
+	Reference   string `json:"reference"`
+}
+
+// CreateLegalHold handles POST /admin/legal-holds
+func (h *Handler) CreateLegalHold(w http.ResponseWriter, r *http.Request) {
+	var req CreateLegalHoldRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if err := normalizeLegalHoldRequest(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	hold, err := h.db.CreateLegalHold(r.Context(), req, adminActor(r))
+	if err != nil {
+		log.Printf("Error creating legal hold: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to create legal hold")
+		return
+	}
+
+	h.audit(r, "legal_hold.place", hold.TargetType, hold.TargetValue, map[string]interface{}{
+		"hold_id":   hold.ID,
+		"reason":    hold.Reason,
+		"reference": hold.Reference,
+	})
+
+	respondWithJSON(w, http.StatusCreated, hold)
+}
+
+// ListLegalHolds handles GET /admin/legal-holds. Pass ?active=true to hide
+// released holds.
+func (h *Handler) ListLegalHolds(w http.ResponseWriter, r *http.Request) {
+	activeOnly := r.URL.Query().Get("active") == "true"
+
+	holds, err := h.db.ListLegalHolds(r.Context(), activeOnly)
+	if err != nil {
+		log.Printf("Error listing legal holds: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve legal holds")
+		return
+	}
+
+	if holds == nil {
+		holds = []LegalHold{}
+	}
+
+	respondWithJSON(w, http.StatusOK, holds)
+}
+
+// ReleaseLegalHold handles DELETE /admin/legal-holds/{id}. Holds are
+// released rather than deleted so their history is kept.
+func (h *Handler) ReleaseLegalHold(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid legal hold ID")
+		return
+	}
+
+	hold, err := h.db.ReleaseLegalHold(r.Context(), id, adminActor(r))
+	if err != nil {
+		log.Printf("Error releasing legal hold: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to release legal hold")
+		return
+	}
+	if hold == nil {
+		respondWithError(w, http.StatusNotFound, "Active legal hold not found")
+		return
+	}
+
+	h.audit(r, "legal_hold.release", hold.TargetType, hold.TargetValue, map[string]interface{}{
+		"hold_id": hold.ID,
+	})
+
+	respondWithJSON(w, http.StatusOK, hold)
+}
+
+func normalizeLegalHoldRequest(req *CreateLegalHoldRequest) error {
+	req.TargetValue = strings.TrimSpace(req.TargetValue)
+	req.Reason = strings.TrimSpace(req.Reason)
+	req.Reference = strings.TrimSpace(req.Reference)
+
+	if req.TargetValue == "" {
+		return &ValidationError{Message: "target_value is required"}
+	}
+
+	switch req.TargetType {
+	case "post":
+		if id, err := strconv.Atoi(req.TargetValue); err != nil || id < 1 {
+			return &ValidationError{Message: "target_value must be a post ID"}
+		}
+	case "event":
+		if len(req.TargetValue) > 200 {
+			return &ValidationError{Message: "target_value must be 200 characters or less"}
+		}
+	case "ip":
+		req.TargetType = "ip_hash"
+		req.TargetValue = hashIP(req.TargetValue)
+	case "ip_hash":
+		req.TargetValue = strings.ToLower(req.TargetValue)
+		if b, err := hex.DecodeString(req.TargetValue); err != nil || len(b) != 32 {
+			return &ValidationError{Message: "target_value must be a 64 character hex IP hash"}
+		}
+	default:
+		return &ValidationError{Message: "target_type must be one of post, event, ip, ip_hash"}
+	}
+
+	if req.Reason == "" {
+		return &ValidationError{Message: "reason is required"}
+	}
+	if len(req.Reference) > 200 {
+		return &ValidationError{Message: "reference must be 200 characters or less"}
+	}
+
+	return nil
+}
+
+const legalHoldColumns = `id, target_type, target_value, reason, COALESCE(reference, ''), placed_by, created_at, released_at, COALESCE(released_by, '')`
+
+func scanLegalHold(row rowScanner) (*LegalHold, error) {
+	var hold LegalHold
+	err := row.Scan(
+		&hold.ID,
+		&hold.TargetType,
+		&hold.TargetValue,
+		&hold.Reason,
+		&hold.Reference,
+		&hold.PlacedBy,
+		&hold.CreatedAt,
+		&hold.ReleasedAt,
+		&hold.ReleasedBy,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &hold, nil
+}
+
+func (db *DB) CreateLegalHold(ctx context.Context, req CreateLegalHoldRequest, placedBy string) (*LegalHold, error) {
+	query := `
+		INSERT INTO legal_holds (target_type, target_value, reason, reference, placed_by)
+		VALUES ($1, $2, $3, NULLIF($4, ''), $5)
+		RETURNING ` + legalHoldColumns
+
+	hold, err := scanLegalHold(db.conn.QueryRowContext(ctx, query,
+		req.TargetType, req.TargetValue, req.Reason, req.Reference, placedBy,
+	))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create legal hold: %w", err)
+	}
+	return hold, nil
+}
+
+func (db *DB) ListLegalHolds(ctx context.Context, activeOnly bool) ([]LegalHold, error) {
+	query := `
+		SELECT ` + legalHoldColumns + `
+		FROM legal_holds
+		WHERE NOT $1 OR released_at IS NULL
+		ORDER BY created_at DESC
+	`
+
+	rows, err := db.conn.QueryContext(ctx, query, activeOnly)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query legal holds: %w", err)
+	}
+	defer rows.Close()
+
+	var holds []LegalHold
+	for rows.Next() {
+		hold, err := scanLegalHold(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan legal hold: %w", err)
+		}
+		holds = append(holds, *hold)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating legal holds: %w", err)
+	}
+
+	return holds, nil
+}
+
+// ReleaseLegalHold releases an active hold, returning nil if there is none
+// with that ID.
+func (db *DB) ReleaseLegalHold(ctx context.Context, id int, releasedBy string) (*LegalHold, error) {
+	query := `
+		UPDATE legal_holds
+		SET released_at = NOW(), released_by = $2
+		WHERE id = $1 AND released_at IS NULL
+		RETURNING ` + legalHoldColumns
+
+	hold, err := scanLegalHold(db.conn.QueryRowContext(ctx, query, id, releasedBy))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to release legal hold: %w", err)
+	}
+	return hold, nil
+}
+
+// notUnderLegalHold is a SQL condition excluding posts (aliased p) that are
+// covered by an active legal hold. Every job that deletes or anonymizes posts
+// must include it.
+const notUnderLegalHold = `
+	NOT EXISTS (
+		SELECT 1 FROM legal_holds h
+		WHERE h.released_at IS NULL
+		AND (
+			(h.target_type = 'post' AND h.target_value = p.id::text)
+			OR (h.target_type = 'event' AND h.target_value = p.event_name)
+			OR (h.target_type = 'ip_hash' AND h.target_value = p.ip_hash)
+		)
+	)
+`
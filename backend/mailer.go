@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"mime"
+	"net"
+	"net/smtp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Email is a plain text message to one recipient.
+type Email struct {
+	To      string
+	Subject string
+	Body    string
+	// Headers are extra headers, such as List-Unsubscribe
+	Headers map[string]string
+}
+
+// Mailer sends emails.
+type Mailer interface {
+	Send(ctx context.Context, email Email) error
+}
+
+// LogMailer writes emails to the application log instead of sending them,
+// for development.
+type LogMailer struct{}
+
+func (LogMailer) Send(ctx context.Context, email Email) error {
+	log.Printf("email to %s: %s\n%s", email.To, email.Subject, email.Body)
+	return nil
+}
+
+// SMTPMailer sends emails through an SMTP relay, authenticating with PLAIN
+// auth when a username is set.
+type SMTPMailer struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+func NewSMTPMailer(addr, username, password, from string) *SMTPMailer {
+	m := &SMTPMailer{addr: addr, from: from}
+	if username != "" {
+		host, _, _ := net.SplitHostPort(addr)
+		m.auth = smtp.PlainAuth("", username, password, host)
+	}
+	return m
+}
+
+// Send delivers email. net/smtp can't be cancelled, so ctx is only checked
+// before connecting.
+func (m *SMTPMailer) Send(ctx context.Context, email Email) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := smtp.SendMail(m.addr, m.auth, m.from, []string{email.To}, formatEmail(m.from, email, time.Now())); err != nil {
+		return fmt.Errorf("failed to send email: %w", err)
+	}
+	return nil
+}
+
+// formatEmail renders email as an RFC 5322 message.
+func formatEmail(from string, email Email, date time.Time) []byte {
+	headers := map[string]string{
+		"From":                      from,
+		"To":                        email.To,
+		"Subject":                   mime.QEncoding.Encode("utf-8", email.Subject),
+		"Date":                      date.Format(time.RFC1123Z),
+		"MIME-Version":              "1.0",
+		"Content-Type":              "text/plain; charset=utf-8",
+		"Content-Transfer-Encoding": "8bit",
+	}
+	for name, value := range email.Headers {
+		headers[name] = value
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&b, "%s: %s\r\n", name, headers[name])
+	}
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(strings.ReplaceAll(email.Body, "\r\n", "\n"), "\n", "\r\n"))
+	return []byte(b.String())
+}
+
+// NewMailer builds the mailer named by MAILER ("log", "smtp" or "none"); it
+// returns nil when email is disabled.
+func NewMailer(kind, addr, username, password, from string) (Mailer, error) {
+	switch kind {
+	case "", "none":
+		return nil, nil
+	case "log":
+		return LogMailer{}, nil
+	case "smtp":
+		if addr == "" || from == "" {
+			return nil, fmt.Errorf("SMTP_ADDR and MAIL_FROM are required for the smtp mailer")
+		}
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return nil, fmt.Errorf("SMTP_ADDR must be host:port: %w", err)
+		}
+		return NewSMTPMailer(addr, username, password, from), nil
+	default:
+		return nil, fmt.Errorf("unknown mailer %q", kind)
+	}
+}
@@ -2,31 +2,129 @@ package main
 
 import (
 	"context"
+	"flag"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 	"github.com/joho/godotenv" // go get github.com/joho/godotenv
 )
 
 func main() {
+	dev := flag.Bool("dev", false, "run for local development: local database defaults, sample data, any origin and the frontend at /")
+	flag.Parse()
+
+	// The environment before .env is loaded, for dev mode restarts
+	baseEnv := os.Environ()
+
 	// Get configuration from environment
 	if _, err := os.Stat(".env"); err == nil {
 		_ = godotenv.Load()
 	}
+	if *dev {
+		applyDevDefaults()
+	}
 	databaseURL := getEnv("DATABASE_URL", "")
 	if databaseURL == "" {
 		log.Fatal("DATABASE_URL environment variable is required")
 	}
+	replicaURLs := getEnv("DATABASE_REPLICA_URLS", "")
 
 	port := getEnv("PORT", "8080")
 	allowedOrigins := getEnv("ALLOWED_ORIGINS","https://sparkling-block-5c5e.jyron-dev.workers.dev")
 	rateLimitRequests := getEnvInt("RATE_LIMIT_REQUESTS", 5)
 	rateLimitWindowMinutes := getEnvInt("RATE_LIMIT_WINDOW_MINUTES", 60)
+	rateLimitAlgorithm := getEnv("RATE_LIMIT_ALGORITHM", "sliding_log")
+	rateLimitBurst := getEnvInt("RATE_LIMIT_BURST", 0)
+	smsRateLimitAlgorithm := getEnv("SMS_RATE_LIMIT_ALGORITHM", rateLimitAlgorithm)
+	smsRateLimitBurst := getEnvInt("SMS_RATE_LIMIT_BURST", rateLimitBurst)
+	postCapGlobal := getEnvInt("POST_CAP_GLOBAL_PER_MINUTE", 0)
+	postCapEvent := getEnvInt("POST_CAP_EVENT_PER_MINUTE", 0)
+	writeQueueSize := getEnvInt("WRITE_QUEUE_SIZE", 0)
+	writeConcurrencyMin := getEnvInt("WRITE_CONCURRENCY_MIN", 2)
+	writeConcurrencyMax := getEnvInt("WRITE_CONCURRENCY_MAX", 0)
+	writeConcurrencyWaitMs := getEnvInt("WRITE_CONCURRENCY_WAIT_MS", 2000)
+	hotEventThreshold := getEnvInt("HOT_EVENT_REQUESTS_PER_MINUTE", 600)
+	routePolicyFile := getEnv("ROUTE_POLICY_FILE", "")
+	inviteOnly := getEnv("INVITE_ONLY", "false") == "true"
+	smsAuthToken := getEnv("SMS_AUTH_TOKEN", "")
+	smsWebhookURL := getEnv("SMS_WEBHOOK_URL", "")
+	stripeWebhookSecret := getEnv("STRIPE_WEBHOOK_SECRET", "")
+	kofiVerificationToken := getEnv("KOFI_VERIFICATION_TOKEN", "")
+	postHookURLs := getEnv("POST_HOOK_URLS", "")
+	postHookSecret := getEnv("POST_HOOK_SECRET", "")
+	postHookTimeoutMs := getEnvInt("POST_HOOK_TIMEOUT_MS", 2000)
+	federationBaseURL := getEnv("FEDERATION_BASE_URL", "")
+	federationDeliveryWorkers := getEnvInt("FEDERATION_DELIVERY_WORKERS", 8)
+	adminToken := getEnv("ADMIN_TOKEN", "")
+	meteringSink := getEnv("METERING_SINK", "log")
+	meteringSinkURL := getEnv("METERING_SINK_URL", "")
+	pubsubRelay := getEnv("PUBSUB_RELAY", "none")
+	clientErrorSink := getEnv("CLIENT_ERROR_SINK", "log")
+	clientErrorSinkURL := getEnv("CLIENT_ERROR_SINK_URL", "")
+	clientErrorSamplePercent := getEnvInt("CLIENT_ERROR_SAMPLE_PERCENT", 10)
+	clientErrorRateLimit := getEnvInt("CLIENT_ERROR_RATE_LIMIT", 10)
+	commentRateLimit := getEnvInt("COMMENT_RATE_LIMIT_PER_HOUR", 20)
+	storyRateLimit := getEnvInt("STORY_RATE_LIMIT_PER_HOUR", 3)
+	retentionClasses := getEnv("RETENTION_CLASSES", "festival:90,conference:365,campus:30")
+	retentionDefaultClass := getEnv("RETENTION_DEFAULT_CLASS", "")
+	termsVersion := getEnv("TERMS_VERSION", "")
+	termsText := getEnv("TERMS_TEXT", "")
+	termsFile := getEnv("TERMS_FILE", "")
+	translationProvider := getEnv("TRANSLATION_PROVIDER", "")
+	translationURL := getEnv("TRANSLATION_URL", "")
+	translationAPIKey := getEnv("TRANSLATION_API_KEY", "")
+	ttsProvider := getEnv("TTS_PROVIDER", "")
+	ttsAPIKey := getEnv("TTS_API_KEY", "")
+	ttsVoice := getEnv("TTS_VOICE", "")
+	ttsMaxSeconds := getEnvInt("TTS_MAX_SECONDS", 120)
+	ttsMaxBytes := getEnvInt("TTS_MAX_BYTES", 2<<20)
+	ttsRateLimit := getEnvInt("TTS_RATE_LIMIT_PER_HOUR", 20)
+	mediaDir := getEnv("MEDIA_DIR", "media")
+	mediaMaxUploadBytes := getEnvInt("MEDIA_MAX_UPLOAD_BYTES", 5<<20)
+	mediaURLSecret := getEnv("MEDIA_URL_SECRET", "")
+	mediaURLTTLMinutes := getEnvInt("MEDIA_URL_TTL_MINUTES", 15)
+	editWindowMinutes := getEnvInt("EDIT_WINDOW_MINUTES", 15)
+	powDifficulty := getEnvInt("POW_DIFFICULTY", 0)
+	powMaxDifficulty := getEnvInt("POW_MAX_DIFFICULTY", 24)
+	powLoadThreshold := getEnvInt("POW_LOAD_THRESHOLD_PER_MINUTE", 0)
+	powSecret := getEnv("POW_SECRET", "")
+	requireAltText := getEnv("REQUIRE_ALT_TEXT", "false") == "true"
+	legacyPostIDs := getEnv("LEGACY_POST_IDS", "true") == "true"
+	archiveS3Endpoint := getEnv("ARCHIVE_S3_ENDPOINT", "https://s3.amazonaws.com")
+	archiveS3Region := getEnv("ARCHIVE_S3_REGION", "us-east-1")
+	archiveS3Bucket := getEnv("ARCHIVE_S3_BUCKET", "")
+	archiveS3AccessKey := getEnv("ARCHIVE_S3_ACCESS_KEY", "")
+	archiveS3SecretKey := getEnv("ARCHIVE_S3_SECRET_KEY", "")
+	archiveAfterDays := getEnvInt("ARCHIVE_AFTER_DAYS", 30)
+	archiveDir := getEnv("ARCHIVE_DIR", "")
+	archiveCacheBoards := getEnvInt("ARCHIVE_CACHE_BOARDS", 20)
+	recorderSize := getEnvInt("RECORDER_SIZE", 200)
+	recorderEnabled := getEnv("RECORDER_ENABLED", "false") == "true"
+	captionProvider := getEnv("CAPTION_PROVIDER", "")
+	captionURL := getEnv("CAPTION_URL", "")
+	captionAPIKey := getEnv("CAPTION_API_KEY", "")
+	virusScannerKind := getEnv("VIRUS_SCANNER", "")
+	virusScannerAddr := getEnv("VIRUS_SCANNER_ADDR", "")
+	toxicityProvider := getEnv("TOXICITY_PROVIDER", "")
+	toxicityURL := getEnv("TOXICITY_URL", "")
+	toxicityAPIKey := getEnv("TOXICITY_API_KEY", "")
+	toxicityHideThreshold := getEnvFloat("TOXICITY_HIDE_THRESHOLD", 0.9)
+	toxicityLabelThreshold := getEnvFloat("TOXICITY_LABEL_THRESHOLD", 0.7)
+	draftTTLHours := getEnvInt("DRAFT_TTL_HOURS", 72)
+	draftMaxBytes := getEnvInt("DRAFT_MAX_BYTES", 16<<10)
+	chaosRules := getEnv("CHAOS_RULES", "")
+	mailerKind := getEnv("MAILER", "none")
+	smtpAddr := getEnv("SMTP_ADDR", "")
+	smtpUsername := getEnv("SMTP_USERNAME", "")
+	smtpPassword := getEnv("SMTP_PASSWORD", "")
+	mailFrom := getEnv("MAIL_FROM", "")
+	publicURL := getEnv("PUBLIC_URL", federationBaseURL)
 
 	// Connect to database
 	db, err := NewDB(databaseURL)
@@ -35,51 +133,682 @@ func main() {
 	}
 	defer db.Close()
 	runMigrations(db) 
+	if replicaURLs != "" {
+		if err := db.ConnectReplicas(strings.Split(replicaURLs, ",")); err != nil {
+			log.Fatalf("Failed to connect to replicas: %v", err)
+		}
+	}
+	if *dev {
+		if err := seedDevData(context.Background(), db); err != nil {
+			log.Fatalf("Failed to seed dev data: %v", err)
+		}
+	}
 
-	// Initialize handlers
-	h := NewHandler(db)
+	// Federation is only enabled when the public base URL is configured
+	var federation *Federation
+	if federationBaseURL != "" {
+		federation, err = NewFederation(db, federationBaseURL, federationDeliveryWorkers, legacyPostIDs)
+		if err != nil {
+			log.Fatalf("Failed to initialize federation: %v", err)
+		}
+	}
 
-	// Initialize rate limiter
-	rateLimiter := NewRateLimiter(db, rateLimitRequests, rateLimitWindowMinutes)
+	retention, err := ParseRetentionPolicy(retentionClasses, retentionDefaultClass)
+	if err != nil {
+		log.Fatalf("Invalid retention configuration: %v", err)
+	}
 
-	// Setup router
-	mux := http.NewServeMux()
+	terms, err := LoadTerms(termsVersion, termsText, termsFile)
+	if err != nil {
+		log.Fatalf("Invalid terms configuration: %v", err)
+	}
 
-	// Wrap handlers with middleware
-	mux.HandleFunc("/api/posts", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method == "GET" {
-			h.GetPosts(w, r)
-		} else if r.Method == "POST" {
-			h.CreatePost(w, r)
-		} else if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
-		} else {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	translator, err := NewTranslator(translationProvider, translationURL, translationAPIKey)
+	if err != nil {
+		log.Fatalf("Invalid translation configuration: %v", err)
+	}
+
+	synth, err := NewSpeechSynthesizer(ttsProvider, ttsAPIKey, ttsVoice)
+	if err != nil {
+		log.Fatalf("Invalid TTS configuration: %v", err)
+	}
+
+	media, err := NewLocalMediaStore(mediaDir)
+	if err != nil {
+		log.Fatalf("Failed to initialize media storage: %v", err)
+	}
+
+	// Without a configured secret, media URLs stop working on restart and
+	// only work on the server that issued them
+	if mediaURLSecret == "" {
+		log.Printf("MEDIA_URL_SECRET is not set; using a random secret for this process")
+		mediaURLSecret = randomToken(32)
+	}
+	if mediaURLTTLMinutes < 2 {
+		log.Fatalf("MEDIA_URL_TTL_MINUTES must be at least 2")
+	}
+	mediaURLs := NewMediaURLSigner([]byte(mediaURLSecret), time.Duration(mediaURLTTLMinutes)*time.Minute)
+
+	if editWindowMinutes < 0 {
+		log.Fatalf("EDIT_WINDOW_MINUTES must not be negative")
+	}
+
+	// Proof of work is only required when a difficulty is set. Like media
+	// URLs, challenges need a shared secret to work across servers
+	if powDifficulty != 0 && powSecret == "" {
+		log.Printf("POW_SECRET is not set; using a random secret for this process")
+		powSecret = randomToken(32)
+	}
+	proofOfWork, err := NewProofOfWork([]byte(powSecret), powDifficulty, powMaxDifficulty, powLoadThreshold)
+	if err != nil {
+		log.Fatalf("Invalid proof of work configuration: %v", err)
+	}
+
+	// Closed boards are only archived when a bucket or directory is
+	// configured
+	var archiveStore MediaStore
+	if archiveS3Bucket != "" {
+		s3, err := NewS3Store(archiveS3Endpoint, archiveS3Region, archiveS3Bucket, archiveS3AccessKey, archiveS3SecretKey)
+		if err != nil {
+			log.Fatalf("Invalid archive configuration: %v", err)
 		}
+		archiveStore = s3
+	} else if archiveDir != "" {
+		dir, err := NewLocalMediaStore(archiveDir)
+		if err != nil {
+			log.Fatalf("Failed to initialize archive storage: %v", err)
+		}
+		archiveStore = dir
+	}
+	if archiveAfterDays < 1 {
+		log.Fatalf("ARCHIVE_AFTER_DAYS must be at least 1")
+	}
+
+	captioner, err := NewCaptioner(captionProvider, captionURL, captionAPIKey)
+	if err != nil {
+		log.Fatalf("Invalid caption configuration: %v", err)
+	}
+
+	virusScanner, err := NewVirusScanner(virusScannerKind, virusScannerAddr)
+	if err != nil {
+		log.Fatalf("Invalid virus scanner configuration: %v", err)
+	}
+
+	toxicityScorer, err := NewToxicityScorer(toxicityProvider, toxicityURL, toxicityAPIKey)
+	if err != nil {
+		log.Fatalf("Invalid toxicity configuration: %v", err)
+	}
+	toxicityDefaults := ToxicityThresholds{Hide: &toxicityHideThreshold, Label: &toxicityLabelThreshold}
+	if err := toxicityDefaults.validate(); err != nil {
+		log.Fatalf("Invalid toxicity configuration: %v", err)
+	}
+
+	// Fault injection is for staging; never set CHAOS_RULES in production
+	chaos, err := ParseChaosRules(chaosRules)
+	if err != nil {
+		log.Fatalf("Invalid chaos configuration: %v", err)
+	}
+	if chaos != nil {
+		log.Printf("WARNING: chaos fault injection is enabled: %s", chaosRules)
+	}
+
+	// Email alerts are only enabled when a mailer is configured
+	mailer, err := NewMailer(mailerKind, smtpAddr, smtpUsername, smtpPassword, mailFrom)
+	if err != nil {
+		log.Fatalf("Failed to initialize mailer: %v", err)
+	}
+	if mailer != nil && publicURL == "" {
+		log.Fatal("PUBLIC_URL is required for email alerts")
+	}
+
+	// Background workers stop when the server shuts down
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	var workers sync.WaitGroup
+
+	// Alt text backfill is only available when a caption provider is configured
+	var altTextBackfill *AltTextBackfill
+	if captioner != nil {
+		altTextBackfill = NewAltTextBackfill(workerCtx, db, media, captioner)
+	}
+
+	// Toxicity scoring is only enabled when a provider is configured
+	toxicity := NewToxicity(db, toxicityScorer, toxicityDefaults)
+
+	// Posting caps are only enabled when at least one is configured
+	caps := NewPostingCaps(postCapGlobal, postCapEvent)
+	// The write concurrency limit is only enabled when its ceiling is set
+	writeLimiter := NewConcurrencyLimiter(writeConcurrencyMin, writeConcurrencyMax, time.Duration(writeConcurrencyWaitMs)*time.Millisecond)
+	hotTier := NewHotTier(hotEventThreshold)
+
+	// Initialize rate limiters; web and SMS posts are limited separately
+	rateLimiter, err := NewRateLimiter(db, "posts", RateLimitPolicy{
+		Algorithm:     rateLimitAlgorithm,
+		Requests:      rateLimitRequests,
+		WindowMinutes: rateLimitWindowMinutes,
+		Burst:         rateLimitBurst,
+	})
+	if err != nil {
+		log.Fatalf("Invalid rate limit configuration: %v", err)
+	}
+	smsLimiter, err := NewRateLimiter(db, "sms", RateLimitPolicy{
+		Algorithm:     smsRateLimitAlgorithm,
+		Requests:      rateLimitRequests,
+		WindowMinutes: rateLimitWindowMinutes,
+		Burst:         smsRateLimitBurst,
 	})
+	if err != nil {
+		log.Fatalf("Invalid SMS rate limit configuration: %v", err)
+	}
+	// Client error reports get their own, much tighter limit
+	clientErrorLimiter, err := NewRateLimiter(db, "client_errors", RateLimitPolicy{
+		Algorithm:     rateLimitAlgorithm,
+		Requests:      clientErrorRateLimit,
+		WindowMinutes: 60,
+	})
+	if err != nil {
+		log.Fatalf("Invalid client error rate limit configuration: %v", err)
+	}
+	// Comments are shorter and more frequent than posts, so have their own
+	commentLimiter, err := NewRateLimiter(db, "comments", RateLimitPolicy{
+		Algorithm:     rateLimitAlgorithm,
+		Requests:      commentRateLimit,
+		WindowMinutes: 60,
+	})
+	if err != nil {
+		log.Fatalf("Invalid comment rate limit configuration: %v", err)
+	}
+	// Stories are long and rare, so a poster's limit shouldn't be spent on them
+	storyLimiter, err := NewRateLimiter(db, "stories", RateLimitPolicy{
+		Algorithm:     rateLimitAlgorithm,
+		Requests:      storyRateLimit,
+		WindowMinutes: 60,
+	})
+	if err != nil {
+		log.Fatalf("Invalid story rate limit configuration: %v", err)
+	}
+	rateLimiters := []*RateLimiter{rateLimiter, smsLimiter, clientErrorLimiter, commentLimiter, storyLimiter}
 
-	mux.HandleFunc("/api/events", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method == "GET" {
-			h.GetEvents(w, r)
-		} else if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
-		} else {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	// Operators tune routes' timeouts, caching, limits and auth in a file
+	routePolicies, err := LoadRoutePolicies(routePolicyFile, adminToken, rateLimiters)
+	if err != nil {
+		log.Fatalf("Invalid route policy configuration: %v", err)
+	}
+
+	// Request metrics feed the public status page and the admin dashboard
+	metrics := NewMetrics()
+
+	// The flight recorder is off until an admin turns it on, unless
+	// RECORDER_ENABLED is set
+	if recorderSize < 0 {
+		log.Fatalf("RECORDER_SIZE must not be negative")
+	}
+	recorder := NewRecorder(recorderSize, RecorderSettings{Enabled: recorderEnabled})
+
+	validationStats := NewValidationStats(db)
+	impressions := NewPromotionImpressions(db)
+
+	// Hooks built in register themselves; HTTP hooks are added after them
+	hookList := append([]PostHook(nil), registeredPostHooks...)
+	for _, endpoint := range strings.Split(postHookURLs, ",") {
+		if endpoint = strings.TrimSpace(endpoint); endpoint == "" {
+			continue
 		}
+		hook, err := NewHTTPPostHook(endpoint, postHookSecret)
+		if err != nil {
+			log.Fatalf("Invalid post hook configuration: %v", err)
+		}
+		hookList = append(hookList, hook)
+	}
+	postHooks := NewPostHooks(db, hookList, time.Duration(postHookTimeoutMs)*time.Millisecond)
+
+	// Wakes long-polls when a post is published, relayed between replicas
+	// when there are several
+	relay, err := NewPostRelay(pubsubRelay, db)
+	if err != nil {
+		log.Fatalf("Failed to initialize pub/sub: %v", err)
+	}
+	broker := NewPostBroker(relay)
+	if relay, ok := relay.(*PostgresRelay); ok {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			relay.Run(workerCtx, broker.wake)
+		}()
+	}
+
+	// Background jobs, which admins can also run on demand
+	retentionJob := NewRetentionJob(db, retention)
+	mediaJanitor := NewMediaJanitor(db, media)
+	renditionJob := NewRenditionJob(db, media)
+	archiver := NewArchiver(db, archiveStore, time.Duration(archiveAfterDays)*24*time.Hour)
+	jobs := NewJobs(workerCtx)
+	jobs.Register("retention", retentionJob.enforce)
+	jobs.Register("media-janitor", mediaJanitor.clean)
+	jobs.Register("renditions", renditionJob.processPending)
+	if archiver != nil {
+		jobs.Register("archive", archiver.archiveEnded)
+	}
+	alertJob := NewAlertJob(db, mailer, publicURL)
+	if alertJob != nil {
+		jobs.Register("alerts", alertJob.send)
+	}
+	counterJob := NewCounterJob(db)
+	jobs.Register("counters", counterJob.reconcile)
+	publicStats := NewPublicStats(db)
+	jobs.Register("public-stats", publicStats.rollup)
+
+	// Initialize handlers
+	h := NewHandler(db, federation, HandlerConfig{
+		AdminToken:      adminToken,
+		Retention:       retention,
+		Terms:           terms,
+		Translator:      translator,
+		Media:           media,
+		MaxUploadBytes:  mediaMaxUploadBytes,
+		RequireAltText:  requireAltText,
+		InviteOnly:      inviteOnly,
+		AltTextBackfill: altTextBackfill,
+		Caps:            caps,
+		WriteLimiter:    writeLimiter,
+		HotTier:         hotTier,
+		WriteQueue:      NewWriteQueue(writeQueueSize),
+		Toxicity:        toxicity,
+		ScanUploads:     virusScanner != nil,
+		MediaURLs:       mediaURLs,
+		LegacyPostIDs:   legacyPostIDs,
+		Archive:         NewArchiveReader(archiveStore, archiveCacheBoards),
+		Jobs:            jobs,
+		RateLimiters:    rateLimiters,
+		Metrics:         metrics,
+		Recorder:        recorder,
+		ValidationStats: validationStats,
+		Impressions:     impressions,
+		Hooks:           postHooks,
+		Broker:          broker,
+		Mailer:          mailer,
+		PublicURL:       publicURL,
+		ProofOfWork:     proofOfWork,
+		EditWindow:      time.Duration(editWindowMinutes) * time.Minute,
 	})
 
+	// Initialize API key authentication and usage metering
+	apiKeys := NewAPIKeyAuth(db)
+	usageSink, err := NewUsageSink(meteringSink, meteringSinkURL)
+	if err != nil {
+		log.Fatalf("Failed to initialize metering: %v", err)
+	}
+	meter := NewMeter(db, usageSink)
+	verifier := NewRequestVerifier()
+
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		meter.Run(workerCtx)
+	}()
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		validationStats.Run(workerCtx)
+	}()
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		impressions.Run(workerCtx)
+	}()
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		postHooks.Run(workerCtx)
+	}()
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		retentionJob.Run(workerCtx)
+	}()
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		mediaJanitor.Run(workerCtx)
+	}()
+	if virusScanner != nil {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			NewUploadScanner(db, media, virusScanner).Run(workerCtx)
+		}()
+	}
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		renditionJob.Run(workerCtx)
+	}()
+	if archiver != nil {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			archiver.Run(workerCtx)
+		}()
+	}
+	if alertJob != nil {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			alertJob.Run(workerCtx)
+		}()
+	}
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		counterJob.Run(workerCtx)
+	}()
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		publicStats.Run(workerCtx)
+	}()
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		hotTier.Run(workerCtx)
+	}()
+	if federation != nil {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			federation.Run(workerCtx)
+		}()
+	}
+	drafts := NewDrafts(db, time.Duration(draftTTLHours)*time.Hour, draftMaxBytes)
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		drafts.Run(workerCtx)
+	}()
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		h.RunWriteQueue(workerCtx)
+	}()
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		toxicity.Run(workerCtx)
+	}()
+
+	for _, limiter := range rateLimiters {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			limiter.Run(workerCtx)
+		}()
+	}
+
+	// Setup router
+	mux := http.NewServeMux()
+
+	// Wrap handlers with middleware
+	mux.Handle("/api/posts", rateLimiter.Limit(writeLimiter.Limit(methods{"GET": h.GetPosts, "POST": h.CreatePost})))
+
+	errorSink, err := NewClientErrorSink(clientErrorSink, clientErrorSinkURL)
+	if err != nil {
+		log.Fatalf("Failed to initialize client error reporting: %v", err)
+	}
+	clientErrors := NewClientErrors(errorSink, clientErrorSamplePercent)
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		clientErrors.Run(workerCtx)
+	}()
+	mux.Handle("/api/client-errors", clientErrorLimiter.Limit(methods{"POST": clientErrors.Report}))
+
+	// SMS posting is only enabled when the provider's auth token is configured
+	if smsAuthToken != "" {
+		sms := NewSMSGateway(db, federation, smsAuthToken, smsWebhookURL, smsLimiter, caps, toxicity, broker, postHooks, hotTier, inviteOnly)
+		mux.Handle("/api/sms/inbound", writeLimiter.Limit(methods{"POST": sms.Inbound}))
+	}
+
+	// Each donation provider's webhook is only enabled when its secret is
+	// configured; supporter counts are always served
+	donations := NewDonations(db, stripeWebhookSecret, kofiVerificationToken)
+	if stripeWebhookSecret != "" {
+		mux.Handle("/api/donations/stripe", methods{"POST": donations.Stripe})
+	}
+	if kofiVerificationToken != "" {
+		mux.Handle("/api/donations/kofi", methods{"POST": donations.Kofi})
+	}
+	mux.Handle("/api/supporters", methods{"GET": donations.GetSupporters})
+	mux.Handle("/api/me/supporter", methods{"GET": donations.GetMySupporter})
+
+	mux.Handle("/api/events", methods{"GET": h.GetEvents})
+
+	if federation != nil {
+		mux.Handle("/.well-known/webfinger", methods{"GET": federation.Webfinger})
+
+		mux.Handle("/ap/events/{handle}", methods{"GET": federation.Actor})
+
+		mux.Handle("/ap/events/{handle}/outbox", methods{"GET": federation.Outbox})
+
+		mux.Handle("/ap/events/{handle}/followers", methods{"GET": federation.Followers})
+
+		mux.Handle("/ap/events/{handle}/inbox", methods{"POST": federation.Inbox})
+
+		mux.Handle("/ap/posts/{id}", methods{"GET": federation.Note})
+	}
+
+	mux.Handle("/api/posts/poll", methods{"GET": h.PollPosts})
+
+	mux.Handle("/api/posts/preview", methods{"POST": h.PreviewPost})
+
+	mux.Handle("/api/pow/challenge", methods{"GET": h.GetPowChallenge})
+
+	// Not /api/posts/status/{token}, which would conflict with /api/posts/{id}/...
+	mux.Handle("/api/post-status/{token}", methods{"GET": h.GetPostStatus})
+
+	mux.Handle("/api/posts/{id}", writeLimiter.Limit(h.withPost(methods{"GET": h.GetPost, "PATCH": h.EditPost, "DELETE": h.DeletePost}.ServeHTTP)))
+
+	mux.Handle("/api/posts/{id}/appeal", writeLimiter.Limit(h.withPost(methods{"POST": h.AppealPost}.ServeHTTP)))
+
+	mux.Handle("/api/posts/{id}/react", writeLimiter.Limit(h.withPost(methods{"POST": h.ReactToPost}.ServeHTTP)))
+
+	mux.Handle("/api/posts/{id}/comments", commentLimiter.Limit(writeLimiter.Limit(h.withPost(methods{"GET": h.GetComments, "POST": h.CreateComment}.ServeHTTP))))
+
+	mux.Handle("/api/stories", storyLimiter.Limit(writeLimiter.Limit(methods{"GET": h.GetStories, "POST": h.SubmitStory})))
+
+	// Earlier versions of edited posts are for moderators only
+	mux.Handle("/api/posts/{id}/revisions", AdminAuth(h.withPost(methods{"GET": h.GetPostRevisions}.ServeHTTP), adminToken))
+
+	mux.Handle("/api/me/draft", methods{"GET": drafts.Get, "PUT": drafts.Put, "DELETE": drafts.Delete})
+
+	mux.Handle("/api/me/counts", methods{"GET": h.GetMyCounts})
+
+	mux.Handle("/api/me/counts/read", methods{"POST": h.MarkMyRepliesRead})
+
+	mux.Handle("/api/me/notifications", methods{"GET": h.GetMyNotifications})
+
+	mux.Handle("/api/me/notifications/read", methods{"POST": h.MarkMyNotificationsRead})
+
+	mux.Handle("/api/me/preferences", methods{"GET": h.GetMyPreferences, "PUT": h.PutMyPreferences})
+
+	mux.Handle("/api/alerts", methods{"POST": h.CreateAlert})
+
+	mux.Handle("/api/alerts/confirm", methods{"GET": h.ConfirmAlert})
+
+	mux.Handle("/api/alerts/unsubscribe", methods{"GET": h.Unsubscribe, "POST": h.Unsubscribe})
+
+	mux.Handle("/api/attachments", writeLimiter.Limit(methods{"POST": h.UploadAttachment}))
+
+	mux.Handle("/media/{key}", methods{"GET": h.ServeMedia})
+
+	mux.HandleFunc("/api/posts/{id}/translate", h.withPost(methods{"GET": h.GetPostTranslation}.ServeHTTP))
+
+	// Post audio is only enabled when a TTS provider is configured
+	if synth != nil {
+		audio := NewPostAudio(db, synth, ttsMaxSeconds, ttsMaxBytes, ttsRateLimit)
+		mux.HandleFunc("/api/posts/{id}/audio", h.withPost(methods{"GET": audio.Get}.ServeHTTP))
+	}
+
+	mux.Handle("/api/discover", methods{"GET": h.Discover})
+
+	mux.Handle("/api/events/nearby", methods{"GET": h.GetNearbyEvents})
+
+	mux.HandleFunc("/api/events/{event}", h.withEvent(methods{"GET": h.GetEvent}.ServeHTTP))
+
+	mux.HandleFunc("/api/events/{event}/calendar.ics", h.withEvent(methods{"GET": h.GetEventCalendar}.ServeHTTP))
+
+	mux.Handle("/api/avatars/{file}", methods{"GET": h.GetAvatar})
+
+	mux.HandleFunc("/api/events/{event}/archive", h.withEvent(methods{"GET": h.GetEventArchive}.ServeHTTP))
+
+	mux.HandleFunc("/archive/{event}", h.withEvent(methods{"GET": h.GetEventArchivePage}.ServeHTTP))
+
+	mux.HandleFunc("/embed/events/{event}", h.withEvent(methods{"GET": h.GetEventEmbed}.ServeHTTP))
+
+	mux.HandleFunc("/api/events/{event}/sessions", h.withEvent(methods{"GET": h.GetSessions}.ServeHTTP))
+
+	mux.HandleFunc("/api/events/{event}/templates", h.withEvent(methods{"GET": h.GetPostTemplates}.ServeHTTP))
+
+	mux.HandleFunc("/api/events/{event}/fields", h.withEvent(methods{"GET": h.GetCustomFields}.ServeHTTP))
+
+	mux.Handle("/api/terms", methods{"GET": h.GetTerms})
+
+	mux.Handle("/api/openapi.json", methods{"GET": h.GetOpenAPISpec})
+
+	mux.Handle("/api/keys/{id}/usage", methods{"GET": h.GetAPIKeyUsage})
+
+	// Admin routes
+	mux.Handle("/admin/keys", AdminAuth(methods{"GET": h.ListAPIKeys, "POST": h.CreateAPIKey}, adminToken))
+
+	mux.Handle("/admin/keys/{id}", AdminAuth(methods{"DELETE": h.RevokeAPIKey}, adminToken))
+
+	mux.Handle("/admin/events/{event}", AdminAuth(h.withEvent(methods{"PATCH": h.UpdateEventSettings}.ServeHTTP), adminToken))
+
+	mux.Handle("/admin/moderation/queue", AdminAuth(methods{"GET": h.GetModerationQueue}, adminToken))
+
+	mux.Handle("/admin/moderation/queue/{id}/resolve", AdminAuth(methods{"POST": h.ResolveModerationItem}, adminToken))
+
+	mux.Handle("/admin/appeals/{id}/resolve", AdminAuth(methods{"POST": h.ResolveAppeal}, adminToken))
+
+	mux.Handle("/admin/stories", AdminAuth(methods{"GET": h.GetStoryQueue}, adminToken))
+
+	mux.Handle("/admin/stories/{id}/review", AdminAuth(methods{"POST": h.ReviewStory}, adminToken))
+
+	mux.Handle("/admin/banned-images", AdminAuth(methods{"GET": h.GetBannedImages, "POST": h.CreateBannedImage}, adminToken))
+
+	mux.Handle("/admin/banned-images/{id}", AdminAuth(methods{"DELETE": h.DeleteBannedImage}, adminToken))
+
+	mux.Handle("/admin/removal-reasons", AdminAuth(methods{"GET": h.GetRemovalReasons, "POST": h.CreateRemovalReason}, adminToken))
+
+	mux.Handle("/admin/removal-reasons/{id}", AdminAuth(methods{"PUT": h.UpdateRemovalReason, "DELETE": h.ArchiveRemovalReason}, adminToken))
+
+	mux.Handle("/admin/invite-codes", AdminAuth(methods{"GET": h.GetInviteCodes, "POST": h.CreateInviteCode}, adminToken))
+
+	mux.Handle("/admin/invite-codes/{id}", AdminAuth(methods{"PATCH": h.UpdateInviteCode, "DELETE": h.RevokeInviteCode}, adminToken))
+
+	mux.Handle("/admin/posts/bulk", AdminAuth(methods{"POST": h.BulkModeratePosts}, adminToken))
+
+	mux.Handle("/admin/posts/rebuild-counters", AdminAuth(methods{"POST": h.RebuildPostCounters}, adminToken))
+
+	mux.Handle("/admin/posts/{id}/context", AdminAuth(methods{"GET": h.GetPostContext}, adminToken))
+
+	mux.Handle("/admin/posts/{id}/events", AdminAuth(methods{"GET": h.GetPostEvents}, adminToken))
+
+	mux.Handle("/admin/posts/{id}/content-warning", AdminAuth(methods{"PUT": h.SetContentWarning}, adminToken))
+
+	mux.Handle("/admin/events/{event}/checkin-codes", AdminAuth(h.withEvent(methods{"GET": h.GetCheckinCodes, "POST": h.CreateCheckinCode}.ServeHTTP), adminToken))
+
+	mux.Handle("/admin/events/{event}/checkin-codes/{id}", AdminAuth(h.withEvent(methods{"DELETE": h.RevokeCheckinCode}.ServeHTTP), adminToken))
+
+	mux.Handle("/admin/events/{event}/promotions", AdminAuth(h.withEvent(methods{"GET": h.GetPromotions, "POST": h.CreatePromotion}.ServeHTTP), adminToken))
+
+	mux.Handle("/admin/events/{event}/promotions/{id}", AdminAuth(h.withEvent(methods{"DELETE": h.EndPromotion}.ServeHTTP), adminToken))
+
+	mux.Handle("/admin/events/{event}/toxicity", AdminAuth(h.withEvent(methods{"GET": h.GetEventToxicity, "PUT": h.SetEventToxicity}.ServeHTTP), adminToken))
+
+	mux.Handle("/admin/toxicity/precision", AdminAuth(methods{"GET": h.GetToxicityPrecision}, adminToken))
+
+	mux.Handle("/admin/events/{event}/details", AdminAuth(h.withEvent(methods{"PUT": h.SetEventDetails}.ServeHTTP), adminToken))
+
+	mux.Handle("/admin/events/{event}/theme", AdminAuth(h.withEvent(methods{"PUT": h.SetEventTheme}.ServeHTTP), adminToken))
+
+	mux.Handle("/admin/events/{event}/embed-domains", AdminAuth(h.withEvent(methods{"PUT": h.SetEmbedDomains}.ServeHTTP), adminToken))
+
+	mux.Handle("/admin/events/{event}/rename", AdminAuth(h.withEvent(methods{"POST": h.RenameEvent}.ServeHTTP), adminToken))
+
+	mux.Handle("/admin/events/{event}/merge", AdminAuth(h.withEvent(methods{"POST": h.MergeEvent}.ServeHTTP), adminToken))
+
+	mux.Handle("/admin/events/{event}/aliases", AdminAuth(h.withEvent(methods{"GET": h.GetEventAliases}.ServeHTTP), adminToken))
+
+	mux.Handle("/admin/events/{event}/sessions", AdminAuth(h.withEvent(methods{"POST": h.CreateSession}.ServeHTTP), adminToken))
+
+	mux.Handle("/admin/events/{event}/sessions/{id}", AdminAuth(h.withEvent(methods{"DELETE": h.DeleteSession}.ServeHTTP), adminToken))
+
+	mux.Handle("/admin/events/{event}/templates", AdminAuth(h.withEvent(methods{"POST": h.CreatePostTemplate}.ServeHTTP), adminToken))
+
+	mux.Handle("/admin/events/{event}/templates/{id}", AdminAuth(h.withEvent(methods{"DELETE": h.ArchivePostTemplate}.ServeHTTP), adminToken))
+
+	mux.Handle("/admin/events/{event}/fields", AdminAuth(h.withEvent(methods{"PUT": h.SetCustomFields}.ServeHTTP), adminToken))
+
+	mux.Handle("/admin/events/{event}/posting-window", AdminAuth(h.withEvent(methods{"PUT": h.SetPostingWindow}.ServeHTTP), adminToken))
+
+	mux.Handle("/admin/events/{event}/retention", AdminAuth(h.withEvent(methods{"PUT": h.SetEventRetentionClass}.ServeHTTP), adminToken))
+
+	mux.Handle("/admin/legal-holds", AdminAuth(methods{"GET": h.ListLegalHolds, "POST": h.CreateLegalHold}, adminToken))
+
+	mux.Handle("/admin/legal-holds/{id}", AdminAuth(methods{"DELETE": h.ReleaseLegalHold}, adminToken))
+
+	mux.Handle("/admin/alt-text-backfill", AdminAuth(methods{"GET": h.GetAltTextBackfill, "POST": h.StartAltTextBackfill}, adminToken))
+
+	mux.Handle("/admin/bans", AdminAuth(methods{"GET": h.GetAuthorBans, "POST": h.CreateAuthorBan}, adminToken))
+
+	mux.Handle("/admin/bans/{hash}", AdminAuth(methods{"DELETE": h.DeleteAuthorBan}, adminToken))
+
+	mux.Handle("/admin/jobs", AdminAuth(methods{"GET": h.GetJobs}, adminToken))
+
+	mux.Handle("/admin/jobs/{name}", AdminAuth(methods{"POST": h.StartJob}, adminToken))
+
+	mux.Handle("/admin/rate-limits", AdminAuth(methods{"GET": h.GetRateLimits}, adminToken))
+
+	mux.Handle("/admin/rate-limits/violations", AdminAuth(methods{"DELETE": h.ClearRateViolations}, adminToken))
+
+	mux.Handle("/admin/metrics", AdminAuth(methods{"GET": h.GetMetrics}, adminToken))
+
+	mux.Handle("/admin/recorder", AdminAuth(methods{"GET": h.GetRecorder, "PUT": h.UpdateRecorder, "DELETE": h.ClearRecorder}, adminToken))
+
+	mux.Handle("/admin/stats/validation", AdminAuth(methods{"GET": h.GetValidationStats}, adminToken))
+
+	mux.Handle("/admin/audit-log", AdminAuth(methods{"GET": h.GetAuditLog}, adminToken))
+	mux.Handle("/admin/dlq", AdminAuth(methods{"GET": h.GetDeadLetters}, adminToken))
+	mux.Handle("/admin/dlq/redrive", AdminAuth(methods{"POST": h.RedriveDeadLetters}, adminToken))
+
+	statusPage := NewStatusPage(db, metrics, h.cfg.WriteQueue)
+	mux.Handle("/api/status", methods{"GET": statusPage.Get})
+	mux.Handle("/api/public-stats", methods{"GET": publicStats.Get})
+
 	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("OK"))
 	})
 
+	// Load balancers should route by /readyz, which waits for warming
+	readiness := NewReadiness(db)
+	mux.Handle("/readyz", methods{"GET": readiness.Get})
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		readiness.WarmUp(workerCtx, h.Warm)
+	}()
+
+	if *dev {
+		mux.Handle("/", devFrontend(devFrontendDir))
+	}
+
 	// Chain middleware
-	handler := LoggingMiddleware(
+	handler := LoggingMiddleware(metrics.Middleware(recorder.Middleware(
 		CORSMiddleware(
-			rateLimiter.Limit(mux),
+			ProblemDetails(chaos.Middleware(apiKeys.Authenticate(verifier.Verify(meter.Middleware(ReadYourWrites(routePolicies.Middleware(mux), db))))), publicURL),
 			parseOrigins(allowedOrigins),
 		),
-	)
+	)))
 
 	// Setup server
 	srv := &http.Server{
@@ -101,6 +830,17 @@ func main() {
 	// Wait for interrupt signal to gracefully shut down the server
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+
+	// In dev mode, editing .env restarts the server with the new config
+	restart := false
+	if *dev {
+		log.Printf("Dev mode: frontend at http://localhost:%s/, admin token %q", port, adminToken)
+		watchDevConfig(workerCtx, devConfigFile, func() {
+			log.Printf("%s changed; restarting", devConfigFile)
+			restart = true
+			quit <- syscall.SIGHUP
+		})
+	}
 	<-quit
 
 	log.Println("Shutting down server...")
@@ -113,7 +853,14 @@ func main() {
 		log.Fatalf("Server forced to shutdown: %v", err)
 	}
 
+	stopWorkers()
+	workers.Wait()
+
 	log.Println("Server stopped")
+
+	if restart {
+		restartSelf(baseEnv)
+	}
 }
 
 func getEnv(key, defaultValue string) string {
@@ -132,6 +879,15 @@ func getEnvInt(key string, defaultValue int) int {
 	return defaultValue
 }
 
+func getEnvFloat(key string, defaultValue float64) float64 {
+	if value := os.Getenv(key); value != "" {
+		if floatValue, err := strconv.ParseFloat(value, 64); err == nil {
+			return floatValue
+		}
+	}
+	return defaultValue
+}
+
 func parseOrigins(originsStr string) []string {
 	origins := strings.Split(originsStr, ",")
 	for i, origin := range origins {
@@ -172,12 +928,14 @@ func CORSMiddleware(next http.Handler, allowedOrigins []string) http.Handler {
 
 		if allowed {
 			w.Header().Set("Access-Control-Allow-Origin", origin)
-			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-			w.Header().Set("Access-Control-Allow-Headers", "Accept, Authorization, Content-Type")
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Accept, Authorization, Content-Type, X-API-Key, X-Signature, X-Signature-Timestamp, X-Signature-Nonce, X-Device-Token, X-Edit-Token, X-PoW-Challenge, X-PoW-Nonce, If-Modified-Since, If-Match, "+consistencyHeader)
+			w.Header().Set("Access-Control-Expose-Headers", snapshotHeader+", "+consistencyHeader+", ETag, Retry-After, RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset")
 			w.Header().Set("Access-Control-Max-Age", "300")
 		}
 
-		if r.Method == "OPTIONS" {
+		// Other OPTIONS requests reach the route, which lists its methods
+		if r.Method == "OPTIONS" && r.Header.Get("Access-Control-Request-Method") != "" {
 			w.WriteHeader(http.StatusOK)
 			return
 		}
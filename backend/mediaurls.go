@@ -0,0 +1,67 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// MediaURLSigner signs attachment URLs so they stop working shortly after
+// they are handed out. A deleted post's images then can't be fetched, or
+// hotlinked, for longer than the TTL. The signature is the hex
+// HMAC-SHA256 of "KEY \n EXPIRES".
+type MediaURLSigner struct {
+	secret []byte
+	ttl    time.Duration
+}
+
+func NewMediaURLSigner(secret []byte, ttl time.Duration) *MediaURLSigner {
+	return &MediaURLSigner{secret: secret, ttl: ttl}
+}
+
+func (s *MediaURLSigner) signature(key string, expires int64) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(key + "\n" + strconv.FormatInt(expires, 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Sign returns the URL of a stored file. Expiry times are rounded so the
+// URL stays the same, and cacheable, for half the TTL; it is valid for
+// between half and all of the TTL.
+func (s *MediaURLSigner) Sign(key string, now time.Time) string {
+	expires := now.Truncate(s.ttl / 2).Add(s.ttl).Unix()
+	q := url.Values{}
+	q.Set("expires", strconv.FormatInt(expires, 10))
+	q.Set("sig", s.signature(key, expires))
+	return "/media/" + key + "?" + q.Encode()
+}
+
+// Verify checks a signed URL's query, returning when it expires.
+func (s *MediaURLSigner) Verify(key string, query url.Values, now time.Time) (time.Time, bool) {
+	expires, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+	if err != nil || now.Unix() >= expires {
+		return time.Time{}, false
+	}
+	if !hmac.Equal([]byte(query.Get("sig")), []byte(s.signature(key, expires))) {
+		return time.Time{}, false
+	}
+	return time.Unix(expires, 0), true
+}
+
+// signAttachments replaces attachment and rendition URLs with signed ones,
+// when signing is enabled.
+func (h *Handler) signAttachments(attachments []Attachment) {
+	if h.cfg.MediaURLs == nil {
+		return
+	}
+	now := time.Now()
+	for i := range attachments {
+		attachments[i].URL = h.cfg.MediaURLs.Sign(attachments[i].storageKey, now)
+		for j, r := range attachments[i].Renditions {
+			attachments[i].Renditions[j].URL = h.cfg.MediaURLs.Sign(r.storageKey, now)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMediaURLSigner(t *testing.T) {
+	signer := NewMediaURLSigner([]byte("secret"), 10*time.Minute)
+	now := time.Date(2026, 6, 1, 12, 3, 0, 0, time.UTC)
+
+	signed := signer.Sign("abc.png", now)
+	if signed != signer.Sign("abc.png", now.Add(time.Minute)) {
+		t.Error("URL changed within half the TTL")
+	}
+	u, err := url.Parse(signed)
+	if err != nil || u.Path != "/media/abc.png" {
+		t.Fatalf("signed URL = %q", signed)
+	}
+
+	tests := []struct {
+		name  string
+		key   string
+		query url.Values
+		at    time.Time
+		valid bool
+	}{
+		{name: "fresh", key: "abc.png", query: u.Query(), at: now, valid: true},
+		{name: "before expiry", key: "abc.png", query: u.Query(), at: now.Add(6 * time.Minute), valid: true},
+		{name: "expired", key: "abc.png", query: u.Query(), at: now.Add(11 * time.Minute)},
+		{name: "other key", key: "def.png", query: u.Query(), at: now},
+		{name: "unsigned", key: "abc.png", query: url.Values{}, at: now},
+		{name: "extended", key: "abc.png", query: url.Values{"expires": {"9999999999"}, "sig": u.Query()["sig"]}, at: now},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, ok := signer.Verify(tt.key, tt.query, tt.at); ok != tt.valid {
+				t.Errorf("Verify = %v, want %v", ok, tt.valid)
+			}
+		})
+	}
+}
+
+func TestServeMediaRequiresSignature(t *testing.T) {
+	signer := NewMediaURLSigner([]byte("secret"), 10*time.Minute)
+	h := newTestHandler(newFakeStore(), HandlerConfig{MediaURLs: signer})
+
+	for _, target := range []string{"/media/abc.png", "/media/abc.png?expires=1&sig=00"} {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.SetPathValue("key", "abc.png")
+		h.ServeMedia(rec, req)
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("GET %s: status = %d, want 403", target, rec.Code)
+		}
+	}
+
+	h.signAttachments(nil)
+	attachments := []Attachment{{URL: "/media/abc.png", storageKey: "abc.png"}}
+	h.signAttachments(attachments)
+	if !strings.HasPrefix(attachments[0].URL, "/media/abc.png?") {
+		t.Errorf("signed URL = %q", attachments[0].URL)
+	}
+}
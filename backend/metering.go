@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	meterFlushInterval = time.Minute
+	usageHistoryDays   = 30
+)
+
+// UsageRecord is the number of requests an API key made on one UTC day
+// during a single flush interval. Sinks receive increments, not totals.
+type UsageRecord struct {
+	APIKeyID  int       `json:"api_key_id"`
+	Day       string    `json:"day"`
+	Requests  int64     `json:"requests"`
+	FlushedAt time.Time `json:"flushed_at"`
+}
+
+// UsageDay is the running total for one key and day, as stored in the database.
+type UsageDay struct {
+	Day      string `json:"day"`
+	Requests int64  `json:"requests"`
+}
+
+// UsageSink receives usage increments after they are persisted, so a
+// billing system can consume them without reading the database.
+type UsageSink interface {
+	Emit(ctx context.Context, records []UsageRecord) error
+}
+
+// LogUsageSink writes usage records to the application log.
+type LogUsageSink struct{}
+
+func (LogUsageSink) Emit(ctx context.Context, records []UsageRecord) error {
+	for _, record := range records {
+		log.Printf("usage: api_key=%d day=%s requests=%d", record.APIKeyID, record.Day, record.Requests)
+	}
+	return nil
+}
+
+// WebhookUsageSink POSTs each batch of usage records as a JSON array.
+type WebhookUsageSink struct {
+	url    string
+	client *http.Client
+}
+
+func NewWebhookUsageSink(url string) *WebhookUsageSink {
+	return &WebhookUsageSink{url: url, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (s *WebhookUsageSink) Emit(ctx context.Context, records []UsageRecord) error {
+	body, err := json.Marshal(records)
+	if err != nil {
+		return fmt.Errorf("failed to encode usage records: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to build usage request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send usage records: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("usage sink returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// NewUsageSink builds the sink named by METERING_SINK ("log", "webhook" or
+// "none"); it returns nil when usage should only be stored.
+func NewUsageSink(kind, url string) (UsageSink, error) {
+	switch kind {
+	case "", "none":
+		return nil, nil
+	case "log":
+		return LogUsageSink{}, nil
+	case "webhook":
+		if url == "" {
+			return nil, fmt.Errorf("METERING_SINK_URL is required for the webhook sink")
+		}
+		return NewWebhookUsageSink(url), nil
+	default:
+		return nil, fmt.Errorf("unknown metering sink %q", kind)
+	}
+}
+
+type meterKey struct {
+	apiKeyID int
+	day      string
+}
+
+// Meter counts requests per API key per day in memory and periodically
+// folds the counts into the api_key_usage table.
+type Meter struct {
+	db   *DB
+	sink UsageSink
+
+	mu     sync.Mutex
+	counts map[meterKey]int64
+}
+
+func NewMeter(db *DB, sink UsageSink) *Meter {
+	return &Meter{
+		db:     db,
+		sink:   sink,
+		counts: make(map[meterKey]int64),
+	}
+}
+
+// Middleware counts every request made with an API key. It must run after
+// APIKeyAuth so the key is in the request context.
+func (m *Meter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if id := APIKeyIDFromContext(r.Context()); id != 0 {
+			key := meterKey{apiKeyID: id, day: time.Now().UTC().Format(time.DateOnly)}
+			m.mu.Lock()
+			m.counts[key]++
+			m.mu.Unlock()
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Run flushes counts every meterFlushInterval until ctx is cancelled, then
+// flushes once more so a graceful shutdown doesn't drop usage.
+func (m *Meter) Run(ctx context.Context) {
+	ticker := time.NewTicker(meterFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			m.flush(ctx)
+		case <-ctx.Done():
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			m.flush(shutdownCtx)
+			cancel()
+			return
+		}
+	}
+}
+
+func (m *Meter) flush(ctx context.Context) {
+	m.mu.Lock()
+	counts := m.counts
+	m.counts = make(map[meterKey]int64)
+	m.mu.Unlock()
+
+	if len(counts) == 0 {
+		return
+	}
+
+	now := time.Now().UTC()
+	records := make([]UsageRecord, 0, len(counts))
+	for key, count := range counts {
+		if err := m.db.AddAPIKeyUsage(ctx, key.apiKeyID, key.day, count); err != nil {
+			// Put the count back so it is retried on the next flush
+			log.Printf("Error recording API key usage: %v", err)
+			m.mu.Lock()
+			m.counts[key] += count
+			m.mu.Unlock()
+			continue
+		}
+		records = append(records, UsageRecord{APIKeyID: key.apiKeyID, Day: key.day, Requests: count, FlushedAt: now})
+	}
+
+	if m.sink != nil && len(records) > 0 {
+		if err := m.sink.Emit(ctx, records); err != nil {
+			log.Printf("Error emitting usage records: %v", err)
+		}
+	}
+}
+
+// GetAPIKeyUsage handles GET /api/keys/{id}/usage. A key may read its own
+// usage; the admin token may read any key's.
+func (h *Handler) GetAPIKeyUsage(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid API key ID")
+		return
+	}
+
+	if APIKeyIDFromContext(r.Context()) != id && !isAdminRequest(r, h.cfg.AdminToken) {
+		respondWithError(w, http.StatusForbidden, "Forbidden")
+		return
+	}
+
+	usage, err := h.db.GetAPIKeyUsage(r.Context(), id, usageHistoryDays)
+	if err != nil {
+		log.Printf("Error getting API key usage: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve usage")
+		return
+	}
+
+	if usage == nil {
+		usage = []UsageDay{}
+	}
+
+	respondWithJSON(w, http.StatusOK, usage)
+}
+
+func (db *DB) AddAPIKeyUsage(ctx context.Context, apiKeyID int, day string, count int64) error {
+	_, err := db.conn.ExecContext(ctx, `
+		INSERT INTO api_key_usage (api_key_id, day, request_count)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (api_key_id, day)
+		DO UPDATE SET request_count = api_key_usage.request_count + EXCLUDED.request_count
+	`, apiKeyID, day, count)
+	if err != nil {
+		return fmt.Errorf("failed to add API key usage: %w", err)
+	}
+	return nil
+}
+
+// GetAPIKeyUsage returns daily totals for the last `days` days, newest first.
+func (db *DB) GetAPIKeyUsage(ctx context.Context, apiKeyID int, days int) ([]UsageDay, error) {
+	rows, err := db.conn.QueryContext(ctx, `
+		SELECT TO_CHAR(day, 'YYYY-MM-DD'), request_count
+		FROM api_key_usage
+		WHERE api_key_id = $1
+		AND day > CURRENT_DATE - $2::int
+		ORDER BY day DESC
+	`, apiKeyID, days)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query API key usage: %w", err)
+	}
+	defer rows.Close()
+
+	var usage []UsageDay
+	for rows.Next() {
+		var day UsageDay
+		if err := rows.Scan(&day.Day, &day.Requests); err != nil {
+			return nil, fmt.Errorf("failed to scan API key usage: %w", err)
+		}
+		usage = append(usage, day)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating API key usage: %w", err)
+	}
+
+	return usage, nil
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// metricsRetention is how far back request metrics are kept.
+const metricsRetention = time.Hour
+
+// latencyBounds are the upper bounds of the latency histogram's buckets;
+// slower requests fall in a final overflow bucket.
+var latencyBounds = [...]time.Duration{
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// metricsBucket counts the requests finished in one minute.
+type metricsBucket struct {
+	minute      int64
+	requests    int
+	errors      int
+	rateLimited int
+	latency     [len(latencyBounds) + 1]int
+}
+
+// Metrics counts requests, server errors and latencies per minute for the
+// last metricsRetention, in memory. Counts are per server process.
+type Metrics struct {
+	mu      sync.Mutex
+	buckets [metricsRetention / time.Minute]metricsBucket
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{}
+}
+
+// MetricsSnapshot summarizes the requests over a window.
+type MetricsSnapshot struct {
+	Requests  int
+	ErrorRate float64
+	P50       time.Duration
+	P95       time.Duration
+	P99       time.Duration
+}
+
+// Record counts a finished request; statuses of 500 and above are errors,
+// and 429s were rate limited.
+func (m *Metrics) Record(status int, latency time.Duration, at time.Time) {
+	minute := at.Unix() / 60
+	i := 0
+	for i < len(latencyBounds) && latency > latencyBounds[i] {
+		i++
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b := &m.buckets[minute%int64(len(m.buckets))]
+	if b.minute > minute {
+		// Too old to keep
+		return
+	}
+	if b.minute < minute {
+		*b = metricsBucket{minute: minute}
+	}
+	b.requests++
+	if status >= 500 {
+		b.errors++
+	}
+	if status == http.StatusTooManyRequests {
+		b.rateLimited++
+	}
+	b.latency[i]++
+}
+
+// Snapshot summarizes the requests finished in the window before now.
+// Percentiles are the upper bound of the bucket they fall in.
+func (m *Metrics) Snapshot(window time.Duration, now time.Time) MetricsSnapshot {
+	current := now.Unix() / 60
+	oldest := current - int64(window/time.Minute) + 1
+
+	var total metricsBucket
+	m.mu.Lock()
+	for _, b := range m.buckets {
+		if b.minute < oldest || b.minute > current {
+			continue
+		}
+		total.requests += b.requests
+		total.errors += b.errors
+		for i, n := range b.latency {
+			total.latency[i] += n
+		}
+	}
+	m.mu.Unlock()
+
+	snapshot := MetricsSnapshot{Requests: total.requests}
+	if total.requests == 0 {
+		return snapshot
+	}
+	snapshot.ErrorRate = float64(total.errors) / float64(total.requests)
+	snapshot.P50 = latencyPercentile(total.latency, total.requests, 0.50)
+	snapshot.P95 = latencyPercentile(total.latency, total.requests, 0.95)
+	snapshot.P99 = latencyPercentile(total.latency, total.requests, 0.99)
+	return snapshot
+}
+
+// MetricsMinute counts the requests finished in one minute.
+type MetricsMinute struct {
+	Minute      time.Time `json:"minute"`
+	Requests    int       `json:"requests"`
+	Errors      int       `json:"errors"`
+	RateLimited int       `json:"rate_limited"`
+}
+
+// Minutes returns the counts for each minute of the window before now,
+// oldest first, including minutes without requests.
+func (m *Metrics) Minutes(window time.Duration, now time.Time) []MetricsMinute {
+	current := now.Unix() / 60
+	n := int64(window / time.Minute)
+	minutes := make([]MetricsMinute, n)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range minutes {
+		minute := current - n + 1 + int64(i)
+		minutes[i].Minute = time.Unix(minute*60, 0).UTC()
+		b := m.buckets[minute%int64(len(m.buckets))]
+		if b.minute == minute {
+			minutes[i].Requests = b.requests
+			minutes[i].Errors = b.errors
+			minutes[i].RateLimited = b.rateLimited
+		}
+	}
+	return minutes
+}
+
+// latencyPercentile returns the upper bound of the histogram bucket holding
+// the p'th fraction of requests; the overflow bucket reports the largest
+// bound.
+func latencyPercentile(counts [len(latencyBounds) + 1]int, total int, p float64) time.Duration {
+	rank := int(p*float64(total) + 0.5)
+	if rank < 1 {
+		rank = 1
+	}
+	seen := 0
+	for i, n := range counts {
+		seen += n
+		if seen >= rank && i < len(latencyBounds) {
+			return latencyBounds[i]
+		}
+	}
+	return latencyBounds[len(latencyBounds)-1]
+}
+
+// Middleware records every request except health checks.
+func (m *Metrics) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+		m.Record(recorder.status, time.Since(start), time.Now())
+	})
+}
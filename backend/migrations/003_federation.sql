@@ -0,0 +1,32 @@
+-- Migration: 003_federation
+-- Description: ActivityPub federation of event boards
+-- Stores the instance signing key, remote followers of each event actor,
+-- and replies to posts (initially only those federated in from the fediverse)
+
+CREATE TABLE IF NOT EXISTS federation_keys (
+    id SERIAL PRIMARY KEY,
+    private_key_pem TEXT NOT NULL,
+    public_key_pem TEXT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS federation_followers (
+    event_name VARCHAR(200) NOT NULL,
+    actor_uri TEXT NOT NULL,
+    inbox_uri TEXT NOT NULL,
+    shared_inbox_uri TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (event_name, actor_uri)
+);
+
+CREATE TABLE IF NOT EXISTS replies (
+    id SERIAL PRIMARY KEY,
+    post_id INTEGER NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+    content TEXT NOT NULL,
+    source VARCHAR(20) NOT NULL,
+    actor_uri TEXT,
+    object_uri TEXT UNIQUE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_replies_post_created ON replies(post_id, created_at);
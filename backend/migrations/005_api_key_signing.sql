@@ -0,0 +1,11 @@
+-- Migration: 005_api_key_signing
+-- Description: Optional HMAC request signing for API-key clients
+-- The signing secret has to be stored in plaintext so the server can
+-- recompute signatures; it is only ever returned when the key is created
+
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS signing_secret VARCHAR(64);
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS require_signature BOOLEAN NOT NULL DEFAULT FALSE;
+
+UPDATE api_keys SET signing_secret = md5(random()::text || id::text || key_hash) WHERE signing_secret IS NULL;
+
+ALTER TABLE api_keys ALTER COLUMN signing_secret SET NOT NULL;
@@ -0,0 +1,17 @@
+-- Migration: 006_events
+-- Description: Per-event metadata, starting with retention classes
+-- Posts still reference events by name; a row is created the first time an
+-- event is posted to
+
+CREATE TABLE IF NOT EXISTS events (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(200) NOT NULL UNIQUE,
+    retention_class VARCHAR(50),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+INSERT INTO events (name, created_at)
+SELECT event_name, MIN(created_at)
+FROM posts
+GROUP BY event_name
+ON CONFLICT (name) DO NOTHING;
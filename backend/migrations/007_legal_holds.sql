@@ -0,0 +1,29 @@
+-- Migration: 007_legal_holds
+-- Description: Legal holds exempt posts, events or IP hashes from retention,
+-- plus an audit log of admin actions
+
+CREATE TABLE IF NOT EXISTS legal_holds (
+    id SERIAL PRIMARY KEY,
+    target_type VARCHAR(20) NOT NULL CHECK (target_type IN ('post', 'event', 'ip_hash')),
+    target_value VARCHAR(200) NOT NULL,
+    reason TEXT NOT NULL,
+    reference VARCHAR(200),
+    placed_by VARCHAR(200) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    released_at TIMESTAMP WITH TIME ZONE,
+    released_by VARCHAR(200)
+);
+
+CREATE INDEX IF NOT EXISTS idx_legal_holds_active ON legal_holds(target_type, target_value) WHERE released_at IS NULL;
+
+CREATE TABLE IF NOT EXISTS admin_audit_log (
+    id SERIAL PRIMARY KEY,
+    actor VARCHAR(200) NOT NULL,
+    action VARCHAR(100) NOT NULL,
+    target_type VARCHAR(50),
+    target_value VARCHAR(200),
+    details JSONB,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_admin_audit_log_created ON admin_audit_log(created_at DESC);
@@ -0,0 +1,4 @@
+-- Migration: 008_terms_version
+-- Description: Record the terms-of-service version a poster accepted
+
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS terms_version VARCHAR(50);
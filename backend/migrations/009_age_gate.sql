@@ -0,0 +1,19 @@
+-- Migration: 009_age_gate
+-- Description: All-ages event boards and a moderation queue for flagged posts
+
+ALTER TABLE events ADD COLUMN IF NOT EXISTS all_ages BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE TABLE IF NOT EXISTS moderation_queue (
+    id SERIAL PRIMARY KEY,
+    post_id INTEGER NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+    reason VARCHAR(50) NOT NULL,
+    details TEXT,
+    source VARCHAR(20) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    resolved_at TIMESTAMP WITH TIME ZONE,
+    resolved_by VARCHAR(200),
+    resolution VARCHAR(20)
+);
+
+CREATE INDEX IF NOT EXISTS idx_moderation_queue_open ON moderation_queue(created_at) WHERE resolved_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_moderation_queue_post ON moderation_queue(post_id);
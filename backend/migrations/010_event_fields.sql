@@ -0,0 +1,6 @@
+-- Migration: 010_event_fields
+-- Description: Per-event choice of which demographic fields are collected and displayed
+
+ALTER TABLE events ADD COLUMN IF NOT EXISTS collect_age BOOLEAN NOT NULL DEFAULT TRUE;
+ALTER TABLE events ADD COLUMN IF NOT EXISTS collect_gender BOOLEAN NOT NULL DEFAULT TRUE;
+ALTER TABLE events ADD COLUMN IF NOT EXISTS collect_location BOOLEAN NOT NULL DEFAULT TRUE;
@@ -0,0 +1,9 @@
+-- Migration: 011_custom_fields
+-- Description: Organizer-defined custom fields on events, stored on posts as JSONB
+
+ALTER TABLE events ADD COLUMN IF NOT EXISTS custom_fields JSONB NOT NULL DEFAULT '[]';
+
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS custom_fields JSONB;
+
+-- jsonb_path_ops supports the containment (@>) queries used by ?field.x= filters
+CREATE INDEX IF NOT EXISTS idx_posts_custom_fields ON posts USING GIN (custom_fields jsonb_path_ops);
@@ -0,0 +1,15 @@
+-- Migration: 012_post_templates
+-- Description: Organizer-defined prompt templates that posts can reference
+
+CREATE TABLE IF NOT EXISTS post_templates (
+    id SERIAL PRIMARY KEY,
+    event_name VARCHAR(200) NOT NULL REFERENCES events(name) ON UPDATE CASCADE ON DELETE CASCADE,
+    prompt VARCHAR(500) NOT NULL,
+    position INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    archived_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS idx_post_templates_event ON post_templates(event_name) WHERE archived_at IS NULL;
+
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS template_id INTEGER REFERENCES post_templates(id) ON DELETE SET NULL;
@@ -0,0 +1,18 @@
+-- Migration: 013_event_sessions
+-- Description: Sessions (days, stages, talks) as sub-boards of an event
+
+CREATE TABLE IF NOT EXISTS event_sessions (
+    id SERIAL PRIMARY KEY,
+    event_name VARCHAR(200) NOT NULL REFERENCES events(name) ON UPDATE CASCADE ON DELETE CASCADE,
+    name VARCHAR(200) NOT NULL,
+    starts_at TIMESTAMP WITH TIME ZONE,
+    ends_at TIMESTAMP WITH TIME ZONE,
+    position INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_event_sessions_event ON event_sessions(event_name);
+
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS session_id INTEGER REFERENCES event_sessions(id) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS idx_posts_session ON posts(session_id, created_at DESC) WHERE session_id IS NOT NULL;
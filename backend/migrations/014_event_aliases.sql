@@ -0,0 +1,11 @@
+-- Migration: 014_event_aliases
+-- Description: Former names of renamed and merged events, so old links keep working
+
+CREATE TABLE IF NOT EXISTS event_aliases (
+    alias VARCHAR(200) PRIMARY KEY,
+    event_name VARCHAR(200) NOT NULL REFERENCES events(name) ON UPDATE CASCADE ON DELETE CASCADE,
+    reason VARCHAR(20) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_event_aliases_event ON event_aliases(event_name);
@@ -0,0 +1,74 @@
+-- Migration: 015_event_slugs
+-- Description: URL-safe event slugs, with history so moved slugs redirect
+-- Slugs are assigned by trigger so every path that creates or renames an
+-- event (including the implicit creation on first post) gets one.
+
+ALTER TABLE events ADD COLUMN IF NOT EXISTS slug VARCHAR(100);
+
+CREATE TABLE IF NOT EXISTS event_slug_history (
+    slug VARCHAR(100) PRIMARY KEY,
+    event_id INTEGER NOT NULL REFERENCES events(id) ON DELETE CASCADE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE OR REPLACE FUNCTION event_slug_base(name TEXT) RETURNS TEXT AS $$
+    SELECT COALESCE(
+        NULLIF(TRIM(BOTH '-' FROM LEFT(TRIM(BOTH '-' FROM REGEXP_REPLACE(LOWER(name), '[^a-z0-9]+', '-', 'g')), 80)), ''),
+        'event'
+    )
+$$ LANGUAGE SQL IMMUTABLE;
+
+CREATE OR REPLACE FUNCTION assign_event_slug() RETURNS TRIGGER AS $$
+DECLARE
+    base TEXT;
+    candidate TEXT;
+    n INTEGER := 1;
+BEGIN
+    IF TG_OP = 'UPDATE' AND NEW.name = OLD.name AND NEW.slug IS NOT NULL THEN
+        RETURN NEW;
+    END IF;
+
+    base := event_slug_base(NEW.name);
+    IF TG_OP = 'UPDATE' AND OLD.slug IS NOT NULL AND event_slug_base(OLD.name) = base THEN
+        NEW.slug := OLD.slug;
+        RETURN NEW;
+    END IF;
+
+    candidate := base;
+    WHILE EXISTS (SELECT 1 FROM events WHERE slug = candidate AND id <> NEW.id)
+        OR EXISTS (SELECT 1 FROM event_slug_history WHERE slug = candidate AND event_id <> NEW.id)
+    LOOP
+        n := n + 1;
+        candidate := base || '-' || n;
+    END LOOP;
+
+    IF TG_OP = 'UPDATE' AND OLD.slug IS NOT NULL AND OLD.slug <> candidate THEN
+        INSERT INTO event_slug_history (slug, event_id) VALUES (OLD.slug, NEW.id)
+        ON CONFLICT (slug) DO NOTHING;
+        -- Moving back to a former slug reclaims it
+        DELETE FROM event_slug_history WHERE slug = candidate;
+    END IF;
+
+    NEW.slug := candidate;
+    RETURN NEW;
+END
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS events_assign_slug ON events;
+CREATE TRIGGER events_assign_slug
+    BEFORE INSERT OR UPDATE OF name, slug ON events
+    FOR EACH ROW EXECUTE FUNCTION assign_event_slug();
+
+-- Backfill one row at a time so each slug sees the ones assigned before it
+DO $$
+DECLARE
+    r RECORD;
+BEGIN
+    FOR r IN SELECT id FROM events WHERE slug IS NULL ORDER BY id LOOP
+        UPDATE events SET slug = NULL WHERE id = r.id;
+    END LOOP;
+END
+$$;
+
+ALTER TABLE events ALTER COLUMN slug SET NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_events_slug ON events(slug);
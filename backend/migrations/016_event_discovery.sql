@@ -0,0 +1,11 @@
+-- Migration: 016_event_discovery
+-- Description: Event category, location and dates for the discovery API
+
+ALTER TABLE events ADD COLUMN IF NOT EXISTS category VARCHAR(50);
+ALTER TABLE events ADD COLUMN IF NOT EXISTS latitude DOUBLE PRECISION;
+ALTER TABLE events ADD COLUMN IF NOT EXISTS longitude DOUBLE PRECISION;
+ALTER TABLE events ADD COLUMN IF NOT EXISTS starts_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE events ADD COLUMN IF NOT EXISTS ends_at TIMESTAMP WITH TIME ZONE;
+
+CREATE INDEX IF NOT EXISTS idx_events_category ON events(category) WHERE category IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_events_starts_at ON events(starts_at) WHERE starts_at IS NOT NULL;
@@ -0,0 +1,12 @@
+-- Migration: 017_post_translations
+-- Description: Cache of machine translations of posts, one per language
+
+CREATE TABLE IF NOT EXISTS post_translations (
+    post_id INTEGER NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+    language VARCHAR(10) NOT NULL,
+    source_language VARCHAR(10),
+    content TEXT NOT NULL,
+    provider VARCHAR(20) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (post_id, language)
+);
@@ -0,0 +1,9 @@
+-- Migration: 018_post_audio
+-- Description: Cache of text-to-speech renderings of posts
+
+CREATE TABLE IF NOT EXISTS post_audio (
+    post_id INTEGER PRIMARY KEY REFERENCES posts(id) ON DELETE CASCADE,
+    audio BYTEA NOT NULL,
+    provider VARCHAR(20) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
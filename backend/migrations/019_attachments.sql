@@ -0,0 +1,24 @@
+-- Migration: 019_attachments
+-- Description: Image attachments on posts, with alt text
+
+CREATE TABLE IF NOT EXISTS attachments (
+    id SERIAL PRIMARY KEY,
+    -- NULL until the upload is attached, and again once its post is deleted
+    post_id INTEGER REFERENCES posts(id) ON DELETE SET NULL,
+    storage_key VARCHAR(64) NOT NULL UNIQUE,
+    content_type VARCHAR(50) NOT NULL,
+    width INTEGER NOT NULL,
+    height INTEGER NOT NULL,
+    size INTEGER NOT NULL,
+    alt_text TEXT,
+    alt_text_generated BOOLEAN NOT NULL DEFAULT FALSE,
+    uploader_ip_hash VARCHAR(64) NOT NULL,
+    attached_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_attachments_post_id ON attachments(post_id);
+
+-- Unattached uploads, for cleanup, and images awaiting generated alt text
+CREATE INDEX IF NOT EXISTS idx_attachments_unattached ON attachments(created_at) WHERE post_id IS NULL;
+CREATE INDEX IF NOT EXISTS idx_attachments_missing_alt_text ON attachments(id) WHERE alt_text IS NULL;
@@ -0,0 +1,5 @@
+-- Migration: 020_content_warnings
+-- Description: Optional content warning on posts, set by the poster, a
+-- moderator or the moderation heuristics
+
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS content_warning VARCHAR(100);
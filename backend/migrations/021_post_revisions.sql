@@ -0,0 +1,18 @@
+-- Migration: 021_post_revisions
+-- Description: Post editing with an edit token, keeping every replaced
+-- version for moderators
+
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS edit_token_hash VARCHAR(64);
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS edited_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS edit_count INTEGER NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS post_revisions (
+    id SERIAL PRIMARY KEY,
+    post_id INTEGER NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+    content TEXT NOT NULL,
+    content_warning VARCHAR(100),
+    -- When this version was replaced
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_post_revisions_post_id ON post_revisions(post_id);
@@ -0,0 +1,11 @@
+-- Migration: 022_drafts
+-- Description: Unfinished posts saved per device, expiring after a TTL
+
+CREATE TABLE IF NOT EXISTS drafts (
+    device_token_hash VARCHAR(64) PRIMARY KEY,
+    content JSONB NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_drafts_expires_at ON drafts(expires_at);
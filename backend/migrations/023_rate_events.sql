@@ -0,0 +1,12 @@
+-- Migration: 023_rate_events
+-- Description: Rate limit reservations, counted and inserted atomically per key
+
+CREATE TABLE IF NOT EXISTS rate_events (
+    id BIGSERIAL PRIMARY KEY,
+    limiter VARCHAR(50) NOT NULL,
+    key VARCHAR(64) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_rate_events_limiter_key ON rate_events(limiter, key, created_at);
+CREATE INDEX IF NOT EXISTS idx_rate_events_created_at ON rate_events(created_at);
@@ -0,0 +1,20 @@
+-- Migration: 024_rate_limit_algorithms
+-- Description: State for the sliding window counter and token bucket rate limiters
+
+CREATE TABLE IF NOT EXISTS rate_counters (
+    limiter VARCHAR(50) NOT NULL,
+    key VARCHAR(64) NOT NULL,
+    window_start TIMESTAMP WITH TIME ZONE NOT NULL,
+    count INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (limiter, key, window_start)
+);
+
+CREATE INDEX IF NOT EXISTS idx_rate_counters_window_start ON rate_counters(window_start);
+
+CREATE TABLE IF NOT EXISTS rate_buckets (
+    limiter VARCHAR(50) NOT NULL,
+    key VARCHAR(64) NOT NULL,
+    tokens DOUBLE PRECISION NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    PRIMARY KEY (limiter, key)
+);
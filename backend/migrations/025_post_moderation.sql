@@ -0,0 +1,13 @@
+-- Migration: 025_post_moderation
+-- Description: Moderators can hide posts and ban their authors, by IP hash
+-- (or phone hash for SMS posts)
+
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS hidden_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS hidden_by VARCHAR(200);
+
+CREATE TABLE IF NOT EXISTS author_bans (
+    ip_hash VARCHAR(64) PRIMARY KEY,
+    banned_by VARCHAR(200) NOT NULL,
+    reason TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
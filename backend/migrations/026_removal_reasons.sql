@@ -0,0 +1,15 @@
+-- Migration: 026_removal_reasons
+-- Description: Moderator-written reasons for hiding posts, shown to the
+-- post's author, who is recognised by the device token they posted with
+
+CREATE TABLE IF NOT EXISTS removal_reasons (
+    id SERIAL PRIMARY KEY,
+    code VARCHAR(50) NOT NULL UNIQUE,
+    message TEXT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    archived_at TIMESTAMP WITH TIME ZONE
+);
+
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS removal_reason_id INTEGER REFERENCES removal_reasons(id);
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS device_token_hash VARCHAR(64);
@@ -0,0 +1,7 @@
+-- Migration: 027_appeals
+-- Description: Authors can appeal a removed post once; the appeal is a
+-- moderation queue item the author follows with a status token
+
+ALTER TABLE moderation_queue ADD COLUMN IF NOT EXISTS status_token_hash VARCHAR(64) UNIQUE;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_moderation_queue_appeal ON moderation_queue(post_id) WHERE reason = 'appeal';
@@ -0,0 +1,16 @@
+-- Migration: 028_toxicity
+-- Description: Toxicity scores of posts, kept for tuning thresholds, and
+-- per-event overrides of the thresholds
+
+ALTER TABLE events ADD COLUMN IF NOT EXISTS toxicity_hide_threshold REAL;
+ALTER TABLE events ADD COLUMN IF NOT EXISTS toxicity_label_threshold REAL;
+
+CREATE TABLE IF NOT EXISTS post_toxicity_scores (
+    post_id INTEGER PRIMARY KEY REFERENCES posts(id) ON DELETE CASCADE,
+    scorer VARCHAR(50) NOT NULL,
+    score REAL NOT NULL,
+    action VARCHAR(10) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_post_toxicity_scores_created_at ON post_toxicity_scores(created_at);
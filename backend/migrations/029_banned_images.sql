@@ -0,0 +1,13 @@
+-- Migration: 029_banned_images
+-- Description: Perceptual hashes of banned images; uploads that nearly match
+-- one are refused, or flagged for moderators when attached to a post
+
+CREATE TABLE IF NOT EXISTS banned_image_hashes (
+    id SERIAL PRIMARY KEY,
+    hash BIGINT NOT NULL,
+    reason TEXT,
+    banned_by VARCHAR(200) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+ALTER TABLE attachments ADD COLUMN IF NOT EXISTS banned_image_id INTEGER REFERENCES banned_image_hashes(id) ON DELETE SET NULL;
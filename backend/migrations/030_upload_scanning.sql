@@ -0,0 +1,9 @@
+-- Migration: 030_upload_scanning
+-- Description: Virus scan status of uploads. Uploads made while a scanner
+-- is configured start pending and are only served once clean
+
+ALTER TABLE attachments ADD COLUMN IF NOT EXISTS scan_status VARCHAR(20) NOT NULL DEFAULT 'clean';
+ALTER TABLE attachments ADD COLUMN IF NOT EXISTS scan_threat TEXT;
+ALTER TABLE attachments ADD COLUMN IF NOT EXISTS scan_attempts INTEGER NOT NULL DEFAULT 0;
+
+CREATE INDEX IF NOT EXISTS idx_attachments_scan_pending ON attachments(id) WHERE scan_status = 'pending';
@@ -0,0 +1,20 @@
+-- Migration: 031_attachment_renditions
+-- Description: Smaller renditions of uploaded images, generated in the
+-- background, so lists don't load full-resolution photos
+
+CREATE TABLE IF NOT EXISTS attachment_renditions (
+    attachment_id INTEGER NOT NULL REFERENCES attachments(id) ON DELETE CASCADE,
+    name VARCHAR(20) NOT NULL,
+    storage_key VARCHAR(64) NOT NULL UNIQUE,
+    content_type VARCHAR(50) NOT NULL,
+    width INTEGER NOT NULL,
+    height INTEGER NOT NULL,
+    size INTEGER NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (attachment_id, name)
+);
+
+-- NULL until renditions have been generated, or found unnecessary
+ALTER TABLE attachments ADD COLUMN IF NOT EXISTS renditions_at TIMESTAMP WITH TIME ZONE;
+
+CREATE INDEX IF NOT EXISTS idx_attachments_renditions_pending ON attachments(id) WHERE renditions_at IS NULL;
@@ -0,0 +1,19 @@
+-- Migration: 032_post_public_ids
+-- Description: Short random public IDs for posts, used in public URLs so
+-- the sequential primary key doesn't reveal post volume or invite
+-- enumeration. IDs are assigned by column default so every path that
+-- creates a post gets one.
+
+-- Ten characters: a letter, so a public ID is never mistaken for a legacy
+-- integer ID, then nine from an alphabet without look-alike characters
+CREATE OR REPLACE FUNCTION new_post_public_id() RETURNS TEXT AS $$
+    SELECT substr('abcdefghjkmnpqrstvwxyz', get_byte(b, 0) % 22 + 1, 1)
+        || string_agg(substr('0123456789abcdefghjkmnpqrstvwxyz', get_byte(b, i) % 32 + 1, 1), '' ORDER BY i)
+    FROM (SELECT decode(md5(gen_random_uuid()::text), 'hex') AS b) random_bytes, generate_series(1, 9) i
+    GROUP BY b
+$$ LANGUAGE SQL VOLATILE;
+
+-- The volatile default gives each existing post its own ID
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS public_id VARCHAR(16) NOT NULL DEFAULT new_post_public_id();
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_posts_public_id ON posts(public_id);
@@ -0,0 +1,34 @@
+-- Migration: 033_uuid_v7
+-- Description: Time-sortable UUIDv7 identifiers for posts and replies
+-- This is the first step toward UUID keys. Integer IDs stay the primary
+-- keys and foreign keys for now, and both IDs resolve in the API. Once
+-- clients and the tables that reference posts use UUIDs, a later migration
+-- can add UUID foreign key columns, backfill them by join, and swap the
+-- primary keys over.
+
+-- A UUIDv7 (RFC 9562) for the given time: 48 bits of Unix milliseconds,
+-- then random bits, with the version nibble set from gen_random_uuid's 4
+-- to 7
+CREATE OR REPLACE FUNCTION uuid_v7(ts TIMESTAMP WITH TIME ZONE DEFAULT clock_timestamp()) RETURNS UUID AS $$
+    SELECT encode(
+        set_bit(set_bit(
+            overlay(uuid_send(gen_random_uuid())
+                PLACING substring(int8send(floor(extract(epoch FROM ts) * 1000)::BIGINT) FROM 3)
+                FROM 1 FOR 6),
+            52, 1), 53, 1),
+        'hex')::UUID
+$$ LANGUAGE SQL VOLATILE;
+
+-- Existing rows get UUIDs from their creation time, so UUID order matches
+-- creation order
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS uuid UUID;
+UPDATE posts SET uuid = uuid_v7(created_at) WHERE uuid IS NULL;
+ALTER TABLE posts ALTER COLUMN uuid SET DEFAULT uuid_v7();
+ALTER TABLE posts ALTER COLUMN uuid SET NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_posts_uuid ON posts(uuid);
+
+ALTER TABLE replies ADD COLUMN IF NOT EXISTS uuid UUID;
+UPDATE replies SET uuid = uuid_v7(created_at) WHERE uuid IS NULL;
+ALTER TABLE replies ALTER COLUMN uuid SET DEFAULT uuid_v7();
+ALTER TABLE replies ALTER COLUMN uuid SET NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_replies_uuid ON replies(uuid);
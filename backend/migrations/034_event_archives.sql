@@ -0,0 +1,7 @@
+-- Migration: 034_event_archives
+-- Description: Mark events whose boards have been archived to object
+-- storage, and whose posts have been removed from the database
+
+ALTER TABLE events ADD COLUMN IF NOT EXISTS archived_at TIMESTAMP WITH TIME ZONE;
+
+CREATE INDEX IF NOT EXISTS idx_events_archivable ON events(ends_at) WHERE archived_at IS NULL AND ends_at IS NOT NULL;
@@ -0,0 +1,10 @@
+-- Migration: 035_validation_rejections
+-- Description: Daily counts of the validation rules that rejected post
+-- submissions, so limits can be tuned from how often people hit them
+
+CREATE TABLE IF NOT EXISTS validation_rejections (
+    day DATE NOT NULL,
+    rule VARCHAR(100) NOT NULL,
+    rejection_count BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (day, rule)
+);
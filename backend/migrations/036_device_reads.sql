@@ -0,0 +1,10 @@
+-- Migration: 036_device_reads
+-- Description: When each device last read the replies to its posts, for the
+-- unread counts behind app badges
+
+CREATE TABLE IF NOT EXISTS device_reads (
+    device_token_hash VARCHAR(64) PRIMARY KEY,
+    read_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_posts_device_token_hash ON posts(device_token_hash) WHERE device_token_hash IS NOT NULL;
@@ -0,0 +1,29 @@
+-- Migration: 037_notifications
+-- Description: A notification inbox per device, recognised by the device
+-- token it posted with. Replies to a device's posts are notifications, and
+-- their read state replaces device_reads.
+
+CREATE TABLE IF NOT EXISTS notifications (
+    id SERIAL PRIMARY KEY,
+    device_token_hash VARCHAR(64) NOT NULL,
+    kind VARCHAR(20) NOT NULL,
+    post_id INTEGER NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+    reply_id INTEGER UNIQUE REFERENCES replies(id) ON DELETE CASCADE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    read_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS idx_notifications_device_created ON notifications(device_token_hash, created_at DESC);
+
+-- Replies received so far, read if they came before the device last read
+-- its replies
+INSERT INTO notifications (device_token_hash, kind, post_id, reply_id, created_at, read_at)
+SELECT p.device_token_hash, 'reply', r.post_id, r.id, COALESCE(r.created_at, CURRENT_TIMESTAMP),
+    CASE WHEN r.created_at <= d.read_at THEN d.read_at END
+FROM replies r
+JOIN posts p ON p.id = r.post_id
+LEFT JOIN device_reads d ON d.device_token_hash = p.device_token_hash
+WHERE p.device_token_hash IS NOT NULL
+ON CONFLICT (reply_id) DO NOTHING;
+
+DROP TABLE IF EXISTS device_reads;
@@ -0,0 +1,10 @@
+-- Migration: 038_device_preferences
+-- Description: Content preferences a device applies to the post listings
+
+CREATE TABLE IF NOT EXISTS device_preferences (
+    device_token_hash VARCHAR(64) PRIMARY KEY,
+    hide_content_warnings BOOLEAN NOT NULL DEFAULT FALSE,
+    keywords JSONB NOT NULL DEFAULT '[]',
+    languages JSONB NOT NULL DEFAULT '[]',
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
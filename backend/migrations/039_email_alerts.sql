@@ -0,0 +1,23 @@
+-- Migration: 039_email_alerts
+-- Description: Email alerts for posts on an event that mention keywords,
+-- sent as they appear or as a daily digest once the address is confirmed
+
+CREATE TABLE IF NOT EXISTS email_alerts (
+    id SERIAL PRIMARY KEY,
+    email VARCHAR(254) NOT NULL,
+    event_id INTEGER NOT NULL REFERENCES events(id) ON DELETE CASCADE,
+    keywords JSONB NOT NULL,
+    frequency VARCHAR(10) NOT NULL,
+    -- Cleared once the address is confirmed
+    confirm_token_hash VARCHAR(64) UNIQUE,
+    -- Kept in the clear, since every alert email links to it
+    unsubscribe_token VARCHAR(32) NOT NULL UNIQUE,
+    confirmed_at TIMESTAMP WITH TIME ZONE,
+    -- The newest post already considered for this alert
+    last_post_id INTEGER NOT NULL DEFAULT 0,
+    last_sent_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_email_alerts_email ON email_alerts(email);
+CREATE INDEX IF NOT EXISTS idx_email_alerts_confirmed ON email_alerts(confirmed_at) WHERE confirmed_at IS NOT NULL;
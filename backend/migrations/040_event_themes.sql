@@ -0,0 +1,6 @@
+-- Migration: 040_event_themes
+-- Description: Organizer-set branding for an event's board
+
+ALTER TABLE events ADD COLUMN IF NOT EXISTS banner_url VARCHAR(2048);
+ALTER TABLE events ADD COLUMN IF NOT EXISTS accent_color VARCHAR(7);
+ALTER TABLE events ADD COLUMN IF NOT EXISTS welcome_message TEXT;
@@ -0,0 +1,4 @@
+-- Migration: 041_event_embed_domains
+-- Description: Sites allowed to embed an event's widget in an iframe
+
+ALTER TABLE events ADD COLUMN IF NOT EXISTS embed_domains JSONB NOT NULL DEFAULT '[]';
@@ -0,0 +1,24 @@
+-- Migration: 042_entity_versions
+-- Description: Version counters on posts and events, for If-Match on writes
+-- Versions are bumped by trigger so every path that updates a row, including
+-- moderation and archiving, invalidates the ETags clients hold for it.
+
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE events ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1;
+
+CREATE OR REPLACE FUNCTION bump_version() RETURNS TRIGGER AS $$
+BEGIN
+    NEW.version := OLD.version + 1;
+    RETURN NEW;
+END
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS posts_bump_version ON posts;
+CREATE TRIGGER posts_bump_version
+    BEFORE UPDATE ON posts
+    FOR EACH ROW EXECUTE FUNCTION bump_version();
+
+DROP TRIGGER IF EXISTS events_bump_version ON events;
+CREATE TRIGGER events_bump_version
+    BEFORE UPDATE ON events
+    FOR EACH ROW EXECUTE FUNCTION bump_version();
@@ -0,0 +1,17 @@
+-- Migration: 043_dead_letters
+-- Description: Dead-letter queue for notification deliveries given up on
+-- Federation deliveries and alert emails that run out of retries land here
+-- with their last error, so an admin can see them and redrive them.
+
+CREATE TABLE IF NOT EXISTS dead_letters (
+    id SERIAL PRIMARY KEY,
+    kind VARCHAR(20) NOT NULL,
+    destination TEXT NOT NULL,
+    payload JSONB NOT NULL,
+    error TEXT NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    redriven_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS idx_dead_letters_pending ON dead_letters(created_at DESC) WHERE redriven_at IS NULL;
@@ -0,0 +1,96 @@
+-- Migration: 044_post_events
+-- Description: An append-only log of every state change to a post
+-- Events are written by trigger, like the version counters, so every path
+-- that changes a post or flags it is recorded without each one having to
+-- remember to.
+
+CREATE TABLE IF NOT EXISTS post_events (
+    id BIGSERIAL PRIMARY KEY,
+    post_id INTEGER NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+    type VARCHAR(30) NOT NULL,
+    actor VARCHAR(200),
+    data JSONB,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_post_events_post_id ON post_events(post_id, id);
+
+-- Backfill from what the tables still show: creation, the edits whose
+-- revisions were kept, flags and their resolutions, and current hiding
+INSERT INTO post_events (post_id, type, data, created_at)
+SELECT id, 'created', jsonb_build_object('event_name', event_name), created_at FROM posts;
+
+INSERT INTO post_events (post_id, type, created_at)
+SELECT post_id, 'edited', created_at FROM post_revisions;
+
+INSERT INTO post_events (post_id, type, data, created_at)
+SELECT post_id, CASE WHEN reason = 'appeal' THEN 'appealed' ELSE 'reported' END,
+    jsonb_build_object('moderation_id', id, 'reason', reason, 'source', source), created_at
+FROM moderation_queue;
+
+INSERT INTO post_events (post_id, type, actor, data, created_at)
+SELECT post_id, 'report_resolved', resolved_by,
+    jsonb_build_object('moderation_id', id, 'reason', reason, 'resolution', resolution), resolved_at
+FROM moderation_queue WHERE resolved_at IS NOT NULL;
+
+INSERT INTO post_events (post_id, type, actor, created_at)
+SELECT id, 'hidden', hidden_by, hidden_at FROM posts WHERE hidden_at IS NOT NULL;
+
+CREATE OR REPLACE FUNCTION record_post_event() RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        INSERT INTO post_events (post_id, type, data)
+        VALUES (NEW.id, 'created', jsonb_build_object('event_name', NEW.event_name));
+        RETURN NEW;
+    END IF;
+
+    IF NEW.edited_at IS DISTINCT FROM OLD.edited_at THEN
+        INSERT INTO post_events (post_id, type)
+        VALUES (NEW.id, 'edited');
+    ELSIF NEW.content_warning IS DISTINCT FROM OLD.content_warning THEN
+        INSERT INTO post_events (post_id, type, data)
+        VALUES (NEW.id, 'content_warning_changed', jsonb_build_object('content_warning', NEW.content_warning));
+    END IF;
+
+    IF OLD.hidden_at IS NULL AND NEW.hidden_at IS NOT NULL THEN
+        INSERT INTO post_events (post_id, type, actor, data)
+        VALUES (NEW.id, 'hidden', NEW.hidden_by, jsonb_strip_nulls(jsonb_build_object('removal_reason_id', NEW.removal_reason_id)));
+    ELSIF OLD.hidden_at IS NOT NULL AND NEW.hidden_at IS NULL THEN
+        INSERT INTO post_events (post_id, type)
+        VALUES (NEW.id, 'unhidden');
+    END IF;
+
+    IF NEW.event_name IS DISTINCT FROM OLD.event_name THEN
+        INSERT INTO post_events (post_id, type, data)
+        VALUES (NEW.id, 'moved', jsonb_build_object('from', OLD.event_name, 'to', NEW.event_name));
+    END IF;
+
+    RETURN NEW;
+END
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS posts_record_event ON posts;
+CREATE TRIGGER posts_record_event
+    AFTER INSERT OR UPDATE ON posts
+    FOR EACH ROW EXECUTE FUNCTION record_post_event();
+
+-- Flags and appeals are recorded against the post they concern
+CREATE OR REPLACE FUNCTION record_moderation_event() RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        INSERT INTO post_events (post_id, type, data)
+        VALUES (NEW.post_id, CASE WHEN NEW.reason = 'appeal' THEN 'appealed' ELSE 'reported' END,
+            jsonb_build_object('moderation_id', NEW.id, 'reason', NEW.reason, 'source', NEW.source));
+    ELSIF OLD.resolved_at IS NULL AND NEW.resolved_at IS NOT NULL THEN
+        INSERT INTO post_events (post_id, type, actor, data)
+        VALUES (NEW.post_id, 'report_resolved', NEW.resolved_by,
+            jsonb_build_object('moderation_id', NEW.id, 'reason', NEW.reason, 'resolution', NEW.resolution));
+    END IF;
+    RETURN NEW;
+END
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS moderation_queue_record_event ON moderation_queue;
+CREATE TRIGGER moderation_queue_record_event
+    AFTER INSERT OR UPDATE ON moderation_queue
+    FOR EACH ROW EXECUTE FUNCTION record_moderation_event();
@@ -0,0 +1,16 @@
+-- Migration: 045_post_counters
+-- Description: Reply and report counts kept on posts
+-- Kept up to date by the statements that add and remove replies and
+-- reports, and checked nightly against the source tables.
+
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS reply_count INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS report_count INTEGER NOT NULL DEFAULT 0;
+
+UPDATE posts p SET reply_count = r.replies
+FROM (SELECT post_id, COUNT(*) AS replies FROM replies GROUP BY post_id) r
+WHERE r.post_id = p.id;
+
+-- Appeals are in the moderation queue too, but aren't reports
+UPDATE posts p SET report_count = m.reports
+FROM (SELECT post_id, COUNT(*) AS reports FROM moderation_queue WHERE reason <> 'appeal' GROUP BY post_id) m
+WHERE m.post_id = p.id;
@@ -0,0 +1,21 @@
+-- Migration: 046_invite_codes
+-- Description: Invite codes for soft-launching an event, or the whole site,
+-- before it opens to the public
+-- A code with an event_name only admits posts to that event; max_uses NULL
+-- is unlimited.
+
+CREATE TABLE IF NOT EXISTS invite_codes (
+    id SERIAL PRIMARY KEY,
+    code VARCHAR(64) NOT NULL UNIQUE,
+    event_name VARCHAR(200) REFERENCES events(name) ON UPDATE CASCADE ON DELETE CASCADE,
+    note TEXT NOT NULL DEFAULT '',
+    max_uses INTEGER,
+    uses INTEGER NOT NULL DEFAULT 0,
+    expires_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    revoked_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS idx_invite_codes_event_name ON invite_codes(event_name);
+
+ALTER TABLE events ADD COLUMN IF NOT EXISTS invite_only BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,4 @@
+-- Migration: 047_device_post_limit
+-- Description: Per-event cap on posts from one device, 0 for no cap
+
+ALTER TABLE events ADD COLUMN IF NOT EXISTS device_post_limit INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,14 @@
+-- Migration: 048_rate_violations
+-- Description: Repeated rate limit violations per key, for escalating cooldowns
+-- A key's strikes reset once it has gone a day without a violation.
+
+CREATE TABLE IF NOT EXISTS rate_violations (
+    limiter VARCHAR(50) NOT NULL,
+    key VARCHAR(64) NOT NULL,
+    strikes INTEGER NOT NULL DEFAULT 0,
+    last_violation_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    blocked_until TIMESTAMP WITH TIME ZONE NOT NULL,
+    PRIMARY KEY (limiter, key)
+);
+
+CREATE INDEX IF NOT EXISTS idx_rate_violations_last_violation_at ON rate_violations(last_violation_at);
@@ -0,0 +1,17 @@
+-- Migration: 049_checkin_codes
+-- Description: Short-lived check-in codes shown at a venue; posts made with
+-- one carry a verified attendee badge
+
+CREATE TABLE IF NOT EXISTS checkin_codes (
+    id SERIAL PRIMARY KEY,
+    event_name VARCHAR(200) NOT NULL REFERENCES events(name) ON UPDATE CASCADE ON DELETE CASCADE,
+    code VARCHAR(16) NOT NULL,
+    label VARCHAR(100) NOT NULL DEFAULT '',
+    uses INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    revoked_at TIMESTAMP WITH TIME ZONE,
+    UNIQUE (event_name, code)
+);
+
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS verified_attendee BOOLEAN NOT NULL DEFAULT FALSE;
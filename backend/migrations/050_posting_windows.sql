@@ -0,0 +1,5 @@
+-- Migration: 050_posting_windows
+-- Description: Per-event posting windows: when a board opens and closes to
+-- posts, and its daily hours
+
+ALTER TABLE events ADD COLUMN IF NOT EXISTS posting_window JSONB;
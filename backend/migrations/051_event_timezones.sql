@@ -0,0 +1,5 @@
+-- Migration: 051_event_timezones
+-- Description: The IANA timezone an event takes place in, so post times can
+-- be shown as they were at the venue
+
+ALTER TABLE events ADD COLUMN IF NOT EXISTS timezone TEXT;
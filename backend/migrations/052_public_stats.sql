@@ -0,0 +1,11 @@
+-- Migration: 052_public_stats
+-- Description: Weekly totals for the public stats page, rolled up so they
+-- outlive the posts they count
+
+CREATE TABLE IF NOT EXISTS public_stats (
+    week_start TIMESTAMP WITH TIME ZONE PRIMARY KEY,
+    posts INTEGER NOT NULL,
+    active_events INTEGER NOT NULL,
+    matched_posts INTEGER NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,19 @@
+-- Migration: 053_stories
+-- Description: Success stories from people who found each other through a
+-- board, reviewed by curators before they are published
+
+CREATE TABLE IF NOT EXISTS stories (
+    id SERIAL PRIMARY KEY,
+    content TEXT NOT NULL,
+    signature VARCHAR(100) NOT NULL DEFAULT '',
+    post_id INTEGER REFERENCES posts(id) ON DELETE SET NULL,
+    event_name VARCHAR(200) REFERENCES events(name) ON UPDATE CASCADE ON DELETE SET NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    featured BOOLEAN NOT NULL DEFAULT FALSE,
+    ip_hash VARCHAR(64),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    reviewed_at TIMESTAMP WITH TIME ZONE,
+    published_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS idx_stories_status_created ON stories(status, created_at);
@@ -0,0 +1,12 @@
+-- Migration: 054_reactions
+-- Description: Hearts on posts, one per reader's IP hash, with the count
+-- kept on posts like reply_count
+
+CREATE TABLE IF NOT EXISTS reactions (
+    post_id INTEGER NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+    ip_hash VARCHAR(64) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (post_id, ip_hash)
+);
+
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS reaction_count INTEGER NOT NULL DEFAULT 0;
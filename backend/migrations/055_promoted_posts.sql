@@ -0,0 +1,18 @@
+-- Migration: 055_promoted_posts
+-- Description: Sponsored cards organizers place in an event's feed, with
+-- the times each was shown
+
+CREATE TABLE IF NOT EXISTS promoted_posts (
+    id SERIAL PRIMARY KEY,
+    event_name VARCHAR(200) NOT NULL REFERENCES events(name) ON UPDATE CASCADE ON DELETE CASCADE,
+    sponsor VARCHAR(100) NOT NULL,
+    content TEXT NOT NULL,
+    link_url TEXT NOT NULL DEFAULT '',
+    position INTEGER NOT NULL,
+    starts_at TIMESTAMP WITH TIME ZONE,
+    ends_at TIMESTAMP WITH TIME ZONE,
+    impressions BIGINT NOT NULL DEFAULT 0,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_promoted_posts_event ON promoted_posts(event_name, created_at);
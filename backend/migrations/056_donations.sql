@@ -0,0 +1,19 @@
+-- Migration: 056_donations
+-- Description: Donations received through payment provider webhooks, for
+-- supporter counts and badges
+
+CREATE TABLE IF NOT EXISTS donations (
+    id SERIAL PRIMARY KEY,
+    provider VARCHAR(20) NOT NULL,
+    -- The provider's ID for the payment, as webhooks are retried
+    provider_id VARCHAR(255) NOT NULL,
+    event_name VARCHAR(200) REFERENCES events(name) ON UPDATE CASCADE ON DELETE SET NULL,
+    device_token_hash VARCHAR(64),
+    amount_cents BIGINT NOT NULL,
+    currency VARCHAR(3) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (provider, provider_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_donations_event ON donations(event_name);
+CREATE INDEX IF NOT EXISTS idx_donations_device ON donations(device_token_hash) WHERE device_token_hash IS NOT NULL;
@@ -0,0 +1,9 @@
+-- Migration: 057_comments
+-- Description: Comments posted on the web alongside federated replies, as
+-- replies with source 'web'. Replies can answer one another, and keep the
+-- commenter's IP hash for moderation.
+
+ALTER TABLE replies ADD COLUMN IF NOT EXISTS parent_id INTEGER REFERENCES replies(id) ON DELETE CASCADE;
+ALTER TABLE replies ADD COLUMN IF NOT EXISTS ip_hash VARCHAR(64);
+
+CREATE INDEX IF NOT EXISTS idx_replies_parent ON replies(parent_id) WHERE parent_id IS NOT NULL;
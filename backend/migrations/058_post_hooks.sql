@@ -0,0 +1,13 @@
+-- Migration: 058_post_hooks
+-- Description: How far post hooks have got through the post events, so
+-- each report is handed to them once however many instances run. Reports
+-- made before hooks existed aren't replayed.
+
+CREATE TABLE IF NOT EXISTS hook_cursors (
+    name VARCHAR(50) PRIMARY KEY,
+    last_event_id BIGINT NOT NULL
+);
+
+INSERT INTO hook_cursors (name, last_event_id)
+SELECT 'reports', COALESCE(MAX(id), 0) FROM post_events
+ON CONFLICT (name) DO NOTHING;
@@ -0,0 +1,5 @@
+-- Migration: 059_admin_api_keys
+-- Description: API keys that can use the admin routes, so each moderator
+-- can have their own revocable key instead of sharing ADMIN_TOKEN
+
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS admin BOOLEAN NOT NULL DEFAULT FALSE;
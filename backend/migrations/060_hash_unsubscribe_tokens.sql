@@ -0,0 +1,21 @@
+-- Migration: 060_hash_unsubscribe_tokens
+-- Description: Store alert unsubscribe tokens hashed, like confirm tokens
+-- Each alert email links to a token of its own, so only their hashes need
+-- keeping. Existing tokens are hashed over, so links in emails already
+-- sent keep working.
+
+CREATE TABLE IF NOT EXISTS email_alert_unsubscribe_tokens (
+    token_hash VARCHAR(64) PRIMARY KEY,
+    alert_id INTEGER NOT NULL REFERENCES email_alerts(id) ON DELETE CASCADE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_email_alert_unsubscribe_tokens_alert ON email_alert_unsubscribe_tokens(alert_id);
+CREATE INDEX IF NOT EXISTS idx_email_alert_unsubscribe_tokens_created ON email_alert_unsubscribe_tokens(created_at);
+
+INSERT INTO email_alert_unsubscribe_tokens (token_hash, alert_id)
+SELECT encode(sha256(convert_to(unsubscribe_token, 'UTF8')), 'hex'), id
+FROM email_alerts
+ON CONFLICT DO NOTHING;
+
+ALTER TABLE email_alerts DROP COLUMN IF EXISTS unsubscribe_token;
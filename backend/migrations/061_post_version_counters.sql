@@ -0,0 +1,21 @@
+-- Migration: 061_post_version_counters
+-- Description: Don't bump a post's version when only its counters change
+-- Replies, hearts and reports update the counts kept on posts, and the
+-- nightly reconcile corrects them. None of that is a change the author or a
+-- moderator could clobber, so it shouldn't make their If-Match fail.
+
+CREATE OR REPLACE FUNCTION bump_post_version() RETURNS TRIGGER AS $$
+DECLARE
+    counters CONSTANT TEXT[] := ARRAY['version', 'reply_count', 'report_count', 'reaction_count', 'edit_count'];
+BEGIN
+    IF (to_jsonb(NEW) - counters) IS DISTINCT FROM (to_jsonb(OLD) - counters) THEN
+        NEW.version := OLD.version + 1;
+    END IF;
+    RETURN NEW;
+END
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS posts_bump_version ON posts;
+CREATE TRIGGER posts_bump_version
+    BEFORE UPDATE ON posts
+    FOR EACH ROW EXECUTE FUNCTION bump_post_version();
@@ -3,19 +3,83 @@ package main
 import "time"
 
 type Post struct {
+	// PublicID identifies the post in public URLs. ID is internal, and will
+	// be dropped from public responses once clients have moved over. UUID
+	// is a time-sortable UUIDv7, which will replace ID as the primary key
 	ID        int       `json:"id"`
+	PublicID  string    `json:"public_id"`
+	UUID      string    `json:"uuid"`
 	EventName string    `json:"event_name"`
 	Content   string    `json:"content"`
-	Age       int       `json:"age"`
-	Gender    string    `json:"gender"`
-	Location  string    `json:"location"`
+	Age       *int      `json:"age,omitempty"`
+	Gender    string    `json:"gender,omitempty"`
+	Location  string    `json:"location,omitempty"`
 	CreatedAt time.Time `json:"created_at"`
+	// CreatedAtLocal is CreatedAt in the event's timezone, Timezone, and
+	// CreatedAtDisplay the same ready to show, such as "Saturday 11:43 PM".
+	// They are only set on posts to events with a timezone
+	CreatedAtLocal   *time.Time `json:"created_at_local,omitempty"`
+	CreatedAtDisplay string     `json:"created_at_display,omitempty"`
+	Timezone         string     `json:"timezone,omitempty"`
+
+	CustomFields CustomFieldValues `json:"custom_fields,omitempty"`
+	TemplateID   *int              `json:"template_id,omitempty"`
+	SessionID    *int              `json:"session_id,omitempty"`
+	Attachments  []Attachment      `json:"attachments,omitempty"`
+	// ContentWarning, when set, is shown in place of the content until the
+	// reader chooses to see it
+	ContentWarning string `json:"content_warning,omitempty"`
+	// EditedAt and EditCount mark posts changed since they were published;
+	// moderators can see the earlier versions
+	EditedAt  *time.Time `json:"edited_at,omitempty"`
+	EditCount int        `json:"edit_count,omitempty"`
+	// HiddenAt is set when a moderator has hidden the post
+	HiddenAt *time.Time `json:"hidden_at,omitempty"`
+	// ReplyCount counts the post's replies. ReportCount, the times it has
+	// been flagged for moderation, is shown to moderators only
+	ReplyCount  int `json:"reply_count,omitempty"`
+	ReportCount int `json:"-"`
+	// ReactionCount counts the readers who have hearted the post
+	ReactionCount int `json:"reaction_count"`
+	// VerifiedAttendee marks a post made with a check-in code shown at
+	// the venue
+	VerifiedAttendee bool `json:"verified_attendee,omitempty"`
+	// IsPromoted marks a sponsored card placed in the feed by the
+	// organizers, with Promotion saying who it is from; it isn't a post
+	IsPromoted bool            `json:"is_promoted,omitempty"`
+	Promotion  *PromotionLabel `json:"promotion,omitempty"`
+	// Archived is set on posts served from an archived board's snapshot
+	Archived bool `json:"archived,omitempty"`
+	// EditToken is returned only when the post is created, and is needed
+	// to edit it
+	EditToken string `json:"edit_token,omitempty"`
+	// Version counts changes to the row; it is sent as the post's ETag
+	Version int `json:"-"`
 }
 
 type CreatePostRequest struct {
-	EventName string `json:"event_name"`
-	Content   string `json:"content"`
-	Age       int    `json:"age"`
-	Gender    string `json:"gender"`
-	Location  string `json:"location"`
+	EventName    string `json:"event_name"`
+	Content      string `json:"content"`
+	Age          int    `json:"age"`
+	Gender       string `json:"gender"`
+	Location     string `json:"location"`
+	TermsVersion string `json:"terms_version"`
+
+	CustomFields CustomFieldValues `json:"custom_fields"`
+	TemplateID   *int              `json:"template_id"`
+	SessionID    *int              `json:"session_id"`
+	// AttachmentIDs are uploads from POST /api/attachments
+	AttachmentIDs  []int  `json:"attachment_ids"`
+	ContentWarning string `json:"content_warning"`
+	// InviteCode admits the post while its event is invite-only
+	InviteCode string `json:"invite_code"`
+	// CheckinCode, shown at the venue, earns the post a verified
+	// attendee badge
+	CheckinCode string `json:"checkin_code"`
+
+	// DeviceTokenHash is the hash of the poster's X-Device-Token, if they
+	// sent one, so they can be told if the post is removed
+	DeviceTokenHash string `json:"-"`
+	// VerifiedAttendee is set once CheckinCode has been checked
+	VerifiedAttendee bool `json:"-"`
 }
\ No newline at end of file
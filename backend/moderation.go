@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ContentFlag is a reason a post should be looked at by a moderator.
+type ContentFlag struct {
+	Reason  string `json:"reason"`
+	Details string `json:"details"`
+}
+
+type contentSignal struct {
+	pattern *regexp.Regexp
+	weight  int
+	label   string
+}
+
+// minorSignals are phrases suggesting the author is under 18. Strong signals
+// flag on their own; weak ones only in combination.
+var minorSignals = []contentSignal{
+	{regexp.MustCompile(`(?i)\b(i'?m|i am|im)\s+(1[0-7]|[5-9])\s*(yo|y/?o|years?\s+old)?([\s.,!?]|$)`), 2, "states an age under 18"},
+	{regexp.MustCompile(`(?i)\b(1[0-7]|[5-9])\s*(yo|y/?o|years?\s+old)\b`), 2, "mentions an age under 18"},
+	{regexp.MustCompile(`(?i)\b(i'?m|i am|im)\s+(in|a)\s+(middle school|junior high|high school|([6-9]|1[01])th grade)`), 2, "says they are in school"},
+	{regexp.MustCompile(`(?i)\b(freshman|sophomore|junior)\s+(in|at)\s+high\s+school\b`), 2, "says they are in high school"},
+	{regexp.MustCompile(`(?i)\bmy\s+(mom|dad|parents?)\s+(won'?t|wouldn'?t|don'?t|didn'?t)\s+let\s+me\b`), 1, "mentions parental permission"},
+	{regexp.MustCompile(`(?i)\b(homework|homeroom|curfew|permission slip)\b`), 1, "mentions school or curfew"},
+}
+
+const minorFlagThreshold = 2
+
+// sensitiveSignals are topics that get a content warning, labelled with the
+// warning text, when the poster didn't add one themselves.
+var sensitiveSignals = []contentSignal{
+	{regexp.MustCompile(`(?i)\b(nsfw|nudes?|naked|porn\w*|sex(ual|ually)?|hooked up|hookup)\b`), 1, "sexual content"},
+	{regexp.MustCompile(`(?i)\b(molly|mdma|ketamine|cocaine|coke|acid|lsd|shrooms|tripping|overdos(e|ed|ing))\b`), 1, "drug use"},
+	{regexp.MustCompile(`(?i)\b(suicid\w*|self[- ]harm|kill (myself|himself|herself|themselves))\b`), 1, "self-harm"},
+	{regexp.MustCompile(`(?i)\b(blood(y)?|stabb(ed|ing)|assault(ed)?|beaten up|fight broke out)\b`), 1, "violence"},
+}
+
+// scoreContent runs the content heuristics over a post and returns any flags
+// that should send it to the moderation queue.
+func scoreContent(content string) []ContentFlag {
+	var flags []ContentFlag
+
+	score := 0
+	var labels []string
+	for _, signal := range minorSignals {
+		if signal.pattern.MatchString(content) {
+			score += signal.weight
+			labels = append(labels, signal.label)
+		}
+	}
+	if score >= minorFlagThreshold {
+		flags = append(flags, ContentFlag{Reason: "possible_minor", Details: strings.Join(labels, "; ")})
+	}
+
+	return flags
+}
+
+// suggestContentWarning returns a content warning naming the sensitive
+// topics a post touches on, or "" if there are none.
+func suggestContentWarning(content string) string {
+	var labels []string
+	for _, signal := range sensitiveSignals {
+		if signal.pattern.MatchString(content) {
+			labels = append(labels, signal.label)
+		}
+	}
+	return strings.Join(labels, ", ")
+}
+
+// screenPost queues a newly created post for review if the heuristics flag
+// it, and adds a content warning if it needs one and the poster gave none.
+// Posts stay visible while queued. Run it in the transaction that wrote the
+// post so a post is never left unscreened.
+func screenPost(ctx context.Context, db Store, post *Post) error {
+	for _, flag := range scoreContent(post.Content) {
+		if err := db.EnqueueModeration(ctx, post.ID, flag, "heuristic"); err != nil {
+			return fmt.Errorf("failed to queue post %d for moderation: %w", post.ID, err)
+		}
+	}
+
+	if post.ContentWarning == "" {
+		if warning := suggestContentWarning(post.Content); warning != "" {
+			if _, err := db.SetContentWarning(ctx, post.ID, warning); err != nil {
+				return fmt.Errorf("failed to set content warning on post %d: %w", post.ID, err)
+			}
+			post.ContentWarning = warning
+		}
+	}
+	return nil
+}
+
+type ModerationItem struct {
+	ID         int        `json:"id"`
+	Post       Post       `json:"post"`
+	Reason     string     `json:"reason"`
+	Details    string     `json:"details,omitempty"`
+	Source     string     `json:"source"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"`
+	ResolvedBy string     `json:"resolved_by,omitempty"`
+	Resolution string     `json:"resolution,omitempty"`
+
+	// ReportCount is how many times the post has been flagged, this
+	// included
+	ReportCount int `json:"report_count"`
+}
+
+type ResolveModerationRequest struct {
+	Resolution string `json:"resolution"`
+}
+
+// GetModerationQueue handles GET /admin/moderation/queue. Open items are
+// returned oldest first; pass ?status=resolved for the history.
+func (h *Handler) GetModerationQueue(w http.ResponseWriter, r *http.Request) {
+	resolved := r.URL.Query().Get("status") == "resolved"
+	limit, offset := parsePagination(r)
+
+	items, err := h.db.GetModerationQueue(r.Context(), resolved, limit, offset)
+	if err != nil {
+		log.Printf("Error getting moderation queue: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve moderation queue")
+		return
+	}
+
+	if items == nil {
+		items = []ModerationItem{}
+	}
+
+	respondWithJSON(w, http.StatusOK, items)
+}
+
+// ResolveModerationItem handles POST /admin/moderation/queue/{id}/resolve
+func (h *Handler) ResolveModerationItem(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid moderation item ID")
+		return
+	}
+
+	var req ResolveModerationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if req.Resolution != "dismissed" && req.Resolution != "actioned" {
+		respondWithError(w, http.StatusBadRequest, "resolution must be dismissed or actioned")
+		return
+	}
+
+	found, err := h.db.ResolveModerationItem(r.Context(), id, req.Resolution, adminActor(r))
+	if err != nil {
+		log.Printf("Error resolving moderation item: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to resolve moderation item")
+		return
+	}
+	if !found {
+		respondWithError(w, http.StatusNotFound, "Open moderation item not found")
+		return
+	}
+
+	h.audit(r, "moderation.resolve", "moderation_item", strconv.Itoa(id), req)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// EnqueueModeration flags a post for moderators, counting it in the post's
+// report count.
+func (db *DB) EnqueueModeration(ctx context.Context, postID int, flag ContentFlag, source string) error {
+	_, err := db.conn.ExecContext(ctx, `
+		WITH item AS (
+			INSERT INTO moderation_queue (post_id, reason, details, source)
+			VALUES ($1, $2, NULLIF($3, ''), $4)
+			RETURNING post_id
+		)
+		UPDATE posts SET report_count = report_count + 1
+		FROM item
+		WHERE posts.id = item.post_id
+	`, postID, flag.Reason, flag.Details, source)
+	if err != nil {
+		return fmt.Errorf("failed to enqueue moderation item: %w", err)
+	}
+	return nil
+}
+
+// CountOpenModerationItems counts the unresolved moderation queue items.
+func (db *DB) CountOpenModerationItems(ctx context.Context) (int, error) {
+	var count int
+	err := db.conn.QueryRowContext(ctx, "SELECT COUNT(*) FROM moderation_queue WHERE resolved_at IS NULL").Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count moderation queue: %w", err)
+	}
+	return count, nil
+}
+
+func (db *DB) GetModerationQueue(ctx context.Context, resolved bool, limit, offset int) ([]ModerationItem, error) {
+	order := "q.created_at ASC"
+	if resolved {
+		order = "q.resolved_at DESC"
+	}
+
+	query := `
+		SELECT p.*, q.id, q.reason, COALESCE(q.details, ''), q.source, q.created_at,
+			q.resolved_at, COALESCE(q.resolved_by, ''), COALESCE(q.resolution, '')
+		FROM moderation_queue q
+		JOIN LATERAL (SELECT ` + postColumns + ` FROM posts WHERE posts.id = q.post_id) p ON TRUE
+		WHERE (q.resolved_at IS NOT NULL) = $1
+		ORDER BY ` + order + `
+		LIMIT $2 OFFSET $3
+	`
+
+	rows, err := db.conn.QueryContext(ctx, query, resolved, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query moderation queue: %w", err)
+	}
+	defer rows.Close()
+
+	var items []ModerationItem
+	for rows.Next() {
+		var item ModerationItem
+		post, err := scanPost(rows,
+			&item.ID, &item.Reason, &item.Details, &item.Source, &item.CreatedAt,
+			&item.ResolvedAt, &item.ResolvedBy, &item.Resolution,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan moderation item: %w", err)
+		}
+		item.Post, item.ReportCount = *post, post.ReportCount
+		items = append(items, item)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating moderation queue: %w", err)
+	}
+
+	return items, nil
+}
+
+// ResolveModerationItem closes an open item, reporting whether one existed.
+func (db *DB) ResolveModerationItem(ctx context.Context, id int, resolution, resolvedBy string) (bool, error) {
+	result, err := db.conn.ExecContext(ctx, `
+		UPDATE moderation_queue
+		SET resolved_at = NOW(), resolved_by = $2, resolution = $3
+		WHERE id = $1 AND resolved_at IS NULL
+	`, id, resolvedBy, resolution)
+	if err != nil {
+		return false, fmt.Errorf("failed to resolve moderation item: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to resolve moderation item: %w", err)
+	}
+
+	return affected > 0, nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Notification kinds
+const (
+	// NotificationReply is a reply to one of the device's posts
+	NotificationReply = "reply"
+	// NotificationReaction is a heart on one of the device's posts
+	NotificationReaction = "reaction"
+)
+
+// maxMarkRead caps how many notifications one request can mark read by ID.
+const maxMarkRead = 100
+
+// Notification is an entry in a device's inbox. Devices are recognised by
+// the X-Device-Token they posted with.
+type Notification struct {
+	ID   int    `json:"id"`
+	Kind string `json:"kind"`
+	// PostID is the public ID of the device's post the notification is about
+	PostID string `json:"post_id"`
+	// Content is the reply's text; reactions have none
+	Content   string     `json:"content,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	ReadAt    *time.Time `json:"read_at,omitempty"`
+}
+
+type MarkNotificationsReadRequest struct {
+	// IDs are the notifications to mark read; without any, every
+	// notification is
+	IDs []int `json:"ids"`
+}
+
+// GetMyNotifications handles GET /api/me/notifications, newest first. Pass
+// ?unread=true for only unread notifications.
+func (h *Handler) GetMyNotifications(w http.ResponseWriter, r *http.Request) {
+	tokenHash, ok := deviceToken(w, r)
+	if !ok {
+		return
+	}
+	limit, offset := parsePagination(r)
+
+	notifications, err := h.db.GetNotifications(r.Context(), tokenHash, r.URL.Query().Get("unread") == "true", limit, offset)
+	if err != nil {
+		log.Printf("Error getting notifications: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve notifications")
+		return
+	}
+
+	if notifications == nil {
+		notifications = []Notification{}
+	}
+
+	respondWithJSON(w, http.StatusOK, notifications)
+}
+
+// MarkMyNotificationsRead handles POST /api/me/notifications/read. The body
+// is optional; without IDs every notification is marked read.
+func (h *Handler) MarkMyNotificationsRead(w http.ResponseWriter, r *http.Request) {
+	tokenHash, ok := deviceToken(w, r)
+	if !ok {
+		return
+	}
+
+	var req MarkNotificationsReadRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if len(req.IDs) > maxMarkRead {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("ids must list at most %d notifications", maxMarkRead))
+		return
+	}
+
+	if err := h.db.MarkNotificationsRead(r.Context(), tokenHash, req.IDs); err != nil {
+		log.Printf("Error marking notifications read: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to mark notifications read")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetNotifications lists a device's notifications on visible posts, newest
+// first.
+func (db *DB) GetNotifications(ctx context.Context, deviceTokenHash string, unreadOnly bool, limit, offset int) ([]Notification, error) {
+	rows, err := db.conn.QueryContext(ctx, `
+		SELECT n.id, n.kind, p.public_id, COALESCE(r.content, ''), n.created_at, n.read_at
+		FROM notifications n
+		JOIN posts p ON p.id = n.post_id
+		LEFT JOIN replies r ON r.id = n.reply_id
+		WHERE n.device_token_hash = $1 AND p.hidden_at IS NULL
+		AND (NOT $2 OR n.read_at IS NULL)
+		ORDER BY n.created_at DESC, n.id DESC
+		LIMIT $3 OFFSET $4
+	`, deviceTokenHash, unreadOnly, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query notifications: %w", err)
+	}
+	defer rows.Close()
+
+	var notifications []Notification
+	for rows.Next() {
+		var n Notification
+		if err := rows.Scan(&n.ID, &n.Kind, &n.PostID, &n.Content, &n.CreatedAt, &n.ReadAt); err != nil {
+			return nil, fmt.Errorf("failed to scan notification: %w", err)
+		}
+		notifications = append(notifications, n)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating notifications: %w", err)
+	}
+
+	return notifications, nil
+}
+
+// MarkNotificationsRead marks a device's notifications read: those in ids,
+// or all of them if ids is empty. IDs belonging to other devices are
+// ignored.
+func (db *DB) MarkNotificationsRead(ctx context.Context, deviceTokenHash string, ids []int) error {
+	if ids == nil {
+		ids = []int{}
+	}
+	_, err := db.conn.ExecContext(ctx, `
+		UPDATE notifications SET read_at = NOW()
+		WHERE device_token_hash = $1 AND read_at IS NULL
+		AND (cardinality($2::int[]) = 0 OR id = ANY($2))
+	`, deviceTokenHash, ids)
+	if err != nil {
+		return fmt.Errorf("failed to mark notifications read: %w", err)
+	}
+	return nil
+}
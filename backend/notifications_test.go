@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGetMyNotifications(t *testing.T) {
+	read := time.Date(2026, 7, 1, 12, 0, 0, 0, time.UTC)
+	store := newFakeStore()
+	store.notifications = []Notification{
+		{ID: 2, Kind: NotificationReply, PostID: "aaaaaaaaaa", Content: "Same!", CreatedAt: read},
+		{ID: 1, Kind: NotificationReply, PostID: "aaaaaaaaaa", Content: "Where?", CreatedAt: read, ReadAt: &read},
+	}
+	h := newTestHandler(store, HandlerConfig{})
+
+	req := httptest.NewRequest(http.MethodGet, "/api/me/notifications?unread=true", nil)
+	req.Header.Set("X-Device-Token", strings.Repeat("d", 16))
+	rec := httptest.NewRecorder()
+	h.GetMyNotifications(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200 (body %s)", rec.Code, rec.Body)
+	}
+	want := `[{"id":2,"kind":"reply","post_id":"aaaaaaaaaa","content":"Same!","created_at":"2026-07-01T12:00:00Z"}]`
+	if got := strings.TrimSpace(rec.Body.String()); got != want {
+		t.Errorf("body = %s, want %s", got, want)
+	}
+}
+
+func TestMarkMyNotificationsRead(t *testing.T) {
+	token := strings.Repeat("d", 16)
+	tests := []struct {
+		name     string
+		body     string
+		status   int
+		errorMsg string
+		wantIDs  []int
+	}{
+		{name: "all", status: 204},
+		{name: "empty object", body: `{}`, status: 204},
+		{name: "some", body: `{"ids":[3,5]}`, status: 204, wantIDs: []int{3, 5}},
+		{name: "invalid body", body: `{"ids":"all"}`, status: 400, errorMsg: "Invalid request body"},
+		{
+			name:     "too many",
+			body:     `{"ids":[` + strings.Repeat("1,", maxMarkRead) + `1]}`,
+			status:   400,
+			errorMsg: "ids must list at most 100 notifications",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newFakeStore()
+			h := newTestHandler(store, HandlerConfig{})
+
+			req := httptest.NewRequest(http.MethodPost, "/api/me/notifications/read", strings.NewReader(tt.body))
+			req.Header.Set("X-Device-Token", token)
+			rec := httptest.NewRecorder()
+			h.MarkMyNotificationsRead(rec, req)
+
+			if tt.errorMsg != "" {
+				assertError(t, rec, tt.status, tt.errorMsg)
+				return
+			}
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d (body %s)", rec.Code, tt.status, rec.Body)
+			}
+			if store.readBy != hashToken(token) || !reflect.DeepEqual(store.readIDs, tt.wantIDs) {
+				t.Errorf("marked %v read for %q, want %v for the token's hash", store.readIDs, store.readBy, tt.wantIDs)
+			}
+		})
+	}
+}
+
+func TestNotifications(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	device := hashToken(fmt.Sprintf("notifications-test-%d", time.Now().UnixNano()))
+
+	post, err := db.CreatePost(ctx, CreatePostRequest{EventName: "Notifications Test", Content: "Anyone here?", DeviceTokenHash: device}, hashIP("notifications-test"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 2 {
+		uri := fmt.Sprintf("https://example.com/notes/%s-%d", device, i)
+		if err := db.CreateFederatedReply(ctx, post.ID, "Me!", "https://example.com/users/a", uri); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	notifications, err := db.GetNotifications(ctx, device, true, 50, 0)
+	if err != nil || len(notifications) != 2 || notifications[0].PostID != post.PublicID || notifications[0].Content != "Me!" {
+		t.Fatalf("GetNotifications = %+v, %v; want both replies", notifications, err)
+	}
+	counts, err := db.GetDeviceCounts(ctx, device)
+	if err != nil || counts.Replies != 2 || counts.LastModified.IsZero() {
+		t.Fatalf("GetDeviceCounts = %+v, %v; want 2 replies", counts, err)
+	}
+
+	if err := db.MarkNotificationsRead(ctx, device, []int{notifications[0].ID}); err != nil {
+		t.Fatal(err)
+	}
+	if counts, err := db.GetDeviceCounts(ctx, device); err != nil || counts.Replies != 1 {
+		t.Errorf("GetDeviceCounts = %+v, %v; want 1 reply after marking one read", counts, err)
+	}
+	if err := db.MarkNotificationsRead(ctx, device, nil); err != nil {
+		t.Fatal(err)
+	}
+	if unread, err := db.GetNotifications(ctx, device, true, 50, 0); err != nil || len(unread) != 0 {
+		t.Errorf("unread notifications = %+v, %v; want none", unread, err)
+	}
+	if all, err := db.GetNotifications(ctx, device, false, 50, 0); err != nil || len(all) != 2 || all[0].ReadAt == nil {
+		t.Errorf("notifications = %+v, %v; want both, read", all, err)
+	}
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// openAPISpec documents the public API. The contract tests check handlers
+// against it, so a change to a response shape must update both.
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// GetOpenAPISpec handles GET /api/openapi.json
+func (h *Handler) GetOpenAPISpec(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(openAPISpec)
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// JobStatus reports a background job's current or last manual run.
+type JobStatus struct {
+	Name       string     `json:"name"`
+	Running    bool       `json:"running"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+type job struct {
+	run    func(context.Context)
+	status JobStatus
+}
+
+// Jobs lets admins run background jobs now rather than waiting for their
+// next tick, one run of each job at a time. Runs are in addition to the
+// jobs' own schedules, so each job must tolerate running twice at once.
+type Jobs struct {
+	ctx context.Context
+
+	mu   sync.Mutex
+	jobs map[string]*job
+}
+
+// NewJobs creates the registry; runs stop when ctx is cancelled.
+func NewJobs(ctx context.Context) *Jobs {
+	return &Jobs{ctx: ctx, jobs: make(map[string]*job)}
+}
+
+// Register adds a job that can be run by name.
+func (j *Jobs) Register(name string, run func(context.Context)) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.jobs[name] = &job{run: run, status: JobStatus{Name: name}}
+}
+
+// Start begins a run of the named job in the background. It reports false
+// for found if there is no such job, and for started if it is already
+// running.
+func (j *Jobs) Start(name string) (status JobStatus, found, started bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	jb, ok := j.jobs[name]
+	if !ok {
+		return JobStatus{}, false, false
+	}
+	if jb.status.Running {
+		return jb.status, true, false
+	}
+	now := time.Now()
+	jb.status = JobStatus{Name: name, Running: true, StartedAt: &now}
+
+	go func() {
+		jb.run(j.ctx)
+
+		j.mu.Lock()
+		defer j.mu.Unlock()
+		now := time.Now()
+		jb.status.Running = false
+		jb.status.FinishedAt = &now
+		log.Printf("Job %s finished", name)
+	}()
+	return jb.status, true, true
+}
+
+// Status lists the jobs by name.
+func (j *Jobs) Status() []JobStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	statuses := make([]JobStatus, 0, len(j.jobs))
+	for _, jb := range j.jobs {
+		statuses = append(statuses, jb.status)
+	}
+	sort.Slice(statuses, func(a, b int) bool { return statuses[a].Name < statuses[b].Name })
+	return statuses
+}
+
+// GetJobs handles GET /admin/jobs
+func (h *Handler) GetJobs(w http.ResponseWriter, r *http.Request) {
+	if h.cfg.Jobs == nil {
+		respondWithJSON(w, http.StatusOK, []JobStatus{})
+		return
+	}
+	respondWithJSON(w, http.StatusOK, h.cfg.Jobs.Status())
+}
+
+// StartJob handles POST /admin/jobs/{name}
+func (h *Handler) StartJob(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if h.cfg.Jobs == nil {
+		respondWithError(w, http.StatusNotFound, "Job not found")
+		return
+	}
+
+	status, found, started := h.cfg.Jobs.Start(name)
+	if !found {
+		respondWithError(w, http.StatusNotFound, "Job not found")
+		return
+	}
+	if !started {
+		respondWithError(w, http.StatusConflict, "The job is already running")
+		return
+	}
+
+	h.audit(r, "job.run", "job", name, nil)
+
+	respondWithJSON(w, http.StatusAccepted, status)
+}
+
+// OpsMetrics is the live view of the server for the admin dashboard.
+type OpsMetrics struct {
+	WindowMinutes int `json:"window_minutes"`
+	// Minutes are this process's request counts, oldest first
+	Minutes   []MetricsMinute `json:"minutes"`
+	ErrorRate float64         `json:"error_rate"`
+	Latency   StatusLatency   `json:"latency_ms"`
+	// ModerationQueue is the number of open moderation queue items
+	ModerationQueue int `json:"moderation_queue"`
+	// WriteQueue is the number of posts waiting for the database
+	WriteQueue int `json:"write_queue"`
+	// WriteConcurrency is the write concurrency limiter's state, if it is
+	// enabled
+	WriteConcurrency *ConcurrencyStats `json:"write_concurrency,omitempty"`
+	// HotEvents is the hot event cache tier's state, if it is enabled
+	HotEvents *HotTierStats `json:"hot_events,omitempty"`
+}
+
+// GetMetrics handles GET /admin/metrics. Unlike the public status page it
+// includes request counts, and it isn't cached, so dashboards can poll it.
+func (h *Handler) GetMetrics(w http.ResponseWriter, r *http.Request) {
+	if h.cfg.Metrics == nil {
+		respondWithError(w, http.StatusNotFound, "Metrics are not enabled")
+		return
+	}
+
+	queued, err := h.db.CountOpenModerationItems(r.Context())
+	if err != nil {
+		log.Printf("Error counting moderation queue: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve metrics")
+		return
+	}
+
+	now := time.Now()
+	m := h.cfg.Metrics.Snapshot(statusWindow, now)
+	respondWithJSON(w, http.StatusOK, OpsMetrics{
+		WindowMinutes: int(statusWindow / time.Minute),
+		Minutes:       h.cfg.Metrics.Minutes(statusWindow, now),
+		ErrorRate:     m.ErrorRate,
+		Latency: StatusLatency{
+			P50: m.P50.Milliseconds(),
+			P95: m.P95.Milliseconds(),
+			P99: m.P99.Milliseconds(),
+		},
+		ModerationQueue:  queued,
+		WriteQueue:       h.cfg.WriteQueue.pending(),
+		WriteConcurrency: h.cfg.WriteLimiter.stats(),
+		HotEvents:        h.cfg.HotTier.stats(),
+	})
+}
+
+// GetRateLimits handles GET /admin/rate-limits. Pass ?key= with an IP hash,
+// or ?ip= with an address to hash, to see how many requests it has left.
+func (h *Handler) GetRateLimits(w http.ResponseWriter, r *http.Request) {
+	key := r.URL.Query().Get("key")
+	if ip := r.URL.Query().Get("ip"); ip != "" {
+		key = hashIP(ip)
+	}
+
+	statuses := []RateLimitStatus{}
+	for _, limiter := range h.cfg.RateLimiters {
+		status, err := limiter.Status(r.Context(), key)
+		if err != nil {
+			log.Printf("Error getting rate limit status: %v", err)
+			respondWithError(w, http.StatusInternalServerError, "Failed to retrieve rate limits")
+			return
+		}
+		statuses = append(statuses, status)
+	}
+
+	respondWithJSON(w, http.StatusOK, statuses)
+}
+
+// ClearRateViolations handles DELETE /admin/rate-limits/violations?ip= (or
+// ?key=), lifting a client's escalated cooldowns, for someone caught by
+// mistake.
+func (h *Handler) ClearRateViolations(w http.ResponseWriter, r *http.Request) {
+	key := r.URL.Query().Get("key")
+	if ip := r.URL.Query().Get("ip"); ip != "" {
+		key = hashIP(ip)
+	}
+	if key == "" {
+		respondWithError(w, http.StatusBadRequest, "ip or key is required")
+		return
+	}
+
+	found, err := h.db.ClearRateViolations(r.Context(), key)
+	if err != nil {
+		log.Printf("Error clearing rate limit violations: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to clear rate limit violations")
+		return
+	}
+	if !found {
+		respondWithError(w, http.StatusNotFound, "No rate limit violations for that client")
+		return
+	}
+
+	h.audit(r, "rate_limit.clear_violations", "ip_hash", key, nil)
+
+	w.WriteHeader(http.StatusNoContent)
+}
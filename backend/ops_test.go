@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJobs(t *testing.T) {
+	jobs := NewJobs(context.Background())
+	release := make(chan struct{})
+	ran := make(chan struct{}, 2)
+	jobs.Register("slow", func(ctx context.Context) {
+		ran <- struct{}{}
+		<-release
+	})
+
+	if _, found, _ := jobs.Start("missing"); found {
+		t.Error("Start(missing) found a job")
+	}
+	status, found, started := jobs.Start("slow")
+	if !found || !started || !status.Running || status.StartedAt == nil {
+		t.Fatalf("Start(slow) = %+v, %v, %v; want a started run", status, found, started)
+	}
+	<-ran
+	if _, _, started := jobs.Start("slow"); started {
+		t.Error("Start started a second run while one was running")
+	}
+
+	close(release)
+	deadline := time.Now().Add(time.Second)
+	for jobs.Status()[0].Running {
+		if time.Now().After(deadline) {
+			t.Fatal("job still running after it returned")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if got := jobs.Status()[0]; got.FinishedAt == nil {
+		t.Errorf("Status = %+v, want a finish time", got)
+	}
+	if _, _, started := jobs.Start("slow"); !started {
+		t.Error("Start didn't start a run after the last finished")
+	}
+	<-ran
+}
+
+func TestCreateAuthorBanValidation(t *testing.T) {
+	h := newTestHandler(newFakeStore(), HandlerConfig{})
+	for _, body := range []string{
+		`{"ip_hash": "not-a-hash"}`,
+		`{"ip_hash": "` + strings.Repeat("a", 63) + `"}`,
+		`{"ip_hash": "` + strings.Repeat("a", 64) + `", "reason": "` + strings.Repeat("x", 1001) + `"}`,
+	} {
+		w := httptest.NewRecorder()
+		h.CreateAuthorBan(w, httptest.NewRequest("POST", "/admin/bans", strings.NewReader(body)))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", body, w.Code)
+		}
+	}
+}
+
+func TestAuthorBans(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	hash := hashIP(fmt.Sprintf("ban-test-%d", time.Now().UnixNano()))
+
+	ban, err := db.CreateAuthorBan(ctx, hash, "test", "spam")
+	if err != nil || ban == nil || ban.Reason != "spam" {
+		t.Fatalf("CreateAuthorBan = %+v, %v", ban, err)
+	}
+	if again, err := db.CreateAuthorBan(ctx, hash, "test", ""); err != nil || again != nil {
+		t.Errorf("second CreateAuthorBan = %+v, %v; want nil", again, err)
+	}
+	if banned, err := db.IsAuthorBanned(ctx, hash); err != nil || !banned {
+		t.Errorf("IsAuthorBanned = %v, %v; want true", banned, err)
+	}
+
+	found, err := db.DeleteAuthorBan(ctx, hash)
+	if err != nil || !found {
+		t.Fatalf("DeleteAuthorBan = %v, %v", found, err)
+	}
+	if found, err := db.DeleteAuthorBan(ctx, hash); err != nil || found {
+		t.Errorf("second DeleteAuthorBan = %v, %v; want false", found, err)
+	}
+}
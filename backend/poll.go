@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+const (
+	defaultPollWait = 25 * time.Second
+	maxPollWait     = 30 * time.Second
+	// pollRecheckInterval is how often a waiting poll checks the database
+	// anyway, for posts the broker doesn't hear about: those approved by
+	// moderators, and those from other server processes when there is no
+	// relay or a notification was lost
+	pollRecheckInterval = 5 * time.Second
+)
+
+// PollPosts handles GET /api/posts/poll?event=&since_id=&wait=25s, a
+// long-poll for networks that cut streaming connections. It responds as
+// soon as there are posts newer than since_id, oldest first, or with an
+// empty list once wait has passed.
+func (h *Handler) PollPosts(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	wait := defaultPollWait
+	if v := query.Get("wait"); v != "" {
+		var err error
+		wait, err = time.ParseDuration(v)
+		if err != nil || wait < 0 || wait > maxPollWait {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("wait must be a duration of at most %s, such as 25s", maxPollWait))
+			return
+		}
+	}
+
+	projection, err := parsePostFields(query.Get("fields"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	sinceID := query.Get("since_id")
+	if !publicIDPattern.MatchString(sinceID) {
+		respondWithError(w, http.StatusBadRequest, "since_id must be the public ID of the newest post the client has")
+		return
+	}
+	afterID, err := h.db.GetPostIDByPublicID(r.Context(), sinceID)
+	if err != nil {
+		log.Printf("Error looking up post: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve posts")
+		return
+	}
+	if afterID == 0 {
+		respondWithError(w, http.StatusNotFound, "Post not found")
+		return
+	}
+
+	event, err := h.canonicalEventName(r.Context(), query.Get("event"))
+	if err != nil {
+		log.Printf("Error resolving event name: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve posts")
+		return
+	}
+	filter := PostFilter{Event: event, IncludeSensitive: query.Get("include_sensitive") == "true"}
+	if !h.applyPreferences(w, r, &filter) {
+		return
+	}
+	limit, _ := parsePagination(r)
+
+	// The server's write timeout is shorter than a poll can wait. Writers
+	// that can't extend it, as in tests, have no timeout to extend
+	http.NewResponseController(w).SetWriteDeadline(time.Now().Add(wait + 10*time.Second))
+
+	timeout := time.NewTimer(wait)
+	defer timeout.Stop()
+	recheck := time.NewTicker(pollRecheckInterval)
+	defer recheck.Stop()
+
+	for {
+		// Subscribe before querying, so a post published in between isn't
+		// missed
+		woken, unsubscribe := h.cfg.Broker.Subscribe(event)
+		posts, err := h.db.GetPostsAfter(r.Context(), filter, afterID, limit)
+		if err != nil {
+			unsubscribe()
+			log.Printf("Error polling posts: %v", err)
+			respondWithError(w, http.StatusInternalServerError, "Failed to retrieve posts")
+			return
+		}
+		if len(posts) > 0 {
+			unsubscribe()
+			if err := h.presentPosts(r.Context(), posts, projection); err != nil {
+				log.Printf("Error presenting posts: %v", err)
+				respondWithError(w, http.StatusInternalServerError, "Failed to retrieve posts")
+				return
+			}
+			respondWithJSON(w, http.StatusOK, projection.Apply(posts))
+			return
+		}
+
+		select {
+		case <-woken:
+		case <-recheck.C:
+		case <-timeout.C:
+			unsubscribe()
+			respondWithJSON(w, http.StatusOK, projection.Apply([]Post{}))
+			return
+		case <-r.Context().Done():
+			unsubscribe()
+			return
+		}
+		unsubscribe()
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPostBroker(t *testing.T) {
+	broker := NewPostBroker(nil)
+	glasto, unsubscribeGlasto := broker.Subscribe("Glastonbury")
+	defer unsubscribeGlasto()
+	all, unsubscribeAll := broker.Subscribe("")
+	defer unsubscribeAll()
+	left, unsubscribeLeft := broker.Subscribe("Glastonbury")
+	unsubscribeLeft()
+
+	broker.Publish(Post{EventName: "Reading"})
+	select {
+	case <-glasto:
+		t.Error("woken by a post on another event")
+	default:
+	}
+	select {
+	case <-all:
+	default:
+		t.Error("subscriber to every event not woken")
+	}
+
+	broker.Publish(Post{EventName: "Glastonbury"})
+	select {
+	case <-glasto:
+	default:
+		t.Error("subscriber to the event not woken")
+	}
+	select {
+	case <-left:
+		t.Error("unsubscribed channel woken")
+	default:
+	}
+
+	// A nil broker never wakes anyone
+	var off *PostBroker
+	if ch, unsubscribe := off.Subscribe(""); ch != nil {
+		t.Error("nil broker returned a channel")
+	} else {
+		unsubscribe()
+	}
+	off.Publish(Post{})
+}
+
+func TestPollPosts(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    string
+		status   int
+		errorMsg string
+		want     []int
+	}{
+		{name: "newer posts", query: "since_id=aaaaaaaaaa", status: 200, want: []int{2}},
+		{name: "none within wait", query: "since_id=bbbbbbbbbb&wait=0s", status: 200, want: []int{}},
+		{name: "missing since_id", query: "", status: 400, errorMsg: "since_id must be the public ID of the newest post the client has"},
+		{name: "unknown since_id", query: "since_id=zzzzzzzzzz", status: 404, errorMsg: "Post not found"},
+		{name: "wait too long", query: "since_id=aaaaaaaaaa&wait=5m", status: 400, errorMsg: "wait must be a duration of at most 30s, such as 25s"},
+		{name: "wait not a duration", query: "since_id=aaaaaaaaaa&wait=soon", status: 400, errorMsg: "wait must be a duration of at most 30s, such as 25s"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newFakeStore()
+			store.posts = []Post{
+				{ID: 1, PublicID: "aaaaaaaaaa", EventName: "Glastonbury"},
+				{ID: 2, PublicID: "bbbbbbbbbb", EventName: "Glastonbury"},
+			}
+			h := newTestHandler(store, HandlerConfig{Broker: NewPostBroker(nil)})
+
+			rec := httptest.NewRecorder()
+			h.PollPosts(rec, httptest.NewRequest(http.MethodGet, "/api/posts/poll?"+tt.query, nil))
+
+			if tt.errorMsg != "" {
+				assertError(t, rec, tt.status, tt.errorMsg)
+				return
+			}
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d (body %s)", rec.Code, tt.status, rec.Body)
+			}
+			var posts []Post
+			if err := json.Unmarshal(rec.Body.Bytes(), &posts); err != nil {
+				t.Fatal(err)
+			}
+			ids := []int{}
+			for _, post := range posts {
+				ids = append(ids, post.ID)
+			}
+			if len(ids) != len(tt.want) || len(ids) > 0 && ids[0] != tt.want[0] {
+				t.Errorf("posts = %v, want %v", ids, tt.want)
+			}
+		})
+	}
+}
+
+func TestPollPostsWakes(t *testing.T) {
+	store := newFakeStore()
+	store.posts = []Post{{ID: 1, PublicID: "aaaaaaaaaa", EventName: "Glastonbury"}}
+	store.polled = make(chan int, 10)
+	broker := NewPostBroker(nil)
+	h := newTestHandler(store, HandlerConfig{Broker: broker})
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		rec := httptest.NewRecorder()
+		h.PollPosts(rec, httptest.NewRequest(http.MethodGet, "/api/posts/poll?event=Glastonbury&since_id=aaaaaaaaaa&wait=10s", nil))
+		done <- rec
+	}()
+
+	// Publish once the poll has found nothing and is waiting
+	if n := <-store.polled; n != 0 {
+		t.Fatalf("first poll found %d posts, want none", n)
+	}
+	post := Post{ID: 2, PublicID: "bbbbbbbbbb", EventName: "Glastonbury"}
+	store.posts = append(store.posts, post)
+	start := time.Now()
+	broker.Publish(post)
+
+	select {
+	case rec := <-done:
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want 200 (body %s)", rec.Code, rec.Body)
+		}
+		var posts []Post
+		if err := json.Unmarshal(rec.Body.Bytes(), &posts); err != nil || len(posts) != 1 || posts[0].ID != 2 {
+			t.Errorf("posts = %+v, %v; want the new post", posts, err)
+		}
+		if elapsed := time.Since(start); elapsed > pollRecheckInterval/2 {
+			t.Errorf("poll answered after %s, want it woken by the broker", elapsed)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("poll not woken by the published post")
+	}
+}
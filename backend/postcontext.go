@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultSiblingWindowHours = 24
+	maxSiblingWindowHours     = 7 * 24
+	maxSiblingPosts           = 50
+)
+
+// PostFlag is a moderation queue item, seen from the post it flags.
+type PostFlag struct {
+	ID         int        `json:"id"`
+	Reason     string     `json:"reason"`
+	Details    string     `json:"details,omitempty"`
+	Source     string     `json:"source"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"`
+	ResolvedBy string     `json:"resolved_by,omitempty"`
+	Resolution string     `json:"resolution,omitempty"`
+}
+
+// PostHeuristics are the content heuristics' current verdict on a post,
+// which may differ from when it was screened if the heuristics changed.
+type PostHeuristics struct {
+	Flags                   []ContentFlag `json:"flags"`
+	SuggestedContentWarning string        `json:"suggested_content_warning,omitempty"`
+}
+
+// PostContext is everything a moderator needs to decide about a post.
+type PostContext struct {
+	Post Post `json:"post"`
+	// Revisions are earlier versions of the post, oldest first
+	Revisions []PostRevision `json:"revisions"`
+	// Flags are the post's moderation queue items, open and resolved
+	Flags []PostFlag `json:"flags"`
+	// History is the admin actions taken on the post, newest first
+	History    []AuditEntry   `json:"history"`
+	Heuristics PostHeuristics `json:"heuristics"`
+	// Siblings are other posts from the same IP hash within
+	// SiblingWindowHours either side of the post, newest first
+	Siblings           []Post `json:"siblings"`
+	SiblingWindowHours int    `json:"sibling_window_hours"`
+}
+
+// GetPostContext handles GET /admin/posts/{id}/context. Pass ?window_hours=
+// to widen or narrow the window searched for sibling posts.
+func (h *Handler) GetPostContext(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid post ID")
+		return
+	}
+
+	windowHours := defaultSiblingWindowHours
+	if v := r.URL.Query().Get("window_hours"); v != "" {
+		windowHours, err = strconv.Atoi(v)
+		if err != nil || windowHours < 1 || windowHours > maxSiblingWindowHours {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("window_hours must be between 1 and %d", maxSiblingWindowHours))
+			return
+		}
+	}
+
+	ctx := r.Context()
+	post, err := h.db.GetPostByID(ctx, id)
+	if err != nil {
+		log.Printf("Error getting post: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve post context")
+		return
+	}
+	if post == nil {
+		respondWithError(w, http.StatusNotFound, "Post not found")
+		return
+	}
+
+	fail := func(err error) {
+		log.Printf("Error getting post context: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve post context")
+	}
+
+	pc := PostContext{
+		Heuristics: PostHeuristics{
+			Flags:                   scoreContent(post.Content),
+			SuggestedContentWarning: suggestContentWarning(post.Content),
+		},
+		SiblingWindowHours: windowHours,
+	}
+	if pc.Revisions, err = h.db.GetPostRevisions(ctx, id); err != nil {
+		fail(err)
+		return
+	}
+	if pc.Flags, err = h.db.GetPostFlags(ctx, id); err != nil {
+		fail(err)
+		return
+	}
+	if pc.History, err = h.db.GetAuditLogForTarget(ctx, "post", strconv.Itoa(id)); err != nil {
+		fail(err)
+		return
+	}
+	if pc.Siblings, err = h.db.GetSiblingPosts(ctx, id, time.Duration(windowHours)*time.Hour, maxSiblingPosts); err != nil {
+		fail(err)
+		return
+	}
+
+	// Attachments for the post and its siblings in one query
+	posts := append([]Post{*post}, pc.Siblings...)
+	if err := h.loadAttachments(ctx, posts); err != nil {
+		fail(err)
+		return
+	}
+	pc.Post, pc.Siblings = posts[0], posts[1:]
+
+	if pc.Revisions == nil {
+		pc.Revisions = []PostRevision{}
+	}
+	if pc.Flags == nil {
+		pc.Flags = []PostFlag{}
+	}
+	if pc.History == nil {
+		pc.History = []AuditEntry{}
+	}
+	if pc.Heuristics.Flags == nil {
+		pc.Heuristics.Flags = []ContentFlag{}
+	}
+	if pc.Siblings == nil {
+		pc.Siblings = []Post{}
+	}
+
+	respondWithJSON(w, http.StatusOK, pc)
+}
+
+// GetPostFlags returns every moderation queue item for a post, oldest first.
+func (db *DB) GetPostFlags(ctx context.Context, postID int) ([]PostFlag, error) {
+	rows, err := db.conn.QueryContext(ctx, `
+		SELECT id, reason, COALESCE(details, ''), source, created_at,
+			resolved_at, COALESCE(resolved_by, ''), COALESCE(resolution, '')
+		FROM moderation_queue
+		WHERE post_id = $1
+		ORDER BY created_at, id
+	`, postID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query post flags: %w", err)
+	}
+	defer rows.Close()
+
+	var flags []PostFlag
+	for rows.Next() {
+		var f PostFlag
+		if err := rows.Scan(&f.ID, &f.Reason, &f.Details, &f.Source, &f.CreatedAt, &f.ResolvedAt, &f.ResolvedBy, &f.Resolution); err != nil {
+			return nil, fmt.Errorf("failed to scan post flag: %w", err)
+		}
+		flags = append(flags, f)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating post flags: %w", err)
+	}
+
+	return flags, nil
+}
+
+// GetAuditLogForTarget returns the audit entries for one target, newest
+// first.
+func (db *DB) GetAuditLogForTarget(ctx context.Context, targetType, targetValue string) ([]AuditEntry, error) {
+	rows, err := db.conn.QueryContext(ctx, `
+		SELECT id, actor, action, COALESCE(target_type, ''), COALESCE(target_value, ''), details, created_at
+		FROM admin_audit_log
+		WHERE target_type = $1 AND target_value = $2
+		ORDER BY created_at DESC, id DESC
+	`, targetType, targetValue)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query audit log: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []AuditEntry
+	for rows.Next() {
+		var entry AuditEntry
+		var details []byte
+		if err := rows.Scan(&entry.ID, &entry.Actor, &entry.Action, &entry.TargetType, &entry.TargetValue, &details, &entry.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan audit entry: %w", err)
+		}
+		entry.Details = details
+		entries = append(entries, entry)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating audit log: %w", err)
+	}
+
+	return entries, nil
+}
+
+// GetSiblingPosts returns other posts from the same IP hash as a post,
+// created within window either side of it, newest first.
+func (db *DB) GetSiblingPosts(ctx context.Context, postID int, window time.Duration, limit int) ([]Post, error) {
+	query := `
+		SELECT ` + postColumns + `
+		FROM posts, (SELECT ip_hash AS target_hash, created_at AS target_at FROM posts WHERE id = $1) target
+		WHERE id <> $1
+		AND ip_hash = target_hash
+		AND created_at BETWEEN target_at - make_interval(secs => $2) AND target_at + make_interval(secs => $2)
+		ORDER BY created_at DESC
+		LIMIT $3
+	`
+
+	rows, err := db.conn.QueryContext(ctx, query, postID, window.Seconds(), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query sibling posts: %w", err)
+	}
+	defer rows.Close()
+
+	var posts []Post
+	for rows.Next() {
+		post, err := scanPost(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan post: %w", err)
+		}
+		posts = append(posts, *post)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating sibling posts: %w", err)
+	}
+
+	return posts, nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// counterReconcileHour is the hour, UTC, the counters are checked each
+// night, when posting is quietest.
+const counterReconcileHour = 4
+
+// PostCounterDrift counts the posts whose kept counters disagreed with the
+// source tables and were corrected.
+type PostCounterDrift struct {
+	EditCount     int64 `json:"edit_count"`
+	ReplyCount    int64 `json:"reply_count"`
+	ReportCount   int64 `json:"report_count"`
+	ReactionCount int64 `json:"reaction_count"`
+}
+
+func (d PostCounterDrift) total() int64 {
+	return d.EditCount + d.ReplyCount + d.ReportCount + d.ReactionCount
+}
+
+// CounterJob reconciles the counters kept on posts with what they count:
+// edit counts with the post events, reply counts with the replies, report
+// counts with the moderation queue and reaction counts with the reactions. The counters are updated in the same
+// statement as what they count, so drift means a bug or a manual fix to
+// the data, and is logged.
+type CounterJob struct {
+	db *DB
+}
+
+func NewCounterJob(db *DB) *CounterJob {
+	return &CounterJob{db: db}
+}
+
+// Run reconciles the counters nightly at counterReconcileHour until ctx is
+// cancelled.
+func (j *CounterJob) Run(ctx context.Context) {
+	for {
+		timer := time.NewTimer(time.Until(nextCounterReconcile(time.Now())))
+		select {
+		case <-timer.C:
+			j.reconcile(ctx)
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// nextCounterReconcile is the first counterReconcileHour after now.
+func nextCounterReconcile(now time.Time) time.Time {
+	now = now.UTC()
+	next := time.Date(now.Year(), now.Month(), now.Day(), counterReconcileHour, 0, 0, 0, time.UTC)
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
+
+func (j *CounterJob) reconcile(ctx context.Context) {
+	drift, err := j.db.ReconcilePostCounters(ctx)
+	if err != nil {
+		log.Printf("Error reconciling post counters: %v", err)
+		return
+	}
+	if drift.total() > 0 {
+		log.Printf("Post counters had drifted and were corrected: %d edit counts, %d reply counts, %d report counts, %d reaction counts",
+			drift.EditCount, drift.ReplyCount, drift.ReportCount, drift.ReactionCount)
+	}
+}
+
+// RebuildPostCounters handles POST /admin/posts/rebuild-counters, which
+// reconciles the counters straight away rather than waiting for the night.
+func (h *Handler) RebuildPostCounters(w http.ResponseWriter, r *http.Request) {
+	drift, err := h.db.ReconcilePostCounters(r.Context())
+	if err != nil {
+		log.Printf("Error reconciling post counters: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to rebuild post counters")
+		return
+	}
+
+	h.audit(r, "post.rebuild_counters", "", "", drift)
+	respondWithJSON(w, http.StatusOK, drift)
+}
+
+// ReconcilePostCounters recomputes every post's counters from the source
+// tables, correcting those that are wrong, and reports how many were.
+func (db *DB) ReconcilePostCounters(ctx context.Context) (PostCounterDrift, error) {
+	var drift PostCounterDrift
+	err := db.conn.QueryRowContext(ctx, `
+		WITH actual AS (
+			SELECT p.id, p.edit_count, p.reply_count, p.report_count, p.reaction_count,
+				(SELECT COUNT(*) FROM post_events e WHERE e.post_id = p.id AND e.type = 'edited') AS edits,
+				(SELECT COUNT(*) FROM replies r WHERE r.post_id = p.id) AS replies,
+				(SELECT COUNT(*) FROM moderation_queue m WHERE m.post_id = p.id AND m.reason <> 'appeal') AS reports,
+				(SELECT COUNT(*) FROM reactions x WHERE x.post_id = p.id) AS reactions
+			FROM posts p
+		), corrected AS (
+			UPDATE posts p SET edit_count = a.edits, reply_count = a.replies, report_count = a.reports, reaction_count = a.reactions
+			FROM actual a
+			WHERE a.id = p.id
+			AND (a.edit_count, a.reply_count, a.report_count, a.reaction_count) IS DISTINCT FROM (a.edits, a.replies, a.reports, a.reactions)
+			RETURNING a.edit_count <> a.edits AS edits_drifted,
+				a.reply_count <> a.replies AS replies_drifted,
+				a.report_count <> a.reports AS reports_drifted,
+				a.reaction_count <> a.reactions AS reactions_drifted
+		)
+		SELECT COUNT(*) FILTER (WHERE edits_drifted),
+			COUNT(*) FILTER (WHERE replies_drifted),
+			COUNT(*) FILTER (WHERE reports_drifted),
+			COUNT(*) FILTER (WHERE reactions_drifted)
+		FROM corrected
+	`).Scan(&drift.EditCount, &drift.ReplyCount, &drift.ReportCount, &drift.ReactionCount)
+	if err != nil {
+		return drift, fmt.Errorf("failed to reconcile post counters: %w", err)
+	}
+	return drift, nil
+}
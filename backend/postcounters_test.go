@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestNextCounterReconcile(t *testing.T) {
+	tests := []struct {
+		now, want string
+	}{
+		{"2026-03-01T01:30:00Z", "2026-03-01T04:00:00Z"},
+		{"2026-03-01T04:00:00Z", "2026-03-02T04:00:00Z"},
+		{"2026-03-31T23:00:00Z", "2026-04-01T04:00:00Z"},
+		// Local times are taken as the instant they are
+		{"2026-03-01T05:30:00+02:00", "2026-03-01T04:00:00Z"},
+	}
+	for _, tt := range tests {
+		now, _ := time.Parse(time.RFC3339, tt.now)
+		want, _ := time.Parse(time.RFC3339, tt.want)
+		if got := nextCounterReconcile(now); !got.Equal(want) {
+			t.Errorf("nextCounterReconcile(%s) = %s, want %s", tt.now, got, tt.want)
+		}
+	}
+}
+
+// TestPostCounters checks that replies and reports are counted as they are
+// written, and that reconciling corrects counters that drifted.
+func TestPostCounters(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	event := fmt.Sprintf("Counters Test %d", time.Now().UnixNano())
+
+	post, err := db.CreatePost(ctx, CreatePostRequest{EventName: event, Content: "hello", Age: 25, Location: "x"}, "counters-test", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	actor := "https://remote.example/users/a"
+	for i := 0; i < 2; i++ {
+		if err := db.CreateFederatedReply(ctx, post.ID, "hi", actor, fmt.Sprintf("https://remote.example/notes/%d-%d", post.ID, i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.DeleteFederatedReply(ctx, fmt.Sprintf("https://remote.example/notes/%d-0", post.ID), actor); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.EnqueueModeration(ctx, post.ID, ContentFlag{Reason: "spam"}, "heuristic"); err != nil {
+		t.Fatal(err)
+	}
+
+	counts := func() (replies, reports int) {
+		got, err := db.GetPostByID(ctx, post.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return got.ReplyCount, got.ReportCount
+	}
+	if replies, reports := counts(); replies != 1 || reports != 1 {
+		t.Fatalf("counted %d replies and %d reports, want 1 and 1", replies, reports)
+	}
+
+	if _, err := db.conn.ExecContext(ctx, "UPDATE posts SET reply_count = 5, report_count = 0 WHERE id = $1", post.ID); err != nil {
+		t.Fatal(err)
+	}
+	drift, err := db.ReconcilePostCounters(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if drift.ReplyCount < 1 || drift.ReportCount < 1 {
+		t.Errorf("drift = %+v, want the reply and report counts corrected", drift)
+	}
+	if replies, reports := counts(); replies != 1 || reports != 1 {
+		t.Errorf("reconciled to %d replies and %d reports, want 1 and 1", replies, reports)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// PostEvent is one state change to a post: created, edited,
+// content_warning_changed, hidden, unhidden, moved, reported, appealed,
+// report_resolved or reacted. Events are recorded by database triggers
+// (migration 044), so every path that changes a post is covered, apart from
+// hearts, which ReactToPost records as it counts them.
+type PostEvent struct {
+	ID     int64  `json:"id"`
+	PostID int    `json:"post_id"`
+	Type   string `json:"type"`
+	// Actor is the moderator or system that made the change, when known
+	Actor     string          `json:"actor,omitempty"`
+	Data      json.RawMessage `json:"data,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// GetPostEvents handles GET /admin/posts/{id}/events, the post's timeline
+// oldest first.
+func (h *Handler) GetPostEvents(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid post ID")
+		return
+	}
+	limit, offset := parsePagination(r)
+
+	events, err := h.db.GetPostEvents(r.Context(), id, limit, offset)
+	if err != nil {
+		log.Printf("Error getting post events: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve post events")
+		return
+	}
+
+	if events == nil {
+		events = []PostEvent{}
+	}
+
+	respondWithJSON(w, http.StatusOK, events)
+}
+
+func (db *DB) GetPostEvents(ctx context.Context, postID, limit, offset int) ([]PostEvent, error) {
+	rows, err := db.conn.QueryContext(ctx, `
+		SELECT id, post_id, type, COALESCE(actor, ''), data, created_at
+		FROM post_events
+		WHERE post_id = $1
+		ORDER BY created_at, id
+		LIMIT $2 OFFSET $3
+	`, postID, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query post events: %w", err)
+	}
+	defer rows.Close()
+
+	var events []PostEvent
+	for rows.Next() {
+		var event PostEvent
+		var data []byte
+		if err := rows.Scan(&event.ID, &event.PostID, &event.Type, &event.Actor, &data, &event.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan post event: %w", err)
+		}
+		event.Data = data
+		events = append(events, event)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating post events: %w", err)
+	}
+
+	return events, nil
+}
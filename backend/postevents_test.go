@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// TestPostEvents checks that the triggers record each kind of change, and
+// that a drifted edit count is rebuilt from the events.
+func TestPostEvents(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	event := fmt.Sprintf("Post Events Test %d", time.Now().UnixNano())
+
+	post, err := db.CreatePost(ctx, CreatePostRequest{EventName: event, Content: "hello", Age: 25, Location: "x"}, "events-test", hashToken("token"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.EditPost(ctx, post.ID, hashToken("token"), "edited", nil, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.SetContentWarning(ctx, post.ID, "spoilers"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.EnqueueModeration(ctx, post.ID, ContentFlag{Reason: "spam"}, "heuristic"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.HidePost(ctx, post.ID, "moderator"); err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := db.GetPostEvents(ctx, post.ID, 50, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var types []string
+	for _, e := range events {
+		types = append(types, e.Type)
+	}
+	want := []string{"created", "edited", "content_warning_changed", "reported", "hidden"}
+	if fmt.Sprint(types) != fmt.Sprint(want) {
+		t.Errorf("events = %v, want %v", types, want)
+	}
+	if last := events[len(events)-1]; last.Actor != "moderator" {
+		t.Errorf("hidden by %q, want moderator", last.Actor)
+	}
+
+	if _, err := db.conn.ExecContext(ctx, "UPDATE posts SET edit_count = 7 WHERE id = $1", post.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.ReconcilePostCounters(ctx); err != nil {
+		t.Fatal(err)
+	}
+	var editCount int
+	if err := db.conn.QueryRowContext(ctx, "SELECT edit_count FROM posts WHERE id = $1", post.ID).Scan(&editCount); err != nil {
+		t.Fatal(err)
+	}
+	if editCount != 1 {
+		t.Errorf("edit_count = %d after rebuilding, want 1", editCount)
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	// Organizers name any IANA timezone, and the server's image may not
+	// ship the zone database
+	_ "time/tzdata"
+)
+
+// postingClosedCode is the error code of a post refused because its board
+// is outside its posting window.
+const postingClosedCode = "posting_closed"
+
+const (
+	maxPostingHours      = 7
+	maxCloseAfterEndDays = 365
+)
+
+// PostingWindow limits when an event's board takes posts: from OpensAt,
+// such as when the doors open, until ClosesAt or CloseAfterEndHours after
+// the event's ends_at, and within the daily Hours if any are set.
+type PostingWindow struct {
+	OpensAt  *time.Time `json:"opens_at,omitempty"`
+	ClosesAt *time.Time `json:"closes_at,omitempty"`
+	// CloseAfterEndHours closes the board this long after the event ends;
+	// it can't be combined with ClosesAt, and has no effect until the event
+	// has an ends_at
+	CloseAfterEndHours *int `json:"close_after_end_hours,omitempty"`
+	// Timezone is the IANA name Hours are in, UTC if not set
+	Timezone string         `json:"timezone,omitempty"`
+	Hours    []PostingHours `json:"hours,omitempty"`
+}
+
+// PostingHours are daily local times, "HH:MM", that a board takes posts
+// between. An End at or before Start runs past midnight.
+type PostingHours struct {
+	Start string `json:"start"`
+	End   string `json:"end"`
+}
+
+// PostingClosedError is returned for a post to a board outside its posting
+// window. NextOpen is when it opens again, or nil if it has closed for good.
+type PostingClosedError struct {
+	NextOpen *time.Time
+}
+
+func (e *PostingClosedError) Error() string {
+	if e.NextOpen == nil {
+		return "This board has closed to new posts."
+	}
+	return fmt.Sprintf("This board isn't taking posts right now. It opens at %s.", e.NextOpen.Format("Mon 2 Jan 15:04 MST"))
+}
+
+// respondPostingClosed writes the 403 for a *PostingClosedError, with its
+// code and, if it will open again, when.
+func respondPostingClosed(w http.ResponseWriter, err *PostingClosedError) {
+	body := map[string]string{
+		"error": err.Error(),
+		"code":  postingClosedCode,
+	}
+	if err.NextOpen != nil {
+		body["next_open_at"] = err.NextOpen.Format(time.RFC3339)
+	}
+	respondWithJSON(w, http.StatusForbidden, body)
+}
+
+// normalize validates a posting window submitted by an organizer.
+func (pw *PostingWindow) normalize() error {
+	if pw.Timezone == "" {
+		pw.Timezone = "UTC"
+	}
+	if _, err := time.LoadLocation(pw.Timezone); err != nil {
+		return &ValidationError{Message: "timezone must be an IANA timezone, such as Europe/London"}
+	}
+	if pw.OpensAt != nil && pw.ClosesAt != nil && !pw.ClosesAt.After(*pw.OpensAt) {
+		return &ValidationError{Message: "closes_at must be after opens_at"}
+	}
+	if pw.CloseAfterEndHours != nil {
+		if pw.ClosesAt != nil {
+			return &ValidationError{Message: "set closes_at or close_after_end_hours, not both"}
+		}
+		if *pw.CloseAfterEndHours < 0 || *pw.CloseAfterEndHours > maxCloseAfterEndDays*24 {
+			return &ValidationError{Message: fmt.Sprintf("close_after_end_hours must be between 0 and %d", maxCloseAfterEndDays*24)}
+		}
+	}
+	if len(pw.Hours) > maxPostingHours {
+		return &ValidationError{Message: fmt.Sprintf("at most %d posting hours are allowed", maxPostingHours)}
+	}
+	for _, h := range pw.Hours {
+		start, err1 := parseClock(h.Start)
+		end, err2 := parseClock(h.End)
+		if err1 != nil || err2 != nil {
+			return &ValidationError{Message: "hours must have a start and end such as 09:00"}
+		}
+		if start == end {
+			return &ValidationError{Message: "hours must not start and end at the same time"}
+		}
+	}
+	return nil
+}
+
+// parseClock parses "HH:MM" into minutes after midnight.
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// closesAt is when the board stops taking posts for good, if ever.
+func (pw *PostingWindow) closesAt(eventEnds *time.Time) *time.Time {
+	if pw.ClosesAt != nil {
+		return pw.ClosesAt
+	}
+	if pw.CloseAfterEndHours != nil && eventEnds != nil {
+		closes := eventEnds.Add(time.Duration(*pw.CloseAfterEndHours) * time.Hour)
+		return &closes
+	}
+	return nil
+}
+
+// nextOpen returns the first time at or after now the board takes posts,
+// in the window's timezone, or false if it never will again.
+func (pw *PostingWindow) nextOpen(now time.Time, eventEnds *time.Time) (time.Time, bool) {
+	loc, err := time.LoadLocation(pw.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	t := now
+	if pw.OpensAt != nil && t.Before(*pw.OpensAt) {
+		t = *pw.OpensAt
+	}
+	t = pw.nextInHours(t.In(loc))
+	if closes := pw.closesAt(eventEnds); closes != nil && !t.Before(*closes) {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// nextInHours returns t if it is within the daily hours, or when they next
+// start. Starts and ends are built from local dates, so they stay at the
+// same wall clock time across daylight saving changes.
+func (pw *PostingWindow) nextInHours(t time.Time) time.Time {
+	if len(pw.Hours) == 0 {
+		return t
+	}
+	var next time.Time
+	y, m, d := t.Date()
+	// Yesterday's hours may run past midnight into today
+	for day := -1; day <= 1; day++ {
+		for _, h := range pw.Hours {
+			startMin, _ := parseClock(h.Start)
+			endMin, _ := parseClock(h.End)
+			start := time.Date(y, m, d+day, startMin/60, startMin%60, 0, 0, t.Location())
+			endDay := d + day
+			if endMin <= startMin {
+				endDay++
+			}
+			end := time.Date(y, m, endDay, endMin/60, endMin%60, 0, 0, t.Location())
+			if !t.Before(start) && t.Before(end) {
+				return t
+			}
+			if start.After(t) && (next.IsZero() || start.Before(next)) {
+				next = start
+			}
+		}
+	}
+	return next
+}
+
+// checkPostingWindow refuses a post to a board outside its posting window.
+func checkPostingWindow(settings EventSettings, now time.Time) error {
+	if settings.PostingWindow == nil {
+		return nil
+	}
+	next, ok := settings.PostingWindow.nextOpen(now, settings.eventEndsAt)
+	if !ok {
+		return &PostingClosedError{}
+	}
+	if next.After(now) {
+		return &PostingClosedError{NextOpen: &next}
+	}
+	return nil
+}
+
+// SetPostingWindow handles PUT /admin/events/{event}/posting-window. A null
+// body clears the window, so the board always takes posts.
+func (h *Handler) SetPostingWindow(w http.ResponseWriter, r *http.Request) {
+	var window *PostingWindow
+	if err := json.NewDecoder(r.Body).Decode(&window); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if window != nil {
+		if err := window.normalize(); err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	found, err := h.db.SetPostingWindow(r.Context(), r.PathValue("event"), window)
+	if err != nil {
+		log.Printf("Error setting posting window: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to update event")
+		return
+	}
+	if !found {
+		respondWithError(w, http.StatusNotFound, "Event not found")
+		return
+	}
+
+	h.audit(r, "event.set_posting_window", "event", r.PathValue("event"), window)
+
+	h.GetEvent(w, r)
+}
+
+func (pw *PostingWindow) Value() (driver.Value, error) {
+	if pw == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(pw)
+	return string(b), err
+}
+
+func (pw *PostingWindow) Scan(src interface{}) error {
+	return scanJSON(src, pw)
+}
+
+// SetPostingWindow replaces an event's posting window, reporting whether
+// the event exists. A nil window clears it.
+func (db *DB) SetPostingWindow(ctx context.Context, name string, window *PostingWindow) (bool, error) {
+	result, err := db.conn.ExecContext(ctx,
+		"UPDATE events SET posting_window = $2::jsonb WHERE name = $1",
+		name, window,
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to set posting window: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to set posting window: %w", err)
+	}
+
+	return affected > 0, nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPostingWindowNextOpen(t *testing.T) {
+	london, err := time.LoadLocation("Europe/London")
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := func(s string) time.Time {
+		t.Helper()
+		v, err := time.ParseInLocation("2006-01-02 15:04", s, london)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	ptr := func(v time.Time) *time.Time { return &v }
+	hours := func(n int) *int { return &n }
+
+	ends := at("2026-06-28 23:00")
+	for _, tt := range []struct {
+		name   string
+		window PostingWindow
+		now    string
+		want   string // "" if closed for good
+	}{
+		{"no limits", PostingWindow{}, "2026-06-24 03:00", "2026-06-24 03:00"},
+		{"before doors", PostingWindow{OpensAt: ptr(at("2026-06-24 16:00"))}, "2026-06-24 10:00", "2026-06-24 16:00"},
+		{"after close", PostingWindow{ClosesAt: ptr(at("2026-06-30 00:00"))}, "2026-06-30 00:00", ""},
+		{"within close after end", PostingWindow{CloseAfterEndHours: hours(48)}, "2026-06-30 22:59", "2026-06-30 22:59"},
+		{"past close after end", PostingWindow{CloseAfterEndHours: hours(48)}, "2026-06-30 23:00", ""},
+		{"within hours", PostingWindow{Hours: []PostingHours{{"09:00", "17:00"}}}, "2026-06-24 09:00", "2026-06-24 09:00"},
+		{"after hours", PostingWindow{Hours: []PostingHours{{"09:00", "17:00"}}}, "2026-06-24 17:00", "2026-06-25 09:00"},
+		{"before hours", PostingWindow{Hours: []PostingHours{{"09:00", "12:00"}, {"14:00", "17:00"}}}, "2026-06-24 12:30", "2026-06-24 14:00"},
+		{"overnight", PostingWindow{Hours: []PostingHours{{"22:00", "02:00"}}}, "2026-06-25 01:00", "2026-06-25 01:00"},
+		{"doors outside hours", PostingWindow{OpensAt: ptr(at("2026-06-24 06:00")), Hours: []PostingHours{{"09:00", "17:00"}}}, "2026-06-23 12:00", "2026-06-24 09:00"},
+		{"closes before hours", PostingWindow{ClosesAt: ptr(at("2026-06-25 08:00")), Hours: []PostingHours{{"09:00", "17:00"}}}, "2026-06-24 18:00", ""},
+		// The clocks go forward on 29 March; the board still opens at 09:00
+		{"across daylight saving", PostingWindow{Hours: []PostingHours{{"09:00", "17:00"}}}, "2026-03-28 18:00", "2026-03-29 09:00"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.window.Timezone = "Europe/London"
+			next, ok := tt.window.nextOpen(at(tt.now), &ends)
+			if tt.want == "" {
+				if ok {
+					t.Errorf("next open = %v, want closed for good", next)
+				}
+				return
+			}
+			if !ok || !next.Equal(at(tt.want)) {
+				t.Errorf("next open = %v, %v; want %v", next, ok, at(tt.want))
+			}
+		})
+	}
+}
+
+func TestCreatePostPostingClosed(t *testing.T) {
+	store := newFakeStore()
+	opens := time.Now().Add(time.Hour).Truncate(time.Second)
+	store.settings["Glastonbury"] = EventSettings{AllAges: true, PostingWindow: &PostingWindow{OpensAt: &opens, Timezone: "UTC"}}
+	store.settings["Reading"] = EventSettings{AllAges: true, PostingWindow: &PostingWindow{ClosesAt: &opens, Timezone: "UTC"}}
+	h := newTestHandler(store, HandlerConfig{})
+
+	post := func(event string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.CreatePost(rec, httptest.NewRequest(http.MethodPost, "/api/posts", strings.NewReader(`{"event_name": "`+event+`", "content": "hi"}`)))
+		return rec
+	}
+
+	rec := post("Glastonbury")
+	var body map[string]string
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusForbidden || body["code"] != postingClosedCode || body["next_open_at"] != opens.Format(time.RFC3339) {
+		t.Errorf("status = %d, body %v; want 403 posting_closed opening at %s", rec.Code, body, opens.Format(time.RFC3339))
+	}
+
+	if rec := post("Reading"); rec.Code != http.StatusCreated {
+		t.Errorf("status = %d before the board closes, want 201 (body %s)", rec.Code, rec.Body)
+	}
+}
+
+func TestSetPostingWindowValidation(t *testing.T) {
+	h := newTestHandler(newFakeStore(), HandlerConfig{})
+	for _, tt := range []struct {
+		body, want string
+	}{
+		{`{`, "Invalid request body"},
+		{`{"timezone": "Mars/Olympus"}`, "timezone must be an IANA timezone, such as Europe/London"},
+		{`{"opens_at": "2026-06-24T16:00:00Z", "closes_at": "2026-06-24T16:00:00Z"}`, "closes_at must be after opens_at"},
+		{`{"closes_at": "2026-06-24T16:00:00Z", "close_after_end_hours": 48}`, "set closes_at or close_after_end_hours, not both"},
+		{`{"close_after_end_hours": -1}`, "close_after_end_hours must be between 0 and 8760"},
+		{`{"hours": [{"start": "9am", "end": "17:00"}]}`, "hours must have a start and end such as 09:00"},
+		{`{"hours": [{"start": "09:00", "end": "09:00"}]}`, "hours must not start and end at the same time"},
+	} {
+		rec := httptest.NewRecorder()
+		h.SetPostingWindow(rec, httptest.NewRequest(http.MethodPut, "/admin/events/Glastonbury/posting-window", strings.NewReader(tt.body)))
+		assertError(t, rec, 400, tt.want)
+	}
+}
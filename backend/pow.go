@@ -0,0 +1,216 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math/bits"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	powChallengeTTL = 5 * time.Minute
+	// powMaxNonceLength bounds the work a server does to check a solution
+	powMaxNonceLength = 64
+	powLoadWindow     = time.Minute
+
+	powCodeRequired = "pow_required"
+	powCodeInvalid  = "pow_invalid"
+)
+
+// ProofOfWork is a privacy-friendly alternative to a CAPTCHA: before
+// posting, a client fetches a challenge and searches for a nonce such that
+// SHA-256(challenge + nonce) starts with the challenge's number of zero
+// bits. That costs a browser a moment and a bot posting in bulk a lot.
+//
+// Challenges are "RANDOM.EXPIRES.DIFFICULTY.SIG", where SIG is the hex
+// HMAC-SHA256 of the other fields and the client's IP hash, so they are
+// checked without storing them. Solved challenges are remembered until they
+// expire so each is used once, per server process. Difficulty rises by a
+// bit each time the posts per minute double past the load threshold.
+type ProofOfWork struct {
+	secret        []byte
+	difficulty    int
+	maxDifficulty int
+	loadThreshold int
+
+	mu          sync.Mutex
+	windowStart time.Time
+	solved      int
+	lastSolved  int
+	spent       map[string]time.Time
+	lastPrune   time.Time
+}
+
+// PowChallenge is the response to GET /api/pow/challenge.
+type PowChallenge struct {
+	// Required is false when proof of work is turned off, and posts don't
+	// need it
+	Required   bool       `json:"required"`
+	Challenge  string     `json:"challenge,omitempty"`
+	Difficulty int        `json:"difficulty,omitempty"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+}
+
+// NewProofOfWork returns nil, turning proof of work off, when difficulty is
+// 0. loadThreshold is the posts per minute at which difficulty starts to
+// rise; 0 keeps it fixed.
+func NewProofOfWork(secret []byte, difficulty, maxDifficulty, loadThreshold int) (*ProofOfWork, error) {
+	if difficulty == 0 {
+		return nil, nil
+	}
+	if difficulty < 0 || maxDifficulty < difficulty || maxDifficulty > 32 {
+		return nil, fmt.Errorf("difficulty must be between 1 and the max difficulty, which is at most 32")
+	}
+	if loadThreshold < 0 {
+		return nil, fmt.Errorf("load threshold must not be negative")
+	}
+	return &ProofOfWork{
+		secret:        secret,
+		difficulty:    difficulty,
+		maxDifficulty: maxDifficulty,
+		loadThreshold: loadThreshold,
+		spent:         make(map[string]time.Time),
+	}, nil
+}
+
+func (p *ProofOfWork) signature(random string, expires int64, difficulty int, ipHash string) string {
+	mac := hmac.New(sha256.New, p.secret)
+	fmt.Fprintf(mac, "%s\n%d\n%d\n%s", random, expires, difficulty, ipHash)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// currentDifficulty is the difficulty for new challenges, given the posts
+// solved in this and the last minute. The caller holds p.mu.
+func (p *ProofOfWork) currentDifficulty(now time.Time) int {
+	p.rollWindow(now)
+	load := max(p.solved, p.lastSolved)
+	if p.loadThreshold == 0 || load < p.loadThreshold {
+		return p.difficulty
+	}
+	return min(p.difficulty+bits.Len(uint(load/p.loadThreshold)), p.maxDifficulty)
+}
+
+func (p *ProofOfWork) rollWindow(now time.Time) {
+	if now.Sub(p.windowStart) < powLoadWindow {
+		return
+	}
+	p.lastSolved = p.solved
+	if now.Sub(p.windowStart) >= 2*powLoadWindow {
+		p.lastSolved = 0
+	}
+	p.windowStart = now
+	p.solved = 0
+}
+
+// Challenge issues a challenge for the client with ipHash.
+func (p *ProofOfWork) Challenge(ipHash string, now time.Time) PowChallenge {
+	p.mu.Lock()
+	difficulty := p.currentDifficulty(now)
+	p.mu.Unlock()
+
+	random := randomToken(16)
+	expiresAt := now.Add(powChallengeTTL).Truncate(time.Second)
+	expires := expiresAt.Unix()
+	return PowChallenge{
+		Required:   true,
+		Challenge:  fmt.Sprintf("%s.%d.%d.%s", random, expires, difficulty, p.signature(random, expires, difficulty, ipHash)),
+		Difficulty: difficulty,
+		ExpiresAt:  &expiresAt,
+	}
+}
+
+// Verify checks a solved challenge from the client with ipHash, and spends
+// it.
+func (p *ProofOfWork) Verify(challenge, nonce, ipHash string, now time.Time) bool {
+	parts := strings.Split(challenge, ".")
+	if len(parts) != 4 || nonce == "" || len(nonce) > powMaxNonceLength {
+		return false
+	}
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || now.Unix() >= expires {
+		return false
+	}
+	difficulty, err := strconv.Atoi(parts[2])
+	if err != nil {
+		return false
+	}
+	if !hmac.Equal([]byte(parts[3]), []byte(p.signature(parts[0], expires, difficulty, ipHash))) {
+		return false
+	}
+	sum := sha256.Sum256([]byte(challenge + nonce))
+	if leadingZeroBits(sum[:]) < difficulty {
+		return false
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.spent[challenge]; ok {
+		return false
+	}
+	if now.Sub(p.lastPrune) > powChallengeTTL {
+		for c, expiresAt := range p.spent {
+			if !now.Before(expiresAt) {
+				delete(p.spent, c)
+			}
+		}
+		p.lastPrune = now
+	}
+	p.spent[challenge] = time.Unix(expires, 0)
+	p.rollWindow(now)
+	p.solved++
+	return true
+}
+
+func leadingZeroBits(b []byte) int {
+	n := 0
+	for _, c := range b {
+		if c != 0 {
+			return n + bits.LeadingZeros8(c)
+		}
+		n += 8
+	}
+	return n
+}
+
+// GetPowChallenge handles GET /api/pow/challenge. The challenge is tied to
+// the client's IP address, so it must be solved and posted from the same
+// one.
+func (h *Handler) GetPowChallenge(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	if h.cfg.ProofOfWork == nil {
+		respondWithJSON(w, http.StatusOK, PowChallenge{})
+		return
+	}
+	respondWithJSON(w, http.StatusOK, h.cfg.ProofOfWork.Challenge(hashIP(getIP(r)), time.Now()))
+}
+
+// checkProofOfWork reports whether a post came with a solved challenge in
+// the X-PoW-Challenge and X-PoW-Nonce headers, when proof of work is on,
+// writing a 403 with a code if it didn't.
+func (h *Handler) checkProofOfWork(w http.ResponseWriter, r *http.Request) bool {
+	if h.cfg.ProofOfWork == nil {
+		return true
+	}
+	challenge := r.Header.Get("X-PoW-Challenge")
+	if challenge == "" {
+		respondWithJSON(w, http.StatusForbidden, map[string]string{
+			"error": "Solve a challenge from /api/pow/challenge before posting",
+			"code":  powCodeRequired,
+		})
+		return false
+	}
+	if !h.cfg.ProofOfWork.Verify(challenge, r.Header.Get("X-PoW-Nonce"), hashIP(getIP(r)), time.Now()) {
+		respondWithJSON(w, http.StatusForbidden, map[string]string{
+			"error": "The challenge is unsolved, expired or already used; fetch a new one",
+			"code":  powCodeInvalid,
+		})
+		return false
+	}
+	return true
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// solvePow finds a nonce for challenge, as a client would.
+func solvePow(challenge PowChallenge) string {
+	for i := 0; ; i++ {
+		nonce := strconv.Itoa(i)
+		sum := sha256.Sum256([]byte(challenge.Challenge + nonce))
+		if leadingZeroBits(sum[:]) >= challenge.Difficulty {
+			return nonce
+		}
+	}
+}
+
+func TestProofOfWorkVerify(t *testing.T) {
+	pow, err := NewProofOfWork([]byte("secret"), 8, 8, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	c := pow.Challenge("ip", now)
+	nonce := solvePow(c)
+
+	if pow.Verify(c.Challenge, nonce, "other ip", now) {
+		t.Error("challenge verified from another IP")
+	}
+	if pow.Verify(strings.Replace(c.Challenge, ".8.", ".1.", 1), nonce, "ip", now) {
+		t.Error("challenge verified with its difficulty lowered")
+	}
+	if pow.Verify(c.Challenge, nonce, "ip", now.Add(powChallengeTTL)) {
+		t.Error("expired challenge verified")
+	}
+	if !pow.Verify(c.Challenge, nonce, "ip", now) {
+		t.Fatal("solved challenge didn't verify")
+	}
+	if pow.Verify(c.Challenge, nonce, "ip", now) {
+		t.Error("challenge verified twice")
+	}
+}
+
+func TestProofOfWorkAdaptive(t *testing.T) {
+	pow, err := NewProofOfWork([]byte("secret"), 4, 6, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	solve := func(n int) {
+		for range n {
+			c := pow.Challenge("ip", now)
+			if !pow.Verify(c.Challenge, solvePow(c), "ip", now) {
+				t.Fatal("solved challenge didn't verify")
+			}
+		}
+	}
+
+	for _, tt := range []struct {
+		solve, want int
+	}{
+		{1, 4}, // under the threshold
+		{1, 5}, // 2 posts a minute
+		{2, 6}, // 4
+		{8, 6}, // 12, capped
+	} {
+		solve(tt.solve)
+		if got := pow.Challenge("ip", now).Difficulty; got != tt.want {
+			t.Errorf("difficulty = %d, want %d", got, tt.want)
+		}
+	}
+
+	// The last minute's load still counts, and is forgotten after that
+	if got := pow.Challenge("ip", now.Add(powLoadWindow)).Difficulty; got != 6 {
+		t.Errorf("difficulty = %d a minute later, want 6", got)
+	}
+	if got := pow.Challenge("ip", now.Add(3*powLoadWindow)).Difficulty; got != 4 {
+		t.Errorf("difficulty = %d once quiet, want 4", got)
+	}
+}
+
+func TestNewProofOfWorkRejects(t *testing.T) {
+	for _, tt := range []struct{ difficulty, max, threshold int }{
+		{-1, 8, 0},
+		{10, 8, 0},
+		{8, 33, 0},
+		{8, 8, -1},
+	} {
+		if _, err := NewProofOfWork([]byte("secret"), tt.difficulty, tt.max, tt.threshold); err == nil {
+			t.Errorf("NewProofOfWork(%d, %d, %d) accepted", tt.difficulty, tt.max, tt.threshold)
+		}
+	}
+}
+
+func TestCreatePostProofOfWork(t *testing.T) {
+	pow, err := NewProofOfWork([]byte("secret"), 8, 8, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := newTestHandler(newFakeStore(), HandlerConfig{ProofOfWork: pow})
+
+	rec := httptest.NewRecorder()
+	h.GetPowChallenge(rec, httptest.NewRequest(http.MethodGet, "/api/pow/challenge", nil))
+	var challenge PowChallenge
+	if err := json.NewDecoder(rec.Body).Decode(&challenge); err != nil {
+		t.Fatal(err)
+	}
+	if !challenge.Required || challenge.Difficulty != 8 {
+		t.Fatalf("challenge = %+v, want a required one of difficulty 8", challenge)
+	}
+
+	post := func(challenge, nonce string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/posts", strings.NewReader(`{"event_name": "Glastonbury", "content": "hi", "age": 25, "location": "x"}`))
+		if challenge != "" {
+			req.Header.Set("X-PoW-Challenge", challenge)
+			req.Header.Set("X-PoW-Nonce", nonce)
+		}
+		rec := httptest.NewRecorder()
+		h.CreatePost(rec, req)
+		return rec
+	}
+
+	assertCode := func(rec *httptest.ResponseRecorder, code string) {
+		t.Helper()
+		var body map[string]string
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+		if rec.Code != http.StatusForbidden || body["code"] != code {
+			t.Errorf("status = %d, body %v; want 403 with code %s", rec.Code, body, code)
+		}
+	}
+
+	assertCode(post("", ""), powCodeRequired)
+	nonce := solvePow(challenge)
+	wrong := nonce + "x"
+	for sum := sha256.Sum256([]byte(challenge.Challenge + wrong)); leadingZeroBits(sum[:]) >= 8; sum = sha256.Sum256([]byte(challenge.Challenge + wrong)) {
+		wrong += "x"
+	}
+	assertCode(post(challenge.Challenge, wrong), powCodeInvalid)
+	if rec := post(challenge.Challenge, nonce); rec.Code != 201 {
+		t.Fatalf("status = %d with a solved challenge, want 201 (body %s)", rec.Code, rec.Body)
+	}
+	assertCode(post(challenge.Challenge, nonce), powCodeInvalid)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// errVersionMismatch is returned by conditional writes when the row has
+// changed since the client read it.
+var errVersionMismatch = errors.New("version does not match")
+
+// versionETag is the strong ETag for a row's version.
+func versionETag(version int) string {
+	return fmt.Sprintf(`"v%d"`, version)
+}
+
+// ifMatchVersions returns the versions named by the request's If-Match
+// header, for a write that should only go ahead if the row is still at one
+// of them. It returns nil when there is no condition: no header, or "*",
+// which any existing row matches. Weak and unrecognised tags can't match,
+// so a header with only those yields an empty, unmatchable list.
+func ifMatchVersions(r *http.Request) []int {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	if header == "" || header == "*" {
+		return nil
+	}
+
+	versions := []int{}
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if !strings.HasPrefix(tag, `"v`) || !strings.HasSuffix(tag, `"`) {
+			continue
+		}
+		if version, err := strconv.Atoi(tag[2 : len(tag)-1]); err == nil {
+			versions = append(versions, version)
+		}
+	}
+	return versions
+}
+
+// respondVersionMismatch writes the 412 for a conditional write that lost
+// to another one.
+func respondVersionMismatch(w http.ResponseWriter, thing string) {
+	respondWithError(w, http.StatusPreconditionFailed, fmt.Sprintf("%s has changed since it was fetched; reload it and try again", thing))
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestIfMatchVersions(t *testing.T) {
+	tests := []struct {
+		header string
+		want   []int
+	}{
+		{"", nil},
+		{"*", nil},
+		{`"v3"`, []int{3}},
+		{`"v2", "v3"`, []int{2, 3}},
+		{`W/"v3"`, []int{}},
+		{`"abc"`, []int{}},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPatch, "/api/posts/1", nil)
+		r.Header.Set("If-Match", tt.header)
+		if got := ifMatchVersions(r); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ifMatchVersions(%q) = %#v, want %#v", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestEditPostIfMatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		ifMatch  string
+		status   int
+		errorMsg string
+	}{
+		{name: "unconditional", status: 200},
+		{name: "current", ifMatch: `"v3"`, status: 200},
+		{name: "any of several", ifMatch: `"v2", "v3"`, status: 200},
+		{name: "any version", ifMatch: "*", status: 200},
+		{name: "stale", ifMatch: `"v2"`, status: 412, errorMsg: "Post has changed since it was fetched; reload it and try again"},
+		{name: "weak", ifMatch: `W/"v3"`, status: 412, errorMsg: "Post has changed since it was fetched; reload it and try again"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newFakeStore()
+			store.posts = []Post{{ID: 1, EventName: "Glastonbury", Content: "Lost: blue hat", Version: 3}}
+			h := newTestHandler(store, HandlerConfig{})
+
+			req := httptest.NewRequest(http.MethodPatch, "/api/posts/1", strings.NewReader(`{"edit_token":"secret","content":"Found: blue hat"}`))
+			req.SetPathValue("id", "1")
+			if tt.ifMatch != "" {
+				req.Header.Set("If-Match", tt.ifMatch)
+			}
+			rec := httptest.NewRecorder()
+			h.EditPost(rec, req)
+
+			if tt.errorMsg != "" {
+				assertError(t, rec, tt.status, tt.errorMsg)
+				if store.posts[0].Content != "Lost: blue hat" {
+					t.Errorf("content = %q, want it unchanged", store.posts[0].Content)
+				}
+				return
+			}
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			if etag := rec.Header().Get("ETag"); etag != `"v4"` {
+				t.Errorf("ETag = %q, want \"v4\"", etag)
+			}
+		})
+	}
+}
+
+func TestUpdateEventSettingsIfMatch(t *testing.T) {
+	store := newFakeStore()
+	store.event = &Event{ID: 1, Name: "Glastonbury", Version: 5}
+	h := newTestHandler(store, HandlerConfig{})
+
+	patch := func(ifMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPatch, "/admin/events/Glastonbury", strings.NewReader(`{"all_ages":true}`))
+		req.SetPathValue("event", "Glastonbury")
+		req.Header.Set("If-Match", ifMatch)
+		rec := httptest.NewRecorder()
+		h.UpdateEventSettings(rec, req)
+		return rec
+	}
+
+	rec := patch(`"v5"`)
+	if rec.Code != http.StatusOK || rec.Header().Get("ETag") != `"v6"` {
+		t.Fatalf("status = %d, ETag = %q, want 200 and \"v6\"", rec.Code, rec.Header().Get("ETag"))
+	}
+
+	// A second organizer still holding the old version is refused
+	assertError(t, patch(`"v5"`), http.StatusPreconditionFailed, "Event has changed since it was fetched; reload it and try again")
+	if store.event.Version != 6 {
+		t.Errorf("version = %d, want 6", store.event.Version)
+	}
+
+	store.event = nil
+	assertError(t, patch(`"v6"`), http.StatusNotFound, "Event not found")
+}
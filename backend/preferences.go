@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	maxKeywords            = 20
+	maxKeywordLength       = 50
+	maxPreferenceLanguages = 10
+)
+
+// Preferences narrow the post listings for a device, server-side, so every
+// client and every page agrees on what is left out. Devices are recognised
+// by their X-Device-Token.
+type Preferences struct {
+	// HideContentWarnings leaves out posts with a content warning even when
+	// a listing asks for them
+	HideContentWarnings bool `json:"hide_content_warnings"`
+	// Keywords, when set, limit listings to posts mentioning any of them
+	Keywords []string `json:"keywords"`
+	// Languages, when set, leave out posts detected in other languages.
+	// A post's language is only known once it has been translated, so
+	// posts never translated are kept.
+	Languages []string   `json:"languages"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+}
+
+// normalizeKeywords trims, lowercases and dedupes keywords, checking they
+// are within limits.
+func normalizeKeywords(raw []string) ([]string, error) {
+	keywords := []string{}
+	for _, keyword := range raw {
+		keyword = strings.ToLower(strings.TrimSpace(keyword))
+		if keyword == "" {
+			return nil, &ValidationError{Message: "keywords can't be empty"}
+		}
+		if len([]rune(keyword)) > maxKeywordLength {
+			return nil, &ValidationError{Message: fmt.Sprintf("keywords must be %d characters or less", maxKeywordLength)}
+		}
+		if !containsString(keywords, keyword) {
+			keywords = append(keywords, keyword)
+		}
+	}
+	if len(keywords) > maxKeywords {
+		return nil, &ValidationError{Message: fmt.Sprintf("at most %d keywords are allowed", maxKeywords)}
+	}
+	return keywords, nil
+}
+
+// normalize dedupes the keywords and languages and checks the preferences
+// are within limits.
+func (p *Preferences) normalize() error {
+	keywords, err := normalizeKeywords(p.Keywords)
+	if err != nil {
+		return err
+	}
+
+	languages := []string{}
+	for _, lang := range p.Languages {
+		if !languageCodePattern.MatchString(lang) {
+			return &ValidationError{Message: fmt.Sprintf("Invalid language code %q", lang)}
+		}
+		if !containsString(languages, lang) {
+			languages = append(languages, lang)
+		}
+	}
+	if len(languages) > maxPreferenceLanguages {
+		return &ValidationError{Message: fmt.Sprintf("at most %d languages are allowed", maxPreferenceLanguages)}
+	}
+
+	p.Keywords, p.Languages = keywords, languages
+	return nil
+}
+
+// apply narrows filter to what the preferences let through.
+func (p *Preferences) apply(filter *PostFilter) {
+	if p.HideContentWarnings {
+		filter.IncludeSensitive = false
+	}
+	filter.Keywords = p.Keywords
+	filter.Languages = p.Languages
+}
+
+// applyPreferences narrows filter by the preferences of the device in
+// X-Device-Token, if the request sends one, writing an error response if
+// they can't be read.
+func (h *Handler) applyPreferences(w http.ResponseWriter, r *http.Request, filter *PostFilter) bool {
+	if r.Header.Get("X-Device-Token") == "" {
+		return true
+	}
+	tokenHash, ok := deviceToken(w, r)
+	if !ok {
+		return false
+	}
+
+	prefs, err := h.db.GetPreferences(r.Context(), tokenHash)
+	if err != nil {
+		log.Printf("Error getting preferences: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve posts")
+		return false
+	}
+	w.Header().Add("Vary", "X-Device-Token")
+	if prefs != nil {
+		prefs.apply(filter)
+	}
+	return true
+}
+
+// GetMyPreferences handles GET /api/me/preferences. A device that hasn't
+// saved any gets the defaults, which filter nothing.
+func (h *Handler) GetMyPreferences(w http.ResponseWriter, r *http.Request) {
+	tokenHash, ok := deviceToken(w, r)
+	if !ok {
+		return
+	}
+
+	prefs, err := h.db.GetPreferences(r.Context(), tokenHash)
+	if err != nil {
+		log.Printf("Error getting preferences: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve preferences")
+		return
+	}
+	if prefs == nil {
+		prefs = &Preferences{Keywords: []string{}, Languages: []string{}}
+	}
+
+	respondWithJSON(w, http.StatusOK, prefs)
+}
+
+// PutMyPreferences handles PUT /api/me/preferences, replacing the device's
+// preferences.
+func (h *Handler) PutMyPreferences(w http.ResponseWriter, r *http.Request) {
+	tokenHash, ok := deviceToken(w, r)
+	if !ok {
+		return
+	}
+
+	var req Preferences
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if err := req.normalize(); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	prefs, err := h.db.SavePreferences(r.Context(), tokenHash, req)
+	if err != nil {
+		log.Printf("Error saving preferences: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to save preferences")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, prefs)
+}
+
+// keywordPatterns turns keywords into ILIKE patterns matching content that
+// contains them.
+func keywordPatterns(keywords []string) []string {
+	escape := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	patterns := make([]string, len(keywords))
+	for i, keyword := range keywords {
+		patterns[i] = "%" + escape.Replace(keyword) + "%"
+	}
+	return patterns
+}
+
+// mentionsKeyword reports whether content contains any of the (lowercase)
+// keywords, as the ILIKE patterns from keywordPatterns would.
+func mentionsKeyword(content string, keywords []string) bool {
+	content = strings.ToLower(content)
+	return slices.ContainsFunc(keywords, func(keyword string) bool {
+		return strings.Contains(content, keyword)
+	})
+}
+
+const preferencesColumns = `hide_content_warnings, keywords, languages, updated_at`
+
+func scanPreferences(row rowScanner) (*Preferences, error) {
+	var prefs Preferences
+	var keywords, languages []byte
+	var updatedAt time.Time
+	if err := row.Scan(&prefs.HideContentWarnings, &keywords, &languages, &updatedAt); err != nil {
+		return nil, err
+	}
+	if err := scanJSON(keywords, &prefs.Keywords); err != nil {
+		return nil, err
+	}
+	if err := scanJSON(languages, &prefs.Languages); err != nil {
+		return nil, err
+	}
+	prefs.UpdatedAt = &updatedAt
+	return &prefs, nil
+}
+
+// GetPreferences returns a device's preferences, or nil if it hasn't saved
+// any.
+func (db *DB) GetPreferences(ctx context.Context, deviceTokenHash string) (*Preferences, error) {
+	query := `SELECT ` + preferencesColumns + ` FROM device_preferences WHERE device_token_hash = $1`
+
+	prefs, err := scanPreferences(db.conn.QueryRowContext(ctx, query, deviceTokenHash))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get preferences: %w", err)
+	}
+	return prefs, nil
+}
+
+// SavePreferences replaces a device's preferences.
+func (db *DB) SavePreferences(ctx context.Context, deviceTokenHash string, prefs Preferences) (*Preferences, error) {
+	keywords, err := json.Marshal(prefs.Keywords)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode keywords: %w", err)
+	}
+	languages, err := json.Marshal(prefs.Languages)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode languages: %w", err)
+	}
+
+	query := `
+		INSERT INTO device_preferences (device_token_hash, hide_content_warnings, keywords, languages, updated_at)
+		VALUES ($1, $2, $3::jsonb, $4::jsonb, NOW())
+		ON CONFLICT (device_token_hash) DO UPDATE
+		SET hide_content_warnings = EXCLUDED.hide_content_warnings, keywords = EXCLUDED.keywords,
+			languages = EXCLUDED.languages, updated_at = EXCLUDED.updated_at
+		RETURNING ` + preferencesColumns
+
+	saved, err := scanPreferences(db.conn.QueryRowContext(ctx, query, deviceTokenHash, prefs.HideContentWarnings, string(keywords), string(languages)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to save preferences: %w", err)
+	}
+	return saved, nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPutMyPreferences(t *testing.T) {
+	token := strings.Repeat("d", 16)
+
+	tests := []struct {
+		name          string
+		token         string
+		body          string
+		fail          bool
+		status        int
+		errorMsg      string
+		wantKeywords  []string
+		wantLanguages []string
+	}{
+		{name: "no token", body: `{}`, status: 400, errorMsg: "X-Device-Token must be a random string of 16 to 128 characters"},
+		{name: "invalid body", token: token, body: `[]`, status: 400, errorMsg: "Invalid request body"},
+		{
+			name:          "normalized",
+			token:         token,
+			body:          `{"keywords":[" Lost ","lost","Ride Share"],"languages":["en","pt-BR","en"]}`,
+			status:        200,
+			wantKeywords:  []string{"lost", "ride share"},
+			wantLanguages: []string{"en", "pt-BR"},
+		},
+		{name: "empty", token: token, body: `{}`, status: 200, wantKeywords: []string{}, wantLanguages: []string{}},
+		{name: "blank keyword", token: token, body: `{"keywords":[" "]}`, status: 400, errorMsg: "keywords can't be empty"},
+		{name: "long keyword", token: token, body: `{"keywords":["` + strings.Repeat("a", 51) + `"]}`, status: 400, errorMsg: "keywords must be 50 characters or less"},
+		{name: "too many keywords", token: token, body: `{"keywords":["a","b","c","d","e","f","g","h","i","j","k","l","m","n","o","p","q","r","s","t","u"]}`, status: 400, errorMsg: "at most 20 keywords are allowed"},
+		{name: "bad language", token: token, body: `{"languages":["English"]}`, status: 400, errorMsg: `Invalid language code "English"`},
+		{name: "save fails", token: token, body: `{}`, fail: true, status: 500, errorMsg: "Failed to save preferences"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newFakeStore()
+			store.fail["SavePreferences"] = tt.fail
+			h := newTestHandler(store, HandlerConfig{})
+
+			req := httptest.NewRequest(http.MethodPut, "/api/me/preferences", strings.NewReader(tt.body))
+			if tt.token != "" {
+				req.Header.Set("X-Device-Token", tt.token)
+			}
+			rec := httptest.NewRecorder()
+			h.PutMyPreferences(rec, req)
+
+			if tt.errorMsg != "" {
+				assertError(t, rec, tt.status, tt.errorMsg)
+				return
+			}
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			if !reflect.DeepEqual(store.preferences.Keywords, tt.wantKeywords) {
+				t.Errorf("keywords = %q, want %q", store.preferences.Keywords, tt.wantKeywords)
+			}
+			if !reflect.DeepEqual(store.preferences.Languages, tt.wantLanguages) {
+				t.Errorf("languages = %q, want %q", store.preferences.Languages, tt.wantLanguages)
+			}
+		})
+	}
+}
+
+func TestGetPostsPreferences(t *testing.T) {
+	prefs := &Preferences{HideContentWarnings: true, Keywords: []string{"lost"}, Languages: []string{"en"}}
+
+	tests := []struct {
+		name       string
+		token      string
+		prefs      *Preferences
+		fail       bool
+		status     int
+		errorMsg   string
+		wantFilter PostFilter
+	}{
+		{name: "no token", prefs: prefs, status: 200, wantFilter: PostFilter{IncludeSensitive: true}},
+		{name: "malformed token", token: "short", status: 400, errorMsg: "X-Device-Token must be a random string of 16 to 128 characters"},
+		{name: "nothing saved", token: strings.Repeat("d", 16), status: 200, wantFilter: PostFilter{IncludeSensitive: true}},
+		{
+			name:       "applied",
+			token:      strings.Repeat("d", 16),
+			prefs:      prefs,
+			status:     200,
+			wantFilter: PostFilter{Keywords: []string{"lost"}, Languages: []string{"en"}},
+		},
+		{name: "lookup fails", token: strings.Repeat("d", 16), fail: true, status: 500, errorMsg: "Failed to retrieve posts"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newFakeStore()
+			store.preferences = tt.prefs
+			store.fail["GetPreferences"] = tt.fail
+			h := newTestHandler(store, HandlerConfig{})
+
+			req := httptest.NewRequest(http.MethodGet, "/api/posts?include_sensitive=true", nil)
+			if tt.token != "" {
+				req.Header.Set("X-Device-Token", tt.token)
+			}
+			rec := httptest.NewRecorder()
+			h.GetPosts(rec, req)
+
+			if tt.errorMsg != "" {
+				assertError(t, rec, tt.status, tt.errorMsg)
+				return
+			}
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			if !reflect.DeepEqual(store.lastFilter, tt.wantFilter) {
+				t.Errorf("filter = %+v, want %+v", store.lastFilter, tt.wantFilter)
+			}
+		})
+	}
+}
+
+func TestKeywordMatching(t *testing.T) {
+	if got, want := keywordPatterns([]string{`100%`, `a_b\c`}), []string{`%100\%%`, `%a\_b\\c%`}; !reflect.DeepEqual(got, want) {
+		t.Errorf("keywordPatterns = %q, want %q", got, want)
+	}
+
+	filter := PostFilter{IncludeSensitive: true, Keywords: []string{"lost", "ride share"}}
+	for content, want := range map[string]bool{
+		"LOST my phone":                  true,
+		"Anyone want a Ride Share home?": true,
+		"Found a phone":                  false,
+	} {
+		if got := filter.matches(Post{Content: content}); got != want {
+			t.Errorf("matches(%q) = %v, want %v", content, got, want)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// PostPreview is what a post would look like once published, with notes on
+// anything that was changed or will happen to it.
+type PostPreview struct {
+	Post     Post     `json:"post"`
+	Warnings []string `json:"warnings"`
+}
+
+// PreviewPost handles POST /api/posts/preview. It takes the same body as
+// POST /api/posts and runs the same checks, but saves nothing, so clients
+// can show a confirmation step with exactly the content that will appear.
+func (h *Handler) PreviewPost(w http.ResponseWriter, r *http.Request) {
+	var req CreatePostRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	submitted := req
+
+	// Hashed as the rate limiter does for POST /api/posts, so the client's
+	// uploads are recognised
+	attachments, err := h.preparePost(w, r, &req, hashIP(getIP(r)))
+	if err != nil {
+		return
+	}
+
+	post := Post{
+		EventName:      req.EventName,
+		Content:        req.Content,
+		Gender:         req.Gender,
+		Location:       req.Location,
+		CreatedAt:      time.Now(),
+		CustomFields:   req.CustomFields,
+		TemplateID:     req.TemplateID,
+		SessionID:      req.SessionID,
+		Attachments:    attachments,
+		ContentWarning: req.ContentWarning,
+	}
+	if req.Age != 0 {
+		post.Age = &req.Age
+	}
+
+	var warnings []string
+
+	if submitted.Age != 0 && req.Age == 0 {
+		warnings = append(warnings, "This event doesn't collect age, so it will be left out")
+	}
+	if submitted.Gender != "" && req.Gender == "" {
+		warnings = append(warnings, "This event doesn't collect gender, so it will be left out")
+	}
+	if submitted.Location != "" && req.Location == "" {
+		warnings = append(warnings, "This event doesn't collect location, so it will be left out")
+	}
+
+	if post.ContentWarning == "" {
+		if suggested := suggestContentWarning(post.Content); suggested != "" {
+			post.ContentWarning = suggested
+			warnings = append(warnings, fmt.Sprintf("A content warning will be added: %s", suggested))
+		}
+	}
+
+	if len(scoreContent(post.Content)) > 0 {
+		warnings = append(warnings, "This post may be reviewed by a moderator")
+	}
+
+	missingAlt := 0
+	for _, a := range attachments {
+		if a.AltText == "" {
+			missingAlt++
+		}
+	}
+	if missingAlt > 0 {
+		warnings = append(warnings, fmt.Sprintf("%d of your images have no alt text for screen reader users", missingAlt))
+	}
+
+	posts := []Post{post}
+	if err := h.applyEventSettings(r.Context(), posts); err != nil {
+		log.Printf("Error applying event settings: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to preview post")
+		return
+	}
+
+	if warnings == nil {
+		warnings = []string{}
+	}
+
+	respondWithJSON(w, http.StatusOK, PostPreview{Post: posts[0], Warnings: warnings})
+}
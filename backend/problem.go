@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const problemContentType = "application/problem+json"
+
+// problemTitles are the titles of the problem types for error codes. An
+// error without a code has no type beyond its status, so it is about:blank
+// with the status text as its title, as RFC 7807 suggests.
+var problemTitles = map[string]string{
+	"rate_limited":  "Rate limit exceeded",
+	capCodeEvent:    "Event is receiving too many posts",
+	capCodeGlobal:   "Posting is paused",
+	overloadedCode:  "Server is busy",
+	postRemovedCode: "Post removed",
+}
+
+// Problem is an RFC 7807 problem details object. Fields of the legacy error
+// body other than the message, such as code and retry_after_seconds, are
+// kept as extension members.
+type Problem struct {
+	Type       string
+	Title      string
+	Status     int
+	Detail     string
+	Extensions map[string]interface{}
+}
+
+func (p Problem) MarshalJSON() ([]byte, error) {
+	fields := map[string]interface{}{
+		"type":   p.Type,
+		"title":  p.Title,
+		"status": p.Status,
+		"detail": p.Detail,
+	}
+	for name, value := range p.Extensions {
+		if _, ok := fields[name]; !ok {
+			fields[name] = value
+		}
+	}
+	return json.Marshal(fields)
+}
+
+// acceptsProblem reports whether the Accept header asks for problem details.
+func acceptsProblem(accept string) bool {
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil || mediaType != problemContentType {
+			continue
+		}
+		if q, err := strconv.ParseFloat(params["q"], 64); err == nil && q == 0 {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// toProblem converts a legacy {"error": ...} body to problem details, or
+// reports false if the body isn't one.
+func toProblem(status int, body []byte, typeBase string) (Problem, bool) {
+	var fields map[string]interface{}
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return Problem{}, false
+	}
+	message, ok := fields["error"].(string)
+	if !ok {
+		return Problem{}, false
+	}
+	delete(fields, "error")
+
+	problem := Problem{Type: "about:blank", Title: http.StatusText(status), Status: status, Detail: message, Extensions: fields}
+	if code, _ := fields["code"].(string); code != "" {
+		problem.Type = typeBase + "/problems/" + strings.ReplaceAll(code, "_", "-")
+		if title, ok := problemTitles[code]; ok {
+			problem.Title = title
+		}
+	}
+	return problem, true
+}
+
+// ProblemDetails returns errors as RFC 7807 problem details to clients that
+// send Accept: application/problem+json; everyone else keeps the legacy
+// {"error": ...} body. Problem types for error codes are URIs under
+// typeBase, the server's public URL; without one they are relative.
+func ProblemDetails(next http.Handler, typeBase string) http.Handler {
+	typeBase = strings.TrimSuffix(typeBase, "/")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !acceptsProblem(r.Header.Get("Accept")) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		pw := &problemWriter{ResponseWriter: w}
+		next.ServeHTTP(pw, r)
+		if pw.body == nil {
+			return
+		}
+
+		problem, ok := toProblem(pw.status, pw.body.Bytes(), typeBase)
+		if !ok {
+			w.WriteHeader(pw.status)
+			w.Write(pw.body.Bytes())
+			return
+		}
+		w.Header().Set("Content-Type", problemContentType)
+		w.Header().Del("Content-Length")
+		w.Header().Add("Vary", "Accept")
+		w.WriteHeader(pw.status)
+		if err := json.NewEncoder(w).Encode(problem); err != nil {
+			log.Printf("Error encoding problem details: %v", err)
+		}
+	})
+}
+
+// problemWriter holds back JSON error responses so ProblemDetails can
+// rewrite them, and passes everything else straight through.
+type problemWriter struct {
+	http.ResponseWriter
+	status int
+	// body is set once an error response is being held back
+	body *bytes.Buffer
+}
+
+func (pw *problemWriter) WriteHeader(status int) {
+	if status >= 400 && strings.HasPrefix(pw.Header().Get("Content-Type"), "application/json") {
+		pw.status, pw.body = status, &bytes.Buffer{}
+		return
+	}
+	pw.ResponseWriter.WriteHeader(status)
+}
+
+func (pw *problemWriter) Write(b []byte) (int, error) {
+	if pw.body != nil {
+		return pw.body.Write(b)
+	}
+	return pw.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (pw *problemWriter) Unwrap() http.ResponseWriter {
+	return pw.ResponseWriter
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestProblemDetails(t *testing.T) {
+	tests := []struct {
+		name     string
+		accept   string
+		handler  http.HandlerFunc
+		wantType string
+		want     map[string]interface{}
+	}{
+		{
+			name:   "legacy by default",
+			accept: "application/json",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				respondWithError(w, http.StatusNotFound, "Event not found")
+			},
+			wantType: "application/json",
+			want:     map[string]interface{}{"error": "Event not found"},
+		},
+		{
+			name:   "declined with q=0",
+			accept: "application/json, application/problem+json;q=0",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				respondWithError(w, http.StatusNotFound, "Event not found")
+			},
+			wantType: "application/json",
+			want:     map[string]interface{}{"error": "Event not found"},
+		},
+		{
+			name:   "error without a code",
+			accept: "application/problem+json, application/json;q=0.5",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				respondWithError(w, http.StatusNotFound, "Event not found")
+			},
+			wantType: problemContentType,
+			want: map[string]interface{}{
+				"type":   "about:blank",
+				"title":  "Not Found",
+				"status": float64(404),
+				"detail": "Event not found",
+			},
+		},
+		{
+			name:   "error with a code",
+			accept: "application/problem+json",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				respondRateLimited(w, "rate_limited", "Slow down", 90*time.Second)
+			},
+			wantType: problemContentType,
+			want: map[string]interface{}{
+				"type":                "https://hndshake.example/problems/rate-limited",
+				"title":               "Rate limit exceeded",
+				"status":              float64(429),
+				"detail":              "Slow down",
+				"code":                "rate_limited",
+				"retry_after_seconds": float64(91),
+			},
+		},
+		{
+			name:   "success untouched",
+			accept: "application/problem+json",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				respondWithJSON(w, http.StatusOK, map[string]string{"error": "not really"})
+			},
+			wantType: "application/json",
+			want:     map[string]interface{}{"error": "not really"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := ProblemDetails(tt.handler, "https://hndshake.example/")
+			req := httptest.NewRequest(http.MethodGet, "/api/events/nope", nil)
+			req.Header.Set("Accept", tt.accept)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if ct := rec.Header().Get("Content-Type"); ct != tt.wantType {
+				t.Errorf("Content-Type = %q, want %q", ct, tt.wantType)
+			}
+			var body map[string]interface{}
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("body is not JSON: %v (%s)", err, rec.Body)
+			}
+			if !reflect.DeepEqual(body, tt.want) {
+				t.Errorf("body = %v, want %v", body, tt.want)
+			}
+		})
+	}
+}
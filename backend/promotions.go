@@ -0,0 +1,366 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	maxPromotionChars     = 500
+	maxSponsorLength      = 100
+	maxPromotionURLLength = 2048
+	// maxPromotionPosition keeps cards on the first page of a feed
+	maxPromotionPosition = defaultPageSize
+	// maxActivePromotions caps the cards in one feed, so sponsors can't
+	// crowd out the posts
+	maxActivePromotions = 3
+
+	promotionImpressionsFlushInterval = time.Minute
+)
+
+// Promotion is a sponsored card an organizer places in an event's feed. It
+// is shown, labeled as promoted, on the first page of the feed between
+// StartsAt and EndsAt.
+type Promotion struct {
+	ID        int    `json:"id"`
+	EventName string `json:"event_name"`
+	Sponsor   string `json:"sponsor"`
+	Content   string `json:"content"`
+	LinkURL   string `json:"link_url,omitempty"`
+	// Position is the card's place on the page; 1 is the top
+	Position    int        `json:"position"`
+	StartsAt    *time.Time `json:"starts_at,omitempty"`
+	EndsAt      *time.Time `json:"ends_at,omitempty"`
+	Impressions int64      `json:"impressions"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+type CreatePromotionRequest struct {
+	Sponsor  string     `json:"sponsor"`
+	Content  string     `json:"content"`
+	LinkURL  string     `json:"link_url"`
+	Position int        `json:"position"`
+	StartsAt *time.Time `json:"starts_at"`
+	EndsAt   *time.Time `json:"ends_at"`
+}
+
+// PromotionLabel says who a promoted card in a feed is from.
+type PromotionLabel struct {
+	ID      int    `json:"id"`
+	Sponsor string `json:"sponsor"`
+	LinkURL string `json:"link_url,omitempty"`
+}
+
+func (req *CreatePromotionRequest) validate() error {
+	req.Sponsor = strings.TrimSpace(req.Sponsor)
+	if req.Sponsor == "" || len(req.Sponsor) > maxSponsorLength {
+		return &ValidationError{Message: fmt.Sprintf("sponsor is required, and must be %d characters or less", maxSponsorLength)}
+	}
+	req.Content = strings.TrimSpace(req.Content)
+	if req.Content == "" || utf8.RuneCountInString(req.Content) > maxPromotionChars {
+		return &ValidationError{Message: fmt.Sprintf("content is required, and must be %d characters or less", maxPromotionChars)}
+	}
+	req.LinkURL = strings.TrimSpace(req.LinkURL)
+	if req.LinkURL != "" {
+		u, err := url.Parse(req.LinkURL)
+		if err != nil || u.Scheme != "https" || u.Host == "" || len(req.LinkURL) > maxPromotionURLLength {
+			return &ValidationError{Message: fmt.Sprintf("link_url must be an https URL of at most %d characters", maxPromotionURLLength)}
+		}
+	}
+	if req.Position < 1 || req.Position > maxPromotionPosition {
+		return &ValidationError{Message: fmt.Sprintf("position must be between 1 and %d", maxPromotionPosition)}
+	}
+	if req.StartsAt != nil && req.EndsAt != nil && !req.EndsAt.After(*req.StartsAt) {
+		return &ValidationError{Message: "ends_at must be after starts_at"}
+	}
+	return nil
+}
+
+// card is the promotion as it appears in a feed.
+func (p Promotion) card() Post {
+	return Post{
+		EventName:  p.EventName,
+		Content:    p.Content,
+		CreatedAt:  p.CreatedAt,
+		IsPromoted: true,
+		Promotion:  &PromotionLabel{ID: p.ID, Sponsor: p.Sponsor, LinkURL: p.LinkURL},
+	}
+}
+
+// placePromotions inserts promoted cards into a feed's first page at their
+// positions, from the top down, so each lands where it was asked for.
+// Positions past the end of the page go last.
+func placePromotions(posts []Post, promotions []Promotion) []Post {
+	if len(promotions) == 0 {
+		return posts
+	}
+	sort.SliceStable(promotions, func(i, j int) bool { return promotions[i].Position < promotions[j].Position })
+
+	placed := slices.Clone(posts)
+	for _, p := range promotions {
+		placed = slices.Insert(placed, min(p.Position-1, len(placed)), p.card())
+	}
+	return placed
+}
+
+// promotePosts places an event's running promotions on the first page of
+// its feed, returning the page and the promotions shown.
+func (h *Handler) promotePosts(ctx context.Context, event string, posts []Post) ([]Post, []int, error) {
+	promotions, err := h.db.GetActivePromotions(ctx, event)
+	if err != nil {
+		return nil, nil, err
+	}
+	ids := make([]int, len(promotions))
+	for i, p := range promotions {
+		ids[i] = p.ID
+	}
+	return placePromotions(posts, promotions), ids, nil
+}
+
+// PromotionImpressions counts the times promoted cards are served in memory
+// and periodically adds them to promoted_posts, so a busy feed costs one
+// write a minute per card rather than one per request.
+type PromotionImpressions struct {
+	db *DB
+
+	mu     sync.Mutex
+	counts map[int]int64
+}
+
+func NewPromotionImpressions(db *DB) *PromotionImpressions {
+	return &PromotionImpressions{db: db, counts: make(map[int]int64)}
+}
+
+// Record counts an impression of each promotion.
+func (p *PromotionImpressions) Record(ids []int) {
+	if p == nil || len(ids) == 0 {
+		return
+	}
+	p.mu.Lock()
+	for _, id := range ids {
+		p.counts[id]++
+	}
+	p.mu.Unlock()
+}
+
+// Run flushes counts every promotionImpressionsFlushInterval, and once more
+// when ctx is cancelled.
+func (p *PromotionImpressions) Run(ctx context.Context) {
+	ticker := time.NewTicker(promotionImpressionsFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			p.flush(ctx)
+		case <-ctx.Done():
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			p.flush(shutdownCtx)
+			cancel()
+			return
+		}
+	}
+}
+
+func (p *PromotionImpressions) flush(ctx context.Context) {
+	p.mu.Lock()
+	counts := p.counts
+	p.counts = make(map[int]int64)
+	p.mu.Unlock()
+
+	for id, count := range counts {
+		if err := p.db.AddPromotionImpressions(ctx, id, count); err != nil {
+			log.Printf("Error recording promotion impressions: %v", err)
+		}
+	}
+}
+
+// GetPromotions handles GET /admin/events/{event}/promotions, newest first,
+// including ended ones with their impressions.
+func (h *Handler) GetPromotions(w http.ResponseWriter, r *http.Request) {
+	limit, offset := parsePagination(r)
+
+	promotions, err := h.db.GetPromotions(r.Context(), r.PathValue("event"), limit, offset)
+	if err != nil {
+		log.Printf("Error getting promotions: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve promotions")
+		return
+	}
+
+	if promotions == nil {
+		promotions = []Promotion{}
+	}
+
+	respondWithJSON(w, http.StatusOK, promotions)
+}
+
+// CreatePromotion handles POST /admin/events/{event}/promotions
+func (h *Handler) CreatePromotion(w http.ResponseWriter, r *http.Request) {
+	var req CreatePromotionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if err := req.validate(); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	promotion, err := h.db.CreatePromotion(r.Context(), r.PathValue("event"), req)
+	if err != nil {
+		log.Printf("Error creating promotion: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to create promotion")
+		return
+	}
+	if promotion == nil {
+		respondWithError(w, http.StatusNotFound, "Event not found")
+		return
+	}
+
+	h.audit(r, "promotion.create", "event", promotion.EventName, promotion)
+
+	respondWithJSON(w, http.StatusCreated, promotion)
+}
+
+// EndPromotion handles DELETE /admin/events/{event}/promotions/{id}, which
+// takes the card out of the feed straight away. It is kept, with its
+// impressions, for reporting to the sponsor.
+func (h *Handler) EndPromotion(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid promotion ID")
+		return
+	}
+
+	found, err := h.db.EndPromotion(r.Context(), r.PathValue("event"), id)
+	if err != nil {
+		log.Printf("Error ending promotion: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to end promotion")
+		return
+	}
+	if !found {
+		respondWithError(w, http.StatusNotFound, "Running promotion not found")
+		return
+	}
+
+	h.audit(r, "promotion.end", "event", r.PathValue("event"), map[string]int{"id": id})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+const promotionColumns = `id, event_name, sponsor, content, link_url, position, starts_at, ends_at, impressions, created_at`
+
+func scanPromotion(row rowScanner) (*Promotion, error) {
+	var p Promotion
+	if err := row.Scan(&p.ID, &p.EventName, &p.Sponsor, &p.Content, &p.LinkURL, &p.Position, &p.StartsAt, &p.EndsAt, &p.Impressions, &p.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func (db *DB) queryPromotions(ctx context.Context, query string, args ...interface{}) ([]Promotion, error) {
+	rows, err := db.reader(ctx).QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query promotions: %w", err)
+	}
+	defer rows.Close()
+
+	var promotions []Promotion
+	for rows.Next() {
+		p, err := scanPromotion(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan promotion: %w", err)
+		}
+		promotions = append(promotions, *p)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating promotions: %w", err)
+	}
+
+	return promotions, nil
+}
+
+// GetActivePromotions returns an event's running promotions, at most
+// maxActivePromotions of them, the oldest first.
+func (db *DB) GetActivePromotions(ctx context.Context, eventName string) ([]Promotion, error) {
+	return db.queryPromotions(ctx, `
+		SELECT `+promotionColumns+`
+		FROM promoted_posts
+		WHERE event_name = $1
+		AND (starts_at IS NULL OR starts_at <= NOW())
+		AND (ends_at IS NULL OR ends_at > NOW())
+		ORDER BY created_at, id
+		LIMIT $2
+	`, eventName, maxActivePromotions)
+}
+
+func (db *DB) GetPromotions(ctx context.Context, eventName string, limit, offset int) ([]Promotion, error) {
+	return db.queryPromotions(ctx, `
+		SELECT `+promotionColumns+`
+		FROM promoted_posts
+		WHERE event_name = $1
+		ORDER BY created_at DESC, id DESC
+		LIMIT $2 OFFSET $3
+	`, eventName, limit, offset)
+}
+
+// CreatePromotion places a promotion in eventName's feed, returning nil if
+// there is no such event.
+func (db *DB) CreatePromotion(ctx context.Context, eventName string, req CreatePromotionRequest) (*Promotion, error) {
+	promotion, err := scanPromotion(db.conn.QueryRowContext(ctx, `
+		INSERT INTO promoted_posts (event_name, sponsor, content, link_url, position, starts_at, ends_at)
+		SELECT name, $2, $3, $4, $5, $6, $7 FROM events WHERE name = $1
+		RETURNING `+promotionColumns,
+		eventName, req.Sponsor, req.Content, req.LinkURL, req.Position, req.StartsAt, req.EndsAt,
+	))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create promotion: %w", err)
+	}
+	return promotion, nil
+}
+
+// EndPromotion ends one of eventName's promotions now, reporting whether it
+// existed and hadn't ended.
+func (db *DB) EndPromotion(ctx context.Context, eventName string, id int) (bool, error) {
+	result, err := db.conn.ExecContext(ctx,
+		"UPDATE promoted_posts SET ends_at = NOW() WHERE id = $1 AND event_name = $2 AND (ends_at IS NULL OR ends_at > NOW())",
+		id, eventName,
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to end promotion: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to end promotion: %w", err)
+	}
+
+	return affected > 0, nil
+}
+
+func (db *DB) AddPromotionImpressions(ctx context.Context, id int, count int64) error {
+	_, err := db.conn.ExecContext(ctx,
+		"UPDATE promoted_posts SET impressions = impressions + $2 WHERE id = $1",
+		id, count,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to add promotion impressions: %w", err)
+	}
+	return nil
+}
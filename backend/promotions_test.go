@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPlacePromotions(t *testing.T) {
+	posts := []Post{{ID: 1}, {ID: 2}, {ID: 3}}
+	placed := placePromotions(posts, []Promotion{
+		{ID: 20, Position: 50},
+		{ID: 10, Position: 2},
+		{ID: 11, Position: 1},
+	})
+
+	var got []int
+	for _, p := range placed {
+		if p.IsPromoted {
+			got = append(got, -p.Promotion.ID)
+		} else {
+			got = append(got, p.ID)
+		}
+	}
+	want := []int{-11, -10, 1, 2, 3, -20}
+	if len(got) != len(want) {
+		t.Fatalf("placed = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("placed = %v, want %v", got, want)
+		}
+	}
+	if len(posts) != 3 {
+		t.Errorf("the page passed in was changed: %v", posts)
+	}
+}
+
+func TestGetPostsPromotions(t *testing.T) {
+	store := newFakeStore()
+	store.posts = []Post{{ID: 1, PublicID: "a000000001", EventName: "Glastonbury", Content: "Lost: blue hat"}}
+	store.promotions = []Promotion{{ID: 7, EventName: "Glastonbury", Sponsor: "Hat Co", Content: "Hats at stall 4", Position: 1}}
+	h := newTestHandler(store, HandlerConfig{})
+
+	get := func(query string) []Post {
+		rec := httptest.NewRecorder()
+		h.GetPosts(rec, httptest.NewRequest(http.MethodGet, "/api/posts?"+query, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want 200 (body %s)", rec.Code, rec.Body)
+		}
+		var posts []Post
+		if err := json.NewDecoder(rec.Body).Decode(&posts); err != nil {
+			t.Fatal(err)
+		}
+		return posts
+	}
+
+	posts := get("event=Glastonbury")
+	if len(posts) != 2 || !posts[0].IsPromoted || posts[0].Promotion == nil || posts[0].Promotion.Sponsor != "Hat Co" {
+		t.Errorf("first page = %+v, want the promoted card on top", posts)
+	}
+
+	// Later pages, and feeds across events, have no promoted cards
+	for _, query := range []string{"event=Glastonbury&offset=50", ""} {
+		for _, p := range get(query) {
+			if p.IsPromoted {
+				t.Errorf("?%s has a promoted card", query)
+			}
+		}
+	}
+}
+
+func TestCreatePromotionValidation(t *testing.T) {
+	h := newTestHandler(newFakeStore(), HandlerConfig{})
+
+	tests := []struct {
+		body string
+		want string
+	}{
+		{`{"content":"Hats","position":1}`, "sponsor is required, and must be 100 characters or less"},
+		{`{"sponsor":"Hat Co","position":1}`, "content is required, and must be 500 characters or less"},
+		{`{"sponsor":"Hat Co","content":"Hats","link_url":"http://hats.example","position":1}`, "link_url must be an https URL of at most 2048 characters"},
+		{`{"sponsor":"Hat Co","content":"Hats","position":0}`, "position must be between 1 and 50"},
+		{`{"sponsor":"Hat Co","content":"Hats","position":1,"starts_at":"2026-07-02T00:00:00Z","ends_at":"2026-07-01T00:00:00Z"}`, "ends_at must be after starts_at"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/admin/events/Glastonbury/promotions", strings.NewReader(tt.body))
+		req.SetPathValue("event", "Glastonbury")
+		rec := httptest.NewRecorder()
+		h.CreatePromotion(rec, req)
+		assertError(t, rec, http.StatusBadRequest, tt.want)
+	}
+}
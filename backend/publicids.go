@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// publicIDPattern matches the public IDs assigned by new_post_public_id.
+// They start with a letter, so they never look like a legacy integer ID.
+var publicIDPattern = regexp.MustCompile(`^[a-z][0-9a-z]{9}$`)
+
+// uuidV7Pattern matches a post's UUID, in lowercase.
+var uuidV7Pattern = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+// withPost resolves the {id} path value of a public post route, which may
+// be a post's public ID or UUID, to its internal ID, so handlers only deal
+// in those. Integer IDs are accepted while LegacyPostIDs is set, so links
+// from before public IDs keep working. Other values pass through unchanged
+// for the handler to report.
+func (h *Handler) withPost(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "OPTIONS" {
+			next(w, r)
+			return
+		}
+
+		ref := r.PathValue("id")
+
+		var lookup func(context.Context, string) (int, error)
+		switch {
+		case publicIDPattern.MatchString(ref):
+			lookup = h.db.GetPostIDByPublicID
+		case uuidV7Pattern.MatchString(strings.ToLower(ref)):
+			ref = strings.ToLower(ref)
+			lookup = h.db.GetPostIDByUUID
+		}
+
+		if lookup != nil {
+			id, err := lookup(r.Context(), ref)
+			if err != nil {
+				log.Printf("Error looking up post: %v", err)
+				respondWithError(w, http.StatusInternalServerError, "Failed to retrieve post")
+				return
+			}
+			if id == 0 {
+				respondWithError(w, http.StatusNotFound, "Post not found")
+				return
+			}
+			r.SetPathValue("id", strconv.Itoa(id))
+		} else if _, err := strconv.Atoi(ref); err == nil && !h.cfg.LegacyPostIDs {
+			respondWithError(w, http.StatusNotFound, "Post not found")
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// maxPostsByID caps how many posts one ?ids= request can fetch.
+const maxPostsByID = 100
+
+// PostsByID is the response to GET /api/posts?ids=.
+type PostsByID struct {
+	// Posts are in the order their IDs were asked for
+	Posts any `json:"posts"`
+	// Missing lists the IDs of posts that don't exist or can't be shown,
+	// such as hidden posts and those on archived boards
+	Missing []string `json:"missing"`
+}
+
+// getPostsByID serves GET /api/posts?ids=a,b,c, so screens like bookmarks
+// can load many posts, by public ID, in one request.
+func (h *Handler) getPostsByID(w http.ResponseWriter, r *http.Request, projection PostProjection) {
+	var ids []string
+	seen := make(map[string]bool)
+	for _, id := range strings.Split(r.URL.Query().Get("ids"), ",") {
+		id = strings.TrimSpace(id)
+		if id == "" || seen[id] {
+			continue
+		}
+		if !publicIDPattern.MatchString(id) {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Invalid post ID %q", id))
+			return
+		}
+		seen[id] = true
+		ids = append(ids, id)
+	}
+	if len(ids) > maxPostsByID {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("ids must list at most %d posts", maxPostsByID))
+		return
+	}
+
+	found, err := h.db.GetPostsByPublicIDs(r.Context(), ids)
+	if err != nil {
+		log.Printf("Error getting posts by ID: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve posts")
+		return
+	}
+
+	byID := make(map[string]Post, len(found))
+	for _, post := range found {
+		byID[post.PublicID] = post
+	}
+	posts := []Post{}
+	missing := []string{}
+	for _, id := range ids {
+		if post, ok := byID[id]; ok {
+			posts = append(posts, post)
+		} else {
+			missing = append(missing, id)
+		}
+	}
+
+	if err := h.presentPosts(r.Context(), posts, projection); err != nil {
+		log.Printf("Error presenting posts: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve posts")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, PostsByID{Posts: projection.Apply(posts), Missing: missing})
+}
+
+// GetPostsByPublicIDs returns the visible posts among publicIDs, in no
+// particular order.
+func (db *DB) GetPostsByPublicIDs(ctx context.Context, publicIDs []string) ([]Post, error) {
+	return db.queryPosts(ctx, `
+		SELECT `+postColumns+`
+		FROM posts
+		WHERE public_id = ANY($1) AND hidden_at IS NULL
+	`, publicIDs)
+}
+
+// GetPostIDByPublicID returns the internal ID of the post with a public ID,
+// or 0 if there is none.
+func (db *DB) GetPostIDByPublicID(ctx context.Context, publicID string) (int, error) {
+	var id int
+	err := db.reader(ctx).QueryRowContext(ctx, "SELECT id FROM posts WHERE public_id = $1", publicID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to get post by public ID: %w", err)
+	}
+	return id, nil
+}
+
+// GetPostIDByUUID returns the internal ID of the post with a UUID, or 0 if
+// there is none.
+func (db *DB) GetPostIDByUUID(ctx context.Context, uuid string) (int, error) {
+	var id int
+	err := db.reader(ctx).QueryRowContext(ctx, "SELECT id FROM posts WHERE uuid = $1", uuid).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to get post by UUID: %w", err)
+	}
+	return id, nil
+}
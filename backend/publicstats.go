@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	publicStatsInterval = time.Hour
+	publicStatsCacheTTL = 5 * time.Minute
+	// publicStatsMinPosts is the fewest posts a week needs for its match
+	// rate to be shown, so the rate can't reveal whether one post was
+	// answered
+	publicStatsMinPosts = 20
+)
+
+// PublicWeekStats are a week's totals, Monday to Monday UTC.
+type PublicWeekStats struct {
+	WeekStart    time.Time `json:"week_start"`
+	Posts        int       `json:"posts"`
+	ActiveEvents int       `json:"active_events"`
+	// MatchRate is the share of the week's posts that got a reply; null
+	// for weeks with too few posts
+	MatchRate *float64 `json:"match_rate"`
+
+	matchedPosts int
+	updatedAt    time.Time
+}
+
+// PublicStatsReport is the public stats page data. It holds totals over
+// all boards only, never anything per event, device or post.
+type PublicStatsReport struct {
+	ThisWeek  PublicWeekStats  `json:"this_week"`
+	LastWeek  *PublicWeekStats `json:"last_week,omitempty"`
+	UpdatedAt *time.Time       `json:"updated_at,omitempty"`
+}
+
+// PublicStats rolls up weekly totals for the public stats page every
+// publicStatsInterval, and serves them. The current and last week are
+// rolled up each time, so the last week is final once the next has begun;
+// earlier weeks keep their totals after retention deletes their posts.
+type PublicStats struct {
+	db *DB
+
+	mu       sync.Mutex
+	cached   *PublicStatsReport
+	cachedAt time.Time
+}
+
+func NewPublicStats(db *DB) *PublicStats {
+	return &PublicStats{db: db}
+}
+
+// weekStart is the Monday, UTC, of the week now is in.
+func weekStart(now time.Time) time.Time {
+	now = now.UTC()
+	offset := (int(now.Weekday()) + 6) % 7
+	return time.Date(now.Year(), now.Month(), now.Day()-offset, 0, 0, 0, 0, time.UTC)
+}
+
+// Run rolls up the totals now and then every publicStatsInterval until ctx
+// is cancelled.
+func (s *PublicStats) Run(ctx context.Context) {
+	s.rollup(ctx)
+
+	ticker := time.NewTicker(publicStatsInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.rollup(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *PublicStats) rollup(ctx context.Context) {
+	thisWeek := weekStart(time.Now())
+	for _, week := range []time.Time{thisWeek.AddDate(0, 0, -7), thisWeek} {
+		if err := s.db.RollupPublicStats(ctx, week); err != nil {
+			log.Printf("Error rolling up public stats: %v", err)
+			return
+		}
+	}
+}
+
+// showMatchRate sets the week's match rate, if it has enough posts.
+func (w *PublicWeekStats) showMatchRate() {
+	if w.Posts < publicStatsMinPosts {
+		return
+	}
+	rate := float64(w.matchedPosts) / float64(w.Posts)
+	w.MatchRate = &rate
+}
+
+func (s *PublicStats) report(ctx context.Context, now time.Time) (*PublicStatsReport, error) {
+	thisWeek := weekStart(now)
+	lastWeek := thisWeek.AddDate(0, 0, -7)
+	weeks, err := s.db.GetPublicStats(ctx, lastWeek)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &PublicStatsReport{ThisWeek: PublicWeekStats{WeekStart: thisWeek}}
+	for i := range weeks {
+		week := weeks[i]
+		week.showMatchRate()
+		switch {
+		case week.WeekStart.Equal(thisWeek):
+			report.ThisWeek = week
+			report.UpdatedAt = &week.updatedAt
+		case week.WeekStart.Equal(lastWeek):
+			report.LastWeek = &week
+		}
+	}
+	return report, nil
+}
+
+// Get handles GET /api/public-stats. Reports are cached for
+// publicStatsCacheTTL; the totals behind them change hourly at most.
+func (s *PublicStats) Get(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cached == nil || now.Sub(s.cachedAt) >= publicStatsCacheTTL {
+		report, err := s.report(r.Context(), now)
+		if err != nil {
+			log.Printf("Error getting public stats: %v", err)
+			respondWithError(w, http.StatusInternalServerError, "Failed to retrieve stats")
+			return
+		}
+		s.cached, s.cachedAt = report, now
+	}
+
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(publicStatsCacheTTL.Seconds())))
+	respondWithJSON(w, http.StatusOK, s.cached)
+}
+
+// RollupPublicStats recounts the totals for the week starting at week.
+// Hidden posts aren't counted.
+func (db *DB) RollupPublicStats(ctx context.Context, week time.Time) error {
+	_, err := db.conn.ExecContext(ctx, `
+		INSERT INTO public_stats (week_start, posts, active_events, matched_posts, updated_at)
+		SELECT $1, COUNT(*), COUNT(DISTINCT event_name), COUNT(*) FILTER (WHERE reply_count > 0), NOW()
+		FROM posts
+		WHERE created_at >= $1 AND created_at < $2 AND hidden_at IS NULL
+		ON CONFLICT (week_start) DO UPDATE SET
+			posts = EXCLUDED.posts,
+			active_events = EXCLUDED.active_events,
+			matched_posts = EXCLUDED.matched_posts,
+			updated_at = EXCLUDED.updated_at
+	`, week, week.AddDate(0, 0, 7))
+	if err != nil {
+		return fmt.Errorf("failed to roll up public stats: %w", err)
+	}
+	return nil
+}
+
+// GetPublicStats returns the rolled up weeks starting at or after since.
+func (db *DB) GetPublicStats(ctx context.Context, since time.Time) ([]PublicWeekStats, error) {
+	rows, err := db.reader(ctx).QueryContext(ctx, `
+		SELECT week_start, posts, active_events, matched_posts, updated_at
+		FROM public_stats
+		WHERE week_start >= $1
+		ORDER BY week_start
+	`, since)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query public stats: %w", err)
+	}
+	defer rows.Close()
+
+	var weeks []PublicWeekStats
+	for rows.Next() {
+		var w PublicWeekStats
+		if err := rows.Scan(&w.WeekStart, &w.Posts, &w.ActiveEvents, &w.matchedPosts, &w.updatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan public stats: %w", err)
+		}
+		w.WeekStart = w.WeekStart.UTC()
+		weeks = append(weeks, w)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating public stats: %w", err)
+	}
+
+	return weeks, nil
+}
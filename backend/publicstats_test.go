@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWeekStart(t *testing.T) {
+	tests := []struct {
+		now  time.Time
+		want time.Time
+	}{
+		{time.Date(2024, 6, 29, 23, 0, 0, 0, time.UTC), time.Date(2024, 6, 24, 0, 0, 0, 0, time.UTC)},
+		{time.Date(2024, 6, 24, 0, 0, 0, 0, time.UTC), time.Date(2024, 6, 24, 0, 0, 0, 0, time.UTC)},
+		{time.Date(2024, 6, 30, 23, 59, 0, 0, time.UTC), time.Date(2024, 6, 24, 0, 0, 0, 0, time.UTC)},
+		// Monday morning in Tokyo is still Sunday in UTC
+		{time.Date(2024, 7, 1, 8, 0, 0, 0, time.FixedZone("JST", 9*60*60)), time.Date(2024, 6, 24, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		if got := weekStart(tt.now); !got.Equal(tt.want) {
+			t.Errorf("weekStart(%v) = %v, want %v", tt.now, got, tt.want)
+		}
+	}
+}
+
+func TestShowMatchRate(t *testing.T) {
+	quiet := PublicWeekStats{Posts: publicStatsMinPosts - 1, matchedPosts: 1}
+	quiet.showMatchRate()
+	if quiet.MatchRate != nil {
+		t.Errorf("match rate of a quiet week = %v, want none", *quiet.MatchRate)
+	}
+
+	busy := PublicWeekStats{Posts: 40, matchedPosts: 10}
+	busy.showMatchRate()
+	if busy.MatchRate == nil || *busy.MatchRate != 0.25 {
+		t.Errorf("match rate = %v, want 0.25", busy.MatchRate)
+	}
+}
+
+func TestPublicStatsRollup(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	stats := NewPublicStats(db)
+
+	get := func() PublicStatsReport {
+		stats.cached = nil
+		rec := httptest.NewRecorder()
+		stats.Get(rec, httptest.NewRequest(http.MethodGet, "/api/public-stats", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want 200 (body %s)", rec.Code, rec.Body)
+		}
+		var report PublicStatsReport
+		if err := json.NewDecoder(rec.Body).Decode(&report); err != nil {
+			t.Fatal(err)
+		}
+		return report
+	}
+
+	stats.rollup(ctx)
+	before := get()
+
+	event := fmt.Sprintf("Public Stats Test %d", time.Now().UnixNano())
+	if _, err := db.CreatePost(ctx, CreatePostRequest{EventName: event, Content: "hello", Age: 25, Location: "x"}, "public-stats-test", hashToken("token")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Until the next rollup, the page shows the last totals
+	if got := get(); got.ThisWeek.Posts != before.ThisWeek.Posts {
+		t.Errorf("posts before rollup = %d, want %d", got.ThisWeek.Posts, before.ThisWeek.Posts)
+	}
+
+	stats.rollup(ctx)
+	after := get()
+	if after.ThisWeek.Posts != before.ThisWeek.Posts+1 || after.ThisWeek.ActiveEvents != before.ThisWeek.ActiveEvents+1 {
+		t.Errorf("this week = %+v, want one more post and active event than %+v", after.ThisWeek, before.ThisWeek)
+	}
+	if !after.ThisWeek.WeekStart.Equal(weekStart(time.Now())) || after.UpdatedAt == nil {
+		t.Errorf("report = %+v, want this week's rolled up totals", after)
+	}
+}
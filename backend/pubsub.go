@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5/stdlib"
+)
+
+// PostRelay carries post publications between server processes, so a
+// PostBroker in one process hears about posts published by another.
+type PostRelay interface {
+	// Send tells the other processes that a post was published on event.
+	Send(event string)
+}
+
+// NewPostRelay returns the relay named by PUBSUB_RELAY, or nil to keep
+// publications within the process.
+func NewPostRelay(kind string, db *DB) (PostRelay, error) {
+	switch kind {
+	case "", "none":
+		return nil, nil
+	case "postgres":
+		return NewPostgresRelay(db), nil
+	default:
+		return nil, fmt.Errorf("unknown pub/sub relay %q", kind)
+	}
+}
+
+const (
+	postsChannel = "posts_published"
+	// relaySendTimeout bounds each NOTIFY, so a slow database can't pile up
+	// senders
+	relaySendTimeout  = 5 * time.Second
+	relayMaxReconnect = 30 * time.Second
+)
+
+// relayMessage is the NOTIFY payload.
+type relayMessage struct {
+	// Instance is the sending process, which already told its own waiters
+	Instance string `json:"instance"`
+	Event    string `json:"event"`
+}
+
+// PostgresRelay relays publications with Postgres LISTEN/NOTIFY. Each
+// process holds one pooled connection open to listen on.
+type PostgresRelay struct {
+	db       *DB
+	instance string
+}
+
+func NewPostgresRelay(db *DB) *PostgresRelay {
+	return &PostgresRelay{db: db, instance: randomToken(8)}
+}
+
+// Send notifies the other processes in the background; a lost notification
+// only delays their waiters until they next recheck.
+func (p *PostgresRelay) Send(event string) {
+	payload, err := json.Marshal(relayMessage{Instance: p.instance, Event: event})
+	if err != nil {
+		log.Printf("Error encoding relay message: %v", err)
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), relaySendTimeout)
+		defer cancel()
+		if _, err := p.db.conn.ExecContext(ctx, "SELECT pg_notify($1, $2)", postsChannel, string(payload)); err != nil {
+			log.Printf("Error relaying post publication: %v", err)
+		}
+	}()
+}
+
+// Run passes publications from other processes to deliver until ctx is
+// cancelled, reconnecting with backoff when the connection drops.
+func (p *PostgresRelay) Run(ctx context.Context, deliver func(event string)) {
+	backoff := time.Second
+	for {
+		start := time.Now()
+		err := p.listen(ctx, deliver)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("Pub/sub relay disconnected: %v", err)
+
+		// A connection that lasted a while starts the backoff again
+		if time.Since(start) > relayMaxReconnect {
+			backoff = time.Second
+		}
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return
+		}
+		backoff = min(2*backoff, relayMaxReconnect)
+	}
+}
+
+// listen takes a connection from the pool and waits on it for
+// notifications until it fails or ctx is cancelled.
+func (p *PostgresRelay) listen(ctx context.Context, deliver func(event string)) error {
+	conn, err := p.db.pool.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer conn.Close()
+
+	var listenErr error
+	conn.Raw(func(driverConn any) error {
+		pgConn := driverConn.(*stdlib.Conn).Conn()
+		if _, err := pgConn.Exec(ctx, "LISTEN "+postsChannel); err != nil {
+			listenErr = fmt.Errorf("failed to listen: %w", err)
+			return driver.ErrBadConn
+		}
+		for {
+			notification, err := pgConn.WaitForNotification(ctx)
+			if err != nil {
+				listenErr = err
+				// Still listening, so keep it out of the pool
+				return driver.ErrBadConn
+			}
+			var msg relayMessage
+			if err := json.Unmarshal([]byte(notification.Payload), &msg); err != nil {
+				log.Printf("Error decoding relay message: %v", err)
+				continue
+			}
+			if msg.Instance != p.instance {
+				deliver(msg.Event)
+			}
+		}
+	})
+	return listenErr
+}
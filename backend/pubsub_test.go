@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+type fakeRelay struct {
+	sent []string
+}
+
+func (r *fakeRelay) Send(event string) {
+	r.sent = append(r.sent, event)
+}
+
+func TestNewPostRelay(t *testing.T) {
+	for _, kind := range []string{"", "none"} {
+		if relay, err := NewPostRelay(kind, nil); relay != nil || err != nil {
+			t.Errorf("NewPostRelay(%q) = %v, %v; want no relay", kind, relay, err)
+		}
+	}
+	if relay, err := NewPostRelay("postgres", nil); err != nil {
+		t.Errorf("NewPostRelay(postgres) error = %v", err)
+	} else if _, ok := relay.(*PostgresRelay); !ok {
+		t.Errorf("NewPostRelay(postgres) = %T, want *PostgresRelay", relay)
+	}
+	if _, err := NewPostRelay("redis", nil); err == nil {
+		t.Error("NewPostRelay(redis) succeeded, want an error")
+	}
+}
+
+func TestPostBrokerRelay(t *testing.T) {
+	relay := &fakeRelay{}
+	broker := NewPostBroker(relay)
+	woken, unsubscribe := broker.Subscribe("Glastonbury")
+	defer unsubscribe()
+
+	broker.Publish(Post{EventName: "Glastonbury"})
+
+	select {
+	case <-woken:
+	default:
+		t.Error("local waiter not woken")
+	}
+	if len(relay.sent) != 1 || relay.sent[0] != "Glastonbury" {
+		t.Errorf("relayed %v, want the post's event", relay.sent)
+	}
+}
+
+func TestPostgresRelay(t *testing.T) {
+	db := openTestDB(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Two relays on one database stand in for two replicas
+	sender, receiver := NewPostgresRelay(db), NewPostgresRelay(db)
+	delivered := make(chan string, 10)
+	go sender.Run(ctx, func(event string) { delivered <- "sender:" + event })
+	go receiver.Run(ctx, func(event string) { delivered <- "receiver:" + event })
+
+	// LISTEN happens in the background, so keep sending until heard
+	deadline := time.After(10 * time.Second)
+	for {
+		sender.Send("Relay Test")
+		select {
+		case got := <-delivered:
+			if got != "receiver:Relay Test" {
+				t.Fatalf("delivered %q, want only the other replica told", got)
+			}
+			return
+		case <-time.After(200 * time.Millisecond):
+		case <-deadline:
+			t.Fatal("publication not relayed")
+		}
+	}
+}
@@ -0,0 +1,671 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const rateEventJanitorInterval = 10 * time.Minute
+
+// Rate limiting algorithms
+const (
+	// rateSlidingLog keeps every request in the window and counts them.
+	// Exact, at the cost of a row per request.
+	rateSlidingLog = "sliding_log"
+	// rateSlidingWindow keeps a count per fixed window and weights the
+	// previous window's count by how much of it still overlaps. Close to
+	// exact, with two rows per key.
+	rateSlidingWindow = "sliding_window"
+	// rateTokenBucket allows Burst requests at once, refilling at Requests
+	// per window, so a couple of quick posts are fine but a steady stream
+	// isn't.
+	rateTokenBucket = "token_bucket"
+)
+
+// RateLimitPolicy configures a RateLimiter.
+type RateLimitPolicy struct {
+	Algorithm     string
+	Requests      int
+	WindowMinutes int
+	// Burst is the token bucket's size; it defaults to Requests. The other
+	// algorithms ignore it.
+	Burst int
+}
+
+// RateLimiter allows each key, such as a client's IP hash, a number of
+// requests over time. Each allowed request is reserved in the database;
+// the check and the reservation happen under a per-key lock, so parallel
+// requests can't all pass a check made before any of them was recorded.
+type RateLimiter struct {
+	db     *DB
+	name   string
+	policy RateLimitPolicy
+}
+
+func NewRateLimiter(db *DB, name string, policy RateLimitPolicy) (*RateLimiter, error) {
+	if policy.Algorithm == "" {
+		policy.Algorithm = rateSlidingLog
+	}
+	switch policy.Algorithm {
+	case rateSlidingLog, rateSlidingWindow, rateTokenBucket:
+	default:
+		return nil, fmt.Errorf("unknown rate limit algorithm %q (want %s, %s or %s)", policy.Algorithm, rateSlidingLog, rateSlidingWindow, rateTokenBucket)
+	}
+	if policy.Requests < 1 || policy.WindowMinutes < 1 {
+		return nil, fmt.Errorf("rate limit must allow at least 1 request per minute window")
+	}
+	if policy.Burst == 0 {
+		policy.Burst = policy.Requests
+	}
+	if policy.Burst < 1 {
+		return nil, fmt.Errorf("rate limit burst must be at least 1")
+	}
+
+	return &RateLimiter{db: db, name: name, policy: policy}, nil
+}
+
+func (rl *RateLimiter) window() time.Duration {
+	return time.Duration(rl.policy.WindowMinutes) * time.Minute
+}
+
+// rateReservation identifies one allowed request, so it can be given back.
+// Refused requests get one too, for its remaining and reset.
+type rateReservation struct {
+	key string
+	// remaining is how many more requests the key may make now
+	remaining int
+	// reset is how long until the limit allows another request, were the
+	// remaining ones all used now
+	reset time.Duration
+	// id is the sliding log's row
+	id int64
+	// windowStart is the sliding window counter's window
+	windowStart time.Time
+}
+
+// Reserve takes one of key's requests, reporting false if none are left.
+// The reservation can be given back with Release.
+func (rl *RateLimiter) Reserve(ctx context.Context, key string) (rateReservation, bool, error) {
+	switch rl.policy.Algorithm {
+	case rateSlidingWindow:
+		return rl.db.ReserveRateWindow(ctx, rl.name, key, rl.policy.Requests, rl.window())
+	case rateTokenBucket:
+		return rl.db.ReserveRateToken(ctx, rl.name, key, rl.policy.Requests, rl.policy.Burst, rl.window())
+	default:
+		return rl.db.ReserveRateEvent(ctx, rl.name, key, rl.policy.Requests, rl.window())
+	}
+}
+
+// Release gives back a reservation for a request that had no effect.
+func (rl *RateLimiter) Release(ctx context.Context, res rateReservation) {
+	var err error
+	switch rl.policy.Algorithm {
+	case rateSlidingWindow:
+		err = rl.db.ReleaseRateWindow(ctx, rl.name, res.key, res.windowStart)
+	case rateTokenBucket:
+		err = rl.db.ReleaseRateToken(ctx, rl.name, res.key, rl.policy.Burst)
+	default:
+		err = rl.db.DeleteRateEvent(ctx, res.id)
+	}
+	if err != nil {
+		log.Printf("Error releasing %s rate limit reservation: %v", rl.name, err)
+	}
+}
+
+// RateLimitStatus describes a limiter and, when Key is set, how many
+// requests the key has left.
+type RateLimitStatus struct {
+	Name          string `json:"name"`
+	Algorithm     string `json:"algorithm"`
+	Requests      int    `json:"requests"`
+	WindowMinutes int    `json:"window_minutes"`
+	Burst         int    `json:"burst"`
+	Key           string `json:"key,omitempty"`
+	Remaining     *int   `json:"remaining,omitempty"`
+	// Violations counts the key's recent requests over the limit, and
+	// CooldownSeconds how long it is still blocked for because of them
+	Violations      int `json:"violations,omitempty"`
+	CooldownSeconds int `json:"cooldown_seconds,omitempty"`
+}
+
+// Status reports the limiter's policy and, if key isn't empty, the
+// requests key has left, without reserving any.
+func (rl *RateLimiter) Status(ctx context.Context, key string) (RateLimitStatus, error) {
+	status := RateLimitStatus{
+		Name:          rl.name,
+		Algorithm:     rl.policy.Algorithm,
+		Requests:      rl.policy.Requests,
+		WindowMinutes: rl.policy.WindowMinutes,
+		Burst:         rl.policy.Burst,
+	}
+	if key == "" {
+		return status, nil
+	}
+
+	var available float64
+	switch rl.policy.Algorithm {
+	case rateSlidingWindow:
+		used, err := rl.db.GetRateWindowEstimate(ctx, rl.name, key, rl.window())
+		if err != nil {
+			return status, err
+		}
+		available = float64(rl.policy.Requests) - used
+	case rateTokenBucket:
+		tokens, err := rl.db.GetRateTokens(ctx, rl.name, key, rl.policy.Requests, rl.policy.Burst, rl.window())
+		if err != nil {
+			return status, err
+		}
+		available = tokens
+	default:
+		count, err := rl.db.CountRateEvents(ctx, rl.name, key, rl.window())
+		if err != nil {
+			return status, err
+		}
+		available = float64(rl.policy.Requests - count)
+	}
+
+	// A request needs a whole one free
+	remaining := max(int(math.Floor(available)), 0)
+	status.Key, status.Remaining = key, &remaining
+
+	violation, err := rl.db.GetRateViolation(ctx, rl.name, key)
+	if err != nil {
+		return status, err
+	}
+	if violation != nil {
+		status.Violations = violation.Strikes
+		status.CooldownSeconds = waitSeconds(violation.Cooldown)
+	}
+	return status, nil
+}
+
+// quota is the most requests a key can make at once.
+func (rl *RateLimiter) quota() int {
+	if rl.policy.Algorithm == rateTokenBucket {
+		return rl.policy.Burst
+	}
+	return rl.policy.Requests
+}
+
+// exceededMessage is the error shown once a key's requests are used up.
+func (rl *RateLimiter) exceededMessage() string {
+	if rl.policy.Algorithm == rateTokenBucket {
+		return fmt.Sprintf("Rate limit exceeded. Maximum %d posts at once, then %d per %d minutes.", rl.policy.Burst, rl.policy.Requests, rl.policy.WindowMinutes)
+	}
+	return fmt.Sprintf("Rate limit exceeded. Maximum %d posts per %d minutes.", rl.policy.Requests, rl.policy.WindowMinutes)
+}
+
+func (rl *RateLimiter) Limit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Only rate limit POST requests
+		if r.Method != http.MethodPost {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ip := getIP(r)
+		ipHash := hashIP(ip)
+
+		// A client that keeps going over the limit is held back for longer
+		violation, err := rl.db.GetRateViolation(r.Context(), rl.name, ipHash)
+		if err != nil {
+			log.Printf("Error checking rate limit violations: %v", err)
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+		if violation != nil && violation.Cooldown > 0 {
+			respondCoolingDown(w, violation.Cooldown)
+			return
+		}
+
+		reservation, ok, err := rl.Reserve(r.Context(), ipHash)
+		if err != nil {
+			log.Printf("Error checking rate limit: %v", err)
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+		setRateLimitHeaders(w, rl.quota(), reservation.remaining, reservation.reset)
+		if !ok {
+			cooldown, err := rl.db.RecordRateViolation(context.WithoutCancel(r.Context()), rl.name, ipHash)
+			if err != nil {
+				log.Printf("Error recording rate limit violation: %v", err)
+			}
+			respondRateLimited(w, "rate_limited", rl.exceededMessage(), max(reservation.reset, cooldown))
+			return
+		}
+
+		// Store IP hash in context for use in handlers
+		ctx := context.WithValue(r.Context(), ipHashKey, ipHash)
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		// Rejected requests don't count against the client, as they didn't
+		// when the limit counted posts
+		if rec.status >= 400 {
+			rl.Release(context.WithoutCancel(r.Context()), reservation)
+		}
+	})
+}
+
+// Run deletes state that no longer affects any decision every
+// rateEventJanitorInterval until ctx is cancelled.
+func (rl *RateLimiter) Run(ctx context.Context) {
+	ticker := time.NewTicker(rateEventJanitorInterval)
+	defer ticker.Stop()
+
+	for {
+		var err error
+		switch rl.policy.Algorithm {
+		case rateSlidingWindow:
+			// The previous window still counts, so keep two
+			_, err = rl.db.DeleteExpiredRateWindows(ctx, rl.name, 2*rl.window())
+		case rateTokenBucket:
+			_, err = rl.db.DeleteFullRateBuckets(ctx, rl.name, rl.policy.Requests, rl.policy.Burst, rl.window())
+		default:
+			_, err = rl.db.DeleteExpiredRateEvents(ctx, rl.name, rl.window())
+		}
+		if err != nil {
+			log.Printf("Error deleting expired %s rate limit state: %v", rl.name, err)
+		}
+		if _, err := rl.db.DeleteExpiredRateViolations(ctx, rl.name); err != nil {
+			log.Printf("Error deleting expired %s rate limit violations: %v", rl.name, err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// RateLimitedError is the body of a 429, telling clients how long to back
+// off for as well as in the Retry-After header.
+type RateLimitedError struct {
+	Error             string `json:"error"`
+	Code              string `json:"code"`
+	RetryAfterSeconds int    `json:"retry_after_seconds"`
+}
+
+// waitSeconds is d in whole seconds, rounded past d so a client waiting
+// that long isn't refused again for being exactly on time.
+func waitSeconds(d time.Duration) int {
+	if d <= 0 {
+		return 0
+	}
+	return int(d/time.Second) + 1
+}
+
+// setRateLimitHeaders sets the RateLimit-Limit, RateLimit-Remaining and
+// RateLimit-Reset fields from the IETF RateLimit header fields draft.
+func setRateLimitHeaders(w http.ResponseWriter, limit, remaining int, reset time.Duration) {
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(limit))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(remaining))
+	w.Header().Set("RateLimit-Reset", strconv.Itoa(waitSeconds(reset)))
+}
+
+// respondRateLimited writes a 429 asking the client to retry after
+// retryAfter, which is at least a second.
+func respondRateLimited(w http.ResponseWriter, code, message string, retryAfter time.Duration) {
+	seconds := max(waitSeconds(retryAfter), 1)
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	respondWithJSON(w, http.StatusTooManyRequests, RateLimitedError{Error: message, Code: code, RetryAfterSeconds: seconds})
+}
+
+// statusRecorder remembers the status a handler responded with.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// slidingWindowEstimate is the number of requests in the window ending at
+// now, assuming the previous fixed window's requests were spread evenly.
+func slidingWindowEstimate(previous, current int, sinceWindowStart, window time.Duration) float64 {
+	overlap := 1 - sinceWindowStart.Seconds()/window.Seconds()
+	return float64(previous)*overlap + float64(current)
+}
+
+// slidingWindowWait is how long until the sliding estimate drops under
+// limit, if no more requests are counted.
+func slidingWindowWait(previous, current, limit int, sinceWindowStart, window time.Duration) time.Duration {
+	if current >= limit {
+		// Only the next window can allow one, once this window's count
+		// has faded enough
+		fade := time.Duration(float64(window) * (1 - float64(limit)/float64(current)))
+		return window - sinceWindowStart + fade
+	}
+	if previous == 0 {
+		return 0
+	}
+	// The estimate is under limit once enough of previous has faded
+	under := time.Duration(float64(window) * (1 - float64(limit-current)/float64(previous)))
+	return max(under-sinceWindowStart, 0)
+}
+
+// refillTokens adds the tokens earned since the bucket was last updated,
+// at requests per window, up to burst.
+func refillTokens(tokens float64, elapsed time.Duration, requests, burst int, window time.Duration) float64 {
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	tokens += elapsed.Seconds() * float64(requests) / window.Seconds()
+	return math.Min(tokens, float64(burst))
+}
+
+// tokenWait is how long until a bucket holding tokens, with the whole ones
+// all taken, refills to a whole token again.
+func tokenWait(tokens float64, requests int, window time.Duration) time.Duration {
+	fraction := tokens - math.Floor(tokens)
+	return time.Duration((1 - fraction) * float64(window) / float64(requests))
+}
+
+// lockRateKey begins a transaction holding an advisory lock on the limiter
+// and key, which serializes concurrent reservations so a check can't go
+// stale before its write. It also returns the database's clock, so every
+// server measures windows the same way.
+func (db *DB) lockRateKey(ctx context.Context, limiter, key string) (*txn, time.Time, error) {
+	tx, err := db.begin(ctx)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("failed to begin rate limit reservation: %w", err)
+	}
+
+	var now time.Time
+	err = tx.QueryRowContext(ctx, "SELECT clock_timestamp() FROM (SELECT pg_advisory_xact_lock(hashtext($1 || ':' || $2))) AS l", limiter, key).Scan(&now)
+	if err != nil {
+		tx.Rollback()
+		return nil, time.Time{}, fmt.Errorf("failed to lock rate limit key: %w", err)
+	}
+	return tx, now, nil
+}
+
+// ReserveRateEvent records a request for key if fewer than limit were
+// recorded in the window.
+func (db *DB) ReserveRateEvent(ctx context.Context, limiter, key string, limit int, window time.Duration) (rateReservation, bool, error) {
+	res := rateReservation{key: key}
+
+	tx, now, err := db.lockRateKey(ctx, limiter, key)
+	if err != nil {
+		return res, false, err
+	}
+	defer tx.Rollback()
+
+	var count int
+	var oldest sql.NullTime
+	err = tx.QueryRowContext(ctx,
+		"SELECT COUNT(*), MIN(created_at) FROM rate_events WHERE limiter = $1 AND key = $2 AND created_at > $3",
+		limiter, key, now.Add(-window),
+	).Scan(&count, &oldest)
+	if err != nil {
+		return res, false, fmt.Errorf("failed to count rate limit reservations: %w", err)
+	}
+	// The next request is allowed once the oldest one leaves the window;
+	// with none yet, that's this one
+	if !oldest.Valid {
+		oldest.Time = now
+	}
+	res.reset = oldest.Time.Add(window).Sub(now)
+	if count >= limit {
+		return res, false, nil
+	}
+	res.remaining = limit - count - 1
+
+	err = tx.QueryRowContext(ctx,
+		"INSERT INTO rate_events (limiter, key, created_at) VALUES ($1, $2, $3) RETURNING id",
+		limiter, key, now,
+	).Scan(&res.id)
+	if err != nil {
+		return res, false, fmt.Errorf("failed to reserve rate limit: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return res, false, fmt.Errorf("failed to commit rate limit reservation: %w", err)
+	}
+	return res, true, nil
+}
+
+// CountRateEvents counts key's requests in the window.
+func (db *DB) CountRateEvents(ctx context.Context, limiter, key string, window time.Duration) (int, error) {
+	var count int
+	err := db.conn.QueryRowContext(ctx,
+		"SELECT COUNT(*) FROM rate_events WHERE limiter = $1 AND key = $2 AND created_at > clock_timestamp() - make_interval(secs => $3)",
+		limiter, key, window.Seconds(),
+	).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count rate limit reservations: %w", err)
+	}
+	return count, nil
+}
+
+func (db *DB) DeleteRateEvent(ctx context.Context, id int64) error {
+	_, err := db.conn.ExecContext(ctx, "DELETE FROM rate_events WHERE id = $1", id)
+	if err != nil {
+		return fmt.Errorf("failed to delete rate limit reservation: %w", err)
+	}
+	return nil
+}
+
+func (db *DB) DeleteExpiredRateEvents(ctx context.Context, limiter string, window time.Duration) (int64, error) {
+	result, err := db.conn.ExecContext(ctx,
+		"DELETE FROM rate_events WHERE limiter = $1 AND created_at <= NOW() - make_interval(secs => $2)",
+		limiter, window.Seconds(),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired rate limit reservations: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+// ReserveRateWindow counts a request in key's current fixed window if the
+// sliding estimate across it and the previous window is under limit.
+func (db *DB) ReserveRateWindow(ctx context.Context, limiter, key string, limit int, window time.Duration) (rateReservation, bool, error) {
+	res := rateReservation{key: key}
+
+	tx, now, err := db.lockRateKey(ctx, limiter, key)
+	if err != nil {
+		return res, false, err
+	}
+	defer tx.Rollback()
+
+	res.windowStart = now.Truncate(window)
+	previousStart := res.windowStart.Add(-window)
+
+	rows, err := tx.QueryContext(ctx,
+		"SELECT window_start, count FROM rate_counters WHERE limiter = $1 AND key = $2 AND window_start IN ($3, $4)",
+		limiter, key, previousStart, res.windowStart,
+	)
+	if err != nil {
+		return res, false, fmt.Errorf("failed to get rate limit counts: %w", err)
+	}
+	var previous, current int
+	for rows.Next() {
+		var start time.Time
+		var count int
+		if err := rows.Scan(&start, &count); err != nil {
+			rows.Close()
+			return res, false, fmt.Errorf("failed to scan rate limit count: %w", err)
+		}
+		if start.Equal(res.windowStart) {
+			current = count
+		} else {
+			previous = count
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return res, false, fmt.Errorf("failed to get rate limit counts: %w", err)
+	}
+
+	since := now.Sub(res.windowStart)
+	estimate := slidingWindowEstimate(previous, current, since, window)
+	if estimate >= float64(limit) {
+		res.reset = slidingWindowWait(previous, current, limit, since, window)
+		return res, false, nil
+	}
+	// Each request is allowed while the estimate before it is under limit
+	res.remaining = max(int(math.Ceil(float64(limit)-estimate-1)), 0)
+	res.reset = slidingWindowWait(previous, current+1+res.remaining, limit, since, window)
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO rate_counters (limiter, key, window_start, count) VALUES ($1, $2, $3, 1)
+		ON CONFLICT (limiter, key, window_start) DO UPDATE SET count = rate_counters.count + 1
+	`, limiter, key, res.windowStart)
+	if err != nil {
+		return res, false, fmt.Errorf("failed to reserve rate limit: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return res, false, fmt.Errorf("failed to commit rate limit reservation: %w", err)
+	}
+	return res, true, nil
+}
+
+// GetRateWindowEstimate is the sliding estimate of key's requests in the
+// window ending now.
+func (db *DB) GetRateWindowEstimate(ctx context.Context, limiter, key string, window time.Duration) (float64, error) {
+	var now time.Time
+	if err := db.conn.QueryRowContext(ctx, "SELECT clock_timestamp()").Scan(&now); err != nil {
+		return 0, fmt.Errorf("failed to get database time: %w", err)
+	}
+	windowStart := now.Truncate(window)
+
+	var previous, current int
+	err := db.conn.QueryRowContext(ctx, `
+		SELECT
+			COALESCE(SUM(count) FILTER (WHERE window_start = $3), 0),
+			COALESCE(SUM(count) FILTER (WHERE window_start = $4), 0)
+		FROM rate_counters
+		WHERE limiter = $1 AND key = $2
+	`, limiter, key, windowStart.Add(-window), windowStart).Scan(&previous, &current)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get rate limit counts: %w", err)
+	}
+	return slidingWindowEstimate(previous, current, now.Sub(windowStart), window), nil
+}
+
+func (db *DB) ReleaseRateWindow(ctx context.Context, limiter, key string, windowStart time.Time) error {
+	_, err := db.conn.ExecContext(ctx,
+		"UPDATE rate_counters SET count = count - 1 WHERE limiter = $1 AND key = $2 AND window_start = $3 AND count > 0",
+		limiter, key, windowStart,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to release rate limit reservation: %w", err)
+	}
+	return nil
+}
+
+func (db *DB) DeleteExpiredRateWindows(ctx context.Context, limiter string, age time.Duration) (int64, error) {
+	result, err := db.conn.ExecContext(ctx,
+		"DELETE FROM rate_counters WHERE limiter = $1 AND window_start <= NOW() - make_interval(secs => $2)",
+		limiter, age.Seconds(),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired rate limit counts: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+// ReserveRateToken takes a token from key's bucket, which holds up to burst
+// tokens and refills at requests per window. A key seen for the first time
+// starts with a full bucket.
+func (db *DB) ReserveRateToken(ctx context.Context, limiter, key string, requests, burst int, window time.Duration) (rateReservation, bool, error) {
+	res := rateReservation{key: key}
+
+	tx, now, err := db.lockRateKey(ctx, limiter, key)
+	if err != nil {
+		return res, false, err
+	}
+	defer tx.Rollback()
+
+	tokens := float64(burst)
+	var updatedAt time.Time
+	err = tx.QueryRowContext(ctx,
+		"SELECT tokens, updated_at FROM rate_buckets WHERE limiter = $1 AND key = $2",
+		limiter, key,
+	).Scan(&tokens, &updatedAt)
+	switch {
+	case err == sql.ErrNoRows:
+	case err != nil:
+		return res, false, fmt.Errorf("failed to get rate limit bucket: %w", err)
+	default:
+		tokens = refillTokens(tokens, now.Sub(updatedAt), requests, burst, window)
+	}
+
+	if tokens < 1 {
+		res.reset = tokenWait(tokens, requests, window)
+		return res, false, nil
+	}
+	res.remaining = int(math.Floor(tokens - 1))
+	res.reset = tokenWait(tokens-1, requests, window)
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO rate_buckets (limiter, key, tokens, updated_at) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (limiter, key) DO UPDATE SET tokens = EXCLUDED.tokens, updated_at = EXCLUDED.updated_at
+	`, limiter, key, tokens-1, now)
+	if err != nil {
+		return res, false, fmt.Errorf("failed to reserve rate limit: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return res, false, fmt.Errorf("failed to commit rate limit reservation: %w", err)
+	}
+	return res, true, nil
+}
+
+// GetRateTokens returns the tokens in key's bucket now, which is full if
+// the key has no bucket.
+func (db *DB) GetRateTokens(ctx context.Context, limiter, key string, requests, burst int, window time.Duration) (float64, error) {
+	var tokens float64
+	var updatedAt, now time.Time
+	err := db.conn.QueryRowContext(ctx,
+		"SELECT tokens, updated_at, clock_timestamp() FROM rate_buckets WHERE limiter = $1 AND key = $2",
+		limiter, key,
+	).Scan(&tokens, &updatedAt, &now)
+	if err == sql.ErrNoRows {
+		return float64(burst), nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to get rate limit bucket: %w", err)
+	}
+	return refillTokens(tokens, now.Sub(updatedAt), requests, burst, window), nil
+}
+
+func (db *DB) ReleaseRateToken(ctx context.Context, limiter, key string, burst int) error {
+	_, err := db.conn.ExecContext(ctx,
+		"UPDATE rate_buckets SET tokens = LEAST(tokens + 1, $3) WHERE limiter = $1 AND key = $2",
+		limiter, key, burst,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to release rate limit reservation: %w", err)
+	}
+	return nil
+}
+
+// DeleteFullRateBuckets deletes buckets that have refilled completely, which
+// behave the same as no bucket.
+func (db *DB) DeleteFullRateBuckets(ctx context.Context, limiter string, requests, burst int, window time.Duration) (int64, error) {
+	result, err := db.conn.ExecContext(ctx, `
+		DELETE FROM rate_buckets
+		WHERE limiter = $1
+		AND updated_at + make_interval(secs => ($2 - tokens) * $3 / $4) <= NOW()
+	`, limiter, burst, window.Seconds(), requests)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete full rate limit buckets: %w", err)
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+var rateAlgorithms = []string{rateSlidingLog, rateSlidingWindow, rateTokenBucket}
+
+func newTestRateLimiter(t *testing.T, db *DB, policy RateLimitPolicy) *RateLimiter {
+	t.Helper()
+	name := fmt.Sprintf("test-%d", time.Now().UnixNano())
+	limiter, err := NewRateLimiter(db, name, policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return limiter
+}
+
+// TestRateLimiterConcurrent sends many posts from one client at once. With a
+// check-then-insert limiter they all see a count under the limit; with
+// reservations exactly the limit get through.
+func TestRateLimiterConcurrent(t *testing.T) {
+	db := openTestDB(t)
+
+	const limit, clients = 5, 50
+	for _, algorithm := range rateAlgorithms {
+		t.Run(algorithm, func(t *testing.T) {
+			limiter := newTestRateLimiter(t, db, RateLimitPolicy{Algorithm: algorithm, Requests: limit, WindowMinutes: 60})
+
+			handler := limiter.Limit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				// Hold the request open so the others overlap it
+				time.Sleep(20 * time.Millisecond)
+				w.WriteHeader(http.StatusCreated)
+			}))
+
+			var (
+				wg    sync.WaitGroup
+				mu    sync.Mutex
+				codes = make(map[int]int)
+			)
+			for i := 0; i < clients; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					r := httptest.NewRequest(http.MethodPost, "/api/posts", nil)
+					r.Header.Set("X-Forwarded-For", "198.51.100.1")
+					rec := httptest.NewRecorder()
+					handler.ServeHTTP(rec, r)
+
+					mu.Lock()
+					codes[rec.Code]++
+					mu.Unlock()
+				}()
+			}
+			wg.Wait()
+
+			if codes[http.StatusCreated] != limit || codes[http.StatusTooManyRequests] != clients-limit {
+				t.Errorf("responses = %v, want %d created and %d rate limited", codes, limit, clients-limit)
+			}
+		})
+	}
+}
+
+// TestRateLimiterReleasesRejected checks that requests the handler rejects
+// don't use up the client's limit.
+func TestRateLimiterReleasesRejected(t *testing.T) {
+	db := openTestDB(t)
+
+	for _, algorithm := range rateAlgorithms {
+		t.Run(algorithm, func(t *testing.T) {
+			limiter := newTestRateLimiter(t, db, RateLimitPolicy{Algorithm: algorithm, Requests: 1, WindowMinutes: 60})
+
+			status := http.StatusBadRequest
+			handler := limiter.Limit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(status)
+			}))
+			post := func() int {
+				rec := httptest.NewRecorder()
+				handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/posts", nil))
+				return rec.Code
+			}
+
+			for i := 0; i < 3; i++ {
+				if code := post(); code != http.StatusBadRequest {
+					t.Fatalf("rejected post %d: status = %d, want 400", i, code)
+				}
+			}
+			status = http.StatusCreated
+			if code := post(); code != http.StatusCreated {
+				t.Fatalf("first valid post: status = %d, want 201", code)
+			}
+			if code := post(); code != http.StatusTooManyRequests {
+				t.Errorf("second valid post: status = %d, want 429", code)
+			}
+		})
+	}
+}
+
+// TestRateLimiterStatus checks Status counts down as requests are reserved,
+// and doesn't reserve any itself.
+func TestRateLimiterStatus(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+
+	for _, algorithm := range rateAlgorithms {
+		t.Run(algorithm, func(t *testing.T) {
+			limiter := newTestRateLimiter(t, db, RateLimitPolicy{Algorithm: algorithm, Requests: 3, WindowMinutes: 60})
+			for want := 3; want >= 0; want-- {
+				for i := 0; i < 2; i++ {
+					status, err := limiter.Status(ctx, "client")
+					if err != nil {
+						t.Fatal(err)
+					}
+					if status.Remaining == nil || *status.Remaining != want {
+						t.Fatalf("Remaining = %v, want %d", status.Remaining, want)
+					}
+				}
+				if _, _, err := limiter.Reserve(ctx, "client"); err != nil {
+					t.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestNewRateLimiter(t *testing.T) {
+	tests := []struct {
+		name      string
+		policy    RateLimitPolicy
+		wantErr   bool
+		wantBurst int
+	}{
+		{"defaults to sliding log", RateLimitPolicy{Requests: 5, WindowMinutes: 60}, false, 5},
+		{"burst defaults to requests", RateLimitPolicy{Algorithm: rateTokenBucket, Requests: 5, WindowMinutes: 60}, false, 5},
+		{"explicit burst", RateLimitPolicy{Algorithm: rateTokenBucket, Requests: 3, WindowMinutes: 60, Burst: 2}, false, 2},
+		{"unknown algorithm", RateLimitPolicy{Algorithm: "leaky", Requests: 5, WindowMinutes: 60}, true, 0},
+		{"no requests", RateLimitPolicy{Requests: 0, WindowMinutes: 60}, true, 0},
+		{"negative burst", RateLimitPolicy{Algorithm: rateTokenBucket, Requests: 5, WindowMinutes: 60, Burst: -1}, true, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter, err := NewRateLimiter(nil, "posts", tt.policy)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if limiter.policy.Burst != tt.wantBurst {
+				t.Errorf("burst = %d, want %d", limiter.policy.Burst, tt.wantBurst)
+			}
+		})
+	}
+}
+
+func TestSlidingWindowEstimate(t *testing.T) {
+	tests := []struct {
+		name              string
+		previous, current int
+		since             time.Duration
+		want              float64
+	}{
+		{"start of window counts all of previous", 4, 0, 0, 4},
+		{"halfway counts half of previous", 4, 1, 30 * time.Minute, 3},
+		{"end of window counts only current", 4, 2, 60 * time.Minute, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := slidingWindowEstimate(tt.previous, tt.current, tt.since, time.Hour)
+			if math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("estimate = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestRefillTokens checks the scenario the token bucket is for: with 3 posts
+// an hour and a burst of 2, two quick posts are fine, and the bucket refills
+// one post every 20 minutes.
+func TestRefillTokens(t *testing.T) {
+	tests := []struct {
+		name    string
+		tokens  float64
+		elapsed time.Duration
+		want    float64
+	}{
+		{"no time passed", 0, 0, 0},
+		{"one refill period", 0, 20 * time.Minute, 1},
+		{"partial refill", 0.5, 10 * time.Minute, 1},
+		{"capped at burst", 1, 5 * time.Hour, 2},
+		{"clock skew doesn't drain", 1, -time.Minute, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := refillTokens(tt.tokens, tt.elapsed, 3, 2, time.Hour)
+			if math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("tokens = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSlidingWindowWait(t *testing.T) {
+	tests := []struct {
+		name              string
+		previous, current int
+		since             time.Duration
+		want              time.Duration
+	}{
+		{"under the limit", 0, 3, 30 * time.Minute, 0},
+		{"previous fades under the limit", 6, 2, 15 * time.Minute, 15 * time.Minute},
+		{"full window waits for the next", 0, 5, 30 * time.Minute, 30 * time.Minute},
+		{"over-full window fades in the next", 0, 6, 30 * time.Minute, 40 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := slidingWindowWait(tt.previous, tt.current, 5, tt.since, time.Hour)
+			if (got - tt.want).Abs() > time.Millisecond {
+				t.Errorf("wait = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestTokenWait uses 3 posts an hour, one token every 20 minutes.
+func TestTokenWait(t *testing.T) {
+	for tokens, want := range map[float64]time.Duration{
+		0:    20 * time.Minute,
+		0.5:  10 * time.Minute,
+		1.25: 15 * time.Minute,
+	} {
+		if got := tokenWait(tokens, 3, time.Hour); (got - want).Abs() > time.Millisecond {
+			t.Errorf("tokenWait(%v) = %v, want %v", tokens, got, want)
+		}
+	}
+}
+
+// TestRateLimiterHeaders checks every limited request reports its quota,
+// and a refused one says how long to back off for.
+func TestRateLimiterHeaders(t *testing.T) {
+	db := openTestDB(t)
+
+	for _, algorithm := range rateAlgorithms {
+		t.Run(algorithm, func(t *testing.T) {
+			limiter := newTestRateLimiter(t, db, RateLimitPolicy{Algorithm: algorithm, Requests: 2, WindowMinutes: 60})
+			handler := limiter.Limit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusCreated)
+			}))
+
+			for i, want := range []string{"1", "0", "0"} {
+				rec := httptest.NewRecorder()
+				handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/posts", nil))
+				if got := rec.Header().Get("RateLimit-Limit"); got != "2" {
+					t.Errorf("request %d: RateLimit-Limit = %q, want 2", i, got)
+				}
+				if got := rec.Header().Get("RateLimit-Remaining"); got != want {
+					t.Errorf("request %d: RateLimit-Remaining = %q, want %s", i, got, want)
+				}
+				if reset := rec.Header().Get("RateLimit-Reset"); reset == "" || reset == "0" {
+					t.Errorf("request %d: RateLimit-Reset = %q, want a wait", i, reset)
+				}
+			}
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/posts", nil))
+			var body RateLimitedError
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if rec.Code != http.StatusTooManyRequests || body.Code != "rate_limited" || body.RetryAfterSeconds < 1 {
+				t.Fatalf("status %d, body %+v", rec.Code, body)
+			}
+			if got := rec.Header().Get("Retry-After"); got != strconv.Itoa(body.RetryAfterSeconds) {
+				t.Errorf("Retry-After = %q, want %d", got, body.RetryAfterSeconds)
+			}
+		})
+	}
+}
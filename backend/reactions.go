@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// ReactionResponse is the response to a heart.
+type ReactionResponse struct {
+	ReactionCount int `json:"reaction_count"`
+}
+
+// ReactToPost handles POST /api/posts/{id}/react, which hearts a post. Each
+// IP address can heart a post once; hearting it again changes nothing, so
+// clients can retry freely. The post's device is notified, as with
+// comments, unless the heart was sent with the same X-Device-Token.
+func (h *Handler) ReactToPost(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid post ID")
+		return
+	}
+
+	var tokenHash string
+	if r.Header.Get("X-Device-Token") != "" {
+		var ok bool
+		if tokenHash, ok = deviceToken(w, r); !ok {
+			return
+		}
+	}
+
+	count, found, err := h.db.ReactToPost(r.Context(), id, hashIP(getIP(r)), tokenHash)
+	if err != nil {
+		log.Printf("Error reacting to post: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to react to post")
+		return
+	}
+	if !found {
+		respondWithError(w, http.StatusNotFound, "Post not found")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, ReactionResponse{ReactionCount: count})
+}
+
+// ReactToPost records ipHash's heart on a visible post, if it hasn't one
+// already, and returns the post's count of hearts. A new heart is logged as
+// a post event and notifies the post's device unless it is reactorDevice.
+// It reports false if there is no such post.
+func (db *DB) ReactToPost(ctx context.Context, postID int, ipHash, reactorDevice string) (int, bool, error) {
+	var count int
+	err := db.conn.QueryRowContext(ctx, `
+		WITH post AS (
+			SELECT id, reaction_count FROM posts WHERE id = $1 AND hidden_at IS NULL
+		), reaction AS (
+			INSERT INTO reactions (post_id, ip_hash)
+			SELECT id, $2 FROM post
+			ON CONFLICT (post_id, ip_hash) DO NOTHING
+			RETURNING post_id
+		), counted AS (
+			UPDATE posts SET reaction_count = reaction_count + 1
+			FROM reaction
+			WHERE posts.id = reaction.post_id
+			RETURNING posts.id, posts.reaction_count, posts.device_token_hash
+		), recorded AS (
+			INSERT INTO post_events (post_id, type)
+			SELECT id, 'reacted' FROM counted
+		), notified AS (
+			INSERT INTO notifications (device_token_hash, kind, post_id)
+			SELECT device_token_hash, $4, id
+			FROM counted
+			WHERE device_token_hash IS NOT NULL AND device_token_hash <> $3
+		)
+		SELECT COALESCE((SELECT reaction_count FROM counted), post.reaction_count)
+		FROM post
+	`, postID, ipHash, reactorDevice, NotificationReaction).Scan(&count)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to react to post: %w", err)
+	}
+	return count, true, nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestReactToPostInvalidID(t *testing.T) {
+	h := newTestHandler(newFakeStore(), HandlerConfig{})
+	req := httptest.NewRequest(http.MethodPost, "/api/posts/abc/react", nil)
+	req.SetPathValue("id", "abc")
+	rec := httptest.NewRecorder()
+	h.ReactToPost(rec, req)
+	assertError(t, rec, http.StatusBadRequest, "Invalid post ID")
+}
+
+// TestReactToPost checks each IP hash hearts a post once.
+func TestReactToPost(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	event := fmt.Sprintf("Reactions Test %d", time.Now().UnixNano())
+
+	post, err := db.CreatePost(ctx, CreatePostRequest{EventName: event, Content: "hello", Age: 25, Location: "x"}, "reactions-test", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, ipHash := range []string{"reader-a", "reader-a", "reader-b"} {
+		want := []int{1, 1, 2}[i]
+		count, found, err := db.ReactToPost(ctx, post.ID, ipHash, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !found || count != want {
+			t.Errorf("heart %d from %s = %d, %v; want %d", i+1, ipHash, count, found, want)
+		}
+	}
+
+	got, err := db.GetPostByID(ctx, post.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ReactionCount != 2 {
+		t.Errorf("ReactionCount = %d, want 2", got.ReactionCount)
+	}
+
+	if _, found, err := db.ReactToPost(ctx, 0, "reader-a", ""); err != nil || found {
+		t.Errorf("heart on a missing post = %v, %v; want not found", found, err)
+	}
+}
+
+// TestReactKeepsVersion checks that a heart between an author fetching
+// their post and editing it doesn't fail their If-Match.
+func TestReactKeepsVersion(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	event := fmt.Sprintf("Reaction Version Test %d", time.Now().UnixNano())
+
+	post, err := db.CreatePost(ctx, CreatePostRequest{EventName: event, Content: "hello", Age: 25, Location: "x"}, "reaction-version-test", hashToken("token"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fetched, err := db.GetPostByID(ctx, post.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := db.ReactToPost(ctx, post.ID, "reader-a", ""); err != nil {
+		t.Fatal(err)
+	}
+	edited, err := db.EditPost(ctx, post.ID, hashToken("token"), "edited", []int{fetched.Version}, 0)
+	if err != nil {
+		t.Fatalf("EditPost at the fetched version after a heart = %v, want it applied", err)
+	}
+	if edited.Version != fetched.Version+1 {
+		t.Errorf("version after edit = %d, want %d", edited.Version, fetched.Version+1)
+	}
+}
+
+// TestReactToPostNotifies checks a heart is logged as a post event and
+// counted on the poster's badge, unless it comes from their own device.
+func TestReactToPostNotifies(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	device := hashToken(fmt.Sprintf("reactions-notify-test-%d", time.Now().UnixNano()))
+
+	post, err := db.CreatePost(ctx, CreatePostRequest{EventName: "Reactions Notify Test", Content: "hello", Age: 25, Location: "x", DeviceTokenHash: device}, "reactions-notify-test", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := db.ReactToPost(ctx, post.ID, "poster", device); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := db.ReactToPost(ctx, post.ID, "reader-a", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	counts, err := db.GetDeviceCounts(ctx, device)
+	if err != nil || counts.Reactions != 1 || counts.Replies != 0 {
+		t.Errorf("GetDeviceCounts = %+v, %v; want 1 reaction, not the poster's own", counts, err)
+	}
+	notifications, err := db.GetNotifications(ctx, device, true, 50, 0)
+	if err != nil || len(notifications) != 1 || notifications[0].Kind != NotificationReaction {
+		t.Errorf("GetNotifications = %+v, %v; want one reaction", notifications, err)
+	}
+
+	events, err := db.GetPostEvents(ctx, post.ID, 50, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reacted := 0
+	for _, e := range events {
+		if e.Type == "reacted" {
+			reacted++
+		}
+	}
+	if reacted != 2 {
+		t.Errorf("%d reacted events, want 2", reacted)
+	}
+}
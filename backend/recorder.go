@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// maxRecordedBody caps each body the recorder keeps.
+const maxRecordedBody = 4 << 10
+
+// Request headers whose values are replaced before recording
+var recordedSecretHeaders = map[string]bool{
+	"Authorization":         true,
+	"Cookie":                true,
+	"X-Api-Key":             true,
+	"X-Device-Token":        true,
+	"X-Signature":           true,
+	"X-Signature-Nonce":     true,
+	"X-Signature-Timestamp": true,
+}
+
+// Query parameters whose values are replaced before recording, such as
+// media URL signatures and post status tokens
+var recordedSecretParams = regexp.MustCompile(`(?i)token|sig|key|secret|password`)
+
+const redacted = "[redacted]"
+
+// RecordedRequest is one request seen by the flight recorder. It never
+// holds the client's IP or credentials; bodies are only kept for failed
+// requests, and only when body capture is on.
+type RecordedRequest struct {
+	Time          time.Time         `json:"time"`
+	Method        string            `json:"method"`
+	Path          string            `json:"path"`
+	Query         string            `json:"query,omitempty"`
+	Headers       map[string]string `json:"headers"`
+	IPHash        string            `json:"ip_hash"`
+	Status        int               `json:"status"`
+	DurationMs    float64           `json:"duration_ms"`
+	ResponseBytes int               `json:"response_bytes"`
+	RequestBody   string            `json:"request_body,omitempty"`
+	ResponseBody  string            `json:"response_body,omitempty"`
+	// Truncated is set when a body was cut at maxRecordedBody
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// RecorderSettings are toggled by admins at runtime.
+type RecorderSettings struct {
+	Enabled bool `json:"enabled"`
+	// CaptureBodies keeps the bodies of requests that failed with a 4xx or
+	// 5xx status
+	CaptureBodies bool `json:"capture_bodies"`
+}
+
+// RecorderState is the recorder's settings and what it holds.
+type RecorderState struct {
+	RecorderSettings
+	Size     int               `json:"size"`
+	Requests []RecordedRequest `json:"requests"`
+}
+
+// Recorder keeps the last requests in a ring buffer, in memory, so an
+// intermittent production issue can be debugged after the fact without
+// logging every request. Each server process records its own requests.
+type Recorder struct {
+	size int
+
+	mu       sync.Mutex
+	settings RecorderSettings
+	entries  []RecordedRequest
+	// next is where the next entry goes once the buffer is full
+	next int
+}
+
+func NewRecorder(size int, settings RecorderSettings) *Recorder {
+	return &Recorder{size: size, settings: settings, entries: make([]RecordedRequest, 0, size)}
+}
+
+func (rec *Recorder) Settings() RecorderSettings {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return rec.settings
+}
+
+func (rec *Recorder) SetSettings(settings RecorderSettings) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.settings = settings
+}
+
+// add records a request, replacing the oldest once the buffer is full.
+func (rec *Recorder) add(entry RecordedRequest) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if rec.size == 0 {
+		return
+	}
+	if len(rec.entries) < rec.size {
+		rec.entries = append(rec.entries, entry)
+		return
+	}
+	rec.entries[rec.next] = entry
+	rec.next = (rec.next + 1) % len(rec.entries)
+}
+
+// Requests returns the recorded requests, newest first. With failedOnly
+// set only those with a 4xx or 5xx status are returned.
+func (rec *Recorder) Requests(failedOnly bool) []RecordedRequest {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	requests := []RecordedRequest{}
+	for i := len(rec.entries) - 1; i >= 0; i-- {
+		entry := rec.entries[(rec.next+i)%len(rec.entries)]
+		if failedOnly && entry.Status < 400 {
+			continue
+		}
+		requests = append(requests, entry)
+	}
+	return requests
+}
+
+// Clear forgets every recorded request.
+func (rec *Recorder) Clear() {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.entries = rec.entries[:0]
+	rec.next = 0
+}
+
+// cappedBuffer keeps the first maxRecordedBody bytes written to it.
+type cappedBuffer struct {
+	bytes.Buffer
+	truncated bool
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if room := maxRecordedBody - b.Len(); len(p) > room {
+		b.Buffer.Write(p[:room])
+		b.truncated = true
+		return len(p), nil
+	}
+	return b.Buffer.Write(p)
+}
+
+// recordingWriter remembers a response's status and size, and its body
+// when one is being captured.
+type recordingWriter struct {
+	http.ResponseWriter
+	status int
+	size   int
+	body   *cappedBuffer
+}
+
+func (w *recordingWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *recordingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *recordingWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.size += n
+	if w.body != nil {
+		w.body.Write(p[:n])
+	}
+	return n, err
+}
+
+// sanitizeHeaders copies the request's headers, redacting credentials.
+func sanitizeHeaders(header http.Header) map[string]string {
+	headers := make(map[string]string, len(header))
+	for name, values := range header {
+		if recordedSecretHeaders[name] {
+			headers[name] = redacted
+			continue
+		}
+		if len(values) > 0 {
+			headers[name] = values[0]
+		}
+	}
+	return headers
+}
+
+// sanitizeQuery redacts the values of secret-looking query parameters.
+func sanitizeQuery(rawQuery string) string {
+	if rawQuery == "" {
+		return ""
+	}
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return redacted
+	}
+	for name := range query {
+		if recordedSecretParams.MatchString(name) {
+			query[name] = []string{redacted}
+		}
+	}
+	return query.Encode()
+}
+
+// Middleware records requests while the recorder is enabled, except health
+// checks and requests for the recorder itself.
+func (rec *Recorder) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		settings := rec.Settings()
+		if !settings.Enabled || r.URL.Path == "/health" || r.URL.Path == "/admin/recorder" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		entry := RecordedRequest{
+			Time:    start.UTC(),
+			Method:  r.Method,
+			Path:    r.URL.Path,
+			Query:   sanitizeQuery(r.URL.RawQuery),
+			Headers: sanitizeHeaders(r.Header),
+			IPHash:  hashIP(getIP(r)),
+		}
+
+		rw := &recordingWriter{ResponseWriter: w, status: http.StatusOK}
+		var requestBody *cappedBuffer
+		if settings.CaptureBodies {
+			requestBody = &cappedBuffer{}
+			rw.body = &cappedBuffer{}
+			r.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.TeeReader(r.Body, requestBody), r.Body}
+		}
+
+		next.ServeHTTP(rw, r)
+
+		entry.Status = rw.status
+		entry.DurationMs = float64(time.Since(start).Microseconds()) / 1000
+		entry.ResponseBytes = rw.size
+		if settings.CaptureBodies && rw.status >= 400 {
+			entry.RequestBody = requestBody.String()
+			entry.ResponseBody = rw.body.String()
+			entry.Truncated = requestBody.truncated || rw.body.truncated
+		}
+		rec.add(entry)
+	})
+}
+
+// GetRecorder handles GET /admin/recorder. Pass ?failed=true for only
+// failed requests.
+func (h *Handler) GetRecorder(w http.ResponseWriter, r *http.Request) {
+	rec := h.cfg.Recorder
+	respondWithJSON(w, http.StatusOK, RecorderState{
+		RecorderSettings: rec.Settings(),
+		Size:             rec.size,
+		Requests:         rec.Requests(r.URL.Query().Get("failed") == "true"),
+	})
+}
+
+// UpdateRecorder handles PUT /admin/recorder, turning recording and body
+// capture on or off.
+func (h *Handler) UpdateRecorder(w http.ResponseWriter, r *http.Request) {
+	var req RecorderSettings
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	h.cfg.Recorder.SetSettings(req)
+	h.audit(r, "recorder.update", "", "", req)
+
+	respondWithJSON(w, http.StatusOK, req)
+}
+
+// ClearRecorder handles DELETE /admin/recorder
+func (h *Handler) ClearRecorder(w http.ResponseWriter, r *http.Request) {
+	h.cfg.Recorder.Clear()
+	h.audit(r, "recorder.clear", "", "", nil)
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRecorderRing(t *testing.T) {
+	rec := NewRecorder(3, RecorderSettings{Enabled: true})
+	for i := 1; i <= 5; i++ {
+		rec.add(RecordedRequest{Path: fmt.Sprintf("/%d", i), Status: 200 + 200*(i%2)})
+	}
+
+	var paths []string
+	for _, entry := range rec.Requests(false) {
+		paths = append(paths, entry.Path)
+	}
+	if got := strings.Join(paths, " "); got != "/5 /4 /3" {
+		t.Errorf("Requests = %s, want the newest three, newest first", got)
+	}
+	if failed := rec.Requests(true); len(failed) != 2 || failed[0].Path != "/5" || failed[1].Path != "/3" {
+		t.Errorf("failed Requests = %+v", failed)
+	}
+
+	rec.Clear()
+	if got := rec.Requests(false); len(got) != 0 {
+		t.Errorf("Requests after Clear = %+v", got)
+	}
+}
+
+func TestRecorderMiddleware(t *testing.T) {
+	rec := NewRecorder(10, RecorderSettings{})
+	handler := rec.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.ReadAll(r.Body)
+		if r.URL.Path == "/fail" {
+			respondWithError(w, http.StatusBadRequest, "content is required")
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	send := func(path string) {
+		r := httptest.NewRequest("POST", path+"?event=x&sig=abc", strings.NewReader(`{"content": ""}`))
+		r.Header.Set("Authorization", "Bearer secret")
+		r.Header.Set("X-Forwarded-For", "203.0.113.9")
+		handler.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	send("/off")
+	if got := rec.Requests(false); len(got) != 0 {
+		t.Fatalf("recorded %d requests while disabled", len(got))
+	}
+
+	rec.SetSettings(RecorderSettings{Enabled: true})
+	send("/ok")
+	send("/fail")
+	rec.SetSettings(RecorderSettings{Enabled: true, CaptureBodies: true})
+	send("/ok")
+	send("/fail")
+
+	got := rec.Requests(false)
+	if len(got) != 4 {
+		t.Fatalf("recorded %d requests, want 4", len(got))
+	}
+	for _, entry := range got {
+		if entry.Headers["Authorization"] != redacted || strings.Contains(entry.Query, "abc") || !strings.Contains(entry.Query, "event=x") {
+			t.Errorf("%s: headers %v, query %q not sanitized", entry.Path, entry.Headers, entry.Query)
+		}
+		if entry.IPHash != hashIP("203.0.113.9") {
+			t.Errorf("%s: ip_hash %q", entry.Path, entry.IPHash)
+		}
+	}
+
+	// Newest first: only the failed request with capture on keeps bodies
+	if got[0].Status != 400 || got[0].RequestBody != `{"content": ""}` || !strings.Contains(got[0].ResponseBody, "content is required") {
+		t.Errorf("captured failure = %+v", got[0])
+	}
+	if got[1].Status != 200 || got[1].RequestBody != "" || got[1].ResponseBody != "" || got[1].ResponseBytes != 2 {
+		t.Errorf("captured success = %+v", got[1])
+	}
+	if got[2].RequestBody != "" || got[2].ResponseBody != "" {
+		t.Errorf("failure without capture = %+v", got[2])
+	}
+}
+
+func TestCappedBuffer(t *testing.T) {
+	var b cappedBuffer
+	b.Write([]byte(strings.Repeat("a", maxRecordedBody-1)))
+	if n, err := b.Write([]byte("bcd")); n != 3 || err != nil {
+		t.Errorf("Write = %d, %v", n, err)
+	}
+	if b.Len() != maxRecordedBody || !b.truncated {
+		t.Errorf("len %d, truncated %v", b.Len(), b.truncated)
+	}
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// postRemovedCode is the error code of a hidden post fetched by its author.
+const postRemovedCode = "post_removed"
+
+// defaultRemovalMessage is shown to authors of posts hidden without a reason.
+const defaultRemovalMessage = "Your post was removed by a moderator."
+
+var removalReasonCodePattern = regexp.MustCompile(`^[a-z0-9_]{1,50}$`)
+
+// RemovalReason is a moderator-written explanation, shown to the author of
+// a hidden post. Its code is fixed once created so clients can rely on it.
+type RemovalReason struct {
+	ID        int       `json:"id"`
+	Code      string    `json:"code"`
+	Message   string    `json:"message"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type CreateRemovalReasonRequest struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type UpdateRemovalReasonRequest struct {
+	Message string `json:"message"`
+}
+
+func validateRemovalMessage(message string) error {
+	if message == "" {
+		return &ValidationError{Message: "message is required"}
+	}
+	if len(message) > 1000 {
+		return &ValidationError{Message: "message must be 1000 characters or less"}
+	}
+	return nil
+}
+
+// GetPost handles GET /api/posts/{id}. A hidden post is a 404, except to
+// its author, identified by the X-Device-Token it was posted with, who gets
+// a 410 saying why it was removed.
+func (h *Handler) GetPost(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid post ID")
+		return
+	}
+
+	post, err := h.db.GetPostByID(r.Context(), id)
+	if err != nil {
+		log.Printf("Error getting post: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve post")
+		return
+	}
+	if post == nil {
+		respondWithError(w, http.StatusNotFound, "Post not found")
+		return
+	}
+
+	if post.HiddenAt != nil {
+		var reason *RemovalReason
+		if token := r.Header.Get("X-Device-Token"); token != "" {
+			reason, err = h.db.GetRemovalNotice(r.Context(), id, hashToken(token))
+			if err != nil {
+				log.Printf("Error getting removal notice: %v", err)
+				respondWithError(w, http.StatusInternalServerError, "Failed to retrieve post")
+				return
+			}
+		}
+		if reason == nil {
+			respondWithError(w, http.StatusNotFound, "Post not found")
+			return
+		}
+		respondWithJSON(w, http.StatusGone, map[string]string{
+			"error":  reason.Message,
+			"code":   postRemovedCode,
+			"reason": reason.Code,
+		})
+		return
+	}
+
+	// Redacted and localized as in the feed
+	posts := []Post{*post}
+	if err := h.presentPosts(r.Context(), posts, nil); err != nil {
+		log.Printf("Error presenting post: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve post")
+		return
+	}
+
+	w.Header().Set("ETag", versionETag(post.Version))
+	respondWithJSON(w, http.StatusOK, posts[0])
+}
+
+// GetRemovalReasons handles GET /admin/removal-reasons
+func (h *Handler) GetRemovalReasons(w http.ResponseWriter, r *http.Request) {
+	reasons, err := h.db.GetRemovalReasons(r.Context())
+	if err != nil {
+		log.Printf("Error getting removal reasons: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve removal reasons")
+		return
+	}
+
+	if reasons == nil {
+		reasons = []RemovalReason{}
+	}
+
+	respondWithJSON(w, http.StatusOK, reasons)
+}
+
+// CreateRemovalReason handles POST /admin/removal-reasons
+func (h *Handler) CreateRemovalReason(w http.ResponseWriter, r *http.Request) {
+	var req CreateRemovalReasonRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	req.Message = strings.TrimSpace(req.Message)
+
+	if !removalReasonCodePattern.MatchString(req.Code) {
+		respondWithError(w, http.StatusBadRequest, "code must be 1 to 50 lowercase letters, digits or underscores")
+		return
+	}
+	if err := validateRemovalMessage(req.Message); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	reason, err := h.db.CreateRemovalReason(r.Context(), req)
+	if err != nil {
+		log.Printf("Error creating removal reason: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to create removal reason")
+		return
+	}
+	if reason == nil {
+		respondWithError(w, http.StatusConflict, "A removal reason with that code already exists")
+		return
+	}
+
+	h.audit(r, "removal_reason.create", "removal_reason", strconv.Itoa(reason.ID), reason)
+
+	respondWithJSON(w, http.StatusCreated, reason)
+}
+
+// UpdateRemovalReason handles PUT /admin/removal-reasons/{id}. Only the
+// message can change; posts already hidden with the reason show the new one.
+func (h *Handler) UpdateRemovalReason(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid removal reason ID")
+		return
+	}
+
+	var req UpdateRemovalReasonRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	req.Message = strings.TrimSpace(req.Message)
+	if err := validateRemovalMessage(req.Message); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	reason, err := h.db.UpdateRemovalReason(r.Context(), id, req.Message)
+	if err != nil {
+		log.Printf("Error updating removal reason: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to update removal reason")
+		return
+	}
+	if reason == nil {
+		respondWithError(w, http.StatusNotFound, "Removal reason not found")
+		return
+	}
+
+	h.audit(r, "removal_reason.update", "removal_reason", strconv.Itoa(id), req)
+
+	respondWithJSON(w, http.StatusOK, reason)
+}
+
+// ArchiveRemovalReason handles DELETE /admin/removal-reasons/{id}. Reasons
+// are archived rather than deleted so hidden posts keep their explanation.
+func (h *Handler) ArchiveRemovalReason(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid removal reason ID")
+		return
+	}
+
+	found, err := h.db.ArchiveRemovalReason(r.Context(), id)
+	if err != nil {
+		log.Printf("Error archiving removal reason: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to delete removal reason")
+		return
+	}
+	if !found {
+		respondWithError(w, http.StatusNotFound, "Removal reason not found")
+		return
+	}
+
+	h.audit(r, "removal_reason.archive", "removal_reason", strconv.Itoa(id), nil)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+const removalReasonColumns = `id, code, message, created_at, updated_at`
+
+func scanRemovalReason(row rowScanner) (*RemovalReason, error) {
+	var reason RemovalReason
+	if err := row.Scan(&reason.ID, &reason.Code, &reason.Message, &reason.CreatedAt, &reason.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return &reason, nil
+}
+
+// GetRemovalReasons lists the active removal reasons by code.
+func (db *DB) GetRemovalReasons(ctx context.Context) ([]RemovalReason, error) {
+	rows, err := db.conn.QueryContext(ctx, `
+		SELECT `+removalReasonColumns+`
+		FROM removal_reasons
+		WHERE archived_at IS NULL
+		ORDER BY code
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query removal reasons: %w", err)
+	}
+	defer rows.Close()
+
+	var reasons []RemovalReason
+	for rows.Next() {
+		reason, err := scanRemovalReason(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan removal reason: %w", err)
+		}
+		reasons = append(reasons, *reason)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating removal reasons: %w", err)
+	}
+
+	return reasons, nil
+}
+
+// GetRemovalReason retrieves an active removal reason, returning nil if
+// there is none with that ID.
+func (db *DB) GetRemovalReason(ctx context.Context, id int) (*RemovalReason, error) {
+	query := `SELECT ` + removalReasonColumns + ` FROM removal_reasons WHERE id = $1 AND archived_at IS NULL`
+
+	reason, err := scanRemovalReason(db.conn.QueryRowContext(ctx, query, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get removal reason: %w", err)
+	}
+	return reason, nil
+}
+
+// CreateRemovalReason adds a removal reason, returning nil if its code is
+// taken, including by an archived reason.
+func (db *DB) CreateRemovalReason(ctx context.Context, req CreateRemovalReasonRequest) (*RemovalReason, error) {
+	query := `
+		INSERT INTO removal_reasons (code, message)
+		VALUES ($1, $2)
+		ON CONFLICT (code) DO NOTHING
+		RETURNING ` + removalReasonColumns
+
+	reason, err := scanRemovalReason(db.conn.QueryRowContext(ctx, query, req.Code, req.Message))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create removal reason: %w", err)
+	}
+	return reason, nil
+}
+
+// UpdateRemovalReason changes an active reason's message, returning nil if
+// there is none with that ID.
+func (db *DB) UpdateRemovalReason(ctx context.Context, id int, message string) (*RemovalReason, error) {
+	query := `
+		UPDATE removal_reasons
+		SET message = $2, updated_at = NOW()
+		WHERE id = $1 AND archived_at IS NULL
+		RETURNING ` + removalReasonColumns
+
+	reason, err := scanRemovalReason(db.conn.QueryRowContext(ctx, query, id, message))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to update removal reason: %w", err)
+	}
+	return reason, nil
+}
+
+// ArchiveRemovalReason stops a reason being used for new removals,
+// reporting whether an active one existed.
+func (db *DB) ArchiveRemovalReason(ctx context.Context, id int) (bool, error) {
+	result, err := db.conn.ExecContext(ctx,
+		"UPDATE removal_reasons SET archived_at = NOW() WHERE id = $1 AND archived_at IS NULL",
+		id,
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to archive removal reason: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to archive removal reason: %w", err)
+	}
+
+	return affected > 0, nil
+}
+
+// GetRemovalNotice returns why a hidden post was removed, if deviceTokenHash
+// is its author's, or nil otherwise. Posts hidden without a reason get a
+// generic one.
+func (db *DB) GetRemovalNotice(ctx context.Context, postID int, deviceTokenHash string) (*RemovalReason, error) {
+	var reason RemovalReason
+	err := db.conn.QueryRowContext(ctx, `
+		SELECT COALESCE(r.code, 'removed'), COALESCE(r.message, $3)
+		FROM posts p
+		LEFT JOIN removal_reasons r ON r.id = p.removal_reason_id
+		WHERE p.id = $1 AND p.device_token_hash = $2 AND p.hidden_at IS NOT NULL
+	`, postID, deviceTokenHash, defaultRemovalMessage).Scan(&reason.Code, &reason.Message)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get removal notice: %w", err)
+	}
+	return &reason, nil
+}
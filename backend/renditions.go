@@ -0,0 +1,320 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	renditionInterval    = 10 * time.Second
+	renditionBatchSize   = 20
+	renditionJPEGQuality = 80
+)
+
+// renditionSizes are the renditions made of each image, smallest first,
+// named with the longest side they are scaled to fit. Images already that
+// small don't get the rendition.
+var renditionSizes = []struct {
+	name    string
+	maxSide int
+}{
+	{"thumb", 320},
+	{"medium", 1024},
+}
+
+// Rendition is a scaled-down copy of an attachment.
+type Rendition struct {
+	Name        string `json:"name"`
+	URL         string `json:"url"`
+	ContentType string `json:"content_type"`
+	Width       int    `json:"width"`
+	Height      int    `json:"height"`
+	Size        int    `json:"size"`
+
+	attachmentID int
+	storageKey   string
+}
+
+// fitWithin scales a size down so its longest side is maxSide, reporting
+// false if it already fits.
+func fitWithin(width, height, maxSide int) (int, int, bool) {
+	if width <= maxSide && height <= maxSide {
+		return width, height, false
+	}
+	if width >= height {
+		return maxSide, max(1, height*maxSide/width), true
+	}
+	return max(1, width*maxSide/height), maxSide, true
+}
+
+// resizeImage scales src down to width by height, averaging the source
+// pixels each destination pixel covers.
+func resizeImage(src image.Image, width, height int) *image.RGBA {
+	rgba, ok := src.(*image.RGBA)
+	if !ok {
+		rgba = image.NewRGBA(src.Bounds())
+		draw.Draw(rgba, rgba.Bounds(), src, src.Bounds().Min, draw.Src)
+	}
+
+	b := rgba.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0, y1 := b.Min.Y+y*sh/height, b.Min.Y+(y+1)*sh/height
+		if y1 == y0 {
+			y1++
+		}
+		for x := 0; x < width; x++ {
+			x0, x1 := b.Min.X+x*sw/width, b.Min.X+(x+1)*sw/width
+			if x1 == x0 {
+				x1++
+			}
+
+			var sum [4]int
+			for sy := y0; sy < y1; sy++ {
+				i := rgba.PixOffset(x0, sy)
+				for sx := x0; sx < x1; sx++ {
+					sum[0] += int(rgba.Pix[i])
+					sum[1] += int(rgba.Pix[i+1])
+					sum[2] += int(rgba.Pix[i+2])
+					sum[3] += int(rgba.Pix[i+3])
+					i += 4
+				}
+			}
+			n := (y1 - y0) * (x1 - x0)
+			j := dst.PixOffset(x, y)
+			for c := range sum {
+				dst.Pix[j+c] = uint8(sum[c] / n)
+			}
+		}
+	}
+	return dst
+}
+
+// encodeRendition encodes a rendition as JPEG for JPEG originals and PNG
+// otherwise, keeping transparency. Animated GIFs become their first frame.
+func encodeRendition(img image.Image, originalType string) ([]byte, string, string, error) {
+	var buf bytes.Buffer
+	if originalType == "image/jpeg" {
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: renditionJPEGQuality}); err != nil {
+			return nil, "", "", err
+		}
+		return buf.Bytes(), "image/jpeg", ".jpg", nil
+	}
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, "", "", err
+	}
+	return buf.Bytes(), "image/png", ".png", nil
+}
+
+// srcset builds an HTML srcset from an attachment's renditions and the
+// original, or "" if it has no renditions.
+func srcset(a Attachment) string {
+	if len(a.Renditions) == 0 {
+		return ""
+	}
+	candidates := make([]string, 0, len(a.Renditions)+1)
+	for _, r := range a.Renditions {
+		candidates = append(candidates, r.URL+" "+strconv.Itoa(r.Width)+"w")
+	}
+	candidates = append(candidates, a.URL+" "+strconv.Itoa(a.Width)+"w")
+	return strings.Join(candidates, ", ")
+}
+
+// RenditionJob makes renditions of new uploads, and of those made before
+// renditions existed, once they have passed any virus scan.
+type RenditionJob struct {
+	db    *DB
+	media MediaStore
+}
+
+func NewRenditionJob(db *DB, media MediaStore) *RenditionJob {
+	return &RenditionJob{db: db, media: media}
+}
+
+// Run works through pending attachments, checking for more every
+// renditionInterval, until ctx is cancelled.
+func (j *RenditionJob) Run(ctx context.Context) {
+	ticker := time.NewTicker(renditionInterval)
+	defer ticker.Stop()
+
+	for {
+		j.processPending(ctx)
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// processPending renders every attachment waiting for renditions.
+func (j *RenditionJob) processPending(ctx context.Context) {
+	for j.processBatch(ctx) {
+	}
+}
+
+// processBatch handles one batch, reporting whether there may be more.
+func (j *RenditionJob) processBatch(ctx context.Context) bool {
+	batch, err := j.db.GetAttachmentsWithoutRenditions(ctx, renditionBatchSize)
+	if err != nil {
+		log.Printf("Error listing attachments for renditions: %v", err)
+		return false
+	}
+
+	for _, a := range batch {
+		if ctx.Err() != nil {
+			return false
+		}
+		// Failures are logged and not retried; the original still works
+		if err := j.render(ctx, a); err != nil {
+			log.Printf("Error making renditions of attachment %d: %v", a.ID, err)
+		}
+		if err := j.db.MarkRenditionsDone(ctx, a.ID); err != nil {
+			log.Printf("Error marking renditions of attachment %d: %v", a.ID, err)
+			return false
+		}
+	}
+	return len(batch) == renditionBatchSize
+}
+
+func (j *RenditionJob) render(ctx context.Context, a Attachment) error {
+	if _, _, needed := fitWithin(a.Width, a.Height, renditionSizes[0].maxSide); !needed {
+		return nil
+	}
+
+	data, err := j.media.Get(ctx, a.storageKey)
+	if err != nil {
+		return fmt.Errorf("failed to read media: %w", err)
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to decode image: %w", err)
+	}
+
+	for _, size := range renditionSizes {
+		width, height, needed := fitWithin(a.Width, a.Height, size.maxSide)
+		if !needed {
+			continue
+		}
+
+		encoded, contentType, ext, err := encodeRendition(resizeImage(img, width, height), a.ContentType)
+		if err != nil {
+			return fmt.Errorf("failed to encode %s: %w", size.name, err)
+		}
+
+		r := Rendition{
+			Name:         size.name,
+			ContentType:  contentType,
+			Width:        width,
+			Height:       height,
+			Size:         len(encoded),
+			attachmentID: a.ID,
+			storageKey:   randomToken(16) + ext,
+		}
+		if err := j.media.Put(ctx, r.storageKey, encoded); err != nil {
+			return fmt.Errorf("failed to store %s: %w", size.name, err)
+		}
+		if err := j.db.SaveRendition(ctx, r); err != nil {
+			j.media.Delete(ctx, r.storageKey)
+			return err
+		}
+	}
+	return nil
+}
+
+// GetAttachmentsWithoutRenditions returns clean attachments that haven't
+// been processed yet, oldest first.
+func (db *DB) GetAttachmentsWithoutRenditions(ctx context.Context, limit int) ([]Attachment, error) {
+	return db.queryAttachments(ctx, `
+		SELECT `+attachmentColumns+`
+		FROM attachments
+		WHERE renditions_at IS NULL AND scan_status = 'clean'
+		ORDER BY id
+		LIMIT $1
+	`, limit)
+}
+
+func (db *DB) SaveRendition(ctx context.Context, r Rendition) error {
+	_, err := db.conn.ExecContext(ctx, `
+		INSERT INTO attachment_renditions (attachment_id, name, storage_key, content_type, width, height, size)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`, r.attachmentID, r.Name, r.storageKey, r.ContentType, r.Width, r.Height, r.Size)
+	if err != nil {
+		return fmt.Errorf("failed to save rendition: %w", err)
+	}
+	return nil
+}
+
+func (db *DB) MarkRenditionsDone(ctx context.Context, attachmentID int) error {
+	_, err := db.conn.ExecContext(ctx, "UPDATE attachments SET renditions_at = NOW() WHERE id = $1", attachmentID)
+	if err != nil {
+		return fmt.Errorf("failed to mark renditions done: %w", err)
+	}
+	return nil
+}
+
+// getRenditions returns the renditions of the given attachments, smallest
+// first, keyed by attachment ID.
+func (db *DB) getRenditions(ctx context.Context, attachmentIDs []int) (map[int][]Rendition, error) {
+	rows, err := db.conn.QueryContext(ctx, `
+		SELECT attachment_id, name, storage_key, content_type, width, height, size
+		FROM attachment_renditions
+		WHERE attachment_id = ANY($1)
+		ORDER BY attachment_id, width
+	`, attachmentIDs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query renditions: %w", err)
+	}
+	defer rows.Close()
+
+	byAttachment := make(map[int][]Rendition)
+	for rows.Next() {
+		var r Rendition
+		if err := rows.Scan(&r.attachmentID, &r.Name, &r.storageKey, &r.ContentType, &r.Width, &r.Height, &r.Size); err != nil {
+			return nil, fmt.Errorf("failed to scan rendition: %w", err)
+		}
+		r.URL = "/media/" + r.storageKey
+		byAttachment[r.attachmentID] = append(byAttachment[r.attachmentID], r)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating renditions: %w", err)
+	}
+
+	return byAttachment, nil
+}
+
+// GetRenditionByKey returns the attachment a rendition stored under key was
+// made from, with the rendition's key and content type, or nil.
+func (db *DB) GetRenditionByKey(ctx context.Context, key string) (*Attachment, error) {
+	query := `
+		SELECT ` + attachmentColumns + `
+		FROM (
+			SELECT a.id, a.post_id, r.storage_key, r.content_type, r.width, r.height, r.size,
+				a.alt_text, a.alt_text_generated, a.created_at, a.banned_image_id, a.scan_status
+			FROM attachment_renditions r
+			JOIN attachments a ON a.id = r.attachment_id
+			WHERE r.storage_key = $1
+		) attachments`
+
+	a, err := scanAttachment(db.conn.QueryRowContext(ctx, query, key))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get rendition: %w", err)
+	}
+	return a, nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import "testing"
+
+func TestFitWithin(t *testing.T) {
+	tests := []struct {
+		width, height, maxSide int
+		wantW, wantH           int
+		wantNeeded             bool
+	}{
+		{4000, 3000, 1024, 1024, 768, true},
+		{3000, 4000, 320, 240, 320, true},
+		{1024, 500, 1024, 1024, 500, false},
+		{200, 100, 320, 200, 100, false},
+		{5000, 2, 320, 320, 1, true},
+	}
+
+	for _, tt := range tests {
+		w, h, needed := fitWithin(tt.width, tt.height, tt.maxSide)
+		if w != tt.wantW || h != tt.wantH || needed != tt.wantNeeded {
+			t.Errorf("fitWithin(%d, %d, %d) = %d, %d, %v, want %d, %d, %v",
+				tt.width, tt.height, tt.maxSide, w, h, needed, tt.wantW, tt.wantH, tt.wantNeeded)
+		}
+	}
+}
+
+func TestResizeImage(t *testing.T) {
+	src := testImage(640, 480, false)
+	dst := resizeImage(src, 320, 240)
+	if b := dst.Bounds(); b.Dx() != 320 || b.Dy() != 240 {
+		t.Fatalf("resized to %dx%d, want 320x240", b.Dx(), b.Dy())
+	}
+
+	// A downscaled image should look like the original
+	if d := perceptualHash(src).distance(perceptualHash(dst)); d > 4 {
+		t.Errorf("hash distance from original = %d, want at most 4", d)
+	}
+}
+
+func TestSrcset(t *testing.T) {
+	a := Attachment{URL: "/media/a.jpg", Width: 2000}
+	if got := srcset(a); got != "" {
+		t.Errorf("srcset without renditions = %q, want empty", got)
+	}
+
+	a.Renditions = []Rendition{
+		{Name: "thumb", URL: "/media/t.jpg", Width: 320},
+		{Name: "medium", URL: "/media/m.jpg", Width: 1024},
+	}
+	want := "/media/t.jpg 320w, /media/m.jpg 1024w, /media/a.jpg 2000w"
+	if got := srcset(a); got != want {
+		t.Errorf("srcset = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// consistencyHeader carries a read-your-writes token. Successful writes
+// return one naming the point the write reached on the primary; sending it
+// back on reads makes them wait for nothing older, so a client always sees
+// its own new post even when reads go to replicas.
+const consistencyHeader = "X-Consistency-Token"
+
+const consistencyPrefix = "c1:"
+
+var errInvalidConsistency = errors.New("invalid consistency token")
+
+// lsn is a PostgreSQL write-ahead log position.
+type lsn uint64
+
+// parseLSN parses an LSN in PostgreSQL's text form, such as "16/B374D848".
+func parseLSN(s string) (lsn, error) {
+	hi, lo, ok := strings.Cut(s, "/")
+	if !ok {
+		return 0, fmt.Errorf("invalid LSN %q", s)
+	}
+	high, err := strconv.ParseUint(hi, 16, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid LSN %q", s)
+	}
+	low, err := strconv.ParseUint(lo, 16, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid LSN %q", s)
+	}
+	return lsn(high<<32 | low), nil
+}
+
+func (l lsn) String() string {
+	return fmt.Sprintf("%X/%X", uint64(l)>>32, uint32(l))
+}
+
+// consistencyToken names a point on the primary. The token is opaque to
+// clients, so its format can change.
+func consistencyToken(l lsn) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(consistencyPrefix + l.String()))
+}
+
+// parseConsistencyToken returns the point a consistency token names.
+func parseConsistencyToken(token string) (lsn, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || !strings.HasPrefix(string(raw), consistencyPrefix) {
+		return 0, errInvalidConsistency
+	}
+	l, err := parseLSN(strings.TrimPrefix(string(raw), consistencyPrefix))
+	if err != nil {
+		return 0, errInvalidConsistency
+	}
+	return l, nil
+}
+
+// replica is a read-only standby of the primary database.
+type replica struct {
+	// host names the replica in logs without its credentials
+	host string
+	pool *sql.DB
+	// replayed is the furthest point the replica has been seen to have
+	// replayed, so a client's token usually needs no round trip to check
+	replayed atomic.Uint64
+}
+
+// replicaSet spreads reads over the replicas in turn.
+type replicaSet struct {
+	replicas []*replica
+	next     atomic.Uint32
+}
+
+// readConsistency marks a context as a read that may use a replica that
+// has replayed at least as far as after.
+type readConsistency struct {
+	after lsn
+}
+
+type readConsistencyKey struct{}
+
+// ConnectReplicas opens the read replicas at urls, ignoring blank ones.
+// Reads for GET requests then go to a replica, see ReadYourWrites;
+// everything else stays on the primary.
+func (db *DB) ConnectReplicas(urls []string) error {
+	set := &replicaSet{}
+	for _, databaseURL := range urls {
+		databaseURL = strings.TrimSpace(databaseURL)
+		if databaseURL == "" {
+			continue
+		}
+		pool, err := sql.Open("pgx", databaseURL)
+		if err != nil {
+			return fmt.Errorf("failed to open replica: %w", err)
+		}
+		pool.SetMaxOpenConns(10)
+		pool.SetMaxIdleConns(2)
+		pool.SetConnMaxLifetime(time.Hour)
+		pool.SetConnMaxIdleTime(30 * time.Minute)
+
+		host := "replica"
+		if u, err := url.Parse(databaseURL); err == nil && u.Host != "" {
+			host = u.Host
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		err = pool.PingContext(ctx)
+		cancel()
+		if err != nil {
+			pool.Close()
+			return fmt.Errorf("failed to ping replica %s: %w", host, err)
+		}
+		set.replicas = append(set.replicas, &replica{host: host, pool: pool})
+	}
+	if len(set.replicas) > 0 {
+		db.replicas = set
+		log.Printf("Reading from %d replica(s)", len(set.replicas))
+	}
+	return nil
+}
+
+// reader returns where a read should run: a replica if ctx is a read that
+// may use one and one has caught up, or else wherever the DB's writes go.
+func (db *DB) reader(ctx context.Context) querier {
+	if db.tx != nil || db.replicas == nil {
+		return db.conn
+	}
+	rc, ok := ctx.Value(readConsistencyKey{}).(readConsistency)
+	if !ok {
+		return db.conn
+	}
+	if r := db.replicas.pick(ctx, rc.after); r != nil {
+		return r.pool
+	}
+	return db.conn
+}
+
+// pick returns the next replica that has replayed as far as after, or nil
+// if none has.
+func (s *replicaSet) pick(ctx context.Context, after lsn) *replica {
+	start := int(s.next.Add(1))
+	for i := range s.replicas {
+		r := s.replicas[(start+i)%len(s.replicas)]
+		if r.caughtUp(ctx, after) {
+			return r
+		}
+	}
+	return nil
+}
+
+func (r *replica) caughtUp(ctx context.Context, after lsn) bool {
+	if lsn(r.replayed.Load()) >= after {
+		return true
+	}
+
+	var replayed sql.NullString
+	if err := r.pool.QueryRowContext(ctx, "SELECT pg_last_wal_replay_lsn()::text").Scan(&replayed); err != nil {
+		log.Printf("Error checking replica %s: %v", r.host, err)
+		return false
+	}
+	// NULL if the database isn't a standby, so its position says nothing
+	if !replayed.Valid {
+		return false
+	}
+	l, err := parseLSN(replayed.String)
+	if err != nil {
+		log.Printf("Error checking replica %s: %v", r.host, err)
+		return false
+	}
+	for {
+		seen := r.replayed.Load()
+		if uint64(l) <= seen || r.replayed.CompareAndSwap(seen, uint64(l)) {
+			break
+		}
+	}
+	return l >= after
+}
+
+// currentLSN returns the primary's current write position.
+func (db *DB) currentLSN(ctx context.Context) (lsn, error) {
+	var current string
+	if err := db.pool.QueryRowContext(ctx, "SELECT pg_current_wal_lsn()::text").Scan(&current); err != nil {
+		return 0, fmt.Errorf("failed to get WAL position: %w", err)
+	}
+	return parseLSN(current)
+}
+
+func (db *DB) closeReplicas() {
+	if db.replicas == nil {
+		return
+	}
+	for _, r := range db.replicas.replicas {
+		r.pool.Close()
+	}
+}
+
+// ReadYourWrites sends the reads of GET and HEAD requests to replicas,
+// holding them to replicas that have caught up with the request's
+// X-Consistency-Token, and gives every successful write a token. Other
+// requests read from the primary, so a handler that reads back what it
+// just wrote sees it. Without replicas it does nothing.
+func ReadYourWrites(next http.Handler, db *DB) http.Handler {
+	if db.replicas == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+		case http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		default:
+			next.ServeHTTP(&consistencyWriter{ResponseWriter: w, r: r, db: db}, r)
+			return
+		}
+
+		var rc readConsistency
+		if token := r.Header.Get(consistencyHeader); token != "" {
+			after, err := parseConsistencyToken(token)
+			if err != nil {
+				respondWithError(w, http.StatusBadRequest, "Invalid "+consistencyHeader)
+				return
+			}
+			rc.after = after
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), readConsistencyKey{}, rc)))
+	})
+}
+
+// consistencyWriter adds a consistency token to a successful write's
+// response. A 202 isn't given one: the write hasn't happened yet.
+type consistencyWriter struct {
+	http.ResponseWriter
+	r           *http.Request
+	db          *DB
+	wroteHeader bool
+}
+
+func (cw *consistencyWriter) WriteHeader(status int) {
+	if !cw.wroteHeader {
+		cw.wroteHeader = true
+		if status >= 200 && status < 300 && status != http.StatusAccepted {
+			if current, err := cw.db.currentLSN(cw.r.Context()); err != nil {
+				log.Printf("Error issuing consistency token: %v", err)
+			} else {
+				cw.Header().Set(consistencyHeader, consistencyToken(current))
+			}
+		}
+	}
+	cw.ResponseWriter.WriteHeader(status)
+}
+
+func (cw *consistencyWriter) Write(b []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (cw *consistencyWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
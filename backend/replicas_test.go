@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestConsistencyToken(t *testing.T) {
+	for _, s := range []string{"0/0", "16/B374D848", "FFFFFFFF/FFFFFFFF"} {
+		l, err := parseLSN(s)
+		if err != nil {
+			t.Fatalf("parseLSN(%q): %v", s, err)
+		}
+		if l.String() != s {
+			t.Errorf("parseLSN(%q).String() = %q", s, l)
+		}
+		got, err := parseConsistencyToken(consistencyToken(l))
+		if err != nil || got != l {
+			t.Errorf("token for %s parsed as %s, %v", s, got, err)
+		}
+	}
+
+	if a, b := mustLSN(t, "1/0"), mustLSN(t, "0/FFFFFFFF"); a <= b {
+		t.Errorf("1/0 = %d not after 0/FFFFFFFF = %d", a, b)
+	}
+
+	for _, token := range []string{"", "!!!", snapshotToken(5), consistencyToken(1)[:4], "YzE6MTYvWg"} {
+		if _, err := parseConsistencyToken(token); err == nil {
+			t.Errorf("parseConsistencyToken(%q) succeeded, want an error", token)
+		}
+	}
+}
+
+func mustLSN(t *testing.T, s string) lsn {
+	t.Helper()
+	l, err := parseLSN(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+// TestReader checks where reads go. The replicas' replay positions are
+// already known, and checking further finds nothing listening, so no
+// database is needed.
+func TestReader(t *testing.T) {
+	unreachable := func() *sql.DB {
+		pool, err := sql.Open("pgx", "postgres://127.0.0.1:1/replica?connect_timeout=1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { pool.Close() })
+		return pool
+	}
+	primary, behind, ahead := &sql.DB{}, unreachable(), unreachable()
+	set := &replicaSet{replicas: []*replica{{host: "behind", pool: behind}, {host: "ahead", pool: ahead}}}
+	set.replicas[0].replayed.Store(100)
+	set.replicas[1].replayed.Store(200)
+	db := &DB{pool: primary, conn: primary, replicas: set}
+
+	read := func(after lsn) context.Context {
+		return context.WithValue(context.Background(), readConsistencyKey{}, readConsistency{after: after})
+	}
+	tests := []struct {
+		name string
+		ctx  context.Context
+		want querier
+	}{
+		{"not a read request", context.Background(), primary},
+		{"only the ahead replica has the write", read(150), ahead},
+		{"neither replica has the write", read(300), primary},
+	}
+	for _, tt := range tests {
+		if got := db.reader(tt.ctx); got != tt.want {
+			t.Errorf("%s: read from the wrong database", tt.name)
+		}
+	}
+
+	// Reads without a token go to each replica in turn
+	seen := map[querier]bool{}
+	for i := 0; i < 2; i++ {
+		seen[db.reader(read(0))] = true
+	}
+	if !seen[behind] || !seen[ahead] {
+		t.Error("reads without a token didn't use both replicas")
+	}
+
+	tx := &DB{pool: primary, conn: &sql.Tx{}, tx: &sql.Tx{}, replicas: set}
+	if got := tx.reader(read(0)); got != tx.conn {
+		t.Error("read in a transaction left the transaction")
+	}
+}
+
+func TestReadYourWritesInvalidToken(t *testing.T) {
+	db := &DB{replicas: &replicaSet{replicas: []*replica{{host: "replica"}}}}
+	h := ReadYourWrites(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("request with an invalid token reached the handler")
+	}), db)
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/api/posts", nil)
+	req.Header.Set(consistencyHeader, "nonsense")
+	h.ServeHTTP(rec, req)
+	assertError(t, rec, http.StatusBadRequest, "Invalid X-Consistency-Token")
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	retentionInterval  = time.Hour
+	retentionBatchSize = 1000
+)
+
+// RetentionPolicy maps retention class names (e.g. "festival") to how many
+// days posts in events of that class are kept. Events without a class use
+// DefaultClass; if that is empty their posts are kept indefinitely.
+type RetentionPolicy struct {
+	Classes      map[string]int
+	DefaultClass string
+}
+
+// ParseRetentionPolicy parses RETENTION_CLASSES, a comma-separated list of
+// class:days pairs such as "festival:90,conference:365,campus:30".
+func ParseRetentionPolicy(classes, defaultClass string) (RetentionPolicy, error) {
+	policy := RetentionPolicy{Classes: make(map[string]int), DefaultClass: defaultClass}
+
+	for _, entry := range strings.Split(classes, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, daysStr, found := strings.Cut(entry, ":")
+		days, err := strconv.Atoi(strings.TrimSpace(daysStr))
+		if !found || err != nil || days < 1 {
+			return policy, fmt.Errorf("invalid retention class %q, expected name:days", entry)
+		}
+		policy.Classes[strings.TrimSpace(name)] = days
+	}
+
+	if defaultClass != "" {
+		if _, ok := policy.Classes[defaultClass]; !ok {
+			return policy, fmt.Errorf("default retention class %q is not defined", defaultClass)
+		}
+	}
+
+	return policy, nil
+}
+
+// Apply fills in the effective retention class and days for an event.
+func (p RetentionPolicy) Apply(event *Event) {
+	if event.RetentionClass == "" {
+		event.RetentionClass = p.DefaultClass
+	}
+	event.RetentionDays = p.Classes[event.RetentionClass]
+}
+
+// RetentionJob deletes posts that have outlived their event's retention class.
+type RetentionJob struct {
+	db     *DB
+	policy RetentionPolicy
+}
+
+func NewRetentionJob(db *DB, policy RetentionPolicy) *RetentionJob {
+	return &RetentionJob{db: db, policy: policy}
+}
+
+// Run enforces retention once at startup and then every retentionInterval
+// until ctx is cancelled.
+func (j *RetentionJob) Run(ctx context.Context) {
+	ticker := time.NewTicker(retentionInterval)
+	defer ticker.Stop()
+
+	for {
+		j.enforce(ctx)
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (j *RetentionJob) enforce(ctx context.Context) {
+	for class, days := range j.policy.Classes {
+		isDefault := class == j.policy.DefaultClass
+		total := 0
+		for {
+			deleted, err := j.db.DeleteExpiredPosts(ctx, class, isDefault, days, retentionBatchSize)
+			if err != nil {
+				log.Printf("Error enforcing retention for class %s: %v", class, err)
+				break
+			}
+			total += deleted
+			if deleted < retentionBatchSize || ctx.Err() != nil {
+				break
+			}
+		}
+		if total > 0 {
+			log.Printf("Retention: deleted %d posts in class %s (older than %d days)", total, class, days)
+		}
+	}
+}
+
+// DeleteExpiredPosts deletes up to limit posts older than days in events of
+// the given retention class. When includeUnassigned is set, events with no
+// class are treated as belonging to it. Posts under legal hold are skipped.
+func (db *DB) DeleteExpiredPosts(ctx context.Context, class string, includeUnassigned bool, days, limit int) (int, error) {
+	query := `
+		DELETE FROM posts
+		WHERE id IN (
+			SELECT p.id
+			FROM posts p
+			JOIN events e ON e.name = p.event_name
+			WHERE (e.retention_class = $1 OR ($2 AND e.retention_class IS NULL))
+			AND p.created_at < NOW() - INTERVAL '1 day' * $3
+			AND ` + notUnderLegalHold + `
+			LIMIT $4
+		)
+	`
+
+	result, err := db.conn.ExecContext(ctx, query, class, includeUnassigned, days, limit)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired posts: %w", err)
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired posts: %w", err)
+	}
+
+	return int(deleted), nil
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	errPostNotFound   = errors.New("post not found")
+	errEditNotAllowed = errors.New("edit token does not match")
+	errEditWindowOver = errors.New("edit window is over")
+)
+
+// PostRevision is a version of a post's content that was replaced by an edit.
+type PostRevision struct {
+	ID             int       `json:"id"`
+	PostID         int       `json:"post_id"`
+	Content        string    `json:"content"`
+	ContentWarning string    `json:"content_warning,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// EditPostRequest replaces a post's content. EditToken is the token
+// returned once, when the post was created. Authors can't change a post's
+// content warning; that is left to moderators and screening.
+type EditPostRequest struct {
+	EditToken string `json:"edit_token"`
+	Content   string `json:"content"`
+}
+
+// hashToken hashes a client-held secret for storage.
+func hashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+// EditPost handles PATCH /api/posts/{id}. The previous version is kept in
+// the post's revision history, and the new content is screened again. With
+// If-Match, the edit only applies if the post hasn't changed since the
+// client fetched it, e.g. by a moderator adding a content warning. Posts can
+// only be edited for EditWindow after they are made, if it is set.
+func (h *Handler) EditPost(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid post ID")
+		return
+	}
+
+	var req EditPostRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	req.Content = strings.TrimSpace(req.Content)
+
+	if req.EditToken == "" {
+		respondWithError(w, http.StatusBadRequest, "edit_token is required")
+		return
+	}
+	if req.Content == "" {
+		respondWithError(w, http.StatusBadRequest, "content is required")
+		return
+	}
+	if len(req.Content) > 5000 {
+		respondWithError(w, http.StatusBadRequest, "content must be 5000 characters or less")
+		return
+	}
+
+	var post *Post
+	err = h.db.WithTx(r.Context(), func(tx Store) error {
+		var err error
+		post, err = tx.EditPost(r.Context(), id, hashToken(req.EditToken), req.Content, ifMatchVersions(r), h.cfg.EditWindow)
+		if err != nil {
+			return err
+		}
+		return screenPost(r.Context(), tx, post)
+	})
+	switch {
+	case err == nil:
+	case errors.Is(err, errPostNotFound):
+		respondWithError(w, http.StatusNotFound, "Post not found")
+		return
+	case errors.Is(err, errEditNotAllowed):
+		respondWithError(w, http.StatusForbidden, "edit_token does not match this post")
+		return
+	case errors.Is(err, errEditWindowOver):
+		respondWithError(w, http.StatusForbidden, fmt.Sprintf("Posts can only be edited for %s after they are made", formatEditWindow(h.cfg.EditWindow)))
+		return
+	case errors.Is(err, errVersionMismatch):
+		respondVersionMismatch(w, "Post")
+		return
+	default:
+		log.Printf("Error editing post: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to edit post")
+		return
+	}
+
+	h.cfg.Toxicity.Enqueue(*post)
+	h.cfg.HotTier.invalidate(post.EventName)
+
+	posts := []Post{*post}
+	if err := h.applyEventSettings(r.Context(), posts); err != nil {
+		log.Printf("Error applying event settings: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to edit post")
+		return
+	}
+	if err := h.loadAttachments(r.Context(), posts); err != nil {
+		log.Printf("Error loading attachments: %v", err)
+	}
+
+	w.Header().Set("ETag", versionETag(post.Version))
+	respondWithJSON(w, http.StatusOK, posts[0])
+}
+
+// DeletePost handles DELETE /api/posts/{id}, for the author, who sends the
+// edit token returned when the post was created in X-Edit-Token. A post
+// under a legal hold is hidden instead, which looks the same to readers.
+func (h *Handler) DeletePost(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid post ID")
+		return
+	}
+
+	token := r.Header.Get("X-Edit-Token")
+	if token == "" {
+		respondWithError(w, http.StatusBadRequest, "X-Edit-Token is required")
+		return
+	}
+
+	event, err := h.db.DeletePost(r.Context(), id, hashToken(token))
+	switch {
+	case err == nil:
+	case errors.Is(err, errPostNotFound):
+		respondWithError(w, http.StatusNotFound, "Post not found")
+		return
+	case errors.Is(err, errEditNotAllowed):
+		respondWithError(w, http.StatusForbidden, "X-Edit-Token does not match this post")
+		return
+	default:
+		log.Printf("Error deleting post: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to delete post")
+		return
+	}
+
+	h.cfg.HotTier.invalidate(event)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetPostRevisions handles GET /api/posts/{id}/revisions, for moderators.
+// Revisions are returned oldest first.
+func (h *Handler) GetPostRevisions(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid post ID")
+		return
+	}
+
+	revisions, err := h.db.GetPostRevisions(r.Context(), id)
+	if err != nil {
+		log.Printf("Error getting post revisions: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve revisions")
+		return
+	}
+
+	if revisions == nil {
+		revisions = []PostRevision{}
+	}
+
+	respondWithJSON(w, http.StatusOK, revisions)
+}
+
+// formatEditWindow describes an edit window for error messages.
+func formatEditWindow(window time.Duration) string {
+	if minutes := int(window.Minutes()); minutes != 1 {
+		return fmt.Sprintf("%d minutes", minutes)
+	}
+	return "1 minute"
+}
+
+// EditPost replaces a post's content if tokenHash matches, recording the
+// old version as a revision. The content warning is kept, so an author
+// can't take off one a moderator or the screening put on. If versions isn't
+// nil, the post must be at one of them or errVersionMismatch is returned. If window is set, a post older
+// than it returns errEditWindowOver; its age is taken from the database's
+// clock, as created_at is.
+func (db *DB) EditPost(ctx context.Context, id int, tokenHash, content string, versions []int, window time.Duration) (*Post, error) {
+	tx, err := db.begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin edit: %w", err)
+	}
+	defer tx.Rollback()
+
+	var storedHash sql.NullString
+	var version int
+	var ageSeconds float64
+	err = tx.QueryRowContext(ctx,
+		"SELECT edit_token_hash, version, EXTRACT(EPOCH FROM NOW() - created_at) FROM posts WHERE id = $1 FOR UPDATE",
+		id,
+	).Scan(&storedHash, &version, &ageSeconds)
+	if err == sql.ErrNoRows {
+		return nil, errPostNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock post: %w", err)
+	}
+	// Posts that arrived without a token, e.g. by SMS, can't be edited
+	if !storedHash.Valid || storedHash.String != tokenHash {
+		return nil, errEditNotAllowed
+	}
+	if window > 0 && ageSeconds > window.Seconds() {
+		return nil, errEditWindowOver
+	}
+	if versions != nil && !slices.Contains(versions, version) {
+		return nil, errVersionMismatch
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO post_revisions (post_id, content, content_warning)
+		SELECT id, content, content_warning FROM posts WHERE id = $1
+	`, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save revision: %w", err)
+	}
+
+	query := `
+		UPDATE posts
+		SET content = $2, edited_at = NOW(), edit_count = edit_count + 1
+		WHERE id = $1
+		RETURNING ` + postColumns
+
+	post, err := scanPost(tx.QueryRowContext(ctx, query, id, content))
+	if err != nil {
+		return nil, fmt.Errorf("failed to edit post: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit edit: %w", err)
+	}
+	return post, nil
+}
+
+// DeletePost deletes a post if tokenHash matches, returning its event. A
+// post under a legal hold is hidden by "author" instead.
+func (db *DB) DeletePost(ctx context.Context, id int, tokenHash string) (string, error) {
+	tx, err := db.begin(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to begin delete: %w", err)
+	}
+	defer tx.Rollback()
+
+	var storedHash sql.NullString
+	var event string
+	err = tx.QueryRowContext(ctx, "SELECT edit_token_hash, event_name FROM posts WHERE id = $1 FOR UPDATE", id).Scan(&storedHash, &event)
+	if err == sql.ErrNoRows {
+		return "", errPostNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to lock post: %w", err)
+	}
+	if !storedHash.Valid || storedHash.String != tokenHash {
+		return "", errEditNotAllowed
+	}
+
+	result, err := tx.ExecContext(ctx, "DELETE FROM posts p WHERE p.id = $1 AND "+notUnderLegalHold, id)
+	if err != nil {
+		return "", fmt.Errorf("failed to delete post: %w", err)
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return "", fmt.Errorf("failed to delete post: %w", err)
+	}
+	if deleted == 0 {
+		_, err = tx.ExecContext(ctx, "UPDATE posts SET hidden_at = NOW(), hidden_by = 'author' WHERE id = $1 AND hidden_at IS NULL", id)
+		if err != nil {
+			return "", fmt.Errorf("failed to hide held post: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", fmt.Errorf("failed to commit delete: %w", err)
+	}
+	return event, nil
+}
+
+func (db *DB) GetPostRevisions(ctx context.Context, postID int) ([]PostRevision, error) {
+	rows, err := db.conn.QueryContext(ctx, `
+		SELECT id, post_id, content, COALESCE(content_warning, ''), created_at
+		FROM post_revisions
+		WHERE post_id = $1
+		ORDER BY id
+	`, postID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query post revisions: %w", err)
+	}
+	defer rows.Close()
+
+	var revisions []PostRevision
+	for rows.Next() {
+		var rev PostRevision
+		if err := rows.Scan(&rev.ID, &rev.PostID, &rev.Content, &rev.ContentWarning, &rev.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan post revision: %w", err)
+		}
+		revisions = append(revisions, rev)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating post revisions: %w", err)
+	}
+
+	return revisions, nil
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDeletePost(t *testing.T) {
+	store := newFakeStore()
+	h := newTestHandler(store, HandlerConfig{})
+	post, err := store.CreatePost(context.Background(), CreatePostRequest{EventName: "Glastonbury", Content: "hi"}, "ip", hashToken("token"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	del := func(id, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodDelete, "/api/posts/"+id, nil)
+		req.SetPathValue("id", id)
+		if token != "" {
+			req.Header.Set("X-Edit-Token", token)
+		}
+		rec := httptest.NewRecorder()
+		h.DeletePost(rec, req)
+		return rec
+	}
+	id := strconv.Itoa(post.ID)
+
+	assertError(t, del("abc", "token"), 400, "Invalid post ID")
+	assertError(t, del(id, ""), 400, "X-Edit-Token is required")
+	assertError(t, del(id, "wrong"), 403, "X-Edit-Token does not match this post")
+	if rec := del(id, "token"); rec.Code != http.StatusNoContent {
+		t.Fatalf("status = %d, want 204 (body %s)", rec.Code, rec.Body)
+	}
+	if len(store.posts) != 0 {
+		t.Errorf("%d posts left, want none", len(store.posts))
+	}
+	assertError(t, del(id, "token"), 404, "Post not found")
+
+	store.fail["DeletePost"] = true
+	assertError(t, del(id, "token"), 500, "Failed to delete post")
+}
+
+// TestDeletePostLegalHold checks that an author's delete hides a post under
+// a legal hold rather than deleting it.
+func TestDeletePostLegalHold(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	event := fmt.Sprintf("Delete Post Test %d", time.Now().UnixNano())
+
+	create := func() *Post {
+		post, err := db.CreatePost(ctx, CreatePostRequest{EventName: event, Content: "hello", Age: 25, Location: "x"}, "delete-test", hashToken("token"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return post
+	}
+
+	post := create()
+	if _, err := db.DeletePost(ctx, post.ID, hashToken("wrong")); !errors.Is(err, errEditNotAllowed) {
+		t.Errorf("DeletePost with the wrong token = %v, want errEditNotAllowed", err)
+	}
+	if got, err := db.DeletePost(ctx, post.ID, hashToken("token")); err != nil || got != event {
+		t.Fatalf("DeletePost = %q, %v; want %q", got, err, event)
+	}
+	if got, err := db.GetPostByID(ctx, post.ID); err != nil || got != nil {
+		t.Errorf("GetPostByID after delete = %v, %v; want nothing", got, err)
+	}
+
+	held := create()
+	hold, err := db.CreateLegalHold(ctx, CreateLegalHoldRequest{TargetType: "post", TargetValue: strconv.Itoa(held.ID), Reason: "test"}, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.ReleaseLegalHold(context.Background(), hold.ID, "test") })
+
+	if _, err := db.DeletePost(ctx, held.ID, hashToken("token")); err != nil {
+		t.Fatal(err)
+	}
+	got, err := db.GetPostByID(ctx, held.ID)
+	if err != nil || got == nil || got.HiddenAt == nil {
+		t.Errorf("held post after delete = %v, %v; want it kept, hidden", got, err)
+	}
+}
+
+func TestEditPostWindow(t *testing.T) {
+	store := newFakeStore()
+	store.posts = []Post{
+		{ID: 1, EventName: "Glastonbury", Content: "Lost: blue hat", CreatedAt: time.Now().Add(-5 * time.Minute)},
+		{ID: 2, EventName: "Glastonbury", Content: "Lost: red hat", CreatedAt: time.Now().Add(-20 * time.Minute)},
+	}
+	h := newTestHandler(store, HandlerConfig{EditWindow: 15 * time.Minute})
+
+	edit := func(id string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPatch, "/api/posts/"+id, strings.NewReader(`{"edit_token":"secret","content":"Found it"}`))
+		req.SetPathValue("id", id)
+		rec := httptest.NewRecorder()
+		h.EditPost(rec, req)
+		return rec
+	}
+
+	if rec := edit("1"); rec.Code != http.StatusOK {
+		t.Errorf("status = %d within the window, want 200 (body %s)", rec.Code, rec.Body)
+	}
+	assertError(t, edit("2"), 403, "Posts can only be edited for 15 minutes after they are made")
+	if store.posts[1].Content != "Lost: red hat" {
+		t.Errorf("content = %q, want it unchanged", store.posts[1].Content)
+	}
+}
+
+// TestEditPostContentOnly checks that an edit changes only the content, and
+// leaves the warning a moderator put on the post.
+func TestEditPostContentOnly(t *testing.T) {
+	store := newFakeStore()
+	store.posts = []Post{{ID: 1, EventName: "Glastonbury", Content: "Lost: blue hat", ContentWarning: "spoilers"}}
+	h := newTestHandler(store, HandlerConfig{})
+
+	body := `{"edit_token":"secret","content":"Found it","content_warning":""}`
+	req := httptest.NewRequest(http.MethodPatch, "/api/posts/1", strings.NewReader(body))
+	req.SetPathValue("id", "1")
+	rec := httptest.NewRecorder()
+	h.EditPost(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200 (body %s)", rec.Code, rec.Body)
+	}
+	if got := store.posts[0]; got.Content != "Found it" || got.ContentWarning != "spoilers" {
+		t.Errorf("post = %q with warning %q, want the new content and the warning kept", got.Content, got.ContentWarning)
+	}
+}
+
+// TestEditPostKeepsWarning checks that an author's edit can't take off a
+// content warning a moderator put on the post.
+func TestEditPostKeepsWarning(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	event := fmt.Sprintf("Edit Warning Test %d", time.Now().UnixNano())
+
+	post, err := db.CreatePost(ctx, CreatePostRequest{EventName: event, Content: "hello", Age: 25, Location: "x"}, "edit-warning-test", hashToken("token"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.SetContentWarning(ctx, post.ID, "spoilers"); err != nil {
+		t.Fatal(err)
+	}
+
+	edited, err := db.EditPost(ctx, post.ID, hashToken("token"), "edited", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if edited.ContentWarning != "spoilers" {
+		t.Errorf("content_warning after edit = %q, want the moderator's kept", edited.ContentWarning)
+	}
+}
+
+// TestEditPostWindowDB checks the window is measured by the database.
+func TestEditPostWindowDB(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	event := fmt.Sprintf("Edit Window Test %d", time.Now().UnixNano())
+
+	post, err := db.CreatePost(ctx, CreatePostRequest{EventName: event, Content: "hello", Age: 25, Location: "x"}, "edit-window-test", hashToken("token"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.EditPost(ctx, post.ID, hashToken("token"), "edited", nil, time.Nanosecond); !errors.Is(err, errEditWindowOver) {
+		t.Errorf("EditPost after the window = %v, want errEditWindowOver", err)
+	}
+	edited, err := db.EditPost(ctx, post.ID, hashToken("token"), "edited", nil, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if edited.EditedAt == nil || edited.Content != "edited" {
+		t.Errorf("edited post = %+v, want the new content and edited_at", edited)
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Route policy auth levels. A policy can only add to the auth a route
+// already requires in code, never lift it.
+const (
+	routeAuthPublic = "public"
+	routeAuthAPIKey = "api_key"
+	routeAuthAdmin  = "admin"
+)
+
+// RoutePolicy tunes one route without a code change. Pattern uses the same
+// syntax as the router, such as "GET /api/posts/{id}"; a request takes the
+// policy of the most specific pattern it matches, as it would a handler.
+type RoutePolicy struct {
+	Pattern string `json:"pattern"`
+	// TimeoutMs bounds how long the request may take, cancelling its
+	// context at the deadline; it may exceed the server's write timeout
+	TimeoutMs int `json:"timeout_ms"`
+	// CacheTTLSeconds sets Cache-Control on successful responses that don't
+	// set their own: public for that long, or no-store when 0. Unset
+	// leaves responses alone.
+	CacheTTLSeconds *int `json:"cache_ttl_seconds"`
+	// RateLimit names a rate limiter (posts, sms or client_errors) to apply
+	// to the route's writes
+	RateLimit string `json:"rate_limit"`
+	// Auth is public, api_key (an API key or the admin token) or admin
+	Auth string `json:"auth"`
+}
+
+type routePolicyFile struct {
+	Routes []RoutePolicy `json:"routes"`
+}
+
+// RoutePolicies applies the policies in a route policy file, see
+// ROUTE_POLICY_FILE in env.example.env.
+type RoutePolicies struct {
+	mux *http.ServeMux
+}
+
+// LoadRoutePolicies reads the route policy file at path. An empty path
+// returns nil, which applies nothing. limiters are the rate limiters
+// policies may name.
+func LoadRoutePolicies(path, adminToken string, limiters []*RateLimiter) (*RoutePolicies, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read route policies: %w", err)
+	}
+	return parseRoutePolicies(data, adminToken, limiters)
+}
+
+func parseRoutePolicies(data []byte, adminToken string, limiters []*RateLimiter) (*RoutePolicies, error) {
+	var file routePolicyFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("invalid route policies: %w", err)
+	}
+
+	byName := make(map[string]*RateLimiter)
+	for _, rl := range limiters {
+		byName[rl.name] = rl
+	}
+
+	rp := &RoutePolicies{mux: http.NewServeMux()}
+	for i, policy := range file.Routes {
+		handler, err := policy.middleware(adminToken, byName)
+		if err != nil {
+			return nil, fmt.Errorf("route policy %d (%s): %w", i+1, policy.Pattern, err)
+		}
+		if err := registerPolicy(rp.mux, policy.Pattern, handler); err != nil {
+			return nil, fmt.Errorf("route policy %d: %w", i+1, err)
+		}
+	}
+	return rp, nil
+}
+
+// registerPolicy adds a policy's pattern, turning the router's panic on a
+// malformed or duplicate pattern into an error.
+func registerPolicy(mux *http.ServeMux, pattern string, policy policyHandler) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("invalid pattern %q: %v", pattern, r)
+		}
+	}()
+	mux.Handle(pattern, policy)
+	return nil
+}
+
+// policyHandler holds a route's policy as middleware; the policy mux only
+// looks it up, it is never served.
+type policyHandler func(next http.Handler) http.Handler
+
+func (policyHandler) ServeHTTP(http.ResponseWriter, *http.Request) {}
+
+// middleware builds the policy's middleware, checking its settings.
+func (p RoutePolicy) middleware(adminToken string, limiters map[string]*RateLimiter) (policyHandler, error) {
+	if p.TimeoutMs < 0 {
+		return nil, fmt.Errorf("timeout_ms must not be negative")
+	}
+	if p.CacheTTLSeconds != nil && *p.CacheTTLSeconds < 0 {
+		return nil, fmt.Errorf("cache_ttl_seconds must not be negative")
+	}
+	var limiter *RateLimiter
+	if p.RateLimit != "" {
+		limiter = limiters[p.RateLimit]
+		if limiter == nil {
+			return nil, fmt.Errorf("unknown rate limit %q", p.RateLimit)
+		}
+	}
+	switch p.Auth {
+	case "", routeAuthPublic, routeAuthAPIKey, routeAuthAdmin:
+	default:
+		return nil, fmt.Errorf("auth must be one of public, api_key, admin")
+	}
+
+	return func(next http.Handler) http.Handler {
+		if limiter != nil {
+			next = limiter.Limit(next)
+		}
+		if p.CacheTTLSeconds != nil {
+			cacheControl := "no-store"
+			if *p.CacheTTLSeconds > 0 {
+				cacheControl = "public, max-age=" + strconv.Itoa(*p.CacheTTLSeconds)
+			}
+			next = withCacheControl(next, cacheControl)
+		}
+		if p.TimeoutMs > 0 {
+			next = withTimeout(next, time.Duration(p.TimeoutMs)*time.Millisecond)
+		}
+		switch p.Auth {
+		case routeAuthAdmin:
+			next = AdminAuth(next, adminToken)
+		case routeAuthAPIKey:
+			next = requireAPIKey(next, adminToken)
+		}
+		return next
+	}, nil
+}
+
+// Middleware applies to each request the policy of the route it matches.
+func (rp *RoutePolicies) Middleware(next http.Handler) http.Handler {
+	if rp == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler, pattern := rp.mux.Handler(r)
+		policy, ok := handler.(policyHandler)
+		if pattern == "" || !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		policy(next).ServeHTTP(w, r)
+	})
+}
+
+func withTimeout(next http.Handler, timeout time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Leave time past the deadline to write the response
+		http.NewResponseController(w).SetWriteDeadline(time.Now().Add(timeout + 5*time.Second))
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func withCacheControl(next http.Handler, cacheControl string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(&cacheControlWriter{ResponseWriter: w, cacheControl: cacheControl}, r)
+	})
+}
+
+// cacheControlWriter sets Cache-Control on a successful response that
+// hasn't set it.
+type cacheControlWriter struct {
+	http.ResponseWriter
+	cacheControl string
+	wroteHeader  bool
+}
+
+func (cw *cacheControlWriter) WriteHeader(status int) {
+	if !cw.wroteHeader {
+		cw.wroteHeader = true
+		if status >= 200 && status < 300 && cw.Header().Get("Cache-Control") == "" {
+			cw.Header().Set("Cache-Control", cw.cacheControl)
+		}
+	}
+	cw.ResponseWriter.WriteHeader(status)
+}
+
+func (cw *cacheControlWriter) Write(b []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (cw *cacheControlWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// requireAPIKey turns away requests without a valid API key, which
+// APIKeyAuth.Authenticate has already checked, or the admin token.
+func requireAPIKey(next http.Handler, adminToken string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if apiKeyFromContext(r.Context()) == nil && !isAdminRequest(r, adminToken) {
+			respondWithError(w, http.StatusUnauthorized, "API key required")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func testRoutePolicies(t *testing.T, config string) *RoutePolicies {
+	t.Helper()
+	limiter, err := NewRateLimiter(nil, "posts", RateLimitPolicy{Requests: 1, WindowMinutes: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rp, err := parseRoutePolicies([]byte(config), "secret", []*RateLimiter{limiter})
+	if err != nil {
+		t.Fatalf("parseRoutePolicies: %v", err)
+	}
+	return rp
+}
+
+func TestParseRoutePoliciesRejects(t *testing.T) {
+	for _, tc := range []struct {
+		name, config, want string
+	}{
+		{"unknown limiter", `{"routes": [{"pattern": "POST /api/posts", "rate_limit": "uploads"}]}`, `unknown rate limit "uploads"`},
+		{"bad auth", `{"routes": [{"pattern": "GET /api/posts", "auth": "staff"}]}`, "auth must be one of"},
+		{"negative timeout", `{"routes": [{"pattern": "GET /api/posts", "timeout_ms": -1}]}`, "timeout_ms"},
+		{"duplicate pattern", `{"routes": [{"pattern": "GET /api/posts"}, {"pattern": "GET /api/posts"}]}`, "route policy 2"},
+		{"bad pattern", `{"routes": [{"pattern": "GET /api/{"}]}`, "invalid pattern"},
+		{"not JSON", `routes:`, "invalid route policies"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := parseRoutePolicies([]byte(tc.config), "secret", nil)
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("error = %v, want one containing %q", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestRoutePoliciesApply(t *testing.T) {
+	rp := testRoutePolicies(t, `{"routes": [
+		{"pattern": "GET /api/events", "cache_ttl_seconds": 30, "timeout_ms": 1000},
+		{"pattern": "GET /api/me/counts", "cache_ttl_seconds": 0},
+		{"pattern": "/api/export/", "auth": "admin"},
+		{"pattern": "GET /api/posts/{id}/translate", "auth": "api_key"}
+	]}`)
+
+	var hasDeadline bool
+	h := rp.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, hasDeadline = r.Context().Deadline()
+		if r.URL.Query().Has("private") {
+			w.Header().Set("Cache-Control", "private")
+		}
+		if r.URL.Query().Has("fail") {
+			respondWithError(w, http.StatusInternalServerError, "Failed")
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	serve := func(method, target, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := serve("GET", "/api/events", "")
+	if got := rec.Header().Get("Cache-Control"); got != "public, max-age=30" {
+		t.Errorf("Cache-Control = %q, want public, max-age=30", got)
+	}
+	if !hasDeadline {
+		t.Error("request has no deadline, want the policy's timeout")
+	}
+	if got := serve("GET", "/api/events?private", "").Header().Get("Cache-Control"); got != "private" {
+		t.Errorf("Cache-Control = %q, want the handler's own private", got)
+	}
+	if got := serve("GET", "/api/events?fail", "").Header().Get("Cache-Control"); got != "" {
+		t.Errorf("Cache-Control = %q on an error, want none", got)
+	}
+	if got := serve("GET", "/api/me/counts", "").Header().Get("Cache-Control"); got != "no-store" {
+		t.Errorf("Cache-Control = %q, want no-store", got)
+	}
+
+	assertError(t, serve("GET", "/api/export/posts", ""), http.StatusUnauthorized, "Unauthorized")
+	if rec := serve("GET", "/api/export/posts", "secret"); rec.Code != http.StatusOK {
+		t.Errorf("admin request status = %d, want 200", rec.Code)
+	}
+	assertError(t, serve("GET", "/api/posts/1/translate", ""), http.StatusUnauthorized, "API key required")
+	if rec := serve("GET", "/api/posts/1/translate", "secret"); rec.Code != http.StatusOK {
+		t.Errorf("admin token on an api_key route status = %d, want 200", rec.Code)
+	}
+
+	// Routes without a policy, and other methods, pass straight through
+	rec = serve("GET", "/api/posts", "")
+	if rec.Code != http.StatusOK || rec.Header().Get("Cache-Control") != "" || hasDeadline {
+		t.Errorf("unmatched route got status %d, Cache-Control %q, deadline %v; want it untouched",
+			rec.Code, rec.Header().Get("Cache-Control"), hasDeadline)
+	}
+	if got := serve("POST", "/api/events", "").Header().Get("Cache-Control"); got != "" {
+		t.Errorf("Cache-Control = %q on a POST, want none", got)
+	}
+}
+
+func TestRoutePoliciesNil(t *testing.T) {
+	rp, err := LoadRoutePolicies("", "secret", nil)
+	if err != nil || rp != nil {
+		t.Fatalf("LoadRoutePolicies(\"\") = %v, %v; want nil, nil", rp, err)
+	}
+	next := http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})
+	rec := httptest.NewRecorder()
+	rp.Middleware(next).ServeHTTP(rec, httptest.NewRequest("GET", "/api/posts", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("status = %d, want 200", rec.Code)
+	}
+}
+
+func TestRoutePolicyExample(t *testing.T) {
+	limiters := make([]*RateLimiter, 0, 3)
+	for _, name := range []string{"posts", "sms", "client_errors"} {
+		limiter, err := NewRateLimiter(nil, name, RateLimitPolicy{Requests: 1, WindowMinutes: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		limiters = append(limiters, limiter)
+	}
+	if _, err := LoadRoutePolicies("route-policy.example.json", "secret", limiters); err != nil {
+		t.Errorf("example route policies don't load: %v", err)
+	}
+}
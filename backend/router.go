@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// methods routes a path's requests by method. HEAD is answered by the GET
+// handler, whose body the server drops, and OPTIONS and 405 responses list
+// the methods in an Allow header, so neither is kept by hand per route.
+type methods map[string]http.HandlerFunc
+
+// allow is the Allow header value for the path.
+func (m methods) allow() string {
+	allowed := []string{http.MethodOptions}
+	for method := range m {
+		allowed = append(allowed, method)
+	}
+	if _, ok := m[http.MethodGet]; ok && !slices.Contains(allowed, http.MethodHead) {
+		allowed = append(allowed, http.MethodHead)
+	}
+	slices.Sort(allowed)
+	return strings.Join(allowed, ", ")
+}
+
+func (m methods) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if f, ok := m[r.Method]; ok {
+		f(w, r)
+		return
+	}
+	switch r.Method {
+	case http.MethodHead:
+		if f, ok := m[http.MethodGet]; ok {
+			f(w, r)
+			return
+		}
+	case http.MethodOptions:
+		w.Header().Set("Allow", m.allow())
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	w.Header().Set("Allow", m.allow())
+	http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMethods(t *testing.T) {
+	route := methods{
+		"GET": func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Handled-By", "get")
+			w.WriteHeader(http.StatusOK)
+		},
+		"POST": func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusCreated)
+		},
+	}
+
+	tests := []struct {
+		method    string
+		status    int
+		wantAllow string
+	}{
+		{method: "GET", status: 200},
+		{method: "POST", status: 201},
+		{method: "HEAD", status: 200},
+		{method: "OPTIONS", status: 200, wantAllow: "GET, HEAD, OPTIONS, POST"},
+		{method: "DELETE", status: 405, wantAllow: "GET, HEAD, OPTIONS, POST"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			route.ServeHTTP(rec, httptest.NewRequest(tt.method, "/api/things", nil))
+
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d", rec.Code, tt.status)
+			}
+			if got := rec.Header().Get("Allow"); got != tt.wantAllow {
+				t.Errorf("Allow = %q, want %q", got, tt.wantAllow)
+			}
+			if tt.method == "HEAD" && rec.Header().Get("X-Handled-By") != "get" {
+				t.Error("HEAD wasn't answered by the GET handler")
+			}
+		})
+	}
+
+	// Without a GET there's nothing to answer HEAD with
+	rec := httptest.NewRecorder()
+	methods{"PUT": route["POST"]}.ServeHTTP(rec, httptest.NewRequest("HEAD", "/api/things", nil))
+	if rec.Code != 405 || rec.Header().Get("Allow") != "OPTIONS, PUT" {
+		t.Errorf("HEAD without GET: status %d, Allow %q", rec.Code, rec.Header().Get("Allow"))
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Session is a sub-board of an event, such as a day, stage or talk. Posts
+// may attach to a session; an event's listing rolls up all of its sessions.
+type Session struct {
+	ID        int        `json:"id"`
+	EventName string     `json:"event_name"`
+	Name      string     `json:"name"`
+	StartsAt  *time.Time `json:"starts_at,omitempty"`
+	EndsAt    *time.Time `json:"ends_at,omitempty"`
+	Position  int        `json:"position"`
+	PostCount int        `json:"post_count"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+type CreateSessionRequest struct {
+	Name     string     `json:"name"`
+	StartsAt *time.Time `json:"starts_at"`
+	EndsAt   *time.Time `json:"ends_at"`
+	Position int        `json:"position"`
+}
+
+// GetSessions handles GET /api/events/{event}/sessions
+func (h *Handler) GetSessions(w http.ResponseWriter, r *http.Request) {
+	sessions, err := h.db.GetSessions(r.Context(), r.PathValue("event"))
+	if err != nil {
+		log.Printf("Error getting sessions: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve sessions")
+		return
+	}
+
+	if sessions == nil {
+		sessions = []Session{}
+	}
+
+	respondWithJSON(w, http.StatusOK, sessions)
+}
+
+// CreateSession handles POST /admin/events/{event}/sessions
+func (h *Handler) CreateSession(w http.ResponseWriter, r *http.Request) {
+	var req CreateSessionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		respondWithError(w, http.StatusBadRequest, "name is required")
+		return
+	}
+	if len(req.Name) > 200 {
+		respondWithError(w, http.StatusBadRequest, "name must be 200 characters or less")
+		return
+	}
+	if req.StartsAt != nil && req.EndsAt != nil && req.EndsAt.Before(*req.StartsAt) {
+		respondWithError(w, http.StatusBadRequest, "ends_at must not be before starts_at")
+		return
+	}
+
+	session, err := h.db.CreateSession(r.Context(), r.PathValue("event"), req)
+	if err != nil {
+		log.Printf("Error creating session: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to create session")
+		return
+	}
+	if session == nil {
+		respondWithError(w, http.StatusNotFound, "Event not found")
+		return
+	}
+
+	h.audit(r, "event.create_session", "event", session.EventName, session)
+
+	respondWithJSON(w, http.StatusCreated, session)
+}
+
+// DeleteSession handles DELETE /admin/events/{event}/sessions/{id}. Posts in
+// the session stay on the event's board.
+func (h *Handler) DeleteSession(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid session ID")
+		return
+	}
+
+	found, err := h.db.DeleteSession(r.Context(), r.PathValue("event"), id)
+	if err != nil {
+		log.Printf("Error deleting session: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to delete session")
+		return
+	}
+	if !found {
+		respondWithError(w, http.StatusNotFound, "Session not found")
+		return
+	}
+
+	h.audit(r, "event.delete_session", "event", r.PathValue("event"), map[string]interface{}{
+		"session_id": id,
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// checkPostSession verifies that a post's session belongs to its event.
+func (h *Handler) checkPostSession(ctx context.Context, req CreatePostRequest) error {
+	if req.SessionID == nil {
+		return nil
+	}
+
+	session, err := h.db.GetSession(ctx, *req.SessionID)
+	if err != nil {
+		return err
+	}
+	if session == nil || session.EventName != req.EventName {
+		return &ValidationError{Message: "session_id is not a session of this event", Rule: "session_id.invalid"}
+	}
+
+	return nil
+}
+
+const sessionColumns = `s.id, s.event_name, s.name, s.starts_at, s.ends_at, s.position, s.created_at,
+	(SELECT COUNT(*) FROM posts p WHERE p.session_id = s.id)`
+
+func scanSession(row rowScanner) (*Session, error) {
+	var s Session
+	err := row.Scan(&s.ID, &s.EventName, &s.Name, &s.StartsAt, &s.EndsAt, &s.Position, &s.CreatedAt, &s.PostCount)
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// GetSessions lists an event's sessions in schedule order
+func (db *DB) GetSessions(ctx context.Context, eventName string) ([]Session, error) {
+	query := `
+		SELECT ` + sessionColumns + `
+		FROM event_sessions s
+		WHERE s.event_name = $1
+		ORDER BY s.position, s.starts_at NULLS LAST, s.id
+	`
+
+	rows, err := db.conn.QueryContext(ctx, query, eventName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query sessions: %w", err)
+	}
+	defer rows.Close()
+
+	var sessions []Session
+	for rows.Next() {
+		s, err := scanSession(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan session: %w", err)
+		}
+		sessions = append(sessions, *s)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating sessions: %w", err)
+	}
+
+	return sessions, nil
+}
+
+// GetSession retrieves a session, returning nil if it does not exist
+func (db *DB) GetSession(ctx context.Context, id int) (*Session, error) {
+	query := `SELECT ` + sessionColumns + ` FROM event_sessions s WHERE s.id = $1`
+
+	s, err := scanSession(db.conn.QueryRowContext(ctx, query, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get session: %w", err)
+	}
+	return s, nil
+}
+
+// CreateSession adds a session to an event, returning nil if the event does
+// not exist.
+func (db *DB) CreateSession(ctx context.Context, eventName string, req CreateSessionRequest) (*Session, error) {
+	query := `
+		WITH s AS (
+			INSERT INTO event_sessions (event_name, name, starts_at, ends_at, position)
+			SELECT name, $2, $3, $4, $5 FROM events WHERE name = $1
+			RETURNING *
+		)
+		SELECT s.id, s.event_name, s.name, s.starts_at, s.ends_at, s.position, s.created_at, 0
+		FROM s
+	`
+
+	s, err := scanSession(db.conn.QueryRowContext(ctx, query, eventName, req.Name, req.StartsAt, req.EndsAt, req.Position))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create session: %w", err)
+	}
+	return s, nil
+}
+
+// DeleteSession removes a session, reporting whether it existed. Its posts
+// are detached by the foreign key.
+func (db *DB) DeleteSession(ctx context.Context, eventName string, id int) (bool, error) {
+	result, err := db.conn.ExecContext(ctx,
+		"DELETE FROM event_sessions WHERE id = $1 AND event_name = $2",
+		id, eventName,
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete session: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to delete session: %w", err)
+	}
+
+	return affected > 0, nil
+}
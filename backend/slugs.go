@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// eventLookup is an event found by name, slug, former slug or former name.
+type eventLookup struct {
+	Name string
+	Slug string
+	// Moved is set when the reference was a former slug or name, and
+	// clients should be sent to the current slug.
+	Moved bool
+}
+
+// withEvent resolves the {event} path value of an event-scoped route, which
+// may be an event's name or slug. Current names and slugs are rewritten to
+// the name so handlers only deal in names; former slugs and names (after a
+// rename or merge) redirect to the current slug. Unknown values pass through
+// unchanged for the handler to report.
+func (h *Handler) withEvent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Preflight requests can't follow redirects
+		if r.Method == "OPTIONS" {
+			next(w, r)
+			return
+		}
+
+		ref := r.PathValue("event")
+
+		lookup, err := h.db.LookupEvent(r.Context(), ref)
+		if err != nil {
+			log.Printf("Error looking up event: %v", err)
+			respondWithError(w, http.StatusInternalServerError, "Failed to retrieve event")
+			return
+		}
+
+		if lookup != nil {
+			if lookup.Moved {
+				redirectEventRef(w, r, ref, lookup.Slug)
+				return
+			}
+			r.SetPathValue("event", lookup.Name)
+		}
+
+		next(w, r)
+	}
+}
+
+// redirectEventRef redirects to the same URL with the event segment replaced
+// by slug. Requests other than GET and HEAD get a 308 so clients repeat the
+// method and body.
+func redirectEventRef(w http.ResponseWriter, r *http.Request, ref, slug string) {
+	segments := strings.Split(r.URL.EscapedPath(), "/")
+	for i, segment := range segments {
+		if unescaped, err := url.PathUnescape(segment); err == nil && unescaped == ref {
+			segments[i] = url.PathEscape(slug)
+			break
+		}
+	}
+
+	target := strings.Join(segments, "/")
+	if r.URL.RawQuery != "" {
+		target += "?" + r.URL.RawQuery
+	}
+
+	status := http.StatusPermanentRedirect
+	if r.Method == "GET" || r.Method == "HEAD" {
+		status = http.StatusMovedPermanently
+	}
+	http.Redirect(w, r, target, status)
+}
+
+// LookupEvent finds an event by name, slug, former slug or former name, in
+// that order of precedence, and last by a name that slugifies to an event's
+// slug, so "woodstock 99" finds the board of "Woodstock '99". It returns nil
+// if nothing matches.
+func (db *DB) LookupEvent(ctx context.Context, ref string) (*eventLookup, error) {
+	query := `
+		SELECT name, slug, moved FROM (
+			SELECT 1 AS priority, name, slug, FALSE AS moved FROM events WHERE name = $1
+			UNION ALL
+			SELECT 2, name, slug, FALSE FROM events WHERE slug = $1
+			UNION ALL
+			SELECT 3, e.name, e.slug, TRUE
+			FROM event_slug_history h JOIN events e ON e.id = h.event_id
+			WHERE h.slug = $1
+			UNION ALL
+			SELECT 4, e.name, e.slug, TRUE
+			FROM event_aliases a JOIN events e ON e.name = a.event_name
+			WHERE a.alias = $1
+			UNION ALL
+			-- Only for names in plain ASCII with a letter or digit: the slug
+			-- drops other characters, which would group "東京 2024" with
+			-- "大阪 2024"
+			SELECT 5, name, slug, TRUE FROM events
+			WHERE slug = event_slug_base($1) AND $1 !~ '[^ -~]' AND $1 ~ '[A-Za-z0-9]'
+		) matches
+		ORDER BY priority
+		LIMIT 1
+	`
+
+	var lookup eventLookup
+	err := db.conn.QueryRowContext(ctx, query, ref).Scan(&lookup.Name, &lookup.Slug, &lookup.Moved)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up event: %w", err)
+	}
+	return &lookup, nil
+}
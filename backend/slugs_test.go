@@ -0,0 +1,32 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestLookupEventBySlugifiedName(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+
+	n := time.Now().UnixNano()
+	name := fmt.Sprintf("Woodstock '99 %d", n)
+	if _, err := db.CreatePost(ctx, CreatePostRequest{EventName: name, Content: "hello", Age: 25, Location: "x"}, "slug-test", hashToken("token")); err != nil {
+		t.Fatal(err)
+	}
+
+	lookup, err := db.LookupEvent(ctx, fmt.Sprintf("woodstock 99 %d", n))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lookup == nil || lookup.Name != name || !lookup.Moved {
+		t.Errorf("lookup = %+v, want %q, moved", lookup, name)
+	}
+
+	// Names outside ASCII don't match on what is left of them
+	if lookup, err := db.LookupEvent(ctx, fmt.Sprintf("Woodstöck '99 %d", n)); err != nil || lookup != nil {
+		t.Errorf("lookup of a non-ASCII variant = %+v, %v, want nil", lookup, err)
+	}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxSMSContentLength caps SMS posts at the length of a long concatenated
+// message; anything bigger is almost certainly not a person typing.
+const maxSMSContentLength = 1600
+
+// SMSGateway receives inbound messages from a Twilio-style SMS provider and
+// turns messages of the form "EVENTCODE: message" into posts.
+type SMSGateway struct {
+	db         *DB
+	federation *Federation
+	authToken  string
+	webhookURL string
+	limiter    *RateLimiter
+	caps       *PostingCaps
+	toxicity   *Toxicity
+	broker     *PostBroker
+	hooks      *PostHooks
+	hotTier    *HotTier
+	// inviteOnly turns away every text, as there's no way to send an
+	// invite code with one
+	inviteOnly bool
+}
+
+func NewSMSGateway(db *DB, federation *Federation, authToken, webhookURL string, limiter *RateLimiter, caps *PostingCaps, toxicity *Toxicity, broker *PostBroker, hooks *PostHooks, hotTier *HotTier, inviteOnly bool) *SMSGateway {
+	return &SMSGateway{
+		db:         db,
+		federation: federation,
+		authToken:  authToken,
+		webhookURL: webhookURL,
+		limiter:    limiter,
+		caps:       caps,
+		toxicity:   toxicity,
+		broker:     broker,
+		hooks:      hooks,
+		hotTier:    hotTier,
+		inviteOnly: inviteOnly,
+	}
+}
+
+// Inbound handles POST /api/sms/inbound
+func (g *SMSGateway) Inbound(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form body", http.StatusBadRequest)
+		return
+	}
+
+	if !g.validSignature(r) {
+		http.Error(w, "Invalid signature", http.StatusForbidden)
+		return
+	}
+
+	from := strings.TrimSpace(r.PostForm.Get("From"))
+	if from == "" {
+		http.Error(w, "Missing sender", http.StatusBadRequest)
+		return
+	}
+	phoneHash := hashPhone(from)
+
+	code, message, ok := parseSMSBody(r.PostForm.Get("Body"))
+	if !ok {
+		respondWithTwiML(w, "To post, text EVENTCODE: your message")
+		return
+	}
+	if len(message) > maxSMSContentLength {
+		respondWithTwiML(w, fmt.Sprintf("Your message is too long. Keep it under %d characters.", maxSMSContentLength))
+		return
+	}
+
+	eventName, err := g.eventName(r.Context(), code)
+	if err != nil {
+		log.Printf("Error finding SMS event: %v", err)
+		respondWithTwiML(w, "Something went wrong. Please try again later.")
+		return
+	}
+	if eventName == "" {
+		respondWithTwiML(w, fmt.Sprintf("We couldn't find an event called %q.", code))
+		return
+	}
+
+	// Invite-only boards take posts with invite codes, which texts can't carry
+	settings, err := g.db.GetEventSettings(r.Context(), eventName)
+	if err != nil {
+		log.Printf("Error getting SMS event settings: %v", err)
+		respondWithTwiML(w, "Something went wrong. Please try again later.")
+		return
+	}
+	if g.inviteOnly || settings.InviteOnly {
+		respondWithTwiML(w, fmt.Sprintf("%s isn't open to text posts yet.", eventName))
+		return
+	}
+
+	if err := checkPostingWindow(settings, time.Now()); err != nil {
+		respondWithTwiML(w, err.Error())
+		return
+	}
+
+	banned, err := g.db.IsAuthorBanned(r.Context(), phoneHash)
+	if err != nil {
+		log.Printf("Error checking SMS author ban: %v", err)
+		respondWithTwiML(w, "Something went wrong. Please try again later.")
+		return
+	}
+	if banned {
+		respondWithTwiML(w, "You can't post here.")
+		return
+	}
+
+	// Texters are counted against the event's device limit by number
+	if settings.DevicePostLimit > 0 {
+		count, err := g.db.CountDevicePosts(r.Context(), eventName, "", phoneHash)
+		if err != nil {
+			log.Printf("Error counting SMS posts: %v", err)
+			respondWithTwiML(w, "Something went wrong. Please try again later.")
+			return
+		}
+		if count >= settings.DevicePostLimit {
+			respondWithTwiML(w, (&DeviceQuotaError{Limit: settings.DevicePostLimit}).Error())
+			return
+		}
+	}
+
+	reservation, ok, err := g.limiter.Reserve(r.Context(), phoneHash)
+	if err != nil {
+		log.Printf("Error checking SMS rate limit: %v", err)
+		respondWithTwiML(w, "Something went wrong. Please try again later.")
+		return
+	}
+	if !ok {
+		respondWithTwiML(w, g.limiter.exceededMessage())
+		return
+	}
+
+	if _, _, ok := g.caps.allow(eventName); !ok {
+		g.limiter.Release(context.WithoutCancel(r.Context()), reservation)
+		respondWithTwiML(w, "We're receiving a lot of posts right now. Please try again in a few minutes.")
+		return
+	}
+
+	req := CreatePostRequest{
+		EventName: eventName,
+		Content:   message,
+		Location:  "SMS",
+	}
+	if err := g.hooks.Create(r.Context(), &req); err != nil {
+		g.limiter.Release(context.WithoutCancel(r.Context()), reservation)
+		respondWithTwiML(w, err.Error())
+		return
+	}
+	var post *Post
+	err = g.db.WithTx(r.Context(), func(tx Store) error {
+		var err error
+		post, err = tx.CreatePost(r.Context(), req, phoneHash, "")
+		if err != nil {
+			return err
+		}
+		return screenPost(r.Context(), tx, post)
+	})
+	if err != nil {
+		log.Printf("Error creating SMS post: %v", err)
+		g.limiter.Release(context.WithoutCancel(r.Context()), reservation)
+		respondWithTwiML(w, "Something went wrong. Please try again later.")
+		return
+	}
+	g.toxicity.Enqueue(*post)
+	g.federation.PublishPost(*post)
+	g.broker.Publish(*post)
+	g.hooks.Publish(*post)
+	g.hotTier.invalidate(post.EventName)
+
+	respondWithTwiML(w, fmt.Sprintf("Posted to %s.", eventName))
+}
+
+// eventName resolves an event code as posts from the web are, so old names,
+// aliases and slugs of renamed and merged events reach the current board.
+// Texters don't mind case, so a code that matches nothing else is matched
+// case-insensitively against the events posted to.
+func (g *SMSGateway) eventName(ctx context.Context, code string) (string, error) {
+	lookup, err := g.db.LookupEvent(ctx, code)
+	if err != nil {
+		return "", err
+	}
+	if lookup != nil {
+		return lookup.Name, nil
+	}
+	return g.db.FindEventName(ctx, code)
+}
+
+// validSignature checks the X-Twilio-Signature header: a base64 HMAC-SHA1,
+// keyed with the auth token, of the webhook URL followed by every POST
+// parameter name and value sorted by name.
+func (g *SMSGateway) validSignature(r *http.Request) bool {
+	signature := r.Header.Get("X-Twilio-Signature")
+	if signature == "" {
+		return false
+	}
+
+	keys := make([]string, 0, len(r.PostForm))
+	for key := range r.PostForm {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var payload strings.Builder
+	payload.WriteString(g.requestURL(r))
+	for _, key := range keys {
+		for _, value := range r.PostForm[key] {
+			payload.WriteString(key)
+			payload.WriteString(value)
+		}
+	}
+
+	mac := hmac.New(sha1.New, []byte(g.authToken))
+	mac.Write([]byte(payload.String()))
+	expected := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
+
+// requestURL returns the URL the provider signed. Behind a proxy the
+// request only carries a path, so the public URL should be configured.
+func (g *SMSGateway) requestURL(r *http.Request) string {
+	if g.webhookURL != "" {
+		return g.webhookURL
+	}
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + r.URL.RequestURI()
+}
+
+// parseSMSBody splits "EVENTCODE: message" into its two parts.
+func parseSMSBody(body string) (code, message string, ok bool) {
+	code, message, found := strings.Cut(body, ":")
+	if !found {
+		return "", "", false
+	}
+	code = strings.TrimSpace(code)
+	message = strings.TrimSpace(message)
+	if code == "" || message == "" || len(code) > 200 {
+		return "", "", false
+	}
+	return code, message, true
+}
+
+func hashPhone(phone string) string {
+	hash := sha256.Sum256([]byte(phone + "living-timeline-sms-salt"))
+	return hex.EncodeToString(hash[:])
+}
+
+func respondWithTwiML(w http.ResponseWriter, message string) {
+	var escaped strings.Builder
+	xml.EscapeText(&escaped, []byte(message))
+
+	w.Header().Set("Content-Type", "text/xml")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?><Response><Message>%s</Message></Response>`, escaped.String())
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// TestSMSEventName checks that texts to a renamed event's old code reach
+// the renamed board.
+func TestSMSEventName(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	g := NewSMSGateway(db, nil, "", "", nil, nil, nil, nil, nil, nil, false)
+
+	n := time.Now().UnixNano()
+	oldName, newName := fmt.Sprintf("SMSTEST%d", n), fmt.Sprintf("SMS Test Renamed %d", n)
+	if _, err := db.CreatePost(ctx, CreatePostRequest{EventName: oldName, Content: "hello", Age: 25, Location: "x"}, "sms-test", hashToken("token")); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := g.eventName(ctx, fmt.Sprintf("smstest%d", n)); err != nil || got != oldName {
+		t.Errorf("eventName in lower case = %q, %v; want %q", got, err, oldName)
+	}
+
+	if err := db.RenameEvent(ctx, oldName, newName); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := g.eventName(ctx, oldName); err != nil || got != newName {
+		t.Errorf("eventName of the old name = %q, %v; want %q", got, err, newName)
+	}
+	if got, err := g.eventName(ctx, fmt.Sprintf("nowhere%d", n)); err != nil || got != "" {
+		t.Errorf("eventName of an unknown code = %q, %v; want none", got, err)
+	}
+}
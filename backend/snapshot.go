@@ -0,0 +1,36 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// snapshotHeader carries the snapshot token of a post listing. Pass it back
+// as ?snapshot= when fetching later pages, so posts made in the meantime
+// don't push posts already seen onto the next page.
+const snapshotHeader = "X-Snapshot-Token"
+
+const snapshotPrefix = "s1:"
+
+var errInvalidSnapshot = errors.New("invalid snapshot token")
+
+// snapshotToken pins a listing to the posts up to and including maxID. The
+// token is opaque to clients, so its format can change.
+func snapshotToken(maxID int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(snapshotPrefix + strconv.Itoa(maxID)))
+}
+
+// parseSnapshotToken returns the newest post ID a snapshot token includes.
+func parseSnapshotToken(token string) (int, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return 0, errInvalidSnapshot
+	}
+	id, err := strconv.Atoi(strings.TrimPrefix(string(raw), snapshotPrefix))
+	if err != nil || id < 1 || !strings.HasPrefix(string(raw), snapshotPrefix) {
+		return 0, errInvalidSnapshot
+	}
+	return id, nil
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// speechCharsPerSecond is a conservative speaking rate used to estimate
+	// audio duration before paying for synthesis.
+	speechCharsPerSecond = 14
+	speechRateWindow     = time.Hour
+)
+
+// SpeechSynthesizer is a text-to-speech provider. Audio is returned as MP3.
+type SpeechSynthesizer interface {
+	Synthesize(ctx context.Context, text string) ([]byte, error)
+	Name() string
+}
+
+// NewSpeechSynthesizer builds the provider named by TTS_PROVIDER. It returns
+// nil when text-to-speech is disabled.
+func NewSpeechSynthesizer(kind, apiKey, voice string) (SpeechSynthesizer, error) {
+	client := &http.Client{Timeout: 30 * time.Second}
+
+	switch kind {
+	case "", "none":
+		return nil, nil
+	case "google":
+		if apiKey == "" {
+			return nil, fmt.Errorf("TTS_API_KEY is required for Google")
+		}
+		if voice == "" {
+			voice = "en-US-Neural2-C"
+		}
+		return &GoogleSpeech{apiKey: apiKey, voice: voice, client: client}, nil
+	case "openai":
+		if apiKey == "" {
+			return nil, fmt.Errorf("TTS_API_KEY is required for OpenAI")
+		}
+		if voice == "" {
+			voice = "alloy"
+		}
+		return &OpenAISpeech{apiKey: apiKey, voice: voice, client: client}, nil
+	default:
+		return nil, fmt.Errorf("unknown TTS provider %q", kind)
+	}
+}
+
+// PostAudio serves cached spoken renderings of posts, for screen-reader-free
+// listening. Synthesis is capped by estimated duration and output size, and
+// uncached requests are rate limited per client since each one costs money.
+type PostAudio struct {
+	db          *DB
+	synth       SpeechSynthesizer
+	maxSeconds  int
+	maxBytes    int
+	rateLimit   int
+	mu          sync.Mutex
+	windowStart time.Time
+	counts      map[string]int
+}
+
+func NewPostAudio(db *DB, synth SpeechSynthesizer, maxSeconds, maxBytes, rateLimit int) *PostAudio {
+	return &PostAudio{
+		db:         db,
+		synth:      synth,
+		maxSeconds: maxSeconds,
+		maxBytes:   maxBytes,
+		rateLimit:  rateLimit,
+		counts:     make(map[string]int),
+	}
+}
+
+// Get handles GET /api/posts/{id}/audio
+func (a *PostAudio) Get(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid post ID")
+		return
+	}
+
+	// The post is looked up even for cached audio, so hiding a post hides
+	// its audio too
+	post, err := a.db.GetPostByID(r.Context(), id)
+	if err != nil {
+		log.Printf("Error getting post: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to render audio")
+		return
+	}
+	if post == nil || post.HiddenAt != nil {
+		respondWithError(w, http.StatusNotFound, "Post not found")
+		return
+	}
+
+	audio, err := a.db.GetPostAudio(r.Context(), id)
+	if err != nil {
+		log.Printf("Error getting post audio: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to render audio")
+		return
+	}
+
+	if audio == nil {
+		if len(post.Content)/speechCharsPerSecond > a.maxSeconds {
+			respondWithError(w, http.StatusRequestEntityTooLarge, "Post is too long to render as audio")
+			return
+		}
+
+		remaining, reset, ok := a.allow(computeIPHash(r))
+		setRateLimitHeaders(w, a.rateLimit, remaining, reset)
+		if !ok {
+			respondRateLimited(w, "rate_limited", "Audio rate limit exceeded, try again later", reset)
+			return
+		}
+
+		audio, err = a.synth.Synthesize(r.Context(), post.Content)
+		if err != nil {
+			log.Printf("Error synthesizing post %d: %v", id, err)
+			respondWithError(w, http.StatusBadGateway, "Speech provider failed")
+			return
+		}
+		if len(audio) > a.maxBytes {
+			log.Printf("Audio for post %d is %d bytes, over the %d byte cap", id, len(audio), a.maxBytes)
+			respondWithError(w, http.StatusRequestEntityTooLarge, "Post is too long to render as audio")
+			return
+		}
+
+		if err := a.db.SavePostAudio(r.Context(), id, audio, a.synth.Name()); err != nil {
+			// Still serve it; the next request will synthesize again
+			log.Printf("Error caching post audio: %v", err)
+		}
+	}
+
+	w.Header().Set("Content-Type", "audio/mpeg")
+	w.Header().Set("Content-Length", strconv.Itoa(len(audio)))
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.WriteHeader(http.StatusOK)
+	w.Write(audio)
+}
+
+// allow counts a synthesis against the client's allowance for the current
+// window, returning how many the client has left and when the window
+// resets. Counts are in memory and reset every speechRateWindow.
+func (a *PostAudio) allow(client string) (remaining int, reset time.Duration, ok bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if time.Since(a.windowStart) > speechRateWindow {
+		a.windowStart = time.Now()
+		a.counts = make(map[string]int)
+	}
+
+	reset = speechRateWindow - time.Since(a.windowStart)
+
+	if a.counts[client] >= a.rateLimit {
+		return 0, reset, false
+	}
+	a.counts[client]++
+	return a.rateLimit - a.counts[client], reset, true
+}
+
+// GoogleSpeech uses the Google Cloud Text-to-Speech API.
+type GoogleSpeech struct {
+	apiKey string
+	voice  string
+	client *http.Client
+}
+
+func (s *GoogleSpeech) Name() string { return "google" }
+
+func (s *GoogleSpeech) Synthesize(ctx context.Context, text string) ([]byte, error) {
+	// Voice names start with their language code, e.g. en-US-Neural2-C
+	lang := s.voice
+	if len(lang) >= 5 {
+		lang = lang[:5]
+	}
+
+	payload, err := json.Marshal(map[string]interface{}{
+		"input":       map[string]string{"text": text},
+		"voice":       map[string]string{"languageCode": lang, "name": s.voice},
+		"audioConfig": map[string]string{"audioEncoding": "MP3"},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode speech request: %w", err)
+	}
+
+	endpoint := "https://texttospeech.googleapis.com/v1/text:synthesize?key=" + url.QueryEscape(s.apiKey)
+	body, err := postSpeechRequest(ctx, s.client, endpoint, nil, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp struct {
+		AudioContent string `json:"audioContent"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("failed to decode speech response: %w", err)
+	}
+	return base64.StdEncoding.DecodeString(resp.AudioContent)
+}
+
+// OpenAISpeech uses the OpenAI audio speech API.
+type OpenAISpeech struct {
+	apiKey string
+	voice  string
+	client *http.Client
+}
+
+func (s *OpenAISpeech) Name() string { return "openai" }
+
+func (s *OpenAISpeech) Synthesize(ctx context.Context, text string) ([]byte, error) {
+	payload, err := json.Marshal(map[string]string{
+		"model":           "tts-1",
+		"voice":           s.voice,
+		"input":           text,
+		"response_format": "mp3",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode speech request: %w", err)
+	}
+
+	return postSpeechRequest(ctx, s.client, "https://api.openai.com/v1/audio/speech", map[string]string{
+		"Authorization": "Bearer " + s.apiKey,
+	}, payload)
+}
+
+// maxSpeechResponseBytes bounds what is read from a provider, independently
+// of the configured cap, so a misbehaving provider can't exhaust memory.
+const maxSpeechResponseBytes = 32 << 20
+
+func postSpeechRequest(ctx context.Context, client *http.Client, endpoint string, headers map[string]string, payload []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return nil, fmt.Errorf("failed to build speech request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send speech request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxSpeechResponseBytes))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read speech response: %w", err)
+	}
+	if resp.StatusCode >= 300 {
+		if len(body) > 512 {
+			body = body[:512]
+		}
+		return nil, fmt.Errorf("speech provider returned status %d: %s", resp.StatusCode, body)
+	}
+	return body, nil
+}
+
+// GetPostAudio returns cached audio for a post, or nil if there is none
+func (db *DB) GetPostAudio(ctx context.Context, postID int) ([]byte, error) {
+	var audio []byte
+	err := db.conn.QueryRowContext(ctx, "SELECT audio FROM post_audio WHERE post_id = $1", postID).Scan(&audio)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get post audio: %w", err)
+	}
+	return audio, nil
+}
+
+func (db *DB) SavePostAudio(ctx context.Context, postID int, audio []byte, provider string) error {
+	_, err := db.conn.ExecContext(ctx, `
+		INSERT INTO post_audio (post_id, audio, provider)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (post_id) DO NOTHING
+	`, postID, audio, provider)
+	if err != nil {
+		return fmt.Errorf("failed to save post audio: %w", err)
+	}
+	return nil
+}
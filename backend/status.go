@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	statusWindow      = 15 * time.Minute
+	statusCacheTTL    = 15 * time.Second
+	statusPingTimeout = 2 * time.Second
+)
+
+// Component and overall statuses, from best to worst
+const (
+	statusOperational = "operational"
+	statusDegraded    = "degraded"
+	statusOutage      = "outage"
+)
+
+// Request health thresholds over statusWindow
+const (
+	degradedErrorRate = 0.02
+	outageErrorRate   = 0.25
+	degradedP95       = 2 * time.Second
+)
+
+// ComponentStatus is the health of one part of the service.
+type ComponentStatus struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+}
+
+// StatusLatency holds request latency percentiles in milliseconds.
+type StatusLatency struct {
+	P50 int64 `json:"p50"`
+	P95 int64 `json:"p95"`
+	P99 int64 `json:"p99"`
+}
+
+// StatusReport is the public status page data. It holds rates and health
+// only, never request counts, paths or error messages.
+type StatusReport struct {
+	Status        string            `json:"status"`
+	WindowMinutes int               `json:"window_minutes"`
+	ErrorRate     float64           `json:"error_rate"`
+	Latency       StatusLatency     `json:"latency_ms"`
+	Components    []ComponentStatus `json:"components"`
+	UpdatedAt     time.Time         `json:"updated_at"`
+}
+
+// StatusPage reports service health from this process's request metrics and
+// a database ping. Reports are cached for statusCacheTTL so polling status
+// pages can't add load during an incident.
+type StatusPage struct {
+	db      Store
+	metrics *Metrics
+	queue   *WriteQueue
+
+	mu     sync.Mutex
+	cached *StatusReport
+}
+
+func NewStatusPage(db Store, metrics *Metrics, queue *WriteQueue) *StatusPage {
+	return &StatusPage{db: db, metrics: metrics, queue: queue}
+}
+
+// requestStatus classifies request metrics.
+func requestStatus(m MetricsSnapshot) string {
+	switch {
+	case m.ErrorRate >= outageErrorRate:
+		return statusOutage
+	case m.ErrorRate >= degradedErrorRate || m.P95 >= degradedP95:
+		return statusDegraded
+	default:
+		return statusOperational
+	}
+}
+
+// worstStatus is the overall status of the given components.
+func worstStatus(components []ComponentStatus) string {
+	rank := map[string]int{statusOperational: 0, statusDegraded: 1, statusOutage: 2}
+	worst := statusOperational
+	for _, c := range components {
+		if rank[c.Status] > rank[worst] {
+			worst = c.Status
+		}
+	}
+	return worst
+}
+
+func (s *StatusPage) report(ctx context.Context, now time.Time) *StatusReport {
+	m := s.metrics.Snapshot(statusWindow, now)
+
+	database := statusOperational
+	// A cancelled request must not cache an outage
+	pingCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), statusPingTimeout)
+	defer cancel()
+	if err := s.db.Ping(pingCtx); err != nil {
+		database = statusOutage
+	} else if s.db.Saturated() {
+		database = statusDegraded
+	}
+
+	// Queued posts are accepted but not yet visible
+	posting := statusOperational
+	if database == statusOutage {
+		posting = statusOutage
+	} else if s.queue.pending() > 0 {
+		posting = statusDegraded
+	}
+
+	components := []ComponentStatus{
+		{Name: "api", Status: requestStatus(m)},
+		{Name: "database", Status: database},
+		{Name: "posting", Status: posting},
+	}
+	return &StatusReport{
+		Status:        worstStatus(components),
+		WindowMinutes: int(statusWindow / time.Minute),
+		ErrorRate:     m.ErrorRate,
+		Latency: StatusLatency{
+			P50: m.P50.Milliseconds(),
+			P95: m.P95.Milliseconds(),
+			P99: m.P99.Milliseconds(),
+		},
+		Components: components,
+		UpdatedAt:  now.UTC(),
+	}
+}
+
+// Get handles GET /api/status
+func (s *StatusPage) Get(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+
+	s.mu.Lock()
+	if s.cached == nil || now.Sub(s.cached.UpdatedAt) >= statusCacheTTL {
+		s.cached = s.report(r.Context(), now)
+	}
+	report := s.cached
+	s.mu.Unlock()
+
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(statusCacheTTL.Seconds())))
+	respondWithJSON(w, http.StatusOK, report)
+}
+
+// Ping checks the database can be reached.
+func (db *DB) Ping(ctx context.Context) error {
+	return db.pool.PingContext(ctx)
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMetricsSnapshot(t *testing.T) {
+	m := NewMetrics()
+	now := time.Date(2026, 7, 1, 12, 30, 0, 0, time.UTC)
+
+	for i := 0; i < 90; i++ {
+		m.Record(200, 20*time.Millisecond, now)
+	}
+	for i := 0; i < 8; i++ {
+		m.Record(404, 400*time.Millisecond, now.Add(-5*time.Minute))
+	}
+	m.Record(500, 3*time.Second, now.Add(-time.Minute))
+	m.Record(503, time.Minute, now)
+	// Outside the window, and too old to keep
+	m.Record(500, time.Second, now.Add(-20*time.Minute))
+	m.Record(500, time.Second, now.Add(-metricsRetention))
+
+	got := m.Snapshot(15*time.Minute, now)
+	want := MetricsSnapshot{
+		Requests:  100,
+		ErrorRate: 0.02,
+		P50:       25 * time.Millisecond,
+		P95:       500 * time.Millisecond,
+		P99:       5 * time.Second,
+	}
+	if got != want {
+		t.Errorf("Snapshot = %+v, want %+v", got, want)
+	}
+
+	if empty := m.Snapshot(15*time.Minute, now.Add(2*time.Hour)); empty != (MetricsSnapshot{}) {
+		t.Errorf("Snapshot of a quiet window = %+v, want zero", empty)
+	}
+}
+
+func TestMetricsMinutes(t *testing.T) {
+	m := NewMetrics()
+	now := time.Date(2026, 7, 1, 12, 30, 20, 0, time.UTC)
+
+	m.Record(200, time.Millisecond, now)
+	m.Record(429, time.Millisecond, now)
+	m.Record(500, time.Millisecond, now.Add(-2*time.Minute))
+	// Outside the window
+	m.Record(200, time.Millisecond, now.Add(-3*time.Minute))
+
+	got := m.Minutes(3*time.Minute, now)
+	want := []MetricsMinute{
+		{Minute: time.Date(2026, 7, 1, 12, 28, 0, 0, time.UTC), Requests: 1, Errors: 1},
+		{Minute: time.Date(2026, 7, 1, 12, 29, 0, 0, time.UTC)},
+		{Minute: time.Date(2026, 7, 1, 12, 30, 0, 0, time.UTC), Requests: 2, RateLimited: 1},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Minutes = %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if !got[i].Minute.Equal(want[i].Minute) || got[i].Requests != want[i].Requests ||
+			got[i].Errors != want[i].Errors || got[i].RateLimited != want[i].RateLimited {
+			t.Errorf("Minutes[%d] = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestStatusPage(t *testing.T) {
+	tests := []struct {
+		name       string
+		setup      func(*fakeStore, *Metrics)
+		status     string
+		components map[string]string
+	}{
+		{
+			name:       "quiet",
+			status:     statusOperational,
+			components: map[string]string{"api": statusOperational, "database": statusOperational, "posting": statusOperational},
+		},
+		{
+			name: "slow",
+			setup: func(s *fakeStore, m *Metrics) {
+				for i := 0; i < 10; i++ {
+					m.Record(200, 3*time.Second, time.Now())
+				}
+			},
+			status:     statusDegraded,
+			components: map[string]string{"api": statusDegraded, "database": statusOperational, "posting": statusOperational},
+		},
+		{
+			name:       "saturated",
+			setup:      func(s *fakeStore, m *Metrics) { s.saturated = true },
+			status:     statusDegraded,
+			components: map[string]string{"api": statusOperational, "database": statusDegraded, "posting": statusOperational},
+		},
+		{
+			name: "database down",
+			setup: func(s *fakeStore, m *Metrics) {
+				s.fail["Ping"] = true
+				for i := 0; i < 10; i++ {
+					m.Record(500, time.Millisecond, time.Now())
+				}
+			},
+			status:     statusOutage,
+			components: map[string]string{"api": statusOutage, "database": statusOutage, "posting": statusOutage},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newFakeStore()
+			metrics := NewMetrics()
+			if tt.setup != nil {
+				tt.setup(store, metrics)
+			}
+			page := NewStatusPage(store, metrics, nil)
+
+			rec := httptest.NewRecorder()
+			page.Get(rec, httptest.NewRequest(http.MethodGet, "/api/status", nil))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200", rec.Code)
+			}
+
+			var report StatusReport
+			if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+				t.Fatal(err)
+			}
+			if report.Status != tt.status {
+				t.Errorf("status = %q, want %q", report.Status, tt.status)
+			}
+			for _, c := range report.Components {
+				if c.Status != tt.components[c.Name] {
+					t.Errorf("%s = %q, want %q", c.Name, c.Status, tt.components[c.Name])
+				}
+			}
+		})
+	}
+}
+
+func TestStatusPageCaches(t *testing.T) {
+	store := newFakeStore()
+	page := NewStatusPage(store, NewMetrics(), nil)
+
+	get := func() StatusReport {
+		rec := httptest.NewRecorder()
+		page.Get(rec, httptest.NewRequest(http.MethodGet, "/api/status", nil))
+		var report StatusReport
+		json.Unmarshal(rec.Body.Bytes(), &report)
+		return report
+	}
+
+	if got := get().Status; got != statusOperational {
+		t.Fatalf("status = %q, want operational", got)
+	}
+	store.fail["Ping"] = true
+	if got := get().Status; got != statusOperational {
+		t.Errorf("status = %q within the cache TTL, want the cached operational", got)
+	}
+}
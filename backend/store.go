@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// Store is the persistence the HTTP handlers depend on. *DB implements it;
+// tests substitute a fake.
+type Store interface {
+	// WithTx runs fn with a Store whose calls share one transaction
+	WithTx(ctx context.Context, fn func(tx Store) error) error
+
+	// Posts
+	CreatePost(ctx context.Context, req CreatePostRequest, ipHash, editTokenHash string) (*Post, error)
+	GetPosts(ctx context.Context, filter PostFilter, limit int, offset int) ([]Post, error)
+	GetPostsAfter(ctx context.Context, filter PostFilter, afterID int, limit int) ([]Post, error)
+	GetPostIDByPublicID(ctx context.Context, publicID string) (int, error)
+	GetPostIDByUUID(ctx context.Context, uuid string) (int, error)
+	GetPostsByPublicIDs(ctx context.Context, publicIDs []string) ([]Post, error)
+	GetPostByID(ctx context.Context, id int) (*Post, error)
+	EditPost(ctx context.Context, id int, tokenHash, content string, versions []int, window time.Duration) (*Post, error)
+	DeletePost(ctx context.Context, id int, tokenHash string) (string, error)
+	GetPostRevisions(ctx context.Context, postID int) ([]PostRevision, error)
+	SetContentWarning(ctx context.Context, postID int, warning string) (bool, error)
+	ReactToPost(ctx context.Context, postID int, ipHash, reactorDevice string) (int, bool, error)
+	CreateComment(ctx context.Context, postID int, req CreateCommentRequest, ipHash, commenterDevice string) (*Comment, error)
+	GetComments(ctx context.Context, postID, limit, offset int) ([]Comment, bool, error)
+	CheckInviteCode(ctx context.Context, code, event string) (bool, error)
+	RedeemInviteCode(ctx context.Context, code, event string) (bool, error)
+	CheckCheckinCode(ctx context.Context, event, code string) (bool, error)
+	UseCheckinCode(ctx context.Context, event, code string) (bool, error)
+	CountDevicePosts(ctx context.Context, event, deviceTokenHash, ipHash string) (int, error)
+
+	// Attachments
+	CreateAttachment(ctx context.Context, a Attachment, ipHash string) (*Attachment, error)
+	GetAttachmentByKey(ctx context.Context, key string) (*Attachment, error)
+	GetRenditionByKey(ctx context.Context, key string) (*Attachment, error)
+	GetAttachmentByID(ctx context.Context, id int) (*Attachment, error)
+	GetPendingAttachments(ctx context.Context, ids []int, ipHash string) ([]Attachment, error)
+	AttachToPost(ctx context.Context, postID int, ids []int) error
+	GetAttachmentsForPosts(ctx context.Context, postIDs []int) (map[int][]Attachment, error)
+
+	// Events
+	GetEvents(ctx context.Context, opts EventListOptions, limit, offset int) ([]string, error)
+	GetEvent(ctx context.Context, name string) (*Event, error)
+	LookupEvent(ctx context.Context, ref string) (*eventLookup, error)
+	GetEventSettings(ctx context.Context, name string) (EventSettings, error)
+	GetEventSettingsByName(ctx context.Context, names []string) (map[string]EventSettings, error)
+	UpdateEventSettings(ctx context.Context, name string, req UpdateEventSettingsRequest, versions []int) (bool, error)
+	SetCustomFields(ctx context.Context, name string, fields CustomFieldSchema) (bool, error)
+	SetPostingWindow(ctx context.Context, name string, window *PostingWindow) (bool, error)
+	SetEventDetails(ctx context.Context, name string, d EventDetails) (bool, error)
+	SetEventTheme(ctx context.Context, name string, t EventTheme) (bool, error)
+	SetEmbedDomains(ctx context.Context, name string, domains []string) (bool, error)
+	SetEventRetentionClass(ctx context.Context, name, class string) (bool, error)
+	RenameEvent(ctx context.Context, oldName, newName string) error
+	MergeEvent(ctx context.Context, source, target string) error
+	GetEventAliases(ctx context.Context, eventName string) ([]EventAlias, error)
+	TrendingEvents(ctx context.Context, since time.Time, limit int) ([]EventSummary, error)
+	UpcomingEvents(ctx context.Context, until time.Time, limit int) ([]EventSummary, error)
+	NearbyEvents(ctx context.Context, lat, lon, radiusKm float64, limit, offset int) ([]EventSummary, error)
+
+	// Sessions and templates
+	GetSession(ctx context.Context, id int) (*Session, error)
+	GetSessions(ctx context.Context, eventName string) ([]Session, error)
+	CreateSession(ctx context.Context, eventName string, req CreateSessionRequest) (*Session, error)
+	DeleteSession(ctx context.Context, eventName string, id int) (bool, error)
+	GetPostTemplate(ctx context.Context, id int) (*PostTemplate, error)
+	GetPostTemplates(ctx context.Context, eventName string) ([]PostTemplate, error)
+	CreatePostTemplate(ctx context.Context, eventName string, req CreatePostTemplateRequest) (*PostTemplate, error)
+	ArchivePostTemplate(ctx context.Context, eventName string, id int) (bool, error)
+
+	// Moderation
+	EnqueueModeration(ctx context.Context, postID int, flag ContentFlag, source string) error
+	GetModerationQueue(ctx context.Context, resolved bool, limit, offset int) ([]ModerationItem, error)
+	CountOpenModerationItems(ctx context.Context) (int, error)
+	ResolveModerationItem(ctx context.Context, id int, resolution, resolvedBy string) (bool, error)
+	GetPostFlags(ctx context.Context, postID int) ([]PostFlag, error)
+	GetPostEvents(ctx context.Context, postID, limit, offset int) ([]PostEvent, error)
+	ReconcilePostCounters(ctx context.Context) (PostCounterDrift, error)
+	BulkModeratePosts(ctx context.Context, req BulkModerationRequest, actor string) (*BulkModerationResult, error)
+	IsAuthorBanned(ctx context.Context, ipHash string) (bool, error)
+	GetAuthorBans(ctx context.Context, limit, offset int) ([]AuthorBan, error)
+	CreateAuthorBan(ctx context.Context, ipHash, bannedBy, reason string) (*AuthorBan, error)
+	DeleteAuthorBan(ctx context.Context, ipHash string) (bool, error)
+	IsEventArchived(ctx context.Context, name string) (bool, error)
+	GetRemovalReasons(ctx context.Context) ([]RemovalReason, error)
+	GetRemovalReason(ctx context.Context, id int) (*RemovalReason, error)
+	CreateRemovalReason(ctx context.Context, req CreateRemovalReasonRequest) (*RemovalReason, error)
+	UpdateRemovalReason(ctx context.Context, id int, message string) (*RemovalReason, error)
+	ArchiveRemovalReason(ctx context.Context, id int) (bool, error)
+	GetRemovalNotice(ctx context.Context, postID int, deviceTokenHash string) (*RemovalReason, error)
+	CreateAppeal(ctx context.Context, postID int, message, statusTokenHash string) (bool, error)
+	GetAppealStatus(ctx context.Context, statusTokenHash string) (*PostStatus, error)
+	ResolveAppeal(ctx context.Context, id int, granted bool, resolvedBy string) (bool, error)
+	GetSiblingPosts(ctx context.Context, postID int, window time.Duration, limit int) ([]Post, error)
+	GetBannedImages(ctx context.Context) ([]BannedImage, error)
+	CreateBannedImage(ctx context.Context, hash imageHash, reason, bannedBy string) (*BannedImage, error)
+	DeleteBannedImage(ctx context.Context, id int) (bool, error)
+	GetToxicityThresholds(ctx context.Context, eventName string) (ToxicityThresholds, error)
+	SetToxicityThresholds(ctx context.Context, eventName string, t ToxicityThresholds) (bool, error)
+	SaveToxicityScore(ctx context.Context, postID int, scorer string, score float64, action string) error
+	HidePost(ctx context.Context, postID int, hiddenBy string) (bool, error)
+	GetToxicityPrecision(ctx context.Context, since time.Time) (*ToxicityPrecision, error)
+
+	// Translations
+	GetTranslation(ctx context.Context, postID int, lang string) (*Translation, error)
+	SaveTranslation(ctx context.Context, t Translation) (*Translation, error)
+
+	// Devices
+	GetDeviceCounts(ctx context.Context, deviceTokenHash string) (*DeviceCounts, error)
+	GetNotifications(ctx context.Context, deviceTokenHash string, unreadOnly bool, limit, offset int) ([]Notification, error)
+	MarkNotificationsRead(ctx context.Context, deviceTokenHash string, ids []int) error
+	GetPreferences(ctx context.Context, deviceTokenHash string) (*Preferences, error)
+	SavePreferences(ctx context.Context, deviceTokenHash string, prefs Preferences) (*Preferences, error)
+	CountEmailAlerts(ctx context.Context, email string) (int, error)
+	CreateEmailAlert(ctx context.Context, alert EmailAlert, confirmTokenHash, unsubscribeTokenHash string) (*EmailAlert, error)
+	ConfirmEmailAlert(ctx context.Context, confirmTokenHash string) (*EmailAlert, error)
+	GetEmailAlertByUnsubscribeToken(ctx context.Context, tokenHash string) (*EmailAlert, error)
+	DeleteEmailAlert(ctx context.Context, unsubscribeTokenHash string) (bool, error)
+
+	// Admin
+	CreateAPIKey(ctx context.Context, req CreateAPIKeyRequest, keyPrefix, keyHash, signingSecret string) (*APIKey, error)
+	ListAPIKeys(ctx context.Context) ([]APIKey, error)
+	RevokeAPIKey(ctx context.Context, id int) (bool, error)
+	GetAPIKeyUsage(ctx context.Context, apiKeyID int, days int) ([]UsageDay, error)
+	CreateLegalHold(ctx context.Context, req CreateLegalHoldRequest, placedBy string) (*LegalHold, error)
+	ListLegalHolds(ctx context.Context, activeOnly bool) ([]LegalHold, error)
+	ReleaseLegalHold(ctx context.Context, id int, releasedBy string) (*LegalHold, error)
+	RecordAudit(ctx context.Context, actor, action, targetType, targetValue string, details interface{}) error
+	GetAuditLog(ctx context.Context, limit, offset int) ([]AuditEntry, error)
+	GetValidationRejections(ctx context.Context, days int) ([]ValidationRejection, error)
+	GetAuditLogForTarget(ctx context.Context, targetType, targetValue string) ([]AuditEntry, error)
+	GetDeadLetters(ctx context.Context, kind string, redriven *bool, limit, offset int) ([]DeadLetter, error)
+	ClaimDeadLetters(ctx context.Context, ids []int) ([]DeadLetter, error)
+	ReleaseDeadLetter(ctx context.Context, id int, errMsg string) error
+	RecordDeadLetter(ctx context.Context, kind, destination string, payload interface{}, errMsg string, attempts int) error
+	GetInviteCodes(ctx context.Context, event string) ([]InviteCode, error)
+	CreateInviteCode(ctx context.Context, req CreateInviteCodeRequest) (*InviteCode, error)
+	UpdateInviteCode(ctx context.Context, id int, req UpdateInviteCodeRequest) (*InviteCode, error)
+	RevokeInviteCode(ctx context.Context, id int) (bool, error)
+	GetCheckinCodes(ctx context.Context, event string, limit, offset int) ([]CheckinCode, error)
+	CreateCheckinCode(ctx context.Context, event, label string, ttl time.Duration) (*CheckinCode, error)
+	RevokeCheckinCode(ctx context.Context, event string, id int) (bool, error)
+	GetActivePromotions(ctx context.Context, event string) ([]Promotion, error)
+	GetPromotions(ctx context.Context, event string, limit, offset int) ([]Promotion, error)
+	CreatePromotion(ctx context.Context, event string, req CreatePromotionRequest) (*Promotion, error)
+	EndPromotion(ctx context.Context, event string, id int) (bool, error)
+	ClearRateViolations(ctx context.Context, key string) (bool, error)
+	CreateStory(ctx context.Context, req SubmitStoryRequest, postID *int, ipHash string) (*Story, error)
+	GetStories(ctx context.Context, status string, limit, offset int) ([]Story, error)
+	ReviewStory(ctx context.Context, id int, status string, content *string, featured *bool) (*Story, error)
+
+	// Health
+	Ping(ctx context.Context) error
+	// Saturated reports whether the connection pool is exhausted
+	Saturated() bool
+}
+
+var _ Store = (*DB)(nil)
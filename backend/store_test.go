@@ -0,0 +1,706 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"slices"
+	"testing"
+	"time"
+)
+
+var errFakeDB = errors.New("database unavailable")
+
+// openTestDB connects to the disposable database in TEST_DATABASE_URL and
+// applies migrations, skipping the test when it isn't set, e.g.
+//
+//	TEST_DATABASE_URL=postgres://localhost/handshake_test go test ./...
+func openTestDB(t *testing.T) *DB {
+	t.Helper()
+	databaseURL := os.Getenv("TEST_DATABASE_URL")
+	if databaseURL == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+
+	db, err := NewDB(databaseURL)
+	if err != nil {
+		t.Fatalf("Failed to connect to test database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	runMigrations(db)
+	return db
+}
+
+// fakeStore is an in-memory Store for handler tests. Methods the tests
+// don't need fall through to the embedded nil Store and panic, so a test
+// that reaches one unexpectedly fails loudly.
+type fakeStore struct {
+	Store
+
+	posts    []Post
+	events   []string
+	event    *Event
+	lookups  map[string]*eventLookup
+	settings map[string]EventSettings
+
+	// fail makes the named method return errFakeDB.
+	fail map[string]bool
+	// saturated is returned by Saturated.
+	saturated bool
+	// banned is returned by IsAuthorBanned.
+	banned bool
+	// archived is returned by IsEventArchived.
+	archived bool
+	// notice is the removal notice GetRemovalNotice returns for
+	// noticeToken.
+	notice      *RemovalReason
+	noticeToken string
+	// appeals maps appeal status token hashes to post IDs.
+	appeals map[string]int
+	// thresholds is returned by GetToxicityThresholds.
+	thresholds ToxicityThresholds
+	// bannedImages is returned by GetBannedImages.
+	bannedImages []BannedImage
+	// counts is returned by GetDeviceCounts.
+	counts DeviceCounts
+	// notifications are returned by GetNotifications.
+	notifications []Notification
+	// preferences are returned by GetPreferences, and set by
+	// SavePreferences.
+	preferences *Preferences
+	// alerts are the email alerts, with the confirmation token hash of
+	// the last one created
+	alerts           []EmailAlert
+	alertConfirmHash string
+	// unsubscribeHashes maps unsubscribe token hashes to alert IDs
+	unsubscribeHashes map[string]int
+	// theme is set by SetEventTheme
+	theme *EventTheme
+	// audited lists the actions recorded in the audit log
+	audited []string
+	// deadLetters are the dead letters, claimed and released in place
+	deadLetters []DeadLetter
+	// invites maps invite codes to the uses they have left
+	invites map[string]int
+	// editTokens maps post IDs to their edit token hashes
+	editTokens map[int]string
+	// checkins maps current check-in codes to the posts made with them
+	checkins map[string]int
+	// promotions are returned by GetActivePromotions
+	promotions []Promotion
+	// polled, if set, is sent how many posts each GetPostsAfter found.
+	polled chan int
+
+	// Arguments of the last calls, for assertions.
+	lastFilter  PostFilter
+	lastLimit   int
+	lastOffset  int
+	lastOptions EventListOptions
+	created     *CreatePostRequest
+	enqueued    []ContentFlag
+	attachment  *Attachment
+	warned      []int
+	hidden      []int
+	toxicity    map[int]string
+	readBy      string
+	readIDs     []int
+}
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{
+		lookups:    make(map[string]*eventLookup),
+		settings:   make(map[string]EventSettings),
+		fail:       make(map[string]bool),
+		appeals:    make(map[string]int),
+		toxicity:   make(map[int]string),
+		invites:    make(map[string]int),
+		checkins:   make(map[string]int),
+		editTokens: make(map[int]string),
+	}
+}
+
+func (s *fakeStore) err(method string) error {
+	if s.fail[method] {
+		return errFakeDB
+	}
+	return nil
+}
+
+// WithTx runs fn on the fake itself, undoing the posts and audit entries fn
+// added if it fails.
+func (s *fakeStore) WithTx(ctx context.Context, fn func(tx Store) error) error {
+	if err := s.err("WithTx"); err != nil {
+		return err
+	}
+	posts, audited, created := slices.Clone(s.posts), slices.Clone(s.audited), s.created
+	if err := fn(s); err != nil {
+		s.posts, s.audited, s.created = posts, audited, created
+		return err
+	}
+	return nil
+}
+
+func (s *fakeStore) CreatePost(ctx context.Context, req CreatePostRequest, ipHash, editTokenHash string) (*Post, error) {
+	if err := s.err("CreatePost"); err != nil {
+		return nil, err
+	}
+	s.created = &req
+	post := Post{
+		ID:               len(s.posts) + 1,
+		EventName:        req.EventName,
+		Content:          req.Content,
+		Gender:           req.Gender,
+		Location:         req.Location,
+		CustomFields:     req.CustomFields,
+		ContentWarning:   req.ContentWarning,
+		VerifiedAttendee: req.VerifiedAttendee,
+	}
+	if req.Age != 0 {
+		age := req.Age
+		post.Age = &age
+	}
+	s.editTokens[post.ID] = editTokenHash
+	s.posts = append(s.posts, post)
+	return &post, nil
+}
+
+func (s *fakeStore) GetPosts(ctx context.Context, filter PostFilter, limit int, offset int) ([]Post, error) {
+	s.lastFilter, s.lastLimit, s.lastOffset = filter, limit, offset
+	if err := s.err("GetPosts"); err != nil {
+		return nil, err
+	}
+	return s.posts, nil
+}
+
+func (s *fakeStore) GetActivePromotions(ctx context.Context, event string) ([]Promotion, error) {
+	if err := s.err("GetActivePromotions"); err != nil {
+		return nil, err
+	}
+	var promotions []Promotion
+	for _, p := range s.promotions {
+		if p.EventName == event {
+			promotions = append(promotions, p)
+		}
+	}
+	return promotions, nil
+}
+
+func (s *fakeStore) GetPostsAfter(ctx context.Context, filter PostFilter, afterID int, limit int) ([]Post, error) {
+	if err := s.err("GetPostsAfter"); err != nil {
+		return nil, err
+	}
+	var posts []Post
+	for _, post := range s.posts {
+		if post.ID > afterID && (filter.Event == "" || post.EventName == filter.Event) {
+			posts = append(posts, post)
+		}
+	}
+	if s.polled != nil {
+		s.polled <- len(posts)
+	}
+	return posts, nil
+}
+
+func (s *fakeStore) GetPostIDByPublicID(ctx context.Context, publicID string) (int, error) {
+	if err := s.err("GetPostIDByPublicID"); err != nil {
+		return 0, err
+	}
+	for _, post := range s.posts {
+		if post.PublicID == publicID {
+			return post.ID, nil
+		}
+	}
+	return 0, nil
+}
+
+func (s *fakeStore) GetPostIDByUUID(ctx context.Context, uuid string) (int, error) {
+	if err := s.err("GetPostIDByUUID"); err != nil {
+		return 0, err
+	}
+	for _, post := range s.posts {
+		if post.UUID == uuid {
+			return post.ID, nil
+		}
+	}
+	return 0, nil
+}
+
+func (s *fakeStore) GetPostsByPublicIDs(ctx context.Context, publicIDs []string) ([]Post, error) {
+	if err := s.err("GetPostsByPublicIDs"); err != nil {
+		return nil, err
+	}
+	var posts []Post
+	for _, post := range s.posts {
+		if containsString(publicIDs, post.PublicID) && post.HiddenAt == nil {
+			posts = append(posts, post)
+		}
+	}
+	return posts, nil
+}
+
+func (s *fakeStore) GetPostByID(ctx context.Context, id int) (*Post, error) {
+	if err := s.err("GetPostByID"); err != nil {
+		return nil, err
+	}
+	for _, post := range s.posts {
+		if post.ID == id {
+			return &post, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *fakeStore) EditPost(ctx context.Context, id int, tokenHash, content string, versions []int, window time.Duration) (*Post, error) {
+	if err := s.err("EditPost"); err != nil {
+		return nil, err
+	}
+	for i := range s.posts {
+		if s.posts[i].ID != id {
+			continue
+		}
+		if window > 0 && time.Since(s.posts[i].CreatedAt) > window {
+			return nil, errEditWindowOver
+		}
+		if versions != nil && !slices.Contains(versions, s.posts[i].Version) {
+			return nil, errVersionMismatch
+		}
+		s.posts[i].Content = content
+		s.posts[i].Version++
+		post := s.posts[i]
+		return &post, nil
+	}
+	return nil, errPostNotFound
+}
+
+func (s *fakeStore) DeletePost(ctx context.Context, id int, tokenHash string) (string, error) {
+	if err := s.err("DeletePost"); err != nil {
+		return "", err
+	}
+	for i, post := range s.posts {
+		if post.ID != id {
+			continue
+		}
+		if s.editTokens[id] == "" || s.editTokens[id] != tokenHash {
+			return "", errEditNotAllowed
+		}
+		s.posts = slices.Delete(s.posts, i, i+1)
+		return post.EventName, nil
+	}
+	return "", errPostNotFound
+}
+
+func (s *fakeStore) GetPostRevisions(ctx context.Context, postID int) ([]PostRevision, error) {
+	return nil, s.err("GetPostRevisions")
+}
+
+// GetSiblingPosts treats every other post as a sibling.
+func (s *fakeStore) GetSiblingPosts(ctx context.Context, postID int, window time.Duration, limit int) ([]Post, error) {
+	if err := s.err("GetSiblingPosts"); err != nil {
+		return nil, err
+	}
+	var siblings []Post
+	for _, post := range s.posts {
+		if post.ID != postID {
+			siblings = append(siblings, post)
+		}
+	}
+	return siblings, nil
+}
+
+func (s *fakeStore) SetContentWarning(ctx context.Context, postID int, warning string) (bool, error) {
+	s.warned = append(s.warned, postID)
+	return true, s.err("SetContentWarning")
+}
+
+func (s *fakeStore) GetAttachmentsForPosts(ctx context.Context, postIDs []int) (map[int][]Attachment, error) {
+	if err := s.err("GetAttachmentsForPosts"); err != nil {
+		return nil, err
+	}
+	return map[int][]Attachment{}, nil
+}
+
+func (s *fakeStore) GetEvents(ctx context.Context, opts EventListOptions, limit, offset int) ([]string, error) {
+	s.lastOptions, s.lastLimit, s.lastOffset = opts, limit, offset
+	if err := s.err("GetEvents"); err != nil {
+		return nil, err
+	}
+	return s.events, nil
+}
+
+func (s *fakeStore) TrendingEvents(ctx context.Context, since time.Time, limit int) ([]EventSummary, error) {
+	return nil, s.err("TrendingEvents")
+}
+
+func (s *fakeStore) GetEvent(ctx context.Context, name string) (*Event, error) {
+	if err := s.err("GetEvent"); err != nil {
+		return nil, err
+	}
+	return s.event, nil
+}
+
+func (s *fakeStore) LookupEvent(ctx context.Context, ref string) (*eventLookup, error) {
+	if err := s.err("LookupEvent"); err != nil {
+		return nil, err
+	}
+	return s.lookups[ref], nil
+}
+
+func (s *fakeStore) GetEventSettings(ctx context.Context, name string) (EventSettings, error) {
+	if err := s.err("GetEventSettings"); err != nil {
+		return EventSettings{}, err
+	}
+	if settings, ok := s.settings[name]; ok {
+		return settings, nil
+	}
+	return defaultEventSettings, nil
+}
+
+func (s *fakeStore) GetEventSettingsByName(ctx context.Context, names []string) (map[string]EventSettings, error) {
+	if err := s.err("GetEventSettingsByName"); err != nil {
+		return nil, err
+	}
+	return s.settings, nil
+}
+
+func (s *fakeStore) Ping(ctx context.Context) error {
+	return s.err("Ping")
+}
+
+func (s *fakeStore) Saturated() bool {
+	return s.saturated
+}
+
+func (s *fakeStore) EnqueueModeration(ctx context.Context, postID int, flag ContentFlag, source string) error {
+	s.enqueued = append(s.enqueued, flag)
+	return s.err("EnqueueModeration")
+}
+
+func (s *fakeStore) IsAuthorBanned(ctx context.Context, ipHash string) (bool, error) {
+	return s.banned, s.err("IsAuthorBanned")
+}
+
+func (s *fakeStore) IsEventArchived(ctx context.Context, name string) (bool, error) {
+	return s.archived, s.err("IsEventArchived")
+}
+
+func (s *fakeStore) GetRemovalNotice(ctx context.Context, postID int, deviceTokenHash string) (*RemovalReason, error) {
+	if err := s.err("GetRemovalNotice"); err != nil {
+		return nil, err
+	}
+	if deviceTokenHash != hashToken(s.noticeToken) {
+		return nil, nil
+	}
+	return s.notice, nil
+}
+
+func (s *fakeStore) CreateAppeal(ctx context.Context, postID int, message, statusTokenHash string) (bool, error) {
+	if err := s.err("CreateAppeal"); err != nil {
+		return false, err
+	}
+	for _, id := range s.appeals {
+		if id == postID {
+			return false, nil
+		}
+	}
+	s.appeals[statusTokenHash] = postID
+	return true, nil
+}
+
+func (s *fakeStore) GetAppealStatus(ctx context.Context, statusTokenHash string) (*PostStatus, error) {
+	if err := s.err("GetAppealStatus"); err != nil {
+		return nil, err
+	}
+	id, ok := s.appeals[statusTokenHash]
+	if !ok {
+		return nil, nil
+	}
+	return &PostStatus{Status: postStatusAppealPending, PostID: id, UpdatedAt: time.Now()}, nil
+}
+
+func (s *fakeStore) CreateAttachment(ctx context.Context, a Attachment, ipHash string) (*Attachment, error) {
+	if err := s.err("CreateAttachment"); err != nil {
+		return nil, err
+	}
+	a.ID = 1
+	s.attachment = &a
+	return &a, nil
+}
+
+func (s *fakeStore) GetBannedImages(ctx context.Context) ([]BannedImage, error) {
+	return s.bannedImages, s.err("GetBannedImages")
+}
+
+func (s *fakeStore) GetToxicityThresholds(ctx context.Context, eventName string) (ToxicityThresholds, error) {
+	return s.thresholds, s.err("GetToxicityThresholds")
+}
+
+func (s *fakeStore) SaveToxicityScore(ctx context.Context, postID int, scorer string, score float64, action string) error {
+	if err := s.err("SaveToxicityScore"); err != nil {
+		return err
+	}
+	s.toxicity[postID] = action
+	return nil
+}
+
+func (s *fakeStore) HidePost(ctx context.Context, postID int, hiddenBy string) (bool, error) {
+	if err := s.err("HidePost"); err != nil {
+		return false, err
+	}
+	s.hidden = append(s.hidden, postID)
+	return true, nil
+}
+
+func (s *fakeStore) GetPostFlags(ctx context.Context, postID int) ([]PostFlag, error) {
+	return nil, s.err("GetPostFlags")
+}
+
+func (s *fakeStore) GetDeviceCounts(ctx context.Context, deviceTokenHash string) (*DeviceCounts, error) {
+	if err := s.err("GetDeviceCounts"); err != nil {
+		return nil, err
+	}
+	counts := s.counts
+	return &counts, nil
+}
+
+func (s *fakeStore) GetNotifications(ctx context.Context, deviceTokenHash string, unreadOnly bool, limit, offset int) ([]Notification, error) {
+	if err := s.err("GetNotifications"); err != nil {
+		return nil, err
+	}
+	var notifications []Notification
+	for _, n := range s.notifications {
+		if !unreadOnly || n.ReadAt == nil {
+			notifications = append(notifications, n)
+		}
+	}
+	return notifications, nil
+}
+
+func (s *fakeStore) MarkNotificationsRead(ctx context.Context, deviceTokenHash string, ids []int) error {
+	if err := s.err("MarkNotificationsRead"); err != nil {
+		return err
+	}
+	s.readBy, s.readIDs = deviceTokenHash, ids
+	return nil
+}
+
+func (s *fakeStore) GetPreferences(ctx context.Context, deviceTokenHash string) (*Preferences, error) {
+	if err := s.err("GetPreferences"); err != nil {
+		return nil, err
+	}
+	return s.preferences, nil
+}
+
+func (s *fakeStore) SavePreferences(ctx context.Context, deviceTokenHash string, prefs Preferences) (*Preferences, error) {
+	if err := s.err("SavePreferences"); err != nil {
+		return nil, err
+	}
+	s.preferences = &prefs
+	return &prefs, nil
+}
+
+func (s *fakeStore) CountEmailAlerts(ctx context.Context, email string) (int, error) {
+	if err := s.err("CountEmailAlerts"); err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, alert := range s.alerts {
+		if alert.Email == email {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (s *fakeStore) CreateEmailAlert(ctx context.Context, alert EmailAlert, confirmTokenHash, unsubscribeTokenHash string) (*EmailAlert, error) {
+	if err := s.err("CreateEmailAlert"); err != nil {
+		return nil, err
+	}
+	alert.ID = len(s.alerts) + 1
+	s.alerts = append(s.alerts, alert)
+	s.alertConfirmHash = confirmTokenHash
+	if s.unsubscribeHashes == nil {
+		s.unsubscribeHashes = make(map[string]int)
+	}
+	s.unsubscribeHashes[unsubscribeTokenHash] = alert.ID
+	return &alert, nil
+}
+
+func (s *fakeStore) ConfirmEmailAlert(ctx context.Context, confirmTokenHash string) (*EmailAlert, error) {
+	if err := s.err("ConfirmEmailAlert"); err != nil {
+		return nil, err
+	}
+	if len(s.alerts) == 0 || confirmTokenHash != s.alertConfirmHash {
+		return nil, nil
+	}
+	alert := &s.alerts[len(s.alerts)-1]
+	alert.Confirmed = true
+	s.alertConfirmHash = ""
+	return alert, nil
+}
+
+func (s *fakeStore) GetEmailAlertByUnsubscribeToken(ctx context.Context, tokenHash string) (*EmailAlert, error) {
+	if err := s.err("GetEmailAlertByUnsubscribeToken"); err != nil {
+		return nil, err
+	}
+	for i, alert := range s.alerts {
+		if id, ok := s.unsubscribeHashes[tokenHash]; ok && alert.ID == id {
+			return &s.alerts[i], nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *fakeStore) DeleteEmailAlert(ctx context.Context, unsubscribeTokenHash string) (bool, error) {
+	if err := s.err("DeleteEmailAlert"); err != nil {
+		return false, err
+	}
+	for i, alert := range s.alerts {
+		if id, ok := s.unsubscribeHashes[unsubscribeTokenHash]; ok && alert.ID == id {
+			s.alerts = append(s.alerts[:i], s.alerts[i+1:]...)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *fakeStore) UpdateEventSettings(ctx context.Context, name string, req UpdateEventSettingsRequest, versions []int) (bool, error) {
+	if err := s.err("UpdateEventSettings"); err != nil {
+		return false, err
+	}
+	if s.event == nil {
+		return false, nil
+	}
+	if versions != nil && !slices.Contains(versions, s.event.Version) {
+		return true, errVersionMismatch
+	}
+	if req.AllAges != nil {
+		s.event.AllAges = *req.AllAges
+	}
+	s.event.Version++
+	return true, nil
+}
+
+func (s *fakeStore) SetEventTheme(ctx context.Context, name string, t EventTheme) (bool, error) {
+	if err := s.err("SetEventTheme"); err != nil {
+		return false, err
+	}
+	if s.event == nil {
+		return false, nil
+	}
+	s.theme = &t
+	s.event.Theme = t
+	return true, nil
+}
+
+func (s *fakeStore) SetEmbedDomains(ctx context.Context, name string, domains []string) (bool, error) {
+	if err := s.err("SetEmbedDomains"); err != nil {
+		return false, err
+	}
+	if s.event == nil {
+		return false, nil
+	}
+	s.event.EmbedDomains = domains
+	return true, nil
+}
+
+func (s *fakeStore) RecordAudit(ctx context.Context, actor, action, targetType, targetValue string, details interface{}) error {
+	s.audited = append(s.audited, action)
+	return s.err("RecordAudit")
+}
+
+func (s *fakeStore) GetAuditLogForTarget(ctx context.Context, targetType, targetValue string) ([]AuditEntry, error) {
+	return nil, s.err("GetAuditLogForTarget")
+}
+
+func (s *fakeStore) ClaimDeadLetters(ctx context.Context, ids []int) ([]DeadLetter, error) {
+	if err := s.err("ClaimDeadLetters"); err != nil {
+		return nil, err
+	}
+	var claimed []DeadLetter
+	for i, letter := range s.deadLetters {
+		if slices.Contains(ids, letter.ID) && letter.RedrivenAt == nil {
+			now := time.Now()
+			s.deadLetters[i].RedrivenAt = &now
+			claimed = append(claimed, s.deadLetters[i])
+		}
+	}
+	return claimed, nil
+}
+
+func (s *fakeStore) ReleaseDeadLetter(ctx context.Context, id int, errMsg string) error {
+	for i, letter := range s.deadLetters {
+		if letter.ID == id {
+			s.deadLetters[i].RedrivenAt = nil
+			s.deadLetters[i].Error = errMsg
+			s.deadLetters[i].Attempts++
+		}
+	}
+	return s.err("ReleaseDeadLetter")
+}
+
+func (s *fakeStore) RecordDeadLetter(ctx context.Context, kind, destination string, payload interface{}, errMsg string, attempts int) error {
+	if err := s.err("RecordDeadLetter"); err != nil {
+		return err
+	}
+	payloadJSON, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	s.deadLetters = append(s.deadLetters, DeadLetter{
+		ID: len(s.deadLetters) + 1, Kind: kind, Destination: destination,
+		Payload: payloadJSON, Error: errMsg, Attempts: attempts, CreatedAt: time.Now(),
+	})
+	return nil
+}
+
+func (s *fakeStore) CheckInviteCode(ctx context.Context, code, event string) (bool, error) {
+	if err := s.err("CheckInviteCode"); err != nil {
+		return false, err
+	}
+	return s.invites[code] > 0, nil
+}
+
+func (s *fakeStore) RedeemInviteCode(ctx context.Context, code, event string) (bool, error) {
+	if err := s.err("RedeemInviteCode"); err != nil {
+		return false, err
+	}
+	if s.invites[code] == 0 {
+		return false, nil
+	}
+	s.invites[code]--
+	return true, nil
+}
+
+func (s *fakeStore) CheckCheckinCode(ctx context.Context, event, code string) (bool, error) {
+	if err := s.err("CheckCheckinCode"); err != nil {
+		return false, err
+	}
+	_, ok := s.checkins[code]
+	return ok, nil
+}
+
+func (s *fakeStore) UseCheckinCode(ctx context.Context, event, code string) (bool, error) {
+	if err := s.err("UseCheckinCode"); err != nil {
+		return false, err
+	}
+	if _, ok := s.checkins[code]; !ok {
+		return false, nil
+	}
+	s.checkins[code]++
+	return true, nil
+}
+
+// CountDevicePosts counts the event's posts, whichever device made them.
+func (s *fakeStore) CountDevicePosts(ctx context.Context, event, deviceTokenHash, ipHash string) (int, error) {
+	if err := s.err("CountDevicePosts"); err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, post := range s.posts {
+		if post.EventName == event {
+			count++
+		}
+	}
+	return count, nil
+}
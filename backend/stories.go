@@ -0,0 +1,323 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Story statuses. Stories wait as pending until a curator publishes or
+// rejects them; only published stories are public.
+const (
+	storyPending   = "pending"
+	storyPublished = "published"
+	storyRejected  = "rejected"
+)
+
+// Curator decisions
+const (
+	storyPublish = "publish"
+	storyReject  = "reject"
+)
+
+const (
+	maxStoryLength          = 2000
+	maxStorySignatureLength = 100
+	// storiesCacheSeconds is how long the public feed may be cached
+	storiesCacheSeconds = 60
+)
+
+// Story is a success story from people who found each other through a
+// board.
+type Story struct {
+	ID      int    `json:"id"`
+	Content string `json:"content"`
+	// Signature is how the story is signed, such as "Sam & Alex"
+	Signature string `json:"signature,omitempty"`
+	// PostID is the public ID of the post the story is about, while that
+	// post is up
+	PostID      string     `json:"post_id,omitempty"`
+	EventName   string     `json:"event_name,omitempty"`
+	Status      string     `json:"status"`
+	Featured    bool       `json:"featured"`
+	CreatedAt   time.Time  `json:"created_at"`
+	PublishedAt *time.Time `json:"published_at,omitempty"`
+}
+
+type SubmitStoryRequest struct {
+	Content   string `json:"content"`
+	Signature string `json:"signature"`
+	// PostID is the public ID of the post the story is about, if any
+	PostID string `json:"post_id"`
+	// Consent must be set: the story may be published, and used to
+	// promote the site
+	Consent bool `json:"consent"`
+}
+
+// ReviewStoryRequest publishes or rejects a story. Curators can trim the
+// content and feature the story as they publish it.
+type ReviewStoryRequest struct {
+	Decision string  `json:"decision"`
+	Content  *string `json:"content"`
+	Featured *bool   `json:"featured"`
+}
+
+func validateStoryContent(content string) error {
+	if content == "" {
+		return &ValidationError{Message: "content is required"}
+	}
+	if len(content) > maxStoryLength {
+		return &ValidationError{Message: fmt.Sprintf("content must be %d characters or less", maxStoryLength)}
+	}
+	return nil
+}
+
+// SubmitStory handles POST /api/stories. The story is queued for curators,
+// and isn't public until one publishes it.
+func (h *Handler) SubmitStory(w http.ResponseWriter, r *http.Request) {
+	var req SubmitStoryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	req.Content = strings.TrimSpace(req.Content)
+	req.Signature = strings.TrimSpace(req.Signature)
+	if err := validateStoryContent(req.Content); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(req.Signature) > maxStorySignatureLength {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("signature must be %d characters or less", maxStorySignatureLength))
+		return
+	}
+	if !req.Consent {
+		respondWithError(w, http.StatusBadRequest, "consent is required for the story to be published")
+		return
+	}
+
+	var postID *int
+	if req.PostID != "" {
+		id := 0
+		if publicIDPattern.MatchString(req.PostID) {
+			var err error
+			id, err = h.db.GetPostIDByPublicID(r.Context(), req.PostID)
+			if err != nil {
+				log.Printf("Error looking up post: %v", err)
+				respondWithError(w, http.StatusInternalServerError, "Failed to submit story")
+				return
+			}
+		}
+		if id == 0 {
+			respondWithError(w, http.StatusBadRequest, "post_id must be the public ID of a post")
+			return
+		}
+		postID = &id
+	}
+
+	story, err := h.db.CreateStory(r.Context(), req, postID, hashIP(getIP(r)))
+	if err != nil {
+		log.Printf("Error creating story: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to submit story")
+		return
+	}
+
+	respondWithJSON(w, http.StatusAccepted, story)
+}
+
+// GetStories handles GET /api/stories, the published stories with the
+// featured ones first.
+func (h *Handler) GetStories(w http.ResponseWriter, r *http.Request) {
+	limit, offset := parsePagination(r)
+
+	stories, err := h.db.GetStories(r.Context(), storyPublished, limit, offset)
+	if err != nil {
+		log.Printf("Error getting stories: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve stories")
+		return
+	}
+
+	if stories == nil {
+		stories = []Story{}
+	}
+
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", storiesCacheSeconds))
+	respondWithJSON(w, http.StatusOK, stories)
+}
+
+// GetStoryQueue handles GET /admin/stories?status=, pending stories by
+// default, oldest first.
+func (h *Handler) GetStoryQueue(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = storyPending
+	}
+	if status != storyPending && status != storyPublished && status != storyRejected {
+		respondWithError(w, http.StatusBadRequest, "status must be pending, published or rejected")
+		return
+	}
+	limit, offset := parsePagination(r)
+
+	stories, err := h.db.GetStories(r.Context(), status, limit, offset)
+	if err != nil {
+		log.Printf("Error getting stories: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve stories")
+		return
+	}
+
+	if stories == nil {
+		stories = []Story{}
+	}
+
+	respondWithJSON(w, http.StatusOK, stories)
+}
+
+// ReviewStory handles POST /admin/stories/{id}/review. A published story
+// can be rejected later to take it down, or featured again.
+func (h *Handler) ReviewStory(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid story ID")
+		return
+	}
+
+	var req ReviewStoryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if req.Decision != storyPublish && req.Decision != storyReject {
+		respondWithError(w, http.StatusBadRequest, "decision must be publish or reject")
+		return
+	}
+	if req.Content != nil {
+		content := strings.TrimSpace(*req.Content)
+		if err := validateStoryContent(content); err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		req.Content = &content
+	}
+
+	status := storyRejected
+	if req.Decision == storyPublish {
+		status = storyPublished
+	}
+	story, err := h.db.ReviewStory(r.Context(), id, status, req.Content, req.Featured)
+	if err != nil {
+		log.Printf("Error reviewing story: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to review story")
+		return
+	}
+	if story == nil {
+		respondWithError(w, http.StatusNotFound, "Story not found")
+		return
+	}
+
+	h.audit(r, "story.review", "story", strconv.Itoa(id), req)
+
+	respondWithJSON(w, http.StatusOK, story)
+}
+
+// storyColumns selects a Story from stories aliased s. The post is only
+// linked while it is visible.
+const storyColumns = `s.id, s.content, s.signature, COALESCE(p.public_id, ''), COALESCE(s.event_name, ''),
+	s.status, s.featured, s.created_at, s.published_at`
+
+const storyPostJoin = `LEFT JOIN posts p ON p.id = s.post_id AND p.hidden_at IS NULL`
+
+func scanStory(row rowScanner) (*Story, error) {
+	var s Story
+	if err := row.Scan(&s.ID, &s.Content, &s.Signature, &s.PostID, &s.EventName, &s.Status, &s.Featured, &s.CreatedAt, &s.PublishedAt); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// CreateStory queues a story, taking its event from the post it is about.
+func (db *DB) CreateStory(ctx context.Context, req SubmitStoryRequest, postID *int, ipHash string) (*Story, error) {
+	story, err := scanStory(db.conn.QueryRowContext(ctx, `
+		WITH s AS (
+			INSERT INTO stories (content, signature, post_id, event_name, ip_hash)
+			VALUES ($1, $2, $3, (SELECT event_name FROM posts WHERE id = $3), $4)
+			RETURNING *
+		)
+		SELECT `+storyColumns+`
+		FROM s `+storyPostJoin,
+		req.Content, req.Signature, postID, ipHash,
+	))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create story: %w", err)
+	}
+	return story, nil
+}
+
+// GetStories returns the stories with a status. Published stories come
+// featured first, then newest first; the others oldest first, as a queue.
+func (db *DB) GetStories(ctx context.Context, status string, limit, offset int) ([]Story, error) {
+	order := "s.created_at, s.id"
+	if status == storyPublished {
+		order = "s.featured DESC, s.published_at DESC, s.id DESC"
+	}
+
+	rows, err := db.reader(ctx).QueryContext(ctx, `
+		SELECT `+storyColumns+`
+		FROM stories s `+storyPostJoin+`
+		WHERE s.status = $1
+		ORDER BY `+order+`
+		LIMIT $2 OFFSET $3
+	`, status, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query stories: %w", err)
+	}
+	defer rows.Close()
+
+	var stories []Story
+	for rows.Next() {
+		story, err := scanStory(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan story: %w", err)
+		}
+		stories = append(stories, *story)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating stories: %w", err)
+	}
+
+	return stories, nil
+}
+
+// ReviewStory sets a story's status, and its content and featured flag if
+// given, returning nil if there is no such story. A story keeps the time
+// it was first published through later edits.
+func (db *DB) ReviewStory(ctx context.Context, id int, status string, content *string, featured *bool) (*Story, error) {
+	story, err := scanStory(db.conn.QueryRowContext(ctx, `
+		WITH s AS (
+			UPDATE stories
+			SET status = $2,
+				content = COALESCE($3, content),
+				featured = COALESCE($4, featured),
+				reviewed_at = NOW(),
+				published_at = CASE WHEN $2 = 'published' THEN COALESCE(published_at, NOW()) END
+			WHERE id = $1
+			RETURNING *
+		)
+		SELECT `+storyColumns+`
+		FROM s `+storyPostJoin,
+		id, status, content, featured,
+	))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to review story: %w", err)
+	}
+	return story, nil
+}
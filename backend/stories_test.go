@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSubmitStoryValidation(t *testing.T) {
+	store := newFakeStore()
+	store.posts = []Post{{ID: 1, PublicID: "a000000001", EventName: "Glastonbury"}}
+	h := newTestHandler(store, HandlerConfig{})
+
+	tests := []struct {
+		body string
+		want string
+	}{
+		{`{"content":"  ","consent":true}`, "content is required"},
+		{`{"content":"We met","consent":false}`, "consent is required for the story to be published"},
+		{fmt.Sprintf(`{"content":"We met","signature":%q,"consent":true}`, strings.Repeat("x", maxStorySignatureLength+1)), "signature must be 100 characters or less"},
+		{`{"content":"We met","post_id":"a000000002","consent":true}`, "post_id must be the public ID of a post"},
+		{`{"content":"We met","post_id":"1","consent":true}`, "post_id must be the public ID of a post"},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		h.SubmitStory(rec, httptest.NewRequest(http.MethodPost, "/api/stories", strings.NewReader(tt.body)))
+		assertError(t, rec, http.StatusBadRequest, tt.want)
+	}
+}
+
+func TestStoryCuration(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	h := NewHandler(db, nil, HandlerConfig{})
+
+	event := fmt.Sprintf("Story Test %d", time.Now().UnixNano())
+	post, err := db.CreatePost(ctx, CreatePostRequest{EventName: event, Content: "Blue hat", Age: 25, Location: "x"}, "story-test", hashToken("token"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	h.SubmitStory(rec, httptest.NewRequest(http.MethodPost, "/api/stories",
+		strings.NewReader(fmt.Sprintf(`{"content":"We met at the hat stall","signature":"Sam & Alex","post_id":%q,"consent":true}`, post.PublicID))))
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, want 202 (body %s)", rec.Code, rec.Body)
+	}
+	var story Story
+	if err := json.NewDecoder(rec.Body).Decode(&story); err != nil {
+		t.Fatal(err)
+	}
+	if story.Status != storyPending || story.EventName != event || story.PostID != post.PublicID {
+		t.Errorf("submitted story = %+v, want pending and linked to the post", story)
+	}
+
+	published := func() []Story {
+		stories, err := db.GetStories(ctx, storyPublished, 100, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return stories
+	}
+	for _, s := range published() {
+		if s.ID == story.ID {
+			t.Fatal("story is public before it was reviewed")
+		}
+	}
+
+	edited := "We met at the hat stall."
+	featured := true
+	reviewed, err := db.ReviewStory(ctx, story.ID, storyPublished, &edited, &featured)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reviewed.Content != edited || !reviewed.Featured || reviewed.PublishedAt == nil {
+		t.Errorf("published story = %+v, want the curator's edit, featured", reviewed)
+	}
+	if stories := published(); len(stories) == 0 || stories[0].ID != story.ID {
+		t.Errorf("published stories = %+v, want the featured story first", stories)
+	}
+
+	if reviewed, err = db.ReviewStory(ctx, story.ID, storyRejected, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if reviewed.PublishedAt != nil {
+		t.Errorf("rejected story has published_at %v", reviewed.PublishedAt)
+	}
+	for _, s := range published() {
+		if s.ID == story.ID {
+			t.Error("rejected story is still public")
+		}
+	}
+
+	if reviewed, err = db.ReviewStory(ctx, 0, storyPublished, nil, nil); err != nil || reviewed != nil {
+		t.Errorf("ReviewStory of a missing story = %v, %v, want nil", reviewed, err)
+	}
+}
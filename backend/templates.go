@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// templateBlank marks the gaps in a template prompt that posters fill in.
+const templateBlank = "___"
+
+// PostTemplate is an organizer-written prompt, such as "I saw you at ___
+// wearing ___", that posts on an event's board can start from.
+type PostTemplate struct {
+	ID        int       `json:"id"`
+	EventName string    `json:"event_name"`
+	Prompt    string    `json:"prompt"`
+	Position  int       `json:"position"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type CreatePostTemplateRequest struct {
+	Prompt   string `json:"prompt"`
+	Position int    `json:"position"`
+}
+
+// GetPostTemplates handles GET /api/events/{event}/templates
+func (h *Handler) GetPostTemplates(w http.ResponseWriter, r *http.Request) {
+	templates, err := h.db.GetPostTemplates(r.Context(), r.PathValue("event"))
+	if err != nil {
+		log.Printf("Error getting post templates: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve templates")
+		return
+	}
+
+	if templates == nil {
+		templates = []PostTemplate{}
+	}
+
+	respondWithJSON(w, http.StatusOK, templates)
+}
+
+// CreatePostTemplate handles POST /admin/events/{event}/templates
+func (h *Handler) CreatePostTemplate(w http.ResponseWriter, r *http.Request) {
+	var req CreatePostTemplateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	req.Prompt = strings.TrimSpace(req.Prompt)
+	if req.Prompt == "" {
+		respondWithError(w, http.StatusBadRequest, "prompt is required")
+		return
+	}
+	if len(req.Prompt) > 500 {
+		respondWithError(w, http.StatusBadRequest, "prompt must be 500 characters or less")
+		return
+	}
+
+	template, err := h.db.CreatePostTemplate(r.Context(), r.PathValue("event"), req)
+	if err != nil {
+		log.Printf("Error creating post template: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to create template")
+		return
+	}
+	if template == nil {
+		respondWithError(w, http.StatusNotFound, "Event not found")
+		return
+	}
+
+	h.audit(r, "event.create_template", "event", template.EventName, template)
+
+	respondWithJSON(w, http.StatusCreated, template)
+}
+
+// ArchivePostTemplate handles DELETE /admin/events/{event}/templates/{id}.
+// Templates are archived rather than deleted so posts keep their reference.
+func (h *Handler) ArchivePostTemplate(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid template ID")
+		return
+	}
+
+	found, err := h.db.ArchivePostTemplate(r.Context(), r.PathValue("event"), id)
+	if err != nil {
+		log.Printf("Error archiving post template: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to delete template")
+		return
+	}
+	if !found {
+		respondWithError(w, http.StatusNotFound, "Template not found")
+		return
+	}
+
+	h.audit(r, "event.archive_template", "event", r.PathValue("event"), map[string]interface{}{
+		"template_id": id,
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// checkPostTemplate verifies that a post's template belongs to its event and
+// that the poster filled in the blanks.
+func (h *Handler) checkPostTemplate(ctx context.Context, req CreatePostRequest) error {
+	if req.TemplateID == nil {
+		return nil
+	}
+
+	template, err := h.db.GetPostTemplate(ctx, *req.TemplateID)
+	if err != nil {
+		return err
+	}
+	if template == nil || template.EventName != req.EventName {
+		return &ValidationError{Message: "template_id is not a template for this event", Rule: "template_id.invalid"}
+	}
+	if strings.Contains(req.Content, templateBlank) {
+		return &ValidationError{Message: "fill in the blanks in the template", Rule: "template.unfilled"}
+	}
+
+	return nil
+}
+
+const postTemplateColumns = `id, event_name, prompt, position, created_at`
+
+func scanPostTemplate(row rowScanner) (*PostTemplate, error) {
+	var t PostTemplate
+	if err := row.Scan(&t.ID, &t.EventName, &t.Prompt, &t.Position, &t.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// GetPostTemplates lists an event's active templates in display order
+func (db *DB) GetPostTemplates(ctx context.Context, eventName string) ([]PostTemplate, error) {
+	query := `
+		SELECT ` + postTemplateColumns + `
+		FROM post_templates
+		WHERE event_name = $1 AND archived_at IS NULL
+		ORDER BY position, id
+	`
+
+	rows, err := db.conn.QueryContext(ctx, query, eventName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query post templates: %w", err)
+	}
+	defer rows.Close()
+
+	var templates []PostTemplate
+	for rows.Next() {
+		t, err := scanPostTemplate(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan post template: %w", err)
+		}
+		templates = append(templates, *t)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating post templates: %w", err)
+	}
+
+	return templates, nil
+}
+
+// GetPostTemplate retrieves an active template, returning nil if there is
+// none with that ID.
+func (db *DB) GetPostTemplate(ctx context.Context, id int) (*PostTemplate, error) {
+	query := `SELECT ` + postTemplateColumns + ` FROM post_templates WHERE id = $1 AND archived_at IS NULL`
+
+	t, err := scanPostTemplate(db.conn.QueryRowContext(ctx, query, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get post template: %w", err)
+	}
+	return t, nil
+}
+
+// CreatePostTemplate adds a template to an event, returning nil if the event
+// does not exist.
+func (db *DB) CreatePostTemplate(ctx context.Context, eventName string, req CreatePostTemplateRequest) (*PostTemplate, error) {
+	query := `
+		INSERT INTO post_templates (event_name, prompt, position)
+		SELECT name, $2, $3 FROM events WHERE name = $1
+		RETURNING ` + postTemplateColumns
+
+	t, err := scanPostTemplate(db.conn.QueryRowContext(ctx, query, eventName, req.Prompt, req.Position))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create post template: %w", err)
+	}
+	return t, nil
+}
+
+// ArchivePostTemplate hides a template from new posts, reporting whether an
+// active one existed.
+func (db *DB) ArchivePostTemplate(ctx context.Context, eventName string, id int) (bool, error) {
+	result, err := db.conn.ExecContext(ctx, `
+		UPDATE post_templates
+		SET archived_at = NOW()
+		WHERE id = $1 AND event_name = $2 AND archived_at IS NULL
+	`, id, eventName)
+	if err != nil {
+		return false, fmt.Errorf("failed to archive post template: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to archive post template: %w", err)
+	}
+
+	return affected > 0, nil
+}
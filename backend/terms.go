@@ -0,0 +1,74 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// Terms is the currently configured terms of service. Posts must cite the
+// current Version; TextHash lets clients detect that the text they showed
+// the user is the one being accepted.
+type Terms struct {
+	Version  string `json:"version"`
+	TextHash string `json:"text_hash"`
+}
+
+// LoadTerms builds the terms from TERMS_VERSION and the text in TERMS_FILE
+// (or TERMS_TEXT). It returns nil when no version is configured, in which
+// case posts are not required to cite one.
+func LoadTerms(version, text, file string) (*Terms, error) {
+	if version == "" {
+		return nil, nil
+	}
+	if len(version) > 50 {
+		return nil, fmt.Errorf("terms version must be 50 characters or less")
+	}
+
+	if file != "" {
+		contents, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read terms file: %w", err)
+		}
+		text = string(contents)
+	}
+
+	hash := sha256.Sum256([]byte(text))
+	return &Terms{Version: version, TextHash: hex.EncodeToString(hash[:])}, nil
+}
+
+// GetTerms handles GET /api/terms
+func (h *Handler) GetTerms(w http.ResponseWriter, r *http.Request) {
+	if h.cfg.Terms == nil {
+		respondWithError(w, http.StatusNotFound, "No terms of service configured")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, h.cfg.Terms)
+}
+
+// TermsChangedError is returned for a post citing terms that have since
+// been replaced; the client should show the new terms.
+type TermsChangedError struct {
+	Version string
+}
+
+func (e *TermsChangedError) Error() string {
+	return fmt.Sprintf("The terms of service have changed. Please review and accept version %s.", e.Version)
+}
+
+// checkTermsVersion checks a post cites the current terms version.
+func (h *Handler) checkTermsVersion(version string) error {
+	if h.cfg.Terms == nil {
+		return nil
+	}
+	if version == "" {
+		return &ValidationError{Message: "terms_version is required", Rule: "terms_version.required"}
+	}
+	if version != h.cfg.Terms.Version {
+		return &TermsChangedError{Version: h.cfg.Terms.Version}
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	maxBannerURLLength     = 2048
+	maxWelcomeMessageChars = 500
+)
+
+var accentColorPattern = regexp.MustCompile(`^#[0-9a-f]{6}$`)
+
+// EventTheme brands an event's board, so organizers can restyle official
+// boards without a frontend release.
+type EventTheme struct {
+	// BannerURL is an https image shown across the top of the board
+	BannerURL string `json:"banner_url,omitempty"`
+	// AccentColor is a hex color such as #ff6600
+	AccentColor    string `json:"accent_color,omitempty"`
+	WelcomeMessage string `json:"welcome_message,omitempty"`
+}
+
+// SetEventTheme handles PUT /admin/events/{event}/theme. The whole theme is
+// replaced; omitted fields are cleared.
+func (h *Handler) SetEventTheme(w http.ResponseWriter, r *http.Request) {
+	var req EventTheme
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if err := validateEventTheme(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	found, err := h.db.SetEventTheme(r.Context(), r.PathValue("event"), req)
+	if err != nil {
+		log.Printf("Error setting event theme: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to update event")
+		return
+	}
+	if !found {
+		respondWithError(w, http.StatusNotFound, "Event not found")
+		return
+	}
+
+	h.audit(r, "event.set_theme", "event", r.PathValue("event"), req)
+
+	h.GetEvent(w, r)
+}
+
+func validateEventTheme(t *EventTheme) error {
+	t.BannerURL = strings.TrimSpace(t.BannerURL)
+	if t.BannerURL != "" {
+		u, err := url.Parse(t.BannerURL)
+		if err != nil || u.Scheme != "https" || u.Host == "" || len(t.BannerURL) > maxBannerURLLength {
+			return &ValidationError{Message: fmt.Sprintf("banner_url must be an https URL of at most %d characters", maxBannerURLLength)}
+		}
+	}
+
+	t.AccentColor = strings.ToLower(strings.TrimSpace(t.AccentColor))
+	if t.AccentColor != "" && !accentColorPattern.MatchString(t.AccentColor) {
+		return &ValidationError{Message: "accent_color must be a hex color such as #ff6600"}
+	}
+
+	t.WelcomeMessage = strings.TrimSpace(t.WelcomeMessage)
+	if utf8.RuneCountInString(t.WelcomeMessage) > maxWelcomeMessageChars {
+		return &ValidationError{Message: fmt.Sprintf("welcome_message must be %d characters or less", maxWelcomeMessageChars)}
+	}
+
+	return nil
+}
+
+// SetEventTheme replaces an event's theme, reporting whether the event
+// exists.
+func (db *DB) SetEventTheme(ctx context.Context, name string, t EventTheme) (bool, error) {
+	result, err := db.conn.ExecContext(ctx, `
+		UPDATE events
+		SET banner_url = NULLIF($2, ''), accent_color = NULLIF($3, ''), welcome_message = NULLIF($4, '')
+		WHERE name = $1
+	`, name, t.BannerURL, t.AccentColor, t.WelcomeMessage)
+	if err != nil {
+		return false, fmt.Errorf("failed to set event theme: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to set event theme: %w", err)
+	}
+
+	return affected > 0, nil
+}
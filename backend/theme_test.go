@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSetEventTheme(t *testing.T) {
+	tests := []struct {
+		name      string
+		body      string
+		noEvent   bool
+		status    int
+		errorMsg  string
+		wantTheme EventTheme
+	}{
+		{name: "invalid body", body: `[]`, status: 400, errorMsg: "Invalid request body"},
+		{name: "http banner", body: `{"banner_url":"http://cdn.example.com/banner.png"}`, status: 400, errorMsg: "banner_url must be an https URL of at most 2048 characters"},
+		{name: "relative banner", body: `{"banner_url":"/banner.png"}`, status: 400, errorMsg: "banner_url must be an https URL of at most 2048 characters"},
+		{name: "named color", body: `{"accent_color":"orange"}`, status: 400, errorMsg: "accent_color must be a hex color such as #ff6600"},
+		{name: "short color", body: `{"accent_color":"#f60"}`, status: 400, errorMsg: "accent_color must be a hex color such as #ff6600"},
+		{name: "long welcome", body: `{"welcome_message":"` + strings.Repeat("é", 501) + `"}`, status: 400, errorMsg: "welcome_message must be 500 characters or less"},
+		{name: "unknown event", noEvent: true, body: `{}`, status: 404, errorMsg: "Event not found"},
+		{
+			name:   "set",
+			body:   `{"banner_url":" https://cdn.example.com/banner.png ","accent_color":"#FF6600","welcome_message":" Welcome to the Pyramid stage board! "}`,
+			status: 200,
+			wantTheme: EventTheme{
+				BannerURL:      "https://cdn.example.com/banner.png",
+				AccentColor:    "#ff6600",
+				WelcomeMessage: "Welcome to the Pyramid stage board!",
+			},
+		},
+		{name: "cleared", body: `{}`, status: 200},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newFakeStore()
+			if !tt.noEvent {
+				store.event = &Event{ID: 1, Name: "Glastonbury", Theme: EventTheme{AccentColor: "#000000"}}
+			}
+			h := newTestHandler(store, HandlerConfig{})
+
+			req := httptest.NewRequest(http.MethodPut, "/admin/events/Glastonbury/theme", strings.NewReader(tt.body))
+			req.SetPathValue("event", "Glastonbury")
+			rec := httptest.NewRecorder()
+			h.SetEventTheme(rec, req)
+
+			if tt.errorMsg != "" {
+				assertError(t, rec, tt.status, tt.errorMsg)
+				if store.theme != nil {
+					t.Errorf("theme saved after an error: %+v", store.theme)
+				}
+				return
+			}
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+
+			var event Event
+			if err := json.Unmarshal(rec.Body.Bytes(), &event); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(event.Theme, tt.wantTheme) {
+				t.Errorf("theme = %+v, want %+v", event.Theme, tt.wantTheme)
+			}
+			if !reflect.DeepEqual(store.audited, []string{"event.set_theme"}) {
+				t.Errorf("audited %v, want event.set_theme", store.audited)
+			}
+		})
+	}
+}
@@ -0,0 +1,472 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	toxicityQueueSize = 1000
+	// toxicityReason is the moderation queue reason of auto-hidden posts
+	toxicityReason = "toxicity"
+	// toxicityLabel is the content warning given to labeled posts
+	toxicityLabel = "potentially offensive"
+
+	defaultPrecisionDays = 30
+	maxPrecisionDays     = 365
+)
+
+// What the scorer did with a post
+const (
+	toxicityActionNone  = "none"
+	toxicityActionLabel = "label"
+	toxicityActionHide  = "hide"
+)
+
+// ToxicityScorer rates how toxic text is, from 0 (not at all) to 1.
+type ToxicityScorer interface {
+	Score(ctx context.Context, text string) (float64, error)
+	Name() string
+}
+
+// NewToxicityScorer builds the provider named by TOXICITY_PROVIDER. It
+// returns nil when scoring is disabled.
+func NewToxicityScorer(kind, endpoint, apiKey string) (ToxicityScorer, error) {
+	client := &http.Client{Timeout: 15 * time.Second}
+
+	switch kind {
+	case "", "none":
+		return nil, nil
+	case "http":
+		if endpoint == "" {
+			return nil, fmt.Errorf("TOXICITY_URL is required for the http scorer")
+		}
+		return &HTTPToxicityScorer{endpoint: endpoint, apiKey: apiKey, client: client}, nil
+	case "perspective":
+		if apiKey == "" {
+			return nil, fmt.Errorf("TOXICITY_API_KEY is required for Perspective")
+		}
+		if endpoint == "" {
+			endpoint = "https://commentanalyzer.googleapis.com/v1alpha1/comments:analyze"
+		}
+		return &PerspectiveScorer{endpoint: endpoint, apiKey: apiKey, client: client}, nil
+	default:
+		return nil, fmt.Errorf("unknown toxicity provider %q", kind)
+	}
+}
+
+// postJSON sends body to endpoint and decodes the JSON response into out.
+func postJSON(ctx context.Context, client *http.Client, endpoint string, header http.Header, body, out interface{}) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("failed to build request: %w", err)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("provider returned status %d: %s", resp.StatusCode, msg)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+// HTTPToxicityScorer posts {"text": "..."} to a scoring service (for
+// example a self-hosted Detoxify server) that responds with {"score": 0.42}.
+type HTTPToxicityScorer struct {
+	endpoint string
+	apiKey   string
+	client   *http.Client
+}
+
+func (s *HTTPToxicityScorer) Name() string { return "http" }
+
+func (s *HTTPToxicityScorer) Score(ctx context.Context, text string) (float64, error) {
+	header := http.Header{}
+	if s.apiKey != "" {
+		header.Set("Authorization", "Bearer "+s.apiKey)
+	}
+
+	var out struct {
+		Score *float64 `json:"score"`
+	}
+	if err := postJSON(ctx, s.client, s.endpoint, header, map[string]string{"text": text}, &out); err != nil {
+		return 0, err
+	}
+	if out.Score == nil {
+		return 0, fmt.Errorf("provider returned no score")
+	}
+	return *out.Score, nil
+}
+
+// PerspectiveScorer uses the TOXICITY attribute of Google's Perspective API.
+type PerspectiveScorer struct {
+	endpoint string
+	apiKey   string
+	client   *http.Client
+}
+
+func (s *PerspectiveScorer) Name() string { return "perspective" }
+
+func (s *PerspectiveScorer) Score(ctx context.Context, text string) (float64, error) {
+	body := map[string]interface{}{
+		"comment":             map[string]string{"text": text},
+		"requestedAttributes": map[string]interface{}{"TOXICITY": struct{}{}},
+		"doNotStore":          true,
+	}
+
+	var out struct {
+		AttributeScores struct {
+			Toxicity struct {
+				SummaryScore struct {
+					Value float64 `json:"value"`
+				} `json:"summaryScore"`
+			} `json:"TOXICITY"`
+		} `json:"attributeScores"`
+	}
+	if err := postJSON(ctx, s.client, s.endpoint+"?key="+s.apiKey, nil, body, &out); err != nil {
+		return 0, err
+	}
+	return out.AttributeScores.Toxicity.SummaryScore.Value, nil
+}
+
+// ToxicityThresholds are the scores at or above which a post is hidden and
+// queued for moderators, or only labeled with a content warning. Nil fields
+// in an event's thresholds fall back to the server's.
+type ToxicityThresholds struct {
+	Hide  *float64 `json:"hide_threshold"`
+	Label *float64 `json:"label_threshold"`
+}
+
+func (t ToxicityThresholds) validate() error {
+	for _, v := range []*float64{t.Hide, t.Label} {
+		if v != nil && (*v <= 0 || *v > 1) {
+			return &ValidationError{Message: "thresholds must be greater than 0 and at most 1"}
+		}
+	}
+	if t.Hide != nil && t.Label != nil && *t.Label > *t.Hide {
+		return &ValidationError{Message: "label_threshold must not be above hide_threshold"}
+	}
+	return nil
+}
+
+// or fills t's unset thresholds from defaults.
+func (t ToxicityThresholds) or(defaults ToxicityThresholds) ToxicityThresholds {
+	if t.Hide == nil {
+		t.Hide = defaults.Hide
+	}
+	if t.Label == nil {
+		t.Label = defaults.Label
+	}
+	return t
+}
+
+// action is what a post with the given score should get.
+func (t ToxicityThresholds) action(score float64) string {
+	switch {
+	case t.Hide != nil && score >= *t.Hide:
+		return toxicityActionHide
+	case t.Label != nil && score >= *t.Label:
+		return toxicityActionLabel
+	default:
+		return toxicityActionNone
+	}
+}
+
+// Toxicity scores posts in the background after they are published or
+// edited, so a slow provider never delays posting. Posts are scored once
+// each time they are queued; when the queue is full they go unscored.
+type Toxicity struct {
+	db       Store
+	scorer   ToxicityScorer
+	defaults ToxicityThresholds
+	posts    chan Post
+}
+
+// NewToxicity returns nil when scorer is nil, which disables scoring.
+func NewToxicity(db Store, scorer ToxicityScorer, defaults ToxicityThresholds) *Toxicity {
+	if scorer == nil {
+		return nil
+	}
+	return &Toxicity{db: db, scorer: scorer, defaults: defaults, posts: make(chan Post, toxicityQueueSize)}
+}
+
+// Enqueue queues a post for scoring without blocking.
+func (t *Toxicity) Enqueue(post Post) {
+	if t == nil {
+		return
+	}
+	select {
+	case t.posts <- post:
+	default:
+		log.Printf("Toxicity queue full, not scoring post %d", post.ID)
+	}
+}
+
+// Run scores queued posts until ctx is cancelled.
+func (t *Toxicity) Run(ctx context.Context) {
+	if t == nil {
+		return
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case post := <-t.posts:
+			if err := t.score(ctx, post); err != nil {
+				log.Printf("Error scoring post %d for toxicity: %v", post.ID, err)
+			}
+		}
+	}
+}
+
+func (t *Toxicity) score(ctx context.Context, post Post) error {
+	score, err := t.scorer.Score(ctx, post.Content)
+	if err != nil {
+		return err
+	}
+
+	thresholds, err := t.db.GetToxicityThresholds(ctx, post.EventName)
+	if err != nil {
+		return err
+	}
+	action := thresholds.or(t.defaults).action(score)
+
+	if err := t.db.SaveToxicityScore(ctx, post.ID, t.scorer.Name(), score, action); err != nil {
+		return err
+	}
+
+	switch action {
+	case toxicityActionHide:
+		hidden, err := t.db.HidePost(ctx, post.ID, "toxicity:"+t.scorer.Name())
+		if err != nil {
+			return err
+		}
+		if hidden {
+			flag := ContentFlag{Reason: toxicityReason, Details: fmt.Sprintf("%s score %.2f", t.scorer.Name(), score)}
+			return t.db.EnqueueModeration(ctx, post.ID, flag, "scorer")
+		}
+	case toxicityActionLabel:
+		if post.ContentWarning == "" {
+			_, err := t.db.SetContentWarning(ctx, post.ID, toxicityLabel)
+			return err
+		}
+	}
+	return nil
+}
+
+// EventToxicitySettings is an event's threshold overrides and the server
+// defaults they fall back to.
+type EventToxicitySettings struct {
+	ToxicityThresholds
+	Defaults ToxicityThresholds `json:"defaults"`
+}
+
+// ToxicityPrecision measures how often moderators agreed with the scorer.
+// Confirmed counts auto-hidden posts whose queue item a moderator actioned,
+// and Overturned those they dismissed.
+type ToxicityPrecision struct {
+	Days       int `json:"days"`
+	Scored     int `json:"scored"`
+	Labeled    int `json:"labeled"`
+	Hidden     int `json:"hidden"`
+	Confirmed  int `json:"confirmed"`
+	Overturned int `json:"overturned"`
+	// Precision is Confirmed over decided posts, or null before any
+	// decisions
+	Precision *float64 `json:"precision"`
+}
+
+// GetEventToxicity handles GET /admin/events/{event}/toxicity
+func (h *Handler) GetEventToxicity(w http.ResponseWriter, r *http.Request) {
+	if h.cfg.Toxicity == nil {
+		respondWithError(w, http.StatusNotFound, "Toxicity scoring is not enabled")
+		return
+	}
+
+	thresholds, err := h.db.GetToxicityThresholds(r.Context(), r.PathValue("event"))
+	if err != nil {
+		log.Printf("Error getting toxicity thresholds: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve toxicity thresholds")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, EventToxicitySettings{thresholds, h.cfg.Toxicity.defaults})
+}
+
+// SetEventToxicity handles PUT /admin/events/{event}/toxicity. A null
+// threshold reverts to the server default.
+func (h *Handler) SetEventToxicity(w http.ResponseWriter, r *http.Request) {
+	if h.cfg.Toxicity == nil {
+		respondWithError(w, http.StatusNotFound, "Toxicity scoring is not enabled")
+		return
+	}
+
+	var req ToxicityThresholds
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if err := req.or(h.cfg.Toxicity.defaults).validate(); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	found, err := h.db.SetToxicityThresholds(r.Context(), r.PathValue("event"), req)
+	if err != nil {
+		log.Printf("Error setting toxicity thresholds: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to update toxicity thresholds")
+		return
+	}
+	if !found {
+		respondWithError(w, http.StatusNotFound, "Event not found")
+		return
+	}
+
+	h.audit(r, "event.set_toxicity", "event", r.PathValue("event"), req)
+
+	respondWithJSON(w, http.StatusOK, EventToxicitySettings{req, h.cfg.Toxicity.defaults})
+}
+
+// GetToxicityPrecision handles GET /admin/toxicity/precision?days=30
+func (h *Handler) GetToxicityPrecision(w http.ResponseWriter, r *http.Request) {
+	days := defaultPrecisionDays
+	if v := r.URL.Query().Get("days"); v != "" {
+		var err error
+		days, err = strconv.Atoi(v)
+		if err != nil || days < 1 || days > maxPrecisionDays {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("days must be between 1 and %d", maxPrecisionDays))
+			return
+		}
+	}
+
+	precision, err := h.db.GetToxicityPrecision(r.Context(), time.Now().AddDate(0, 0, -days))
+	if err != nil {
+		log.Printf("Error getting toxicity precision: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve toxicity precision")
+		return
+	}
+	precision.Days = days
+
+	respondWithJSON(w, http.StatusOK, precision)
+}
+
+// GetToxicityThresholds returns an event's threshold overrides; an event
+// that doesn't exist has none.
+func (db *DB) GetToxicityThresholds(ctx context.Context, eventName string) (ToxicityThresholds, error) {
+	var t ToxicityThresholds
+	err := db.conn.QueryRowContext(ctx, `
+		SELECT toxicity_hide_threshold, toxicity_label_threshold FROM events WHERE name = $1
+	`, eventName).Scan(&t.Hide, &t.Label)
+	if err == sql.ErrNoRows {
+		return ToxicityThresholds{}, nil
+	}
+	if err != nil {
+		return t, fmt.Errorf("failed to get toxicity thresholds: %w", err)
+	}
+	return t, nil
+}
+
+// SetToxicityThresholds replaces an event's threshold overrides, reporting
+// whether the event exists.
+func (db *DB) SetToxicityThresholds(ctx context.Context, eventName string, t ToxicityThresholds) (bool, error) {
+	result, err := db.conn.ExecContext(ctx, `
+		UPDATE events SET toxicity_hide_threshold = $2, toxicity_label_threshold = $3 WHERE name = $1
+	`, eventName, t.Hide, t.Label)
+	if err != nil {
+		return false, fmt.Errorf("failed to set toxicity thresholds: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to set toxicity thresholds: %w", err)
+	}
+
+	return affected > 0, nil
+}
+
+// SaveToxicityScore records a post's latest score and what was done with it.
+func (db *DB) SaveToxicityScore(ctx context.Context, postID int, scorer string, score float64, action string) error {
+	_, err := db.conn.ExecContext(ctx, `
+		INSERT INTO post_toxicity_scores (post_id, scorer, score, action)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (post_id) DO UPDATE
+		SET scorer = EXCLUDED.scorer, score = EXCLUDED.score, action = EXCLUDED.action, created_at = NOW()
+	`, postID, scorer, score, action)
+	if err != nil {
+		return fmt.Errorf("failed to save toxicity score: %w", err)
+	}
+	return nil
+}
+
+// HidePost hides a visible post, reporting whether it was visible.
+func (db *DB) HidePost(ctx context.Context, postID int, hiddenBy string) (bool, error) {
+	result, err := db.conn.ExecContext(ctx,
+		"UPDATE posts SET hidden_at = NOW(), hidden_by = $2 WHERE id = $1 AND hidden_at IS NULL",
+		postID, hiddenBy,
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to hide post: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to hide post: %w", err)
+	}
+
+	return affected > 0, nil
+}
+
+// GetToxicityPrecision counts the posts scored since a time and the
+// moderator decisions on those auto-hidden, judged by the latest resolved
+// toxicity queue item of each.
+func (db *DB) GetToxicityPrecision(ctx context.Context, since time.Time) (*ToxicityPrecision, error) {
+	var p ToxicityPrecision
+	err := db.conn.QueryRowContext(ctx, `
+		SELECT COUNT(*),
+			COUNT(*) FILTER (WHERE s.action = 'label'),
+			COUNT(*) FILTER (WHERE s.action = 'hide'),
+			COUNT(*) FILTER (WHERE s.action = 'hide' AND q.resolution = 'actioned'),
+			COUNT(*) FILTER (WHERE s.action = 'hide' AND q.resolution = 'dismissed')
+		FROM post_toxicity_scores s
+		LEFT JOIN LATERAL (
+			SELECT resolution FROM moderation_queue
+			WHERE post_id = s.post_id AND reason = $2 AND resolved_at IS NOT NULL
+			ORDER BY resolved_at DESC
+			LIMIT 1
+		) q ON TRUE
+		WHERE s.created_at >= $1
+	`, since, toxicityReason).Scan(&p.Scored, &p.Labeled, &p.Hidden, &p.Confirmed, &p.Overturned)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get toxicity precision: %w", err)
+	}
+
+	if decided := p.Confirmed + p.Overturned; decided > 0 {
+		precision := float64(p.Confirmed) / float64(decided)
+		p.Precision = &precision
+	}
+	return &p, nil
+}
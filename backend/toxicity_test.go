@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type fixedScorer struct {
+	score float64
+	err   error
+}
+
+func (s fixedScorer) Score(ctx context.Context, text string) (float64, error) { return s.score, s.err }
+func (s fixedScorer) Name() string                                            { return "fixed" }
+
+func threshold(v float64) *float64 { return &v }
+
+func TestToxicityScore(t *testing.T) {
+	defaults := ToxicityThresholds{Hide: threshold(0.9), Label: threshold(0.7)}
+
+	tests := []struct {
+		name    string
+		score   float64
+		event   ToxicityThresholds
+		warning string
+		action  string
+		hidden  bool
+		warned  bool
+		flagged bool
+	}{
+		{name: "clean", score: 0.1, action: toxicityActionNone},
+		{name: "labeled", score: 0.75, action: toxicityActionLabel, warned: true},
+		{name: "labeled, has warning", score: 0.75, warning: "spoilers", action: toxicityActionLabel},
+		{name: "hidden", score: 0.95, action: toxicityActionHide, hidden: true, flagged: true},
+		{name: "event hides lower", score: 0.75, event: ToxicityThresholds{Hide: threshold(0.7)}, action: toxicityActionHide, hidden: true, flagged: true},
+		{name: "event labels lower", score: 0.5, event: ToxicityThresholds{Label: threshold(0.4)}, action: toxicityActionLabel, warned: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newFakeStore()
+			store.thresholds = tt.event
+			tox := NewToxicity(store, fixedScorer{score: tt.score}, defaults)
+
+			post := Post{ID: 7, EventName: "Glastonbury", Content: "hi", ContentWarning: tt.warning}
+			if err := tox.score(context.Background(), post); err != nil {
+				t.Fatal(err)
+			}
+
+			if store.toxicity[7] != tt.action {
+				t.Errorf("saved action = %q, want %q", store.toxicity[7], tt.action)
+			}
+			if hidden := reflect.DeepEqual(store.hidden, []int{7}); hidden != tt.hidden {
+				t.Errorf("hidden = %v, want %v", store.hidden, tt.hidden)
+			}
+			if warned := reflect.DeepEqual(store.warned, []int{7}); warned != tt.warned {
+				t.Errorf("warned = %v, want %v", store.warned, tt.warned)
+			}
+			if flagged := len(store.enqueued) == 1 && store.enqueued[0].Reason == toxicityReason; flagged != tt.flagged {
+				t.Errorf("enqueued = %v, want flagged %v", store.enqueued, tt.flagged)
+			}
+		})
+	}
+
+	t.Run("scorer error", func(t *testing.T) {
+		store := newFakeStore()
+		tox := NewToxicity(store, fixedScorer{err: errors.New("timeout")}, defaults)
+		if err := tox.score(context.Background(), Post{ID: 7}); err == nil {
+			t.Fatal("want error")
+		}
+		if len(store.toxicity) != 0 || len(store.hidden) != 0 {
+			t.Errorf("scorer error saved %v and hid %v", store.toxicity, store.hidden)
+		}
+	})
+}
+
+func TestToxicityThresholdsValidate(t *testing.T) {
+	tests := []struct {
+		name       string
+		thresholds ToxicityThresholds
+		valid      bool
+	}{
+		{name: "unset", valid: true},
+		{name: "ordered", thresholds: ToxicityThresholds{Hide: threshold(0.9), Label: threshold(0.7)}, valid: true},
+		{name: "equal", thresholds: ToxicityThresholds{Hide: threshold(0.8), Label: threshold(0.8)}, valid: true},
+		{name: "label above hide", thresholds: ToxicityThresholds{Hide: threshold(0.7), Label: threshold(0.9)}},
+		{name: "zero", thresholds: ToxicityThresholds{Hide: threshold(0)}},
+		{name: "above one", thresholds: ToxicityThresholds{Label: threshold(1.5)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.thresholds.validate(); (err == nil) != tt.valid {
+				t.Errorf("validate() = %v, want valid %v", err, tt.valid)
+			}
+		})
+	}
+}
+
+func TestToxicityDisabled(t *testing.T) {
+	tox := NewToxicity(newFakeStore(), nil, ToxicityThresholds{})
+	if tox != nil {
+		t.Fatal("NewToxicity without a scorer should return nil")
+	}
+	// A nil Toxicity ignores posts
+	tox.Enqueue(Post{ID: 1})
+}
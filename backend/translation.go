@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var languageCodePattern = regexp.MustCompile(`^[a-z]{2,3}(-[A-Z]{2})?$`)
+
+// Translation is a post's content in another language.
+type Translation struct {
+	PostID         int       `json:"post_id"`
+	Language       string    `json:"language"`
+	SourceLanguage string    `json:"source_language,omitempty"`
+	Content        string    `json:"content"`
+	Provider       string    `json:"provider"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// Translator is a machine translation provider.
+type Translator interface {
+	// Translate returns text in the target language and the detected
+	// source language, if the provider reports it.
+	Translate(ctx context.Context, text, target string) (translated, source string, err error)
+	Name() string
+}
+
+// NewTranslator builds the provider named by TRANSLATION_PROVIDER. It returns
+// nil when translation is disabled.
+func NewTranslator(kind, endpoint, apiKey string) (Translator, error) {
+	client := &http.Client{Timeout: 15 * time.Second}
+
+	switch kind {
+	case "", "none":
+		return nil, nil
+	case "deepl":
+		if apiKey == "" {
+			return nil, fmt.Errorf("TRANSLATION_API_KEY is required for DeepL")
+		}
+		if endpoint == "" {
+			endpoint = "https://api.deepl.com/v2/translate"
+		}
+		return &DeepLTranslator{endpoint: endpoint, apiKey: apiKey, client: client}, nil
+	case "google":
+		if apiKey == "" {
+			return nil, fmt.Errorf("TRANSLATION_API_KEY is required for Google")
+		}
+		if endpoint == "" {
+			endpoint = "https://translation.googleapis.com/language/translate/v2"
+		}
+		return &GoogleTranslator{endpoint: endpoint, apiKey: apiKey, client: client}, nil
+	case "libretranslate":
+		if endpoint == "" {
+			return nil, fmt.Errorf("TRANSLATION_URL is required for LibreTranslate")
+		}
+		return &LibreTranslator{endpoint: endpoint, apiKey: apiKey, client: client}, nil
+	default:
+		return nil, fmt.Errorf("unknown translation provider %q", kind)
+	}
+}
+
+// GetPostTranslation handles GET /api/posts/{id}/translate?to=es.
+// Translations are cached per post and language, so each is paid for once.
+func (h *Handler) GetPostTranslation(w http.ResponseWriter, r *http.Request) {
+	if h.cfg.Translator == nil {
+		respondWithError(w, http.StatusNotFound, "Translation is not enabled")
+		return
+	}
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid post ID")
+		return
+	}
+
+	lang := r.URL.Query().Get("to")
+	if !languageCodePattern.MatchString(lang) {
+		respondWithError(w, http.StatusBadRequest, "to must be a language code such as es or pt-BR")
+		return
+	}
+
+	// The post is looked up even for cached translations, so hiding a
+	// post hides its translations too
+	post, err := h.db.GetPostByID(r.Context(), id)
+	if err != nil {
+		log.Printf("Error getting post: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to translate post")
+		return
+	}
+	if post == nil || post.HiddenAt != nil {
+		respondWithError(w, http.StatusNotFound, "Post not found")
+		return
+	}
+
+	translation, err := h.db.GetTranslation(r.Context(), id, lang)
+	if err != nil {
+		log.Printf("Error getting translation: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to translate post")
+		return
+	}
+	if translation != nil {
+		respondWithJSON(w, http.StatusOK, translation)
+		return
+	}
+
+	text, source, err := h.cfg.Translator.Translate(r.Context(), post.Content, lang)
+	if err != nil {
+		log.Printf("Error translating post %d to %s: %v", id, lang, err)
+		respondWithError(w, http.StatusBadGateway, "Translation provider failed")
+		return
+	}
+
+	translation, err = h.db.SaveTranslation(r.Context(), Translation{
+		PostID:         id,
+		Language:       lang,
+		SourceLanguage: strings.ToLower(source),
+		Content:        text,
+		Provider:       h.cfg.Translator.Name(),
+	})
+	if err != nil {
+		log.Printf("Error saving translation: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to translate post")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, translation)
+}
+
+// DeepLTranslator uses the DeepL API.
+type DeepLTranslator struct {
+	endpoint string
+	apiKey   string
+	client   *http.Client
+}
+
+func (t *DeepLTranslator) Name() string { return "deepl" }
+
+func (t *DeepLTranslator) Translate(ctx context.Context, text, target string) (string, string, error) {
+	var resp struct {
+		Translations []struct {
+			DetectedSourceLanguage string `json:"detected_source_language"`
+			Text                   string `json:"text"`
+		} `json:"translations"`
+	}
+	err := postTranslationJSON(ctx, t.client, t.endpoint, map[string]string{
+		"Authorization": "DeepL-Auth-Key " + t.apiKey,
+	}, map[string]interface{}{
+		"text":        []string{text},
+		"target_lang": strings.ToUpper(target),
+	}, &resp)
+	if err != nil {
+		return "", "", err
+	}
+	if len(resp.Translations) == 0 {
+		return "", "", fmt.Errorf("deepl returned no translations")
+	}
+	return resp.Translations[0].Text, resp.Translations[0].DetectedSourceLanguage, nil
+}
+
+// GoogleTranslator uses the Google Cloud Translation v2 API.
+type GoogleTranslator struct {
+	endpoint string
+	apiKey   string
+	client   *http.Client
+}
+
+func (t *GoogleTranslator) Name() string { return "google" }
+
+func (t *GoogleTranslator) Translate(ctx context.Context, text, target string) (string, string, error) {
+	var resp struct {
+		Data struct {
+			Translations []struct {
+				TranslatedText         string `json:"translatedText"`
+				DetectedSourceLanguage string `json:"detectedSourceLanguage"`
+			} `json:"translations"`
+		} `json:"data"`
+	}
+	err := postTranslationJSON(ctx, t.client, t.endpoint+"?key="+url.QueryEscape(t.apiKey), nil, map[string]interface{}{
+		"q":      text,
+		"target": target,
+		"format": "text",
+	}, &resp)
+	if err != nil {
+		return "", "", err
+	}
+	if len(resp.Data.Translations) == 0 {
+		return "", "", fmt.Errorf("google returned no translations")
+	}
+	return resp.Data.Translations[0].TranslatedText, resp.Data.Translations[0].DetectedSourceLanguage, nil
+}
+
+// LibreTranslator uses a LibreTranslate server, which may be self-hosted.
+type LibreTranslator struct {
+	endpoint string
+	apiKey   string
+	client   *http.Client
+}
+
+func (t *LibreTranslator) Name() string { return "libretranslate" }
+
+func (t *LibreTranslator) Translate(ctx context.Context, text, target string) (string, string, error) {
+	var resp struct {
+		TranslatedText   string `json:"translatedText"`
+		DetectedLanguage struct {
+			Language string `json:"language"`
+		} `json:"detectedLanguage"`
+	}
+	body := map[string]interface{}{
+		"q":      text,
+		"source": "auto",
+		"target": target,
+		"format": "text",
+	}
+	if t.apiKey != "" {
+		body["api_key"] = t.apiKey
+	}
+	err := postTranslationJSON(ctx, t.client, strings.TrimSuffix(t.endpoint, "/")+"/translate", nil, body, &resp)
+	if err != nil {
+		return "", "", err
+	}
+	return resp.TranslatedText, resp.DetectedLanguage.Language, nil
+}
+
+func postTranslationJSON(ctx context.Context, client *http.Client, endpoint string, headers map[string]string, body, out interface{}) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("failed to encode translation request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("failed to build translation request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send translation request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("translation provider returned status %d: %s", resp.StatusCode, msg)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode translation response: %w", err)
+	}
+	return nil
+}
+
+const translationColumns = `post_id, language, COALESCE(source_language, ''), content, provider, created_at`
+
+func scanTranslation(row rowScanner) (*Translation, error) {
+	var t Translation
+	if err := row.Scan(&t.PostID, &t.Language, &t.SourceLanguage, &t.Content, &t.Provider, &t.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// GetTranslation returns a cached translation, or nil if there is none
+func (db *DB) GetTranslation(ctx context.Context, postID int, lang string) (*Translation, error) {
+	query := `SELECT ` + translationColumns + ` FROM post_translations WHERE post_id = $1 AND language = $2`
+
+	t, err := scanTranslation(db.conn.QueryRowContext(ctx, query, postID, lang))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get translation: %w", err)
+	}
+	return t, nil
+}
+
+// SaveTranslation caches a translation. If another request cached the same
+// one first, that row is kept and returned.
+func (db *DB) SaveTranslation(ctx context.Context, t Translation) (*Translation, error) {
+	query := `
+		INSERT INTO post_translations (post_id, language, source_language, content, provider)
+		VALUES ($1, $2, NULLIF($3, ''), $4, $5)
+		ON CONFLICT (post_id, language) DO UPDATE SET post_id = post_translations.post_id
+		RETURNING ` + translationColumns
+
+	saved, err := scanTranslation(db.conn.QueryRowContext(ctx, query,
+		t.PostID, t.Language, t.SourceLanguage, t.Content, t.Provider,
+	))
+	if err != nil {
+		return nil, fmt.Errorf("failed to save translation: %w", err)
+	}
+	return saved, nil
+}
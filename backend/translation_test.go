@@ -0,0 +1,32 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type stubTranslator struct{}
+
+func (stubTranslator) Translate(ctx context.Context, text, target string) (string, string, error) {
+	return "[" + target + "] " + text, "en", nil
+}
+
+func (stubTranslator) Name() string { return "stub" }
+
+// TestGetPostTranslationHidden checks a hidden post isn't translated, even
+// when a translation is cached, which is looked up only after the post.
+func TestGetPostTranslationHidden(t *testing.T) {
+	store := newFakeStore()
+	hiddenAt := time.Now()
+	store.posts = []Post{{ID: 1, Content: "Blue hat", HiddenAt: &hiddenAt}}
+	h := newTestHandler(store, HandlerConfig{Translator: stubTranslator{}})
+
+	req := httptest.NewRequest(http.MethodGet, "/api/posts/1/translate?to=es", nil)
+	req.SetPathValue("id", "1")
+	rec := httptest.NewRecorder()
+	h.GetPostTranslation(rec, req)
+	assertError(t, rec, http.StatusNotFound, "Post not found")
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// querier runs statements, on the connection pool or in a transaction.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// WithTx runs fn with a Store whose statements all run in one transaction,
+// committed if fn returns nil and rolled back if it returns an error, so a
+// unit of work spanning several Store calls lands whole or not at all. A
+// WithTx inside another joins the outer transaction.
+func (db *DB) WithTx(ctx context.Context, fn func(tx Store) error) error {
+	if db.tx != nil {
+		return fn(db)
+	}
+
+	tx, err := db.pool.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := fn(&DB{pool: db.pool, conn: tx, tx: tx, savepoints: new(int)}); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// txn is a transaction begun by a DB method for its own statements. On a DB
+// from WithTx it is a savepoint instead: rolling back undoes only the
+// method's statements, and committing leaves the outcome to the outer
+// transaction.
+type txn struct {
+	querier
+	ctx context.Context
+	// tx is set when the txn is a transaction of its own
+	tx        *sql.Tx
+	savepoint string
+	done      bool
+}
+
+func (db *DB) begin(ctx context.Context) (*txn, error) {
+	if db.tx == nil {
+		tx, err := db.pool.BeginTx(ctx, nil)
+		if err != nil {
+			return nil, err
+		}
+		return &txn{querier: tx, ctx: ctx, tx: tx}, nil
+	}
+
+	*db.savepoints++
+	t := &txn{querier: db.tx, ctx: ctx, savepoint: fmt.Sprintf("sp%d", *db.savepoints)}
+	if _, err := db.tx.ExecContext(ctx, "SAVEPOINT "+t.savepoint); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *txn) Commit() error {
+	if t.tx != nil {
+		return t.tx.Commit()
+	}
+	if t.done {
+		return sql.ErrTxDone
+	}
+	t.done = true
+	_, err := t.ExecContext(t.ctx, "RELEASE SAVEPOINT "+t.savepoint)
+	return err
+}
+
+func (t *txn) Rollback() error {
+	if t.tx != nil {
+		return t.tx.Rollback()
+	}
+	if t.done {
+		return sql.ErrTxDone
+	}
+	t.done = true
+	_, err := t.ExecContext(context.WithoutCancel(t.ctx), "ROLLBACK TO SAVEPOINT "+t.savepoint)
+	return err
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// TestWithTx checks that a failed unit of work leaves nothing behind, and
+// that a method with a transaction of its own, run inside WithTx, only
+// undoes its own statements when it fails.
+func TestWithTx(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	event := fmt.Sprintf("Tx Test %d", time.Now().UnixNano())
+	req := CreatePostRequest{EventName: event, Content: "hello", Age: 25, Location: "x"}
+	posts := func() int {
+		posts, err := db.GetPosts(ctx, PostFilter{Event: event}, 50, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(posts)
+	}
+
+	errAbort := errors.New("abort")
+	err := db.WithTx(ctx, func(tx Store) error {
+		if _, err := tx.CreatePost(ctx, req, "tx-test", ""); err != nil {
+			return err
+		}
+		return errAbort
+	})
+	if !errors.Is(err, errAbort) {
+		t.Fatalf("WithTx = %v, want the error from fn", err)
+	}
+	if n := posts(); n != 0 {
+		t.Fatalf("%d posts after rollback, want 0", n)
+	}
+
+	err = db.WithTx(ctx, func(tx Store) error {
+		post, err := tx.CreatePost(ctx, req, "tx-test", hashToken("token"))
+		if err != nil {
+			return err
+		}
+		// EditPost fails inside its own savepoint, which must leave the
+		// transaction usable
+		if _, err := tx.EditPost(ctx, post.ID, hashToken("wrong"), "edited", nil, 0); !errors.Is(err, errEditNotAllowed) {
+			t.Errorf("EditPost with the wrong token = %v, want errEditNotAllowed", err)
+		}
+		_, err = tx.EditPost(ctx, post.ID, hashToken("token"), "edited", nil, 0)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := posts(); n != 1 {
+		t.Fatalf("%d posts after commit, want 1", n)
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	validationStatsFlushInterval = time.Minute
+	defaultValidationStatsDays   = 30
+	maxValidationStatsDays       = 365
+)
+
+// Rules for rejections that aren't a *ValidationError with a Rule
+const (
+	ruleTermsOutdated = "terms_version.outdated"
+	ruleOther         = "other"
+)
+
+type validationStatKey struct {
+	day  string
+	rule string
+}
+
+// ValidationStats counts the validation rules that reject post submissions
+// per UTC day in memory and periodically folds the counts into the
+// validation_rejections table, so a burst of bad requests costs no more
+// than a steady trickle. Previews aren't counted.
+type ValidationStats struct {
+	db *DB
+
+	mu     sync.Mutex
+	counts map[validationStatKey]int64
+}
+
+func NewValidationStats(db *DB) *ValidationStats {
+	return &ValidationStats{db: db, counts: make(map[validationStatKey]int64)}
+}
+
+// rejectionRule names the rule behind a rejected submission.
+func rejectionRule(err error) string {
+	var validation *ValidationError
+	var terms *TermsChangedError
+	switch {
+	case errors.As(err, &validation) && validation.Rule != "":
+		return validation.Rule
+	case errors.As(err, &terms):
+		return ruleTermsOutdated
+	default:
+		return ruleOther
+	}
+}
+
+// Record counts a rejected submission. Errors that aren't the post's fault,
+// such as a failed database call, aren't counted.
+func (s *ValidationStats) Record(err error) {
+	if s == nil {
+		return
+	}
+	var validation *ValidationError
+	var terms *TermsChangedError
+	if !errors.As(err, &validation) && !errors.As(err, &terms) {
+		return
+	}
+
+	key := validationStatKey{day: time.Now().UTC().Format(time.DateOnly), rule: rejectionRule(err)}
+	s.mu.Lock()
+	s.counts[key]++
+	s.mu.Unlock()
+}
+
+// Run flushes counts every validationStatsFlushInterval, and once more
+// when ctx is cancelled.
+func (s *ValidationStats) Run(ctx context.Context) {
+	ticker := time.NewTicker(validationStatsFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.flush(ctx)
+		case <-ctx.Done():
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			s.flush(shutdownCtx)
+			cancel()
+			return
+		}
+	}
+}
+
+func (s *ValidationStats) flush(ctx context.Context) {
+	s.mu.Lock()
+	counts := s.counts
+	s.counts = make(map[validationStatKey]int64)
+	s.mu.Unlock()
+
+	for key, count := range counts {
+		if err := s.db.AddValidationRejections(ctx, key.day, key.rule, count); err != nil {
+			log.Printf("Error recording validation rejections: %v", err)
+		}
+	}
+}
+
+// ValidationRuleStats is how often one rule rejected submissions.
+type ValidationRuleStats struct {
+	Rule  string `json:"rule"`
+	Total int64  `json:"total"`
+	// Days are the daily counts, newest first, leaving out days without
+	// rejections
+	Days []ValidationDay `json:"days"`
+}
+
+type ValidationDay struct {
+	Day   string `json:"day"`
+	Count int64  `json:"count"`
+}
+
+// ValidationRejection is one rule's count for one day, as stored.
+type ValidationRejection struct {
+	Day   string
+	Rule  string
+	Count int64
+}
+
+// GetValidationStats handles GET /admin/stats/validation. Rules are listed
+// most frequent first over the last ?days= days (default 30).
+func (h *Handler) GetValidationStats(w http.ResponseWriter, r *http.Request) {
+	days := defaultValidationStatsDays
+	if v := r.URL.Query().Get("days"); v != "" {
+		var err error
+		days, err = strconv.Atoi(v)
+		if err != nil || days < 1 || days > maxValidationStatsDays {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("days must be between 1 and %d", maxValidationStatsDays))
+			return
+		}
+	}
+
+	rejections, err := h.db.GetValidationRejections(r.Context(), days)
+	if err != nil {
+		log.Printf("Error getting validation rejections: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve validation stats")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, summarizeRejections(rejections))
+}
+
+// summarizeRejections groups daily counts by rule, most frequent first.
+func summarizeRejections(rejections []ValidationRejection) []ValidationRuleStats {
+	byRule := make(map[string]*ValidationRuleStats)
+	stats := []ValidationRuleStats{}
+	var order []string
+	for _, rej := range rejections {
+		s, ok := byRule[rej.Rule]
+		if !ok {
+			s = &ValidationRuleStats{Rule: rej.Rule}
+			byRule[rej.Rule] = s
+			order = append(order, rej.Rule)
+		}
+		s.Total += rej.Count
+		s.Days = append(s.Days, ValidationDay{Day: rej.Day, Count: rej.Count})
+	}
+	for _, rule := range order {
+		stats = append(stats, *byRule[rule])
+	}
+	sort.SliceStable(stats, func(i, j int) bool {
+		if stats[i].Total != stats[j].Total {
+			return stats[i].Total > stats[j].Total
+		}
+		return stats[i].Rule < stats[j].Rule
+	})
+	return stats
+}
+
+func (db *DB) AddValidationRejections(ctx context.Context, day, rule string, count int64) error {
+	_, err := db.conn.ExecContext(ctx, `
+		INSERT INTO validation_rejections (day, rule, rejection_count)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (day, rule)
+		DO UPDATE SET rejection_count = validation_rejections.rejection_count + EXCLUDED.rejection_count
+	`, day, rule, count)
+	if err != nil {
+		return fmt.Errorf("failed to add validation rejections: %w", err)
+	}
+	return nil
+}
+
+// GetValidationRejections returns the daily counts for the last `days`
+// days, newest first.
+func (db *DB) GetValidationRejections(ctx context.Context, days int) ([]ValidationRejection, error) {
+	rows, err := db.conn.QueryContext(ctx, `
+		SELECT TO_CHAR(day, 'YYYY-MM-DD'), rule, rejection_count
+		FROM validation_rejections
+		WHERE day > CURRENT_DATE - $1::int
+		ORDER BY day DESC, rule
+	`, days)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query validation rejections: %w", err)
+	}
+	defer rows.Close()
+
+	var rejections []ValidationRejection
+	for rows.Next() {
+		var rej ValidationRejection
+		if err := rows.Scan(&rej.Day, &rej.Rule, &rej.Count); err != nil {
+			return nil, fmt.Errorf("failed to scan validation rejection: %w", err)
+		}
+		rejections = append(rejections, rej)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating validation rejections: %w", err)
+	}
+
+	return rejections, nil
+}
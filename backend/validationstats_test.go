@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestRejectionRule(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{&ValidationError{Message: "content is required", Rule: "content.required"}, "content.required"},
+		{fmt.Errorf("wrapped: %w", &ValidationError{Message: "x", Rule: "age.range"}), "age.range"},
+		{&ValidationError{Message: "no rule"}, ruleOther},
+		{&TermsChangedError{Version: "2"}, ruleTermsOutdated},
+	}
+	for _, tt := range tests {
+		if got := rejectionRule(tt.err); got != tt.want {
+			t.Errorf("rejectionRule(%v) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestValidationStatsRecord(t *testing.T) {
+	stats := NewValidationStats(nil)
+	stats.Record(&ValidationError{Message: "content is required", Rule: "content.required"})
+	stats.Record(&ValidationError{Message: "content is required", Rule: "content.required"})
+	stats.Record(&BannedError{})
+	stats.Record(errors.New("database unavailable"))
+
+	if len(stats.counts) != 1 {
+		t.Fatalf("counts = %v, want only content.required", stats.counts)
+	}
+	for key, count := range stats.counts {
+		if key.rule != "content.required" || count != 2 {
+			t.Errorf("counts = %v, want content.required twice", stats.counts)
+		}
+	}
+
+	// A nil ValidationStats, as when tests leave it out, ignores records
+	var off *ValidationStats
+	off.Record(&ValidationError{Message: "x"})
+}
+
+func TestSummarizeRejections(t *testing.T) {
+	got := summarizeRejections([]ValidationRejection{
+		{Day: "2026-07-02", Rule: "age.range", Count: 3},
+		{Day: "2026-07-02", Rule: "content.max_length", Count: 1},
+		{Day: "2026-07-01", Rule: "content.max_length", Count: 9},
+		{Day: "2026-07-01", Rule: "location.required", Count: 3},
+	})
+	want := []ValidationRuleStats{
+		{Rule: "content.max_length", Total: 10, Days: []ValidationDay{{"2026-07-02", 1}, {"2026-07-01", 9}}},
+		{Rule: "age.range", Total: 3, Days: []ValidationDay{{"2026-07-02", 3}}},
+		{Rule: "location.required", Total: 3, Days: []ValidationDay{{"2026-07-01", 3}}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("summarizeRejections = %+v, want %+v", got, want)
+	}
+
+	if empty := summarizeRejections(nil); empty == nil || len(empty) != 0 {
+		t.Errorf("summarizeRejections(nil) = %#v, want an empty list", empty)
+	}
+}
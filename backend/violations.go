@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Cooldown escalation for keys that keep hitting a rate limit. The first
+// violation only waits out the limit; each one after adds a cooldown,
+// doubling from violationCooldownBase up to violationCooldownMax, so a
+// script retrying in a loop backs off further each time without ever
+// being banned. Strikes are forgotten after violationDecay without one.
+const (
+	violationCooldownBase = time.Minute
+	violationCooldownMax  = time.Hour
+	violationDecay        = 24 * time.Hour
+)
+
+// rateCooldownCode is the error code of a request refused because its key
+// is cooling down, rather than over the limit itself.
+const rateCooldownCode = "cooldown"
+
+// violationCooldown is the cooldown for a key's strikes-th violation.
+func violationCooldown(strikes int) time.Duration {
+	if strikes < 2 {
+		return 0
+	}
+	cooldown := violationCooldownBase
+	for i := 2; i < strikes && cooldown < violationCooldownMax; i++ {
+		cooldown *= 2
+	}
+	return min(cooldown, violationCooldownMax)
+}
+
+// respondCoolingDown writes the 429 for a request from a key still cooling
+// down after repeated violations.
+func respondCoolingDown(w http.ResponseWriter, cooldown time.Duration) {
+	respondRateLimited(w, rateCooldownCode,
+		fmt.Sprintf("Too many requests over the limit. Please wait %s before trying again.", cooldown.Round(time.Second)),
+		cooldown)
+}
+
+// RateViolation is a key's record of exceeding a limiter.
+type RateViolation struct {
+	Strikes         int
+	LastViolationAt time.Time
+	// Cooldown is how much longer the key is blocked for
+	Cooldown time.Duration
+}
+
+// RecordRateViolation counts a violation by key, escalating its cooldown,
+// and returns the cooldown it now has to wait out.
+func (db *DB) RecordRateViolation(ctx context.Context, limiter, key string) (time.Duration, error) {
+	tx, err := db.begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin rate violation: %w", err)
+	}
+	defer tx.Rollback()
+
+	var strikes int
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO rate_violations (limiter, key, strikes, last_violation_at, blocked_until)
+		VALUES ($1, $2, 1, NOW(), NOW())
+		ON CONFLICT (limiter, key) DO UPDATE SET
+			strikes = CASE WHEN rate_violations.last_violation_at <= NOW() - make_interval(secs => $3)
+				THEN 1 ELSE rate_violations.strikes + 1 END,
+			last_violation_at = NOW()
+		RETURNING strikes
+	`, limiter, key, violationDecay.Seconds()).Scan(&strikes)
+	if err != nil {
+		return 0, fmt.Errorf("failed to record rate violation: %w", err)
+	}
+
+	cooldown := violationCooldown(strikes)
+	_, err = tx.ExecContext(ctx,
+		"UPDATE rate_violations SET blocked_until = NOW() + make_interval(secs => $3) WHERE limiter = $1 AND key = $2",
+		limiter, key, cooldown.Seconds(),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to record rate violation: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit rate violation: %w", err)
+	}
+	return cooldown, nil
+}
+
+// GetRateViolation returns key's violations of limiter, or nil if it has
+// none that still count.
+func (db *DB) GetRateViolation(ctx context.Context, limiter, key string) (*RateViolation, error) {
+	var v RateViolation
+	var cooldownSeconds float64
+	err := db.conn.QueryRowContext(ctx, `
+		SELECT strikes, last_violation_at, GREATEST(EXTRACT(EPOCH FROM blocked_until - NOW()), 0)
+		FROM rate_violations
+		WHERE limiter = $1 AND key = $2 AND last_violation_at > NOW() - make_interval(secs => $3)
+	`, limiter, key, violationDecay.Seconds()).Scan(&v.Strikes, &v.LastViolationAt, &cooldownSeconds)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get rate violation: %w", err)
+	}
+	v.Cooldown = time.Duration(cooldownSeconds * float64(time.Second))
+	return &v, nil
+}
+
+// ClearRateViolations forgets key's violations of every limiter, lifting
+// any cooldown, and reports whether it had any.
+func (db *DB) ClearRateViolations(ctx context.Context, key string) (bool, error) {
+	result, err := db.conn.ExecContext(ctx, "DELETE FROM rate_violations WHERE key = $1", key)
+	if err != nil {
+		return false, fmt.Errorf("failed to clear rate violations: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to clear rate violations: %w", err)
+	}
+
+	return affected > 0, nil
+}
+
+// DeleteExpiredRateViolations deletes violations that have decayed and
+// whose cooldown is over.
+func (db *DB) DeleteExpiredRateViolations(ctx context.Context, limiter string) (int64, error) {
+	result, err := db.conn.ExecContext(ctx, `
+		DELETE FROM rate_violations
+		WHERE limiter = $1
+		AND last_violation_at <= NOW() - make_interval(secs => $2)
+		AND blocked_until <= NOW()
+	`, limiter, violationDecay.Seconds())
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired rate violations: %w", err)
+	}
+	return result.RowsAffected()
+}
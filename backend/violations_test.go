@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestViolationCooldown(t *testing.T) {
+	for strikes, want := range map[int]time.Duration{
+		0:  0,
+		1:  0,
+		2:  time.Minute,
+		3:  2 * time.Minute,
+		4:  4 * time.Minute,
+		7:  32 * time.Minute,
+		8:  time.Hour,
+		50: time.Hour,
+	} {
+		if got := violationCooldown(strikes); got != want {
+			t.Errorf("violationCooldown(%d) = %v, want %v", strikes, got, want)
+		}
+	}
+}
+
+// TestRateLimiterEscalates checks repeated violations earn growing
+// cooldowns, during which even requests under the limit are refused.
+func TestRateLimiterEscalates(t *testing.T) {
+	db := openTestDB(t)
+	limiter := newTestRateLimiter(t, db, RateLimitPolicy{Requests: 1, WindowMinutes: 1})
+	handler := limiter.Limit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	}))
+	post := func() (int, RateLimitedError) {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/posts", nil))
+		var body RateLimitedError
+		json.Unmarshal(rec.Body.Bytes(), &body)
+		return rec.Code, body
+	}
+
+	if code, _ := post(); code != http.StatusCreated {
+		t.Fatalf("first post: status = %d, want 201", code)
+	}
+	// The first violation only waits out the window
+	if code, body := post(); code != http.StatusTooManyRequests || body.Code != "rate_limited" || body.RetryAfterSeconds > 61 {
+		t.Fatalf("first violation: status %d, body %+v", code, body)
+	}
+
+	// Later violations add a doubling cooldown beyond the window, and
+	// nothing gets through until it is over
+	key := hashIP(getIP(httptest.NewRequest(http.MethodPost, "/api/posts", nil)))
+	db.conn.ExecContext(context.Background(),
+		"UPDATE rate_violations SET strikes = 4 WHERE limiter = $1", limiter.name)
+	if code, body := post(); code != http.StatusTooManyRequests || body.RetryAfterSeconds < int((8*time.Minute).Seconds()) {
+		t.Fatalf("fifth violation: status %d, body %+v, want an 8 minute cooldown", code, body)
+	}
+	if code, body := post(); code != http.StatusTooManyRequests || body.Code != rateCooldownCode {
+		t.Fatalf("during cooldown: status %d, body %+v", code, body)
+	}
+
+	status, err := limiter.Status(context.Background(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Violations != 5 || status.CooldownSeconds < 1 {
+		t.Errorf("status violations = %d, cooldown = %ds; want 5 and a cooldown", status.Violations, status.CooldownSeconds)
+	}
+
+	if found, err := db.ClearRateViolations(context.Background(), key); err != nil || !found {
+		t.Fatalf("ClearRateViolations = %v, %v", found, err)
+	}
+	if code, body := post(); body.Code == rateCooldownCode {
+		t.Errorf("after clearing: status %d, body %+v, want the cooldown lifted", code, body)
+	}
+}
+
+func TestClearRateViolationsNeedsKey(t *testing.T) {
+	h := newTestHandler(newFakeStore(), HandlerConfig{})
+	rec := httptest.NewRecorder()
+	h.ClearRateViolations(rec, httptest.NewRequest(http.MethodDelete, "/admin/rate-limits/violations", nil))
+	assertError(t, rec, http.StatusBadRequest, "ip or key is required")
+}
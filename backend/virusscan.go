@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	uploadScanInterval    = 5 * time.Second
+	uploadScanBatchSize   = 20
+	uploadScanMaxAttempts = 5
+	uploadScanTimeout     = time.Minute
+	// clamdChunkSize is the size of the chunks streamed to clamd, which
+	// must be below its StreamMaxLength
+	clamdChunkSize = 64 << 10
+)
+
+// Scan statuses of an attachment. Only clean attachments are served.
+const (
+	scanPending     = "pending"
+	scanClean       = "clean"
+	scanQuarantined = "quarantined"
+	// scanFailed attachments couldn't be scanned after uploadScanMaxAttempts
+	scanFailed = "failed"
+)
+
+// scannerActor is the audit log actor of the upload scanner.
+const scannerActor = "virus-scanner"
+
+// VirusScanner checks files for malware.
+type VirusScanner interface {
+	// Scan returns the name of the threat found in data, or "" if it is
+	// clean.
+	Scan(ctx context.Context, data []byte) (string, error)
+}
+
+// NewVirusScanner builds the scanner named by VIRUS_SCANNER. It returns nil
+// when scanning is disabled. addr is a clamd socket, as unix:///path or
+// tcp://host:port, or an ICAP service URL, as icap://host:port/service.
+func NewVirusScanner(kind, addr string) (VirusScanner, error) {
+	switch kind {
+	case "", "none":
+		return nil, nil
+	case "clamd":
+		u, err := url.Parse(addr)
+		if err != nil || (u.Scheme != "unix" && u.Scheme != "tcp") {
+			return nil, fmt.Errorf("VIRUS_SCANNER_ADDR must be unix:///path or tcp://host:port for clamd")
+		}
+		if u.Scheme == "unix" {
+			return &ClamdScanner{network: "unix", address: u.Path}, nil
+		}
+		return &ClamdScanner{network: "tcp", address: u.Host}, nil
+	case "icap":
+		u, err := url.Parse(addr)
+		if err != nil || u.Scheme != "icap" || u.Host == "" {
+			return nil, fmt.Errorf("VIRUS_SCANNER_ADDR must be icap://host:port/service for ICAP")
+		}
+		if u.Port() == "" {
+			u.Host = net.JoinHostPort(u.Hostname(), "1344")
+		}
+		return &ICAPScanner{service: u}, nil
+	default:
+		return nil, fmt.Errorf("unknown virus scanner %q", kind)
+	}
+}
+
+// dialScanner connects to a scanner, bounding the whole exchange by ctx.
+func dialScanner(ctx context.Context, network, address string) (net.Conn, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to scanner: %w", err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	return conn, nil
+}
+
+// ClamdScanner streams files to a clamd daemon with INSTREAM.
+type ClamdScanner struct {
+	network string
+	address string
+}
+
+func (s *ClamdScanner) Scan(ctx context.Context, data []byte) (string, error) {
+	conn, err := dialScanner(ctx, s.network, s.address)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	w := bufio.NewWriter(conn)
+	w.WriteString("zINSTREAM\x00")
+	var size [4]byte
+	for len(data) > 0 {
+		chunk := data[:min(len(data), clamdChunkSize)]
+		data = data[len(chunk):]
+		binary.BigEndian.PutUint32(size[:], uint32(len(chunk)))
+		w.Write(size[:])
+		w.Write(chunk)
+	}
+	binary.BigEndian.PutUint32(size[:], 0)
+	w.Write(size[:])
+	if err := w.Flush(); err != nil {
+		return "", fmt.Errorf("failed to send file to clamd: %w", err)
+	}
+
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && err != io.EOF {
+		return "", fmt.Errorf("failed to read clamd reply: %w", err)
+	}
+	return parseClamdReply(strings.TrimRight(reply, "\x00\n"))
+}
+
+// parseClamdReply reads a reply like "stream: OK" or
+// "stream: Eicar-Signature FOUND".
+func parseClamdReply(reply string) (string, error) {
+	result := strings.TrimPrefix(reply, "stream: ")
+	switch {
+	case result == "OK":
+		return "", nil
+	case strings.HasSuffix(result, " FOUND"):
+		return strings.TrimSuffix(result, " FOUND"), nil
+	default:
+		return "", fmt.Errorf("clamd returned %q", reply)
+	}
+}
+
+// ICAPScanner sends files to an ICAP antivirus service in a RESPMOD
+// request, as if they were an HTTP response being proxied.
+type ICAPScanner struct {
+	service *url.URL
+}
+
+func (s *ICAPScanner) Scan(ctx context.Context, data []byte) (string, error) {
+	conn, err := dialScanner(ctx, "tcp", s.service.Host)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	httpHeader := "HTTP/1.1 200 OK\r\nContent-Type: application/octet-stream\r\nContent-Length: " + strconv.Itoa(len(data)) + "\r\n\r\n"
+
+	var req bytes.Buffer
+	fmt.Fprintf(&req, "RESPMOD %s ICAP/1.0\r\n", s.service)
+	fmt.Fprintf(&req, "Host: %s\r\n", s.service.Host)
+	req.WriteString("Allow: 204\r\n")
+	fmt.Fprintf(&req, "Encapsulated: res-hdr=0, res-body=%d\r\n\r\n", len(httpHeader))
+	req.WriteString(httpHeader)
+	fmt.Fprintf(&req, "%x\r\n", len(data))
+	req.Write(data)
+	req.WriteString("\r\n0\r\n\r\n")
+	if _, err := conn.Write(req.Bytes()); err != nil {
+		return "", fmt.Errorf("failed to send file to ICAP server: %w", err)
+	}
+
+	r := textproto.NewReader(bufio.NewReader(conn))
+	status, err := r.ReadLine()
+	if err != nil {
+		return "", fmt.Errorf("failed to read ICAP reply: %w", err)
+	}
+	header, err := r.ReadMIMEHeader()
+	if err != nil && err != io.EOF {
+		return "", fmt.Errorf("failed to read ICAP reply: %w", err)
+	}
+	return parseICAPReply(status, header)
+}
+
+// parseICAPReply reads an ICAP status line and headers. A 204 means the
+// file is unchanged, so clean; a 200 means the server replaced it, which
+// antivirus services do to block a threat.
+func parseICAPReply(status string, header textproto.MIMEHeader) (string, error) {
+	fields := strings.Fields(status)
+	if len(fields) < 2 || !strings.HasPrefix(fields[0], "ICAP/") {
+		return "", fmt.Errorf("ICAP server returned %q", status)
+	}
+
+	switch fields[1] {
+	case "204":
+		return "", nil
+	case "200":
+		// X-Infection-Found: Type=0; Resolution=2; Threat=Eicar-Signature;
+		for _, part := range strings.Split(header.Get("X-Infection-Found"), ";") {
+			if threat, ok := strings.CutPrefix(strings.TrimSpace(part), "Threat="); ok && threat != "" {
+				return threat, nil
+			}
+		}
+		if v := header.Get("X-Violations-Found"); v != "" {
+			return strings.TrimSpace(v), nil
+		}
+		return "blocked by ICAP server", nil
+	default:
+		return "", fmt.Errorf("ICAP server returned %q", status)
+	}
+}
+
+// UploadScanner scans pending uploads in the background. Clean uploads
+// become visible; infected ones are quarantined: never served, and kept
+// rather than cleaned up so they can be investigated.
+type UploadScanner struct {
+	db      *DB
+	media   MediaStore
+	scanner VirusScanner
+}
+
+func NewUploadScanner(db *DB, media MediaStore, scanner VirusScanner) *UploadScanner {
+	return &UploadScanner{db: db, media: media, scanner: scanner}
+}
+
+// Run scans every uploadScanInterval until ctx is cancelled.
+func (s *UploadScanner) Run(ctx context.Context) {
+	ticker := time.NewTicker(uploadScanInterval)
+	defer ticker.Stop()
+
+	for {
+		s.scanPending(ctx)
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *UploadScanner) scanPending(ctx context.Context) {
+	attachments, err := s.db.GetUnscannedAttachments(ctx, uploadScanBatchSize)
+	if err != nil {
+		log.Printf("Error listing uploads to scan: %v", err)
+		return
+	}
+
+	for _, a := range attachments {
+		if ctx.Err() != nil {
+			return
+		}
+		threat, err := s.scan(ctx, a)
+		if err != nil {
+			log.Printf("Error scanning attachment %d: %v", a.ID, err)
+			if err := s.db.RecordScanFailure(ctx, a.ID, uploadScanMaxAttempts); err != nil {
+				log.Printf("Error recording scan failure for attachment %d: %v", a.ID, err)
+			}
+			continue
+		}
+
+		status := scanClean
+		if threat != "" {
+			status = scanQuarantined
+		}
+		if err := s.db.SetScanResult(ctx, a.ID, status, threat); err != nil {
+			log.Printf("Error saving scan result for attachment %d: %v", a.ID, err)
+			continue
+		}
+		if threat != "" {
+			log.Printf("Quarantined attachment %d: %s", a.ID, threat)
+			details := map[string]interface{}{"threat": threat, "storage_key": a.storageKey, "post_id": a.postID}
+			if err := s.db.RecordAudit(ctx, scannerActor, "attachment.quarantine", "attachment", strconv.Itoa(a.ID), details); err != nil {
+				log.Printf("Error recording audit entry for attachment.quarantine: %v", err)
+			}
+		}
+	}
+}
+
+func (s *UploadScanner) scan(ctx context.Context, a Attachment) (string, error) {
+	data, err := s.media.Get(ctx, a.storageKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to read media: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, uploadScanTimeout)
+	defer cancel()
+	return s.scanner.Scan(ctx, data)
+}
+
+// GetUnscannedAttachments returns pending uploads, oldest first.
+func (db *DB) GetUnscannedAttachments(ctx context.Context, limit int) ([]Attachment, error) {
+	return db.queryAttachments(ctx, `
+		SELECT `+attachmentColumns+`
+		FROM attachments
+		WHERE scan_status = 'pending'
+		ORDER BY id
+		LIMIT $1
+	`, limit)
+}
+
+// SetScanResult records the outcome of scanning a pending upload.
+func (db *DB) SetScanResult(ctx context.Context, id int, status, threat string) error {
+	_, err := db.conn.ExecContext(ctx, `
+		UPDATE attachments
+		SET scan_status = $2, scan_threat = NULLIF($3, '')
+		WHERE id = $1 AND scan_status = 'pending'
+	`, id, status, threat)
+	if err != nil {
+		return fmt.Errorf("failed to save scan result: %w", err)
+	}
+	return nil
+}
+
+// RecordScanFailure counts a failed scan, giving up on the upload after
+// maxAttempts.
+func (db *DB) RecordScanFailure(ctx context.Context, id, maxAttempts int) error {
+	_, err := db.conn.ExecContext(ctx, `
+		UPDATE attachments
+		SET scan_attempts = scan_attempts + 1,
+			scan_status = CASE WHEN scan_attempts + 1 >= $2 THEN $3 ELSE scan_status END
+		WHERE id = $1 AND scan_status = 'pending'
+	`, id, maxAttempts, scanFailed)
+	if err != nil {
+		return fmt.Errorf("failed to record scan failure: %w", err)
+	}
+	return nil
+}
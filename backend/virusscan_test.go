@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+)
+
+// serveOnce accepts one connection on a loopback listener and hands it to
+// handle, returning the listener's address.
+func serveOnce(t *testing.T, handle func(conn net.Conn)) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("can't listen on loopback: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		handle(conn)
+	}()
+	return ln.Addr().String()
+}
+
+func TestClamdScanner(t *testing.T) {
+	data := bytes.Repeat([]byte("x"), clamdChunkSize+10)
+	var received []byte
+
+	addr := serveOnce(t, func(conn net.Conn) {
+		r := bufio.NewReader(conn)
+		if cmd, _ := r.ReadString(0); cmd != "zINSTREAM\x00" {
+			conn.Write([]byte("UNKNOWN COMMAND\x00"))
+			return
+		}
+		for {
+			var size uint32
+			if err := binary.Read(r, binary.BigEndian, &size); err != nil || size == 0 {
+				break
+			}
+			chunk := make([]byte, size)
+			io.ReadFull(r, chunk)
+			received = append(received, chunk...)
+		}
+		conn.Write([]byte("stream: Eicar-Signature FOUND\x00"))
+	})
+
+	scanner, err := NewVirusScanner("clamd", "tcp://"+addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	threat, err := scanner.Scan(context.Background(), data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if threat != "Eicar-Signature" {
+		t.Errorf("threat = %q, want Eicar-Signature", threat)
+	}
+	if !bytes.Equal(received, data) {
+		t.Errorf("clamd received %d bytes, want %d", len(received), len(data))
+	}
+}
+
+func TestParseClamdReply(t *testing.T) {
+	tests := []struct {
+		reply   string
+		threat  string
+		wantErr bool
+	}{
+		{reply: "stream: OK"},
+		{reply: "stream: Win.Test.EICAR_HDB-1 FOUND", threat: "Win.Test.EICAR_HDB-1"},
+		{reply: "INSTREAM size limit exceeded. ERROR", wantErr: true},
+		{reply: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		threat, err := parseClamdReply(tt.reply)
+		if threat != tt.threat || (err != nil) != tt.wantErr {
+			t.Errorf("parseClamdReply(%q) = %q, %v", tt.reply, threat, err)
+		}
+	}
+}
+
+func TestICAPScanner(t *testing.T) {
+	addr := serveOnce(t, func(conn net.Conn) {
+		r := textproto.NewReader(bufio.NewReader(conn))
+		line, _ := r.ReadLine()
+		if !strings.HasPrefix(line, "RESPMOD icap://") {
+			conn.Write([]byte("ICAP/1.0 400 Bad Request\r\n\r\n"))
+			return
+		}
+		r.ReadMIMEHeader()
+		conn.Write([]byte("ICAP/1.0 200 OK\r\nX-Infection-Found: Type=0; Resolution=2; Threat=Eicar-Test;\r\nEncapsulated: null-body=0\r\n\r\n"))
+	})
+
+	scanner, err := NewVirusScanner("icap", "icap://"+addr+"/avscan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	threat, err := scanner.Scan(context.Background(), []byte("file"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if threat != "Eicar-Test" {
+		t.Errorf("threat = %q, want Eicar-Test", threat)
+	}
+}
+
+func TestParseICAPReply(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  string
+		header  textproto.MIMEHeader
+		threat  string
+		wantErr bool
+	}{
+		{name: "clean", status: "ICAP/1.0 204 No Content"},
+		{name: "infected", status: "ICAP/1.0 200 OK", header: textproto.MIMEHeader{"X-Infection-Found": {"Type=0; Resolution=2; Threat=Eicar;"}}, threat: "Eicar"},
+		{name: "violation", status: "ICAP/1.0 200 OK", header: textproto.MIMEHeader{"X-Violations-Found": {"1"}}, threat: "1"},
+		{name: "blocked", status: "ICAP/1.0 200 OK", threat: "blocked by ICAP server"},
+		{name: "server error", status: "ICAP/1.0 500 Server Error", wantErr: true},
+		{name: "not ICAP", status: "HTTP/1.1 200 OK", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			threat, err := parseICAPReply(tt.status, tt.header)
+			if threat != tt.threat || (err != nil) != tt.wantErr {
+				t.Errorf("parseICAPReply = %q, %v", threat, err)
+			}
+		})
+	}
+}
+
+func TestNewVirusScanner(t *testing.T) {
+	for _, tt := range []struct{ kind, addr string }{
+		{"clamd", "/var/run/clamd.sock"},
+		{"icap", "http://scanner:1344/avscan"},
+		{"sophos", ""},
+	} {
+		if _, err := NewVirusScanner(tt.kind, tt.addr); err == nil {
+			t.Errorf("NewVirusScanner(%q, %q) succeeded, want error", tt.kind, tt.addr)
+		}
+	}
+	if s, err := NewVirusScanner("", ""); s != nil || err != nil {
+		t.Errorf("disabled scanner = %v, %v", s, err)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// hotListTTL is how long the events list and trending events are
+	// served from memory; new events show up within it
+	hotListTTL = 30 * time.Second
+	// warmConnections is how many pool connections warming prepares the
+	// hot queries on, the pool's idle connection count
+	warmConnections = 2
+	warmupTimeout   = 30 * time.Second
+	defaultPageSize = 50
+)
+
+// listCache holds the result of one hot query for hotListTTL. Only the
+// unfiltered first page every visitor loads is cached; anything else goes
+// to the database.
+type listCache[T any] struct {
+	mu      sync.Mutex
+	value   T
+	fetched time.Time
+}
+
+// get returns the cached list, refilling it with fill once it is stale.
+// Concurrent callers wait for one refill rather than each querying.
+func (c *listCache[T]) get(ctx context.Context, fill func(context.Context) (T, error)) (T, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.fetched.IsZero() && time.Since(c.fetched) < hotListTTL {
+		return c.value, nil
+	}
+	value, err := fill(ctx)
+	if err != nil {
+		return value, err
+	}
+	c.value, c.fetched = value, time.Now()
+	return value, nil
+}
+
+// hotEvents is the first page of the events list in the default order.
+func (h *Handler) hotEvents(ctx context.Context) ([]string, error) {
+	return h.events.get(ctx, func(ctx context.Context) ([]string, error) {
+		return h.db.GetEvents(ctx, EventListOptions{Sort: "recent"}, defaultPageSize, 0)
+	})
+}
+
+// hotTrending is the discovery page's trending events.
+func (h *Handler) hotTrending(ctx context.Context) ([]EventSummary, error) {
+	return h.trending.get(ctx, func(ctx context.Context) ([]EventSummary, error) {
+		return h.db.TrendingEvents(ctx, time.Now().Add(-discoverTrendingWindow), discoverListSize)
+	})
+}
+
+// Warm fills the events list and trending caches, then runs the hottest
+// reads on several connections at once so each has its statements prepared
+// and its plans cached, so the first visitors after a deploy don't pay for
+// it.
+func (h *Handler) Warm(ctx context.Context) error {
+	if _, err := h.hotEvents(ctx); err != nil {
+		return err
+	}
+	if _, err := h.hotTrending(ctx); err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, warmConnections)
+	for i := 0; i < warmConnections; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := h.db.GetPosts(ctx, PostFilter{}, defaultPageSize, 0); err != nil {
+				errs <- err
+				return
+			}
+			if _, err := h.db.GetEvents(ctx, EventListOptions{Sort: "recent"}, defaultPageSize, 0); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	return <-errs
+}
+
+// Readiness answers /readyz, which fails until startup warming is done and
+// whenever the database is unreachable, so a rollout only sends traffic to
+// warm processes. /health stays a plain liveness check.
+type Readiness struct {
+	db    Store
+	ready atomic.Bool
+}
+
+func NewReadiness(db Store) *Readiness {
+	return &Readiness{db: db}
+}
+
+// WarmUp runs warm and then reports ready. A failed warm-up is logged and
+// doesn't hold the process back: it will serve, just cold.
+func (rd *Readiness) WarmUp(ctx context.Context, warm func(context.Context) error) {
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(ctx, warmupTimeout)
+	defer cancel()
+	if err := warm(ctx); err != nil {
+		log.Printf("Error warming caches: %v", err)
+	} else {
+		log.Printf("Warmed caches in %s", time.Since(start).Round(time.Millisecond))
+	}
+	rd.ready.Store(true)
+}
+
+// Get handles GET /readyz
+func (rd *Readiness) Get(w http.ResponseWriter, r *http.Request) {
+	if !rd.ready.Load() {
+		respondWithError(w, http.StatusServiceUnavailable, "Warming up")
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), statusPingTimeout)
+	defer cancel()
+	if err := rd.db.Ping(ctx); err != nil {
+		respondWithError(w, http.StatusServiceUnavailable, "Database unavailable")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, map[string]string{"status": "ready"})
+}
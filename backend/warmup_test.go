@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestListCache(t *testing.T) {
+	var c listCache[int]
+	fills := 0
+	fill := func(ctx context.Context) (int, error) {
+		fills++
+		return fills, nil
+	}
+	for i := 0; i < 3; i++ {
+		if got, err := c.get(context.Background(), fill); err != nil || got != 1 {
+			t.Fatalf("get = %d, %v; want the first fill", got, err)
+		}
+	}
+
+	c.fetched = c.fetched.Add(-hotListTTL)
+	if got, _ := c.get(context.Background(), fill); got != 2 {
+		t.Errorf("stale get = %d, want a refill", got)
+	}
+
+	c.fetched = c.fetched.Add(-hotListTTL)
+	failing := func(ctx context.Context) (int, error) { return 0, errFakeDB }
+	if _, err := c.get(context.Background(), failing); !errors.Is(err, errFakeDB) {
+		t.Errorf("failed refill = %v, want the error", err)
+	}
+	if got, _ := c.get(context.Background(), fill); got != 3 {
+		t.Errorf("get after a failed refill = %d, want another try", got)
+	}
+}
+
+// TestWarm checks that warming fills the events list cache, so the first
+// request is served from it.
+func TestWarm(t *testing.T) {
+	store := newFakeStore()
+	store.events = []string{"Glastonbury"}
+	h := newTestHandler(store, HandlerConfig{})
+	if err := h.Warm(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	store.fail["GetEvents"] = true
+	rec := httptest.NewRecorder()
+	h.GetEvents(rec, httptest.NewRequest(http.MethodGet, "/api/events", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "[\"Glastonbury\"]\n" {
+		t.Errorf("events = %d %s, want the warmed list", rec.Code, rec.Body)
+	}
+
+	store.fail = map[string]bool{"GetPosts": true}
+	if err := newTestHandler(store, HandlerConfig{}).Warm(context.Background()); !errors.Is(err, errFakeDB) {
+		t.Errorf("Warm = %v, want the query's error", err)
+	}
+}
+
+func TestReadiness(t *testing.T) {
+	store := newFakeStore()
+	rd := NewReadiness(store)
+	get := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		rd.Get(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		return rec
+	}
+
+	assertError(t, get(), http.StatusServiceUnavailable, "Warming up")
+
+	// A failed warm-up still lets the process serve
+	rd.WarmUp(context.Background(), func(ctx context.Context) error { return errFakeDB })
+	if rec := get(); rec.Code != http.StatusOK {
+		t.Errorf("status = %d after warming, want 200", rec.Code)
+	}
+
+	store.fail["Ping"] = true
+	assertError(t, get(), http.StatusServiceUnavailable, "Database unavailable")
+}
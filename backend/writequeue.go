@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	writeQueueMinRetry  = time.Second
+	writeQueueMaxRetry  = time.Minute
+	writeQueueStatusTTL = time.Hour
+	// writeQueueMaxAttempts is how many times a post that hits a database
+	// error is tried before it is dead-lettered, so one bad post can't hold
+	// up the queue behind it
+	writeQueueMaxAttempts = 8
+)
+
+// Outcomes of a queued post
+const (
+	postStatusPending   = "pending"
+	postStatusPublished = "published"
+	postStatusRejected  = "rejected"
+	postStatusFailed    = "failed"
+)
+
+// Codes of a rejected post
+const (
+	invalidPostCode  = "invalid"
+	termsChangedCode = "terms_changed"
+	bannedCode       = "banned"
+)
+
+// PostStatus is the outcome of a post accepted for later processing.
+type PostStatus struct {
+	Status string `json:"status"`
+	PostID int    `json:"post_id,omitempty"`
+	// PublicID is the published post's ID for public URLs
+	PublicID string `json:"public_id,omitempty"`
+	// Reason says why a post was rejected or failed, and Code whether the
+	// client should fix it or show the new terms and resubmit
+	Reason    string    `json:"reason,omitempty"`
+	Code      string    `json:"code,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// QueuedPostResponse is the 202 response for a queued post.
+type QueuedPostResponse struct {
+	Status    string `json:"status"`
+	StatusURL string `json:"status_url"`
+	// EditToken works once the post is published
+	EditToken string `json:"edit_token"`
+}
+
+type queuedPost struct {
+	token         string
+	req           CreatePostRequest
+	ipHash        string
+	editTokenHash string
+}
+
+// queuedPostDeadLetter is the payload of a post dead letter, enough to
+// queue it again under the same status token.
+type queuedPostDeadLetter struct {
+	Token         string            `json:"token"`
+	Post          CreatePostRequest `json:"post"`
+	IPHash        string            `json:"ip_hash"`
+	EditTokenHash string            `json:"edit_token_hash"`
+}
+
+// WriteQueue holds posts that arrived while the database was saturated, so
+// a spike delays posts rather than failing them. It is bounded and kept in
+// memory: when it is full posts fail as before, and posts still queued when
+// the server shuts down are lost. Statuses are kept for
+// writeQueueStatusTTL after a post is processed, on the server that
+// accepted it.
+type WriteQueue struct {
+	posts chan *queuedPost
+	// retryDelay is writeQueueMinRetry outside tests
+	retryDelay time.Duration
+
+	mu       sync.Mutex
+	statuses map[string]*PostStatus
+}
+
+// NewWriteQueue returns nil when size is 0, disabling the queue.
+func NewWriteQueue(size int) *WriteQueue {
+	if size <= 0 {
+		return nil
+	}
+	return &WriteQueue{
+		posts:      make(chan *queuedPost, size),
+		retryDelay: writeQueueMinRetry,
+		statuses:   make(map[string]*PostStatus),
+	}
+}
+
+func (q *WriteQueue) setStatus(token string, status PostStatus) {
+	status.UpdatedAt = time.Now()
+	q.mu.Lock()
+	q.statuses[token] = &status
+	q.mu.Unlock()
+}
+
+func (q *WriteQueue) status(token string) (PostStatus, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	status, ok := q.statuses[token]
+	if !ok {
+		return PostStatus{}, false
+	}
+	return *status, true
+}
+
+// enqueue adds a post, reporting false if the queue is full.
+func (q *WriteQueue) enqueue(p *queuedPost) bool {
+	q.setStatus(p.token, PostStatus{Status: postStatusPending})
+	select {
+	case q.posts <- p:
+		return true
+	default:
+		q.mu.Lock()
+		delete(q.statuses, p.token)
+		q.mu.Unlock()
+		return false
+	}
+}
+
+// pending is the number of posts waiting; a nil queue has none.
+func (q *WriteQueue) pending() int {
+	if q == nil {
+		return 0
+	}
+	return len(q.posts)
+}
+
+// pruneStatuses forgets outcomes older than writeQueueStatusTTL.
+func (q *WriteQueue) pruneStatuses() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for token, status := range q.statuses {
+		if status.Status != postStatusPending && time.Since(status.UpdatedAt) > writeQueueStatusTTL {
+			delete(q.statuses, token)
+		}
+	}
+}
+
+// queuePost accepts a post for the write queue without touching the
+// database; it is checked when it is processed.
+func (h *Handler) queuePost(w http.ResponseWriter, req CreatePostRequest, ipHash string) {
+	editToken := randomToken(16)
+	p := &queuedPost{
+		token:         randomToken(16),
+		req:           req,
+		ipHash:        ipHash,
+		editTokenHash: hashToken(editToken),
+	}
+	if !h.cfg.WriteQueue.enqueue(p) {
+		log.Printf("Write queue full, refusing post")
+		respondWithError(w, http.StatusServiceUnavailable, "We're receiving a lot of posts right now. Please try again in a minute.")
+		return
+	}
+
+	respondWithJSON(w, http.StatusAccepted, QueuedPostResponse{
+		Status:    postStatusPending,
+		StatusURL: "/api/post-status/" + p.token,
+		EditToken: editToken,
+	})
+}
+
+// GetPostStatus handles GET /api/post-status/{token}, for queued posts and
+// appeals. Clients poll it, so responses must not be cached.
+func (h *Handler) GetPostStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	token := r.PathValue("token")
+	if h.cfg.WriteQueue != nil {
+		if status, ok := h.cfg.WriteQueue.status(token); ok {
+			respondWithJSON(w, http.StatusOK, status)
+			return
+		}
+	}
+
+	status, err := h.db.GetAppealStatus(r.Context(), hashToken(token))
+	if err != nil {
+		log.Printf("Error getting appeal status: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve status")
+		return
+	}
+	if status == nil {
+		respondWithError(w, http.StatusNotFound, "Unknown or expired status token")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, status)
+}
+
+// RunWriteQueue processes queued posts one at a time until ctx is
+// cancelled, retrying with backoff while the database is unavailable. A
+// post that fails writeQueueMaxAttempts times is dead-lettered so the
+// queue can move on.
+func (h *Handler) RunWriteQueue(ctx context.Context) {
+	q := h.cfg.WriteQueue
+	if q == nil {
+		return
+	}
+
+	ticker := time.NewTicker(writeQueueStatusTTL / 4)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case p := <-q.posts:
+			h.processQueuedPost(ctx, p)
+		case <-ticker.C:
+			q.pruneStatuses()
+		case <-ctx.Done():
+			if n := len(q.posts); n > 0 {
+				log.Printf("Write queue: shutting down with %d posts unprocessed", n)
+			}
+			return
+		}
+	}
+}
+
+func (h *Handler) processQueuedPost(ctx context.Context, p *queuedPost) {
+	q := h.cfg.WriteQueue
+	delay := q.retryDelay
+	attempts := 0
+
+	for {
+		// Wait for a free connection rather than adding to the pile-up
+		if !h.db.Saturated() {
+			attempts++
+			req := p.req
+			attachments, err := h.checkPost(ctx, &req, p.ipHash)
+			switch err.(type) {
+			case nil:
+				post, err := h.publishPost(ctx, req, attachments, p.ipHash, p.editTokenHash)
+				if err == nil {
+					q.setStatus(p.token, PostStatus{Status: postStatusPublished, PostID: post.ID, PublicID: post.PublicID})
+					return
+				}
+				if _, ok := err.(*ValidationError); ok {
+					h.cfg.ValidationStats.Record(err)
+					q.setStatus(p.token, PostStatus{Status: postStatusRejected, Reason: err.Error(), Code: invalidPostCode})
+					return
+				}
+				log.Printf("Write queue: error creating post: %v", err)
+				if attempts >= writeQueueMaxAttempts {
+					h.deadLetterQueuedPost(ctx, p, err, attempts)
+					return
+				}
+			case *ValidationError:
+				h.cfg.ValidationStats.Record(err)
+				q.setStatus(p.token, PostStatus{Status: postStatusRejected, Reason: err.Error(), Code: invalidPostCode})
+				return
+			case *TermsChangedError:
+				h.cfg.ValidationStats.Record(err)
+				q.setStatus(p.token, PostStatus{Status: postStatusRejected, Reason: err.Error(), Code: termsChangedCode})
+				return
+			case *BannedError:
+				q.setStatus(p.token, PostStatus{Status: postStatusRejected, Reason: err.Error(), Code: bannedCode})
+				return
+			case *DeviceQuotaError:
+				q.setStatus(p.token, PostStatus{Status: postStatusRejected, Reason: err.Error(), Code: deviceQuotaCode})
+				return
+			case *PostingClosedError:
+				q.setStatus(p.token, PostStatus{Status: postStatusRejected, Reason: err.Error(), Code: postingClosedCode})
+				return
+			default:
+				log.Printf("Write queue: error checking post: %v", err)
+				if attempts >= writeQueueMaxAttempts {
+					h.deadLetterQueuedPost(ctx, p, err, attempts)
+					return
+				}
+			}
+		}
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return
+		}
+		delay = min(2*delay, writeQueueMaxRetry)
+	}
+}
+
+// deadLetterQueuedPost gives up on a post that kept failing, marking it
+// failed and saving it so an admin can redrive it.
+func (h *Handler) deadLetterQueuedPost(ctx context.Context, p *queuedPost, err error, attempts int) {
+	log.Printf("Write queue: giving up on post after %d attempts: %v", attempts, err)
+	h.cfg.WriteQueue.setStatus(p.token, PostStatus{Status: postStatusFailed, Reason: "We couldn't save your post. Please try again later."})
+	payload := queuedPostDeadLetter{Token: p.token, Post: p.req, IPHash: p.ipHash, EditTokenHash: p.editTokenHash}
+	if err := h.db.RecordDeadLetter(ctx, deadLetterPost, p.req.EventName, payload, err.Error(), attempts); err != nil {
+		log.Printf("Write queue: error recording dead letter: %v", err)
+	}
+}
+
+// Saturated reports whether every connection in the pool is in use, so a
+// new query would have to wait for one.
+func (db *DB) Saturated() bool {
+	stats := db.pool.Stats()
+	return stats.MaxOpenConnections > 0 && stats.InUse >= stats.MaxOpenConnections
+}